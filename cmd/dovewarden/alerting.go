@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dovewarden/dovewarden/internal/alerting"
+	"github.com/dovewarden/dovewarden/internal/config"
+	"github.com/dovewarden/dovewarden/internal/queue"
+)
+
+// newAlertEngine creates the alerting engine with the enabled rules and the
+// configured notifiers. It returns nil if no notifier is configured.
+func newAlertEngine(cfg *config.Config, q queue.Queue, workers *queue.WorkerPool, logger *slog.Logger) (*alerting.Engine, error) {
+	var notifiers []alerting.Notifier
+	if cfg.AlertWebhookURL != "" {
+		notifiers = append(notifiers, alerting.NewWebhookNotifier(cfg.AlertWebhookURL))
+	}
+	if cfg.AlertSMTPAddr != "" {
+		to := splitList(cfg.AlertSMTPTo)
+		if cfg.AlertSMTPFrom == "" || len(to) == 0 {
+			return nil, fmt.Errorf("alert mails require DOVEWARDEN_ALERT_SMTP_FROM and DOVEWARDEN_ALERT_SMTP_TO")
+		}
+		notifiers = append(notifiers, alerting.NewSMTPNotifier(cfg.AlertSMTPAddr, cfg.AlertSMTPFrom, to, cfg.AlertSMTPUsername, cfg.AlertSMTPPassword))
+	}
+	if cfg.AlertPagerDutyRoutingKey != "" {
+		notifiers = append(notifiers, alerting.NewPagerDutyNotifier(cfg.AlertPagerDutyRoutingKey))
+	}
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+
+	labels := map[string]string{"namespace": cfg.Namespace}
+	if hostname, err := os.Hostname(); err == nil {
+		labels["instance"] = hostname
+	}
+	engine := alerting.NewEngine(cfg.AlertInterval, labels, logger, notifiers...)
+	if cfg.AlertQueueAge > 0 {
+		engine.AddRule(alerting.QueueAgeRule(q, cfg.AlertQueueAge))
+	}
+	if cfg.AlertConsecutiveFailures > 0 {
+		engine.AddRule(alerting.ConsecutiveFailuresRule(workers.ConsecutiveFailures, int64(cfg.AlertConsecutiveFailures)))
+	}
+	if cfg.AlertFailedGrowth > 0 {
+		engine.AddRule(alerting.FailedGrowthRule(q, int64(cfg.AlertFailedGrowth), cfg.AlertFailedGrowthWindow))
+	}
+	return engine, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/dovewarden/dovewarden/internal/config"
+	"github.com/dovewarden/dovewarden/internal/errreport"
+)
+
+// newErrorReporter creates the error reporter for the configured Sentry DSN
+// and webhook. It returns nil if neither is configured.
+func newErrorReporter(cfg *config.Config, logger *slog.Logger) (*errreport.Reporter, error) {
+	var sinks []errreport.Sink
+	if cfg.ErrorReportSentryDSN != "" {
+		sink, err := errreport.NewSentrySink(cfg.ErrorReportSentryDSN, cfg.ErrorReportEnvironment, version)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.ErrorReportWebhookURL != "" {
+		sinks = append(sinks, errreport.NewWebhookSink(cfg.ErrorReportWebhookURL))
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	tags := map[string]string{"version": version}
+	if cfg.ErrorReportEnvironment != "" {
+		tags["environment"] = cfg.ErrorReportEnvironment
+	}
+	return errreport.NewReporter(errreport.Options{
+		SampleRate:  cfg.ErrorReportSampleRate,
+		DedupWindow: cfg.ErrorReportDedupWindow,
+		Tags:        tags,
+	}, logger, sinks...), nil
+}
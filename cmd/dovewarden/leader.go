@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dovewarden/dovewarden/internal/config"
+	"github.com/dovewarden/dovewarden/internal/leader"
+)
+
+// newLeaderElector creates the elector deciding which replica runs background
+// replication. The identity is the pod name if POD_NAME is set (e.g. from the
+// downward API), the hostname otherwise.
+func newLeaderElector(cfg *config.Config, logger *slog.Logger) (*leader.LeaseElector, error) {
+	if cfg.LeaderElection != "kubernetes" {
+		return nil, fmt.Errorf("unsupported leader election %q", cfg.LeaderElection)
+	}
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine identity: %w", err)
+		}
+		identity = hostname
+	}
+	return leader.NewInClusterLeaseElector(leader.Config{
+		Namespace:     cfg.LeaderElectionNamespace,
+		Name:          cfg.LeaderElectionLeaseName,
+		Identity:      identity,
+		LeaseDuration: cfg.LeaderElectionLeaseDuration,
+	}, logger)
+}
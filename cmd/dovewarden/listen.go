@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/dovewarden/dovewarden/internal/systemd"
+)
+
+// inheritedListeners maps the sockets passed via socket activation to the
+// "events" and "metrics" listeners, by FileDescriptorName= if set and by
+// order otherwise.
+func inheritedListeners() (map[string]net.Listener, error) {
+	passed, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+
+	byOrder := []string{"events", "metrics"}
+	listeners := make(map[string]net.Listener, len(passed))
+	for i, ln := range passed {
+		name := ln.Name
+		if name != "events" && name != "metrics" {
+			if i >= len(byOrder) {
+				_ = ln.Close()
+				continue
+			}
+			name = byOrder[i]
+		}
+		listeners[name] = ln.Listener
+	}
+	return listeners, nil
+}
+
+// listen returns the inherited listener with the given name, or binds addr.
+// With reusePort, SO_REUSEPORT lets a new process bind the address while the
+// old one is still draining, so no events are refused during a restart.
+func listen(inherited map[string]net.Listener, name, addr string, reusePort bool) (net.Listener, bool, error) {
+	if ln, ok := inherited[name]; ok {
+		return ln, true, nil
+	}
+	lc := net.ListenConfig{}
+	if reusePort {
+		lc.Control = setReusePort
+	}
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	return ln, false, err
+}
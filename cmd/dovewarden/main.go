@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -15,12 +16,19 @@ import (
 	"time"
 
 	"github.com/dovewarden/dovewarden/internal/config"
+	"github.com/dovewarden/dovewarden/internal/credentials"
 	"github.com/dovewarden/dovewarden/internal/doveadm"
+	"github.com/dovewarden/dovewarden/internal/logging"
 	"github.com/dovewarden/dovewarden/internal/metrics"
 	"github.com/dovewarden/dovewarden/internal/queue"
 	"github.com/dovewarden/dovewarden/internal/server"
+	"github.com/dovewarden/dovewarden/internal/systemd"
+	"github.com/dovewarden/dovewarden/internal/vault"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var (
@@ -28,6 +36,10 @@ var (
 	showVersion bool
 )
 
+// queueDepthSampleInterval is how often the queue depth is sampled for the
+// dashboard.
+const queueDepthSampleInterval = 10 * time.Second
+
 func init() {
 	flag.BoolVar(&showVersion, "version", false, "Show version and exit")
 	flag.Parse()
@@ -42,29 +54,110 @@ func main() {
 	// Load configuration early so we can configure logging
 	cfg := config.Load()
 
+	// Fetch secrets from Vault before they are used, e.g. the privacy salt by
+	// the log handler
+	var vaultClient *vault.Client
+	if cfg.VaultAddr != "" {
+		client, err := loadVaultSecrets(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load secrets from vault: %v\n", err)
+			os.Exit(1)
+		}
+		vaultClient = client
+	}
+
 	// Initialize structured logging
 	// LOG_FORMAT environment variable controls output: "json" or "text" (default)
 	logFormat := strings.ToLower(os.Getenv("LOG_FORMAT"))
 	var logger *slog.Logger
 
-	lvl := parseLogLevel(cfg.LogLevel)
+	// Every component logs through its own logger with the attribute
+	// component=<name>; levels filters records per component, so the handler
+	// itself accepts all levels
+	componentLevels, err := logging.ParseLevels(cfg.LogLevels)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	levels := logging.NewLevels(parseLogLevel(cfg.LogLevel), componentLevels)
 	opts := &slog.HandlerOptions{
 		AddSource: true,
-		Level:     lvl,
+		Level:     slog.LevelDebug,
 	}
 
+	var logHandler slog.Handler
 	if logFormat == "json" {
-		handler := slog.NewJSONHandler(os.Stdout, opts)
-		logger = slog.New(handler)
+		logHandler = slog.NewJSONHandler(os.Stdout, opts)
 	} else {
-		handler := slog.NewTextHandler(os.Stdout, opts)
-		logger = slog.New(handler)
+		logHandler = slog.NewTextHandler(os.Stdout, opts)
+	}
+	if cfg.PrivacyMode {
+		if cfg.PrivacySalt == "" {
+			fmt.Fprintln(os.Stderr, "privacy mode requires DOVEWARDEN_PRIVACY_SALT to be set")
+			os.Exit(1)
+		}
+		logHandler = logging.NewPrivacyHandler(logHandler, cfg.PrivacySalt)
+	}
+	if cfg.LogSamplingFirst > 0 && cfg.LogSamplingInterval > 0 {
+		samplingHandler := logging.NewSamplingHandler(logHandler, cfg.LogSamplingFirst, cfg.LogSamplingInterval)
+		go samplingHandler.Run(context.Background())
+		logHandler = samplingHandler
 	}
+	logger = levels.Logger(logHandler, "")
+	queueLogger := levels.Logger(logHandler, "queue")
+	doveadmLogger := levels.Logger(logHandler, "doveadm")
+	serverLogger := levels.Logger(logHandler, "server")
+	credentialsLogger := levels.Logger(logHandler, "credentials")
 
 	slog.SetDefault(logger)
 
+	// Read the doveadm password from a file, if configured, so that a rotated
+	// password is picked up without a restart
+	var doveadmPasswordFile *credentials.File
+	if cfg.DoveadmPasswordFile != "" {
+		f, err := credentials.NewFile(cfg.DoveadmPasswordFile)
+		if err != nil {
+			slog.Error("failed to read doveadm password file", "path", cfg.DoveadmPasswordFile, "error", err)
+			os.Exit(1)
+		}
+		doveadmPasswordFile = f
+		cfg.DoveadmPassword = f.Value()
+	}
+
+	// Dispatch subcommands (e.g. "dovewarden migrate-namespace --to new")
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate-namespace":
+			os.Exit(runMigrateNamespace(cfg, logger, args[1:]))
+		case "migrate":
+			os.Exit(runMigrate(cfg, logger, args[1:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown subcommand %q\n", args[0])
+			os.Exit(2)
+		}
+	}
+
+	// Report unexpected failures to an error tracker, if configured
+	reporter, err := newErrorReporter(cfg, levels.Logger(logHandler, "errreport"))
+	if err != nil {
+		slog.Error("failed to set up error reporting", "error", err)
+		os.Exit(1)
+	}
+	if reporter != nil {
+		go reporter.Run(context.Background())
+		defer func() {
+			if v := recover(); v != nil {
+				reporter.ReportPanic(v, nil)
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				reporter.Flush(ctx)
+				cancel()
+				panic(v)
+			}
+		}()
+	}
+
 	// Log version information
-	slog.Info("dovewarden starting", "version", version, "log_level", lvl.String())
+	slog.Info("dovewarden starting", "version", version, "log_level", levels.Default().String(), "log_levels", cfg.LogLevels)
 
 	slog.Info("Starting dovewarden",
 		"http_addr", cfg.HTTPAddr,
@@ -77,23 +170,95 @@ func main() {
 
 	// Initialize metrics with default prometheus registry
 	m := metrics.New(prometheus.DefaultRegisterer)
+	m.SetMaxLabelValues(cfg.MetricsMaxLabelValues)
 
 	// Initialize queue
 	var q queue.Queue
-	var err error
 
-	if cfg.RedisMode == "inmemory" {
-		slog.Info("Initializing in-memory Redis queue")
-		q, err = queue.NewInMemoryQueue(cfg.Namespace, cfg.RedisAddr, logger)
+	if cfg.RedisMode == "inmemory" || cfg.RedisMode == "external" {
+		var inMemoryQueue *queue.InMemoryQueue
+		var err error
+		if cfg.RedisMode == "external" {
+			slog.Info("Connecting to external Redis queue", "redis_addr", cfg.RedisAddr)
+			inMemoryQueue, err = queue.NewExternalQueue(cfg.Namespace, cfg.RedisAddr, cfg.RedisPassword, queueLogger)
+		} else {
+			slog.Info("Initializing in-memory Redis queue")
+			inMemoryQueue, err = queue.NewInMemoryQueue(cfg.Namespace, cfg.RedisAddr, queueLogger)
+		}
 		if err != nil {
-			slog.Error("failed to create in-memory queue", "error", err)
+			slog.Error("failed to create Redis queue", "error", err)
+			os.Exit(1)
+		}
+		if err := inMemoryQueue.EnableMetrics(m, prometheus.DefaultRegisterer); err != nil {
+			slog.Error("failed to enable Redis metrics", "error", err)
 			os.Exit(1)
 		}
+		if cfg.RedisMode == "external" {
+			// Upgrade data stored by older versions before anything reads it;
+			// the embedded server always starts empty
+			migration, err := inMemoryQueue.MigrateSchema(context.Background())
+			if err != nil {
+				slog.Error("failed to migrate the stored data", "error", err)
+				os.Exit(1)
+			}
+			if migration.From != migration.To {
+				slog.Info("Migrated the stored data", "from_version", migration.From, "to_version", migration.To,
+					"upgraded_keys", migration.Upgraded, "quarantined_keys", len(migration.Quarantined))
+			}
+		}
+		if cfg.EnqueueBatchSize > 1 {
+			slog.Info("Enabling enqueue batching", "batch_size", cfg.EnqueueBatchSize, "batch_interval", cfg.EnqueueBatchInterval)
+			inMemoryQueue.EnableEnqueueBatching(cfg.EnqueueBatchSize, cfg.EnqueueBatchInterval)
+		}
+		if cfg.RedisWakeups {
+			if cfg.RedisMode == "inmemory" {
+				slog.Warn("Redis wakeups in inmemory mode only serve external publishers, as no other replica shares the embedded server")
+			}
+			slog.Info("Enabling Redis wakeups")
+			if err := inMemoryQueue.EnableWakeups(context.Background()); err != nil {
+				slog.Error("failed to enable Redis wakeups", "error", err)
+				os.Exit(1)
+			}
+		}
+		if cfg.QueueSpillDir != "" {
+			slog.Info("Enabling queue spill to disk", "dir", cfg.QueueSpillDir, "max_in_memory", cfg.QueueMaxInMemory)
+			if err := inMemoryQueue.EnableSpill(cfg.QueueSpillDir, cfg.QueueMaxInMemory); err != nil {
+				slog.Error("failed to enable queue spill", "error", err)
+				os.Exit(1)
+			}
+		}
+		q = inMemoryQueue
+	} else if cfg.RedisMode == "native" {
+		slog.Info("Initializing native in-memory queue")
+		if cfg.QueueSpillDir != "" {
+			slog.Error("queue spill is only supported in inmemory and external mode")
+			os.Exit(1)
+		}
+		q = queue.NewNativeQueue(queueLogger)
 	} else {
-		slog.Error("Redis mode not yet implemented", "mode", cfg.RedisMode)
+		slog.Error("unknown Redis mode", "mode", cfg.RedisMode)
 		os.Exit(1)
 	}
 
+	// Clamp priority factors, so that a bug or a careless admin call cannot
+	// produce absurd scores
+	priorityBounds, err := queue.NewPriorityBounds(cfg.PriorityMin, cfg.PriorityMax, m)
+	if err != nil {
+		slog.Error("invalid priority bounds", "error", err)
+		os.Exit(1)
+	}
+	if limiter, ok := q.(queue.PriorityLimiter); ok {
+		limiter.SetPriorityBounds(priorityBounds)
+	}
+
+	// Stream the queue changes to standbys
+	var mirroredQueue *queue.MirroredQueue
+	if cfg.Mirror {
+		slog.Info("Streaming queue changes to standbys", "path", queue.MirrorStreamPath)
+		mirroredQueue = queue.NewMirroredQueue(q, m, queueLogger)
+		q = mirroredQueue
+	}
+
 	defer func() {
 		if err := q.Close(); err != nil {
 			slog.Error("error closing queue", "error", err)
@@ -102,35 +267,324 @@ func main() {
 
 	// Initialize worker pool for dequeuing
 	slog.Info("Initializing worker pool", "num_workers", cfg.NumWorkers)
-	workerPool := queue.NewWorkerPool(q, cfg.NumWorkers, logger)
+	workerPool := queue.NewWorkerPool(q, cfg.NumWorkers, queueLogger)
+	workerPool.SetMaxFetchBackoff(cfg.FetchMaxBackoff)
+	workerPool.SetJobRecoveryAge(cfg.JobRecoveryAge)
+	if cfg.RetryBudgetRatio > 0 {
+		workerPool.SetRetryBudget(queue.NewRetryBudget(cfg.RetryBudgetRatio, cfg.RetryBudgetMinRetries, cfg.RetryBudgetWindow, cfg.RetryBudgetDelay, m))
+	}
+	retryPolicies, err := queue.ParseRetryPolicies(cfg.RetryPolicies)
+	if err != nil {
+		slog.Error("Invalid retry policies", "error", err)
+		os.Exit(1)
+	}
+	workerPool.SetRetryPolicies(retryPolicies)
 
 	// Set up Doveadm event handler if credentials are provided
-	if cfg.DoveadmPassword == "" {
+	if cfg.DoveadmPassword == "" && !cfg.DryRun {
 		slog.Error("Doveadm password not provided; exiting")
 		os.Exit(1)
 	}
 	slog.Info("Setting up Doveadm sync handler")
-	handler := queue.NewDoveadmEventHandler(cfg.DoveadmURL, cfg.DoveadmPassword, cfg.DoveadmDest, logger, q)
+	handler := queue.NewDoveadmEventHandler(cfg.DoveadmURL, cfg.DoveadmPassword, cfg.DoveadmDest, doveadmLogger, q, m)
+	doveadmHTTP := newDoveadmHTTPClient(cfg)
+	handler.SetHTTPClient(doveadmHTTP)
+	handler.SetStateResetThreshold(cfg.StateResetAfterFailures)
+	handler.SetHistorySize(cfg.HistorySize)
+	handler.SetMailboxConcurrency(cfg.MailboxSyncConcurrency)
+	handler.SetPurgeDeletedUsers(cfg.PurgeDeletedUsers)
+	syncParams, err := doveadm.ParseSyncParams(cfg.DsyncParams)
+	if err != nil {
+		slog.Error("Invalid dsync parameters", "error", err)
+		os.Exit(1)
+	}
+	handler.SetSyncParams(syncParams)
+	errorRules, err := doveadm.ParseErrorRules(cfg.ErrorRules)
+	if err != nil {
+		slog.Error("Invalid error rules", "error", err)
+		os.Exit(1)
+	}
+	classifier, err := doveadm.NewClassifier(errorRules)
+	if err != nil {
+		slog.Error("Invalid error rules", "error", err)
+		os.Exit(1)
+	}
+	handler.SetErrorClassifier(classifier)
+	handler.SetCoolDown(cfg.OverloadCoolDown, cfg.OverloadMaxCoolDown, cfg.TempFailCoolDownThreshold)
+	routes, err := doveadm.ParseRoutes(cfg.DoveadmRoutes)
+	if err != nil {
+		slog.Error("Invalid doveadm routes", "error", err)
+		os.Exit(1)
+	}
+	if len(routes) > 0 {
+		router, err := handler.NewRouter(routes)
+		if err != nil {
+			slog.Error("Invalid doveadm routes", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Routing users to other doveadm endpoints", "routes", len(routes))
+		handler.SetRoutes(router)
+	}
+	if cfg.BackendURLTemplate != "" {
+		slog.Info("Resolving the backend of every user", "url_template", cfg.BackendURLTemplate, "cache_ttl", cfg.BackendCacheTTL)
+		handler.SetBackendResolution(cfg.BackendURLTemplate, cfg.BackendCacheTTL)
+	}
+	destinationLimits, err := queue.ParseDestinationLimits(cfg.DestinationMaxSyncs)
+	if err != nil {
+		slog.Error("Invalid destination sync limits", "error", err)
+		os.Exit(1)
+	}
+	if len(destinationLimits) > 0 {
+		slog.Info("Limiting concurrent syncs per destination", "limits", destinationLimits)
+		handler.SetDestinationLimits(destinationLimits)
+	}
+	if cfg.SessionCheck {
+		slog.Info("Deferring syncs of users with active sessions", "min_connections", cfg.SessionCheckMinConnections, "services", cfg.SessionCheckServices, "delay", cfg.SessionCheckDelay, "max_delay", cfg.SessionCheckMaxDelay)
+		handler.SetSessionCheck(queue.SessionCheck{
+			MinConnections: cfg.SessionCheckMinConnections,
+			Services:       splitList(cfg.SessionCheckServices),
+			Delay:          cfg.SessionCheckDelay,
+			MaxDelay:       cfg.SessionCheckMaxDelay,
+		})
+	}
+	if cfg.LargeAccountMB > 0 || cfg.LargeAccountMessages > 0 {
+		slog.Info("Syncing large accounts in a lane of their own", "min_mb", cfg.LargeAccountMB, "min_messages", cfg.LargeAccountMessages, "concurrency", cfg.LargeAccountConcurrency)
+		handler.SetLargeAccountLane(queue.LargeAccountLane{
+			MinBytes:    cfg.LargeAccountMB << 20,
+			MinMessages: cfg.LargeAccountMessages,
+			Concurrency: cfg.LargeAccountConcurrency,
+			SizeTTL:     cfg.LargeAccountSizeTTL,
+		})
+	}
+	if cfg.RedundantSyncWindow > 0 {
+		slog.Info("Skipping syncs of users without events since their last sync", "window", cfg.RedundantSyncWindow)
+		handler.SetRedundantSyncWindow(cfg.RedundantSyncWindow)
+	}
+	if filter := queue.NewMailboxFilter(splitList(cfg.SyncIncludeMailboxes), splitList(cfg.SyncExcludeMailboxes)); filter != nil {
+		slog.Info("Restricting synced mailboxes", "include", cfg.SyncIncludeMailboxes, "exclude", cfg.SyncExcludeMailboxes, "background_sync_all", cfg.BackgroundSyncAllMailboxes)
+		handler.SetMailboxFilter(filter, cfg.BackgroundSyncAllMailboxes)
+	}
+	if cfg.DryRun {
+		slog.Warn("Dry-run mode enabled, syncs are logged but not executed")
+		handler.SetDryRun(true)
+	}
+	if cfg.PostSyncCommand != "" {
+		slog.Info("Running a command after every sync", "command", cfg.PostSyncCommand)
+		handler.AddPostSyncHook(queue.CommandHook(cfg.PostSyncCommand, cfg.PostSyncCommandTimeout, doveadmLogger))
+	}
+	var slowSyncs *queue.SlowSyncTracker
+	if cfg.SlowSyncThreshold > 0 {
+		slowSyncs = queue.NewSlowSyncTracker(cfg.SlowSyncThreshold, cfg.SlowSyncBufferSize, doveadmLogger, m)
+		handler.AddPostSyncHook(slowSyncs.Hook())
+	}
+	var recentSyncs *queue.RecentSyncTracker
+	if cfg.RecentSyncs > 0 {
+		recentSyncs = queue.NewRecentSyncTracker(cfg.RecentSyncs)
+		handler.AddPostSyncHook(recentSyncs.Hook())
+	}
 	workerPool.SetHandler(handler)
+	workerPool.Use(queue.MetricsMiddleware(m))
+	if cfg.DoveadmRateLimit > 0 && cfg.DoveadmRateLimitRedisURL != "" {
+		opts, err := redis.ParseURL(cfg.DoveadmRateLimitRedisURL)
+		if err != nil {
+			slog.Error("Invalid doveadm rate limit Redis URL", "error", err)
+			os.Exit(1)
+		}
+		rateLimitClient := redis.NewClient(opts)
+		defer func() { _ = rateLimitClient.Close() }()
+		fallback := cfg.DoveadmRateLimitFallback
+		if fallback == 0 {
+			fallback = cfg.DoveadmRateLimit
+		}
+		bucket := queue.NewDistributedTokenBucket(rateLimitClient, cfg.Namespace+":doveadm_rate_limit",
+			cfg.DoveadmRateLimit, cfg.DoveadmRateLimitBurst, fallback, m, doveadmLogger)
+		workerPool.Use(queue.DistributedRateLimitMiddleware(bucket))
+		slog.Info("Limiting the doveadm sync rate across replicas", "rate", cfg.DoveadmRateLimit, "burst", cfg.DoveadmRateLimitBurst)
+	} else if cfg.DoveadmRateLimit > 0 {
+		workerPool.Use(queue.RateLimitMiddleware(cfg.DoveadmRateLimit, cfg.DoveadmRateLimitBurst))
+		slog.Info("Limiting the doveadm sync rate of this replica", "rate", cfg.DoveadmRateLimit, "burst", cfg.DoveadmRateLimitBurst)
+	}
+	workerPool.SetErrorReporter(reporter, cfg.ErrorReportFailureThreshold)
+
+	// A standby mirrors the queue of its primary and syncs nothing until it takes over
+	var standby *queue.Standby
+	if cfg.StandbyOf != "" {
+		standby = queue.NewStandby(cfg.StandbyOf, q, workerPool, m, queueLogger, cfg.StandbyTakeoverAfter)
+		if cfg.StandbyTokenFile != "" {
+			tokenFile, err := credentials.NewFile(cfg.StandbyTokenFile)
+			if err != nil {
+				slog.Error("failed to read standby token file", "path", cfg.StandbyTokenFile, "error", err)
+				os.Exit(1)
+			}
+			standby.SetToken(tokenFile.Value())
+			tokenFile.OnChange(standby.SetToken)
+			go tokenFile.Watch(context.Background(), cfg.CredentialsCheckInterval, credentialsLogger)
+		}
+		standby.Start(context.Background())
+	}
 
 	workerPool.Start(context.Background())
 
+	doveadmClient := doveadm.NewClient(cfg.DoveadmURL, cfg.DoveadmPassword)
+	doveadmClient.SetHTTPClient(doveadmHTTP)
+	doveadmClient.SetSyncParams(syncParams)
+
+	if doveadmPasswordFile != nil {
+		doveadmPasswordFile.OnChange(handler.SetPassword)
+		doveadmPasswordFile.OnChange(doveadmClient.SetPassword)
+		go doveadmPasswordFile.Watch(context.Background(), cfg.CredentialsCheckInterval, credentialsLogger)
+	}
+
+	if vaultClient != nil {
+		go vaultClient.KeepAlive(context.Background(), credentialsLogger)
+		go vaultClient.WatchSecret(context.Background(), cfg.VaultSecretPath, cfg.CredentialsCheckInterval, credentialsLogger, func(data map[string]string) {
+			if password := data[vaultKeyDoveadmPassword]; password != "" {
+				handler.SetPassword(password)
+				doveadmClient.SetPassword(password)
+			}
+		})
+	}
+
+	// Optionally gate readiness on doveadm API reachability
+	var doveadmProbe *doveadm.Probe
+	if cfg.ReadinessDoveadmCheck {
+		slog.Info("Enabling doveadm readiness probe",
+			"interval", cfg.ReadinessDoveadmInterval,
+			"failure_threshold", cfg.ReadinessDoveadmFailures,
+		)
+		doveadmProbe = doveadm.NewProbe(doveadmClient, doveadmLogger, cfg.ReadinessDoveadmInterval, cfg.ReadinessDoveadmFailures)
+		doveadmProbe.Start(context.Background())
+	}
+
+	// Optionally hold syncs while the destination is down
+	var destinationMonitor *queue.DestinationMonitor
+	if cfg.DoveadmFallbackDest != "" && !cfg.DestinationHealthCheck {
+		slog.Warn("Fallback destination ignored, failover requires the destination health check")
+	}
+	if cfg.DestinationHealthCheck {
+		probe := queue.PingProbe(doveadmClient)
+		if cfg.DestinationHealthUser != "" {
+			probe = queue.CanarySyncProbe(doveadmClient, cfg.DestinationHealthUser, cfg.DoveadmDest)
+		}
+		destinationMonitor = queue.NewDestinationMonitor(cfg.DoveadmDest, probe, workerPool, q, m, doveadmLogger, cfg.DestinationHealthInterval, cfg.DestinationHealthFailures)
+		if cfg.DoveadmFallbackDest != "" {
+			slog.Info("Enabling destination failover", "fallback", cfg.DoveadmFallbackDest, "after", cfg.FailoverAfter)
+			handler.SetFallbackDestination(cfg.DoveadmFallbackDest)
+			destinationMonitor.SetFailover(handler, cfg.FailoverAfter)
+		}
+		destinationMonitor.Start(context.Background())
+	}
+	// Reconcile the primary destination for users redirected before a restart
+	handler.ReconcileRedirected(context.Background())
+
+	// Record the time users wait in the queue by priority, to reveal starvation
+	if observer, ok := q.(queue.DequeueObserver); ok {
+		observer.NotifyDequeue(queue.QueueWaitRecorder(m, cfg.QueueWaitThreshold))
+	}
+
+	// Initialize queue aging to prevent starvation of low-priority users
+	var agingService *queue.AgingService
+	if cfg.QueueMaxDelay > 0 {
+		agingService = queue.NewAgingService(q, queueLogger, cfg.QueueMaxDelay)
+		agingService.Start(context.Background())
+	} else {
+		slog.Info("Queue aging disabled")
+	}
+
+	// Periodically give dead-lettered users another chance after long outages
+	var deadLetterRedriver *queue.DeadLetterRedriver
+	if cfg.DeadLetterRedriveInterval > 0 {
+		deadLetterRedriver = queue.NewDeadLetterRedriver(q, m, queueLogger, cfg.DeadLetterRedriveInterval)
+		deadLetterRedriver.Start(context.Background())
+	}
+
+	// Estimate the time to drain the queue from the recent sync throughput
+	backlogEstimator := queue.NewBacklogEstimator(q, workerPool, m, queueLogger)
+	backlogEstimator.Start(context.Background())
+
+	// Sample the queue depth for the dashboard
+	var depthHistory *queue.QueueDepthHistory
+	if cfg.DashboardHistory > 0 {
+		depthHistory = queue.NewQueueDepthHistory(q, queueLogger, queueDepthSampleInterval, cfg.DashboardHistory)
+		depthHistory.Start(context.Background())
+	}
+
+	// Notify on-call about queue backlogs and failing syncs
+	alertEngine, err := newAlertEngine(cfg, q, workerPool, levels.Logger(logHandler, "alerting"))
+	if err != nil {
+		slog.Error("Invalid alerting configuration", "error", err)
+		os.Exit(1)
+	}
+	if alertEngine != nil {
+		go alertEngine.Run(context.Background())
+	} else {
+		slog.Info("Alerting disabled, no notifier configured")
+	}
+
 	// Initialize background replication service if enabled
 	var backgroundReplicationService *queue.BackgroundReplicationService
+	releaseLeadership := func() {}
 	if cfg.BackgroundReplicationEnabled {
 		slog.Info("Initializing background replication service",
 			"enabled", cfg.BackgroundReplicationEnabled,
 			"interval", cfg.BackgroundReplicationInterval,
 			"threshold", cfg.BackgroundReplicationThreshold,
 		)
-		doveadmClient := doveadm.NewClient(cfg.DoveadmURL, cfg.DoveadmPassword)
 		backgroundReplicationService = queue.NewBackgroundReplicationService(
 			doveadmClient,
 			q,
-			logger,
+			m,
+			queueLogger,
 			cfg.BackgroundReplicationInterval,
 			cfg.BackgroundReplicationThreshold,
 		)
+		backgroundReplicationService.SetSpread(cfg.BackgroundReplicationSpread)
+		backgroundReplicationService.SetEnqueueLimits(queue.EnqueueLimits{
+			MaxPerSweep:  cfg.BackgroundMaxEnqueuesPerSweep,
+			MaxPerMinute: cfg.BackgroundMaxEnqueuesPerMinute,
+			Priority:     cfg.BackgroundPriority,
+		})
+		if cfg.BackgroundActiveWindow > 0 {
+			slog.Info("Adapting the background replication threshold to user activity",
+				"active_window", cfg.BackgroundActiveWindow,
+				"active_threshold", cfg.BackgroundActiveThreshold,
+				"dormant_threshold", cfg.BackgroundDormantThreshold,
+			)
+			backgroundReplicationService.SetActivityThresholds(queue.ActivityThresholds{
+				ActiveWindow:     cfg.BackgroundActiveWindow,
+				ActiveThreshold:  cfg.BackgroundActiveThreshold,
+				DormantThreshold: cfg.BackgroundDormantThreshold,
+			})
+		}
+		if cfg.LeaderElection != "" {
+			elector, err := newLeaderElector(cfg, levels.Logger(logHandler, "leader"))
+			if err != nil {
+				slog.Error("failed to set up leader election", "error", err)
+				os.Exit(1)
+			}
+			backgroundReplicationService.SetLeaderCheck(elector.IsLeader)
+			service := backgroundReplicationService
+			elector.OnChange(func(leader bool) {
+				if leader {
+					m.Leader.Set(1)
+					service.RunNow()
+				} else {
+					m.Leader.Set(0)
+				}
+			})
+			electionCtx, stopElection := context.WithCancel(context.Background())
+			electionDone := make(chan struct{})
+			go func() {
+				defer close(electionDone)
+				elector.Run(electionCtx)
+			}()
+			releaseLeadership = func() {
+				stopElection()
+				<-electionDone
+			}
+		} else {
+			m.Leader.Set(1)
+		}
 		backgroundReplicationService.Start(context.Background())
 	} else {
 		slog.Info("Background replication disabled")
@@ -138,47 +592,188 @@ func main() {
 
 	// Create HTTP server for events
 	eventSrv := server.New(cfg.HTTPAddr, q, m)
+	eventSrv.SetLogger(serverLogger)
+	eventSrv.SetErrorReporter(reporter)
+
+	// Apply reloadable settings (filters, priorities, rate limits, log level)
+	configReloader := &reloader{levels: levels, eventSrv: eventSrv, workers: workerPool}
+	if err := configReloader.apply(cfg); err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	configReloader.reloadOnSIGHUP()
 	eventsHTTP := &http.Server{Addr: cfg.HTTPAddr, Handler: eventSrv.Handler()}
+	if cfg.EventsTLSCert != "" {
+		tlsConfig, err := server.NewTLSConfig(server.TLSOptions{
+			CertFile:       cfg.EventsTLSCert,
+			KeyFile:        cfg.EventsTLSKey,
+			ClientCAFile:   cfg.EventsTLSClientCA,
+			AllowedClients: splitList(cfg.EventsTLSAllowedClients),
+		})
+		if err != nil {
+			slog.Error("Invalid events TLS configuration", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Enabling TLS on the events listener", "client_auth", cfg.EventsTLSClientCA != "")
+		eventsHTTP.TLSConfig = tlsConfig
+	} else if cfg.EventsTLSClientCA != "" {
+		slog.Error("Client certificate verification requires DOVEWARDEN_EVENTS_TLS_CERT")
+		os.Exit(1)
+	}
 
 	// Create HTTP server for metrics with health and readiness probes
 	var readyFlag uint32 // 0 = not ready, 1 = ready
 	metricsMux := http.NewServeMux()
 	metricsMux.Handle("/metrics", promhttp.Handler())
+	admin := server.NewAdmin(q, m, cfg.DoveadmDest)
+	admin.SetLogger(serverLogger)
+	admin.SetVersion(version)
+	admin.SetReloadFunc(configReloader.reload)
+	admin.SetBacklogEstimator(backlogEstimator)
+	if slowSyncs != nil {
+		admin.SetSlowSyncTracker(slowSyncs)
+	}
+	if recentSyncs != nil {
+		admin.SetRecentSyncTracker(recentSyncs)
+	}
+	if depthHistory != nil {
+		admin.SetQueueDepthHistory(depthHistory)
+	}
+	if destinationMonitor != nil {
+		admin.SetDestinationMonitor(destinationMonitor)
+	}
+	if mirroredQueue != nil {
+		admin.SetMirroredQueue(mirroredQueue)
+	}
+	if standby != nil {
+		admin.SetStandby(standby)
+	}
+	if cfg.AdminTokensFile != "" {
+		tokensFile, err := credentials.NewFile(cfg.AdminTokensFile)
+		if err != nil {
+			slog.Error("failed to read admin tokens file", "path", cfg.AdminTokensFile, "error", err)
+			os.Exit(1)
+		}
+		auth, err := server.NewTokenAuth(tokensFile.Value())
+		if err != nil {
+			slog.Error("invalid admin tokens file", "path", cfg.AdminTokensFile, "error", err)
+			os.Exit(1)
+		}
+		tokensFile.OnChange(func(data string) {
+			if err := auth.Update(data); err != nil {
+				slog.Error("invalid admin tokens file, keeping the previous tokens", "path", cfg.AdminTokensFile, "error", err)
+			}
+		})
+		go tokensFile.Watch(context.Background(), cfg.CredentialsCheckInterval, credentialsLogger)
+		admin.SetAuth(auth)
+	} else if cfg.AdminInsecure {
+		admin.SetInsecure(true)
+		slog.Warn("No admin tokens file configured and --admin-insecure set; the whole admin API is open to anyone reaching the metrics listener")
+	} else {
+		slog.Warn("No admin tokens file configured; only the read-only admin routes are open")
+	}
+	metricsMux.Handle("/admin/", admin.Handler())
 	metricsMux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		// Liveness check: process is up
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
+	health := server.NewHealth(q, doveadmClient, workerPool, backgroundReplicationService)
+	metricsMux.HandleFunc("GET /healthz/details", health.HandleDetails)
+	// ready is the readiness logic shared by /readyz and the gRPC health service
+	ready := func(ctx context.Context) error {
+		if atomic.LoadUint32(&readyFlag) == 0 {
+			return errors.New("not ready")
+		}
+		if err := q.HealthCheck(ctx); err != nil {
+			return errors.New("queue not healthy")
+		}
+		if doveadmProbe != nil && !doveadmProbe.Reachable() {
+			return errors.New("doveadm not reachable")
+		}
+		return nil
+	}
 	metricsMux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
 		defer cancel()
 
-		if atomic.LoadUint32(&readyFlag) == 0 {
-			http.Error(w, "not ready", http.StatusServiceUnavailable)
-			return
-		}
-		if err := q.HealthCheck(ctx); err != nil {
-			http.Error(w, "queue not healthy", http.StatusServiceUnavailable)
+		if err := ready(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ready"))
 	})
 	metricsHTTP := &http.Server{Addr: cfg.MetricsAddr, Handler: metricsMux}
+	if mirroredQueue != nil {
+		// mirror streams never end on their own
+		metricsHTTP.RegisterOnShutdown(mirroredQueue.Disconnect)
+	}
+	if cfg.AccessLog {
+		excluded := splitList(cfg.AccessLogExcludePaths)
+		eventsHTTP.Handler = server.AccessLog(eventsHTTP.Handler, server.AccessLogOptions{
+			Listener: "events", SampleRate: cfg.AccessLogSampleRate, ExcludePaths: excluded,
+			Logger: serverLogger,
+		})
+		metricsHTTP.Handler = server.AccessLog(metricsHTTP.Handler, server.AccessLogOptions{
+			Listener: "metrics", SampleRate: cfg.AccessLogSampleRate, ExcludePaths: excluded,
+			Logger: serverLogger,
+		})
+	}
 
-	// Bind event listener before serving; mark ready only after bind success
-	ln, err := net.Listen("tcp", cfg.HTTPAddr)
+	// Bind listeners before serving; mark ready only after bind success. Sockets
+	// passed via socket activation survive restarts, so they are preferred.
+	inherited, err := inheritedListeners()
+	if err != nil {
+		slog.Error("failed to inherit listeners", "error", err)
+		os.Exit(1)
+	}
+	ln, fromManager, err := listen(inherited, "events", cfg.HTTPAddr, cfg.ReusePort)
 	if err != nil {
 		slog.Error("failed to bind events listener", "addr", cfg.HTTPAddr, "error", err)
 		os.Exit(1)
 	}
+	if fromManager {
+		slog.Info("Using events listener passed by the service manager", "addr", ln.Addr().String())
+	}
+	metricsLn, fromManager, err := listen(inherited, "metrics", cfg.MetricsAddr, cfg.ReusePort)
+	if err != nil {
+		slog.Error("failed to bind metrics listener", "addr", cfg.MetricsAddr, "error", err)
+		os.Exit(1)
+	}
+	if fromManager {
+		slog.Info("Using metrics listener passed by the service manager", "addr", metricsLn.Addr().String())
+	}
+
+	// Optionally serve the grpc.health.v1 protocol for service meshes
+	var grpcServer *grpc.Server
+	var grpcLn net.Listener
+	if cfg.GRPCHealthAddr != "" {
+		grpcLn, fromManager, err = listen(inherited, "grpc-health", cfg.GRPCHealthAddr, cfg.ReusePort)
+		if err != nil {
+			slog.Error("failed to bind gRPC health listener", "addr", cfg.GRPCHealthAddr, "error", err)
+			os.Exit(1)
+		}
+		if fromManager {
+			slog.Info("Using gRPC health listener passed by the service manager", "addr", grpcLn.Addr().String())
+		}
+		grpcServer = grpc.NewServer()
+		healthpb.RegisterHealthServer(grpcServer, server.NewGRPCHealth(ready))
+	}
 
 	// Start servers in goroutines
-	done := make(chan struct{}, 2)
+	done := make(chan struct{}, 3)
 	go func() {
 		slog.Info("Events HTTP server listening", "addr", cfg.HTTPAddr)
 		atomic.StoreUint32(&readyFlag, 1)
-		if err := eventsHTTP.Serve(ln); err != nil && err != http.ErrServerClosed {
+		var err error
+		if eventsHTTP.TLSConfig != nil {
+			// certificates are taken from TLSConfig
+			err = eventsHTTP.ServeTLS(ln, "", "")
+		} else {
+			err = eventsHTTP.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			slog.Error("events server error", "error", err)
 		}
 		done <- struct{}{}
@@ -186,42 +781,111 @@ func main() {
 
 	go func() {
 		slog.Info("Metrics HTTP server listening", "addr", cfg.MetricsAddr)
-		if err := metricsHTTP.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := metricsHTTP.Serve(metricsLn); err != nil && err != http.ErrServerClosed {
 			slog.Error("metrics server error", "error", err)
 		}
 		done <- struct{}{}
 	}()
 
+	if grpcServer != nil {
+		go func() {
+			slog.Info("gRPC health server listening", "addr", cfg.GRPCHealthAddr)
+			if err := grpcServer.Serve(grpcLn); err != nil {
+				slog.Error("gRPC health server error", "error", err)
+			}
+			done <- struct{}{}
+		}()
+	}
+
+	// Report readiness and liveness to systemd when running as a Type=notify service
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		slog.Warn("failed to notify systemd of readiness", "error", err)
+	}
+	stopWatchdog := startWatchdog(q, workerPool)
+
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-sigChan
 	slog.Info("Shutdown signal received", "signal", sig.String())
+	stopWatchdog()
+	if _, err := systemd.Notify(systemd.Stopping); err != nil {
+		slog.Warn("failed to notify systemd of shutdown", "error", err)
+	}
 
 	// Graceful shutdown
 	atomic.StoreUint32(&readyFlag, 0)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Stop background replication service first if enabled
+	// Stop accepting events first, so that a process sharing or inheriting the
+	// socket receives them while this one drains
+	if err := eventsHTTP.Shutdown(ctx); err != nil {
+		slog.Error("error shutting down events server", "error", err)
+	}
+
+	// Stop background replication service if enabled
 	if cfg.BackgroundReplicationEnabled && backgroundReplicationService != nil {
 		if err := backgroundReplicationService.Stop(ctx); err != nil {
 			slog.Error("error stopping background replication service", "error", err)
 		}
 	}
+	releaseLeadership()
+
+	if agingService != nil {
+		if err := agingService.Stop(ctx); err != nil {
+			slog.Error("error stopping queue aging service", "error", err)
+		}
+	}
+
+	if standby != nil {
+		if err := standby.Stop(ctx); err != nil {
+			slog.Error("error stopping standby", "error", err)
+		}
+	}
+
+	if deadLetterRedriver != nil {
+		if err := deadLetterRedriver.Stop(ctx); err != nil {
+			slog.Error("error stopping dead-letter re-drive", "error", err)
+		}
+	}
+
+	if err := backlogEstimator.Stop(ctx); err != nil {
+		slog.Error("error stopping backlog estimator", "error", err)
+	}
+
+	if depthHistory != nil {
+		if err := depthHistory.Stop(ctx); err != nil {
+			slog.Error("error stopping queue depth history", "error", err)
+		}
+	}
+
+	if doveadmProbe != nil {
+		if err := doveadmProbe.Stop(ctx); err != nil {
+			slog.Error("error stopping doveadm probe", "error", err)
+		}
+	}
+
+	if destinationMonitor != nil {
+		if err := destinationMonitor.Stop(ctx); err != nil {
+			slog.Error("error stopping destination health monitor", "error", err)
+		}
+	}
 
 	// Stop worker pool (gracefully)
 	if err := workerPool.Stop(ctx); err != nil {
 		slog.Error("error stopping worker pool", "error", err)
 	}
 
-	if err := eventsHTTP.Shutdown(ctx); err != nil {
-		slog.Error("error shutting down events server", "error", err)
-	}
 	if err := metricsHTTP.Shutdown(ctx); err != nil {
 		slog.Error("error shutting down metrics server", "error", err)
 	}
 
+	// Health watches never end on their own, so close them instead of draining
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+
 	// Wait for goroutines to exit or timeout
 	select {
 	case <-done:
@@ -245,3 +909,26 @@ func parseLogLevel(lvl string) slog.Level {
 		return slog.LevelInfo
 	}
 }
+
+// newDoveadmHTTPClient returns the HTTP client shared by all doveadm API
+// clients, with the configured connection pooling.
+func newDoveadmHTTPClient(cfg *config.Config) *http.Client {
+	return doveadm.NewHTTPClient(doveadm.TransportConfig{
+		MaxIdleConns:        cfg.DoveadmMaxIdleConns,
+		MaxIdleConnsPerHost: cfg.DoveadmMaxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.DoveadmMaxConnsPerHost,
+		IdleConnTimeout:     cfg.DoveadmIdleConnTimeout,
+		HTTP2:               cfg.DoveadmHTTP2,
+	})
+}
+
+// splitList splits a comma-separated configuration value, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
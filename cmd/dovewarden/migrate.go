@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/config"
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+	"github.com/dovewarden/dovewarden/internal/queue"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// runMigrate implements the "migrate" subcommand, a one-shot campaign that
+// full-syncs all users to the destination when onboarding an existing server
+// pair, and exits with a summary. It does not need a running daemon.
+func runMigrate(cfg *config.Config, logger *slog.Logger, args []string) (code int) {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	concurrency := fs.Int("concurrency", cfg.NumWorkers, "Number of parallel full syncs")
+	rate := fs.Float64("rate", 0, "Maximum full syncs started per second (0 is unlimited)")
+	usersFile := fs.String("users-file", "", "File with one username per line to sync instead of all users listed by doveadm")
+	progress := fs.Duration("progress-interval", 10*time.Second, "Interval of progress reports")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *concurrency < 1 || *rate < 0 {
+		fmt.Fprintln(os.Stderr, "migrate: --concurrency must be positive and --rate must not be negative")
+		return 2
+	}
+	if cfg.DoveadmPassword == "" {
+		fmt.Fprintln(os.Stderr, "migrate: the doveadm password is required")
+		return 2
+	}
+
+	runMetrics := newRunMetrics("migrate")
+	defer func() { runMetrics.finish(cfg, code) }()
+	campaignUsers := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dovewarden_migration_users",
+		Help: "Users of the last migration by outcome (synced, failed or skipped)",
+	}, []string{"outcome"})
+	runMetrics.registry.MustRegister(campaignUsers)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	client := doveadm.NewClient(cfg.DoveadmURL, cfg.DoveadmPassword)
+	client.SetHTTPClient(newDoveadmHTTPClient(cfg))
+	syncParams, err := doveadm.ParseSyncParams(cfg.DsyncParams)
+	if err != nil {
+		slog.Error("invalid dsync parameters", "error", err)
+		return 1
+	}
+	client.SetSyncParams(syncParams)
+
+	var users []string
+	if *usersFile != "" {
+		if users, err = readUsersFile(*usersFile); err != nil {
+			slog.Error("failed to read users file", "path", *usersFile, "error", err)
+			return 1
+		}
+	} else {
+		listed, err := client.ListUsers(ctx)
+		if err != nil {
+			slog.Error("failed to list users", "error", err)
+			return 1
+		}
+		for _, user := range listed {
+			users = append(users, user.Username)
+		}
+	}
+
+	slog.Info("Starting migration",
+		"users", len(users),
+		"destination", cfg.DoveadmDest,
+		"concurrency", *concurrency,
+		"rate", *rate,
+	)
+	result := queue.RunCampaign(ctx, client, cfg.DoveadmDest, users, queue.CampaignOptions{
+		Concurrency:      *concurrency,
+		Rate:             *rate,
+		ProgressInterval: *progress,
+	}, logger)
+
+	slog.Info("Migration finished",
+		"users", result.Users,
+		"synced", result.Synced,
+		"failed", len(result.Failed),
+		"skipped", result.Skipped,
+		"duration", result.Duration.Round(time.Second),
+	)
+	campaignUsers.WithLabelValues("synced").Set(float64(result.Synced))
+	campaignUsers.WithLabelValues("failed").Set(float64(len(result.Failed)))
+	campaignUsers.WithLabelValues("skipped").Set(float64(result.Skipped))
+	for _, failure := range result.Failed {
+		slog.Error("user not migrated", "username", failure.Username, "error", failure.Error)
+	}
+	if len(result.Failed) > 0 || result.Skipped > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readUsersFile reads one username per line, ignoring blank lines and lines
+// starting with #.
+func readUsersFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var users []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		users = append(users, line)
+	}
+	return users, scanner.Err()
+}
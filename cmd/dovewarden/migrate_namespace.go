@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/config"
+	"github.com/dovewarden/dovewarden/internal/queue"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// runMigrateNamespace implements the "migrate-namespace" subcommand, which moves
+// all keys of one namespace to another in the external Redis server. Stop all
+// dovewarden instances using the source namespace before running it; the data
+// stays in the server meanwhile.
+func runMigrateNamespace(cfg *config.Config, logger *slog.Logger, args []string) (code int) {
+	fs := flag.NewFlagSet("migrate-namespace", flag.ContinueOnError)
+	from := fs.String("from", cfg.Namespace, "Source namespace")
+	to := fs.String("to", "", "Target namespace (required)")
+	dryRun := fs.Bool("dry-run", false, "Only count the keys that would be migrated")
+	timeout := fs.Duration("timeout", 10*time.Minute, "Maximum duration of the migration")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *to == "" {
+		fmt.Fprintln(os.Stderr, "migrate-namespace: --to is required")
+		fs.Usage()
+		return 2
+	}
+
+	runMetrics := newRunMetrics("migrate-namespace")
+	defer func() { runMetrics.finish(cfg, code) }()
+	migratedKeys := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dovewarden_namespace_migration_keys",
+		Help: "Keys of the last namespace migration by outcome (moved or conflict)",
+	}, []string{"outcome"})
+	runMetrics.registry.MustRegister(migratedKeys)
+
+	if cfg.RedisMode != "external" {
+		// the embedded server loses its data when the instances are stopped
+		fmt.Fprintf(os.Stderr, "migrate-namespace: only supported in external Redis mode, not %q\n", cfg.RedisMode)
+		return 2
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: cfg.RedisAddr, Password: cfg.RedisPassword})
+	defer func() {
+		_ = client.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	slog.Info("Migrating namespace", "from", *from, "to", *to, "redis_addr", cfg.RedisAddr, "dry_run", *dryRun)
+	result, err := queue.MigrateNamespace(ctx, client, *from, *to, *dryRun, logger)
+	if err != nil {
+		slog.Error("namespace migration failed", "error", err)
+		return 1
+	}
+
+	migratedKeys.WithLabelValues("moved").Set(float64(result.Moved))
+	migratedKeys.WithLabelValues("conflict").Set(float64(len(result.Conflicts)))
+	slog.Info("Namespace migration completed",
+		"moved", result.Moved,
+		"conflicts", len(result.Conflicts),
+		"dry_run", *dryRun,
+	)
+	if len(result.Conflicts) > 0 {
+		slog.Error("some keys were not migrated because they already exist in the target namespace", "keys", result.Conflicts)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// runMetrics collects the metrics of a one-shot subcommand, which exits
+// before Prometheus could scrape it, and pushes them to a Pushgateway on exit.
+type runMetrics struct {
+	registry *prometheus.Registry
+	command  string
+	start    time.Time
+
+	duration       prometheus.Gauge
+	success        prometheus.Gauge
+	lastCompletion prometheus.Gauge
+}
+
+func newRunMetrics(command string) *runMetrics {
+	m := &runMetrics{
+		registry: prometheus.NewRegistry(),
+		command:  command,
+		start:    time.Now(),
+		duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dovewarden_run_duration_seconds",
+			Help: "Duration of the last one-shot run",
+		}),
+		success: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dovewarden_run_success",
+			Help: "1 if the last one-shot run succeeded, 0 otherwise",
+		}),
+		lastCompletion: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dovewarden_run_last_completion_timestamp_seconds",
+			Help: "Unix time the last one-shot run completed",
+		}),
+	}
+	m.registry.MustRegister(m.duration, m.success, m.lastCompletion)
+	return m
+}
+
+// finish records the outcome of the run and pushes all metrics of the
+// registry if a Pushgateway is configured. Pushing replaces the metrics
+// previously pushed with the same job and grouping labels. Failures are only
+// logged, as they must not change the outcome of the run.
+func (m *runMetrics) finish(cfg *config.Config, exitCode int) {
+	m.duration.Set(time.Since(m.start).Seconds())
+	m.lastCompletion.SetToCurrentTime()
+	if exitCode == 0 {
+		m.success.Set(1)
+	}
+
+	if cfg.PushgatewayURL == "" {
+		return
+	}
+	pusher := push.New(cfg.PushgatewayURL, cfg.PushgatewayJob).
+		Gatherer(m.registry).
+		Grouping("command", m.command)
+	grouping, err := parseGrouping(cfg.PushgatewayGrouping)
+	if err != nil {
+		slog.Error("invalid Pushgateway grouping labels, not pushing metrics", "error", err)
+		return
+	}
+	for _, label := range grouping {
+		pusher = pusher.Grouping(label[0], label[1])
+	}
+	if err := pusher.Push(); err != nil {
+		slog.Error("failed to push metrics to the Pushgateway", "url", cfg.PushgatewayURL, "error", err)
+		return
+	}
+	slog.Info("Pushed metrics to the Pushgateway", "url", cfg.PushgatewayURL, "job", cfg.PushgatewayJob)
+}
+
+// parseGrouping parses comma-separated name=value grouping labels.
+func parseGrouping(spec string) ([][2]string, error) {
+	var labels [][2]string
+	for _, item := range splitList(spec) {
+		name, value, ok := strings.Cut(item, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("invalid grouping label %q, expected name=value", item)
+		}
+		if name == "job" || name == "command" {
+			return nil, fmt.Errorf("grouping label %q is reserved", name)
+		}
+		labels = append(labels, [2]string{name, value})
+	}
+	return labels, nil
+}
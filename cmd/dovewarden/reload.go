@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/dovewarden/dovewarden/internal/config"
+	"github.com/dovewarden/dovewarden/internal/events"
+	"github.com/dovewarden/dovewarden/internal/logging"
+	"github.com/dovewarden/dovewarden/internal/queue"
+	"github.com/dovewarden/dovewarden/internal/server"
+)
+
+// reloader applies the reloadable settings (log levels, filters, priorities and
+// rate limits) to the running components.
+type reloader struct {
+	mu       sync.Mutex
+	cfg      *config.Config
+	levels   *logging.Levels
+	eventSrv *server.Server
+	workers  *queue.WorkerPool
+}
+
+// apply validates the reloadable settings of cfg and applies them. Nothing is
+// applied if any setting is invalid.
+func (r *reloader) apply(cfg *config.Config) error {
+	mailboxPriorities, err := server.ParseMailboxPriorities(cfg.MailboxPriorities)
+	if err != nil {
+		return fmt.Errorf("invalid mailbox priorities: %w", err)
+	}
+	selfNetworks, err := events.ParseRemoteNetworks(splitList(cfg.SelfRemoteIPs))
+	if err != nil {
+		return fmt.Errorf("invalid self remote IPs: %w", err)
+	}
+	allowedNetworks, err := events.ParseRemoteNetworks(splitList(cfg.EventsAllowedIPs))
+	if err != nil {
+		return fmt.Errorf("invalid events allowed IPs: %w", err)
+	}
+	domainIntervals, err := queue.ParseDomainIntervals(cfg.DomainMinSyncIntervals)
+	if err != nil {
+		return fmt.Errorf("invalid domain sync intervals: %w", err)
+	}
+	componentLevels, err := logging.ParseLevels(cfg.LogLevels)
+	if err != nil {
+		return fmt.Errorf("invalid log levels: %w", err)
+	}
+
+	r.levels.Set(parseLogLevel(cfg.LogLevel), componentLevels)
+	r.eventSrv.SetMailboxPriorities(mailboxPriorities)
+	r.eventSrv.SetFirstSeenPriority(cfg.FirstSeenPriority)
+	r.eventSrv.SetAllowedNetworks(allowedNetworks)
+	events.SetIgnoredNamespacePrefixes(splitList(cfg.IgnoredNamespacePrefixes))
+	events.SetSelfInduced(splitList(cfg.SelfSessionPrefixes), selfNetworks)
+
+	rateLimit := queue.NewSyncRateLimit(cfg.UserMinSyncInterval, domainIntervals)
+	if rateLimit.Enabled() {
+		slog.Info("Per-user sync rate limiting enabled", "min_interval", cfg.UserMinSyncInterval, "domain_overrides", len(domainIntervals))
+	}
+	r.workers.SetRateLimit(rateLimit)
+
+	r.cfg = cfg
+	return nil
+}
+
+// reload re-reads the config file and applies the reloadable settings.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.Reload(r.cfg)
+	if err != nil {
+		return err
+	}
+	if err := r.apply(next); err != nil {
+		return err
+	}
+	config.Commit(next)
+	slog.Info("Configuration reloaded", "config_file", next.ConfigFile, "log_level", r.levels.Default().String(), "log_levels", next.LogLevels)
+	return nil
+}
+
+// reloadOnSIGHUP reloads the configuration whenever SIGHUP is received.
+func (r *reloader) reloadOnSIGHUP() {
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			slog.Info("SIGHUP received, reloading configuration")
+			if err := r.reload(); err != nil {
+				slog.Error("Configuration reload failed, keeping previous settings", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"syscall"
+)
+
+// soReusePort is SO_REUSEPORT, which the frozen syscall package lacks on Linux.
+const soReusePort = 0xf
+
+// setReusePort enables SO_REUSEPORT on a socket before it is bound.
+func setReusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
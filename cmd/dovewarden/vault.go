@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/config"
+	"github.com/dovewarden/dovewarden/internal/vault"
+)
+
+// Keys of the Vault secret holding dovewarden's credentials.
+const (
+	vaultKeyDoveadmPassword = "doveadm_password"
+	vaultKeyRedisPassword   = "redis_password"
+	vaultKeyPrivacySalt     = "privacy_salt"
+)
+
+// loadVaultSecrets logs in to Vault and replaces the credentials in cfg with
+// those found in the configured secret. Keys missing from the secret keep
+// their configured values.
+func loadVaultSecrets(cfg *config.Config) (*vault.Client, error) {
+	if cfg.VaultSecretPath == "" {
+		return nil, errors.New("the vault secret path is required")
+	}
+	client, err := vault.NewClient(vault.Config{
+		Addr:         cfg.VaultAddr,
+		Namespace:    cfg.VaultNamespace,
+		AuthMethod:   cfg.VaultAuthMethod,
+		AuthMount:    cfg.VaultAuthMount,
+		RoleID:       cfg.VaultRoleID,
+		SecretIDFile: cfg.VaultSecretIDFile,
+		Role:         cfg.VaultRole,
+		TokenFile:    cfg.VaultTokenFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := client.Login(ctx); err != nil {
+		return nil, err
+	}
+	secret, err := client.ReadSecret(ctx, cfg.VaultSecretPath)
+	if err != nil {
+		return nil, err
+	}
+	if v := secret.Data[vaultKeyDoveadmPassword]; v != "" {
+		cfg.DoveadmPassword = v
+	}
+	if v := secret.Data[vaultKeyRedisPassword]; v != "" {
+		cfg.RedisPassword = v
+	}
+	if v := secret.Data[vaultKeyPrivacySalt]; v != "" {
+		cfg.PrivacySalt = v
+	}
+	return client, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/queue"
+	"github.com/dovewarden/dovewarden/internal/systemd"
+)
+
+// startWatchdog sends systemd watchdog notifications at half the configured
+// watchdog interval for as long as the queue is healthy and the worker pool
+// makes progress, so that systemd restarts a hung process. It returns a
+// function stopping the notifications; without a watchdog it does nothing.
+func startWatchdog(q queue.Queue, workers *queue.WorkerPool) func() {
+	interval, err := systemd.WatchdogInterval()
+	if err != nil {
+		slog.Warn("ignoring systemd watchdog", "error", err)
+		return func() {}
+	}
+	if interval == 0 {
+		return func() {}
+	}
+	slog.Info("Enabling systemd watchdog", "interval", interval)
+
+	stopCh := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				if !workers.Alive(interval) {
+					slog.Error("worker pool stalled, withholding systemd watchdog notification")
+					continue
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), interval/4)
+				err := q.HealthCheck(ctx)
+				cancel()
+				if err != nil {
+					slog.Error("queue unhealthy, withholding systemd watchdog notification", "error", err)
+					continue
+				}
+				if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+					slog.Warn("failed to notify systemd watchdog", "error", err)
+				}
+			}
+		}
+	}()
+
+	return func() { close(stopCh) }
+}
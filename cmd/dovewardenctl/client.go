@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// adminClient performs requests against the dovewarden admin API.
+type adminClient struct {
+	baseURL string
+	token   string
+	timeout time.Duration
+	client  *http.Client
+}
+
+func newAdminClient(baseURL, token string, timeout time.Duration) *adminClient {
+	return &adminClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		timeout: timeout,
+		client:  &http.Client{},
+	}
+}
+
+// getJSON fetches path and decodes the JSON response into out.
+func (c *adminClient) getJSON(path string, out any) error {
+	return c.doJSON(http.MethodGet, path, nil, out)
+}
+
+// postJSON sends in as JSON to path and decodes the JSON response into out.
+func (c *adminClient) postJSON(path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	return c.doJSON(http.MethodPost, path, body, out)
+}
+
+// putJSON sends in as JSON to path with PUT and decodes the JSON response, if
+// any, into out.
+func (c *adminClient) putJSON(path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	return c.doJSON(http.MethodPut, path, body, out)
+}
+
+// delete sends a DELETE request to path.
+func (c *adminClient) delete(path string) error {
+	return c.doJSON(http.MethodDelete, path, nil, nil)
+}
+
+// doJSON performs a request with an optional JSON body and decodes the JSON
+// response into out, unless out is nil.
+func (c *adminClient) doJSON(method, path string, reqBody []byte, out any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	var bodyReader io.Reader
+	if reqBody != nil {
+		bodyReader = bytes.NewReader(reqBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bodyReader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("admin API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
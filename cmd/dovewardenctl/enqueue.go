@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dovewarden/dovewarden/internal/server"
+)
+
+// enqueueBatchSize is the number of users sent per POST /admin/enqueue.
+const enqueueBatchSize = 1000
+
+// enqueueResult is the JSON output of enqueue.
+type enqueueResult struct {
+	Requested int `json:"requested"`
+	Enqueued  int `json:"enqueued"`
+	Failed    int `json:"failed"` // users of batches the admin API rejected
+}
+
+// runEnqueue queues the users given as arguments or listed in --file for a
+// sync through POST /admin/enqueue, in batches. It exits with exitPartial if
+// only some of the batches were enqueued.
+func runEnqueue(c *adminClient, args []string) int {
+	fs := flag.NewFlagSet("enqueue", flag.ContinueOnError)
+	priority := fs.Float64("priority", 1, "Priority factor, above 1 is synced sooner")
+	full := fs.Bool("full", false, "Drop the replication state so that the users are fully synced")
+	file := fs.String("file", "", "File listing one user per line, - for stdin; empty lines and lines starting with # are skipped")
+
+	// flags may follow the usernames
+	var users []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return exitError
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		users = append(users, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if *priority <= 0 {
+		return fail(errors.New("--priority must be positive"))
+	}
+	if *file != "" {
+		fileUsers, err := readUsersFile(*file)
+		if err != nil {
+			return fail(err)
+		}
+		users = append(users, fileUsers...)
+	}
+	if len(users) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: dovewardenctl enqueue <username>... [--priority F] [--full]")
+		fmt.Fprintln(os.Stderr, "       dovewardenctl enqueue --file users.txt [--priority F] [--full]")
+		return exitError
+	}
+
+	// a failed batch does not stop the others, so that an interrupted run
+	// reports which part of the list to retry
+	result := enqueueResult{Requested: len(users)}
+	for start := 0; start < len(users); start += enqueueBatchSize {
+		batch := users[start:min(start+enqueueBatchSize, len(users))]
+		var resp server.EnqueueResponse
+		req := server.EnqueueRequest{Users: batch, Priority: *priority, Full: *full}
+		if err := c.postJSON("/admin/enqueue", req, &resp); err != nil {
+			fmt.Fprintf(os.Stderr, "error: users %d to %d: %v\n", start+1, start+len(batch), err)
+			result.Failed += len(batch)
+			continue
+		}
+		result.Enqueued += resp.Enqueued
+	}
+
+	if jsonOutput {
+		if code := printJSON(result); code != exitOK {
+			return code
+		}
+	} else {
+		fmt.Printf("Enqueued %d of %d users\n", result.Enqueued, result.Requested)
+	}
+	switch {
+	case result.Failed == 0:
+		return exitOK
+	case result.Failed < result.Requested:
+		return exitPartial
+	default:
+		return exitError
+	}
+}
+
+// readUsersFile reads one username per line from path, or stdin for "-".
+func readUsersFile(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open users file: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+
+	var users []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		users = append(users, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+	return users, nil
+}
@@ -0,0 +1,172 @@
+// Command dovewardenctl is the operator CLI for a running dovewarden instance.
+// It talks to the admin API served on the metrics listener.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+var version = "0.0.0-dev" // Set by ldflags during build
+
+// Exit codes of all commands.
+const (
+	exitOK      = 0
+	exitError   = 1 // the command failed or was invoked incorrectly
+	exitPartial = 2 // the command succeeded for some of the given users only
+)
+
+// jsonOutput is set by --output json: commands print their result as JSON to
+// stdout instead of tables. Errors are still printed to stderr.
+var jsonOutput bool
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: dovewardenctl [flags] <command> [args]
+
+Commands:
+  replicator status    Show queue summary like "doveadm replicator status"
+  queue watch          Show a live view of the queue, refreshed until interrupted
+  queue export [--file file] [--limit N]
+  queue import [--file file]
+                       Copy the queued users between environments
+  enqueue <user>... [--priority F] [--full]
+  enqueue --file users.txt
+                       Queue users for a sync
+  state get|clear <user>
+                       Show or drop the stored dsync state of a user
+  state export <user> [--file file]
+  state import <user> [--file file]
+                       Copy the dsync state of a user between environments
+  user history <user>  Show the most recent sync attempts of a user
+  user rename <old> <new>
+                       Move the replication state of a renamed user
+  version              Show version
+
+Exit codes: 0 ok, 1 error, 2 partial success (e.g. some users not enqueued)
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	adminURL := flag.String("admin-url", envOrDefault("DOVEWARDEN_ADMIN_URL", "http://localhost:9090"), "Base URL of the dovewarden admin API")
+	token := flag.String("token", os.Getenv("DOVEWARDEN_ADMIN_TOKEN"), "Bearer token for the admin API")
+	timeout := flag.Duration("timeout", 30*time.Second, "Timeout for admin API requests")
+	output := flag.String("output", envOrDefault("DOVEWARDEN_OUTPUT", "text"), "Output format: text or json")
+	flag.Usage = usage
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitError)
+	}
+	switch *output {
+	case "text":
+	case "json":
+		jsonOutput = true
+	default:
+		fmt.Fprintf(os.Stderr, "invalid output format %q, must be text or json\n", *output)
+		os.Exit(exitError)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(exitError)
+	}
+
+	c := newAdminClient(*adminURL, *token, *timeout)
+
+	switch args[0] {
+	case "replicator":
+		if len(args) < 2 || args[1] != "status" {
+			fmt.Fprintln(os.Stderr, "usage: dovewardenctl replicator status [--next N]")
+			os.Exit(exitError)
+		}
+		os.Exit(runReplicatorStatus(c, args[2:]))
+	case "queue":
+		if len(args) >= 2 {
+			switch args[1] {
+			case "watch":
+				os.Exit(runQueueWatch(c, args[2:]))
+			case "export":
+				os.Exit(runQueueExport(c, args[2:]))
+			case "import":
+				os.Exit(runQueueImport(c, args[2:]))
+			}
+		}
+		fmt.Fprintln(os.Stderr, "usage: dovewardenctl queue watch [--interval 2s] [--next N]")
+		fmt.Fprintln(os.Stderr, "       dovewardenctl queue export [--file file] [--limit N]")
+		fmt.Fprintln(os.Stderr, "       dovewardenctl queue import [--file file]")
+		os.Exit(exitError)
+	case "enqueue":
+		os.Exit(runEnqueue(c, args[1:]))
+	case "state":
+		if len(args) >= 3 {
+			switch args[1] {
+			case "get":
+				os.Exit(runStateGet(c, args[2]))
+			case "clear":
+				os.Exit(runStateClear(c, args[2]))
+			case "export":
+				os.Exit(runStateExport(c, args[2], args[3:]))
+			case "import":
+				os.Exit(runStateImport(c, args[2], args[3:]))
+			}
+		}
+		fmt.Fprintln(os.Stderr, "usage: dovewardenctl state get|clear <username>")
+		fmt.Fprintln(os.Stderr, "       dovewardenctl state export <username> [--file file]")
+		fmt.Fprintln(os.Stderr, "       dovewardenctl state import <username> [--file file]")
+		os.Exit(exitError)
+	case "user":
+		switch {
+		case len(args) == 3 && args[1] == "history":
+			os.Exit(runUserHistory(c, args[2]))
+		case len(args) == 4 && args[1] == "rename":
+			os.Exit(runUserRename(c, args[2], args[3]))
+		}
+		fmt.Fprintln(os.Stderr, "usage: dovewardenctl user history <username>")
+		fmt.Fprintln(os.Stderr, "       dovewardenctl user rename <old-username> <new-username>")
+		os.Exit(exitError)
+	case "version":
+		if jsonOutput {
+			os.Exit(printJSON(struct {
+				Version string `json:"version"`
+			}{version}))
+		}
+		fmt.Printf("dovewardenctl version %s\n", version)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		usage()
+		os.Exit(exitError)
+	}
+}
+
+// printJSON prints v as indented JSON to stdout.
+func printJSON(v any) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fail(fmt.Errorf("failed to encode output: %w", err))
+	}
+	return exitOK
+}
+
+// fail prints err to stderr and returns exitError.
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	return exitError
+}
+
+func envOrDefault(key, defaultVal string) string {
+	if val, ok := os.LookupEnv(key); ok {
+		return val
+	}
+	return defaultVal
+}
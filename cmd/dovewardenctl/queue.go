@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/queue"
+	"github.com/dovewarden/dovewarden/internal/server"
+)
+
+// clearScreen moves the cursor home and clears the terminal.
+const clearScreen = "\033[H\033[2J"
+
+// queueSnapshot is one poll of queue watch, printed as a line of JSON with
+// --output json.
+type queueSnapshot struct {
+	Time    time.Time                `json:"time"`
+	Status  *server.ReplicatorStatus `json:"status,omitempty"`
+	Backlog *server.BacklogETA       `json:"backlog,omitempty"` // nil if the backlog estimate is not available
+	Error   string                   `json:"error,omitempty"`
+}
+
+// runQueueWatch polls GET /admin/replicator/status and GET /admin/backlog/eta
+// and redraws the queue depth, in-flight syncs, throughput and the age of the
+// oldest entry until interrupted, like `watch doveadm replicator status`.
+// Failed polls are shown in place of the view and retried. With --output json,
+// every poll is printed as one line of JSON instead.
+func runQueueWatch(c *adminClient, args []string) int {
+	fs := flag.NewFlagSet("queue watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", 2*time.Second, "Time between refreshes")
+	next := fs.Int("next", 10, "Number of upcoming syncs to list")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if *interval <= 0 {
+		return fail(errors.New("--interval must be positive"))
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	enc := json.NewEncoder(os.Stdout)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		snapshot := fetchQueueSnapshot(c, *next)
+		if jsonOutput {
+			if err := enc.Encode(snapshot); err != nil {
+				return fail(fmt.Errorf("failed to encode output: %w", err))
+			}
+		} else {
+			fmt.Print(clearScreen + renderQueueWatch(snapshot, *interval))
+		}
+		select {
+		case <-ticker.C:
+		case <-sigCh:
+			if !jsonOutput {
+				fmt.Println()
+			}
+			return exitOK
+		}
+	}
+}
+
+// fetchQueueSnapshot polls the queue status and, if available, the backlog
+// estimate.
+func fetchQueueSnapshot(c *adminClient, next int) queueSnapshot {
+	snapshot := queueSnapshot{Time: time.Now()}
+	var status server.ReplicatorStatus
+	if err := c.getJSON(fmt.Sprintf("/admin/replicator/status?next=%d", next), &status); err != nil {
+		snapshot.Error = err.Error()
+		return snapshot
+	}
+	snapshot.Status = &status
+	// the backlog estimate is optional, throughput is unknown without it
+	var backlog server.BacklogETA
+	if err := c.getJSON("/admin/backlog/eta", &backlog); err == nil && backlog.BacklogEstimate != nil {
+		snapshot.Backlog = &backlog
+	}
+	return snapshot
+}
+
+// renderQueueWatch renders one frame of the view.
+func renderQueueWatch(snapshot queueSnapshot, interval time.Duration) string {
+	var b strings.Builder
+	now := snapshot.Time
+	fmt.Fprintf(&b, "Every %s: dovewardenctl queue watch  %s\n\n", interval, now.Format(time.DateTime))
+	if snapshot.Error != "" {
+		fmt.Fprintf(&b, "error: %s\n", snapshot.Error)
+		return b.String()
+	}
+
+	status := snapshot.Status
+	throughput := "-"
+	if backlog := snapshot.Backlog; backlog != nil {
+		throughput = fmt.Sprintf("%.2f syncs/s", backlog.ThroughputPerSecond)
+		if backlog.ETA != "" && backlog.Queued > 0 {
+			throughput += " (drained in " + backlog.ETA + ")"
+		}
+	}
+	oldest := "-"
+	if !status.OldestEnqueuedAt.IsZero() {
+		oldest = now.Sub(status.OldestEnqueuedAt).Truncate(time.Second).String()
+	}
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "Destination\t%s\n", status.Destination)
+	_, _ = fmt.Fprintf(w, "Queued\t%d (%d full, %d incremental)\n", status.Queued, status.QueuedFull, status.QueuedIncremental)
+	_, _ = fmt.Fprintf(w, "In-flight\t%d\n", status.InFlight)
+	_, _ = fmt.Fprintf(w, "Throughput\t%s\n", throughput)
+	_, _ = fmt.Fprintf(w, "Oldest entry\t%s\n", oldest)
+	_, _ = fmt.Fprintf(w, "Failed\t%d\n", status.Failed)
+	_, _ = fmt.Fprintf(w, "Rate-limited\t%d\n", status.Deferred)
+	_, _ = fmt.Fprintf(w, "Spilled to disk\t%d\n", status.Spilled)
+	_ = w.Flush()
+
+	if len(status.Next) == 0 {
+		return b.String()
+	}
+	b.WriteString("\n")
+	w = tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "username\ttype\twaiting")
+	for _, u := range status.Next {
+		syncType := "incremental"
+		if u.FullSync {
+			syncType = "full"
+		}
+		waiting := "-"
+		if !u.EnqueuedAt.IsZero() {
+			waiting = now.Sub(u.EnqueuedAt).Truncate(time.Second).String()
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", u.Username, syncType, waiting)
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// runQueueExport writes the queued users with their scores and event info as
+// JSON to --file, or stdout, for `queue import` in another environment. The
+// export is JSON regardless of --output.
+func runQueueExport(c *adminClient, args []string) int {
+	fs := flag.NewFlagSet("queue export", flag.ContinueOnError)
+	file := fs.String("file", "-", "File to write the queue to, - for stdout")
+	limit := fs.Int("limit", 0, "Export at most this many users in dequeue order, 0 for all")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if *limit < 0 {
+		fmt.Fprintln(os.Stderr, "--limit must not be negative")
+		return exitError
+	}
+
+	path := "/admin/queue/export"
+	if *limit > 0 {
+		path += "?limit=" + strconv.Itoa(*limit)
+	}
+	var export queue.QueueExport
+	if err := c.getJSON(path, &export); err != nil {
+		return fail(err)
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return fail(fmt.Errorf("failed to encode queue: %w", err))
+	}
+	data = append(data, '\n')
+	if *file == "-" {
+		_, _ = os.Stdout.Write(data)
+		return exitOK
+	}
+	if err := os.WriteFile(*file, data, 0o600); err != nil {
+		return fail(fmt.Errorf("failed to write queue: %w", err))
+	}
+	return exitOK
+}
+
+// runQueueImport queues the users exported by `queue export` from --file, or
+// stdin. Users already queued keep the better of their scores.
+func runQueueImport(c *adminClient, args []string) int {
+	fs := flag.NewFlagSet("queue import", flag.ContinueOnError)
+	file := fs.String("file", "-", "File to read the queue from, - for stdin")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fail(fmt.Errorf("failed to open queue file: %w", err))
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+	var export queue.QueueExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return fail(fmt.Errorf("queue file must be the output of dovewardenctl queue export: %w", err))
+	}
+
+	var resp server.QueueImportResponse
+	if err := c.postJSON("/admin/queue/import", export, &resp); err != nil {
+		return fail(err)
+	}
+	if jsonOutput {
+		return printJSON(resp)
+	}
+	fmt.Printf("Imported %d queued users\n", resp.Imported)
+	return exitOK
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/server"
+)
+
+// runReplicatorStatus renders GET /admin/replicator/status in the layout of the
+// former `doveadm replicator status`.
+func runReplicatorStatus(c *adminClient, args []string) int {
+	fs := flag.NewFlagSet("replicator status", flag.ContinueOnError)
+	next := fs.Int("next", 10, "Number of upcoming syncs to list")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+
+	var status server.ReplicatorStatus
+	if err := c.getJSON(fmt.Sprintf("/admin/replicator/status?next=%d", *next), &status); err != nil {
+		return fail(err)
+	}
+	if jsonOutput {
+		return printJSON(status)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "Destination\t%s\n", status.Destination)
+	_, _ = fmt.Fprintf(w, "Queued 'full' requests\t%d\n", status.QueuedFull)
+	_, _ = fmt.Fprintf(w, "Queued 'incremental' requests\t%d\n", status.QueuedIncremental)
+	_, _ = fmt.Fprintf(w, "In-flight requests\t%d\n", status.InFlight)
+	_, _ = fmt.Fprintf(w, "Failed requests\t%d\n", status.Failed)
+	_, _ = fmt.Fprintf(w, "Rate-limited users\t%d\n", status.Deferred)
+	_, _ = fmt.Fprintf(w, "Spilled to disk\t%d\n", status.Spilled)
+	_, _ = fmt.Fprintf(w, "Total number of known users\t%d\n", status.KnownUsers)
+	_ = w.Flush()
+
+	if len(status.Next) == 0 {
+		return exitOK
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "username\ttype\twaiting\tscore")
+	for _, u := range status.Next {
+		syncType := "incremental"
+		if u.FullSync {
+			syncType = "full"
+		}
+		waiting := "-"
+		if !u.EnqueuedAt.IsZero() {
+			waiting = time.Since(u.EnqueuedAt).Truncate(time.Second).String()
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%.3f\n", u.Username, syncType, waiting, u.Score)
+	}
+	_ = w.Flush()
+	return exitOK
+}
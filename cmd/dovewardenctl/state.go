@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/server"
+)
+
+// stateResult is the JSON output of state clear and state import.
+type stateResult struct {
+	Username string `json:"username"`
+	Cleared  bool   `json:"cleared,omitempty"`
+	Imported bool   `json:"imported,omitempty"`
+}
+
+func statePath(username string) string {
+	return "/admin/users/" + url.PathEscape(username) + "/state"
+}
+
+// runStateGet shows the stored dsync state of a user.
+func runStateGet(c *adminClient, username string) int {
+	var state server.UserState
+	if err := c.getJSON(statePath(username), &state); err != nil {
+		return fail(err)
+	}
+	if jsonOutput {
+		return printJSON(state)
+	}
+	last := "-"
+	if !state.LastReplication.IsZero() {
+		last = state.LastReplication.Local().Format(time.DateTime)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "Username\t%s\n", state.Username)
+	_, _ = fmt.Fprintf(w, "Destination\t%s\n", state.Destination)
+	_, _ = fmt.Fprintf(w, "Last replication\t%s\n", last)
+	_, _ = fmt.Fprintf(w, "State\t%s\n", state.State)
+	_ = w.Flush()
+	return exitOK
+}
+
+// runStateClear drops the stored dsync state of a user, so that its next sync
+// is a full sync.
+func runStateClear(c *adminClient, username string) int {
+	if err := c.delete(statePath(username)); err != nil {
+		return fail(err)
+	}
+	if jsonOutput {
+		return printJSON(stateResult{Username: username, Cleared: true})
+	}
+	fmt.Printf("Cleared the replication state of %s, its next sync is a full sync\n", username)
+	return exitOK
+}
+
+// runStateExport writes the stored dsync state of a user as JSON to --file,
+// or stdout, for `state import` in another environment. The export is JSON
+// regardless of --output.
+func runStateExport(c *adminClient, username string, args []string) int {
+	fs := flag.NewFlagSet("state export", flag.ContinueOnError)
+	file := fs.String("file", "-", "File to write the state to, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+
+	var state server.UserState
+	if err := c.getJSON(statePath(username), &state); err != nil {
+		return fail(err)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fail(fmt.Errorf("failed to encode state: %w", err))
+	}
+	data = append(data, '\n')
+	if *file == "-" {
+		_, _ = os.Stdout.Write(data)
+		return exitOK
+	}
+	if err := os.WriteFile(*file, data, 0o600); err != nil {
+		return fail(fmt.Errorf("failed to write state: %w", err))
+	}
+	return exitOK
+}
+
+// runStateImport stores the dsync state exported by `state export` from
+// --file, or stdin, for a user. The username of the export is ignored, so a
+// state can be imported for a renamed user.
+func runStateImport(c *adminClient, username string, args []string) int {
+	fs := flag.NewFlagSet("state import", flag.ContinueOnError)
+	file := fs.String("file", "-", "File to read the state from, - for stdin")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fail(fmt.Errorf("failed to open state file: %w", err))
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+	var state server.UserState
+	if err := json.NewDecoder(r).Decode(&state); err != nil || state.State == "" {
+		return fail(errors.New("state file must be the output of dovewardenctl state export"))
+	}
+
+	if err := c.putJSON(statePath(username), server.UserState{State: state.State}, nil); err != nil {
+		return fail(err)
+	}
+	if jsonOutput {
+		return printJSON(stateResult{Username: username, Imported: true})
+	}
+	fmt.Printf("Imported the replication state of %s\n", username)
+	return exitOK
+}
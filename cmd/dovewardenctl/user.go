@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/server"
+)
+
+// runUserRename moves the replication state and history of a renamed user to
+// its new username.
+func runUserRename(c *adminClient, oldName, newName string) int {
+	var resp server.RenameResponse
+	path := "/admin/users/" + url.PathEscape(oldName) + "/rename"
+	if err := c.postJSON(path, server.RenameRequest{NewUsername: newName}, &resp); err != nil {
+		return fail(err)
+	}
+	if jsonOutput {
+		return printJSON(resp)
+	}
+	fmt.Printf("Renamed %s to %s\n", resp.OldUsername, resp.NewUsername)
+	return exitOK
+}
+
+// runUserHistory renders GET /admin/users/{user}/history as a table, most
+// recent attempt first.
+func runUserHistory(c *adminClient, username string) int {
+	var history server.UserHistory
+	if err := c.getJSON("/admin/users/"+url.PathEscape(username)+"/history", &history); err != nil {
+		return fail(err)
+	}
+	if jsonOutput {
+		return printJSON(history)
+	}
+	if len(history.History) == 0 {
+		fmt.Println("No sync attempts recorded")
+		return exitOK
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "started\tduration\ttype\tresult\ttrigger\terror")
+	for _, e := range history.History {
+		syncType := "incremental"
+		if e.FullSync {
+			syncType = "full"
+		}
+		trigger := "-"
+		if e.Trigger != nil {
+			trigger = strings.TrimSpace(e.Trigger.Event + " " + e.Trigger.CmdName)
+			if len(e.Trigger.Mailboxes) > 0 {
+				trigger += " (" + strings.Join(e.Trigger.Mailboxes, ", ") + ")"
+			}
+		}
+		errMsg := "-"
+		if e.Error != "" {
+			errMsg = e.Error
+		}
+		duration := time.Duration(e.DurationSeconds * float64(time.Second)).Round(time.Millisecond)
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.StartedAt.Local().Format(time.DateTime), duration, syncType, e.Result, trigger, errMsg)
+	}
+	_ = w.Flush()
+	return exitOK
+}
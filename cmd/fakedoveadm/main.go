@@ -0,0 +1,66 @@
+// Command fakedoveadm serves a fake doveadm HTTP API for integration tests and
+// local development without a Dovecot pair. Syncs succeed with a generated
+// state unless failures are injected; see package doveadmtest for the control
+// API under /fake/.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm/doveadmtest"
+)
+
+func main() {
+	addr := flag.String("addr", envOrDefault("FAKEDOVEADM_ADDR", ":8080"), "Listen address")
+	password := flag.String("password", envOrDefault("FAKEDOVEADM_PASSWORD", "doveadm"), "Doveadm API password")
+	users := flag.String("users", envOrDefault("FAKEDOVEADM_USERS", ""), "Comma-separated users returned by the user listing")
+	failureRate := flag.Float64("failure-rate", 0, "Probability in [0, 1] of a sync failing")
+	failingUsers := flag.String("failing-users", "", "Comma-separated users whose syncs always fail")
+	latency := flag.Duration("latency", 0, "Delay added to every sync")
+	jitter := flag.Duration("latency-jitter", 0, "Random extra delay of up to this duration")
+	mailboxes := flag.String("mailboxes", "", "Comma-separated mailboxes listed for every user (default INBOX)")
+	flag.Parse()
+
+	if *failureRate < 0 || *failureRate > 1 {
+		slog.Error("failure rate must be between 0 and 1", "failure_rate", *failureRate)
+		os.Exit(2)
+	}
+
+	srv := doveadmtest.New(*password, splitList(*users))
+	srv.SetConfig(doveadmtest.Config{
+		FailureRate:   *failureRate,
+		FailingUsers:  splitList(*failingUsers),
+		Latency:       doveadmtest.Duration(*latency),
+		LatencyJitter: doveadmtest.Duration(*jitter),
+		Mailboxes:     splitList(*mailboxes),
+	})
+
+	slog.Info("Starting fake doveadm API", "addr", *addr, "failure_rate", *failureRate, "latency", *latency)
+	httpSrv := &http.Server{Addr: *addr, Handler: srv, ReadHeaderTimeout: 10 * time.Second}
+	if err := httpSrv.ListenAndServe(); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func envOrDefault(key, defaultVal string) string {
+	if val, ok := os.LookupEnv(key); ok {
+		return val
+	}
+	return defaultVal
+}
@@ -0,0 +1,161 @@
+// Package alerting evaluates alert rules, such as an old queue head or
+// repeated sync failures, at a fixed interval and notifies webhooks, mail
+// recipients or PagerDuty when an alert starts firing and when it resolves.
+// It lets small deployments get paged without running Alertmanager.
+package alerting
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Alert statuses.
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Severities of rules.
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+// Alert is a notification about a rule that started firing or resolved.
+type Alert struct {
+	Rule     string            `json:"rule"`
+	Status   string            `json:"status"`
+	Severity string            `json:"severity"`
+	Summary  string            `json:"summary"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	StartsAt time.Time         `json:"starts_at"`
+	EndsAt   *time.Time        `json:"ends_at,omitempty"`
+}
+
+// Notifier delivers alerts, e.g. to a webhook or a pager.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Rule is a condition evaluated on every pass. Evaluate reports whether the
+// condition holds and a human-readable summary of the observed value.
+type Rule struct {
+	Name     string
+	Severity string
+	Evaluate func(ctx context.Context) (firing bool, summary string, err error)
+}
+
+// Engine evaluates its rules periodically. Notifiers are called once when a
+// rule starts firing and once when it resolves, not on every pass.
+type Engine struct {
+	rules     []Rule
+	notifiers []Notifier
+	labels    map[string]string
+	interval  time.Duration
+	logger    *slog.Logger
+
+	mu     sync.Mutex
+	firing map[string]Alert
+}
+
+// NewEngine creates an engine evaluating rules every interval and notifying
+// the given notifiers. The labels are attached to every alert.
+func NewEngine(interval time.Duration, labels map[string]string, logger *slog.Logger, notifiers ...Notifier) *Engine {
+	return &Engine{
+		notifiers: notifiers,
+		labels:    labels,
+		interval:  interval,
+		logger:    logger,
+		firing:    make(map[string]Alert),
+	}
+}
+
+// AddRule adds a rule. It must be called before Run.
+func (e *Engine) AddRule(r Rule) {
+	e.rules = append(e.rules, r)
+}
+
+// Firing returns the currently firing alerts.
+func (e *Engine) Firing() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	alerts := make([]Alert, 0, len(e.firing))
+	for _, a := range e.firing {
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
+
+// Run evaluates all rules every interval until ctx is cancelled.
+func (e *Engine) Run(ctx context.Context) {
+	e.logger.Info("Starting alerting engine", "interval", e.interval, "rules", len(e.rules), "notifiers", len(e.notifiers))
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Evaluate(ctx)
+		}
+	}
+}
+
+// Evaluate runs a single pass over all rules and sends notifications for
+// rules whose state changed. A rule that fails to evaluate keeps its state,
+// so that a flaky backend neither fires nor resolves alerts.
+func (e *Engine) Evaluate(ctx context.Context) {
+	now := time.Now()
+	for _, rule := range e.rules {
+		firing, summary, err := rule.Evaluate(ctx)
+		if err != nil {
+			e.logger.Warn("Failed to evaluate alert rule", "rule", rule.Name, "error", err)
+			continue
+		}
+
+		e.mu.Lock()
+		active, wasFiring := e.firing[rule.Name]
+		var alert Alert
+		switch {
+		case firing && !wasFiring:
+			alert = Alert{
+				Rule:     rule.Name,
+				Status:   StatusFiring,
+				Severity: rule.Severity,
+				Summary:  summary,
+				Labels:   e.labels,
+				StartsAt: now,
+			}
+			e.firing[rule.Name] = alert
+		case !firing && wasFiring:
+			alert = active
+			alert.Status = StatusResolved
+			alert.Summary = summary
+			alert.EndsAt = &now
+			delete(e.firing, rule.Name)
+		}
+		e.mu.Unlock()
+
+		if alert.Rule == "" {
+			continue
+		}
+		if alert.Status == StatusFiring {
+			e.logger.Warn("Alert firing", "rule", rule.Name, "summary", summary)
+		} else {
+			e.logger.Info("Alert resolved", "rule", rule.Name, "summary", summary)
+		}
+		e.notify(ctx, alert)
+	}
+}
+
+func (e *Engine) notify(ctx context.Context, a Alert) {
+	notifyCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	for _, n := range e.notifiers {
+		if err := n.Notify(notifyCtx, a); err != nil {
+			e.logger.Error("Failed to send alert notification", "rule", a.Rule, "status", a.Status, "error", err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, a Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, a)
+	return nil
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+type fakeFailedCounter struct {
+	n   int64
+	err error
+}
+
+func (f *fakeFailedCounter) FailedCount(ctx context.Context) (int64, error) {
+	return f.n, f.err
+}
+
+func TestEngineNotifiesOnStateChanges(t *testing.T) {
+	notifier := &recordingNotifier{}
+	engine := NewEngine(0, map[string]string{"namespace": "dw"}, discardLogger(), notifier)
+	var failures int64
+	engine.AddRule(ConsecutiveFailuresRule(func() int64 { return failures }, 3))
+	ctx := context.Background()
+
+	engine.Evaluate(ctx)
+	failures = 3
+	engine.Evaluate(ctx)
+	failures = 5
+	engine.Evaluate(ctx)
+	if len(notifier.alerts) != 1 || notifier.alerts[0].Status != StatusFiring {
+		t.Fatalf("expected a single firing notification, got %+v", notifier.alerts)
+	}
+	if len(engine.Firing()) != 1 {
+		t.Fatalf("expected one firing alert, got %+v", engine.Firing())
+	}
+
+	failures = 0
+	engine.Evaluate(ctx)
+	engine.Evaluate(ctx)
+	if len(notifier.alerts) != 2 {
+		t.Fatalf("expected a single resolve notification, got %+v", notifier.alerts)
+	}
+	resolved := notifier.alerts[1]
+	if resolved.Status != StatusResolved || resolved.EndsAt == nil || !resolved.StartsAt.Equal(notifier.alerts[0].StartsAt) {
+		t.Fatalf("unexpected resolve notification %+v", resolved)
+	}
+	if resolved.Labels["namespace"] != "dw" {
+		t.Fatalf("expected engine labels on alert, got %v", resolved.Labels)
+	}
+	if len(engine.Firing()) != 0 {
+		t.Fatalf("expected no firing alerts, got %+v", engine.Firing())
+	}
+}
+
+func TestEngineKeepsStateOnEvaluationError(t *testing.T) {
+	notifier := &recordingNotifier{}
+	engine := NewEngine(0, nil, discardLogger(), notifier)
+	counter := &fakeFailedCounter{}
+	engine.AddRule(FailedGrowthRule(counter, 10, time.Hour))
+	ctx := context.Background()
+
+	engine.Evaluate(ctx)
+	counter.n = 10
+	engine.Evaluate(ctx)
+	if len(notifier.alerts) != 1 {
+		t.Fatalf("expected firing notification, got %+v", notifier.alerts)
+	}
+	counter.err = errors.New("backend down")
+	engine.Evaluate(ctx)
+	if len(notifier.alerts) != 1 || len(engine.Firing()) != 1 {
+		t.Fatalf("evaluation error must not resolve the alert, got %+v", notifier.alerts)
+	}
+}
+
+func TestFailedGrowthRule(t *testing.T) {
+	counter := &fakeFailedCounter{n: 100}
+	rule := FailedGrowthRule(counter, 5, time.Hour)
+	ctx := context.Background()
+
+	if firing, _, _ := rule.Evaluate(ctx); firing {
+		t.Fatal("a single sample must not fire")
+	}
+	counter.n = 104
+	if firing, _, _ := rule.Evaluate(ctx); firing {
+		t.Fatal("growth below the threshold must not fire")
+	}
+	counter.n = 105
+	firing, summary, err := rule.Evaluate(ctx)
+	if err != nil || !firing {
+		t.Fatalf("expected growth of 5 to fire, got %v (%v)", firing, err)
+	}
+	if !strings.Contains(summary, "grew by 5 to 105") {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+}
+
+func TestPagerDutyNotifierTriggersAndResolves(t *testing.T) {
+	var events []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		events = append(events, event)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	n := NewPagerDutyNotifier("routing-key")
+	n.url = srv.URL
+	ctx := context.Background()
+	alert := Alert{Rule: "queue_age", Status: StatusFiring, Severity: SeverityWarning, Summary: "old", Labels: map[string]string{"namespace": "dw"}}
+	if err := n.Notify(ctx, alert); err != nil {
+		t.Fatalf("trigger: %v", err)
+	}
+	alert.Status = StatusResolved
+	if err := n.Notify(ctx, alert); err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0]["event_action"] != "trigger" || events[1]["event_action"] != "resolve" {
+		t.Fatalf("unexpected actions: %v, %v", events[0]["event_action"], events[1]["event_action"])
+	}
+	if events[0]["dedup_key"] != "dovewarden/dw/queue_age" || events[0]["dedup_key"] != events[1]["dedup_key"] {
+		t.Fatalf("dedup keys must match, got %v and %v", events[0]["dedup_key"], events[1]["dedup_key"])
+	}
+	if events[0]["routing_key"] != "routing-key" {
+		t.Fatalf("unexpected routing key %v", events[0]["routing_key"])
+	}
+}
+
+func TestSMTPNotifierComposesMessage(t *testing.T) {
+	n := NewSMTPNotifier("mail.example.org:587", "dovewarden@example.org", []string{"ops@example.org"}, "", "")
+	var sent string
+	n.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		if addr != "mail.example.org:587" || from != "dovewarden@example.org" || len(to) != 1 {
+			t.Errorf("unexpected envelope %s %s %v", addr, from, to)
+		}
+		sent = string(msg)
+		return nil
+	}
+	if err := n.Notify(context.Background(), Alert{Rule: "queue_age", Status: StatusResolved, Summary: "queue is empty"}); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if !strings.Contains(sent, "Subject: [RESOLVED] dovewarden: queue_age\r\n") || !strings.Contains(sent, "queue is empty") {
+		t.Fatalf("unexpected message:\n%s", sent)
+	}
+}
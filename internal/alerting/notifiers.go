@@ -0,0 +1,152 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// PagerDutyEventsURL is the endpoint of the PagerDuty Events API v2.
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// WebhookNotifier posts every alert as JSON to a URL.
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier posting alerts to url.
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{}}
+}
+
+// Notify implements Notifier.
+func (n *WebhookNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, n.client, n.url, body)
+}
+
+// SMTPNotifier mails every alert to a list of recipients.
+type SMTPNotifier struct {
+	addr     string
+	from     string
+	to       []string
+	auth     smtp.Auth
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTPNotifier creates a notifier sending mail through the SMTP server at
+// addr (host:port). If username is set, PLAIN authentication is used, which
+// net/smtp only allows over TLS or to localhost; STARTTLS is used whenever
+// the server offers it.
+func NewSMTPNotifier(addr, from string, to []string, username, password string) *SMTPNotifier {
+	n := &SMTPNotifier{addr: addr, from: from, to: to, sendMail: smtp.SendMail}
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		n.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return n
+}
+
+// Notify implements Notifier. net/smtp does not take a context, so a slow
+// server is only bounded by its own timeouts.
+func (n *SMTPNotifier) Notify(ctx context.Context, a Alert) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&msg, "Subject: [%s] dovewarden: %s\r\n", strings.ToUpper(a.Status), a.Rule)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n", a.Summary)
+	fmt.Fprintf(&msg, "Rule: %s\r\nSeverity: %s\r\nStarted: %s\r\n", a.Rule, a.Severity, a.StartsAt.Format(time.RFC3339))
+	if a.EndsAt != nil {
+		fmt.Fprintf(&msg, "Resolved: %s\r\n", a.EndsAt.Format(time.RFC3339))
+	}
+	for k, v := range a.Labels {
+		fmt.Fprintf(&msg, "%s: %s\r\n", k, v)
+	}
+	return n.sendMail(n.addr, n.auth, n.from, n.to, msg.Bytes())
+}
+
+// PagerDutyNotifier triggers and resolves PagerDuty incidents through the
+// Events API v2. The dedup key is derived from the rule and the labels, so
+// that a resolve closes the incident its trigger opened.
+type PagerDutyNotifier struct {
+	url        string
+	routingKey string
+	client     *http.Client
+}
+
+// NewPagerDutyNotifier creates a notifier for the integration with the given
+// routing key.
+func NewPagerDutyNotifier(routingKey string) *PagerDutyNotifier {
+	return &PagerDutyNotifier{url: PagerDutyEventsURL, routingKey: routingKey, client: &http.Client{}}
+}
+
+// Notify implements Notifier.
+func (n *PagerDutyNotifier) Notify(ctx context.Context, a Alert) error {
+	source := a.Labels["instance"]
+	if source == "" {
+		source = "dovewarden"
+	}
+	dedupKey := "dovewarden/" + a.Rule
+	if ns := a.Labels["namespace"]; ns != "" {
+		dedupKey = "dovewarden/" + ns + "/" + a.Rule
+	}
+	event := map[string]any{
+		"routing_key": n.routingKey,
+		"dedup_key":   dedupKey,
+	}
+	if a.Status == StatusResolved {
+		event["event_action"] = "resolve"
+	} else {
+		severity := "warning"
+		if a.Severity == SeverityCritical {
+			severity = "critical"
+		}
+		event["event_action"] = "trigger"
+		event["payload"] = map[string]any{
+			"summary":        a.Summary,
+			"source":         source,
+			"severity":       severity,
+			"timestamp":      a.StartsAt.UTC().Format(time.RFC3339),
+			"component":      "dovewarden",
+			"class":          a.Rule,
+			"custom_details": a.Labels,
+		}
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, n.client, n.url, body)
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OldestEnqueued is implemented by queues that know when their longest
+// waiting user was enqueued.
+type OldestEnqueued interface {
+	OldestEnqueuedAt(ctx context.Context) (time.Time, error)
+}
+
+// FailedCounter is implemented by queues that count users whose last sync failed.
+type FailedCounter interface {
+	FailedCount(ctx context.Context) (int64, error)
+}
+
+// QueueAgeRule fires while a user has been waiting in the queue for longer
+// than maxAge.
+func QueueAgeRule(q OldestEnqueued, maxAge time.Duration) Rule {
+	return Rule{
+		Name:     "queue_age",
+		Severity: SeverityWarning,
+		Evaluate: func(ctx context.Context) (bool, string, error) {
+			oldest, err := q.OldestEnqueuedAt(ctx)
+			if err != nil {
+				return false, "", err
+			}
+			if oldest.IsZero() {
+				return false, "queue is empty", nil
+			}
+			age := time.Since(oldest).Truncate(time.Second)
+			return age > maxAge, fmt.Sprintf("oldest queued user has been waiting for %s (threshold %s)", age, maxAge), nil
+		},
+	}
+}
+
+// ConsecutiveFailuresRule fires while at least threshold syncs in a row
+// failed, as reported by count.
+func ConsecutiveFailuresRule(count func() int64, threshold int64) Rule {
+	return Rule{
+		Name:     "consecutive_sync_failures",
+		Severity: SeverityCritical,
+		Evaluate: func(ctx context.Context) (bool, string, error) {
+			n := count()
+			return n >= threshold, fmt.Sprintf("%d consecutive doveadm syncs failed (threshold %d)", n, threshold), nil
+		},
+	}
+}
+
+// FailedGrowthRule fires while the number of users whose last sync failed
+// grew by at least growth within window. Users that keep failing stay in
+// the failed set and are retried, so growth rather than the absolute number
+// signals a new problem.
+func FailedGrowthRule(q FailedCounter, growth int64, window time.Duration) Rule {
+	type sample struct {
+		at    time.Time
+		count int64
+	}
+	var (
+		mu      sync.Mutex
+		samples []sample
+	)
+	return Rule{
+		Name:     "failed_users_growth",
+		Severity: SeverityWarning,
+		Evaluate: func(ctx context.Context) (bool, string, error) {
+			n, err := q.FailedCount(ctx)
+			if err != nil {
+				return false, "", err
+			}
+			now := time.Now()
+
+			mu.Lock()
+			defer mu.Unlock()
+			samples = append(samples, sample{at: now, count: n})
+			for len(samples) > 1 && now.Sub(samples[0].at) > window {
+				samples = samples[1:]
+			}
+			lowest := n
+			for _, s := range samples {
+				if s.count < lowest {
+					lowest = s.count
+				}
+			}
+			delta := n - lowest
+			return delta >= growth, fmt.Sprintf("failed users grew by %d to %d within %s (threshold %d)", delta, n, window, growth), nil
+		},
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"strconv"
 	"time"
@@ -9,48 +10,300 @@ import (
 
 // Config holds application configuration.
 type Config struct {
+	ConfigFile                     string // optional KEY=VALUE file with DOVEWARDEN_* settings, re-read on reload
 	HTTPAddr                       string
 	MetricsAddr                    string
-	RedisMode                      string // "inmemory" or "external"
+	MetricsMaxLabelValues          int    // distinct values kept per unbounded metric label; 0 keeps all
+	RedisMode                      string // "inmemory", "native" or "external"
 	RedisAddr                      string
+	RedisPassword                  string
+	RedisWakeups                   bool // announce enqueues to the consumers sharing the Redis server by pub/sub
 	Namespace                      string
 	NumWorkers                     int
 	DoveadmURL                     string
 	DoveadmPassword                string
-	DoveadmDest                    string // destination for dsync (e.g., "imap")
+	DoveadmPasswordFile            string        // file holding the doveadm password, re-read when it changes
+	CredentialsCheckInterval       time.Duration // how often credential files are checked for changes
+	AdminTokensFile                string        // admin API tokens with their roles; empty leaves only the read-only routes open
+	AdminInsecure                  bool          // without admin tokens, open the operator routes as well
+	DoveadmDest                    string        // destination for dsync (e.g., "imap")
 	LogLevel                       string
+	LogLevels                      string // comma-separated component=level overrides of LogLevel
 	BackgroundReplicationEnabled   bool
 	BackgroundReplicationInterval  time.Duration
 	BackgroundReplicationThreshold time.Duration
+	BackgroundReplicationSpread    bool          // spread the enqueues of a sweep over the interval
+	BackgroundActiveWindow         time.Duration // users with an event within it are active; 0 disables activity thresholds
+	BackgroundActiveThreshold      time.Duration // background threshold of active users
+	BackgroundDormantThreshold     time.Duration // background threshold of all other users
+	BackgroundMaxEnqueuesPerSweep  int           // 0 disables
+	BackgroundMaxEnqueuesPerMinute int           // 0 disables
+	BackgroundPriority             float64       // priority factor of users enqueued by background replication
+	EnqueueBatchSize               int           // max enqueues per Redis pipeline; <2 disables batching
+	EnqueueBatchInterval           time.Duration // max time an enqueue waits for its batch to fill
+	QueueSpillDir                  string        // spills the queue tail to disk beyond QueueMaxInMemory if set
+	QueueMaxInMemory               int
+	QueueMaxDelay                  time.Duration // queued users older than this are promoted to the head; 0 disables aging
+	QueueWaitThreshold             time.Duration // dequeued users that waited longer are counted per priority bucket; 0 disables
+	DeadLetterRedriveInterval      time.Duration // interval of requeuing dead-lettered users; 0 disables
+	FetchMaxBackoff                time.Duration // max wait of the worker pool between polls of an empty queue
+	JobRecoveryAge                 time.Duration // in-flight claims older than this are requeued as abandoned; 0 disables
+	Mirror                         bool          // stream the queue changes to standbys on the admin API
+	StandbyOf                      string        // metrics listener URL of the primary to mirror; empty runs as primary
+	StandbyTokenFile               string        // admin API token of the primary, re-read when it changes
+	StandbyTakeoverAfter           time.Duration // take over once the primary was lost this long; 0 only on request
+	ReadinessDoveadmCheck          bool          // gate /readyz on doveadm API reachability
+	ReadinessDoveadmInterval       time.Duration
+	ReadinessDoveadmFailures       int  // consecutive failed pings before reporting not ready
+	DestinationHealthCheck         bool // hold syncs while the destination is down
+	DestinationHealthInterval      time.Duration
+	DestinationHealthFailures      int           // consecutive failed probes before holding syncs
+	DestinationHealthUser          string        // canary user synced as probe; empty pings the doveadm API
+	DoveadmFallbackDest            string        // destination of syncs while the primary is down; empty disables failover
+	FailoverAfter                  time.Duration // how long syncs are held before failing over
+	DestinationMaxSyncs            string        // comma-separated destination=limit caps of concurrent syncs
+	DoveadmMaxIdleConns            int           // idle doveadm connections kept across all hosts; 0 means no limit
+	DoveadmMaxIdleConnsPerHost     int           // idle doveadm connections kept per host
+	DoveadmMaxConnsPerHost         int           // doveadm connections per host, including active ones; 0 means no limit
+	DoveadmIdleConnTimeout         time.Duration // idle doveadm connections are closed after this long
+	DoveadmHTTP2                   bool          // negotiate HTTP/2 with HTTPS doveadm endpoints
+	DoveadmRoutes                  string        // JSON array of routes of users to other doveadm endpoints
+	BackendURLTemplate             string        // doveadm URL of a user's backend with {host}; empty disables backend lookups
+	BackendCacheTTL                time.Duration // how long resolved backends are cached
+	StateResetAfterFailures        int           // drop the state after this many consecutive failed incremental syncs; 0 disables
+	PurgeDeletedUsers              bool          // delete the data of users doveadm reports as unknown
+	ErrorRules                     string        // JSON array of regex rules classifying sync failures, checked before the built-in ones
+	OverloadCoolDown               time.Duration // pause of all syncs after doveadm reported overload; 0 only delays the failed user
+	OverloadMaxCoolDown            time.Duration // cap of delays requested by Retry-After headers
+	TempFailCoolDownThreshold      int           // temporary failures within 10s that cool down all syncs; 0 disables
+	MailboxSyncConcurrency         int           // changed mailboxes of a user synced in parallel instead of an incremental sync; 0 disables
+	SessionCheck                   bool          // defer syncs of users with active sessions, counted with doveadm who
+	SessionCheckMinConnections     int           // connections from which a user's sync is deferred
+	SessionCheckServices           string        // comma-separated services whose connections count; empty counts all
+	SessionCheckDelay              time.Duration // delay of syncs of users with active sessions
+	SessionCheckMaxDelay           time.Duration // users are synced anyway once deferred this long; 0 defers indefinitely
+	LargeAccountMB                 int64         // accounts of at least this many MB are synced in the large account lane; 0 ignores the size
+	LargeAccountMessages           int64         // accounts with at least this many messages are synced in the large account lane; 0 ignores the count
+	LargeAccountConcurrency        int           // concurrent syncs of large accounts
+	LargeAccountSizeTTL            time.Duration // how long account sizes are cached
+	RedundantSyncWindow            time.Duration // skip syncs of users synced this recently without events since; 0 disables
+	DsyncParams                    string        // JSON object of extra sync request parameters by destination
+	MailboxPriorities              string        // comma-separated mailbox=factor priority modifiers
+	SyncIncludeMailboxes           string        // comma-separated mailbox globs synced on events; empty syncs all
+	SyncExcludeMailboxes           string        // comma-separated mailbox globs or special-use flags not synced on events
+	BackgroundSyncAllMailboxes     bool          // background replication ignores the mailbox globs
+	FirstSeenPriority              float64       // priority factor of users never replicated; <= 1 disables
+	PriorityMin                    float64       // enqueued priority factors below are raised to it; 0 disables
+	PriorityMax                    float64       // enqueued priority factors above are lowered to it; 0 disables
+	IgnoredNamespacePrefixes       string        // comma-separated mailbox prefixes of shared/public namespaces to ignore
+	SelfSessionPrefixes            string        // comma-separated session ID prefixes of our own syncs
+	SelfRemoteIPs                  string        // comma-separated IPs/CIDRs of hosts running our own syncs
+	UserMinSyncInterval            time.Duration // minimum time between two syncs of a user; 0 disables
+	DomainMinSyncIntervals         string        // comma-separated domain=duration overrides of UserMinSyncInterval
+	PrivacyMode                    bool          // log salted username hashes instead of usernames
+	PrivacySalt                    string
+	GRPCHealthAddr                 string // serves grpc.health.v1 if set
+	ErrorReportSentryDSN           string
+	ErrorReportWebhookURL          string
+	ErrorReportEnvironment         string
+	ErrorReportSampleRate          float64
+	ErrorReportDedupWindow         time.Duration
+	ErrorReportFailureThreshold    int // consecutive failed syncs reported as a systemic problem; 0 disables
+	AlertInterval                  time.Duration
+	AlertQueueAge                  time.Duration // alert if a user waits longer; 0 disables
+	AlertConsecutiveFailures       int           // alert after this many failed syncs in a row; 0 disables
+	AlertFailedGrowth              int           // alert if failed users grow by this many within AlertFailedGrowthWindow; 0 disables
+	AlertFailedGrowthWindow        time.Duration
+	AlertWebhookURL                string
+	AlertSMTPAddr                  string // host:port
+	AlertSMTPFrom                  string
+	AlertSMTPTo                    string // comma-separated recipients
+	AlertSMTPUsername              string
+	AlertSMTPPassword              string
+	AlertPagerDutyRoutingKey       string
+	PushgatewayURL                 string // one-shot subcommands push their metrics here on exit if set
+	PushgatewayJob                 string
+	PushgatewayGrouping            string // comma-separated name=value grouping labels
+	LeaderElection                 string // "" (every replica is leader) or "kubernetes"
+	LeaderElectionLeaseName        string
+	LeaderElectionNamespace        string // defaults to the namespace of the service account
+	LeaderElectionLeaseDuration    time.Duration
+	VaultAddr                      string // fetches secrets from Vault if set
+	VaultNamespace                 string
+	VaultAuthMethod                string // approle or kubernetes
+	VaultAuthMount                 string
+	VaultRoleID                    string
+	VaultSecretIDFile              string
+	VaultRole                      string
+	VaultTokenFile                 string
+	VaultSecretPath                string
+	ReusePort                      bool          // bind listeners with SO_REUSEPORT so a new process can take over during restarts
+	LogSamplingFirst               int           // warnings/errors of one message passed per interval; 0 disables sampling
+	LogSamplingInterval            time.Duration // interval after which suppressed records are summarized
+	HistorySize                    int           // sync attempts kept per user; 0 disables the history
+	SlowSyncThreshold              time.Duration // syncs taking longer are reported as slow; 0 disables
+	SlowSyncBufferSize             int           // slow syncs kept for GET /admin/slow-syncs
+	DashboardHistory               time.Duration // queue depth history kept for the dashboard; 0 disables
+	RecentSyncs                    int           // syncs kept for GET /admin/syncs/recent; 0 disables
+	AccessLog                      bool          // log every HTTP request of both listeners
+	AccessLogSampleRate            float64       // share of successful requests logged
+	AccessLogExcludePaths          string        // comma-separated paths logged only when they fail
+	RetryBudgetRatio               float64       // immediate retries allowed per fresh attempt; 0 disables the budget
+	RetryBudgetMinRetries          int           // immediate retries always allowed per window
+	RetryBudgetWindow              time.Duration // sliding window of the retry budget
+	RetryBudgetDelay               time.Duration // delay of retries beyond the budget
+	RetryPolicies                  string        // comma-separated origin=policy pairs replacing the immediate retry per origin
+	DoveadmRateLimit               float64       // syncs started per second across all replicas; 0 disables
+	DoveadmRateLimitBurst          int           // syncs started at once beyond the rate limit
+	DoveadmRateLimitRedisURL       string        // Redis shared by the replicas for the rate limit; empty limits each replica alone
+	DoveadmRateLimitFallback       float64       // syncs started per second by a replica while the shared Redis fails; 0 uses the rate limit
+	DryRun                         bool          // log syncs instead of calling doveadm
+	PostSyncCommand                string        // program run after every sync; empty disables
+	PostSyncCommandTimeout         time.Duration
+	EventsAllowedIPs               string // comma-separated IPs/CIDRs allowed to post events; empty allows all
+	EventsTLSCert                  string // certificate of the events listener; enables TLS
+	EventsTLSKey                   string
+	EventsTLSClientCA              string // CA verifying client certificates; enables mutual TLS
+	EventsTLSAllowedClients        string // comma-separated client certificate DNS SANs or CNs; empty allows any
+
+	fileValues map[string]string // settings read from ConfigFile
 }
 
-// Load reads configuration from environment and command-line flags.
-func Load() *Config {
-	cfg := &Config{
+// defaults returns the configuration used when neither flags, environment nor
+// the config file set a value.
+func defaults() *Config {
+	return &Config{
 		HTTPAddr:                       ":8080",
 		MetricsAddr:                    ":9090",
+		MetricsMaxLabelValues:          100,
 		RedisMode:                      "inmemory",
 		RedisAddr:                      "localhost:6379",
 		Namespace:                      "dovewarden",
 		NumWorkers:                     4,
 		DoveadmURL:                     "http://localhost:8080",
 		DoveadmPassword:                "",
+		CredentialsCheckInterval:       30 * time.Second,
+		LeaderElectionLeaseName:        "dovewarden",
+		PushgatewayJob:                 "dovewarden",
+		ErrorReportSampleRate:          1,
+		ErrorReportDedupWindow:         10 * time.Minute,
+		ErrorReportFailureThreshold:    20,
+		AlertInterval:                  30 * time.Second,
+		AlertQueueAge:                  30 * time.Minute,
+		AlertConsecutiveFailures:       10,
+		AlertFailedGrowth:              50,
+		AlertFailedGrowthWindow:        15 * time.Minute,
+		LeaderElectionLeaseDuration:    15 * time.Second,
+		VaultAuthMethod:                "kubernetes",
+		VaultTokenFile:                 "/var/run/secrets/kubernetes.io/serviceaccount/token",
 		DoveadmDest:                    "imap",
 		LogLevel:                       "info",
 		BackgroundReplicationEnabled:   true,
 		BackgroundReplicationInterval:  time.Hour,
 		BackgroundReplicationThreshold: 24 * time.Hour,
+		BackgroundReplicationSpread:    true,
+		BackgroundActiveThreshold:      6 * time.Hour,
+		BackgroundDormantThreshold:     7 * 24 * time.Hour,
+		BackgroundPriority:             0.5,
+		EnqueueBatchSize:               0,
+		EnqueueBatchInterval:           5 * time.Millisecond,
+		QueueMaxInMemory:               100000,
+		QueueMaxDelay:                  time.Hour,
+		QueueWaitThreshold:             15 * time.Minute,
+		FetchMaxBackoff:                time.Second,
+		JobRecoveryAge:                 time.Hour,
+		ReadinessDoveadmCheck:          false,
+		ReadinessDoveadmInterval:       10 * time.Second,
+		ReadinessDoveadmFailures:       3,
+		DestinationHealthCheck:         false,
+		DestinationHealthInterval:      10 * time.Second,
+		DestinationHealthFailures:      3,
+		FailoverAfter:                  5 * time.Minute,
+		BackendCacheTTL:                5 * time.Minute,
+		DoveadmMaxIdleConns:            100,
+		DoveadmMaxIdleConnsPerHost:     32,
+		DoveadmIdleConnTimeout:         90 * time.Second,
+		StateResetAfterFailures:        3,
+		PurgeDeletedUsers:              true,
+		SessionCheckMinConnections:     1,
+		SessionCheckDelay:              5 * time.Minute,
+		SessionCheckMaxDelay:           time.Hour,
+		LargeAccountConcurrency:        1,
+		LargeAccountSizeTTL:            24 * time.Hour,
+		OverloadCoolDown:               30 * time.Second,
+		OverloadMaxCoolDown:            5 * time.Minute,
+		TempFailCoolDownThreshold:      20,
+		MailboxPriorities:              "INBOX=2,Sent=2,Trash=0.5,Junk=0.5",
+		FirstSeenPriority:              2,
+		PriorityMin:                    0.01,
+		PriorityMax:                    100,
+		IgnoredNamespacePrefixes:       "Shared/,Public/",
+		LogSamplingFirst:               10,
+		LogSamplingInterval:            time.Minute,
+		HistorySize:                    20,
+		SlowSyncThreshold:              5 * time.Minute,
+		SlowSyncBufferSize:             100,
+		DashboardHistory:               time.Hour,
+		RecentSyncs:                    100,
+		AccessLogSampleRate:            1,
+		AccessLogExcludePaths:          "/metrics,/healthz,/readyz",
+		RetryBudgetRatio:               0.2,
+		RetryBudgetMinRetries:          10,
+		RetryBudgetWindow:              time.Minute,
+		RetryBudgetDelay:               time.Minute,
+		DoveadmRateLimitBurst:          10,
+		PostSyncCommandTimeout:         10 * time.Second,
+	}
+}
+
+// Load reads configuration from environment, the optional config file and
+// command-line flags, in decreasing order of precedence: flags, environment,
+// config file.
+func Load() *Config {
+	cfg := defaults()
+	cfg.ConfigFile = configFilePath()
+	if cfg.ConfigFile != "" {
+		values, err := readConfigFile(cfg.ConfigFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read config file: %v\n", err)
+			os.Exit(1)
+		}
+		fileValues = values
+		cfg.fileValues = values
 	}
 
+	flag.StringVar(&cfg.ConfigFile, "config-file", cfg.ConfigFile, "Config file with DOVEWARDEN_* settings as KEY=VALUE lines, re-read on SIGHUP")
+
 	flag.StringVar(&cfg.HTTPAddr, "http-addr", envOrDefault("DOVEWARDEN_HTTP_ADDR", cfg.HTTPAddr), "HTTP server listen address for events")
 	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", envOrDefault("DOVEWARDEN_METRICS_ADDR", cfg.MetricsAddr), "HTTP server listen address for Prometheus metrics")
-	flag.StringVar(&cfg.RedisMode, "redis-mode", envOrDefault("DOVEWARDEN_REDIS_MODE", cfg.RedisMode), "Redis mode: inmemory or external")
+	metricsMaxLabelValuesStr := envOrDefault("DOVEWARDEN_METRICS_MAX_LABEL_VALUES", "100")
+	if n, err := strconv.Atoi(metricsMaxLabelValuesStr); err == nil && n >= 0 {
+		cfg.MetricsMaxLabelValues = n
+	}
+	flag.IntVar(&cfg.MetricsMaxLabelValues, "metrics-max-label-values", cfg.MetricsMaxLabelValues, "Distinct values kept per unbounded metric label, e.g. destination; further values are exported as \"other\" (0 keeps all)")
+	flag.StringVar(&cfg.RedisMode, "redis-mode", envOrDefault("DOVEWARDEN_REDIS_MODE", cfg.RedisMode), "Redis mode: inmemory, native or external")
 	flag.StringVar(&cfg.RedisAddr, "redis-addr", envOrDefault("DOVEWARDEN_REDIS_ADDR", cfg.RedisAddr), "Redis address for external mode")
+	flag.StringVar(&cfg.RedisPassword, "redis-password", envOrDefault("DOVEWARDEN_REDIS_PASSWORD", cfg.RedisPassword), "Redis password for external mode")
+	redisWakeupsStr := envOrDefault("DOVEWARDEN_REDIS_WAKEUPS", "false")
+	cfg.RedisWakeups = redisWakeupsStr == "true" || redisWakeupsStr == "1"
+	flag.BoolVar(&cfg.RedisWakeups, "redis-wakeups", cfg.RedisWakeups, "Announce enqueues on a Redis pub/sub channel, waking idle consumers sharing the Redis server right away")
 	flag.StringVar(&cfg.Namespace, "namespace", envOrDefault("DOVEWARDEN_NAMESPACE", cfg.Namespace), "Key namespace prefix")
 	flag.StringVar(&cfg.DoveadmURL, "doveadm-url", envOrDefault("DOVEWARDEN_DOVEADM_URL", cfg.DoveadmURL), "Doveadm API base URL")
 	flag.StringVar(&cfg.DoveadmPassword, "doveadm-password", envOrDefault("DOVEWARDEN_DOVEADM_PASSWORD", cfg.DoveadmPassword), "Doveadm API password")
+	flag.StringVar(&cfg.DoveadmPasswordFile, "doveadm-password-file", envOrDefault("DOVEWARDEN_DOVEADM_PASSWORD_FILE", cfg.DoveadmPasswordFile), "File holding the doveadm API password, re-read when it changes; takes precedence over --doveadm-password")
+
+	credentialsCheckIntervalStr := envOrDefault("DOVEWARDEN_CREDENTIALS_CHECK_INTERVAL", "30s")
+	if interval, err := time.ParseDuration(credentialsCheckIntervalStr); err == nil && interval > 0 {
+		cfg.CredentialsCheckInterval = interval
+	}
+	flag.StringVar(&cfg.AdminTokensFile, "admin-tokens-file", envOrDefault("DOVEWARDEN_ADMIN_TOKENS_FILE", cfg.AdminTokensFile), "File of '<name> <role> <token>' lines authorizing admin API requests, re-read when it changes (empty leaves only the read-only routes open)")
+	adminInsecureStr := envOrDefault("DOVEWARDEN_ADMIN_INSECURE", "false")
+	cfg.AdminInsecure = adminInsecureStr == "true" || adminInsecureStr == "1"
+	flag.BoolVar(&cfg.AdminInsecure, "admin-insecure", cfg.AdminInsecure, "Without an admin tokens file, let anyone call the destructive admin routes as well")
+	flag.DurationVar(&cfg.CredentialsCheckInterval, "credentials-check-interval", cfg.CredentialsCheckInterval, "How often credential files are checked for changes")
 	flag.StringVar(&cfg.DoveadmDest, "doveadm-dest", envOrDefault("DOVEWARDEN_DOVEADM_DEST", cfg.DoveadmDest), "Doveadm dsync destination")
 	flag.StringVar(&cfg.LogLevel, "log-level", envOrDefault("DOVEWARDEN_LOG_LEVEL", cfg.LogLevel), "Log level: debug, info, warn, error")
+	flag.StringVar(&cfg.LogLevels, "log-levels", envOrDefault("DOVEWARDEN_LOG_LEVELS", cfg.LogLevels), "Comma-separated component=level overrides of the log level, e.g. queue=debug,server=warn")
 
 	// Parse NumWorkers from environment or flag
 	numWorkersStr := envOrDefault("DOVEWARDEN_NUM_WORKERS", "4")
@@ -76,14 +329,541 @@ func Load() *Config {
 	}
 	flag.DurationVar(&cfg.BackgroundReplicationThreshold, "background-replication-threshold", cfg.BackgroundReplicationThreshold, "Background replication threshold - users replicated within this time are skipped")
 
+	backgroundReplicationSpreadStr := envOrDefault("DOVEWARDEN_BACKGROUND_REPLICATION_SPREAD", "true")
+	cfg.BackgroundReplicationSpread = backgroundReplicationSpreadStr == "true" || backgroundReplicationSpreadStr == "1"
+	flag.BoolVar(&cfg.BackgroundReplicationSpread, "background-replication-spread", cfg.BackgroundReplicationSpread, "Spread the enqueues of a background replication sweep evenly over the interval")
+
+	backgroundActiveWindowStr := envOrDefault("DOVEWARDEN_BACKGROUND_ACTIVE_WINDOW", "0")
+	if window, err := time.ParseDuration(backgroundActiveWindowStr); err == nil && window >= 0 {
+		cfg.BackgroundActiveWindow = window
+	}
+	flag.DurationVar(&cfg.BackgroundActiveWindow, "background-active-window", cfg.BackgroundActiveWindow, "Users with an event within this window get the active background threshold, all others the dormant one (0 disables)")
+	backgroundActiveThresholdStr := envOrDefault("DOVEWARDEN_BACKGROUND_ACTIVE_THRESHOLD", "6h")
+	if threshold, err := time.ParseDuration(backgroundActiveThresholdStr); err == nil && threshold > 0 {
+		cfg.BackgroundActiveThreshold = threshold
+	}
+	flag.DurationVar(&cfg.BackgroundActiveThreshold, "background-active-threshold", cfg.BackgroundActiveThreshold, "Background replication threshold of active users")
+	backgroundDormantThresholdStr := envOrDefault("DOVEWARDEN_BACKGROUND_DORMANT_THRESHOLD", "168h")
+	if threshold, err := time.ParseDuration(backgroundDormantThresholdStr); err == nil && threshold > 0 {
+		cfg.BackgroundDormantThreshold = threshold
+	}
+	flag.DurationVar(&cfg.BackgroundDormantThreshold, "background-dormant-threshold", cfg.BackgroundDormantThreshold, "Background replication threshold of dormant users")
+
+	backgroundMaxEnqueuesPerSweepStr := envOrDefault("DOVEWARDEN_BACKGROUND_MAX_ENQUEUES_PER_SWEEP", "0")
+	if n, err := strconv.Atoi(backgroundMaxEnqueuesPerSweepStr); err == nil && n >= 0 {
+		cfg.BackgroundMaxEnqueuesPerSweep = n
+	}
+	flag.IntVar(&cfg.BackgroundMaxEnqueuesPerSweep, "background-max-enqueues-per-sweep", cfg.BackgroundMaxEnqueuesPerSweep, "Maximum number of users enqueued by one background replication sweep; the next sweep continues (0 disables)")
+	backgroundMaxEnqueuesPerMinuteStr := envOrDefault("DOVEWARDEN_BACKGROUND_MAX_ENQUEUES_PER_MINUTE", "0")
+	if n, err := strconv.Atoi(backgroundMaxEnqueuesPerMinuteStr); err == nil && n >= 0 {
+		cfg.BackgroundMaxEnqueuesPerMinute = n
+	}
+	flag.IntVar(&cfg.BackgroundMaxEnqueuesPerMinute, "background-max-enqueues-per-minute", cfg.BackgroundMaxEnqueuesPerMinute, "Maximum number of users enqueued by background replication per minute (0 disables)")
+	backgroundPriorityStr := envOrDefault("DOVEWARDEN_BACKGROUND_PRIORITY", "0.5")
+	if factor, err := strconv.ParseFloat(backgroundPriorityStr, 64); err == nil && factor > 0 {
+		cfg.BackgroundPriority = factor
+	}
+	flag.Float64Var(&cfg.BackgroundPriority, "background-priority", cfg.BackgroundPriority, "Priority factor of users enqueued by background replication")
+
+	// Parse enqueue batching settings
+	enqueueBatchSizeStr := envOrDefault("DOVEWARDEN_ENQUEUE_BATCH_SIZE", "0")
+	if size, err := strconv.Atoi(enqueueBatchSizeStr); err == nil && size >= 0 {
+		cfg.EnqueueBatchSize = size
+	}
+	flag.IntVar(&cfg.EnqueueBatchSize, "enqueue-batch-size", cfg.EnqueueBatchSize, "Maximum number of enqueues written in one Redis pipeline (0 or 1 disables batching)")
+
+	enqueueBatchIntervalStr := envOrDefault("DOVEWARDEN_ENQUEUE_BATCH_INTERVAL", "5ms")
+	if interval, err := time.ParseDuration(enqueueBatchIntervalStr); err == nil && interval > 0 {
+		cfg.EnqueueBatchInterval = interval
+	}
+	flag.DurationVar(&cfg.EnqueueBatchInterval, "enqueue-batch-interval", cfg.EnqueueBatchInterval, "Maximum time an enqueue waits for its batch to be flushed")
+
+	flag.StringVar(&cfg.QueueSpillDir, "queue-spill-dir", envOrDefault("DOVEWARDEN_QUEUE_SPILL_DIR", cfg.QueueSpillDir), "Directory the lowest-priority queued users are spilled to beyond the in-memory limit (empty disables)")
+	queueMaxInMemoryStr := envOrDefault("DOVEWARDEN_QUEUE_MAX_IN_MEMORY", "100000")
+	if n, err := strconv.Atoi(queueMaxInMemoryStr); err == nil && n > 0 {
+		cfg.QueueMaxInMemory = n
+	}
+	flag.IntVar(&cfg.QueueMaxInMemory, "queue-max-in-memory", cfg.QueueMaxInMemory, "Maximum number of queued users kept in memory when spilling is enabled")
+
+	queueMaxDelayStr := envOrDefault("DOVEWARDEN_QUEUE_MAX_DELAY", "1h")
+	if maxDelay, err := time.ParseDuration(queueMaxDelayStr); err == nil && maxDelay >= 0 {
+		cfg.QueueMaxDelay = maxDelay
+	}
+	flag.DurationVar(&cfg.QueueMaxDelay, "queue-max-delay", cfg.QueueMaxDelay, "Maximum time a user may wait in the queue before being promoted to the head (0 disables aging)")
+
+	queueWaitThresholdStr := envOrDefault("DOVEWARDEN_QUEUE_WAIT_THRESHOLD", "15m")
+	if d, err := time.ParseDuration(queueWaitThresholdStr); err == nil && d >= 0 {
+		cfg.QueueWaitThreshold = d
+	}
+	flag.DurationVar(&cfg.QueueWaitThreshold, "queue-wait-threshold", cfg.QueueWaitThreshold, "Dequeued users that waited longer are counted per priority bucket (0 disables)")
+
+	deadLetterRedriveIntervalStr := envOrDefault("DOVEWARDEN_DEADLETTER_REDRIVE_INTERVAL", "0")
+	if d, err := time.ParseDuration(deadLetterRedriveIntervalStr); err == nil && d >= 0 {
+		cfg.DeadLetterRedriveInterval = d
+	}
+	flag.DurationVar(&cfg.DeadLetterRedriveInterval, "deadletter-redrive-interval", cfg.DeadLetterRedriveInterval, "Interval at which failed users without a pending retry are queued again (0 disables)")
+
+	fetchMaxBackoffStr := envOrDefault("DOVEWARDEN_FETCH_MAX_BACKOFF", "1s")
+	if backoff, err := time.ParseDuration(fetchMaxBackoffStr); err == nil && backoff > 0 {
+		cfg.FetchMaxBackoff = backoff
+	}
+	flag.DurationVar(&cfg.FetchMaxBackoff, "fetch-max-backoff", cfg.FetchMaxBackoff, "Maximum wait of the worker pool between polls of an empty or failing queue")
+
+	jobRecoveryAgeStr := envOrDefault("DOVEWARDEN_JOB_RECOVERY_AGE", "1h")
+	if d, err := time.ParseDuration(jobRecoveryAgeStr); err == nil && d >= 0 {
+		cfg.JobRecoveryAge = d
+	}
+	flag.DurationVar(&cfg.JobRecoveryAge, "job-recovery-age", cfg.JobRecoveryAge, "Requeue users claimed by a worker longer ago than this, as their sync was abandoned (0 disables)")
+
+	mirrorStr := envOrDefault("DOVEWARDEN_MIRROR", "false")
+	cfg.Mirror = mirrorStr == "true" || mirrorStr == "1"
+	flag.BoolVar(&cfg.Mirror, "mirror", cfg.Mirror, "Stream the queue changes to standbys on the admin API")
+	flag.StringVar(&cfg.StandbyOf, "standby-of", envOrDefault("DOVEWARDEN_STANDBY_OF", cfg.StandbyOf), "Run as warm standby mirroring the queue of the primary whose metrics listener is at this URL, e.g. http://primary:9090")
+	flag.StringVar(&cfg.StandbyTokenFile, "standby-token-file", envOrDefault("DOVEWARDEN_STANDBY_TOKEN_FILE", cfg.StandbyTokenFile), "File holding the admin API token of the primary, re-read when it changes")
+
+	standbyTakeoverAfterStr := envOrDefault("DOVEWARDEN_STANDBY_TAKEOVER_AFTER", "0")
+	if d, err := time.ParseDuration(standbyTakeoverAfterStr); err == nil && d >= 0 {
+		cfg.StandbyTakeoverAfter = d
+	}
+	flag.DurationVar(&cfg.StandbyTakeoverAfter, "standby-takeover-after", cfg.StandbyTakeoverAfter, "Take over once the primary was unreachable this long (0 only on request)")
+
+	// Parse doveadm readiness gating settings
+	readinessDoveadmCheckStr := envOrDefault("DOVEWARDEN_READINESS_DOVEADM_CHECK", "false")
+	cfg.ReadinessDoveadmCheck = readinessDoveadmCheckStr == "true" || readinessDoveadmCheckStr == "1"
+	flag.BoolVar(&cfg.ReadinessDoveadmCheck, "readiness-doveadm-check", cfg.ReadinessDoveadmCheck, "Report not ready while the doveadm API is unreachable")
+
+	readinessDoveadmIntervalStr := envOrDefault("DOVEWARDEN_READINESS_DOVEADM_INTERVAL", "10s")
+	if interval, err := time.ParseDuration(readinessDoveadmIntervalStr); err == nil && interval > 0 {
+		cfg.ReadinessDoveadmInterval = interval
+	}
+	flag.DurationVar(&cfg.ReadinessDoveadmInterval, "readiness-doveadm-interval", cfg.ReadinessDoveadmInterval, "Interval between doveadm API reachability probes")
+
+	readinessDoveadmFailuresStr := envOrDefault("DOVEWARDEN_READINESS_DOVEADM_FAILURES", "3")
+	if n, err := strconv.Atoi(readinessDoveadmFailuresStr); err == nil && n > 0 {
+		cfg.ReadinessDoveadmFailures = n
+	}
+	flag.IntVar(&cfg.ReadinessDoveadmFailures, "readiness-doveadm-failures", cfg.ReadinessDoveadmFailures, "Consecutive failed doveadm probes before reporting not ready")
+
+	// Parse destination health monitoring settings
+	destinationHealthCheckStr := envOrDefault("DOVEWARDEN_DESTINATION_HEALTH_CHECK", "false")
+	cfg.DestinationHealthCheck = destinationHealthCheckStr == "true" || destinationHealthCheckStr == "1"
+	flag.BoolVar(&cfg.DestinationHealthCheck, "destination-health-check", cfg.DestinationHealthCheck, "Hold syncs while the destination is down")
+
+	destinationHealthIntervalStr := envOrDefault("DOVEWARDEN_DESTINATION_HEALTH_INTERVAL", "10s")
+	if interval, err := time.ParseDuration(destinationHealthIntervalStr); err == nil && interval > 0 {
+		cfg.DestinationHealthInterval = interval
+	}
+	flag.DurationVar(&cfg.DestinationHealthInterval, "destination-health-interval", cfg.DestinationHealthInterval, "Interval between destination health probes")
+
+	destinationHealthFailuresStr := envOrDefault("DOVEWARDEN_DESTINATION_HEALTH_FAILURES", "3")
+	if n, err := strconv.Atoi(destinationHealthFailuresStr); err == nil && n > 0 {
+		cfg.DestinationHealthFailures = n
+	}
+	flag.IntVar(&cfg.DestinationHealthFailures, "destination-health-failures", cfg.DestinationHealthFailures, "Consecutive failed destination probes before holding syncs")
+
+	flag.StringVar(&cfg.DestinationHealthUser, "destination-health-user", envOrDefault("DOVEWARDEN_DESTINATION_HEALTH_USER", cfg.DestinationHealthUser), "Canary user synced to probe the destination; empty pings the doveadm API")
+
+	flag.StringVar(&cfg.DoveadmFallbackDest, "doveadm-fallback-dest", envOrDefault("DOVEWARDEN_DOVEADM_FALLBACK_DEST", cfg.DoveadmFallbackDest), "Dsync destination of syncs while the primary destination is down; requires the destination health check")
+
+	failoverAfterStr := envOrDefault("DOVEWARDEN_FAILOVER_AFTER", "5m")
+	if d, err := time.ParseDuration(failoverAfterStr); err == nil && d >= 0 {
+		cfg.FailoverAfter = d
+	}
+	flag.DurationVar(&cfg.FailoverAfter, "failover-after", cfg.FailoverAfter, "How long syncs are held while the destination is down before failing over to the fallback destination")
+
+	flag.StringVar(&cfg.DestinationMaxSyncs, "destination-max-syncs", envOrDefault("DOVEWARDEN_DESTINATION_MAX_SYNCS", cfg.DestinationMaxSyncs), "Comma-separated destination=limit caps of concurrent syncs per destination")
+
+	// Parse doveadm connection pooling settings
+	doveadmMaxIdleConnsStr := envOrDefault("DOVEWARDEN_DOVEADM_MAX_IDLE_CONNS", "100")
+	if n, err := strconv.Atoi(doveadmMaxIdleConnsStr); err == nil && n >= 0 {
+		cfg.DoveadmMaxIdleConns = n
+	}
+	flag.IntVar(&cfg.DoveadmMaxIdleConns, "doveadm-max-idle-conns", cfg.DoveadmMaxIdleConns, "Idle doveadm API connections kept across all hosts; 0 means no limit")
+
+	doveadmMaxIdleConnsPerHostStr := envOrDefault("DOVEWARDEN_DOVEADM_MAX_IDLE_CONNS_PER_HOST", "32")
+	if n, err := strconv.Atoi(doveadmMaxIdleConnsPerHostStr); err == nil && n > 0 {
+		cfg.DoveadmMaxIdleConnsPerHost = n
+	}
+	flag.IntVar(&cfg.DoveadmMaxIdleConnsPerHost, "doveadm-max-idle-conns-per-host", cfg.DoveadmMaxIdleConnsPerHost, "Idle doveadm API connections kept per host")
+
+	doveadmMaxConnsPerHostStr := envOrDefault("DOVEWARDEN_DOVEADM_MAX_CONNS_PER_HOST", "0")
+	if n, err := strconv.Atoi(doveadmMaxConnsPerHostStr); err == nil && n >= 0 {
+		cfg.DoveadmMaxConnsPerHost = n
+	}
+	flag.IntVar(&cfg.DoveadmMaxConnsPerHost, "doveadm-max-conns-per-host", cfg.DoveadmMaxConnsPerHost, "Doveadm API connections per host, including active ones; 0 means no limit")
+
+	doveadmIdleConnTimeoutStr := envOrDefault("DOVEWARDEN_DOVEADM_IDLE_CONN_TIMEOUT", "90s")
+	if d, err := time.ParseDuration(doveadmIdleConnTimeoutStr); err == nil && d >= 0 {
+		cfg.DoveadmIdleConnTimeout = d
+	}
+	flag.DurationVar(&cfg.DoveadmIdleConnTimeout, "doveadm-idle-conn-timeout", cfg.DoveadmIdleConnTimeout, "Idle doveadm API connections are closed after this long; 0 means never")
+
+	doveadmHTTP2Str := envOrDefault("DOVEWARDEN_DOVEADM_HTTP2", "false")
+	cfg.DoveadmHTTP2 = doveadmHTTP2Str == "true" || doveadmHTTP2Str == "1"
+	flag.BoolVar(&cfg.DoveadmHTTP2, "doveadm-http2", cfg.DoveadmHTTP2, "Negotiate HTTP/2 with HTTPS doveadm API endpoints")
+
+	flag.StringVar(&cfg.DoveadmRoutes, "doveadm-routes", envOrDefault("DOVEWARDEN_DOVEADM_ROUTES", cfg.DoveadmRoutes), "JSON array of routes sending the syncs of users matched by domain or pattern to other doveadm endpoints")
+
+	flag.StringVar(&cfg.ErrorRules, "error-rules", envOrDefault("DOVEWARDEN_ERROR_RULES", cfg.ErrorRules), "JSON array of rules classifying sync failures whose message matches a regular expression")
+
+	overloadCoolDownStr := envOrDefault("DOVEWARDEN_OVERLOAD_COOLDOWN", "30s")
+	if d, err := time.ParseDuration(overloadCoolDownStr); err == nil && d >= 0 {
+		cfg.OverloadCoolDown = d
+	}
+	flag.DurationVar(&cfg.OverloadCoolDown, "overload-cooldown", cfg.OverloadCoolDown, "Pause of all syncs after doveadm reported overload without a Retry-After header (0 only delays the failed user)")
+
+	overloadMaxCoolDownStr := envOrDefault("DOVEWARDEN_OVERLOAD_MAX_COOLDOWN", "5m")
+	if d, err := time.ParseDuration(overloadMaxCoolDownStr); err == nil && d > 0 {
+		cfg.OverloadMaxCoolDown = d
+	}
+	flag.DurationVar(&cfg.OverloadMaxCoolDown, "overload-max-cooldown", cfg.OverloadMaxCoolDown, "Maximum delay honored from a Retry-After header")
+
+	tempFailCoolDownThresholdStr := envOrDefault("DOVEWARDEN_TEMPFAIL_COOLDOWN_THRESHOLD", "20")
+	if n, err := strconv.Atoi(tempFailCoolDownThresholdStr); err == nil && n >= 0 {
+		cfg.TempFailCoolDownThreshold = n
+	}
+	flag.IntVar(&cfg.TempFailCoolDownThreshold, "tempfail-cooldown-threshold", cfg.TempFailCoolDownThreshold, "Temporary sync failures within 10 seconds that pause all syncs for the overload cool-down (0 disables)")
+
+	// Parse backend resolution settings for proxied clusters
+	flag.StringVar(&cfg.BackendURLTemplate, "backend-url-template", envOrDefault("DOVEWARDEN_BACKEND_URL_TEMPLATE", cfg.BackendURLTemplate), "Doveadm API URL of a user's backend, with {host} replaced by the passdb host field; empty syncs all users via the doveadm URL")
+
+	backendCacheTTLStr := envOrDefault("DOVEWARDEN_BACKEND_CACHE_TTL", "5m")
+	if ttl, err := time.ParseDuration(backendCacheTTLStr); err == nil && ttl >= 0 {
+		cfg.BackendCacheTTL = ttl
+	}
+	flag.DurationVar(&cfg.BackendCacheTTL, "backend-cache-ttl", cfg.BackendCacheTTL, "How long the resolved backend of a user is cached")
+
+	stateResetAfterFailuresStr := envOrDefault("DOVEWARDEN_STATE_RESET_AFTER_FAILURES", "3")
+	if n, err := strconv.Atoi(stateResetAfterFailuresStr); err == nil && n >= 0 {
+		cfg.StateResetAfterFailures = n
+	}
+	flag.IntVar(&cfg.StateResetAfterFailures, "state-reset-after-failures", cfg.StateResetAfterFailures, "Drop the replication state after this many consecutive failed incremental syncs (0 disables)")
+
+	flag.StringVar(&cfg.DsyncParams, "dsync-params", envOrDefault("DOVEWARDEN_DSYNC_PARAMS", cfg.DsyncParams), "JSON object of extra parameters merged into the sync requests by destination, e.g. {\"imap\": {\"lockTimeout\": 30}}")
+
+	purgeDeletedUsersStr := envOrDefault("DOVEWARDEN_PURGE_DELETED_USERS", "true")
+	cfg.PurgeDeletedUsers = purgeDeletedUsersStr == "true" || purgeDeletedUsersStr == "1"
+	flag.BoolVar(&cfg.PurgeDeletedUsers, "purge-deleted-users", cfg.PurgeDeletedUsers, "Delete the queue entries, state and history of users doveadm reports as unknown")
+
+	mailboxSyncConcurrencyStr := envOrDefault("DOVEWARDEN_MAILBOX_SYNC_CONCURRENCY", "0")
+	if n, err := strconv.Atoi(mailboxSyncConcurrencyStr); err == nil && n >= 0 {
+		cfg.MailboxSyncConcurrency = n
+	}
+	flag.IntVar(&cfg.MailboxSyncConcurrency, "mailbox-sync-concurrency", cfg.MailboxSyncConcurrency, "Changed mailboxes of a user synced in parallel instead of an incremental account sync (0 disables)")
+
+	sessionCheckStr := envOrDefault("DOVEWARDEN_SESSION_CHECK", "false")
+	cfg.SessionCheck = sessionCheckStr == "true" || sessionCheckStr == "1"
+	flag.BoolVar(&cfg.SessionCheck, "session-check", cfg.SessionCheck, "Defer syncs of users with active sessions, counted with doveadm who")
+
+	sessionCheckMinConnectionsStr := envOrDefault("DOVEWARDEN_SESSION_CHECK_MIN_CONNECTIONS", "1")
+	if n, err := strconv.Atoi(sessionCheckMinConnectionsStr); err == nil && n > 0 {
+		cfg.SessionCheckMinConnections = n
+	}
+	flag.IntVar(&cfg.SessionCheckMinConnections, "session-check-min-connections", cfg.SessionCheckMinConnections, "Connections from which the sync of a user is deferred")
+
+	flag.StringVar(&cfg.SessionCheckServices, "session-check-services", envOrDefault("DOVEWARDEN_SESSION_CHECK_SERVICES", cfg.SessionCheckServices), "Comma-separated services whose connections count, e.g. imap,pop3; empty counts all")
+
+	sessionCheckDelayStr := envOrDefault("DOVEWARDEN_SESSION_CHECK_DELAY", "5m")
+	if d, err := time.ParseDuration(sessionCheckDelayStr); err == nil && d > 0 {
+		cfg.SessionCheckDelay = d
+	}
+	flag.DurationVar(&cfg.SessionCheckDelay, "session-check-delay", cfg.SessionCheckDelay, "Delay of syncs of users with active sessions")
+
+	sessionCheckMaxDelayStr := envOrDefault("DOVEWARDEN_SESSION_CHECK_MAX_DELAY", "1h")
+	if d, err := time.ParseDuration(sessionCheckMaxDelayStr); err == nil && d >= 0 {
+		cfg.SessionCheckMaxDelay = d
+	}
+	flag.DurationVar(&cfg.SessionCheckMaxDelay, "session-check-max-delay", cfg.SessionCheckMaxDelay, "Sync users with active sessions anyway once deferred this long (0 defers indefinitely)")
+
+	largeAccountMBStr := envOrDefault("DOVEWARDEN_LARGE_ACCOUNT_MB", "0")
+	if n, err := strconv.ParseInt(largeAccountMBStr, 10, 64); err == nil && n >= 0 {
+		cfg.LargeAccountMB = n
+	}
+	flag.Int64Var(&cfg.LargeAccountMB, "large-account-mb", cfg.LargeAccountMB, "Sync accounts of at least this many MB in the large account lane (0 ignores the size)")
+
+	largeAccountMessagesStr := envOrDefault("DOVEWARDEN_LARGE_ACCOUNT_MESSAGES", "0")
+	if n, err := strconv.ParseInt(largeAccountMessagesStr, 10, 64); err == nil && n >= 0 {
+		cfg.LargeAccountMessages = n
+	}
+	flag.Int64Var(&cfg.LargeAccountMessages, "large-account-messages", cfg.LargeAccountMessages, "Sync accounts with at least this many messages in the large account lane (0 ignores the count)")
+
+	largeAccountConcurrencyStr := envOrDefault("DOVEWARDEN_LARGE_ACCOUNT_CONCURRENCY", "1")
+	if n, err := strconv.Atoi(largeAccountConcurrencyStr); err == nil && n > 0 {
+		cfg.LargeAccountConcurrency = n
+	}
+	flag.IntVar(&cfg.LargeAccountConcurrency, "large-account-concurrency", cfg.LargeAccountConcurrency, "Concurrent syncs of large accounts")
+
+	largeAccountSizeTTLStr := envOrDefault("DOVEWARDEN_LARGE_ACCOUNT_SIZE_TTL", "24h")
+	if d, err := time.ParseDuration(largeAccountSizeTTLStr); err == nil && d > 0 {
+		cfg.LargeAccountSizeTTL = d
+	}
+	flag.DurationVar(&cfg.LargeAccountSizeTTL, "large-account-size-ttl", cfg.LargeAccountSizeTTL, "How long account sizes are cached")
+
+	redundantSyncWindowStr := envOrDefault("DOVEWARDEN_REDUNDANT_SYNC_WINDOW", "0")
+	if d, err := time.ParseDuration(redundantSyncWindowStr); err == nil && d >= 0 {
+		cfg.RedundantSyncWindow = d
+	}
+	flag.DurationVar(&cfg.RedundantSyncWindow, "redundant-sync-window", cfg.RedundantSyncWindow, "Skip syncs of users synced successfully this recently without events since (0 disables)")
+
+	flag.StringVar(&cfg.MailboxPriorities, "mailbox-priorities", envOrDefault("DOVEWARDEN_MAILBOX_PRIORITIES", cfg.MailboxPriorities), "Comma-separated mailbox=factor priority modifiers for events (factor > 1 syncs sooner)")
+
+	flag.StringVar(&cfg.SyncIncludeMailboxes, "sync-include-mailboxes", envOrDefault("DOVEWARDEN_SYNC_INCLUDE_MAILBOXES", cfg.SyncIncludeMailboxes), "Comma-separated mailbox globs synced by event-triggered syncs (empty syncs all)")
+	flag.StringVar(&cfg.SyncExcludeMailboxes, "sync-exclude-mailboxes", envOrDefault("DOVEWARDEN_SYNC_EXCLUDE_MAILBOXES", cfg.SyncExcludeMailboxes), "Comma-separated mailbox globs or special-use flags not synced by event-triggered syncs")
+	backgroundSyncAllMailboxesStr := envOrDefault("DOVEWARDEN_BACKGROUND_SYNC_ALL_MAILBOXES", "false")
+	cfg.BackgroundSyncAllMailboxes = backgroundSyncAllMailboxesStr == "true" || backgroundSyncAllMailboxesStr == "1"
+	flag.BoolVar(&cfg.BackgroundSyncAllMailboxes, "background-sync-all-mailboxes", cfg.BackgroundSyncAllMailboxes, "Sync all mailboxes in background replication regardless of the mailbox globs")
+
+	firstSeenPriorityStr := envOrDefault("DOVEWARDEN_FIRST_SEEN_PRIORITY", "2")
+	if factor, err := strconv.ParseFloat(firstSeenPriorityStr, 64); err == nil && factor > 0 {
+		cfg.FirstSeenPriority = factor
+	}
+	flag.Float64Var(&cfg.FirstSeenPriority, "first-seen-priority", cfg.FirstSeenPriority, "Priority factor of events of users that were never replicated (1 disables)")
+
+	priorityMinStr := envOrDefault("DOVEWARDEN_PRIORITY_MIN", "0.01")
+	if factor, err := strconv.ParseFloat(priorityMinStr, 64); err == nil && factor >= 0 {
+		cfg.PriorityMin = factor
+	}
+	flag.Float64Var(&cfg.PriorityMin, "priority-min", cfg.PriorityMin, "Lowest priority factor of enqueued users; lower factors are raised to it (0 disables)")
+	priorityMaxStr := envOrDefault("DOVEWARDEN_PRIORITY_MAX", "100")
+	if factor, err := strconv.ParseFloat(priorityMaxStr, 64); err == nil && factor >= 0 {
+		cfg.PriorityMax = factor
+	}
+	flag.Float64Var(&cfg.PriorityMax, "priority-max", cfg.PriorityMax, "Highest priority factor of enqueued users; higher factors are lowered to it (0 disables)")
+
+	flag.StringVar(&cfg.IgnoredNamespacePrefixes, "ignored-namespace-prefixes", envOrDefault("DOVEWARDEN_IGNORED_NAMESPACE_PREFIXES", cfg.IgnoredNamespacePrefixes), "Comma-separated mailbox prefixes of shared/public namespaces whose events are ignored (empty disables)")
+
+	userMinSyncIntervalStr := envOrDefault("DOVEWARDEN_USER_MIN_SYNC_INTERVAL", "0s")
+	if interval, err := time.ParseDuration(userMinSyncIntervalStr); err == nil && interval >= 0 {
+		cfg.UserMinSyncInterval = interval
+	}
+	flag.DurationVar(&cfg.UserMinSyncInterval, "user-min-sync-interval", cfg.UserMinSyncInterval, "Minimum time between two syncs of the same user (0 disables)")
+	flag.StringVar(&cfg.DomainMinSyncIntervals, "domain-min-sync-intervals", envOrDefault("DOVEWARDEN_DOMAIN_MIN_SYNC_INTERVALS", cfg.DomainMinSyncIntervals), "Comma-separated domain=duration overrides of the minimum sync interval")
+
+	privacyModeStr := envOrDefault("DOVEWARDEN_PRIVACY_MODE", "false")
+	cfg.PrivacyMode = privacyModeStr == "true" || privacyModeStr == "1"
+	flag.BoolVar(&cfg.PrivacyMode, "privacy-mode", cfg.PrivacyMode, "Replace usernames in log output with salted hashes")
+	flag.StringVar(&cfg.PrivacySalt, "privacy-salt", envOrDefault("DOVEWARDEN_PRIVACY_SALT", cfg.PrivacySalt), "Secret salt for username hashes in privacy mode")
+
+	flag.StringVar(&cfg.GRPCHealthAddr, "grpc-health-addr", envOrDefault("DOVEWARDEN_GRPC_HEALTH_ADDR", cfg.GRPCHealthAddr), "Listen address of the grpc.health.v1 health service (empty disables)")
+
+	flag.StringVar(&cfg.ErrorReportSentryDSN, "error-report-sentry-dsn", envOrDefault("DOVEWARDEN_ERROR_REPORT_SENTRY_DSN", cfg.ErrorReportSentryDSN), "Sentry DSN receiving panics, repeated sync failures and queue backend errors (empty disables)")
+	flag.StringVar(&cfg.ErrorReportWebhookURL, "error-report-webhook-url", envOrDefault("DOVEWARDEN_ERROR_REPORT_WEBHOOK_URL", cfg.ErrorReportWebhookURL), "URL receiving the same error reports as JSON (empty disables)")
+	flag.StringVar(&cfg.ErrorReportEnvironment, "error-report-environment", envOrDefault("DOVEWARDEN_ERROR_REPORT_ENVIRONMENT", cfg.ErrorReportEnvironment), "Environment name attached to error reports")
+	errorReportSampleRateStr := envOrDefault("DOVEWARDEN_ERROR_REPORT_SAMPLE_RATE", "1")
+	if rate, err := strconv.ParseFloat(errorReportSampleRateStr, 64); err == nil && rate > 0 && rate <= 1 {
+		cfg.ErrorReportSampleRate = rate
+	}
+	flag.Float64Var(&cfg.ErrorReportSampleRate, "error-report-sample-rate", cfg.ErrorReportSampleRate, "Share of error reports sent, in (0, 1]")
+	errorReportDedupWindowStr := envOrDefault("DOVEWARDEN_ERROR_REPORT_DEDUP_WINDOW", "10m")
+	if window, err := time.ParseDuration(errorReportDedupWindowStr); err == nil && window >= 0 {
+		cfg.ErrorReportDedupWindow = window
+	}
+	flag.DurationVar(&cfg.ErrorReportDedupWindow, "error-report-dedup-window", cfg.ErrorReportDedupWindow, "Identical error reports are sent at most once per window (0 disables)")
+	errorReportFailureThresholdStr := envOrDefault("DOVEWARDEN_ERROR_REPORT_FAILURE_THRESHOLD", "20")
+	if n, err := strconv.Atoi(errorReportFailureThresholdStr); err == nil && n >= 0 {
+		cfg.ErrorReportFailureThreshold = n
+	}
+	flag.IntVar(&cfg.ErrorReportFailureThreshold, "error-report-failure-threshold", cfg.ErrorReportFailureThreshold, "Consecutive failed syncs across all users reported as a systemic problem (0 disables)")
+
+	alertIntervalStr := envOrDefault("DOVEWARDEN_ALERT_INTERVAL", "30s")
+	if interval, err := time.ParseDuration(alertIntervalStr); err == nil && interval > 0 {
+		cfg.AlertInterval = interval
+	}
+	flag.DurationVar(&cfg.AlertInterval, "alert-interval", cfg.AlertInterval, "How often alert rules are evaluated")
+	alertQueueAgeStr := envOrDefault("DOVEWARDEN_ALERT_QUEUE_AGE", "30m")
+	if age, err := time.ParseDuration(alertQueueAgeStr); err == nil && age >= 0 {
+		cfg.AlertQueueAge = age
+	}
+	flag.DurationVar(&cfg.AlertQueueAge, "alert-queue-age", cfg.AlertQueueAge, "Alert while a user has been queued for longer (0 disables)")
+	alertConsecutiveFailuresStr := envOrDefault("DOVEWARDEN_ALERT_CONSECUTIVE_FAILURES", "10")
+	if n, err := strconv.Atoi(alertConsecutiveFailuresStr); err == nil && n >= 0 {
+		cfg.AlertConsecutiveFailures = n
+	}
+	flag.IntVar(&cfg.AlertConsecutiveFailures, "alert-consecutive-failures", cfg.AlertConsecutiveFailures, "Alert while this many syncs in a row failed (0 disables)")
+	alertFailedGrowthStr := envOrDefault("DOVEWARDEN_ALERT_FAILED_GROWTH", "50")
+	if n, err := strconv.Atoi(alertFailedGrowthStr); err == nil && n >= 0 {
+		cfg.AlertFailedGrowth = n
+	}
+	flag.IntVar(&cfg.AlertFailedGrowth, "alert-failed-growth", cfg.AlertFailedGrowth, "Alert if the number of failed users grows by this many within the growth window (0 disables)")
+	alertFailedGrowthWindowStr := envOrDefault("DOVEWARDEN_ALERT_FAILED_GROWTH_WINDOW", "15m")
+	if window, err := time.ParseDuration(alertFailedGrowthWindowStr); err == nil && window > 0 {
+		cfg.AlertFailedGrowthWindow = window
+	}
+	flag.DurationVar(&cfg.AlertFailedGrowthWindow, "alert-failed-growth-window", cfg.AlertFailedGrowthWindow, "Window of the failed users growth alert")
+	flag.StringVar(&cfg.AlertWebhookURL, "alert-webhook-url", envOrDefault("DOVEWARDEN_ALERT_WEBHOOK_URL", cfg.AlertWebhookURL), "URL receiving alerts as JSON (empty disables)")
+	flag.StringVar(&cfg.AlertSMTPAddr, "alert-smtp-addr", envOrDefault("DOVEWARDEN_ALERT_SMTP_ADDR", cfg.AlertSMTPAddr), "SMTP server (host:port) mailing alerts (empty disables)")
+	flag.StringVar(&cfg.AlertSMTPFrom, "alert-smtp-from", envOrDefault("DOVEWARDEN_ALERT_SMTP_FROM", cfg.AlertSMTPFrom), "Sender address of alert mails")
+	flag.StringVar(&cfg.AlertSMTPTo, "alert-smtp-to", envOrDefault("DOVEWARDEN_ALERT_SMTP_TO", cfg.AlertSMTPTo), "Comma-separated recipients of alert mails")
+	flag.StringVar(&cfg.AlertSMTPUsername, "alert-smtp-username", envOrDefault("DOVEWARDEN_ALERT_SMTP_USERNAME", cfg.AlertSMTPUsername), "SMTP username (empty disables authentication)")
+	flag.StringVar(&cfg.AlertSMTPPassword, "alert-smtp-password", envOrDefault("DOVEWARDEN_ALERT_SMTP_PASSWORD", cfg.AlertSMTPPassword), "SMTP password")
+	flag.StringVar(&cfg.AlertPagerDutyRoutingKey, "alert-pagerduty-routing-key", envOrDefault("DOVEWARDEN_ALERT_PAGERDUTY_ROUTING_KEY", cfg.AlertPagerDutyRoutingKey), "PagerDuty Events API v2 routing key (empty disables)")
+
+	flag.StringVar(&cfg.PushgatewayURL, "pushgateway-url", envOrDefault("DOVEWARDEN_PUSHGATEWAY_URL", cfg.PushgatewayURL), "Pushgateway URL receiving the metrics of one-shot subcommands on exit (empty disables)")
+	flag.StringVar(&cfg.PushgatewayJob, "pushgateway-job", envOrDefault("DOVEWARDEN_PUSHGATEWAY_JOB", cfg.PushgatewayJob), "Job label of pushed metrics")
+	flag.StringVar(&cfg.PushgatewayGrouping, "pushgateway-grouping", envOrDefault("DOVEWARDEN_PUSHGATEWAY_GROUPING", cfg.PushgatewayGrouping), "Comma-separated name=value grouping labels of pushed metrics")
+
+	flag.StringVar(&cfg.LeaderElection, "leader-election", envOrDefault("DOVEWARDEN_LEADER_ELECTION", cfg.LeaderElection), "Leader election for background replication: empty (every replica runs it) or kubernetes")
+	flag.StringVar(&cfg.LeaderElectionLeaseName, "leader-election-lease-name", envOrDefault("DOVEWARDEN_LEADER_ELECTION_LEASE_NAME", cfg.LeaderElectionLeaseName), "Name of the Kubernetes Lease used for leader election")
+	flag.StringVar(&cfg.LeaderElectionNamespace, "leader-election-namespace", envOrDefault("DOVEWARDEN_LEADER_ELECTION_NAMESPACE", cfg.LeaderElectionNamespace), "Namespace of the Kubernetes Lease (defaults to the pod's namespace)")
+	leaderElectionLeaseDurationStr := envOrDefault("DOVEWARDEN_LEADER_ELECTION_LEASE_DURATION", "15s")
+	if d, err := time.ParseDuration(leaderElectionLeaseDurationStr); err == nil && d >= 3*time.Second {
+		cfg.LeaderElectionLeaseDuration = d
+	}
+	flag.DurationVar(&cfg.LeaderElectionLeaseDuration, "leader-election-lease-duration", cfg.LeaderElectionLeaseDuration, "How long the leader lease is valid without renewal")
+
+	flag.StringVar(&cfg.VaultAddr, "vault-addr", envOrDefault("DOVEWARDEN_VAULT_ADDR", cfg.VaultAddr), "Vault address; fetches the doveadm password, Redis password and privacy salt from Vault if set")
+	flag.StringVar(&cfg.VaultNamespace, "vault-namespace", envOrDefault("DOVEWARDEN_VAULT_NAMESPACE", cfg.VaultNamespace), "Vault Enterprise namespace")
+	flag.StringVar(&cfg.VaultAuthMethod, "vault-auth-method", envOrDefault("DOVEWARDEN_VAULT_AUTH_METHOD", cfg.VaultAuthMethod), "Vault auth method: approle or kubernetes")
+	flag.StringVar(&cfg.VaultAuthMount, "vault-auth-mount", envOrDefault("DOVEWARDEN_VAULT_AUTH_MOUNT", cfg.VaultAuthMount), "Mount path of the Vault auth method (defaults to the method name)")
+	flag.StringVar(&cfg.VaultRoleID, "vault-role-id", envOrDefault("DOVEWARDEN_VAULT_ROLE_ID", cfg.VaultRoleID), "AppRole role ID")
+	flag.StringVar(&cfg.VaultSecretIDFile, "vault-secret-id-file", envOrDefault("DOVEWARDEN_VAULT_SECRET_ID_FILE", cfg.VaultSecretIDFile), "File holding the AppRole secret ID")
+	flag.StringVar(&cfg.VaultRole, "vault-role", envOrDefault("DOVEWARDEN_VAULT_ROLE", cfg.VaultRole), "Vault role for Kubernetes auth")
+	flag.StringVar(&cfg.VaultTokenFile, "vault-token-file", envOrDefault("DOVEWARDEN_VAULT_TOKEN_FILE", cfg.VaultTokenFile), "Service account token file for Kubernetes auth")
+	flag.StringVar(&cfg.VaultSecretPath, "vault-secret-path", envOrDefault("DOVEWARDEN_VAULT_SECRET_PATH", cfg.VaultSecretPath), "Path of the Vault secret, e.g. secret/data/dovewarden")
+
+	reusePortStr := envOrDefault("DOVEWARDEN_REUSE_PORT", "false")
+	cfg.ReusePort = reusePortStr == "true" || reusePortStr == "1"
+	flag.BoolVar(&cfg.ReusePort, "reuse-port", cfg.ReusePort, "Bind the listeners with SO_REUSEPORT so a new process can take over the sockets before the old one stops")
+
+	flag.StringVar(&cfg.EventsAllowedIPs, "events-allowed-ips", envOrDefault("DOVEWARDEN_EVENTS_ALLOWED_IPS", cfg.EventsAllowedIPs), "Comma-separated IPs or CIDR networks allowed to post events (empty allows all)")
+
+	flag.StringVar(&cfg.EventsTLSCert, "events-tls-cert", envOrDefault("DOVEWARDEN_EVENTS_TLS_CERT", cfg.EventsTLSCert), "Certificate file of the events listener; enables TLS")
+	flag.StringVar(&cfg.EventsTLSKey, "events-tls-key", envOrDefault("DOVEWARDEN_EVENTS_TLS_KEY", cfg.EventsTLSKey), "Private key file of the events listener")
+	flag.StringVar(&cfg.EventsTLSClientCA, "events-tls-client-ca", envOrDefault("DOVEWARDEN_EVENTS_TLS_CLIENT_CA", cfg.EventsTLSClientCA), "CA file verifying client certificates of event producers; enables mutual TLS")
+	flag.StringVar(&cfg.EventsTLSAllowedClients, "events-tls-allowed-clients", envOrDefault("DOVEWARDEN_EVENTS_TLS_ALLOWED_CLIENTS", cfg.EventsTLSAllowedClients), "Comma-separated DNS SANs or common names of accepted client certificates (empty accepts any signed by the CA)")
+
+	dryRunStr := envOrDefault("DOVEWARDEN_DRY_RUN", "false")
+	cfg.DryRun = dryRunStr == "true" || dryRunStr == "1"
+	flag.BoolVar(&cfg.DryRun, "dry-run", cfg.DryRun, "Log the syncs that would be performed without calling doveadm")
+
+	historySizeStr := envOrDefault("DOVEWARDEN_HISTORY_SIZE", "20")
+	if n, err := strconv.Atoi(historySizeStr); err == nil && n >= 0 {
+		cfg.HistorySize = n
+	}
+	flag.IntVar(&cfg.HistorySize, "history-size", cfg.HistorySize, "Number of sync attempts kept in the history of each user (0 disables)")
+
+	slowSyncThresholdStr := envOrDefault("DOVEWARDEN_SLOW_SYNC_THRESHOLD", "5m")
+	if d, err := time.ParseDuration(slowSyncThresholdStr); err == nil && d >= 0 {
+		cfg.SlowSyncThreshold = d
+	}
+	flag.DurationVar(&cfg.SlowSyncThreshold, "slow-sync-threshold", cfg.SlowSyncThreshold, "Syncs taking longer are reported as slow (0 disables)")
+
+	slowSyncBufferSizeStr := envOrDefault("DOVEWARDEN_SLOW_SYNC_BUFFER_SIZE", "100")
+	if n, err := strconv.Atoi(slowSyncBufferSizeStr); err == nil && n > 0 {
+		cfg.SlowSyncBufferSize = n
+	}
+	flag.IntVar(&cfg.SlowSyncBufferSize, "slow-sync-buffer-size", cfg.SlowSyncBufferSize, "Number of slow syncs kept for GET /admin/slow-syncs")
+
+	dashboardHistoryStr := envOrDefault("DOVEWARDEN_DASHBOARD_HISTORY", "1h")
+	if d, err := time.ParseDuration(dashboardHistoryStr); err == nil && d >= 0 {
+		cfg.DashboardHistory = d
+	}
+	flag.DurationVar(&cfg.DashboardHistory, "dashboard-history", cfg.DashboardHistory, "Queue depth history kept for the dashboard and GET /admin/queue/history (0 disables)")
+
+	recentSyncsStr := envOrDefault("DOVEWARDEN_RECENT_SYNCS", "100")
+	if n, err := strconv.Atoi(recentSyncsStr); err == nil && n >= 0 {
+		cfg.RecentSyncs = n
+	}
+	flag.IntVar(&cfg.RecentSyncs, "recent-syncs", cfg.RecentSyncs, "Number of syncs kept for the dashboard and GET /admin/syncs/recent (0 disables)")
+
+	accessLogStr := envOrDefault("DOVEWARDEN_ACCESS_LOG", "false")
+	cfg.AccessLog = accessLogStr == "true" || accessLogStr == "1"
+	flag.BoolVar(&cfg.AccessLog, "access-log", cfg.AccessLog, "Log every HTTP request of the events and metrics listeners")
+	accessLogSampleRateStr := envOrDefault("DOVEWARDEN_ACCESS_LOG_SAMPLE_RATE", "1")
+	if rate, err := strconv.ParseFloat(accessLogSampleRateStr, 64); err == nil && rate > 0 && rate <= 1 {
+		cfg.AccessLogSampleRate = rate
+	}
+	flag.Float64Var(&cfg.AccessLogSampleRate, "access-log-sample-rate", cfg.AccessLogSampleRate, "Share of successful HTTP requests logged, in (0, 1]; failed requests are always logged")
+	flag.StringVar(&cfg.AccessLogExcludePaths, "access-log-exclude-paths", envOrDefault("DOVEWARDEN_ACCESS_LOG_EXCLUDE_PATHS", cfg.AccessLogExcludePaths), "Comma-separated paths whose requests are logged only when they fail")
+
+	retryBudgetRatioStr := envOrDefault("DOVEWARDEN_RETRY_BUDGET_RATIO", "0.2")
+	if ratio, err := strconv.ParseFloat(retryBudgetRatioStr, 64); err == nil && ratio >= 0 {
+		cfg.RetryBudgetRatio = ratio
+	}
+	flag.Float64Var(&cfg.RetryBudgetRatio, "retry-budget-ratio", cfg.RetryBudgetRatio, "Immediate retries allowed per fresh sync attempt within the retry budget window (0 disables the budget)")
+
+	retryBudgetMinRetriesStr := envOrDefault("DOVEWARDEN_RETRY_BUDGET_MIN_RETRIES", "10")
+	if n, err := strconv.Atoi(retryBudgetMinRetriesStr); err == nil && n >= 0 {
+		cfg.RetryBudgetMinRetries = n
+	}
+	flag.IntVar(&cfg.RetryBudgetMinRetries, "retry-budget-min-retries", cfg.RetryBudgetMinRetries, "Immediate retries always allowed within the retry budget window")
+
+	retryBudgetWindowStr := envOrDefault("DOVEWARDEN_RETRY_BUDGET_WINDOW", "1m")
+	if d, err := time.ParseDuration(retryBudgetWindowStr); err == nil && d > 0 {
+		cfg.RetryBudgetWindow = d
+	}
+	flag.DurationVar(&cfg.RetryBudgetWindow, "retry-budget-window", cfg.RetryBudgetWindow, "Sliding window over which retries and fresh sync attempts are counted")
+
+	retryBudgetDelayStr := envOrDefault("DOVEWARDEN_RETRY_BUDGET_DELAY", "1m")
+	if d, err := time.ParseDuration(retryBudgetDelayStr); err == nil && d > 0 {
+		cfg.RetryBudgetDelay = d
+	}
+	flag.DurationVar(&cfg.RetryBudgetDelay, "retry-budget-delay", cfg.RetryBudgetDelay, "Delay of retries beyond the retry budget")
+
+	flag.StringVar(&cfg.RetryPolicies, "retry-policies", envOrDefault("DOVEWARDEN_RETRY_POLICIES", cfg.RetryPolicies), "Comma-separated origin=policy pairs replacing the immediate retry of failed syncs, e.g. background=10m,admin=none")
+
+	doveadmRateLimitStr := envOrDefault("DOVEWARDEN_DOVEADM_RATE_LIMIT", "0")
+	if rate, err := strconv.ParseFloat(doveadmRateLimitStr, 64); err == nil && rate >= 0 {
+		cfg.DoveadmRateLimit = rate
+	}
+	flag.Float64Var(&cfg.DoveadmRateLimit, "doveadm-rate-limit", cfg.DoveadmRateLimit, "Syncs started per second across all replicas sharing the rate limit Redis (0 disables)")
+
+	doveadmRateLimitBurstStr := envOrDefault("DOVEWARDEN_DOVEADM_RATE_LIMIT_BURST", "10")
+	if n, err := strconv.Atoi(doveadmRateLimitBurstStr); err == nil && n > 0 {
+		cfg.DoveadmRateLimitBurst = n
+	}
+	flag.IntVar(&cfg.DoveadmRateLimitBurst, "doveadm-rate-limit-burst", cfg.DoveadmRateLimitBurst, "Syncs started at once beyond the doveadm rate limit")
+
+	flag.StringVar(&cfg.DoveadmRateLimitRedisURL, "doveadm-rate-limit-redis-url", envOrDefault("DOVEWARDEN_DOVEADM_RATE_LIMIT_REDIS_URL", cfg.DoveadmRateLimitRedisURL), "Redis URL shared by all replicas for the doveadm rate limit (empty limits each replica alone)")
+
+	doveadmRateLimitFallbackStr := envOrDefault("DOVEWARDEN_DOVEADM_RATE_LIMIT_FALLBACK", "0")
+	if rate, err := strconv.ParseFloat(doveadmRateLimitFallbackStr, 64); err == nil && rate >= 0 {
+		cfg.DoveadmRateLimitFallback = rate
+	}
+	flag.Float64Var(&cfg.DoveadmRateLimitFallback, "doveadm-rate-limit-fallback", cfg.DoveadmRateLimitFallback, "Syncs started per second by a replica while the rate limit Redis fails (0 uses the doveadm rate limit)")
+
+	flag.StringVar(&cfg.PostSyncCommand, "post-sync-command", envOrDefault("DOVEWARDEN_POST_SYNC_COMMAND", cfg.PostSyncCommand), "Program run after every sync with username, destination and result as arguments (empty disables)")
+	postSyncCommandTimeoutStr := envOrDefault("DOVEWARDEN_POST_SYNC_COMMAND_TIMEOUT", "10s")
+	if timeout, err := time.ParseDuration(postSyncCommandTimeoutStr); err == nil && timeout > 0 {
+		cfg.PostSyncCommandTimeout = timeout
+	}
+	flag.DurationVar(&cfg.PostSyncCommandTimeout, "post-sync-command-timeout", cfg.PostSyncCommandTimeout, "Time after which the post-sync command is killed")
+
+	logSamplingFirstStr := envOrDefault("DOVEWARDEN_LOG_SAMPLING_FIRST", "10")
+	if n, err := strconv.Atoi(logSamplingFirstStr); err == nil && n >= 0 {
+		cfg.LogSamplingFirst = n
+	}
+	flag.IntVar(&cfg.LogSamplingFirst, "log-sampling-first", cfg.LogSamplingFirst, "Warnings and errors with the same message logged per interval before further ones are suppressed (0 disables)")
+
+	logSamplingIntervalStr := envOrDefault("DOVEWARDEN_LOG_SAMPLING_INTERVAL", "1m")
+	if interval, err := time.ParseDuration(logSamplingIntervalStr); err == nil && interval > 0 {
+		cfg.LogSamplingInterval = interval
+	}
+	flag.DurationVar(&cfg.LogSamplingInterval, "log-sampling-interval", cfg.LogSamplingInterval, "Interval after which a summary of suppressed log records is logged")
+
+	flag.StringVar(&cfg.SelfSessionPrefixes, "self-session-prefixes", envOrDefault("DOVEWARDEN_SELF_SESSION_PREFIXES", cfg.SelfSessionPrefixes), "Comma-separated session ID prefixes of events caused by dovewarden's own syncs, which are ignored")
+	flag.StringVar(&cfg.SelfRemoteIPs, "self-remote-ips", envOrDefault("DOVEWARDEN_SELF_REMOTE_IPS", cfg.SelfRemoteIPs), "Comma-separated IPs or CIDR networks of hosts running dovewarden's syncs; events from these are ignored")
+
 	flag.Parse()
 
 	return cfg
 }
 
+// fileValues holds the settings read from the config file.
+var fileValues map[string]string
+
 func envOrDefault(key, defaultVal string) string {
+	return lookupValue(fileValues, key, defaultVal)
+}
+
+// lookupValue returns the environment variable key, else its value in the given
+// config file values, else defaultVal.
+func lookupValue(values map[string]string, key, defaultVal string) string {
 	if val, ok := os.LookupEnv(key); ok {
 		return val
 	}
+	if val, ok := values[key]; ok {
+		return val
+	}
 	return defaultVal
 }
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// configFilePath returns the config file given by --config-file or
+// DOVEWARDEN_CONFIG_FILE. The command line is scanned ahead of flag parsing,
+// as the file provides the defaults of all other flags.
+func configFilePath() string {
+	args := os.Args[1:]
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			// first positional argument, e.g. a subcommand
+			break
+		}
+		if value, ok := strings.CutPrefix(name, "config-file="); ok {
+			return value
+		}
+		if name == "config-file" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return envOrDefault("DOVEWARDEN_CONFIG_FILE", "")
+}
+
+// readConfigFile parses a file of KEY=VALUE lines using the environment variable
+// names as keys. Blank lines and lines starting with # are ignored, and values
+// may be enclosed in single or double quotes.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(strings.TrimPrefix(key, "export "))
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNo)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Reload re-reads the config file and returns a copy of cfg with the reloadable
+// settings updated: log levels, filters, priorities, the events allowlist and
+// rate limits. Other settings require a restart. Values given as flags or in the
+// environment keep taking precedence over the file, as on startup. The new file
+// values are only used by later lookups once the copy is passed to Commit.
+func Reload(cfg *Config) (*Config, error) {
+	if cfg.ConfigFile == "" {
+		return nil, errors.New("no config file configured")
+	}
+	values, err := readConfigFile(cfg.ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	lookup := func(key, defaultVal string) string {
+		return lookupValue(values, key, defaultVal)
+	}
+
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	next := *cfg
+	next.fileValues = values
+	def := defaults()
+	reloadString := func(dst *string, flagName, key, defaultVal string) {
+		if !setFlags[flagName] {
+			*dst = lookup(key, defaultVal)
+		}
+	}
+	reloadString(&next.LogLevel, "log-level", "DOVEWARDEN_LOG_LEVEL", def.LogLevel)
+	reloadString(&next.LogLevels, "log-levels", "DOVEWARDEN_LOG_LEVELS", def.LogLevels)
+	reloadString(&next.MailboxPriorities, "mailbox-priorities", "DOVEWARDEN_MAILBOX_PRIORITIES", def.MailboxPriorities)
+	reloadString(&next.IgnoredNamespacePrefixes, "ignored-namespace-prefixes", "DOVEWARDEN_IGNORED_NAMESPACE_PREFIXES", def.IgnoredNamespacePrefixes)
+	reloadString(&next.SelfSessionPrefixes, "self-session-prefixes", "DOVEWARDEN_SELF_SESSION_PREFIXES", def.SelfSessionPrefixes)
+	reloadString(&next.SelfRemoteIPs, "self-remote-ips", "DOVEWARDEN_SELF_REMOTE_IPS", def.SelfRemoteIPs)
+	reloadString(&next.EventsAllowedIPs, "events-allowed-ips", "DOVEWARDEN_EVENTS_ALLOWED_IPS", def.EventsAllowedIPs)
+	reloadString(&next.DomainMinSyncIntervals, "domain-min-sync-intervals", "DOVEWARDEN_DOMAIN_MIN_SYNC_INTERVALS", def.DomainMinSyncIntervals)
+
+	if !setFlags["user-min-sync-interval"] {
+		intervalStr := lookup("DOVEWARDEN_USER_MIN_SYNC_INTERVAL", "0s")
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid DOVEWARDEN_USER_MIN_SYNC_INTERVAL %q", intervalStr)
+		}
+		next.UserMinSyncInterval = interval
+	}
+
+	return &next, nil
+}
+
+// Commit makes the config file values read by Reload for cfg the ones used by
+// later lookups. It is called once the reloaded settings were validated and
+// applied, so a rejected reload leaves the previous values in place.
+func Commit(cfg *Config) {
+	fileValues = cfg.fileValues
+}
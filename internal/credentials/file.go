@@ -0,0 +1,125 @@
+// Package credentials keeps secrets read from files up to date, so that
+// rotated credentials are picked up without a restart.
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File holds a secret read from a file, such as a password mounted from a
+// Kubernetes secret. Watch re-reads the file when its modification time or
+// size changes and notifies the registered callbacks.
+type File struct {
+	path string
+
+	mu       sync.RWMutex
+	value    string
+	modTime  time.Time
+	size     int64
+	onChange []func(string)
+}
+
+// NewFile reads the secret from path. Surrounding whitespace, e.g. a trailing
+// newline, is removed. An empty file is an error.
+func NewFile(path string) (*File, error) {
+	f := &File{path: path}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path returns the path of the file.
+func (f *File) Path() string {
+	return f.path
+}
+
+// Value returns the current secret.
+func (f *File) Value() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.value
+}
+
+// OnChange registers a callback invoked with the new secret after it changed.
+func (f *File) OnChange(fn func(string)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onChange = append(f.onChange, fn)
+}
+
+// Watch checks the file every interval until ctx is cancelled. A file that
+// cannot be read or is empty, e.g. while being replaced, keeps the previous
+// secret in use.
+func (f *File) Watch(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := f.reload()
+			if err != nil {
+				logger.Warn("Failed to re-read credential file, keeping the previous value", "path", f.path, "error", err)
+				continue
+			}
+			if changed {
+				logger.Info("Credential file changed, using the new value", "path", f.path)
+			}
+		}
+	}
+}
+
+// reload re-reads the file if it changed since the last read and reports
+// whether the secret changed.
+func (f *File) reload() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	f.mu.RLock()
+	unchanged := info.ModTime().Equal(f.modTime) && info.Size() == f.size
+	f.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return false, errors.New("file is empty")
+	}
+
+	f.mu.Lock()
+	changed := value != f.value
+	first := f.value == ""
+	f.value = value
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	callbacks := f.onChange
+	f.mu.Unlock()
+
+	if !changed || first {
+		return false, nil
+	}
+	for _, fn := range callbacks {
+		fn(value)
+	}
+	return true, nil
+}
+
+// String implements fmt.Stringer without revealing the secret.
+func (f *File) String() string {
+	return fmt.Sprintf("credentials.File(%s)", f.path)
+}
@@ -0,0 +1,84 @@
+package credentials
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileReloadsChangedSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile: %v", err)
+	}
+	if got := f.Value(); got != "old" {
+		t.Fatalf("expected old, got %q", got)
+	}
+
+	var notified []string
+	f.OnChange(func(v string) { notified = append(notified, v) })
+
+	if err := os.WriteFile(path, []byte("new-password\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := f.reload()
+	if err != nil || !changed {
+		t.Fatalf("expected change, got changed=%v err=%v", changed, err)
+	}
+	if got := f.Value(); got != "new-password" {
+		t.Fatalf("expected new-password, got %q", got)
+	}
+	if len(notified) != 1 || notified[0] != "new-password" {
+		t.Fatalf("expected one notification, got %v", notified)
+	}
+
+	// An unchanged file does not notify again
+	if changed, err := f.reload(); err != nil || changed {
+		t.Fatalf("expected no change, got changed=%v err=%v", changed, err)
+	}
+}
+
+func TestFileKeepsPreviousSecretOnInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.reload(); err == nil {
+		t.Fatal("expected error for empty file")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.reload(); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+	if got := f.Value(); got != "secret" {
+		t.Fatalf("expected previous secret, got %q", got)
+	}
+}
+
+func TestNewFileRejectsEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFile(path); err == nil {
+		t.Fatal("expected error for empty file")
+	}
+}
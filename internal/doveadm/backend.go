@@ -0,0 +1,182 @@
+package doveadm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LookupPassdb returns the passdb fields of a user (doveadm auth lookup), e.g.
+// "proxy" and "host" in proxied clusters. Fields are returned as key=value
+// pairs by some Dovecot versions and as object members by others; both are
+// accepted.
+func (c *Client) LookupPassdb(ctx context.Context, username string) (map[string]string, error) {
+	// [["authLookup",{"user":["$username"]},"tag1"]]
+	params := map[string]interface{}{
+		"user": []string{username},
+	}
+
+	payload := []interface{}{
+		[]interface{}{
+			"authLookup",
+			params,
+			"dovewarden-auth-lookup",
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/doveadm/v1", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("doveadm", *c.password.Load())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("doveadm auth lookup failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var respPayload []responseEntry
+	if err := json.Unmarshal(respBody, &respPayload); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	fields := make(map[string]string)
+	for _, entry := range respPayload {
+		if entry.Status == "error" {
+			if entry.Error != nil {
+				return nil, fmt.Errorf("doveadm auth lookup error (tag %s): %s (exitCode %d)", entry.Tag, entry.Error.Type, entry.Error.ExitCode)
+			}
+			return nil, fmt.Errorf("doveadm auth lookup error (tag %s): unknown reason", entry.Tag)
+		}
+
+		items := entry.ResponseList
+		if entry.Response != nil {
+			items = append(items, entry.Response)
+		}
+		for _, item := range items {
+			for key, value := range item {
+				switch v := value.(type) {
+				case string:
+					fields[key] = v
+				case []interface{}:
+					// e.g. "passdbExtraFields": ["proxy=y", "host=10.0.0.2"]
+					for _, pair := range v {
+						if s, ok := pair.(string); ok {
+							if k, val, ok := strings.Cut(s, "="); ok {
+								fields[k] = val
+							} else {
+								fields[s] = ""
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return fields, nil
+}
+
+// BackendResolver resolves the doveadm endpoint of a user's current backend in
+// proxied Dovecot clusters, where the dsync must run on the backend holding
+// the user's mailbox. It looks up the user's passdb "host" field and
+// substitutes it for {host} in a URL template, e.g. "http://{host}:8080".
+// Users without a host field are handled by the client they were looked up
+// at. Resolved endpoints are cached for the TTL.
+type BackendResolver struct {
+	urlTemplate string
+	ttl         time.Duration
+
+	mu        sync.Mutex
+	backends  map[string]cachedBackend
+	clients   map[string]*Client
+	lastSweep time.Time
+}
+
+type cachedBackend struct {
+	client  *Client
+	expires time.Time
+}
+
+// NewBackendResolver creates a resolver for the backend endpoints described by
+// urlTemplate.
+func NewBackendResolver(urlTemplate string, ttl time.Duration) *BackendResolver {
+	return &BackendResolver{
+		urlTemplate: urlTemplate,
+		ttl:         ttl,
+		backends:    make(map[string]cachedBackend),
+		clients:     make(map[string]*Client),
+		lastSweep:   time.Now(),
+	}
+}
+
+// Resolve looks the user up at client and returns the client for the doveadm
+// endpoint of its backend, with the credentials of client.
+func (r *BackendResolver) Resolve(ctx context.Context, client *Client, username string) (*Client, error) {
+	now := time.Now()
+	r.mu.Lock()
+	if cached, ok := r.backends[username]; ok && now.Before(cached.expires) {
+		r.mu.Unlock()
+		return cached.client, nil
+	}
+	r.mu.Unlock()
+
+	fields, err := client.LookupPassdb(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up backend: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if host := fields["host"]; host != "" {
+		baseURL := strings.ReplaceAll(r.urlTemplate, "{host}", host)
+		backend := r.clients[baseURL]
+		if backend == nil || backend.password != client.password {
+			backend = client.WithBaseURL(baseURL)
+			r.clients[baseURL] = backend
+		}
+		client = backend
+	}
+	r.backends[username] = cachedBackend{client: client, expires: now.Add(r.ttl)}
+	if now.Sub(r.lastSweep) >= r.ttl {
+		r.lastSweep = now
+		for user, cached := range r.backends {
+			if !now.Before(cached.expires) {
+				delete(r.backends, user)
+			}
+		}
+	}
+	return client, nil
+}
+
+// Forget drops the cached backend of a user, e.g. after a failed sync, so that
+// the next sync looks it up again in case the user moved.
+func (r *BackendResolver) Forget(username string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.backends, username)
+}
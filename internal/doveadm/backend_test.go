@@ -0,0 +1,67 @@
+package doveadm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackendResolver(t *testing.T) {
+	var lookups atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload [][]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if payload[0][0] != "authLookup" {
+			t.Errorf("expected authLookup, got %v", payload[0][0])
+		}
+		lookups.Add(1)
+		user := payload[0][1].(map[string]interface{})["user"].([]interface{})[0]
+		switch user {
+		case "proxied":
+			_, _ = fmt.Fprint(w, `[["doveadmResponse",[{"user":"proxied","source":"passdb","passdbExtraFields":["proxy=y","host=10.0.0.2"]}],"dovewarden-auth-lookup"]]`)
+		case "local":
+			_, _ = fmt.Fprint(w, `[["doveadmResponse",[{"user":"local","source":"passdb"}],"dovewarden-auth-lookup"]]`)
+		default:
+			_, _ = fmt.Fprint(w, `[["error",{"type":"exitCode","exitCode":67},"dovewarden-auth-lookup"]]`)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "testpass")
+	r := NewBackendResolver("http://{host}:8080", time.Hour)
+	ctx := context.Background()
+
+	backend, err := r.Resolve(ctx, client, "proxied")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if backend.BaseURL() != "http://10.0.0.2:8080" {
+		t.Fatalf("expected the backend endpoint, got %s", backend.BaseURL())
+	}
+	client.SetPassword("rotated")
+	if got := *backend.password.Load(); got != "rotated" {
+		t.Fatalf("expected the backend client to share the password, got %q", got)
+	}
+
+	if again, _ := r.Resolve(ctx, client, "proxied"); again != backend || lookups.Load() != 1 {
+		t.Fatalf("expected the backend to be cached, got %d lookups", lookups.Load())
+	}
+	r.Forget("proxied")
+	if _, err := r.Resolve(ctx, client, "proxied"); err != nil || lookups.Load() != 2 {
+		t.Fatalf("expected a forgotten backend to be looked up again, got %d lookups, %v", lookups.Load(), err)
+	}
+
+	if local, err := r.Resolve(ctx, client, "local"); err != nil || local != client {
+		t.Fatalf("expected users without host to use the default client, got %v", err)
+	}
+	if _, err := r.Resolve(ctx, client, "unknown"); err == nil {
+		t.Fatal("expected a failed lookup to fail")
+	}
+}
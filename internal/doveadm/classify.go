@@ -0,0 +1,128 @@
+package doveadm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Error classes of failed syncs.
+const (
+	ErrorNetwork      = "network"       // doveadm unreachable, connection reset or timed out
+	ErrorAuth         = "auth"          // credentials rejected
+	ErrorTempFail     = "tempfail"      // temporary failure, e.g. the user is locked by another dsync
+	ErrorUserMissing  = "user-missing"  // the user does not exist
+	ErrorStateInvalid = "state-invalid" // the replication state was rejected
+	ErrorOverload     = "overload"      // doveadm is overloaded and sheds requests
+	ErrorOther        = "other"
+)
+
+var errorClasses = []string{ErrorNetwork, ErrorAuth, ErrorTempFail, ErrorUserMissing, ErrorStateInvalid, ErrorOverload, ErrorOther}
+
+// invalidStatePattern matches errors reporting a rejected replication state.
+var invalidStatePattern = regexp.MustCompile(`(?i)invalid (input )?state|state.*(corrupt|invalid)`)
+
+// ErrorRule classifies errors whose message matches the regular expression
+// Pattern as Class.
+type ErrorRule struct {
+	Pattern string `json:"pattern"`
+	Class   string `json:"class"`
+}
+
+// ParseErrorRules parses a JSON array of error rules, e.g.
+// [{"pattern": "Too many connections", "class": "overload"}].
+// An empty string yields no rules.
+func ParseErrorRules(s string) ([]ErrorRule, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var rules []ErrorRule
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, fmt.Errorf("error rules must be a JSON array: %w", err)
+	}
+	return rules, nil
+}
+
+// Classifier maps errors of doveadm requests to error classes: first by the
+// configured rules, in order, then by the exit code, HTTP status or network
+// error. Errors matching nothing are ErrorOther.
+type Classifier struct {
+	rules []compiledErrorRule
+}
+
+type compiledErrorRule struct {
+	pattern *regexp.Regexp
+	class   string
+}
+
+// NewClassifier creates a classifier checking the rules before the built-in
+// classification.
+func NewClassifier(rules []ErrorRule) (*Classifier, error) {
+	c := &Classifier{}
+	for i, rule := range rules {
+		if !slices.Contains(errorClasses, rule.Class) {
+			return nil, fmt.Errorf("error rule %d: unknown class %q, must be one of %s", i+1, rule.Class, strings.Join(errorClasses, ", "))
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error rule %d: invalid pattern: %w", i+1, err)
+		}
+		c.rules = append(c.rules, compiledErrorRule{pattern: pattern, class: rule.Class})
+	}
+	return c, nil
+}
+
+// Classify returns the error class of err. A nil classifier applies only the
+// built-in classification.
+func (c *Classifier) Classify(err error) string {
+	if err == nil {
+		return ""
+	}
+	if c != nil {
+		msg := err.Error()
+		for _, rule := range c.rules {
+			if rule.pattern.MatchString(msg) {
+				return rule.class
+			}
+		}
+	}
+
+	var syncErr *SyncError
+	if errors.As(err, &syncErr) {
+		switch syncErr.ExitCode {
+		case ExitCodeNoUser:
+			return ErrorUserMissing
+		case ExitCodeTempFail:
+			return ErrorTempFail
+		case ExitCodeNoPerm:
+			return ErrorAuth
+		}
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return ErrorAuth
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return ErrorOverload
+		case http.StatusBadGateway, http.StatusGatewayTimeout:
+			return ErrorNetwork
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorNetwork
+	}
+	if invalidStatePattern.MatchString(err.Error()) {
+		return ErrorStateInvalid
+	}
+	return ErrorOther
+}
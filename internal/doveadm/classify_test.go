@@ -0,0 +1,75 @@
+package doveadm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	c, err := NewClassifier([]ErrorRule{{Pattern: "(?i)too many connections", Class: ErrorOverload}})
+	if err != nil {
+		t.Fatalf("NewClassifier: %v", err)
+	}
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&SyncError{ResponseError: ResponseError{Type: "exitCode", ExitCode: ExitCodeNoUser}}, ErrorUserMissing},
+		{fmt.Errorf("mailbox %q: %w", "INBOX", &SyncError{ResponseError: ResponseError{Type: "exitCode", ExitCode: ExitCodeTempFail}}), ErrorTempFail},
+		{&SyncError{ResponseError: ResponseError{Type: "exitCode", ExitCode: ExitCodeNoPerm}}, ErrorAuth},
+		{&SyncError{ResponseError: ResponseError{Type: "exitCode", ExitCode: 1}}, ErrorOther},
+		{&StatusError{StatusCode: http.StatusUnauthorized}, ErrorAuth},
+		{&StatusError{StatusCode: http.StatusServiceUnavailable}, ErrorOverload},
+		{&StatusError{StatusCode: http.StatusBadGateway}, ErrorNetwork},
+		{&StatusError{StatusCode: http.StatusInternalServerError, Body: "Too many connections"}, ErrorOverload},
+		{fmt.Errorf("failed to send request: %w", context.DeadlineExceeded), ErrorNetwork},
+		{errors.New("dsync: Invalid input state"), ErrorStateInvalid},
+		{errors.New("something else"), ErrorOther},
+	}
+	for _, tt := range tests {
+		if got := c.Classify(tt.err); got != tt.want {
+			t.Errorf("Classify(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+	if got := c.Classify(nil); got != "" {
+		t.Errorf("Classify(nil) = %q, want empty", got)
+	}
+}
+
+func TestClassifyRequestErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	client := NewClient(srv.URL, "secret")
+
+	var c *Classifier
+	_, err := client.Sync(context.Background(), "user", "imap", "")
+	if got := c.Classify(err); got != ErrorOverload {
+		t.Fatalf("expected overload for status 429, got %q (%v)", got, err)
+	}
+
+	srv.Close()
+	_, err = client.Sync(context.Background(), "user", "imap", "")
+	if got := c.Classify(err); got != ErrorNetwork {
+		t.Fatalf("expected network error for a closed server, got %q (%v)", got, err)
+	}
+}
+
+func TestNewClassifierRejectsInvalidRules(t *testing.T) {
+	for _, rules := range [][]ErrorRule{
+		{{Pattern: "x", Class: "unknown"}},
+		{{Pattern: "(", Class: ErrorNetwork}},
+	} {
+		if _, err := NewClassifier(rules); err == nil {
+			t.Errorf("expected %+v to be rejected", rules)
+		}
+	}
+	if _, err := ParseErrorRules(`{"pattern": "x"}`); err == nil {
+		t.Error("expected a JSON object to be rejected")
+	}
+}
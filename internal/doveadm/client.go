@@ -7,22 +7,91 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Client handles communication with the Doveadm API
 type Client struct {
-	baseURL  string
-	password string
+	baseURL string
+	// shared with the clients of WithBaseURL, so that rotations reach them
+	password *atomic.Pointer[string]
 	client   *http.Client
+
+	// extra parameters merged into sync requests, by destination
+	syncParams map[string]map[string]any
 }
 
 // NewClient creates a new Doveadm API client
 func NewClient(baseURL, password string) *Client {
-	return &Client{
+	c := &Client{
 		baseURL:  baseURL,
-		password: password,
+		password: new(atomic.Pointer[string]),
 		client:   &http.Client{},
 	}
+	c.SetPassword(password)
+	return c
+}
+
+// SetPassword replaces the password used for subsequent requests, e.g. after
+// the credential was rotated. It is safe to call while requests are running.
+func (c *Client) SetPassword(password string) {
+	c.password.Store(&password)
+}
+
+// WithBaseURL returns a client for the Doveadm API at baseURL, e.g. of another
+// backend, with the same password, HTTP client and sync parameters. Password
+// changes apply to both clients.
+func (c *Client) WithBaseURL(baseURL string) *Client {
+	clone := *c
+	clone.baseURL = baseURL
+	return &clone
+}
+
+// BaseURL returns the URL of the Doveadm API the client talks to.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// SetHTTPClient replaces the HTTP client used for requests, e.g. to configure
+// TLS or timeouts. Must be called before the client is used concurrently.
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.client = client
+}
+
+// reservedSyncParams are the sync parameters set by the client itself.
+var reservedSyncParams = []string{"destination", "state", "user", "mailbox", "excludeMailbox"}
+
+// ParseSyncParams parses a JSON object mapping destinations to objects of
+// extra sync parameters, e.g. {"imap": {"lockTimeout": 30}}, for SetSyncParams.
+// An empty string yields no parameters. Parameters set by the client itself are
+// rejected.
+func ParseSyncParams(s string) (map[string]map[string]any, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var params map[string]map[string]any
+	if err := json.Unmarshal([]byte(s), &params); err != nil {
+		return nil, fmt.Errorf("sync parameters must be a JSON object of objects by destination: %w", err)
+	}
+	for dest, destParams := range params {
+		for _, key := range reservedSyncParams {
+			if _, ok := destParams[key]; ok {
+				return nil, fmt.Errorf("sync parameter %q of destination %q is set by dovewarden", key, dest)
+			}
+		}
+	}
+	return params, nil
+}
+
+// SetSyncParams sets extra parameters by destination, merged into the sync
+// requests to that destination, so that new dsync options can be used without
+// code changes. Parameters set by the client itself take precedence. Must be
+// called before the client is used concurrently.
+func (c *Client) SetSyncParams(params map[string]map[string]any) {
+	c.syncParams = params
 }
 
 // ResponseError represents an error entry returned by Doveadm
@@ -32,15 +101,64 @@ type ResponseError struct {
 	ExitCode int    `json:"exitCode"`
 }
 
+// Exit codes of failed doveadm commands that callers may handle specially.
+const (
+	ExitCodeNoUser   = 67 // EX_NOUSER: the user does not exist
+	ExitCodeTempFail = 75 // EX_TEMPFAIL: e.g. the user is locked by another dsync
+	ExitCodeNoPerm   = 77 // EX_NOPERM: the request was not authorized
+)
+
+// SyncError is a failed sync reported by doveadm in the response body.
+type SyncError struct {
+	Tag string
+	ResponseError
+}
+
+func (e *SyncError) Error() string {
+	return fmt.Sprintf("doveadm sync error (tag %s): %s (exitCode %d)", e.Tag, e.Type, e.ExitCode)
+}
+
+// StatusError is a sync rejected by the Doveadm API with an HTTP error status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the delay requested by a Retry-After header, e.g. with 503
+	// responses of an overloaded endpoint; 0 if none was sent.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("doveadm sync failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Warning types reported by dsync.
+const (
+	WarningMailboxSkipped = "mailbox_skipped"
+	WarningConflict       = "conflict"
+	WarningOther          = "other"
+)
+
+// Warning is a non-fatal problem reported by dsync, e.g. a skipped mailbox.
+// Doveadm reports these either as separate entries
+// [ "warning", {"type":"conflict","message":"...","mailbox":"INBOX"}, "tag" ]
+// or as a "warnings" list inside the response object.
+type Warning struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Mailbox string `json:"mailbox,omitempty"`
+}
+
 // SyncResponse represents the response from a sync operation
 type SyncResponse struct {
-	State string // Replication state for incremental sync
+	State    string    // Replication state for incremental sync
+	Warnings []Warning // Non-fatal warnings reported during the sync
 }
 
 // responseEntry models a single Doveadm response array.
 type responseEntry struct {
 	Status       string
 	Error        *ResponseError
+	Warning      *Warning
 	Response     map[string]interface{}
 	ResponseList []map[string]interface{}
 	Tag          string
@@ -67,6 +185,15 @@ func (r *responseEntry) UnmarshalJSON(data []byte) error {
 			return fmt.Errorf("failed to parse error payload: %w", err)
 		}
 		r.Error = &errPayload
+	} else if r.Status == "warning" {
+		var warnPayload Warning
+		if err := json.Unmarshal(raw[1], &warnPayload); err != nil {
+			// plain string warnings carry only a message
+			if err := json.Unmarshal(raw[1], &warnPayload.Message); err != nil {
+				return fmt.Errorf("failed to parse warning payload: %w", err)
+			}
+		}
+		r.Warning = &warnPayload
 	} else {
 		// Parse as response object for successful responses. Dovecot may return
 		// either a single map or an array of maps as the second element.
@@ -92,6 +219,18 @@ func (r *responseEntry) UnmarshalJSON(data []byte) error {
 // If state is provided (non-empty), it will be used for incremental sync.
 // Returns the new state string for the next sync operation.
 func (c *Client) Sync(ctx context.Context, username string, destination string, state string) (*SyncResponse, error) {
+	return c.SyncWith(ctx, username, destination, state, SyncOptions{})
+}
+
+// SyncOptions restricts what a sync replicates.
+type SyncOptions struct {
+	// ExcludeMailboxes are mailbox names or masks with the * and % wildcards,
+	// or special-use flags such as \Junk, that are not synced (dsync -x).
+	ExcludeMailboxes []string
+}
+
+// SyncWith is like Sync, restricted by opts.
+func (c *Client) SyncWith(ctx context.Context, username, destination, state string, opts SyncOptions) (*SyncResponse, error) {
 	// Build the request payload according to Doveadm API format:
 	// [["sync",{"destination":["$destination"],"user":"$username","state":"$state"},"tag1"]]
 	params := map[string]interface{}{
@@ -100,12 +239,57 @@ func (c *Client) Sync(ctx context.Context, username string, destination string,
 		"state": state,
 		"user":  username,
 	}
+	if len(opts.ExcludeMailboxes) > 0 {
+		params["excludeMailbox"] = opts.ExcludeMailboxes
+	}
+	return c.sync(ctx, params)
+}
+
+// SyncMailbox performs a dsync operation restricted to a single mailbox of the
+// user. It runs without a replication state, as states cover whole accounts.
+func (c *Client) SyncMailbox(ctx context.Context, username, destination, mailbox string) (*SyncResponse, error) {
+	// [["sync",{"destination":["$destination"],"user":"$username","mailbox":"$mailbox"},"tag1"]]
+	params := map[string]interface{}{
+		"destination": []string{destination},
+		"mailbox":     mailbox,
+		"user":        username,
+	}
+	return c.sync(ctx, params)
+}
+
+// defaultSyncTag is the tag of sync commands whose context carries none.
+const defaultSyncTag = "dovewarden-sync"
+
+type syncTagKey struct{}
+
+// WithSyncTag returns a context whose sync commands are sent with tag instead
+// of the default tag. Doveadm echoes the tag in its response, and errors of
+// the sync include it.
+func WithSyncTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, syncTagKey{}, tag)
+}
 
+// syncTag returns the tag of sync commands sent with ctx.
+func syncTag(ctx context.Context) string {
+	if tag, ok := ctx.Value(syncTagKey{}).(string); ok && tag != "" {
+		return tag
+	}
+	return defaultSyncTag
+}
+
+// sync runs the sync command with the given parameters.
+func (c *Client) sync(ctx context.Context, params map[string]interface{}) (*SyncResponse, error) {
+	dest, _ := params["destination"].([]string)
+	for key, value := range c.syncParams[strings.Join(dest, ",")] {
+		if _, ok := params[key]; !ok {
+			params[key] = value
+		}
+	}
 	payload := []interface{}{
 		[]interface{}{
 			"sync",
 			params,
-			"dovewarden-sync",
+			syncTag(ctx),
 		},
 	}
 
@@ -120,7 +304,7 @@ func (c *Client) Sync(ctx context.Context, username string, destination string,
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth("doveadm", c.password)
+	req.SetBasicAuth("doveadm", *c.password.Load())
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -138,7 +322,11 @@ func (c *Client) Sync(ctx context.Context, username string, destination string,
 
 	// Check for HTTP errors
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("doveadm sync failed with status %d: %s", resp.StatusCode, string(respBody))
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(respBody),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
 
 	// Doveadm API returns error with HTTP 200 but indicates failure in the response body
@@ -151,27 +339,115 @@ func (c *Client) Sync(ctx context.Context, username string, destination string,
 	for _, entry := range respPayload {
 		if entry.Status == "error" {
 			if entry.Error != nil {
-				return nil, fmt.Errorf("doveadm sync error (tag %s): %s (exitCode %d)", entry.Tag, entry.Error.Type, entry.Error.ExitCode)
+				return nil, &SyncError{Tag: entry.Tag, ResponseError: *entry.Error}
 			}
 			return nil, fmt.Errorf("doveadm sync error (tag %s): unknown reason", entry.Tag)
 		}
 
+		if entry.Warning != nil {
+			syncResp.Warnings = append(syncResp.Warnings, classifyWarning(*entry.Warning))
+			continue
+		}
+
 		// Extract state from response if available
 		if entry.Response != nil {
 			if stateVal, ok := entry.Response["state"].(string); ok {
 				syncResp.State = stateVal
 			}
+			syncResp.Warnings = append(syncResp.Warnings, parseWarnings(entry.Response["warnings"])...)
 		}
 		if len(entry.ResponseList) > 0 {
 			if stateVal, ok := entry.ResponseList[0]["state"].(string); ok {
 				syncResp.State = stateVal
 			}
+			for _, item := range entry.ResponseList {
+				syncResp.Warnings = append(syncResp.Warnings, parseWarnings(item["warnings"])...)
+			}
 		}
 	}
 
 	return syncResp, nil
 }
 
+// parseRetryAfter returns the delay of a Retry-After header value, given in
+// seconds or as an HTTP date, or 0 if it is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+// parseWarnings extracts warnings from a "warnings" response field, which may
+// hold plain strings or objects with type/message/mailbox.
+func parseWarnings(v interface{}) []Warning {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	var warnings []Warning
+	for _, item := range items {
+		switch w := item.(type) {
+		case string:
+			warnings = append(warnings, classifyWarning(Warning{Message: w}))
+		case map[string]interface{}:
+			warning := Warning{}
+			warning.Type, _ = w["type"].(string)
+			warning.Message, _ = w["message"].(string)
+			warning.Mailbox, _ = w["mailbox"].(string)
+			warnings = append(warnings, classifyWarning(warning))
+		}
+	}
+	return warnings
+}
+
+// classifyWarning derives the warning type from the message if doveadm did not set one.
+func classifyWarning(w Warning) Warning {
+	if w.Type != "" {
+		return w
+	}
+	msg := strings.ToLower(w.Message)
+	switch {
+	case strings.Contains(msg, "skip"):
+		w.Type = WarningMailboxSkipped
+	case strings.Contains(msg, "conflict"):
+		w.Type = WarningConflict
+	default:
+		w.Type = WarningOther
+	}
+	return w
+}
+
+// Ping checks that the Doveadm API is reachable and accepts the configured credentials.
+// It requests the command listing, which is cheap and does not touch any mailbox.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/doveadm/v1", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth("doveadm", *c.password.Load())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("doveadm ping failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // User represents a user returned by the user list command
 type User struct {
 	Username string `json:"username"`
@@ -207,7 +483,7 @@ func (c *Client) ListUsers(ctx context.Context) ([]User, error) {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth("doveadm", c.password)
+	req.SetBasicAuth("doveadm", *c.password.Load())
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -261,3 +537,78 @@ func (c *Client) ListUsers(ctx context.Context) ([]User, error) {
 
 	return users, nil
 }
+
+// ListMailboxes returns the names of all mailboxes of a user.
+func (c *Client) ListMailboxes(ctx context.Context, username string) ([]string, error) {
+	// [["mailboxList",{"user":"$username"},"tag1"]]
+	params := map[string]interface{}{
+		"user": username,
+	}
+
+	payload := []interface{}{
+		[]interface{}{
+			"mailboxList",
+			params,
+			"dovewarden-list-mailboxes",
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/doveadm/v1", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("doveadm", *c.password.Load())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("doveadm mailbox list failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var respPayload []responseEntry
+	if err := json.Unmarshal(respBody, &respPayload); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var mailboxes []string
+	for _, entry := range respPayload {
+		if entry.Status == "error" {
+			if entry.Error != nil {
+				return nil, fmt.Errorf("doveadm mailbox list error (tag %s): %s (exitCode %d)", entry.Tag, entry.Error.Type, entry.Error.ExitCode)
+			}
+			return nil, fmt.Errorf("doveadm mailbox list error (tag %s): unknown reason", entry.Tag)
+		}
+
+		// Response contains [{"mailbox":"INBOX"}, {"mailbox":"Sent"}, ...]; a
+		// single mailbox may be returned as a plain object
+		items := entry.ResponseList
+		if entry.Response != nil {
+			items = append(items, entry.Response)
+		}
+		for _, item := range items {
+			if name, ok := item["mailbox"].(string); ok && name != "" {
+				mailboxes = append(mailboxes, name)
+			}
+		}
+	}
+
+	return mailboxes, nil
+}
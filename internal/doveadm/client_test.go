@@ -3,10 +3,12 @@ package doveadm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 // TestSyncSuccess verifies that a successful sync request works
@@ -82,6 +84,24 @@ func TestSyncServerError(t *testing.T) {
 	}
 }
 
+// TestSyncErrorExitCode verifies that failures reported in the response body carry the exit code
+func TestSyncErrorExitCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `[["error",{"type":"exitCode","exitCode":67},"dovewarden-sync"]]`)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "testpass")
+	_, err := client.Sync(context.Background(), "user-a", "imap", "")
+	var syncErr *SyncError
+	if !errors.As(err, &syncErr) || syncErr.ExitCode != ExitCodeNoUser {
+		t.Fatalf("expected sync error with exit code %d, got %v", ExitCodeNoUser, err)
+	}
+	if want := "doveadm sync error (tag dovewarden-sync): exitCode (exitCode 67)"; err.Error() != want {
+		t.Fatalf("expected message %q, got %q", want, err.Error())
+	}
+}
+
 // TestSyncUnauthorized verifies error handling for authentication failures
 func TestSyncUnauthorized(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -204,6 +224,27 @@ func TestSyncWithState(t *testing.T) {
 	}
 }
 
+// TestSyncTag verifies that a tag in the context replaces the default tag
+func TestSyncTag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload [][]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if tag := payload[0][2]; tag != "dovewarden-abc,def" {
+			t.Errorf("expected tag 'dovewarden-abc,def', got %v", tag)
+		}
+		_, _ = fmt.Fprintf(w, `[["error",{"type":"exitCode","exitCode":75},"dovewarden-abc,def"]]`)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "testpass")
+	_, err := client.Sync(WithSyncTag(context.Background(), "dovewarden-abc,def"), "test-user", "imap", "")
+	if want := "doveadm sync error (tag dovewarden-abc,def): exitCode (exitCode 75)"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+}
+
 // TestSyncWithoutState verifies that state parameter is omitted when empty
 func TestSyncWithoutState(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -243,3 +284,133 @@ func TestSyncWithoutState(t *testing.T) {
 		t.Errorf("expected empty state, got %s", resp.State)
 	}
 }
+
+// TestPing verifies the reachability check against the command listing endpoint
+func TestPing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/doveadm/v1" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if _, pass, ok := r.BasicAuth(); !ok || pass != "testpass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[{"command":"sync"}]`))
+	}))
+	defer server.Close()
+
+	if err := NewClient(server.URL, "testpass").Ping(context.Background()); err != nil {
+		t.Fatalf("expected ping to succeed, got %v", err)
+	}
+	if err := NewClient(server.URL, "wrong").Ping(context.Background()); err == nil {
+		t.Fatal("expected ping with wrong password to fail")
+	}
+}
+
+// TestSyncWarnings verifies that warnings are collected from both warning entries
+// and the warnings list of the response object
+func TestSyncWarnings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `[
+			["warning", {"type":"conflict","message":"conflicting changes","mailbox":"INBOX"}, "dovewarden-sync"],
+			["warning", "Mailbox Archive skipped", "dovewarden-sync"],
+			["doveadmResponse", {"state":"new-state","warnings":["something odd"]}, "dovewarden-sync"]
+		]`)
+	}))
+	defer server.Close()
+
+	resp, err := NewClient(server.URL, "testpass").Sync(context.Background(), "user@example.com", "imap", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.State != "new-state" {
+		t.Errorf("expected state new-state, got %q", resp.State)
+	}
+
+	want := []Warning{
+		{Type: WarningConflict, Message: "conflicting changes", Mailbox: "INBOX"},
+		{Type: WarningMailboxSkipped, Message: "Mailbox Archive skipped"},
+		{Type: WarningOther, Message: "something odd"},
+	}
+	if len(resp.Warnings) != len(want) {
+		t.Fatalf("expected %d warnings, got %+v", len(want), resp.Warnings)
+	}
+	for i := range want {
+		if resp.Warnings[i] != want[i] {
+			t.Errorf("warning %d: expected %+v, got %+v", i, want[i], resp.Warnings[i])
+		}
+	}
+}
+
+// TestSyncParams verifies that extra parameters of the destination are merged
+// into the payload without overriding the client's own parameters
+func TestSyncParams(t *testing.T) {
+	var params map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload []interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		params = payload[0].([]interface{})[1].(map[string]interface{})
+		_, _ = fmt.Fprint(w, `[["doveadmResponse",[{"state":"new-state"}],"dovewarden-sync"]]`)
+	}))
+	defer server.Close()
+
+	syncParams, err := ParseSyncParams(`{"imap": {"lockTimeout": 30}, "other": {"noop": true}}`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	client := NewClient(server.URL, "testpass")
+	client.SetSyncParams(syncParams)
+
+	if _, err := client.Sync(context.Background(), "test-user", "imap", "state"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if params["lockTimeout"] != float64(30) || params["state"] != "state" || params["destination"].([]interface{})[0] != "imap" {
+		t.Errorf("expected merged parameters, got %v", params)
+	}
+	if _, ok := params["noop"]; ok {
+		t.Errorf("expected parameters of other destinations to be left out, got %v", params)
+	}
+
+	for _, s := range []string{`{"imap": {"state": "x"}}`, `{"lockTimeout": 30}`, `[]`} {
+		if _, err := ParseSyncParams(s); err == nil {
+			t.Errorf("expected %s to be rejected", s)
+		}
+	}
+	if p, err := ParseSyncParams(""); err != nil || p != nil {
+		t.Errorf("expected no parameters for empty string, got %v, %v", p, err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for value, want := range map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"-5":                            0,
+		"soon":                          0,
+		"Fri, 02 Jan 2026 03:05:05 GMT": time.Minute,
+		"Fri, 02 Jan 2026 03:00:00 GMT": 0,
+	} {
+		if got := parseRetryAfter(value, now); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestSyncStatusErrorRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, "testpass").Sync(context.Background(), "user", "imap", "")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable || statusErr.RetryAfter != 30*time.Second {
+		t.Fatalf("expected a 503 status error with Retry-After, got %v", err)
+	}
+}
@@ -0,0 +1,328 @@
+// Package doveadmtest implements a fake doveadm HTTP API for end-to-end tests
+// and local development without a Dovecot pair. It supports the commands used
+// by dovewarden: sync, user and mailbox listing, mailbox status, who and the
+// command listing used as ping.
+// Failures and latency can be injected, and the syncs served are recorded.
+package doveadmtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config controls the injected failures and latency.
+type Config struct {
+	FailureRate   float64        `json:"failure_rate"`   // probability in [0, 1] of a sync failing
+	FailingUsers  []string       `json:"failing_users"`  // users whose syncs always fail
+	Latency       Duration       `json:"latency"`        // delay added to every sync
+	LatencyJitter Duration       `json:"latency_jitter"` // random extra delay of up to this duration
+	ExitCode      int            `json:"exit_code"`      // exit code reported for failed syncs, 75 (tempfail) if 0
+	Mailboxes     []string       `json:"mailboxes"`      // mailboxes listed for every user, only INBOX if empty
+	Sessions      map[string]int `json:"sessions"`       // IMAP connections reported by who per user
+	AccountSizes  map[string]int `json:"account_sizes"`  // bytes reported by mailbox status per user, in INBOX; one message per KiB
+}
+
+// Duration is a time.Duration encoded as a string like "250ms" in JSON.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Stats summarizes the syncs served per user.
+type Stats struct {
+	Syncs        map[string]int `json:"syncs"`         // successful syncs
+	Failures     map[string]int `json:"failures"`      // injected failures
+	MailboxSyncs map[string]int `json:"mailbox_syncs"` // successful single-mailbox syncs
+}
+
+// Server is a fake doveadm HTTP API. Besides /doveadm/v1 it serves a control
+// API: GET /fake/stats returns the recorded Stats, GET and PUT /fake/config
+// read and replace the Config, and POST /fake/reset clears the stats.
+type Server struct {
+	password string
+
+	mu       sync.Mutex
+	users    []string
+	config   Config
+	syncs    map[string]int
+	failures map[string]int
+	mboxes   map[string]int
+	mux      *http.ServeMux
+}
+
+// New creates a fake doveadm API accepting the given password and listing the
+// given users. Users that are synced are added to the listing.
+func New(password string, users []string) *Server {
+	s := &Server{
+		password: password,
+		users:    slices.Clone(users),
+		syncs:    make(map[string]int),
+		failures: make(map[string]int),
+		mboxes:   make(map[string]int),
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /doveadm/v1", s.handleCommandList)
+	s.mux.HandleFunc("POST /doveadm/v1", s.handleCommands)
+	s.mux.HandleFunc("GET /fake/stats", s.handleStats)
+	s.mux.HandleFunc("GET /fake/config", s.handleGetConfig)
+	s.mux.HandleFunc("PUT /fake/config", s.handlePutConfig)
+	s.mux.HandleFunc("POST /fake/reset", s.handleReset)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// SetConfig replaces the injected failures and latency.
+func (s *Server) SetConfig(cfg Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = cfg
+}
+
+// Stats returns a copy of the syncs served so far.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := Stats{
+		Syncs:        make(map[string]int, len(s.syncs)),
+		Failures:     make(map[string]int, len(s.failures)),
+		MailboxSyncs: make(map[string]int, len(s.mboxes)),
+	}
+	for user, n := range s.syncs {
+		stats.Syncs[user] = n
+	}
+	for user, n := range s.failures {
+		stats.Failures[user] = n
+	}
+	for user, n := range s.mboxes {
+		stats.MailboxSyncs[user] = n
+	}
+	return stats
+}
+
+// authorized checks the basic auth password, as doveadm does.
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if _, pass, ok := r.BasicAuth(); !ok || pass != s.password {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleCommandList serves the command listing, which dovewarden uses as ping.
+func (s *Server) handleCommandList(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	writeJSON(w, []map[string]any{
+		{"command": "sync", "parameters": []any{}},
+		{"command": "user", "parameters": []any{}},
+		{"command": "mailboxList", "parameters": []any{}},
+	})
+}
+
+// handleCommands runs a batch of [command, parameters, tag] entries.
+func (s *Server) handleCommands(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	var commands [][]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&commands); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	responses := make([]any, 0, len(commands))
+	for _, cmd := range commands {
+		if len(cmd) != 3 {
+			http.Error(w, "invalid command", http.StatusBadRequest)
+			return
+		}
+		var name, tag string
+		var params map[string]any
+		if json.Unmarshal(cmd[0], &name) != nil || json.Unmarshal(cmd[1], &params) != nil || json.Unmarshal(cmd[2], &tag) != nil {
+			http.Error(w, "invalid command", http.StatusBadRequest)
+			return
+		}
+		switch name {
+		case "sync":
+			responses = append(responses, s.sync(params, tag))
+		case "user":
+			responses = append(responses, []any{"doveadmResponse", map[string]any{"userList": s.listUsers()}, tag})
+		case "mailboxList":
+			responses = append(responses, []any{"doveadmResponse", s.listMailboxes(), tag})
+		case "mailboxStatus":
+			responses = append(responses, []any{"doveadmResponse", s.mailboxStatus(params), tag})
+		case "who":
+			responses = append(responses, []any{"doveadmResponse", s.who(params), tag})
+		default:
+			responses = append(responses, []any{"error", map[string]any{"type": "unknownCommand", "exitCode": 64}, tag})
+		}
+	}
+	writeJSON(w, responses)
+}
+
+// sync simulates a dsync run. The returned state encodes the user and the
+// number of its syncs, so that incremental syncs can be told apart. Syncs of
+// a single mailbox are counted separately and return no state.
+func (s *Server) sync(params map[string]any, tag string) []any {
+	username, _ := params["user"].(string)
+	if username == "" {
+		return []any{"error", map[string]any{"type": "exitCode", "exitCode": 64}, tag}
+	}
+
+	s.mu.Lock()
+	cfg := s.config
+	s.mu.Unlock()
+
+	delay := time.Duration(cfg.Latency)
+	if cfg.LatencyJitter > 0 {
+		delay += rand.N(time.Duration(cfg.LatencyJitter))
+	}
+	time.Sleep(delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing(cfg, username) {
+		s.failures[username]++
+		exitCode := cfg.ExitCode
+		if exitCode == 0 {
+			exitCode = 75
+		}
+		return []any{"error", map[string]any{"type": "exitCode", "exitCode": exitCode}, tag}
+	}
+
+	if mailbox, _ := params["mailbox"].(string); mailbox != "" {
+		s.mboxes[username]++
+		return []any{"doveadmResponse", []map[string]any{}, tag}
+	}
+	if _, known := s.syncs[username]; !known && !slices.Contains(s.users, username) {
+		s.users = append(s.users, username)
+	}
+	s.syncs[username]++
+	state := base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "%s:%d", username, s.syncs[username]))
+	return []any{"doveadmResponse", []map[string]any{{"state": state}}, tag}
+}
+
+// failing decides whether a sync of the user fails. The caller must hold the lock.
+func (s *Server) failing(cfg Config, username string) bool {
+	if slices.Contains(cfg.FailingUsers, username) {
+		return true
+	}
+	return cfg.FailureRate > 0 && rand.Float64() < cfg.FailureRate
+}
+
+// listUsers returns the known users in sorted order.
+func (s *Server) listUsers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := slices.Clone(s.users)
+	slices.Sort(users)
+	return users
+}
+
+// listMailboxes returns the configured mailboxes in the doveadm response format.
+func (s *Server) listMailboxes() []map[string]any {
+	s.mu.Lock()
+	mailboxes := s.config.Mailboxes
+	s.mu.Unlock()
+	if len(mailboxes) == 0 {
+		mailboxes = []string{"INBOX"}
+	}
+	list := make([]map[string]any, len(mailboxes))
+	for i, name := range mailboxes {
+		list[i] = map[string]any{"mailbox": name}
+	}
+	return list
+}
+
+// mailboxStatus returns the configured size of the user in the doveadm
+// response format, as a single INBOX.
+func (s *Server) mailboxStatus(params map[string]any) []map[string]any {
+	username, _ := params["user"].(string)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := s.config.AccountSizes[username]
+	return []map[string]any{{"mailbox": "INBOX", "messages": strconv.Itoa(size / 1024), "vsize": strconv.Itoa(size)}}
+}
+
+// who returns the configured sessions of the users matching the mask in the
+// doveadm response format. Masks are matched literally.
+func (s *Server) who(params map[string]any) []map[string]any {
+	mask, _ := params["mask"].([]any)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []map[string]any{}
+	for _, m := range mask {
+		username, _ := m.(string)
+		if n := s.config.Sessions[username]; n > 0 {
+			list = append(list, map[string]any{"username": username, "connections": strconv.Itoa(n), "service": "imap"})
+		}
+	}
+	return list
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Stats())
+}
+
+func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	cfg := s.config
+	s.mu.Unlock()
+	writeJSON(w, cfg)
+}
+
+func (s *Server) handlePutConfig(w http.ResponseWriter, r *http.Request) {
+	var cfg Config
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cfg.FailureRate < 0 || cfg.FailureRate > 1 {
+		http.Error(w, "failure_rate must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	s.SetConfig(cfg)
+	writeJSON(w, cfg)
+}
+
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.syncs = make(map[string]int)
+	s.failures = make(map[string]int)
+	s.mboxes = make(map[string]int)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,77 @@
+package doveadmtest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+)
+
+func TestServer(t *testing.T) {
+	fake := New("secret", []string{"bob"})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.Background()
+	client := doveadm.NewClient(srv.URL, "secret")
+
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	if err := doveadm.NewClient(srv.URL, "wrong").Ping(ctx); err == nil {
+		t.Fatal("expected ping with the wrong password to fail")
+	}
+
+	full, err := client.Sync(ctx, "alice", "imap", "")
+	if err != nil {
+		t.Fatalf("full sync: %v", err)
+	}
+	incremental, err := client.Sync(ctx, "alice", "imap", full.State)
+	if err != nil {
+		t.Fatalf("incremental sync: %v", err)
+	}
+	if full.State == "" || incremental.State == full.State {
+		t.Fatalf("expected a new state per sync, got %q and %q", full.State, incremental.State)
+	}
+
+	users, err := client.ListUsers(ctx)
+	if err != nil {
+		t.Fatalf("list users: %v", err)
+	}
+	if len(users) != 2 || users[0].Username != "alice" || users[1].Username != "bob" {
+		t.Fatalf("expected configured and synced users, got %+v", users)
+	}
+
+	fake.SetConfig(Config{FailingUsers: []string{"alice"}, Latency: Duration(10 * time.Millisecond)})
+	start := time.Now()
+	if _, err := client.Sync(ctx, "alice", "imap", incremental.State); err == nil {
+		t.Fatal("expected sync of failing user to fail")
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Fatalf("expected injected latency, sync took %v", elapsed)
+	}
+	if _, err := client.Sync(ctx, "bob", "imap", ""); err != nil {
+		t.Fatalf("expected sync of other users to succeed, got %v", err)
+	}
+
+	stats := fake.Stats()
+	if stats.Syncs["alice"] != 2 || stats.Failures["alice"] != 1 || stats.Syncs["bob"] != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	fake.SetConfig(Config{Sessions: map[string]int{"alice": 2}})
+	sessions, err := client.Who(ctx, "alice")
+	if err != nil || len(sessions) != 1 || sessions[0].Connections != 2 {
+		t.Fatalf("expected 2 connections of alice, got %+v, %v", sessions, err)
+	}
+	if sessions, err := client.Who(ctx, "bob"); err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no sessions of bob, got %+v, %v", sessions, err)
+	}
+
+	fake.SetConfig(Config{AccountSizes: map[string]int{"alice": 4096}})
+	if size, err := client.AccountSize(ctx, "alice"); err != nil || size != (doveadm.AccountSize{Messages: 4, Bytes: 4096}) {
+		t.Fatalf("expected 4 KiB in 4 messages, got %+v, %v", size, err)
+	}
+}
@@ -0,0 +1,74 @@
+package doveadm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestListMailboxes verifies that mailbox names are extracted from the response
+func TestListMailboxes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload [][]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+			return
+		}
+		if payload[0][0] != "mailboxList" {
+			t.Errorf("expected mailboxList command, got %v", payload[0][0])
+		}
+		params := payload[0][1].(map[string]interface{})
+		if params["user"] != "user-a" {
+			t.Errorf("expected user-a, got %v", params["user"])
+		}
+		_, _ = fmt.Fprintf(w, `[["doveadmResponse",[{"mailbox":"INBOX"},{"mailbox":"Sent"},{"mailbox":"Archive/2024"}],"dovewarden-list-mailboxes"]]`)
+	}))
+	defer server.Close()
+
+	mailboxes, err := NewClient(server.URL, "testpass").ListMailboxes(context.Background(), "user-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mailboxes) != 3 || mailboxes[0] != "INBOX" || mailboxes[2] != "Archive/2024" {
+		t.Fatalf("unexpected mailboxes %v", mailboxes)
+	}
+}
+
+// TestListMailboxesError verifies that doveadm errors are returned
+func TestListMailboxesError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `[["error",{"type":"exitCode","exitCode":67},"dovewarden-list-mailboxes"]]`)
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(server.URL, "testpass").ListMailboxes(context.Background(), "nobody"); err == nil {
+		t.Fatal("expected error for unknown user")
+	}
+}
+
+// TestSyncMailboxPayload verifies that mailbox syncs name the mailbox and carry no state
+func TestSyncMailboxPayload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload [][]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+			return
+		}
+		params := payload[0][1].(map[string]interface{})
+		if params["mailbox"] != "Sent" || params["user"] != "user-a" {
+			t.Errorf("unexpected params %v", params)
+		}
+		if _, ok := params["state"]; ok {
+			t.Errorf("mailbox sync must not send a state, got %v", params["state"])
+		}
+		_, _ = fmt.Fprintf(w, `[["doveadmResponse",[{"state":"mailbox-state"}],"dovewarden-sync"]]`)
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(server.URL, "testpass").SyncMailbox(context.Background(), "user-a", "imap", "Sent"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package doveadm
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Probe periodically pings the Doveadm API and tracks whether it is reachable.
+// The API counts as unreachable until the first successful ping and after
+// failureThreshold consecutive failed pings, so single hiccups do not flap.
+type Probe struct {
+	client           *Client
+	logger           *slog.Logger
+	interval         time.Duration
+	failureThreshold int
+
+	mu                  sync.Mutex
+	reachable           bool
+	consecutiveFailures int
+	lastErr             error
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewProbe creates a reachability probe for the given client.
+func NewProbe(client *Client, logger *slog.Logger, interval time.Duration, failureThreshold int) *Probe {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	return &Probe{
+		client:           client,
+		logger:           logger,
+		interval:         interval,
+		failureThreshold: failureThreshold,
+		stopCh:           make(chan struct{}),
+		doneCh:           make(chan struct{}),
+	}
+}
+
+// Start pings once immediately and then every interval until Stop is called.
+func (p *Probe) Start(ctx context.Context) {
+	go func() {
+		defer close(p.doneCh)
+
+		p.check(ctx)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stopCh:
+				return
+			case <-ticker.C:
+				p.check(ctx)
+			}
+		}
+	}()
+}
+
+// Stop terminates the probe loop.
+func (p *Probe) Stop(ctx context.Context) error {
+	close(p.stopCh)
+
+	select {
+	case <-p.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reachable reports whether the Doveadm API is currently considered reachable.
+func (p *Probe) Reachable() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reachable
+}
+
+// LastError returns the error of the most recent failed ping, or nil.
+func (p *Probe) LastError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastErr
+}
+
+func (p *Probe) check(ctx context.Context) {
+	pingCtx, cancel := context.WithTimeout(ctx, p.interval)
+	err := p.client.Ping(pingCtx)
+	cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		if !p.reachable {
+			p.logger.Info("Doveadm API reachable")
+		}
+		p.reachable = true
+		p.consecutiveFailures = 0
+		p.lastErr = nil
+		return
+	}
+
+	p.consecutiveFailures++
+	p.lastErr = err
+	p.logger.Warn("Doveadm API ping failed", "consecutive_failures", p.consecutiveFailures, "error", err)
+	if p.reachable && p.consecutiveFailures >= p.failureThreshold {
+		p.logger.Error("Doveadm API unreachable, reporting not ready", "consecutive_failures", p.consecutiveFailures)
+		p.reachable = false
+	}
+}
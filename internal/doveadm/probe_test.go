@@ -0,0 +1,54 @@
+package doveadm
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestProbeThreshold verifies that the probe only flips to unreachable after
+// the configured number of consecutive failures
+func TestProbeThreshold(t *testing.T) {
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	p := NewProbe(NewClient(server.URL, "testpass"), logger, time.Second, 2)
+	ctx := context.Background()
+
+	p.check(ctx)
+	if p.Reachable() {
+		t.Fatal("expected probe to be unreachable before the first successful ping")
+	}
+
+	healthy.Store(true)
+	p.check(ctx)
+	if !p.Reachable() {
+		t.Fatal("expected probe to be reachable after a successful ping")
+	}
+
+	healthy.Store(false)
+	p.check(ctx)
+	if !p.Reachable() {
+		t.Fatal("expected a single failure to be tolerated")
+	}
+	p.check(ctx)
+	if p.Reachable() {
+		t.Fatal("expected probe to be unreachable after reaching the failure threshold")
+	}
+	if p.LastError() == nil {
+		t.Fatal("expected last error to be recorded")
+	}
+}
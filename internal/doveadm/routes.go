@@ -0,0 +1,93 @@
+package doveadm
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Route sends the syncs of the users it matches to another doveadm endpoint,
+// e.g. of another cluster. A route matches users of Domain, the part after the
+// last "@" compared case-insensitively, or usernames matching the regular
+// expression Pattern; exactly one of them must be set.
+type Route struct {
+	Domain   string `json:"domain,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+	URL      string `json:"url"`
+	Password string `json:"password"`
+}
+
+// ParseRoutes parses a JSON array of routes, e.g.
+// [{"domain": "example.org", "url": "http://doveadm-b:8080", "password": "secret"}].
+// An empty string yields no routes.
+func ParseRoutes(s string) ([]Route, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var routes []Route
+	if err := json.Unmarshal([]byte(s), &routes); err != nil {
+		return nil, fmt.Errorf("routes must be a JSON array: %w", err)
+	}
+	return routes, nil
+}
+
+// Router selects the doveadm endpoint of a user from a static routing table.
+type Router struct {
+	routes   []compiledRoute
+	fallback *Client
+}
+
+type compiledRoute struct {
+	domain  string
+	pattern *regexp.Regexp
+	client  *Client
+}
+
+// NewRouter creates a router for the routes, checked in order. Users matched
+// by no route are handled by fallback. The clients of the routes use the HTTP
+// client and sync parameters of fallback with their own URL and password.
+func NewRouter(fallback *Client, routes []Route) (*Router, error) {
+	r := &Router{fallback: fallback}
+	for i, route := range routes {
+		if route.URL == "" {
+			return nil, fmt.Errorf("route %d: url is required", i+1)
+		}
+		if (route.Domain == "") == (route.Pattern == "") {
+			return nil, fmt.Errorf("route %d: exactly one of domain and pattern is required", i+1)
+		}
+		compiled := compiledRoute{domain: strings.ToLower(route.Domain)}
+		if route.Pattern != "" {
+			pattern, err := regexp.Compile(route.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: invalid pattern: %w", i+1, err)
+			}
+			compiled.pattern = pattern
+		}
+		client := fallback.WithBaseURL(route.URL)
+		client.password = new(atomic.Pointer[string])
+		client.SetPassword(route.Password)
+		compiled.client = client
+		r.routes = append(r.routes, compiled)
+	}
+	return r, nil
+}
+
+// Client returns the client for the doveadm endpoint of the user.
+func (r *Router) Client(username string) *Client {
+	domain := ""
+	if i := strings.LastIndex(username, "@"); i >= 0 {
+		domain = strings.ToLower(username[i+1:])
+	}
+	for _, route := range r.routes {
+		if route.pattern != nil {
+			if route.pattern.MatchString(username) {
+				return route.client
+			}
+		} else if route.domain == domain {
+			return route.client
+		}
+	}
+	return r.fallback
+}
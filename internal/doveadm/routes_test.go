@@ -0,0 +1,52 @@
+package doveadm
+
+import (
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	routes, err := ParseRoutes(`[
+		{"domain": "Example.org", "url": "http://doveadm-b:8080", "password": "secret-b"},
+		{"pattern": "^archive-", "url": "http://doveadm-c:8080", "password": "secret-c"}
+	]`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	fallback := NewClient("http://doveadm-a:8080", "secret-a")
+	r, err := NewRouter(fallback, routes)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+
+	for username, want := range map[string]string{
+		"alice@example.org":     "http://doveadm-b:8080",
+		"archive-1@example.org": "http://doveadm-b:8080",
+		"archive-1@example.com": "http://doveadm-c:8080",
+		"bob@example.com":       "http://doveadm-a:8080",
+		"carol":                 "http://doveadm-a:8080",
+	} {
+		if got := r.Client(username).BaseURL(); got != want {
+			t.Errorf("%s: expected %s, got %s", username, want, got)
+		}
+	}
+
+	routed := r.Client("alice@example.org")
+	fallback.SetPassword("rotated")
+	if got := *routed.password.Load(); got != "secret-b" {
+		t.Fatalf("expected the route to keep its own password, got %q", got)
+	}
+
+	for _, invalid := range [][]Route{
+		{{Domain: "example.org"}},
+		{{URL: "http://doveadm-b:8080"}},
+		{{Domain: "example.org", Pattern: "x", URL: "http://doveadm-b:8080"}},
+		{{Pattern: "(", URL: "http://doveadm-b:8080"}},
+	} {
+		if _, err := NewRouter(fallback, invalid); err == nil {
+			t.Errorf("expected %+v to be rejected", invalid)
+		}
+	}
+	if _, err := ParseRoutes(`{"domain": "example.org"}`); err == nil {
+		t.Error("expected a JSON object to be rejected")
+	}
+}
@@ -0,0 +1,105 @@
+package doveadm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// AccountSize is the size of all mailboxes of a user.
+type AccountSize struct {
+	Messages int64
+	Bytes    int64 // virtual size, as reported by doveadm mailbox status vsize
+}
+
+// AccountSize sums the message counts and sizes of all mailboxes of a user
+// (doveadm mailbox status messages vsize '*').
+func (c *Client) AccountSize(ctx context.Context, username string) (AccountSize, error) {
+	// [["mailboxStatus",{"user":"$username","field":["messages","vsize"],"mailboxMask":["*"]},"tag1"]]
+	params := map[string]interface{}{
+		"user":        username,
+		"field":       []string{"messages", "vsize"},
+		"mailboxMask": []string{"*"},
+	}
+
+	payload := []interface{}{
+		[]interface{}{
+			"mailboxStatus",
+			params,
+			"dovewarden-mailbox-status",
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return AccountSize{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/doveadm/v1", bytes.NewReader(body))
+	if err != nil {
+		return AccountSize{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("doveadm", *c.password.Load())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return AccountSize{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return AccountSize{}, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return AccountSize{}, fmt.Errorf("doveadm mailbox status failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var respPayload []responseEntry
+	if err := json.Unmarshal(respBody, &respPayload); err != nil {
+		return AccountSize{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var size AccountSize
+	for _, entry := range respPayload {
+		if entry.Status == "error" {
+			if entry.Error != nil {
+				return AccountSize{}, fmt.Errorf("doveadm mailbox status error (tag %s): %s (exitCode %d)", entry.Tag, entry.Error.Type, entry.Error.ExitCode)
+			}
+			return AccountSize{}, fmt.Errorf("doveadm mailbox status error (tag %s): unknown reason", entry.Tag)
+		}
+
+		// Response contains [{"messages":"12","vsize":"34567"}, ...], one per mailbox
+		items := entry.ResponseList
+		if entry.Response != nil {
+			items = append(items, entry.Response)
+		}
+		for _, item := range items {
+			size.Messages += statusNumber(item["messages"])
+			size.Bytes += statusNumber(item["vsize"])
+		}
+	}
+	return size, nil
+}
+
+// statusNumber parses a numeric status field, which most Dovecot versions
+// report as a string.
+func statusNumber(v interface{}) int64 {
+	switch n := v.(type) {
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+package doveadm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAccountSize verifies that the sizes of all mailboxes are summed up
+func TestAccountSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload [][]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+			return
+		}
+		if payload[0][0] != "mailboxStatus" {
+			t.Errorf("expected mailboxStatus command, got %v", payload[0][0])
+		}
+		params := payload[0][1].(map[string]interface{})
+		if params["user"] != "user-a" {
+			t.Errorf("expected user-a, got %v", params["user"])
+		}
+		_, _ = fmt.Fprintf(w, `[["doveadmResponse",[{"messages":"10","vsize":"2048"},{"messages":5,"vsize":1024}],"dovewarden-mailbox-status"]]`)
+	}))
+	defer server.Close()
+
+	size, err := NewClient(server.URL, "testpass").AccountSize(context.Background(), "user-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size != (AccountSize{Messages: 15, Bytes: 3072}) {
+		t.Fatalf("unexpected size %+v", size)
+	}
+}
+
+// TestAccountSizeError verifies that doveadm errors are returned
+func TestAccountSizeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `[["error",{"type":"exitCode","exitCode":67},"dovewarden-mailbox-status"]]`)
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(server.URL, "testpass").AccountSize(context.Background(), "nobody"); err == nil {
+		t.Fatal("expected error for unknown user")
+	}
+}
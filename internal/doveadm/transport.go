@@ -0,0 +1,44 @@
+package doveadm
+
+import (
+	"net/http"
+	"time"
+)
+
+// TransportConfig tunes the connection pooling of the HTTP client used for the
+// Doveadm API.
+type TransportConfig struct {
+	// MaxIdleConns bounds the idle connections kept across all hosts; 0 means
+	// no limit.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost bounds the idle connections kept per host. It should
+	// be at least the number of concurrent syncs, otherwise connections are
+	// closed after every request and reopened by the next one.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds all connections per host, including active ones;
+	// requests beyond it wait for a connection. 0 means no limit.
+	MaxConnsPerHost int
+	// IdleConnTimeout closes connections idle for this long; 0 means no limit.
+	IdleConnTimeout time.Duration
+	// HTTP2 negotiates HTTP/2 with HTTPS endpoints, multiplexing all requests
+	// to a host over a single connection.
+	HTTP2 bool
+}
+
+// NewHTTPClient returns an HTTP client with a transport tuned by cfg, to be
+// shared by all Doveadm API clients with Client.SetHTTPClient.
+func NewHTTPClient(cfg TransportConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(cfg.HTTP2)
+	transport.Protocols = &protocols
+	transport.ForceAttemptHTTP2 = cfg.HTTP2
+
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,53 @@
+package doveadm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	hc := NewHTTPClient(TransportConfig{MaxIdleConns: 10, MaxIdleConnsPerHost: 4, MaxConnsPerHost: 8, IdleConnTimeout: time.Minute})
+	transport := hc.Transport.(*http.Transport)
+	if transport.MaxIdleConns != 10 || transport.MaxIdleConnsPerHost != 4 || transport.MaxConnsPerHost != 8 || transport.IdleConnTimeout != time.Minute {
+		t.Fatalf("unexpected transport settings %+v", transport)
+	}
+	if transport.Protocols.HTTP2() {
+		t.Fatal("expected HTTP/2 to be disabled by default")
+	}
+	if hc.Transport == http.DefaultTransport {
+		t.Fatal("expected a transport of its own")
+	}
+}
+
+// TestHTTPClientReusesConnections verifies that sequential requests of a
+// client share one keep-alive connection
+func TestHTTPClientReusesConnections(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[["doveadmResponse",[{"state":"new-state"}],"dovewarden-sync"]]`)
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := NewClient(server.URL, "testpass")
+	client.SetHTTPClient(NewHTTPClient(TransportConfig{MaxIdleConnsPerHost: 2, IdleConnTimeout: time.Minute}))
+	for range 5 {
+		if _, err := client.Sync(context.Background(), "user", "imap", ""); err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+	}
+	if got := conns.Load(); got != 1 {
+		t.Fatalf("expected 1 connection, got %d", got)
+	}
+}
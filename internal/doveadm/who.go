@@ -0,0 +1,103 @@
+package doveadm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Session is an entry of doveadm who: the connections of a user to a service,
+// e.g. IMAP.
+type Session struct {
+	Username    string
+	Service     string
+	Connections int
+}
+
+// Who returns the sessions of a user logged in to Dovecot (doveadm who). A
+// user without sessions has none.
+func (c *Client) Who(ctx context.Context, username string) ([]Session, error) {
+	// [["who",{"mask":["$username"]},"tag1"]]
+	params := map[string]interface{}{
+		"mask": []string{username},
+	}
+
+	payload := []interface{}{
+		[]interface{}{
+			"who",
+			params,
+			"dovewarden-who",
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/doveadm/v1", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("doveadm", *c.password.Load())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("doveadm who failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var respPayload []responseEntry
+	if err := json.Unmarshal(respBody, &respPayload); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var sessions []Session
+	for _, entry := range respPayload {
+		if entry.Status == "error" {
+			if entry.Error != nil {
+				return nil, fmt.Errorf("doveadm who error (tag %s): %s (exitCode %d)", entry.Tag, entry.Error.Type, entry.Error.ExitCode)
+			}
+			return nil, fmt.Errorf("doveadm who error (tag %s): unknown reason", entry.Tag)
+		}
+
+		// Response contains [{"username":"u","connections":"2","service":"imap","pids":"(..)","ips":"(..)"}, ...]
+		items := entry.ResponseList
+		if entry.Response != nil {
+			items = append(items, entry.Response)
+		}
+		for _, item := range items {
+			session := Session{}
+			session.Username, _ = item["username"].(string)
+			session.Service, _ = item["service"].(string)
+			// connections are reported as a string by most Dovecot versions
+			switch n := item["connections"].(type) {
+			case string:
+				session.Connections, _ = strconv.Atoi(n)
+			case float64:
+				session.Connections = int(n)
+			}
+			if session.Username != "" {
+				sessions = append(sessions, session)
+			}
+		}
+	}
+	return sessions, nil
+}
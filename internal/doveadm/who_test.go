@@ -0,0 +1,51 @@
+package doveadm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWho verifies that the sessions of a user are extracted from the response
+func TestWho(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload [][]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+			return
+		}
+		if payload[0][0] != "who" {
+			t.Errorf("expected who command, got %v", payload[0][0])
+		}
+		params := payload[0][1].(map[string]interface{})
+		if mask, _ := params["mask"].([]interface{}); len(mask) != 1 || mask[0] != "user-a" {
+			t.Errorf("expected mask user-a, got %v", params["mask"])
+		}
+		_, _ = fmt.Fprintf(w, `[["doveadmResponse",[{"username":"user-a","connections":"3","service":"imap","pids":"(1 2 3)","ips":"(10.0.0.1)"},{"username":"user-a","connections":1,"service":"submission"}],"dovewarden-who"]]`)
+	}))
+	defer server.Close()
+
+	sessions, err := NewClient(server.URL, "testpass").Who(context.Background(), "user-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0] != (Session{Username: "user-a", Service: "imap", Connections: 3}) || sessions[1].Connections != 1 {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+}
+
+// TestWhoWithoutSessions verifies that an empty response yields no sessions
+func TestWhoWithoutSessions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `[["doveadmResponse",[],"dovewarden-who"]]`)
+	}))
+	defer server.Close()
+
+	sessions, err := NewClient(server.URL, "testpass").Who(context.Background(), "user-a")
+	if err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no sessions, got %+v, %v", sessions, err)
+	}
+}
@@ -0,0 +1,181 @@
+// Package errreport sends unexpected failures, such as panics, repeated sync
+// failures and queue backend errors, to an error tracker, so that systemic
+// problems are noticed without tailing logs. Reports are sampled and
+// deduplicated, and delivered asynchronously so that reporting never blocks
+// or fails the caller.
+package errreport
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Kinds of reported failures.
+const (
+	KindPanic           = "panic"
+	KindHandlerFailures = "handler_failures" // many consecutive failed syncs
+	KindQueueError      = "queue_error"      // the queue backend failed
+)
+
+// queueSize bounds the reports waiting for delivery; further ones are dropped.
+const queueSize = 64
+
+// Event is a reported failure.
+type Event struct {
+	Kind      string            `json:"kind"`
+	Message   string            `json:"message"`
+	Error     string            `json:"error,omitempty"`
+	Stack     string            `json:"stack,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Sink delivers events to an error tracker.
+type Sink interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// Options configures a Reporter.
+type Options struct {
+	SampleRate  float64       // share of events sent, in (0, 1]
+	DedupWindow time.Duration // events of the same kind and message are sent at most once per window; 0 disables
+	Tags        map[string]string
+}
+
+// Reporter samples, deduplicates and delivers events to its sinks. A nil
+// *Reporter discards all events, so callers need not check whether error
+// reporting is enabled.
+type Reporter struct {
+	sinks  []Sink
+	opts   Options
+	logger *slog.Logger
+	events chan Event
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	wg       sync.WaitGroup
+}
+
+// NewReporter creates a reporter delivering to the given sinks. Call Run to
+// start delivery.
+func NewReporter(opts Options, logger *slog.Logger, sinks ...Sink) *Reporter {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	return &Reporter{
+		sinks:    sinks,
+		opts:     opts,
+		logger:   logger,
+		events:   make(chan Event, queueSize),
+		lastSent: make(map[string]time.Time),
+	}
+}
+
+// Report queues an event for delivery, unless it is sampled out or a
+// duplicate within the dedup window.
+func (r *Reporter) Report(e Event) {
+	if r == nil {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	if r.opts.SampleRate < 1 && rand.Float64() >= r.opts.SampleRate {
+		return
+	}
+	if r.opts.DedupWindow > 0 {
+		key := e.Kind + "\x00" + e.Message
+		r.mu.Lock()
+		if last, ok := r.lastSent[key]; ok && e.Timestamp.Sub(last) < r.opts.DedupWindow {
+			r.mu.Unlock()
+			return
+		}
+		r.lastSent[key] = e.Timestamp
+		r.mu.Unlock()
+	}
+	if len(r.opts.Tags) > 0 {
+		tags := make(map[string]string, len(r.opts.Tags)+len(e.Tags))
+		for k, v := range r.opts.Tags {
+			tags[k] = v
+		}
+		for k, v := range e.Tags {
+			tags[k] = v
+		}
+		e.Tags = tags
+	}
+
+	r.wg.Add(1)
+	select {
+	case r.events <- e:
+	default:
+		r.wg.Done()
+		r.logger.Warn("Error report queue full, dropping report", "kind", e.Kind)
+	}
+}
+
+// ReportError reports err as an event of the given kind.
+func (r *Reporter) ReportError(kind, message string, err error, tags map[string]string) {
+	if r == nil || err == nil {
+		return
+	}
+	r.Report(Event{Kind: kind, Message: message, Error: err.Error(), Tags: tags})
+}
+
+// ReportPanic reports a recovered panic value with the current stack. Call it
+// from the deferred function that recovered.
+func (r *Reporter) ReportPanic(recovered any, tags map[string]string) {
+	if r == nil {
+		return
+	}
+	r.Report(Event{
+		Kind:    KindPanic,
+		Message: fmt.Sprint(recovered),
+		Stack:   string(debug.Stack()),
+		Tags:    tags,
+	})
+}
+
+// Run delivers queued events until ctx is cancelled.
+func (r *Reporter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-r.events:
+			r.send(ctx, e)
+		}
+	}
+}
+
+// Flush waits until all queued events were delivered or ctx expires, e.g.
+// before exiting after a panic.
+func (r *Reporter) Flush(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func (r *Reporter) send(ctx context.Context, e Event) {
+	defer r.wg.Done()
+	sendCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	for _, sink := range r.sinks {
+		if err := sink.Send(sendCtx, e); err != nil {
+			r.logger.Warn("Failed to send error report", "kind", e.Kind, "error", err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package errreport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *recordingSink) Send(ctx context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *recordingSink) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.events)
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestReporterDeduplicates(t *testing.T) {
+	sink := &recordingSink{}
+	r := NewReporter(Options{DedupWindow: time.Minute, Tags: map[string]string{"version": "1.0"}}, discardLogger(), sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	r.ReportError(KindQueueError, "failed to dequeue", errors.New("connection refused"), nil)
+	r.ReportError(KindQueueError, "failed to dequeue", errors.New("connection refused"), nil)
+	r.ReportError(KindQueueError, "failed to enqueue event", errors.New("connection refused"), nil)
+
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), time.Second)
+	defer flushCancel()
+	r.Flush(flushCtx)
+
+	if got := sink.len(); got != 2 {
+		t.Fatalf("expected 2 reports after deduplication, got %d", got)
+	}
+	if sink.events[0].Tags["version"] != "1.0" {
+		t.Errorf("expected global tags to be attached, got %v", sink.events[0].Tags)
+	}
+}
+
+func TestNilReporterDiscards(t *testing.T) {
+	var r *Reporter
+	r.ReportError(KindQueueError, "failed", errors.New("boom"), nil)
+	r.ReportPanic("boom", nil)
+	r.Flush(context.Background())
+}
+
+func TestSentrySinkSendsEnvelope(t *testing.T) {
+	var (
+		gotPath, gotAuth string
+		lines            []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("X-Sentry-Auth")
+		body, _ := io.ReadAll(r.Body)
+		lines = strings.Split(strings.TrimSpace(string(body)), "\n")
+	}))
+	defer srv.Close()
+
+	dsn := strings.Replace(srv.URL, "http://", "http://publickey@", 1) + "/42"
+	sink, err := NewSentrySink(dsn, "prod", "1.0")
+	if err != nil {
+		t.Fatalf("NewSentrySink: %v", err)
+	}
+	err = sink.Send(context.Background(), Event{Kind: KindPanic, Message: "boom", Stack: "goroutine 1", Timestamp: time.Now()})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if gotPath != "/api/42/envelope/" {
+		t.Errorf("unexpected path %q", gotPath)
+	}
+	if !strings.Contains(gotAuth, "sentry_key=publickey") {
+		t.Errorf("unexpected auth header %q", gotAuth)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("expected envelope of 3 lines, got %d", len(lines))
+	}
+	var event map[string]any
+	if err := json.Unmarshal([]byte(lines[2]), &event); err != nil {
+		t.Fatalf("invalid event: %v", err)
+	}
+	if event["level"] != "fatal" || event["environment"] != "prod" {
+		t.Errorf("unexpected event %v", event)
+	}
+}
+
+func TestNewSentrySinkRejectsInvalidDSN(t *testing.T) {
+	for _, dsn := range []string{"https://sentry.example.com/1", "https://key@sentry.example.com/"} {
+		if _, err := NewSentrySink(dsn, "", ""); err == nil {
+			t.Errorf("expected error for %q", dsn)
+		}
+	}
+}
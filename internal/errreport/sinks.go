@@ -0,0 +1,155 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebhookSink posts every event as JSON to a URL.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink creates a sink posting events to url.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{}}
+}
+
+// Send implements Sink.
+func (s *WebhookSink) Send(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return post(ctx, s.client, s.url, "application/json", body, nil)
+}
+
+// SentrySink sends events to Sentry using its envelope endpoint.
+type SentrySink struct {
+	endpoint    string
+	publicKey   string
+	dsn         string
+	environment string
+	release     string
+	client      *http.Client
+}
+
+// NewSentrySink creates a sink from a Sentry DSN of the form
+// https://<public key>@<host>/<project id>.
+func NewSentrySink(dsn, environment, release string) (*SentrySink, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: missing public key")
+	}
+	path := strings.Trim(u.Path, "/")
+	idx := strings.LastIndex(path, "/")
+	prefix, project := "", path
+	if idx >= 0 {
+		prefix, project = "/"+path[:idx], path[idx+1:]
+	}
+	if project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: missing project ID")
+	}
+	return &SentrySink{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		publicKey:   u.User.Username(),
+		dsn:         dsn,
+		environment: environment,
+		release:     release,
+		client:      &http.Client{},
+	}, nil
+}
+
+// Send implements Sink.
+func (s *SentrySink) Send(ctx context.Context, e Event) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	eventID := hex.EncodeToString(id)
+
+	level := "error"
+	if e.Kind == KindPanic {
+		level = "fatal"
+	}
+	tags := map[string]string{"kind": e.Kind}
+	for k, v := range e.Tags {
+		tags[k] = v
+	}
+	event := map[string]any{
+		"event_id":    eventID,
+		"timestamp":   e.Timestamp.UTC().Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       level,
+		"logger":      "dovewarden",
+		"message":     map[string]string{"formatted": e.Message},
+		"tags":        tags,
+		"fingerprint": []string{e.Kind, e.Message},
+	}
+	if s.environment != "" {
+		event["environment"] = s.environment
+	}
+	if s.release != "" {
+		event["release"] = s.release
+	}
+	extra := map[string]string{}
+	if e.Error != "" {
+		extra["error"] = e.Error
+	}
+	if e.Stack != "" {
+		extra["stack"] = e.Stack
+	}
+	if len(extra) > 0 {
+		event["extra"] = extra
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, item := range []any{
+		map[string]string{"event_id": eventID, "dsn": s.dsn},
+		map[string]string{"type": "event"},
+		event,
+	} {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=dovewarden, sentry_key=%s", s.publicKey)
+	return post(ctx, s.client, s.endpoint, "application/x-sentry-envelope", body.Bytes(), map[string]string{"X-Sentry-Auth": auth})
+}
+
+func post(ctx context.Context, client *http.Client, url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
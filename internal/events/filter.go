@@ -3,20 +3,96 @@ package events
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/netip"
 	"strings"
+	"sync"
 )
 
 var (
-	ErrEmptyEvent       = errors.New("event field is empty")
-	ErrEmptyUsername    = errors.New("username field is empty")
-	ErrInvalidEventType = errors.New("event type not accepted by filter")
-	ErrInvalidCmdName   = errors.New("cmd_name not accepted by filter")
+	ErrEmptyEvent         = errors.New("event field is empty")
+	ErrEmptyUsername      = errors.New("username field is empty")
+	ErrInvalidEventType   = errors.New("event type not accepted by filter")
+	ErrInvalidCmdName     = errors.New("cmd_name not accepted by filter")
+	ErrSharedNamespace    = errors.New("mailbox is in an ignored shared or public namespace")
+	ErrSelfInduced        = errors.New("event was caused by a dovewarden sync")
+	ErrDeliveryFailed     = errors.New("mail delivery failed")
+	ErrInvalidSieveAction = errors.New("sieve action not accepted by filter")
+)
+
+// Stable reason codes describing why an event was rejected. They are part of the
+// events API and must not change once released.
+const (
+	ReasonInvalidJSON        = "invalid_json"
+	ReasonEmptyEvent         = "empty_event"
+	ReasonEmptyUsername      = "empty_username"
+	ReasonInvalidEventType   = "invalid_event_type"
+	ReasonInvalidCmdName     = "invalid_cmd_name"
+	ReasonSharedNamespace    = "shared_namespace"
+	ReasonSelfInduced        = "self_induced"
+	ReasonDeliveryFailed     = "delivery_failed"
+	ReasonInvalidSieveAction = "invalid_sieve_action"
+	ReasonUnknown            = "unknown"
+)
+
+// Reason maps an error returned by Filter to its stable reason code.
+func Reason(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, ErrEmptyEvent):
+		return ReasonEmptyEvent
+	case errors.Is(err, ErrEmptyUsername):
+		return ReasonEmptyUsername
+	case errors.Is(err, ErrInvalidEventType):
+		return ReasonInvalidEventType
+	case errors.Is(err, ErrInvalidCmdName):
+		return ReasonInvalidCmdName
+	case errors.Is(err, ErrSharedNamespace):
+		return ReasonSharedNamespace
+	case errors.Is(err, ErrSelfInduced):
+		return ReasonSelfInduced
+	case errors.Is(err, ErrDeliveryFailed):
+		return ReasonDeliveryFailed
+	case errors.Is(err, ErrInvalidSieveAction):
+		return ReasonInvalidSieveAction
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return ReasonInvalidJSON
+	default:
+		return ReasonUnknown
+	}
+}
+
+// Event types handled specially by the filter.
+const (
+	EventIMAPCommandFinished  = "imap_command_finished"
+	EventMailDeliveryFinished = "mail_delivery_finished"
+	EventSieveActionFinished  = "sieve_action_finished"
 )
 
 // AcceptedEvents is the list of event types that pass the filter.
 var AcceptedEvents = map[string]bool{
-	"imap_command_finished":  true,
-	"mail_delivery_finished": true,
+	EventIMAPCommandFinished:  true,
+	EventMailDeliveryFinished: true,
+	EventSieveActionFinished:  true,
+}
+
+// AcceptedSieveActions is the list of Sieve actions that should be queued. Sieve
+// runs during delivery and via IMAPSieve, so actions storing or moving messages
+// change mailboxes without an IMAP command. Events without an action name are
+// accepted.
+var AcceptedSieveActions = map[string]bool{
+	"addflag":    true,
+	"discard":    false,
+	"fileinto":   true,
+	"keep":       true,
+	"notify":     false,
+	"redirect":   true,
+	"reject":     false,
+	"removeflag": true,
+	"setflag":    true,
+	"vacation":   false,
 }
 
 // AcceptedIMAPCmdNames is the list of IMAP commands that should be queued.
@@ -67,13 +143,140 @@ var AcceptedIMAPCmdNames = map[string]bool{
 	"UNSUBSCRIBE":  true,
 }
 
+// IMAPCmdPriorities holds the priority factors of accepted IMAP commands that
+// should replicate later than new mail. Flag changes and expunges are frequent
+// and cheap to lose briefly on failover, so they yield to APPENDs and deliveries.
+// Commands not listed use DefaultPriority.
+var IMAPCmdPriorities = map[string]float64{
+	"STORE":       0.5,
+	"UID STORE":   0.5,
+	"EXPUNGE":     0.7,
+	"UID EXPUNGE": 0.7,
+	"CLOSE":       0.7,
+	"COPY":        0.8,
+	"UID COPY":    0.8,
+	"MOVE":        0.8,
+	"UID MOVE":    0.8,
+}
+
+// DefaultPriority is the priority factor of events without a specific priority.
+const DefaultPriority = 1.0
+
+// eventPriority returns the priority factor of an accepted event.
+func eventPriority(evt Event) float64 {
+	if evt.Event == EventIMAPCommandFinished {
+		if priority, ok := IMAPCmdPriorities[strings.ToUpper(evt.Fields.CmdName)]; ok {
+			return priority
+		}
+	}
+	return DefaultPriority
+}
+
+// filterMu guards the reloadable filter settings below. Use the setters when
+// changing them while events are being filtered.
+var filterMu sync.RWMutex
+
+// SetIgnoredNamespacePrefixes replaces IgnoredNamespacePrefixes.
+func SetIgnoredNamespacePrefixes(prefixes []string) {
+	filterMu.Lock()
+	defer filterMu.Unlock()
+	IgnoredNamespacePrefixes = prefixes
+}
+
+// SetSelfInduced replaces IgnoredSessionPrefixes and IgnoredRemoteNetworks.
+func SetSelfInduced(sessionPrefixes []string, remoteNetworks []netip.Prefix) {
+	filterMu.Lock()
+	defer filterMu.Unlock()
+	IgnoredSessionPrefixes = sessionPrefixes
+	IgnoredRemoteNetworks = remoteNetworks
+}
+
+// IgnoredNamespacePrefixes lists mailbox prefixes of shared and public namespaces.
+// Changes there belong to another user's or a public mailbox, so syncing the
+// accessing user is pointless. A prefix also matches the namespace root itself,
+// e.g. "Shared/" matches "Shared".
+var IgnoredNamespacePrefixes = []string{"Shared/", "Public/"}
+
+// inIgnoredNamespace reports whether the mailbox belongs to an ignored namespace.
+func inIgnoredNamespace(mailbox string) bool {
+	if mailbox == "" {
+		return false
+	}
+	for _, prefix := range IgnoredNamespacePrefixes {
+		if prefix == "" {
+			continue
+		}
+		if strings.HasPrefix(mailbox, prefix) || mailbox == strings.TrimRight(prefix, "/.") {
+			return true
+		}
+	}
+	return false
+}
+
+// Events caused by dsync writing to this server would otherwise be replicated
+// back, resulting in a sync ping-pong. Such events are recognized by the session
+// ID prefix or by the remote IP of the host running the sync.
+var (
+	// IgnoredSessionPrefixes lists session ID prefixes of dovewarden's own syncs.
+	IgnoredSessionPrefixes []string
+	// IgnoredRemoteNetworks lists the addresses of hosts running dovewarden's syncs.
+	IgnoredRemoteNetworks []netip.Prefix
+)
+
+// ParseRemoteNetworks parses IP addresses and CIDR networks for IgnoredRemoteNetworks.
+func ParseRemoteNetworks(values []string) ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if strings.Contains(v, "/") {
+			prefix, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", v, err)
+			}
+			networks = append(networks, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", v, err)
+		}
+		networks = append(networks, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return networks, nil
+}
+
+// selfInduced reports whether the event was caused by one of our own syncs.
+func selfInduced(fields Fields) bool {
+	if fields.Session != "" {
+		for _, prefix := range IgnoredSessionPrefixes {
+			if prefix != "" && strings.HasPrefix(fields.Session, prefix) {
+				return true
+			}
+		}
+	}
+	if fields.RemoteIP != "" && len(IgnoredRemoteNetworks) > 0 {
+		addr, err := netip.ParseAddr(fields.RemoteIP)
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		for _, network := range IgnoredRemoteNetworks {
+			if network.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Filter validates and filters incoming events.
 // Returns a FilteredEvent if the event passes, or an error if it doesn't.
 func Filter(data []byte) (*FilteredEvent, error) {
-	var evt Event
-	if err := json.Unmarshal(data, &evt); err != nil {
+	// decode straight into the result to allocate a single struct per event
+	filtered := &FilteredEvent{}
+	if err := json.Unmarshal(data, &filtered.Raw); err != nil {
 		return nil, err
 	}
+	evt := &filtered.Raw
 
 	if evt.Event == "" {
 		return nil, ErrEmptyEvent
@@ -83,18 +286,55 @@ func Filter(data []byte) (*FilteredEvent, error) {
 		return nil, ErrInvalidEventType
 	}
 
+	if evt.Event == EventMailDeliveryFinished {
+		if evt.Fields.Error != "" {
+			return nil, ErrDeliveryFailed
+		}
+		// LMTP sessions may not have resolved the user yet
+		if evt.Fields.User == "" {
+			evt.Fields.User = evt.Fields.RcptTo
+		}
+	}
+
 	if evt.Fields.User == "" {
 		return nil, ErrEmptyUsername
 	}
 
-	if evt.Event == "imap_command_finished" && !AcceptedIMAPCmdNames[strings.ToUpper(evt.Fields.CmdName)] {
+	if evt.Event == EventSieveActionFinished {
+		if evt.Fields.Error != "" {
+			return nil, ErrDeliveryFailed
+		}
+		if action := strings.ToLower(evt.Fields.SieveAction); action != "" && !AcceptedSieveActions[action] {
+			return nil, ErrInvalidSieveAction
+		}
+	}
+
+	if evt.Event == EventIMAPCommandFinished && !AcceptedIMAPCmdNames[strings.ToUpper(evt.Fields.CmdName)] {
 		return nil, ErrInvalidCmdName
 	}
 
-	return &FilteredEvent{
-		Event:    evt.Event,
-		Username: evt.Fields.User,
-		CmdName:  evt.Fields.CmdName,
-		Raw:      evt,
-	}, nil
+	filterMu.RLock()
+	ignored, shared := selfInduced(evt.Fields), inIgnoredNamespace(evt.Fields.Mailbox)
+	filterMu.RUnlock()
+	if ignored {
+		return nil, ErrSelfInduced
+	}
+	if shared {
+		return nil, ErrSharedNamespace
+	}
+
+	filtered.Event = evt.Event
+	filtered.Username = evt.Fields.User
+	filtered.CmdName = evt.Fields.CmdName
+	filtered.CmdInputName = evt.Fields.CmdInputName
+	filtered.Mailbox = evt.Fields.Mailbox
+	filtered.MessageGUID = evt.Fields.MessageGUID
+	filtered.Priority = eventPriority(*evt)
+	// the end of the command the event reports, if known, is when the change
+	// happened
+	filtered.OccurredAt = evt.EndTime.Time
+	if filtered.OccurredAt.IsZero() {
+		filtered.OccurredAt = evt.StartTime.Time
+	}
+	return filtered, nil
 }
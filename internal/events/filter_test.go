@@ -2,8 +2,10 @@ package events
 
 import (
 	"encoding/json"
+	"net/netip"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestFilterWithFixtures(t *testing.T) {
@@ -419,8 +421,11 @@ func TestFilteredEventProperties(t *testing.T) {
 		Event:    "imap_command_finished",
 		Hostname: "test-host",
 		Fields: Fields{
-			User:    "user-a",
-			CmdName: "APPEND",
+			User:         "user-a",
+			CmdName:      "APPEND",
+			CmdInputName: "APPEND",
+			Mailbox:      "INBOX",
+			MessageGUID:  "c2d1b0076f5a2a65c90f0000b1e2e36e",
 		},
 	}
 
@@ -436,7 +441,246 @@ func TestFilteredEventProperties(t *testing.T) {
 	if result.CmdName != "APPEND" {
 		t.Errorf("expected CmdName 'APPEND', got %s", result.CmdName)
 	}
+	if result.CmdInputName != "APPEND" {
+		t.Errorf("expected CmdInputName 'APPEND', got %s", result.CmdInputName)
+	}
+	if result.Mailbox != "INBOX" {
+		t.Errorf("expected Mailbox 'INBOX', got %s", result.Mailbox)
+	}
+	if result.MessageGUID != "c2d1b0076f5a2a65c90f0000b1e2e36e" {
+		t.Errorf("expected MessageGUID to be carried over, got %s", result.MessageGUID)
+	}
 	if result.Raw.Hostname != "test-host" {
 		t.Errorf("expected Raw.Hostname 'test-host', got %s", result.Raw.Hostname)
 	}
 }
+
+func TestReason(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"invalid json", `{"event":`, ReasonInvalidJSON},
+		{"wrong type", `{"event": 1}`, ReasonInvalidJSON},
+		{"empty event", `{"fields":{"user":"a"}}`, ReasonEmptyEvent},
+		{"unknown event", `{"event":"foo","fields":{"user":"a"}}`, ReasonInvalidEventType},
+		{"empty user", `{"event":"imap_command_finished","fields":{"cmd_name":"APPEND"}}`, ReasonEmptyUsername},
+		{"ignored cmd", `{"event":"imap_command_finished","fields":{"user":"a","cmd_name":"FETCH"}}`, ReasonInvalidCmdName},
+		{"failed delivery", `{"event":"mail_delivery_finished","fields":{"user":"a","error":"Mailbox full"}}`, ReasonDeliveryFailed},
+		{"ignored sieve action", `{"event":"sieve_action_finished","fields":{"user":"a","sieve_action":"discard"}}`, ReasonInvalidSieveAction},
+		{"shared namespace", `{"event":"imap_command_finished","fields":{"user":"a","cmd_name":"APPEND","mailbox":"Shared/bob/INBOX"}}`, ReasonSharedNamespace},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Filter([]byte(tt.data))
+			if err == nil {
+				t.Fatal("expected filter error")
+			}
+			if got := Reason(err); got != tt.want {
+				t.Fatalf("Reason() = %q, want %q (err %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestFilterIgnoredNamespaces(t *testing.T) {
+	defer func(prefixes []string) { IgnoredNamespacePrefixes = prefixes }(IgnoredNamespacePrefixes)
+	IgnoredNamespacePrefixes = []string{"Shared/", "Public/"}
+
+	tests := []struct {
+		mailbox string
+		ignored bool
+	}{
+		{"", false},
+		{"INBOX", false},
+		{"Archive/2024", false},
+		{"SharedFolder", false},
+		{"Shared", true},
+		{"Shared/bob/INBOX", true},
+		{"Public/Announcements", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mailbox, func(t *testing.T) {
+			ev := Event{
+				Event:  "imap_command_finished",
+				Fields: Fields{User: "alice", CmdName: "APPEND", Mailbox: tt.mailbox},
+			}
+			data, _ := json.Marshal(ev)
+			_, err := Filter(data)
+			if tt.ignored && err != ErrSharedNamespace {
+				t.Fatalf("expected ErrSharedNamespace, got %v", err)
+			}
+			if !tt.ignored && err != nil {
+				t.Fatalf("expected event to pass, got %v", err)
+			}
+		})
+	}
+
+	// clearing the prefixes disables namespace filtering
+	IgnoredNamespacePrefixes = nil
+	data, _ := json.Marshal(Event{
+		Event:  "imap_command_finished",
+		Fields: Fields{User: "alice", CmdName: "APPEND", Mailbox: "Shared/bob/INBOX"},
+	})
+	if _, err := Filter(data); err != nil {
+		t.Fatalf("expected event to pass without prefixes, got %v", err)
+	}
+}
+
+func TestFilterSelfInduced(t *testing.T) {
+	defer func(sessions []string) { IgnoredSessionPrefixes = sessions }(IgnoredSessionPrefixes)
+	defer func(networks []netip.Prefix) { IgnoredRemoteNetworks = networks }(IgnoredRemoteNetworks)
+
+	networks, err := ParseRemoteNetworks([]string{"172.20.0.3", "10.1.0.0/16", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("ParseRemoteNetworks() error: %v", err)
+	}
+	IgnoredRemoteNetworks = networks
+	IgnoredSessionPrefixes = []string{"dovewarden-"}
+
+	tests := []struct {
+		name     string
+		session  string
+		remoteIP string
+		ignored  bool
+	}{
+		{"unrelated client", "ErPH5eBGRLKsFAAD", "172.20.0.4", false},
+		{"no remote ip", "ErPH5eBGRLKsFAAD", "", false},
+		{"sync host address", "ErPH5eBGRLKsFAAD", "172.20.0.3", true},
+		{"sync host network", "", "10.1.2.3", true},
+		{"ipv4-mapped address", "", "::ffff:10.1.2.3", true},
+		{"ipv6 network", "", "2001:db8::1", true},
+		{"sync session", "dovewarden-abc", "192.0.2.1", true},
+		{"garbage remote ip", "", "not-an-ip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := Event{
+				Event:  "imap_command_finished",
+				Fields: Fields{User: "alice", CmdName: "APPEND", Session: tt.session, RemoteIP: tt.remoteIP},
+			}
+			data, _ := json.Marshal(ev)
+			_, err := Filter(data)
+			if tt.ignored && err != ErrSelfInduced {
+				t.Fatalf("expected ErrSelfInduced, got %v", err)
+			}
+			if !tt.ignored && err != nil {
+				t.Fatalf("expected event to pass, got %v", err)
+			}
+		})
+	}
+
+	if _, err := ParseRemoteNetworks([]string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("expected error for invalid network")
+	}
+}
+
+func TestFilterMailDelivery(t *testing.T) {
+	data, err := os.ReadFile("../../fixtures/events/lmtp.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	res, err := Filter(data)
+	if err != nil {
+		t.Fatalf("expected delivery event to pass, got %v", err)
+	}
+	if res.Event != EventMailDeliveryFinished || res.Username != "user-b" {
+		t.Fatalf("unexpected filtered event %+v", res)
+	}
+
+	t.Run("recipient fallback", func(t *testing.T) {
+		data := []byte(`{"event":"mail_delivery_finished","fields":{"rcpt_to":"user-c","protocol":"lmtp"}}`)
+		res, err := Filter(data)
+		if err != nil {
+			t.Fatalf("expected delivery event to pass, got %v", err)
+		}
+		if res.Username != "user-c" {
+			t.Fatalf("expected username from rcpt_to, got %q", res.Username)
+		}
+	})
+
+	t.Run("recipient is not used for imap events", func(t *testing.T) {
+		data := []byte(`{"event":"imap_command_finished","fields":{"rcpt_to":"user-c","cmd_name":"APPEND"}}`)
+		if _, err := Filter(data); err != ErrEmptyUsername {
+			t.Fatalf("expected ErrEmptyUsername, got %v", err)
+		}
+	})
+}
+
+func TestFilterPriorities(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want float64
+	}{
+		{"append", `{"event":"imap_command_finished","fields":{"user":"a","cmd_name":"APPEND"}}`, DefaultPriority},
+		{"delivery", `{"event":"mail_delivery_finished","fields":{"user":"a"}}`, DefaultPriority},
+		{"store", `{"event":"imap_command_finished","fields":{"user":"a","cmd_name":"UID STORE"}}`, 0.5},
+		{"expunge", `{"event":"imap_command_finished","fields":{"user":"a","cmd_name":"expunge"}}`, 0.7},
+		{"move", `{"event":"imap_command_finished","fields":{"user":"a","cmd_name":"MOVE"}}`, 0.8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Filter([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("Filter() error: %v", err)
+			}
+			if res.Priority != tt.want {
+				t.Fatalf("expected priority %v, got %v", tt.want, res.Priority)
+			}
+		})
+	}
+
+	// every command with a reduced priority must also be accepted
+	for cmd, priority := range IMAPCmdPriorities {
+		if !AcceptedIMAPCmdNames[cmd] {
+			t.Errorf("command %q has a priority but is not accepted", cmd)
+		}
+		if priority <= 0 || priority >= DefaultPriority {
+			t.Errorf("command %q should have a lower priority than the default, got %v", cmd, priority)
+		}
+	}
+}
+
+func BenchmarkFilter(b *testing.B) {
+	data, err := os.ReadFile("../../fixtures/events/append.json")
+	if err != nil {
+		b.Fatalf("failed to read fixture: %v", err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := Filter(data); err != nil {
+			b.Fatalf("filter: %v", err)
+		}
+	}
+}
+
+func TestFilterEventTime(t *testing.T) {
+	want := time.Date(2024, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+	for _, tc := range []struct {
+		name string
+		data string
+		want time.Time
+	}{
+		{"rfc3339", `{"event":"imap_command_finished","start_time":"2024-03-01T11:59:59Z","end_time":"2024-03-01T12:00:00.5Z","fields":{"user":"user-a","cmd_name":"APPEND"}}`, want},
+		{"unix", `{"event":"imap_command_finished","end_time":1709294400.5,"fields":{"user":"user-a","cmd_name":"APPEND"}}`, want},
+		{"start time only", `{"event":"imap_command_finished","start_time":"2024-03-01T12:00:00.5Z","fields":{"user":"user-a","cmd_name":"APPEND"}}`, want},
+		{"missing", `{"event":"imap_command_finished","fields":{"user":"user-a","cmd_name":"APPEND"}}`, time.Time{}},
+		{"invalid", `{"event":"imap_command_finished","end_time":"yesterday","fields":{"user":"user-a","cmd_name":"APPEND"}}`, time.Time{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := Filter([]byte(tc.data))
+			if err != nil {
+				t.Fatalf("Filter: %v", err)
+			}
+			if !result.OccurredAt.Equal(tc.want) {
+				t.Errorf("OccurredAt = %v, want %v", result.OccurredAt, tc.want)
+			}
+		})
+	}
+}
@@ -1,24 +1,72 @@
 package events
 
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
 // Fields represents nested fields in a Dovecot event.
 type Fields struct {
-	User    string `json:"user"`
-	CmdName string `json:"cmd_name"`
+	User         string `json:"user"`
+	CmdName      string `json:"cmd_name"`
+	CmdInputName string `json:"cmd_input_name"` // command as sent by the client, e.g. "UID MOVE"
+	Mailbox      string `json:"mailbox"`        // mailbox the command or delivery targeted
+	MessageGUID  string `json:"message_guid"`   // GUID of the affected message, if any
+	Session      string `json:"session"`
+	RemoteIP     string `json:"remote_ip"`
+	RcptTo       string `json:"rcpt_to"`      // LMTP recipient, used if user is not set
+	Error        string `json:"error"`        // set on failed deliveries and Sieve actions
+	SieveAction  string `json:"sieve_action"` // Sieve action name, e.g. "fileinto"
 	// Additional fields can be added here as needed
 }
 
 // Event represents a Dovecot event from the event API.
 type Event struct {
-	Event    string `json:"event"`
-	Fields   Fields `json:"fields"`
-	Hostname string `json:"hostname,omitempty"`
+	Event     string    `json:"event"`
+	Fields    Fields    `json:"fields"`
+	Hostname  string    `json:"hostname,omitempty"`
+	StartTime Timestamp `json:"start_time,omitzero"`
+	EndTime   Timestamp `json:"end_time,omitzero"`
 	// Additional fields from Dovecot can be added here as needed
 }
 
 // FilteredEvent represents an event that passed filter validation.
 type FilteredEvent struct {
-	Event    string
-	Username string
-	CmdName  string
-	Raw      Event
+	Event         string
+	Username      string
+	CmdName       string
+	CmdInputName  string
+	Mailbox       string
+	MessageGUID   string
+	Priority      float64   // priority factor, higher is synced sooner
+	CorrelationID string    // identifies the event in the logs; set by the receiver, not the filter
+	OccurredAt    time.Time // when Dovecot emitted the event, zero if it carries no time
+	Raw           Event
+}
+
+// Timestamp is the time of an event as exported by Dovecot, either as an
+// RFC 3339 string (time-format=rfc3339) or as fractional unix seconds
+// (time-format=unix). Values that are neither are ignored.
+type Timestamp struct {
+	time.Time
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	t.Time = time.Time{}
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+	if parsed, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		t.Time = parsed
+	} else if secs, err := strconv.ParseFloat(s, 64); err == nil && secs > 0 {
+		t.Time = time.Unix(0, int64(secs*float64(time.Second)))
+	}
+	return nil
 }
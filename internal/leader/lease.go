@@ -0,0 +1,376 @@
+// Package leader elects a single replica to run cluster-wide periodic work,
+// such as background replication, using a Kubernetes coordination/v1 Lease.
+// It talks to the API server directly with the in-cluster service account.
+package leader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In-cluster service account files.
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = serviceAccountDir + "/token"
+	caFile            = serviceAccountDir + "/ca.crt"
+	namespaceFile     = serviceAccountDir + "/namespace"
+)
+
+// microTimeFormat is the format of metav1.MicroTime.
+const microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// errConflict is returned when the Lease was modified concurrently.
+var errConflict = errors.New("lease was modified concurrently")
+
+// Config configures a Lease elector.
+type Config struct {
+	APIServer     string        // e.g. https://10.0.0.1:443; defaults to the in-cluster address
+	Namespace     string        // defaults to the namespace of the service account
+	Name          string        // name of the Lease object
+	Identity      string        // identity of this replica, e.g. the pod name
+	LeaseDuration time.Duration // how long a lease is valid without renewal
+	RetryPeriod   time.Duration // interval of acquire and renew attempts; defaults to a third of LeaseDuration
+}
+
+// leaseSpec is the part of coordination/v1 LeaseSpec used here.
+type leaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *string `json:"acquireTime,omitempty"`
+	RenewTime            *string `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32  `json:"leaseTransitions,omitempty"`
+}
+
+type lease struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Metadata   map[string]any `json:"metadata"`
+	Spec       leaseSpec      `json:"spec"`
+}
+
+// LeaseElector competes for a Kubernetes Lease. The holder of an unexpired
+// Lease is the leader; it renews the Lease every retry period, and other
+// replicas take it over once it expired.
+type LeaseElector struct {
+	cfg       Config
+	client    *http.Client
+	tokenFile string // re-read for every request, as the kubelet rotates the token
+	logger    *slog.Logger
+
+	mu         sync.Mutex
+	validUntil time.Time // leadership is assumed until then, zero if not leader
+	onChange   []func(bool)
+}
+
+// NewInClusterLeaseElector creates an elector authenticating with the pod's
+// service account.
+func NewInClusterLeaseElector(cfg Config, logger *slog.Logger) (*LeaseElector, error) {
+	if cfg.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("not running in a Kubernetes cluster: KUBERNETES_SERVICE_HOST is not set")
+		}
+		cfg.APIServer = "https://" + net.JoinHostPort(host, port)
+	}
+	if cfg.Namespace == "" {
+		ns, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read namespace: %w", err)
+		}
+		cfg.Namespace = strings.TrimSpace(string(ns))
+	}
+	if _, err := os.Stat(tokenFile); err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found in service account CA")
+	}
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+	}
+	return newLeaseElector(cfg, client, tokenFile, logger)
+}
+
+func newLeaseElector(cfg Config, client *http.Client, tokenFile string, logger *slog.Logger) (*LeaseElector, error) {
+	if cfg.Name == "" || cfg.Identity == "" {
+		return nil, errors.New("lease name and identity are required")
+	}
+	if cfg.LeaseDuration <= 0 {
+		return nil, errors.New("lease duration must be positive")
+	}
+	if cfg.RetryPeriod <= 0 {
+		cfg.RetryPeriod = cfg.LeaseDuration / 3
+	}
+	if cfg.RetryPeriod >= cfg.LeaseDuration {
+		return nil, errors.New("retry period must be shorter than the lease duration")
+	}
+	cfg.APIServer = strings.TrimRight(cfg.APIServer, "/")
+	return &LeaseElector{cfg: cfg, client: client, tokenFile: tokenFile, logger: logger}, nil
+}
+
+// IsLeader reports whether this replica currently holds the Lease. Leadership
+// ends one retry period before the Lease expires, so that two replicas never
+// consider themselves leader at the same time.
+func (e *LeaseElector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Now().Before(e.validUntil)
+}
+
+// OnChange registers a callback invoked when leadership is gained or lost.
+func (e *LeaseElector) OnChange(fn func(leader bool)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onChange = append(e.onChange, fn)
+}
+
+// Run tries to acquire or renew the Lease every retry period until ctx is
+// cancelled, then releases it if held.
+func (e *LeaseElector) Run(ctx context.Context) {
+	e.logger.Info("Starting leader election", "lease", e.cfg.Namespace+"/"+e.cfg.Name, "identity", e.cfg.Identity)
+	ticker := time.NewTicker(e.cfg.RetryPeriod)
+	defer ticker.Stop()
+	for {
+		e.tryAcquireOrRenew(ctx)
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryAcquireOrRenew performs one election round.
+func (e *LeaseElector) tryAcquireOrRenew(ctx context.Context) {
+	now := time.Now()
+	held, err := e.acquireOrRenew(ctx, now)
+	switch {
+	case errors.Is(err, errConflict):
+		// Another replica won the race for this round
+		e.setValidUntil(time.Time{})
+	case err != nil:
+		e.logger.Warn("Leader election failed", "lease", e.cfg.Name, "error", err)
+		// Keep the leadership until it runs out; the next round may succeed
+		e.setValidUntil(e.currentValidUntil())
+	case held:
+		e.setValidUntil(now.Add(e.cfg.LeaseDuration - e.cfg.RetryPeriod))
+	default:
+		e.setValidUntil(time.Time{})
+	}
+}
+
+// acquireOrRenew creates, renews or takes over the Lease and reports whether
+// this replica holds it.
+func (e *LeaseElector) acquireOrRenew(ctx context.Context, now time.Time) (bool, error) {
+	current, err := e.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	nowStr := now.UTC().Format(microTimeFormat)
+	durationSeconds := int32(e.cfg.LeaseDuration / time.Second)
+	identity := e.cfg.Identity
+
+	if current == nil {
+		zero := int32(0)
+		return true, e.write(ctx, http.MethodPost, &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   map[string]any{"name": e.cfg.Name, "namespace": e.cfg.Namespace},
+			Spec: leaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &nowStr,
+				RenewTime:            &nowStr,
+				LeaseTransitions:     &zero,
+			},
+		})
+	}
+
+	spec := &current.Spec
+	holder := ""
+	if spec.HolderIdentity != nil {
+		holder = *spec.HolderIdentity
+	}
+	if holder != identity {
+		if holder != "" && !expired(spec, now) {
+			return false, nil
+		}
+		transitions := int32(0)
+		if spec.LeaseTransitions != nil {
+			transitions = *spec.LeaseTransitions
+		}
+		transitions++
+		spec.LeaseTransitions = &transitions
+		spec.AcquireTime = &nowStr
+		spec.HolderIdentity = &identity
+	}
+	spec.RenewTime = &nowStr
+	spec.LeaseDurationSeconds = &durationSeconds
+	return true, e.write(ctx, http.MethodPut, current)
+}
+
+// expired reports whether the holder failed to renew the Lease in time.
+func expired(spec *leaseSpec, now time.Time) bool {
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	renewed, err := time.Parse(time.RFC3339Nano, *spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second))
+}
+
+// release gives up a held Lease so that another replica can take over
+// without waiting for it to expire.
+func (e *LeaseElector) release() {
+	if !e.IsLeader() {
+		return
+	}
+	e.setValidUntil(time.Time{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	current, err := e.get(ctx)
+	if err != nil || current == nil || current.Spec.HolderIdentity == nil || *current.Spec.HolderIdentity != e.cfg.Identity {
+		return
+	}
+	one := int32(1)
+	nowStr := time.Now().UTC().Format(microTimeFormat)
+	current.Spec.HolderIdentity = nil
+	current.Spec.LeaseDurationSeconds = &one
+	current.Spec.RenewTime = &nowStr
+	if err := e.write(ctx, http.MethodPut, current); err != nil {
+		e.logger.Warn("Failed to release leader lease", "lease", e.cfg.Name, "error", err)
+		return
+	}
+	e.logger.Info("Released leader lease", "lease", e.cfg.Name)
+}
+
+func (e *LeaseElector) currentValidUntil() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.validUntil
+}
+
+// setValidUntil updates the leadership and notifies the callbacks on change.
+func (e *LeaseElector) setValidUntil(t time.Time) {
+	now := time.Now()
+	e.mu.Lock()
+	was := now.Before(e.validUntil)
+	e.validUntil = t
+	is := now.Before(t)
+	callbacks := e.onChange
+	e.mu.Unlock()
+
+	if was == is {
+		return
+	}
+	if is {
+		e.logger.Info("Became leader", "lease", e.cfg.Name, "identity", e.cfg.Identity)
+	} else {
+		e.logger.Info("Lost leadership", "lease", e.cfg.Name, "identity", e.cfg.Identity)
+	}
+	for _, fn := range callbacks {
+		fn(is)
+	}
+}
+
+func (e *LeaseElector) leaseURL(named bool) string {
+	url := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.cfg.APIServer, e.cfg.Namespace)
+	if named {
+		url += "/" + e.cfg.Name
+	}
+	return url
+}
+
+// get returns the Lease, or nil if it does not exist.
+func (e *LeaseElector) get(ctx context.Context) (*lease, error) {
+	var l lease
+	status, err := e.do(ctx, http.MethodGet, e.leaseURL(true), nil, &l)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lease: %w", err)
+	}
+	return &l, nil
+}
+
+// write creates (POST) or replaces (PUT) the Lease. Replacing relies on the
+// resourceVersion in the metadata to detect concurrent modifications.
+func (e *LeaseElector) write(ctx context.Context, method string, l *lease) error {
+	status, err := e.do(ctx, method, e.leaseURL(method == http.MethodPut), l, nil)
+	if status == http.StatusConflict {
+		return errConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write lease: %w", err)
+	}
+	return nil
+}
+
+func (e *LeaseElector) do(ctx context.Context, method, url string, body, out any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if e.tokenFile != "" {
+		token, err := os.ReadFile(e.tokenFile)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
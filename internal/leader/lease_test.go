@@ -0,0 +1,157 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLeaseAPI stores a single Lease with optimistic concurrency on the
+// resourceVersion, like the Kubernetes API server.
+type fakeLeaseAPI struct {
+	mu      sync.Mutex
+	lease   *lease
+	version int
+}
+
+func (f *fakeLeaseAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if f.lease == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(f.lease)
+	case http.MethodPost, http.MethodPut:
+		var l lease
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost && f.lease != nil {
+			http.Error(w, "already exists", http.StatusConflict)
+			return
+		}
+		if r.Method == http.MethodPut && l.Metadata["resourceVersion"] != f.lease.Metadata["resourceVersion"] {
+			http.Error(w, "conflict", http.StatusConflict)
+			return
+		}
+		f.version++
+		l.Metadata["resourceVersion"] = strconv.Itoa(f.version)
+		f.lease = &l
+		_ = json.NewEncoder(w).Encode(f.lease)
+	}
+}
+
+func (f *fakeLeaseAPI) holder() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lease == nil || f.lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *f.lease.Spec.HolderIdentity
+}
+
+func newTestElector(t *testing.T, url, identity string) *LeaseElector {
+	t.Helper()
+	e, err := newLeaseElector(Config{
+		APIServer:     url,
+		Namespace:     "mail",
+		Name:          "dovewarden",
+		Identity:      identity,
+		LeaseDuration: 3 * time.Second,
+		RetryPeriod:   time.Second,
+	}, http.DefaultClient, "", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("newLeaseElector: %v", err)
+	}
+	return e
+}
+
+func TestOnlyOneReplicaLeads(t *testing.T) {
+	api := &fakeLeaseAPI{}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	a := newTestElector(t, srv.URL, "pod-a")
+	b := newTestElector(t, srv.URL, "pod-b")
+	ctx := context.Background()
+
+	var changes []bool
+	a.OnChange(func(leader bool) { changes = append(changes, leader) })
+
+	a.tryAcquireOrRenew(ctx)
+	b.tryAcquireOrRenew(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expected pod-a to lead, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+
+	// Renewing keeps the leadership
+	a.tryAcquireOrRenew(ctx)
+	b.tryAcquireOrRenew(ctx)
+	if !a.IsLeader() || b.IsLeader() || api.holder() != "pod-a" {
+		t.Fatalf("expected pod-a to keep leading, holder %q", api.holder())
+	}
+	if len(changes) != 1 || !changes[0] {
+		t.Errorf("expected a single leadership gain, got %v", changes)
+	}
+}
+
+func TestExpiredLeaseIsTakenOver(t *testing.T) {
+	api := &fakeLeaseAPI{}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	a := newTestElector(t, srv.URL, "pod-a")
+	b := newTestElector(t, srv.URL, "pod-b")
+	ctx := context.Background()
+
+	a.tryAcquireOrRenew(ctx)
+	if !a.IsLeader() {
+		t.Fatal("expected pod-a to lead")
+	}
+
+	// pod-a stops renewing; pod-b takes over once the lease expired
+	if held, err := b.acquireOrRenew(ctx, time.Now().Add(4*time.Second)); err != nil || !held {
+		t.Fatalf("expected takeover, got held=%v err=%v", held, err)
+	}
+	if api.holder() != "pod-b" || *api.lease.Spec.LeaseTransitions != 1 {
+		t.Fatalf("expected pod-b to hold the lease after one transition, got %q", api.holder())
+	}
+
+	a.tryAcquireOrRenew(ctx)
+	if a.IsLeader() {
+		t.Error("expected pod-a to lose leadership")
+	}
+}
+
+func TestReleaseHandsOverImmediately(t *testing.T) {
+	api := &fakeLeaseAPI{}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	a := newTestElector(t, srv.URL, "pod-a")
+	b := newTestElector(t, srv.URL, "pod-b")
+	ctx := context.Background()
+
+	a.tryAcquireOrRenew(ctx)
+	a.release()
+	if a.IsLeader() || api.holder() != "" {
+		t.Fatalf("expected released lease, holder %q", api.holder())
+	}
+
+	b.tryAcquireOrRenew(ctx)
+	if !b.IsLeader() {
+		t.Error("expected pod-b to acquire the released lease")
+	}
+}
@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Components are the names of the component loggers of dovewarden, logged as
+// the "component" attribute.
+var Components = []string{"server", "queue", "doveadm", "alerting", "errreport", "leader", "credentials"}
+
+// Levels holds the minimum level of the logger of each component, falling
+// back to a default level. Changes apply to loggers created before, so levels
+// can be changed on a configuration reload.
+type Levels struct {
+	mu         sync.Mutex
+	def        slog.LevelVar
+	overrides  map[string]slog.Level
+	components map[string]*slog.LevelVar
+}
+
+// NewLevels returns levels with the default level def and the given
+// per-component levels.
+func NewLevels(def slog.Level, overrides map[string]slog.Level) *Levels {
+	l := &Levels{components: make(map[string]*slog.LevelVar)}
+	l.Set(def, overrides)
+	return l
+}
+
+// Set replaces the default and per-component levels.
+func (l *Levels) Set(def slog.Level, overrides map[string]slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.def.Set(def)
+	l.overrides = overrides
+	for component, level := range l.components {
+		level.Set(l.levelOf(component))
+	}
+}
+
+// Default returns the default level.
+func (l *Levels) Default() slog.Level {
+	return l.def.Level()
+}
+
+// levelOf returns the configured level of component. l.mu must be held.
+func (l *Levels) levelOf(component string) slog.Level {
+	if level, ok := l.overrides[component]; ok {
+		return level
+	}
+	return l.def.Level()
+}
+
+// Logger returns a logger writing to next with the level of component and
+// the attribute component=<component>. next must accept records of every
+// level; an empty component returns a logger with the default level and no
+// component attribute.
+func (l *Levels) Logger(next slog.Handler, component string) *slog.Logger {
+	if component == "" {
+		return slog.New(&levelHandler{next: next, level: &l.def})
+	}
+	l.mu.Lock()
+	level, ok := l.components[component]
+	if !ok {
+		level = new(slog.LevelVar)
+		level.Set(l.levelOf(component))
+		l.components[component] = level
+	}
+	l.mu.Unlock()
+	return slog.New(&levelHandler{next: next, level: level}).With("component", component)
+}
+
+// ParseLevels parses comma-separated component=level pairs, e.g.
+// "queue=debug,server=warn". Components must be one of Components.
+func ParseLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		component, name, ok := strings.Cut(pair, "=")
+		component = strings.TrimSpace(component)
+		if !ok || !slices.Contains(Components, component) {
+			return nil, fmt.Errorf("invalid component level %q, must be <component>=<level> with a component of %s", pair, strings.Join(Components, ", "))
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+			return nil, fmt.Errorf("invalid level of component %s: %w", component, err)
+		}
+		levels[component] = level
+	}
+	return levels, nil
+}
+
+// levelHandler drops the records below a level before passing them to the
+// next handler.
+type levelHandler struct {
+	next  slog.Handler
+	level slog.Leveler
+}
+
+// Enabled implements slog.Handler.
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{next: h.next.WithAttrs(attrs), level: h.level}
+}
+
+// WithGroup implements slog.Handler.
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{next: h.next.WithGroup(name), level: h.level}
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	levels := NewLevels(slog.LevelInfo, map[string]slog.Level{"queue": slog.LevelDebug})
+	root := levels.Logger(handler, "")
+	queue := levels.Logger(handler, "queue")
+	server := levels.Logger(handler, "server")
+
+	root.Debug("root debug")
+	queue.Debug("queue debug")
+	server.Debug("server debug")
+	server.Info("server info")
+
+	out := buf.String()
+	if strings.Contains(out, "root debug") || strings.Contains(out, "server debug") {
+		t.Errorf("expected debug records of components without override to be dropped, got %s", out)
+	}
+	if !strings.Contains(out, `msg="queue debug" component=queue`) || !strings.Contains(out, `msg="server info" component=server`) {
+		t.Errorf("expected component records, got %s", out)
+	}
+
+	// a reload applies to existing loggers
+	buf.Reset()
+	levels.Set(slog.LevelWarn, map[string]slog.Level{"server": slog.LevelDebug})
+	queue.Info("queue info")
+	server.With("username", "alice").Debug("server debug")
+	out = buf.String()
+	if strings.Contains(out, "queue info") || !strings.Contains(out, "server debug") {
+		t.Errorf("expected reloaded levels to apply, got %s", out)
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels(" queue=debug, server=WARN,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(levels) != 2 || levels["queue"] != slog.LevelDebug || levels["server"] != slog.LevelWarn {
+		t.Errorf("unexpected levels %v", levels)
+	}
+	for _, invalid := range []string{"queue", "unknown=debug", "queue=loud"} {
+		if _, err := ParseLevels(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
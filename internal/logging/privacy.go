@@ -0,0 +1,105 @@
+// Package logging provides slog helpers shared by the dovewarden binaries.
+package logging
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+)
+
+// userAttrKeys are the attribute keys whose values are usernames.
+var userAttrKeys = map[string]bool{
+	"username":    true,
+	"user":        true,
+	"oldest_user": true,
+}
+
+// redactedAttrKeys are the attribute keys whose values may contain personal
+// data in arbitrary places, such as raw event bodies, and are dropped.
+var redactedAttrKeys = map[string]bool{
+	"body":    true,
+	"mailbox": true,
+}
+
+// redactedValue replaces the values of redactedAttrKeys.
+const redactedValue = "[redacted]"
+
+// PrivacyHandler replaces usernames in log records with a salted hash, so logs
+// can be shipped to central systems without exposing email addresses. The same
+// username always maps to the same hash, so a user's records can still be
+// correlated; the salt prevents recovering usernames by hashing guesses.
+type PrivacyHandler struct {
+	next slog.Handler
+	salt []byte
+}
+
+// NewPrivacyHandler wraps next, hashing usernames with the given salt.
+func NewPrivacyHandler(next slog.Handler, salt string) *PrivacyHandler {
+	return &PrivacyHandler{next: next, salt: []byte(salt)}
+}
+
+// HashUsername returns the pseudonym logged in place of a username.
+func (h *PrivacyHandler) HashUsername(username string) string {
+	mac := hmac.New(sha256.New, h.salt)
+	mac.Write([]byte(username))
+	return "u-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Enabled implements slog.Handler.
+func (h *PrivacyHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *PrivacyHandler) Handle(ctx context.Context, r slog.Record) error {
+	clean := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(h.scrub(a))
+		return true
+	})
+	return h.next.Handle(ctx, clean)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *PrivacyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = h.scrub(a)
+	}
+	return &PrivacyHandler{next: h.next.WithAttrs(clean), salt: h.salt}
+}
+
+// WithGroup implements slog.Handler.
+func (h *PrivacyHandler) WithGroup(name string) slog.Handler {
+	return &PrivacyHandler{next: h.next.WithGroup(name), salt: h.salt}
+}
+
+// scrub hashes or redacts a single attribute, descending into groups.
+func (h *PrivacyHandler) scrub(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch {
+	case a.Value.Kind() == slog.KindGroup:
+		group := a.Value.Group()
+		clean := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			clean[i] = h.scrub(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(clean...)}
+	case userAttrKeys[a.Key] && a.Value.Kind() == slog.KindString:
+		if username := a.Value.String(); username != "" {
+			return slog.String(a.Key, h.HashUsername(username))
+		}
+	case redactedAttrKeys[a.Key]:
+		return slog.String(a.Key, redactedValue)
+	case a.Key == "key" && a.Value.Kind() == slog.KindString:
+		// per-user Redis keys end with the username, e.g. "dovewarden:state:alice"
+		key := a.Value.String()
+		if i := strings.LastIndexByte(key, ':'); i >= 0 && strings.Count(key, ":") >= 2 {
+			return slog.String(a.Key, key[:i+1]+h.HashUsername(key[i+1:]))
+		}
+	}
+	return a
+}
@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestPrivacyHandler(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewPrivacyHandler(slog.NewTextHandler(&buf, nil), "salt")
+	logger := slog.New(handler).With("user", "bob@example.org")
+
+	logger.Info("syncing",
+		"username", "alice@example.org",
+		"key", "dovewarden:state:alice@example.org",
+		"body", `{"fields":{"user":"alice@example.org"}}`,
+		slog.Group("event", "username", "alice@example.org"),
+		"destination", "imap",
+	)
+
+	out := buf.String()
+	if strings.Contains(out, "example.org") {
+		t.Fatalf("expected no usernames in log output, got %s", out)
+	}
+	hash := handler.HashUsername("alice@example.org")
+	for _, want := range []string{
+		"username=" + hash,
+		"key=dovewarden:state:" + hash,
+		"event.username=" + hash,
+		"user=" + handler.HashUsername("bob@example.org"),
+		"body=[redacted]",
+		"destination=imap",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in log output, got %s", want, out)
+		}
+	}
+
+	if other := NewPrivacyHandler(slog.NewTextHandler(&buf, nil), "other"); other.HashUsername("alice@example.org") == hash {
+		t.Fatal("expected hashes to depend on the salt")
+	}
+}
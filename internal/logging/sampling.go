@@ -0,0 +1,172 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// sampleClass tracks the records of one level and message in the current window.
+type sampleClass struct {
+	windowStart time.Time
+	seen        int
+	suppressed  int
+	level       slog.Level
+	message     string
+	next        slog.Handler // handler that saw the last suppressed record
+}
+
+// samplingState is shared by a SamplingHandler and the handlers derived from it
+// with WithAttrs and WithGroup, so that sampling applies across all loggers.
+type samplingState struct {
+	mu      sync.Mutex
+	classes map[string]*sampleClass
+}
+
+// SamplingHandler suppresses bursts of repeated warnings and errors, such as a
+// "dsync failed" per user while doveadm is down. Records are grouped into
+// classes by level and message. Of each class, the first records per interval
+// are passed through; further records are dropped and reported as a single
+// summary record once the interval has passed. Records below Warn are never
+// sampled.
+type SamplingHandler struct {
+	next     slog.Handler
+	first    int
+	interval time.Duration
+	now      func() time.Time
+	state    *samplingState
+}
+
+// NewSamplingHandler wraps next, passing through the first records of each
+// class per interval.
+func NewSamplingHandler(next slog.Handler, first int, interval time.Duration) *SamplingHandler {
+	return &SamplingHandler{
+		next:     next,
+		first:    first,
+		interval: interval,
+		now:      time.Now,
+		state:    &samplingState{classes: make(map[string]*sampleClass)},
+	}
+}
+
+// Enabled implements slog.Handler.
+func (h *SamplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *SamplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.next.Handle(ctx, r)
+	}
+
+	now := h.now()
+	key := r.Level.String() + "\x00" + r.Message
+
+	h.state.mu.Lock()
+	class, ok := h.state.classes[key]
+	if !ok {
+		class = &sampleClass{windowStart: now, level: r.Level, message: r.Message}
+		h.state.classes[key] = class
+	}
+	summary := h.rotate(class, now)
+	class.seen++
+	pass := class.seen <= h.first
+	if !pass {
+		class.suppressed++
+		class.next = h.next
+	}
+	h.state.mu.Unlock()
+
+	if summary != nil {
+		_ = summary.next.Handle(ctx, summary.record)
+	}
+	if !pass {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// Flush emits the summaries of all classes whose interval has passed and
+// forgets idle classes. It should be called periodically, so that the summary
+// of a burst is logged even if no further records of its class follow.
+func (h *SamplingHandler) Flush(ctx context.Context) {
+	now := h.now()
+
+	h.state.mu.Lock()
+	var summaries []*samplingSummary
+	for key, class := range h.state.classes {
+		if now.Sub(class.windowStart) < h.interval {
+			continue
+		}
+		if summary := h.rotate(class, now); summary != nil {
+			summaries = append(summaries, summary)
+		} else {
+			delete(h.state.classes, key)
+		}
+	}
+	h.state.mu.Unlock()
+
+	for _, summary := range summaries {
+		_ = summary.next.Handle(ctx, summary.record)
+	}
+}
+
+// Run calls Flush every interval until ctx is cancelled.
+func (h *SamplingHandler) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.Flush(ctx)
+		}
+	}
+}
+
+// samplingSummary is a summary record and the handler to emit it with.
+type samplingSummary struct {
+	next   slog.Handler
+	record slog.Record
+}
+
+// rotate starts a new window for class if the current one has passed, and
+// returns the summary of the suppressed records, if any. The caller must hold
+// the state lock.
+func (h *SamplingHandler) rotate(class *sampleClass, now time.Time) *samplingSummary {
+	if now.Sub(class.windowStart) < h.interval {
+		return nil
+	}
+	var summary *samplingSummary
+	if class.suppressed > 0 {
+		r := slog.NewRecord(now, class.level, "suppressed repeated log records", 0)
+		r.AddAttrs(
+			slog.String("message", class.message),
+			slog.Int("suppressed", class.suppressed),
+			slog.Duration("interval", now.Sub(class.windowStart)),
+		)
+		summary = &samplingSummary{next: class.next, record: r}
+	}
+	class.windowStart = now
+	class.seen = 0
+	class.suppressed = 0
+	class.next = nil
+	return summary
+}
+
+// WithAttrs implements slog.Handler.
+func (h *SamplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *h
+	derived.next = h.next.WithAttrs(attrs)
+	return &derived
+}
+
+// WithGroup implements slog.Handler.
+func (h *SamplingHandler) WithGroup(name string) slog.Handler {
+	derived := *h
+	derived.next = h.next.WithGroup(name)
+	return &derived
+}
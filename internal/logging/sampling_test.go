@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSamplingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewSamplingHandler(slog.NewTextHandler(&buf, nil), 2, time.Minute)
+	now := time.Unix(1700000000, 0)
+	handler.now = func() time.Time { return now }
+	logger := slog.New(handler)
+
+	for i := 0; i < 5; i++ {
+		logger.With("username", "alice").Error("dsync failed")
+		logger.Info("event accepted")
+	}
+	logger.Error("queue unhealthy")
+
+	out := buf.String()
+	if n := strings.Count(out, `msg="dsync failed"`); n != 2 {
+		t.Fatalf("expected 2 dsync errors to pass, got %d: %s", n, out)
+	}
+	if n := strings.Count(out, `msg="event accepted"`); n != 5 {
+		t.Fatalf("expected info records not to be sampled, got %d: %s", n, out)
+	}
+	if !strings.Contains(out, `msg="queue unhealthy"`) {
+		t.Fatalf("expected other errors to pass, got %s", out)
+	}
+	if strings.Contains(out, "suppressed") {
+		t.Fatalf("expected no summary before the interval has passed, got %s", out)
+	}
+
+	buf.Reset()
+	handler.Flush(context.Background())
+	if buf.Len() != 0 {
+		t.Fatalf("expected no summary before the interval has passed, got %s", buf.String())
+	}
+
+	now = now.Add(time.Minute)
+	handler.Flush(context.Background())
+	out = buf.String()
+	for _, want := range []string{`msg="suppressed repeated log records"`, `message="dsync failed"`, "suppressed=3", "username=alice"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in summary, got %s", want, out)
+		}
+	}
+	if strings.Count(out, "\n") != 1 {
+		t.Fatalf("expected a single summary, got %s", out)
+	}
+
+	// a new interval passes the first records again
+	buf.Reset()
+	logger.Error("dsync failed")
+	if !strings.Contains(buf.String(), `msg="dsync failed"`) {
+		t.Fatalf("expected record to pass in the new interval, got %s", buf.String())
+	}
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OtherLabelValue replaces the values of a label beyond its limit.
+const OtherLabelValue = "other"
+
+// DefaultMaxLabelValues is the default number of distinct values kept per
+// label by LimitLabel.
+const DefaultMaxLabelValues = 100
+
+// labelLimits caps the distinct values of unbounded labels, e.g. destinations
+// or users, so that a runaway label cannot create a series per value.
+type labelLimits struct {
+	mu        sync.Mutex
+	max       int // 0 keeps all values
+	seen      map[string]map[string]struct{}
+	truncated *prometheus.CounterVec
+	values    *prometheus.GaugeVec
+}
+
+func newLabelLimits() *labelLimits {
+	return &labelLimits{
+		max:  DefaultMaxLabelValues,
+		seen: make(map[string]map[string]struct{}),
+		truncated: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_metric_label_values_truncated_total",
+				Help: "Total number of observations whose label value was replaced by \"other\" because the label reached its limit of distinct values",
+			},
+			[]string{"label"},
+		),
+		values: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_metric_label_values",
+				Help: "Number of distinct values of a limited label exported, excluding \"other\"",
+			},
+			[]string{"label"},
+		),
+	}
+}
+
+// SetMaxLabelValues sets the number of distinct values LimitLabel keeps per
+// label; 0 keeps all values. Values already kept stay kept.
+func (m *Metrics) SetMaxLabelValues(n int) {
+	m.labels.mu.Lock()
+	defer m.labels.mu.Unlock()
+	m.labels.max = n
+}
+
+// LimitLabel returns the value to export for label: value itself if it is
+// among the first distinct values of label seen, up to the limit, and
+// OtherLabelValue beyond it. The first values seen are kept rather than the
+// most frequent ones so that existing series never move to "other". Every
+// replaced value is counted in dovewarden_metric_label_values_truncated_total.
+func (m *Metrics) LimitLabel(label, value string) string {
+	l := m.labels
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seen, ok := l.seen[label]
+	if !ok {
+		seen = make(map[string]struct{})
+		l.seen[label] = seen
+	}
+	if _, ok := seen[value]; ok {
+		return value
+	}
+	if l.max > 0 && len(seen) >= l.max {
+		l.truncated.WithLabelValues(label).Inc()
+		return OtherLabelValue
+	}
+	seen[value] = struct{}{}
+	l.values.WithLabelValues(label).Set(float64(len(seen)))
+	return value
+}
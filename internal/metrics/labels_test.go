@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLimitLabel(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+	m.SetMaxLabelValues(2)
+
+	for _, tc := range []struct{ value, want string }{
+		{"imap", "imap"},
+		{"backup", "backup"},
+		{"imap", "imap"},
+		{"third", OtherLabelValue},
+		{"fourth", OtherLabelValue},
+		{"backup", "backup"},
+	} {
+		if got := m.LimitLabel("destination", tc.value); got != tc.want {
+			t.Errorf("LimitLabel(%q) = %q, want %q", tc.value, got, tc.want)
+		}
+	}
+	// labels are limited independently
+	if got := m.LimitLabel("domain", "example.com"); got != "example.com" {
+		t.Errorf("LimitLabel of another label = %q, want example.com", got)
+	}
+	if got := testutil.ToFloat64(m.labels.truncated.WithLabelValues("destination")); got != 2 {
+		t.Errorf("truncated = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.labels.values.WithLabelValues("destination")); got != 2 {
+		t.Errorf("values = %v, want 2", got)
+	}
+
+	m.SetMaxLabelValues(0)
+	if got := m.LimitLabel("destination", "third"); got != "third" {
+		t.Errorf("LimitLabel without limit = %q, want third", got)
+	}
+}
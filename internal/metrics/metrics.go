@@ -6,22 +6,88 @@ import (
 
 // Metrics holds all Prometheus metrics for the application.
 type Metrics struct {
-	EventsReceived prometheus.Counter
-	EventsFiltered prometheus.Counter
-	EventsEnqueued prometheus.Counter
-	EnqueueErrors  prometheus.Counter
-	RedisErrors    prometheus.Counter
+	EventsReceived    prometheus.Counter
+	EventsRejected    *prometheus.CounterVec
+	EventsAPIRequests *prometheus.CounterVec
+	EventsFiltered    prometheus.Counter
+	EventsEnqueued    prometheus.Counter
+	EnqueueErrors     prometheus.Counter
+	RedisErrors       prometheus.Counter
+
+	RedisCommandDuration *prometheus.HistogramVec
+
+	LastSuccessfulSync *prometheus.GaugeVec
+	SyncWarnings       *prometheus.CounterVec
+	ForcedStateResets  prometheus.Counter
+	DeletedUsersPurged prometheus.Counter
+	InitialSyncs       *prometheus.CounterVec
+	ReplicationLatency prometheus.Histogram
+	EventLatency       prometheus.Histogram
+	MirrorSubscribers  prometheus.Gauge
+	StandbyConnected   prometheus.Gauge
+	StandbyBacklog     prometheus.Gauge
+	PriorityAdjusted   *prometheus.CounterVec
+	HandlerDuration    *prometheus.HistogramVec
+	SyncThroughput     prometheus.Gauge
+	BacklogETA         prometheus.Gauge
+	DryRunSyncs        *prometheus.CounterVec
+	Leader             prometheus.Gauge
+
+	SweepInProgress    prometheus.Gauge
+	SweepLastCompleted prometheus.Gauge
+	SweepLastSuccess   prometheus.Gauge
+	SweepDuration      *prometheus.HistogramVec
+	SweepUsers         *prometheus.CounterVec
+	MailboxSyncs       *prometheus.CounterVec
+
+	DestinationUp            *prometheus.GaugeVec
+	DestinationProbeFailures *prometheus.CounterVec
+	DestinationHolds         *prometheus.CounterVec
+	FailoverActive           *prometheus.GaugeVec
+	FailoverReconciliations  prometheus.Counter
+	DestinationActiveSyncs   *prometheus.GaugeVec
+	SlowSyncs                *prometheus.CounterVec
+	RetryBudgetExhausted     prometheus.Gauge
+	RetryBudgetDeferrals     prometheus.Counter
+	QueueWait                *prometheus.HistogramVec
+	QueueWaitExceeded        *prometheus.CounterVec
+	DeadLettersRequeued      *prometheus.CounterVec
+	SyncFailures             *prometheus.CounterVec
+	CoolDowns                *prometheus.CounterVec
+	RateLimitWait            prometheus.Counter
+	RateLimitErrors          prometheus.Counter
+	SessionChecks            *prometheus.CounterVec
+	LargeAccountSyncs        prometheus.Gauge
+	LargeAccountDeferrals    prometheus.Counter
+	RedundantSyncsSkipped    prometheus.Counter
+
+	labels *labelLimits
 }
 
 // New creates and registers all metrics.
 func New(reg prometheus.Registerer) *Metrics {
 	m := &Metrics{
+		labels: newLabelLimits(),
 		EventsReceived: prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Name: "dovewarden_events_received_total",
 				Help: "Total number of events received from Dovecot",
 			},
 		),
+		EventsRejected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_events_rejected_total",
+				Help: "Total number of event requests rejected because the source is not in the allowlist, by source IP",
+			},
+			[]string{"source"},
+		),
+		EventsAPIRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_events_api_requests_total",
+				Help: "Total number of event requests by events API version and path, e.g. to find exporters still posting to deprecated paths",
+			},
+			[]string{"version", "path"},
+		),
 		EventsFiltered: prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Name: "dovewarden_events_filtered_total",
@@ -46,14 +112,348 @@ func New(reg prometheus.Registerer) *Metrics {
 				Help: "Total number of Redis operation errors",
 			},
 		),
+		RedisCommandDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "dovewarden_redis_command_duration_seconds",
+				Help:    "Latency of Redis commands including the wait for a pooled connection, by command (pipeline for pipelines and transactions)",
+				Buckets: []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+			},
+			[]string{"command"},
+		),
+		LastSuccessfulSync: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_last_successful_sync_timestamp",
+				Help: "Unix timestamp of the last successful dsync per destination",
+			},
+			[]string{"destination"},
+		),
+		SyncWarnings: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_dsync_warnings_total",
+				Help: "Total number of warnings reported by dsync, by warning type",
+			},
+			[]string{"type"},
+		),
+		ForcedStateResets: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dovewarden_forced_state_resets_total",
+				Help: "Total number of replication states dropped after repeated incremental sync failures",
+			},
+		),
+		DeletedUsersPurged: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dovewarden_deleted_users_purged_total",
+				Help: "Total number of users purged because doveadm reported that they no longer exist",
+			},
+		),
+		InitialSyncs: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_initial_syncs_total",
+				Help: "Total number of first syncs of users that were never replicated, by result",
+			},
+			[]string{"result"},
+		),
+		ReplicationLatency: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name: "dovewarden_replication_latency_seconds",
+				Help: "Time from the first event triggering a sync to its successful completion",
+				// include the SLA targets of 1m, 5m and 1h
+				Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 4 * 3600, 24 * 3600},
+			},
+		),
+		EventLatency: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "dovewarden_event_latency_seconds",
+				Help:    "Time from the Dovecot event triggering a sync, by its own timestamp, to the successful completion of the sync, including the delivery of the event",
+				Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 4 * 3600, 24 * 3600},
+			},
+		),
+		MirrorSubscribers: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_mirror_subscribers",
+				Help: "Number of standbys subscribed to the mirror stream of the queue",
+			},
+		),
+		StandbyConnected: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_standby_connected",
+				Help: "Whether this standby is connected to the mirror stream of its primary (1) or not (0)",
+			},
+		),
+		StandbyBacklog: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_standby_backlog_users",
+				Help: "Number of users queued at the primary as mirrored by this standby, queued locally once it takes over",
+			},
+		),
+		PriorityAdjusted: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_priority_adjusted_total",
+				Help: "Total number of enqueues whose priority factor was out of bounds, by action: clamped to the bounds, or rejected as not finite and positive",
+			},
+			[]string{"action"},
+		),
+		HandlerDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "dovewarden_handler_duration_seconds",
+				Help:    "Time a worker spent handling a dequeued user, i.e. the sync and its bookkeeping, by result (success, failure or postponed) and origin (event, admin, retry, background or unknown)",
+				Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+			},
+			[]string{"result", "origin"},
+		),
+		SyncThroughput: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_sync_throughput_per_second",
+				Help: "Average number of successful syncs per second over the last 5 minutes",
+			},
+		),
+		BacklogETA: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_backlog_eta_seconds",
+				Help: "Estimated time to sync all queued users at the recent throughput; +Inf if the queue is not draining",
+			},
+		),
+		DryRunSyncs: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_dry_run_syncs_total",
+				Help: "Total number of syncs skipped in dry-run mode, by sync type (full or incremental)",
+			},
+			[]string{"type"},
+		),
+		SweepInProgress: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_background_sweep_in_progress",
+				Help: "1 while a background replication sweep is running on this replica, 0 otherwise",
+			},
+		),
+		SweepLastCompleted: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_background_sweep_last_completion_timestamp_seconds",
+				Help: "Unix timestamp of the end of the last background replication sweep, successful or not",
+			},
+		),
+		SweepLastSuccess: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_background_sweep_last_success_timestamp_seconds",
+				Help: "Unix timestamp of the end of the last successful background replication sweep",
+			},
+		),
+		SweepDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "dovewarden_background_sweep_duration_seconds",
+				Help:    "Duration of background replication sweeps, by result (success or failure)",
+				Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 2 * 3600},
+			},
+			[]string{"result"},
+		),
+		SweepUsers: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_background_sweep_users_total",
+				Help: "Total number of users processed by background replication sweeps, by outcome (enqueued, skipped or errored)",
+			},
+			[]string{"outcome"},
+		),
+		Leader: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_leader",
+				Help: "1 if this replica holds the leader lease and runs background replication, 0 otherwise",
+			},
+		),
+		MailboxSyncs: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_mailbox_syncs_total",
+				Help: "Total number of single-mailbox dsyncs of changed mailboxes run in parallel instead of incremental account syncs, by result (success or failure)",
+			},
+			[]string{"result"},
+		),
+		DestinationUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_destination_up",
+				Help: "Whether the sync destination is considered healthy (1) or its syncs are held (0)",
+			},
+			[]string{"destination"},
+		),
+		DestinationProbeFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_destination_probe_failures_total",
+				Help: "Total number of failed health probes of the sync destination",
+			},
+			[]string{"destination"},
+		),
+		DestinationHolds: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_destination_holds_total",
+				Help: "Total number of times the syncs to the destination were held because it was down",
+			},
+			[]string{"destination"},
+		),
+		FailoverActive: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_failover_active",
+				Help: "Whether the syncs to the destination are redirected to its fallback destination (1) or not (0)",
+			},
+			[]string{"destination"},
+		),
+		FailoverReconciliations: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dovewarden_failover_reconciliations_total",
+				Help: "Total number of users synced to the fallback destination that were queued to reconcile the primary destination",
+			},
+		),
+		DestinationActiveSyncs: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_destination_active_syncs",
+				Help: "Number of running syncs to destinations with a concurrency limit",
+			},
+			[]string{"destination"},
+		),
+		SlowSyncs: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_slow_syncs_total",
+				Help: "Total number of syncs that took longer than the slow sync threshold, by sync type (full or incremental)",
+			},
+			[]string{"sync_type"},
+		),
+		RetryBudgetExhausted: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_retry_budget_exhausted",
+				Help: "Whether the retry budget is exhausted and failed users are retried after a delay (1) or right away (0)",
+			},
+		),
+		RetryBudgetDeferrals: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dovewarden_retry_budget_deferrals_total",
+				Help: "Total number of retries deferred because the retry budget was exhausted",
+			},
+		),
+		QueueWait: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "dovewarden_queue_wait_seconds",
+				Help:    "Time users spent in the queue from their first enqueue until they were dequeued, by priority bucket (high, normal, low or overdue)",
+				Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 4 * 3600, 24 * 3600},
+			},
+			[]string{"priority"},
+		),
+		QueueWaitExceeded: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_queue_wait_exceeded_total",
+				Help: "Total number of dequeued users that waited longer than the queue wait threshold, by priority bucket",
+			},
+			[]string{"priority"},
+		),
+		DeadLettersRequeued: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_deadletter_requeued_total",
+				Help: "Total number of dead-lettered users queued again, by trigger (api or redrive)",
+			},
+			[]string{"trigger"},
+		),
+		SyncFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_sync_failures_total",
+				Help: "Total number of failed syncs, by error class (network, auth, tempfail, user-missing, state-invalid, overload or other)",
+			},
+			[]string{"class"},
+		),
+		CoolDowns: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_cooldowns_total",
+				Help: "Total number of cool-downs pausing all syncs, by reason (overload or tempfail)",
+			},
+			[]string{"reason"},
+		),
+		RateLimitWait: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dovewarden_rate_limit_wait_seconds_total",
+				Help: "Total time syncs waited for the doveadm rate limit",
+			},
+		),
+		RateLimitErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dovewarden_rate_limit_errors_total",
+				Help: "Total number of tokens taken from the local fallback because the distributed rate limiter failed",
+			},
+		),
+		SessionChecks: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dovewarden_session_checks_total",
+				Help: "Total number of session checks before syncs, by result (idle, deferred, max_delay or error)",
+			},
+			[]string{"result"},
+		),
+		LargeAccountSyncs: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dovewarden_large_account_syncs",
+				Help: "Number of syncs of large accounts currently running in the large account lane",
+			},
+		),
+		LargeAccountDeferrals: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dovewarden_large_account_deferrals_total",
+				Help: "Total number of syncs of large accounts deferred because all slots of the large account lane were taken",
+			},
+		),
+		RedundantSyncsSkipped: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dovewarden_redundant_syncs_skipped_total",
+				Help: "Total number of syncs skipped because the user was synced recently and had no events since",
+			},
+		),
 	}
 
 	reg.MustRegister(
 		m.EventsReceived,
+		m.EventsRejected,
+		m.EventsAPIRequests,
 		m.EventsFiltered,
 		m.EventsEnqueued,
 		m.EnqueueErrors,
 		m.RedisErrors,
+		m.RedisCommandDuration,
+		m.LastSuccessfulSync,
+		m.SyncWarnings,
+		m.ForcedStateResets,
+		m.DeletedUsersPurged,
+		m.InitialSyncs,
+		m.ReplicationLatency,
+		m.EventLatency,
+		m.MirrorSubscribers,
+		m.StandbyConnected,
+		m.StandbyBacklog,
+		m.PriorityAdjusted,
+		m.HandlerDuration,
+		m.SyncThroughput,
+		m.BacklogETA,
+		m.DryRunSyncs,
+		m.Leader,
+		m.SweepInProgress,
+		m.SweepLastCompleted,
+		m.SweepLastSuccess,
+		m.SweepDuration,
+		m.SweepUsers,
+		m.MailboxSyncs,
+		m.DestinationUp,
+		m.DestinationProbeFailures,
+		m.DestinationHolds,
+		m.FailoverActive,
+		m.FailoverReconciliations,
+		m.DestinationActiveSyncs,
+		m.SlowSyncs,
+		m.RetryBudgetExhausted,
+		m.RetryBudgetDeferrals,
+		m.QueueWait,
+		m.QueueWaitExceeded,
+		m.DeadLettersRequeued,
+		m.SyncFailures,
+		m.CoolDowns,
+		m.RateLimitWait,
+		m.RateLimitErrors,
+		m.SessionChecks,
+		m.LargeAccountSyncs,
+		m.LargeAccountDeferrals,
+		m.RedundantSyncsSkipped,
+		m.labels.truncated,
+		m.labels.values,
 	)
 
 	return m
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisHook records the latency and errors of the commands of a go-redis client.
+type redisHook struct {
+	m *Metrics
+}
+
+// InstrumentRedis adds a hook to client that records the latency of its
+// commands in RedisCommandDuration and counts failed commands and dials in
+// RedisErrors. Missing keys (redis.Nil) are not errors. Must be called before
+// the client is used concurrently.
+func (m *Metrics) InstrumentRedis(client *redis.Client) {
+	client.AddHook(redisHook{m: m})
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			h.m.RedisErrors.Inc()
+		}
+		return conn, err
+	}
+}
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.m.RedisCommandDuration.WithLabelValues(cmd.Name()).Observe(time.Since(start).Seconds())
+		if isRedisError(err) {
+			h.m.RedisErrors.Inc()
+		}
+		return err
+	}
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.m.RedisCommandDuration.WithLabelValues("pipeline").Observe(time.Since(start).Seconds())
+		for _, cmd := range cmds {
+			if isRedisError(cmd.Err()) {
+				h.m.RedisErrors.Inc()
+			}
+		}
+		return err
+	}
+}
+
+func isRedisError(err error) bool {
+	return err != nil && !errors.Is(err, redis.Nil)
+}
+
+// RedisPoolCollector exposes the connection pool statistics of a go-redis
+// client, which tell whether commands wait for connections.
+type RedisPoolCollector struct {
+	stats func() *redis.PoolStats
+
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	timeouts    *prometheus.Desc
+	waits       *prometheus.Desc
+	waitSeconds *prometheus.Desc
+	stale       *prometheus.Desc
+	conns       *prometheus.Desc
+}
+
+// NewRedisPoolCollector creates a collector reading the pool statistics from
+// stats, usually the PoolStats method of a client.
+func NewRedisPoolCollector(stats func() *redis.PoolStats) *RedisPoolCollector {
+	return &RedisPoolCollector{
+		stats:       stats,
+		hits:        prometheus.NewDesc("dovewarden_redis_pool_hits_total", "Total number of times a free connection was found in the Redis pool", nil, nil),
+		misses:      prometheus.NewDesc("dovewarden_redis_pool_misses_total", "Total number of times no free connection was found in the Redis pool", nil, nil),
+		timeouts:    prometheus.NewDesc("dovewarden_redis_pool_timeouts_total", "Total number of times waiting for a Redis pool connection timed out", nil, nil),
+		waits:       prometheus.NewDesc("dovewarden_redis_pool_waits_total", "Total number of times a command waited for a Redis pool connection", nil, nil),
+		waitSeconds: prometheus.NewDesc("dovewarden_redis_pool_wait_seconds_total", "Total time spent waiting for Redis pool connections", nil, nil),
+		stale:       prometheus.NewDesc("dovewarden_redis_pool_stale_connections_total", "Total number of stale connections removed from the Redis pool", nil, nil),
+		conns:       prometheus.NewDesc("dovewarden_redis_pool_connections", "Number of connections in the Redis pool, by state (idle or total)", []string{"state"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *RedisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.timeouts
+	ch <- c.waits
+	ch <- c.waitSeconds
+	ch <- c.stale
+	ch <- c.conns
+}
+
+// Collect implements prometheus.Collector.
+func (c *RedisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(s.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.waits, prometheus.CounterValue, float64(s.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitSeconds, prometheus.CounterValue, time.Duration(s.WaitDurationNs).Seconds())
+	ch <- prometheus.MustNewConstMetric(c.stale, prometheus.CounterValue, float64(s.StaleConns))
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.IdleConns), "idle")
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.TotalConns), "total")
+}
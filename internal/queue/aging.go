@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// AgingService periodically promotes users that have been queued for longer than
+// maxDelay to the head of the queue, guaranteeing that low-priority users are
+// eventually synced even under a constant stream of high-priority events.
+type AgingService struct {
+	queue    Queue
+	logger   *slog.Logger
+	maxDelay time.Duration
+	interval time.Duration
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewAgingService creates a new queue aging service.
+// The aging pass runs every maxDelay/10, but at least once per second.
+func NewAgingService(queue Queue, logger *slog.Logger, maxDelay time.Duration) *AgingService {
+	interval := maxDelay / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return &AgingService{
+		queue:    queue,
+		logger:   logger,
+		maxDelay: maxDelay,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// Start begins the periodic aging pass.
+func (s *AgingService) Start(ctx context.Context) {
+	s.logger.Info("Starting queue aging service", "max_delay", s.maxDelay, "interval", s.interval)
+
+	go func() {
+		defer close(s.doneCh)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopCh:
+				s.logger.Info("Queue aging service stopping")
+				return
+			case <-ticker.C:
+				promoted, err := s.queue.PromoteOverdue(ctx, s.maxDelay)
+				if err != nil {
+					s.logger.Error("Queue aging pass failed", "error", err)
+					continue
+				}
+				if promoted > 0 {
+					s.logger.Info("Promoted overdue users to the head of the queue", "count", promoted, "max_delay", s.maxDelay)
+				}
+			}
+		}
+	}()
+}
+
+// Stop gracefully stops the aging service.
+func (s *AgingService) Stop(ctx context.Context) error {
+	close(s.stopCh)
+
+	select {
+	case <-s.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestPromoteOverdue(t *testing.T) {
+	q, err := NewInMemoryQueue("testaging", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, "user-low", 0.5); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	// backdate the first-enqueue time so user-low is overdue
+	if err := q.client.ZAdd(ctx, q.ns+":"+ENQUEUED_AT, redis.Z{
+		Score:  float64(time.Now().Add(-2 * time.Hour).Unix()),
+		Member: "user-low",
+	}).Err(); err != nil {
+		t.Fatalf("backdate: %v", err)
+	}
+	if err := q.Enqueue(ctx, "user-high", 10.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	if order := getQueueOrder(t, q); order[0] != "user-high" {
+		t.Fatalf("expected user-high first before aging, got %v", order)
+	}
+
+	promoted, err := q.PromoteOverdue(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	if promoted != 1 {
+		t.Fatalf("expected 1 promoted user, got %d", promoted)
+	}
+	if order := getQueueOrder(t, q); order[0] != "user-low" {
+		t.Fatalf("expected user-low first after aging, got %v", order)
+	}
+
+	// a new high-priority event must not overtake the promoted user
+	if err := q.Enqueue(ctx, "user-high", 100.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	user, err := q.Dequeue(ctx)
+	if err != nil || user.Username != "user-low" {
+		t.Fatalf("expected user-low to be dequeued first, got %q (err %v)", user.Username, err)
+	}
+
+	// dequeued users lose their first-enqueue time
+	if n := q.client.ZCard(ctx, q.ns+":"+ENQUEUED_AT).Val(); n != 1 {
+		t.Fatalf("expected only user-high to keep an enqueue time, got %d entries", n)
+	}
+	promoted, err = q.PromoteOverdue(ctx, time.Hour)
+	if err != nil || promoted != 0 {
+		t.Fatalf("expected nothing to promote, got %d (err %v)", promoted, err)
+	}
+}
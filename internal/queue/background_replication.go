@@ -2,27 +2,54 @@ package queue
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dovewarden/dovewarden/internal/doveadm"
+	"github.com/dovewarden/dovewarden/internal/metrics"
 )
 
+// sweepCheckpointInterval is the number of users processed between two
+// checkpoints of a sweep. A sweep resuming after a crash processes up to this
+// many users twice.
+const sweepCheckpointInterval = 100
+
+// errSweepInterrupted is returned by a sweep stopped before it processed all
+// users. Its checkpoint is kept, so the next sweep resumes where it stopped.
+var errSweepInterrupted = errors.New("background replication sweep interrupted")
+
 // BackgroundReplicationService manages periodic background replication
 type BackgroundReplicationService struct {
 	client    *doveadm.Client
 	queue     Queue
+	metrics   *metrics.Metrics
 	logger    *slog.Logger
 	interval  time.Duration
 	threshold time.Duration
 	stopCh    chan struct{}
 	doneCh    chan struct{}
+	runNowCh  chan struct{}
+	isLeader  func() bool
+	spread    bool
+	activity  ActivityThresholds
+	limits    EnqueueLimits
+
+	mu           sync.Mutex
+	lastSweep    time.Time
+	lastSweepErr error
+	sweepRunning bool
 }
 
 // NewBackgroundReplicationService creates a new background replication service
 func NewBackgroundReplicationService(
 	client *doveadm.Client,
 	queue Queue,
+	m *metrics.Metrics,
 	logger *slog.Logger,
 	interval time.Duration,
 	threshold time.Duration,
@@ -30,12 +57,93 @@ func NewBackgroundReplicationService(
 	return &BackgroundReplicationService{
 		client:    client,
 		queue:     queue,
+		metrics:   m,
 		logger:    logger,
 		interval:  interval,
 		threshold: threshold,
 		stopCh:    make(chan struct{}),
 		doneCh:    make(chan struct{}),
+		runNowCh:  make(chan struct{}, 1),
+		limits:    EnqueueLimits{Priority: 1.0},
+	}
+}
+
+// SetLeaderCheck restricts sweeps to the replica for which isLeader returns
+// true, so that only one of several replicas lists and enqueues all users.
+// Must be called before Start.
+func (s *BackgroundReplicationService) SetLeaderCheck(isLeader func() bool) {
+	s.isLeader = isLeader
+}
+
+// SetSpread spreads the enqueues of a sweep evenly over the interval instead
+// of enqueueing all users due at once. Must be called before Start.
+func (s *BackgroundReplicationService) SetSpread(spread bool) {
+	s.spread = spread
+}
+
+// EnqueueLimits bound the enqueues of background replication, so that a
+// sweep after a long outage does not queue the whole user base at once ahead
+// of event-driven syncs.
+type EnqueueLimits struct {
+	MaxPerSweep  int     // enqueues per sweep; the next sweep continues after the last processed user. 0 disables
+	MaxPerMinute int     // enqueues per minute; 0 disables
+	Priority     float64 // priority factor of enqueued users; below 1 events of other users are synced first
+}
+
+// SetEnqueueLimits caps the enqueues of sweeps and sets their priority. Must
+// be called before Start.
+func (s *BackgroundReplicationService) SetEnqueueLimits(limits EnqueueLimits) {
+	if limits.Priority <= 0 {
+		limits.Priority = 1.0
+	}
+	s.limits = limits
+}
+
+// ActivityThresholds adapt the threshold of background replication to the
+// activity of a user, judged by its last event: users with an event within
+// ActiveWindow are enqueued once not replicated for ActiveThreshold, all others
+// once not replicated for DormantThreshold. A zero ActiveWindow disables them.
+type ActivityThresholds struct {
+	ActiveWindow     time.Duration
+	ActiveThreshold  time.Duration
+	DormantThreshold time.Duration
+}
+
+// SetActivityThresholds replaces the fixed threshold by thresholds depending
+// on the activity of each user. Must be called before Start.
+func (s *BackgroundReplicationService) SetActivityThresholds(t ActivityThresholds) {
+	s.activity = t
+}
+
+// thresholdOf returns the threshold of a user. Without activity thresholds, or
+// if the last event time cannot be read, it is the fixed threshold.
+func (s *BackgroundReplicationService) thresholdOf(ctx context.Context, username string) time.Duration {
+	if s.activity.ActiveWindow <= 0 {
+		return s.threshold
+	}
+	lastEvent, err := s.queue.GetLastEventTime(ctx, username)
+	if err != nil {
+		s.logger.Warn("Failed to get last event time, using the default threshold", "username", username, "error", err)
+		return s.threshold
 	}
+	if !lastEvent.IsZero() && time.Since(lastEvent) < s.activity.ActiveWindow {
+		return s.activity.ActiveThreshold
+	}
+	return s.activity.DormantThreshold
+}
+
+// RunNow requests a sweep without waiting for the next interval, e.g. after
+// becoming leader. It does not block; a pending request is not duplicated.
+func (s *BackgroundReplicationService) RunNow() {
+	select {
+	case s.runNowCh <- struct{}{}:
+	default:
+	}
+}
+
+// leader reports whether this replica may run sweeps.
+func (s *BackgroundReplicationService) leader() bool {
+	return s.isLeader == nil || s.isLeader()
 }
 
 // Start begins the background replication service
@@ -50,9 +158,13 @@ func (s *BackgroundReplicationService) Start(ctx context.Context) {
 		defer close(s.doneCh)
 
 		// Run once immediately on startup
-		s.logger.Info("Running initial background replication")
-		if err := s.runReplication(ctx); err != nil {
-			s.logger.Error("Initial background replication failed", "error", err)
+		if s.leader() {
+			s.logger.Info("Running initial background replication")
+			if err := s.runReplication(ctx); errors.Is(err, errSweepInterrupted) {
+				s.logger.Info("Initial background replication interrupted, the next sweep resumes it", "error", err)
+			} else if err != nil {
+				s.logger.Error("Initial background replication failed", "error", err)
+			}
 		}
 
 		ticker := time.NewTicker(s.interval)
@@ -64,10 +176,17 @@ func (s *BackgroundReplicationService) Start(ctx context.Context) {
 				s.logger.Info("Background replication service stopping")
 				return
 			case <-ticker.C:
-				s.logger.Info("Running periodic background replication")
-				if err := s.runReplication(ctx); err != nil {
-					s.logger.Error("Background replication failed", "error", err)
-				}
+			case <-s.runNowCh:
+			}
+			if !s.leader() {
+				s.logger.Debug("Skipping background replication, not the leader")
+				continue
+			}
+			s.logger.Info("Running periodic background replication")
+			if err := s.runReplication(ctx); errors.Is(err, errSweepInterrupted) {
+				s.logger.Info("Background replication interrupted, the next sweep resumes it", "error", err)
+			} else if err != nil {
+				s.logger.Error("Background replication failed", "error", err)
 			}
 		}
 	}()
@@ -88,9 +207,40 @@ func (s *BackgroundReplicationService) Stop(ctx context.Context) error {
 	}
 }
 
-// runReplication lists all users and enqueues those that need replication
-func (s *BackgroundReplicationService) runReplication(ctx context.Context) error {
+// runReplication lists all users and enqueues those that need replication.
+// Users are processed in username order and the last processed one is
+// checkpointed in the queue, so that a sweep interrupted by a restart, Stop or
+// the loss of leadership resumes after it instead of starting over.
+func (s *BackgroundReplicationService) runReplication(ctx context.Context) (err error) {
 	startTime := time.Now()
+	s.mu.Lock()
+	s.sweepRunning = true
+	s.mu.Unlock()
+	s.metrics.SweepInProgress.Set(1)
+	defer func() {
+		s.metrics.SweepInProgress.Set(0)
+		now := time.Now()
+		s.mu.Lock()
+		s.sweepRunning = false
+		if !errors.Is(err, errSweepInterrupted) {
+			s.lastSweep = now
+			s.lastSweepErr = err
+		}
+		s.mu.Unlock()
+		if errors.Is(err, errSweepInterrupted) {
+			return
+		}
+
+		result := "success"
+		if err != nil {
+			result = "failure"
+		} else {
+			s.metrics.SweepLastSuccess.Set(float64(now.Unix()))
+		}
+		s.metrics.SweepLastCompleted.Set(float64(now.Unix()))
+		s.metrics.SweepDuration.WithLabelValues(result).Observe(now.Sub(startTime).Seconds())
+	}()
+
 	s.logger.Debug("Listing users from doveadm API")
 
 	// List all users from doveadm
@@ -98,14 +248,56 @@ func (s *BackgroundReplicationService) runReplication(ctx context.Context) error
 	if err != nil {
 		return err
 	}
+	slices.SortFunc(users, func(a, b doveadm.User) int {
+		return strings.Compare(a.Username, b.Username)
+	})
 
 	s.logger.Info("Retrieved user list from doveadm", "count", len(users))
 
+	// Resume after the checkpoint of an interrupted sweep
+	first := 0
+	cursor, err := s.queue.GetSweepCursor(ctx)
+	if err != nil {
+		s.logger.Warn("Failed to read the sweep checkpoint, starting from the first user", "error", err)
+	} else if cursor != "" {
+		first, _ = slices.BinarySearchFunc(users, cursor, func(u doveadm.User, name string) int {
+			return strings.Compare(u.Username, name)
+		})
+		if first < len(users) && users[first].Username == cursor {
+			first++
+		}
+		s.logger.Info("Resuming interrupted background replication", "after", cursor, "remaining", len(users)-first)
+	}
+
+	// Spread the enqueues of a whole sweep over the interval; a resumed sweep
+	// keeps the pace of a complete one
+	var pace time.Duration
+	if s.spread && len(users) > 0 {
+		pace = s.interval / time.Duration(len(users))
+	}
+	if s.limits.MaxPerMinute > 0 {
+		pace = max(pace, time.Minute/time.Duration(s.limits.MaxPerMinute))
+	}
+
 	// Track statistics
 	var enqueuedCount, skippedCount, errorCount int
+	capped := false
 
 	// Process each user
-	for _, user := range users {
+	for i := first; i < len(users); i++ {
+		user := users[i]
+		if i > first && (i-first)%sweepCheckpointInterval == 0 {
+			s.checkpoint(ctx, users[i-1].Username)
+			if !s.leader() {
+				return fmt.Errorf("%w: no longer the leader", errSweepInterrupted)
+			}
+			select {
+			case <-s.stopCh:
+				return errSweepInterrupted
+			default:
+			}
+		}
+
 		// Check if this user was replicated recently
 		lastReplication, err := s.queue.GetLastReplicationTime(ctx, user.Username)
 		if err != nil {
@@ -114,27 +306,49 @@ func (s *BackgroundReplicationService) runReplication(ctx context.Context) error
 				"error", err,
 			)
 			errorCount++
+			s.metrics.SweepUsers.WithLabelValues("errored").Inc()
 			// Continue to enqueue in case of error
 		}
 
 		// Skip if user was replicated within the threshold
-		if !lastReplication.IsZero() && time.Since(lastReplication) < s.threshold {
-			s.logger.Debug("Skipping user - recently replicated",
-				"username", user.Username,
-				"last_replication", lastReplication,
-				"age", time.Since(lastReplication),
+		if !lastReplication.IsZero() {
+			if threshold := s.thresholdOf(ctx, user.Username); time.Since(lastReplication) < threshold {
+				s.logger.Debug("Skipping user - recently replicated",
+					"username", user.Username,
+					"last_replication", lastReplication,
+					"age", time.Since(lastReplication),
+					"threshold", threshold,
+				)
+				skippedCount++
+				s.metrics.SweepUsers.WithLabelValues("skipped").Inc()
+				continue
+			}
+		}
+
+		if s.limits.MaxPerSweep > 0 && enqueuedCount >= s.limits.MaxPerSweep {
+			// continue after the last processed user in the next sweep
+			s.checkpoint(ctx, users[i-1].Username)
+			s.logger.Info("Background replication reached its enqueue limit, the next sweep continues",
+				"limit", s.limits.MaxPerSweep,
+				"remaining", len(users)-i,
 			)
-			skippedCount++
-			continue
+			capped = true
+			break
+		}
+		if pace > 0 && enqueuedCount > 0 && !s.wait(ctx, pace) {
+			if i > 0 {
+				s.checkpoint(ctx, users[i-1].Username)
+			}
+			return errSweepInterrupted
 		}
 
-		// Enqueue user for replication with normal priority
-		if err := s.queue.Enqueue(ctx, user.Username, 1.0); err != nil {
+		if err := s.queue.EnqueueEvent(ctx, user.Username, s.limits.Priority, &EventInfo{Origin: OriginBackground}); err != nil {
 			s.logger.Error("Failed to enqueue user for background replication",
 				"username", user.Username,
 				"error", err,
 			)
 			errorCount++
+			s.metrics.SweepUsers.WithLabelValues("errored").Inc()
 			continue
 		}
 
@@ -143,16 +357,68 @@ func (s *BackgroundReplicationService) runReplication(ctx context.Context) error
 			"last_replication", lastReplication,
 		)
 		enqueuedCount++
+		s.metrics.SweepUsers.WithLabelValues("enqueued").Inc()
+	}
+	if !capped {
+		s.checkpoint(ctx, "")
 	}
 
 	duration := time.Since(startTime)
 	s.logger.Info("Background replication completed",
 		"duration", duration,
 		"total_users", len(users),
+		"resumed_at", first,
 		"enqueued", enqueuedCount,
 		"skipped", skippedCount,
 		"errors", errorCount,
+		"limited", capped,
 	)
 
 	return nil
 }
+
+// wait pauses a sweep for d. It returns false if the service is stopped or
+// ctx ends meanwhile.
+func (s *BackgroundReplicationService) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.stopCh:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// checkpoint stores the last processed user of the running sweep, or removes
+// the checkpoint if cursor is empty. A failure is logged only; the sweep then
+// resumes from an older checkpoint.
+func (s *BackgroundReplicationService) checkpoint(ctx context.Context, cursor string) {
+	if err := s.queue.SetSweepCursor(context.WithoutCancel(ctx), cursor); err != nil {
+		s.logger.Warn("Failed to store the sweep checkpoint", "cursor", cursor, "error", err)
+	}
+}
+
+// SweepStatus describes the most recent background replication sweep.
+type SweepStatus struct {
+	LastSweep time.Time // completion time of the last sweep; zero if none finished yet
+	LastError error     // error of the last sweep, nil if it succeeded
+	Running   bool      // a sweep is currently in progress
+	Interval  time.Duration
+	Leader    bool // this replica runs the sweeps
+}
+
+// SweepStatus returns the state of the most recent sweep.
+func (s *BackgroundReplicationService) SweepStatus() SweepStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SweepStatus{
+		LastSweep: s.lastSweep,
+		LastError: s.lastSweepErr,
+		Running:   s.sweepRunning,
+		Interval:  s.interval,
+		Leader:    s.leader(),
+	}
+}
@@ -0,0 +1,200 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+	"github.com/dovewarden/dovewarden/internal/doveadm/doveadmtest"
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBackgroundReplicationSweepMetrics(t *testing.T) {
+	srv := httptest.NewServer(doveadmtest.New("secret", []string{"user-a", "user-b", "user-c"}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	q := NewNativeQueue(testLogger())
+	if err := q.SetLastReplicationTime(ctx, "user-b", time.Now()); err != nil {
+		t.Fatalf("SetLastReplicationTime: %v", err)
+	}
+	m := metrics.New(prometheus.NewRegistry())
+	s := NewBackgroundReplicationService(doveadm.NewClient(srv.URL, "secret"), q, m, testLogger(), time.Hour, 24*time.Hour)
+
+	if err := s.runReplication(ctx); err != nil {
+		t.Fatalf("runReplication: %v", err)
+	}
+	if got := testutil.ToFloat64(m.SweepUsers.WithLabelValues("enqueued")); got != 2 {
+		t.Errorf("enqueued = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.SweepUsers.WithLabelValues("skipped")); got != 1 {
+		t.Errorf("skipped = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.SweepInProgress); got != 0 {
+		t.Errorf("in progress = %v, want 0", got)
+	}
+	success := testutil.ToFloat64(m.SweepLastSuccess)
+	if success == 0 || testutil.ToFloat64(m.SweepLastCompleted) != success {
+		t.Errorf("unexpected sweep timestamps: success %v, completion %v", success, testutil.ToFloat64(m.SweepLastCompleted))
+	}
+
+	// a failed sweep updates the completion but not the success timestamp
+	failing := NewBackgroundReplicationService(doveadm.NewClient(srv.URL, "wrong"), q, m, testLogger(), time.Hour, 24*time.Hour)
+	if err := failing.runReplication(ctx); err == nil {
+		t.Fatal("expected the sweep to fail")
+	}
+	if got := testutil.ToFloat64(m.SweepLastSuccess); got != success {
+		t.Errorf("last success = %v, want %v", got, success)
+	}
+	if n := testutil.CollectAndCount(m.SweepDuration, "dovewarden_background_sweep_duration_seconds"); n != 2 {
+		t.Errorf("got %d duration series, want 2", n)
+	}
+}
+
+func TestBackgroundReplicationResume(t *testing.T) {
+	srv := httptest.NewServer(doveadmtest.New("secret", []string{"user-c", "user-a", "user-b"}))
+	defer srv.Close()
+
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		if err := q.SetSweepCursor(ctx, "user-a"); err != nil {
+			t.Fatalf("SetSweepCursor: %v", err)
+		}
+		s := NewBackgroundReplicationService(doveadm.NewClient(srv.URL, "secret"), q, metrics.New(prometheus.NewRegistry()), testLogger(), time.Hour, 24*time.Hour)
+		if err := s.runReplication(ctx); err != nil {
+			t.Fatalf("runReplication: %v", err)
+		}
+
+		// the sweep resumed after user-a and removed the checkpoint when done
+		var queued []string
+		for _, u := range nextUsers(t, q) {
+			queued = append(queued, u.Username)
+		}
+		slices.Sort(queued)
+		if !slices.Equal(queued, []string{"user-b", "user-c"}) {
+			t.Errorf("queued %v, want user-b and user-c", queued)
+		}
+		if cursor, err := q.GetSweepCursor(ctx); err != nil || cursor != "" {
+			t.Errorf("cursor = %q, %v; want empty", cursor, err)
+		}
+	})
+}
+
+func TestBackgroundReplicationSpread(t *testing.T) {
+	srv := httptest.NewServer(doveadmtest.New("secret", []string{"user-a", "user-b", "user-c"}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	q := NewNativeQueue(testLogger())
+	m := metrics.New(prometheus.NewRegistry())
+	s := NewBackgroundReplicationService(doveadm.NewClient(srv.URL, "secret"), q, m, testLogger(), 300*time.Millisecond, 24*time.Hour)
+	s.SetSpread(true)
+
+	// the enqueues are spaced by a third of the interval, so stopping the
+	// service after the first one interrupts the sweep
+	done := make(chan error, 1)
+	go func() { done <- s.runReplication(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+	close(s.stopCh)
+	if err := <-done; !errors.Is(err, errSweepInterrupted) {
+		t.Fatalf("runReplication = %v, want interruption", err)
+	}
+	if n, _ := q.Len(ctx); n != 1 {
+		t.Errorf("queued %d users, want 1", n)
+	}
+	if cursor, _ := q.GetSweepCursor(ctx); cursor != "user-a" {
+		t.Errorf("cursor = %q, want user-a", cursor)
+	}
+	if status := s.SweepStatus(); !status.LastSweep.IsZero() || status.LastError != nil {
+		t.Errorf("interrupted sweep recorded as completed: %+v", status)
+	}
+	if got := testutil.ToFloat64(m.SweepInProgress); got != 0 {
+		t.Errorf("in progress = %v, want 0", got)
+	}
+}
+
+func TestBackgroundReplicationActivityThresholds(t *testing.T) {
+	srv := httptest.NewServer(doveadmtest.New("secret", []string{"active", "dormant", "unknown"}))
+	defer srv.Close()
+
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		now := time.Now()
+		// all users were replicated 12 hours ago, only active had an event since
+		for _, username := range []string{"active", "dormant", "unknown"} {
+			if err := q.SetLastReplicationTime(ctx, username, now.Add(-12*time.Hour)); err != nil {
+				t.Fatalf("SetLastReplicationTime: %v", err)
+			}
+		}
+		if err := q.EnqueueEvent(ctx, "active", 1.0, &EventInfo{TriggeredAt: now.Add(-time.Hour)}); err != nil {
+			t.Fatalf("EnqueueEvent: %v", err)
+		}
+		if err := q.EnqueueEvent(ctx, "dormant", 1.0, &EventInfo{TriggeredAt: now.Add(-72 * time.Hour)}); err != nil {
+			t.Fatalf("EnqueueEvent: %v", err)
+		}
+		for range 2 {
+			if _, err := q.Dequeue(ctx); err != nil {
+				t.Fatalf("Dequeue: %v", err)
+			}
+		}
+		for _, username := range []string{"active", "dormant"} {
+			if err := q.Ack(ctx, username); err != nil {
+				t.Fatalf("Ack: %v", err)
+			}
+		}
+
+		s := NewBackgroundReplicationService(doveadm.NewClient(srv.URL, "secret"), q, metrics.New(prometheus.NewRegistry()), testLogger(), time.Hour, time.Hour)
+		s.SetActivityThresholds(ActivityThresholds{
+			ActiveWindow:     24 * time.Hour,
+			ActiveThreshold:  6 * time.Hour,
+			DormantThreshold: 7 * 24 * time.Hour,
+		})
+		if err := s.runReplication(ctx); err != nil {
+			t.Fatalf("runReplication: %v", err)
+		}
+		// the fixed threshold of one hour would have enqueued all users
+		if next := nextUsers(t, q); len(next) != 1 || next[0].Username != "active" {
+			t.Errorf("queued %+v, want only active", next)
+		}
+	})
+}
+
+func TestBackgroundReplicationEnqueueLimits(t *testing.T) {
+	srv := httptest.NewServer(doveadmtest.New("secret", []string{"user-a", "user-b", "user-c"}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	q := NewNativeQueue(testLogger())
+	s := NewBackgroundReplicationService(doveadm.NewClient(srv.URL, "secret"), q, metrics.New(prometheus.NewRegistry()), testLogger(), time.Hour, time.Hour)
+	s.SetEnqueueLimits(EnqueueLimits{MaxPerSweep: 2, Priority: 0.5})
+	if err := q.Enqueue(ctx, "event-user", 1.0); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	// the first sweep stops after two enqueues, the second one continues
+	if err := s.runReplication(ctx); err != nil {
+		t.Fatalf("runReplication: %v", err)
+	}
+	next := nextUsers(t, q)
+	if len(next) != 3 || next[0].Username != "event-user" {
+		t.Fatalf("queued %+v, want event-user ahead of two background users", next)
+	}
+	if cursor, _ := q.GetSweepCursor(ctx); cursor != "user-b" {
+		t.Errorf("cursor = %q, want user-b", cursor)
+	}
+	if err := s.runReplication(ctx); err != nil {
+		t.Fatalf("runReplication: %v", err)
+	}
+	if next := nextUsers(t, q); len(next) != 4 || next[3].Username != "user-c" {
+		t.Errorf("queued %+v, want user-c added", next)
+	}
+	if cursor, _ := q.GetSweepCursor(ctx); cursor != "" {
+		t.Errorf("cursor = %q, want empty", cursor)
+	}
+}
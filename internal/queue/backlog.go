@@ -0,0 +1,181 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+)
+
+// throughputWindow is the time span over which the recent sync throughput is
+// averaged for the backlog ETA.
+const throughputWindow = 5 * time.Minute
+
+// backlogUpdateInterval is how often the backlog gauges are refreshed.
+const backlogUpdateInterval = 15 * time.Second
+
+// Throughput counts completed syncs in one-second slots over a sliding window.
+type Throughput struct {
+	mu      sync.Mutex
+	started time.Time
+	slots   []int64
+	seconds []int64 // unix second each slot was last counted for
+}
+
+// NewThroughput creates a throughput counter over the given window.
+func NewThroughput(window time.Duration) *Throughput {
+	n := int(window / time.Second)
+	if n < 1 {
+		n = 1
+	}
+	return &Throughput{
+		started: time.Now(),
+		slots:   make([]int64, n),
+		seconds: make([]int64, n),
+	}
+}
+
+// Add counts a sync completed at the given time.
+func (t *Throughput) Add(at time.Time) {
+	sec := at.Unix()
+	i := int(sec % int64(len(t.slots)))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seconds[i] != sec {
+		t.seconds[i] = sec
+		t.slots[i] = 0
+	}
+	t.slots[i]++
+}
+
+// Rate returns the average number of completed syncs per second within the
+// window before now. Shortly after startup, only the elapsed time is averaged
+// over.
+func (t *Throughput) Rate(now time.Time) float64 {
+	window := int64(len(t.slots))
+	sec := now.Unix()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var total int64
+	for i, s := range t.seconds {
+		if s > sec-window && s <= sec {
+			total += t.slots[i]
+		}
+	}
+	elapsed := sec - t.started.Unix() + 1
+	if elapsed > window {
+		elapsed = window
+	}
+	return float64(total) / float64(elapsed)
+}
+
+// BacklogEstimate is the estimated time to sync all currently queued users.
+type BacklogEstimate struct {
+	Queued              int64     `json:"queued"`
+	ThroughputPerSecond float64   `json:"throughput_per_second"`
+	Drainable           bool      `json:"drainable"`                   // false if the queue is not empty but no syncs completed recently
+	ETASeconds          float64   `json:"eta_seconds"`                 // 0 if the queue is empty or not drainable
+	EstimatedDrainAt    time.Time `json:"estimated_drain_at,omitzero"` // zero if not drainable
+	GeneratedAt         time.Time `json:"generated_at"`
+}
+
+// BacklogEstimator estimates the time to drain the queue from its length and
+// the recent sync throughput, and exports the estimate as gauges.
+type BacklogEstimator struct {
+	queue      Queue
+	throughput *Throughput
+	metrics    *metrics.Metrics
+	logger     *slog.Logger
+	stopCh     chan struct{}
+	doneCh     chan struct{}
+}
+
+// NewBacklogEstimator creates an estimator using the throughput of the given
+// worker pool.
+func NewBacklogEstimator(q Queue, workers *WorkerPool, m *metrics.Metrics, logger *slog.Logger) *BacklogEstimator {
+	return &BacklogEstimator{
+		queue:      q,
+		throughput: workers.Throughput(),
+		metrics:    m,
+		logger:     logger,
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+}
+
+// Estimate computes the backlog ETA from the current queue length.
+func (e *BacklogEstimator) Estimate(ctx context.Context) (*BacklogEstimate, error) {
+	queued, err := e.queue.Len(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	estimate := &BacklogEstimate{
+		Queued:              queued,
+		ThroughputPerSecond: e.throughput.Rate(now),
+		Drainable:           true,
+		GeneratedAt:         now,
+	}
+	switch {
+	case queued == 0:
+		estimate.EstimatedDrainAt = now
+	case estimate.ThroughputPerSecond == 0:
+		estimate.Drainable = false
+	default:
+		estimate.ETASeconds = float64(queued) / estimate.ThroughputPerSecond
+		estimate.EstimatedDrainAt = now.Add(time.Duration(estimate.ETASeconds * float64(time.Second)))
+	}
+	return estimate, nil
+}
+
+// Start periodically refreshes the backlog gauges.
+func (e *BacklogEstimator) Start(ctx context.Context) {
+	go func() {
+		defer close(e.doneCh)
+
+		ticker := time.NewTicker(backlogUpdateInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-e.stopCh:
+				return
+			case <-ticker.C:
+				e.update(ctx)
+			}
+		}
+	}()
+}
+
+// update sets the gauges from a fresh estimate. A backlog that is not draining
+// is reported with an infinite ETA.
+func (e *BacklogEstimator) update(ctx context.Context) {
+	estimate, err := e.Estimate(ctx)
+	if err != nil {
+		e.logger.Warn("Failed to estimate backlog drain time", "error", err)
+		return
+	}
+	e.metrics.SyncThroughput.Set(estimate.ThroughputPerSecond)
+	if estimate.Drainable {
+		e.metrics.BacklogETA.Set(estimate.ETASeconds)
+	} else {
+		e.metrics.BacklogETA.Set(math.Inf(1))
+	}
+}
+
+// Stop stops refreshing the gauges.
+func (e *BacklogEstimator) Stop(ctx context.Context) error {
+	close(e.stopCh)
+
+	select {
+	case <-e.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
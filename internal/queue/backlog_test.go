@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestThroughputRate(t *testing.T) {
+	tp := NewThroughput(10 * time.Second)
+	start := time.Unix(1700000000, 0)
+	tp.started = start
+
+	for i := 0; i < 20; i++ {
+		tp.Add(start.Add(time.Duration(i%5) * time.Second))
+	}
+	// 20 syncs within the first 5 seconds
+	if rate := tp.Rate(start.Add(4 * time.Second)); rate != 4 {
+		t.Fatalf("expected 4/s shortly after start, got %v", rate)
+	}
+	// averaged over the full window once it has passed
+	if rate := tp.Rate(start.Add(9 * time.Second)); rate != 2 {
+		t.Fatalf("expected 2/s over the window, got %v", rate)
+	}
+	// syncs older than the window no longer count
+	if rate := tp.Rate(start.Add(20 * time.Second)); rate != 0 {
+		t.Fatalf("expected 0/s after the window, got %v", rate)
+	}
+}
+
+func TestBacklogEstimate(t *testing.T) {
+	q, err := NewInMemoryQueue("testbacklog", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+
+	wp := NewWorkerPool(q, 1, testLogger())
+	e := NewBacklogEstimator(q, wp, metrics.New(prometheus.NewRegistry()), testLogger())
+	ctx := context.Background()
+
+	estimate, err := e.Estimate(ctx)
+	if err != nil {
+		t.Fatalf("estimate: %v", err)
+	}
+	if estimate.Queued != 0 || !estimate.Drainable || estimate.ETASeconds != 0 {
+		t.Fatalf("expected empty queue to be drained, got %+v", estimate)
+	}
+
+	for _, username := range []string{"user-a", "user-b", "user-c", "user-d"} {
+		if err := q.Enqueue(ctx, username, 1.0); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	estimate, _ = e.Estimate(ctx)
+	if estimate.Queued != 4 || estimate.Drainable {
+		t.Fatalf("expected backlog without throughput not to be drainable, got %+v", estimate)
+	}
+
+	wp.Throughput().Add(time.Now())
+	estimate, _ = e.Estimate(ctx)
+	if !estimate.Drainable || estimate.ETASeconds <= 0 || !estimate.EstimatedDrainAt.After(estimate.GeneratedAt) {
+		t.Fatalf("expected a positive ETA, got %+v", estimate)
+	}
+}
@@ -0,0 +1,158 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var errBatcherClosed = errors.New("enqueue batcher closed")
+
+// pendingEnqueue is a single enqueue waiting to be flushed by the batcher.
+type pendingEnqueue struct {
+	username string
+	score    float64
+	info     *EventInfo
+	done     chan error
+}
+
+// pipeEnqueueFunc queues the commands for one enqueue on a pipeline and returns
+// the command whose error decides the outcome.
+type pipeEnqueueFunc func(ctx context.Context, pipe redis.Pipeliner, username string, score float64, info *EventInfo) *redis.IntCmd
+
+// enqueueBatcher buffers enqueue operations and flushes them in a single pipeline
+// once maxItems are buffered or flushInterval has passed since the first
+// buffered item, whichever comes first. Callers block until their item has been
+// flushed so that errors are still reported per Enqueue call.
+type enqueueBatcher struct {
+	client        *redis.Client
+	maxItems      int
+	flushInterval time.Duration
+	pipeEnqueue   pipeEnqueueFunc
+	onSuccess     func(n int)
+
+	ch     chan pendingEnqueue
+	stopCh chan struct{}
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+func newEnqueueBatcher(client *redis.Client, maxItems int, flushInterval time.Duration, pipeEnqueue pipeEnqueueFunc, onSuccess func(n int)) *enqueueBatcher {
+	b := &enqueueBatcher{
+		client:        client,
+		maxItems:      maxItems,
+		flushInterval: flushInterval,
+		pipeEnqueue:   pipeEnqueue,
+		onSuccess:     onSuccess,
+		ch:            make(chan pendingEnqueue, maxItems),
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// add submits an enqueue to the batch and waits for the result of its flush.
+// ctx only bounds the submission: once submitted, the item is flushed within
+// flushInterval and its result is reported even if ctx is done meanwhile, so
+// callers never see an error for an item that was enqueued.
+func (b *enqueueBatcher) add(ctx context.Context, username string, score float64, info *EventInfo) error {
+	p := pendingEnqueue{username: username, score: score, info: info, done: make(chan error, 1)}
+	select {
+	case <-b.stopCh:
+		return errBatcherClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	case b.ch <- p:
+	}
+	select {
+	case err := <-p.done:
+		return err
+	case <-b.doneCh:
+		// batcher exited; the item was either flushed during shutdown or missed it
+		select {
+		case err := <-p.done:
+			return err
+		default:
+			return errBatcherClosed
+		}
+	}
+}
+
+func (b *enqueueBatcher) run() {
+	defer close(b.doneCh)
+
+	batch := make([]pendingEnqueue, 0, b.maxItems)
+	timer := time.NewTimer(b.flushInterval)
+	timer.Stop()
+
+	for {
+		select {
+		case p := <-b.ch:
+			if len(batch) == 0 {
+				timer.Reset(b.flushInterval)
+			}
+			batch = append(batch, p)
+			if len(batch) >= b.maxItems {
+				timer.Stop()
+				batch = b.flush(batch)
+			}
+		case <-timer.C:
+			batch = b.flush(batch)
+		case <-b.stopCh:
+			timer.Stop()
+			// drain anything that was submitted before stop
+			for {
+				select {
+				case p := <-b.ch:
+					batch = append(batch, p)
+				default:
+					b.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush executes the buffered operations in one pipeline and reports each result.
+func (b *enqueueBatcher) flush(batch []pendingEnqueue) []pendingEnqueue {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipe := b.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(batch))
+	for i, p := range batch {
+		cmds[i] = b.pipeEnqueue(ctx, pipe, p.username, p.score, p.info)
+	}
+	_ = execPipeline(ctx, pipe)
+
+	succeeded := 0
+	for i, p := range batch {
+		err := cmds[i].Err()
+		if err == nil {
+			succeeded++
+		}
+		p.done <- err
+	}
+	if b.onSuccess != nil && succeeded > 0 {
+		b.onSuccess(succeeded)
+	}
+
+	return batch[:0]
+}
+
+// stop flushes pending items and terminates the batcher.
+func (b *enqueueBatcher) stop() {
+	b.once.Do(func() {
+		close(b.stopCh)
+	})
+	<-b.doneCh
+}
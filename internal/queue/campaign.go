@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+)
+
+// CampaignOptions bounds the load of a migration campaign.
+type CampaignOptions struct {
+	Concurrency      int           // number of parallel syncs; at least 1
+	Rate             float64       // maximum syncs started per second; 0 is unlimited
+	ProgressInterval time.Duration // interval of progress log records; 0 disables them
+}
+
+// CampaignFailure is a user whose sync failed during a campaign.
+type CampaignFailure struct {
+	Username string
+	Error    string
+}
+
+// CampaignResult summarizes a migration campaign.
+type CampaignResult struct {
+	Users    int
+	Synced   int
+	Failed   []CampaignFailure
+	Skipped  int // not attempted because the campaign was cancelled
+	Duration time.Duration
+}
+
+// syncer is the part of the doveadm client used by a campaign.
+type syncer interface {
+	Sync(ctx context.Context, username, destination, state string) (*doveadm.SyncResponse, error)
+}
+
+// RunCampaign full-syncs the given users to destination, e.g. when onboarding
+// an existing server pair. Failed syncs are not retried but reported in the
+// result. Cancelling ctx stops starting new syncs; the remaining users are
+// counted as skipped.
+func RunCampaign(ctx context.Context, client syncer, destination string, users []string, opts CampaignOptions, logger *slog.Logger) *CampaignResult {
+	start := time.Now()
+	concurrency := max(opts.Concurrency, 1)
+
+	var (
+		mu       sync.Mutex
+		failures []CampaignFailure
+		synced   atomic.Int64
+		failed   atomic.Int64
+	)
+
+	if opts.ProgressInterval > 0 {
+		progressCtx, stopProgress := context.WithCancel(ctx)
+		defer stopProgress()
+		go func() {
+			ticker := time.NewTicker(opts.ProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-progressCtx.Done():
+					return
+				case <-ticker.C:
+					logCampaignProgress(logger, len(users), int(synced.Load()), int(failed.Load()), time.Since(start))
+				}
+			}
+		}()
+	}
+
+	var limiter <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for username := range jobs {
+				if _, err := client.Sync(ctx, username, destination, ""); err != nil {
+					logger.Error("full sync failed", "username", username, "error", err)
+					failed.Add(1)
+					mu.Lock()
+					failures = append(failures, CampaignFailure{Username: username, Error: err.Error()})
+					mu.Unlock()
+					continue
+				}
+				logger.Debug("full sync completed", "username", username)
+				synced.Add(1)
+			}
+		}()
+	}
+
+	dispatched := 0
+dispatch:
+	for _, username := range users {
+		if limiter != nil {
+			select {
+			case <-ctx.Done():
+				break dispatch
+			case <-limiter:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case jobs <- username:
+			dispatched++
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return &CampaignResult{
+		Users:    len(users),
+		Synced:   int(synced.Load()),
+		Failed:   failures,
+		Skipped:  len(users) - dispatched,
+		Duration: time.Since(start),
+	}
+}
+
+// logCampaignProgress logs the completed share of a campaign and the estimated
+// time remaining at the average rate so far.
+func logCampaignProgress(logger *slog.Logger, total, synced, failed int, elapsed time.Duration) {
+	done := synced + failed
+	attrs := []any{"done", done, "total", total, "synced", synced, "failed", failed, "elapsed", elapsed.Round(time.Second)}
+	if done > 0 && total > 0 {
+		attrs = append(attrs, "percent", done*100/total)
+		remaining := time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+		attrs = append(attrs, "eta", remaining.Round(time.Second))
+	}
+	logger.Info("Migration progress", attrs...)
+}
@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+	"github.com/dovewarden/dovewarden/internal/doveadm/doveadmtest"
+)
+
+func TestRunCampaign(t *testing.T) {
+	fake := doveadmtest.New("secret", nil)
+	fake.SetConfig(doveadmtest.Config{FailingUsers: []string{"user-c"}})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	users := []string{"user-a", "user-b", "user-c", "user-d"}
+	start := time.Now()
+	result := RunCampaign(context.Background(), doveadm.NewClient(srv.URL, "secret"), "imap", users, CampaignOptions{
+		Concurrency: 2,
+		Rate:        100,
+	}, testLogger())
+
+	if result.Users != 4 || result.Synced != 3 || result.Skipped != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Username != "user-c" {
+		t.Fatalf("expected user-c to fail, got %+v", result.Failed)
+	}
+	// four syncs at 100/s take at least 40ms
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("expected the rate to be bounded, took %v", elapsed)
+	}
+	if stats := fake.Stats(); stats.Syncs["user-a"] != 1 || stats.Syncs["user-d"] != 1 {
+		t.Fatalf("expected one full sync per user, got %+v", stats)
+	}
+}
+
+func TestRunCampaignCancelled(t *testing.T) {
+	srv := httptest.NewServer(doveadmtest.New("secret", nil))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := RunCampaign(ctx, doveadm.NewClient(srv.URL, "secret"), "imap", []string{"user-a", "user-b"}, CampaignOptions{Rate: 1}, testLogger())
+	if result.Skipped != 2 || result.Synced != 0 {
+		t.Fatalf("expected all users to be skipped, got %+v", result)
+	}
+}
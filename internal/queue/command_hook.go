@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCommandOutput bounds the command output included in log records.
+const maxCommandOutput = 1024
+
+// CommandHook returns a PostSyncHook running the program at path after every
+// sync with the username, destination and result ("success", "failure" or
+// "dry_run") as arguments. The same values and further details are passed in
+// DOVEWARDEN_* environment variables. The program runs in the worker that
+// synced the user and is killed after timeout; its failures are logged but do
+// not fail the sync.
+func CommandHook(path string, timeout time.Duration, logger *slog.Logger) PostSyncHook {
+	return func(ctx context.Context, attempt SyncInfo, duration time.Duration, syncErr error) {
+		logger := jobLogger(ctx, logger)
+		result := HistoryResultSuccess
+		errMsg := ""
+		switch {
+		case syncErr != nil:
+			result = HistoryResultFailure
+			errMsg = syncErr.Error()
+		case attempt.DryRun:
+			result = HistoryResultDryRun
+		}
+
+		// run the command even if the sync ended because the pool is stopping
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, path, attempt.Username, attempt.Destination, result)
+		cmd.Env = append(os.Environ(),
+			"DOVEWARDEN_USERNAME="+attempt.Username,
+			"DOVEWARDEN_DESTINATION="+attempt.Destination,
+			"DOVEWARDEN_RESULT="+result,
+			"DOVEWARDEN_ERROR="+errMsg,
+			"DOVEWARDEN_FULL_SYNC="+strconv.FormatBool(attempt.FullSync),
+			"DOVEWARDEN_ORIGIN="+string(attempt.Origin),
+			"DOVEWARDEN_DURATION_SECONDS="+strconv.FormatFloat(duration.Seconds(), 'f', 3, 64),
+		)
+		if attempt.Trigger != nil {
+			cmd.Env = append(cmd.Env,
+				"DOVEWARDEN_TRIGGER_EVENT="+attempt.Trigger.Event,
+				"DOVEWARDEN_CORRELATION_IDS="+strings.Join(attempt.Trigger.CorrelationIDs, ","),
+			)
+		}
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		// children of a killed script may keep the output open
+		cmd.WaitDelay = time.Second
+
+		start := time.Now()
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				err = fmt.Errorf("timed out after %v: %w", timeout, err)
+			}
+			output := out.String()
+			if len(output) > maxCommandOutput {
+				output = output[:maxCommandOutput]
+			}
+			logger.Warn("Post-sync command failed", "username", attempt.Username, "command", path, "error", err, "output", output)
+			return
+		}
+		logger.Debug("Post-sync command finished", "username", attempt.Username, "command", path, "duration", time.Since(start))
+	}
+}
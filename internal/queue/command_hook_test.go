@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCommandHook(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	body := "#!/bin/sh\necho \"$1 $2 $3 $DOVEWARDEN_FULL_SYNC $DOVEWARDEN_TRIGGER_EVENT $DOVEWARDEN_ERROR\" >> " + out + "\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+
+	hook := CommandHook(script, time.Second, testLogger())
+	trigger := &EventInfo{Event: "imap_command_finished"}
+	hook(context.Background(), SyncInfo{Username: "user-a", Destination: "imap", FullSync: true, Trigger: trigger}, time.Second, nil)
+
+	// a canceled context still runs the command
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hook(ctx, SyncInfo{Username: "user-b", Destination: "imap"}, time.Second, errors.New("dsync failed"))
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	want := "user-a imap success true imap_command_finished \nuser-b imap failure false  dsync failed\n"
+	if string(data) != want {
+		t.Fatalf("expected output %q, got %q", want, data)
+	}
+}
+
+func TestCommandHookTimeout(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nsleep 5\n"), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+
+	start := time.Now()
+	CommandHook(script, 50*time.Millisecond, testLogger())(context.Background(), SyncInfo{Username: "user-a"}, 0, nil)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected command to be killed after the timeout, took %v", elapsed)
+	}
+}
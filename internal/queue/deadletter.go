@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+)
+
+// DeadLetterRedriver periodically queues dead-lettered users again, i.e. users
+// whose last sync failed and for which no retry is pending, so that users
+// given up on during a long outage are eventually synced once it is over.
+type DeadLetterRedriver struct {
+	queue    Queue
+	metrics  *metrics.Metrics
+	logger   *slog.Logger
+	interval time.Duration
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewDeadLetterRedriver creates a re-drive of the dead-lettered users every interval.
+func NewDeadLetterRedriver(queue Queue, m *metrics.Metrics, logger *slog.Logger, interval time.Duration) *DeadLetterRedriver {
+	return &DeadLetterRedriver{
+		queue:    queue,
+		metrics:  m,
+		logger:   logger,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// Start begins the periodic re-drive.
+func (r *DeadLetterRedriver) Start(ctx context.Context) {
+	r.logger.Info("Starting dead-letter re-drive", "interval", r.interval)
+
+	go func() {
+		defer close(r.doneCh)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stopCh:
+				r.logger.Info("Dead-letter re-drive stopping")
+				return
+			case <-ticker.C:
+				requeued, err := r.queue.RequeueFailed(ctx, nil, OriginRetry)
+				if err != nil {
+					r.logger.Error("Dead-letter re-drive failed", "error", err)
+					continue
+				}
+				if len(requeued) > 0 {
+					r.metrics.DeadLettersRequeued.WithLabelValues("redrive").Add(float64(len(requeued)))
+					r.logger.Info("Requeued dead-lettered users", "count", len(requeued))
+				}
+			}
+		}
+	}()
+}
+
+// Stop gracefully stops the re-drive.
+func (r *DeadLetterRedriver) Stop(ctx context.Context) error {
+	close(r.stopCh)
+
+	select {
+	case <-r.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testRequeueFailed(t *testing.T, q Queue) {
+	ctx := context.Background()
+	for _, user := range []string{"dead-1", "dead-2", "queued", "deferred", "syncing"} {
+		if err := q.RecordFailure(ctx, user); err != nil {
+			t.Fatalf("record failure: %v", err)
+		}
+	}
+	if err := q.Enqueue(ctx, "queued", 1); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.Defer(ctx, "deferred", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("defer: %v", err)
+	}
+	if err := q.Enqueue(ctx, "syncing", 1); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	for {
+		user, err := q.Dequeue(ctx)
+		if err != nil {
+			t.Fatalf("dequeue: %v", err)
+		}
+		if user.Username == "syncing" {
+			break
+		}
+		if user.Username == "" {
+			t.Fatal("expected syncing to be dequeued")
+		}
+		if err := q.Enqueue(ctx, user.Username, 1); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+
+	requeued, err := q.RequeueFailed(ctx, []string{"dead-1", "queued", "unknown"}, OriginAdmin)
+	if err != nil {
+		t.Fatalf("requeue: %v", err)
+	}
+	if !slices.Equal(requeued, []string{"dead-1"}) {
+		t.Fatalf("expected only dead-1 to be requeued, got %v", requeued)
+	}
+
+	requeued, err = q.RequeueFailed(ctx, nil, OriginRetry)
+	if err != nil {
+		t.Fatalf("requeue: %v", err)
+	}
+	if !slices.Equal(requeued, []string{"dead-2"}) {
+		t.Fatalf("expected only dead-2 to be requeued, got %v", requeued)
+	}
+	for user, want := range map[string]Origin{"dead-1": OriginAdmin, "dead-2": OriginRetry} {
+		if info, err := q.TakeEventInfo(ctx, user); err != nil || info == nil || info.Origin != want {
+			t.Fatalf("expected %s to be queued with origin %s, got %+v, %v", user, want, info, err)
+		}
+	}
+
+	if n, err := q.Len(ctx); err != nil || n != 3 {
+		t.Fatalf("expected dead-1, dead-2 and queued to be queued, got %d (err %v)", n, err)
+	}
+	if n, err := q.FailedCount(ctx); err != nil || n != 5 {
+		t.Fatalf("expected failure marks to be kept, got %d (err %v)", n, err)
+	}
+}
+
+func TestRequeueFailed(t *testing.T) {
+	t.Run("inmemory", func(t *testing.T) {
+		q, err := NewInMemoryQueue("testdeadletter", "", testLogger())
+		if err != nil {
+			t.Fatalf("failed to create queue: %v", err)
+		}
+		defer func() { _ = q.Close() }()
+		testRequeueFailed(t, q)
+	})
+	t.Run("native", func(t *testing.T) {
+		q := NewNativeQueue(testLogger())
+		defer func() { _ = q.Close() }()
+		testRequeueFailed(t, q)
+	})
+}
+
+func TestDeadLetterRedriver(t *testing.T) {
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	m := metrics.New(prometheus.NewRegistry())
+
+	ctx := context.Background()
+	if err := q.RecordFailure(ctx, "alice"); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+
+	r := NewDeadLetterRedriver(q, m, testLogger(), 10*time.Millisecond)
+	r.Start(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(m.DeadLettersRequeued.WithLabelValues("redrive")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected alice to be requeued")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := r.Stop(ctx); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	if user, err := q.Dequeue(ctx); err != nil || user.Username != "alice" {
+		t.Fatalf("expected alice to be queued, got %q (err %v)", user.Username, err)
+	}
+	if got := testutil.ToFloat64(m.DeadLettersRequeued.WithLabelValues("redrive")); got != 1 {
+		t.Fatalf("expected alice to be requeued once while queued, got %v", got)
+	}
+}
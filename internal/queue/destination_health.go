@@ -0,0 +1,219 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+	"github.com/dovewarden/dovewarden/internal/metrics"
+)
+
+// DestinationProbe checks whether a sync destination is healthy.
+type DestinationProbe func(ctx context.Context) error
+
+// PingProbe returns a DestinationProbe that pings the Doveadm API. It detects
+// an unreachable doveadm server, but not an unreachable remote destination.
+func PingProbe(client *doveadm.Client) DestinationProbe {
+	return client.Ping
+}
+
+// CanarySyncProbe returns a DestinationProbe that syncs the user, ideally a
+// small dedicated account, to the destination. After the first sync only
+// changes are synced, so the probe stays cheap while exercising the whole
+// replication path.
+func CanarySyncProbe(client *doveadm.Client, username, destination string) DestinationProbe {
+	var state string
+	return func(ctx context.Context) error {
+		resp, err := client.Sync(ctx, username, destination, state)
+		if err != nil {
+			state = ""
+			return err
+		}
+		state = resp.State
+		return nil
+	}
+}
+
+// Failover redirects syncs to a fallback destination while the primary one is
+// down. It is implemented by DoveadmEventHandler.
+type Failover interface {
+	// FailOver redirects the following syncs to the fallback destination.
+	FailOver()
+	// FailBack syncs to the primary destination again and reconciles it.
+	FailBack(ctx context.Context)
+}
+
+// DestinationMonitor periodically probes a sync destination. After
+// failureThreshold consecutive failed probes it holds the worker pool, so that
+// queued users wait instead of failing and being retried, and resumes it with
+// the first successful probe. With a failover, syncs held for longer than the
+// failover delay are redirected to the fallback destination instead.
+type DestinationMonitor struct {
+	destination      string
+	probe            DestinationProbe
+	pool             *WorkerPool
+	queue            Queue
+	metrics          *metrics.Metrics
+	logger           *slog.Logger
+	interval         time.Duration
+	failureThreshold int
+
+	failover      Failover
+	failoverAfter time.Duration
+
+	consecutiveFailures int
+	heldSince           time.Time
+	failedOver          bool
+	health              atomic.Pointer[DestinationHealth]
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// DestinationHealth is the state of a monitored destination after its last
+// probe.
+type DestinationHealth struct {
+	Destination         string    `json:"destination"`
+	Up                  bool      `json:"up"` // the last probe succeeded
+	Held                bool      `json:"held"`
+	FailedOver          bool      `json:"failed_over"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	HeldSince           time.Time `json:"held_since,omitzero"`
+	LastProbeAt         time.Time `json:"last_probe_at,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+// NewDestinationMonitor creates a health monitor for the destination the pool
+// syncs to.
+func NewDestinationMonitor(destination string, probe DestinationProbe, pool *WorkerPool, q Queue, m *metrics.Metrics, logger *slog.Logger, interval time.Duration, failureThreshold int) *DestinationMonitor {
+	return &DestinationMonitor{
+		destination:      destination,
+		probe:            probe,
+		pool:             pool,
+		queue:            q,
+		metrics:          m,
+		logger:           logger.With("destination", destination),
+		interval:         interval,
+		failureThreshold: max(failureThreshold, 1),
+		stopCh:           make(chan struct{}),
+		doneCh:           make(chan struct{}),
+	}
+}
+
+// SetFailover fails over to f once syncs were held for after, and fails back
+// with the first successful probe. Must be called before Start.
+func (d *DestinationMonitor) SetFailover(f Failover, after time.Duration) {
+	d.failover = f
+	d.failoverAfter = after
+}
+
+// Start probes once immediately and then every interval until Stop is called.
+func (d *DestinationMonitor) Start(ctx context.Context) {
+	d.logger.Info("Starting destination health monitor", "interval", d.interval, "failure_threshold", d.failureThreshold)
+	d.metrics.DestinationUp.WithLabelValues(d.destination).Set(1)
+
+	go func() {
+		defer close(d.doneCh)
+
+		d.check(ctx)
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.stopCh:
+				d.logger.Info("Destination health monitor stopping")
+				return
+			case <-ticker.C:
+				d.check(ctx)
+			}
+		}
+	}()
+}
+
+// Stop terminates the monitor. A held pool stays held.
+func (d *DestinationMonitor) Stop(ctx context.Context) error {
+	close(d.stopCh)
+
+	select {
+	case <-d.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Health returns the state after the last probe, nil before the first one.
+func (d *DestinationMonitor) Health() *DestinationHealth {
+	return d.health.Load()
+}
+
+func (d *DestinationMonitor) check(ctx context.Context) {
+	probeCtx, cancel := context.WithTimeout(ctx, d.interval)
+	err := d.probe(probeCtx)
+	cancel()
+	defer d.recordHealth(err)
+
+	if err == nil {
+		d.consecutiveFailures = 0
+		if d.failedOver {
+			d.logger.Info("Destination recovered, failing back", "down_for", time.Since(d.heldSince))
+			d.failover.FailBack(ctx)
+			d.failedOver = false
+			d.metrics.DestinationUp.WithLabelValues(d.destination).Set(1)
+		}
+		if d.pool.Held() {
+			queued, lenErr := d.queue.Len(ctx)
+			if lenErr != nil {
+				d.logger.Warn("Failed to read queue length", "error", lenErr)
+			}
+			d.logger.Info("Destination recovered, resuming syncs", "held_for", time.Since(d.heldSince), "queued_users", queued)
+			d.pool.Resume()
+			d.metrics.DestinationUp.WithLabelValues(d.destination).Set(1)
+		}
+		return
+	}
+
+	d.consecutiveFailures++
+	d.metrics.DestinationProbeFailures.WithLabelValues(d.destination).Inc()
+	d.logger.Warn("Destination health probe failed", "consecutive_failures", d.consecutiveFailures, "error", err)
+	if d.failedOver {
+		return
+	}
+	if d.pool.Held() && d.failover != nil && time.Since(d.heldSince) >= d.failoverAfter {
+		d.logger.Warn("Destination down for too long, failing over", "held_for", time.Since(d.heldSince))
+		d.failover.FailOver()
+		d.failedOver = true
+		d.pool.Resume()
+		return
+	}
+	if !d.pool.Held() && d.consecutiveFailures >= d.failureThreshold {
+		d.logger.Error("Destination down, holding syncs", "consecutive_failures", d.consecutiveFailures)
+		d.heldSince = time.Now()
+		d.pool.Hold()
+		d.metrics.DestinationUp.WithLabelValues(d.destination).Set(0)
+		d.metrics.DestinationHolds.WithLabelValues(d.destination).Inc()
+	}
+}
+
+// recordHealth publishes the state after a probe for Health.
+func (d *DestinationMonitor) recordHealth(err error) {
+	health := &DestinationHealth{
+		Destination:         d.destination,
+		Up:                  err == nil,
+		Held:                d.pool.Held(),
+		FailedOver:          d.failedOver,
+		ConsecutiveFailures: d.consecutiveFailures,
+		LastProbeAt:         time.Now(),
+	}
+	if health.Held || health.FailedOver {
+		health.HeldSince = d.heldSince
+	}
+	if err != nil {
+		health.LastError = err.Error()
+	}
+	d.health.Store(health)
+}
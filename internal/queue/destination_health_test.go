@@ -0,0 +1,169 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDestinationMonitorHoldsSyncs(t *testing.T) {
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	ctx := context.Background()
+
+	var handled atomic.Int32
+	wp := NewWorkerPool(q, 1, testLogger())
+	wp.SetMaxFetchBackoff(20 * time.Millisecond)
+	wp.SetHandler(EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+		handled.Add(1)
+		return nil
+	}))
+
+	var down atomic.Bool
+	down.Store(true)
+	probe := func(ctx context.Context) error {
+		if down.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	m := metrics.New(prometheus.NewRegistry())
+	d := NewDestinationMonitor("imap", probe, wp, q, m, testLogger(), time.Hour, 2)
+
+	if d.Health() != nil {
+		t.Fatal("expected no health before the first probe")
+	}
+	// the first failure is tolerated
+	d.check(ctx)
+	if wp.Held() {
+		t.Fatal("expected a single failed probe not to hold syncs")
+	}
+	d.check(ctx)
+	if !wp.Held() {
+		t.Fatal("expected syncs to be held after 2 failed probes")
+	}
+	if h := d.Health(); h.Up || !h.Held || h.ConsecutiveFailures != 2 || h.HeldSince.IsZero() || h.LastError != "connection refused" {
+		t.Fatalf("unexpected health %+v", h)
+	}
+	if got := testutil.ToFloat64(m.DestinationUp.WithLabelValues("imap")); got != 0 {
+		t.Fatalf("expected destination to be reported down, got %v", got)
+	}
+
+	wp.Start(ctx)
+	defer func() { _ = wp.Stop(ctx) }()
+	for _, user := range []string{"user-a", "user-b"} {
+		if err := q.Enqueue(ctx, user, 1.0); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := handled.Load(); got != 0 {
+		t.Fatalf("expected no syncs while held, got %d", got)
+	}
+	if n, _ := q.Len(ctx); n != 2 {
+		t.Fatalf("expected users to stay queued, got %d", n)
+	}
+
+	down.Store(false)
+	d.check(ctx)
+	if wp.Held() {
+		t.Fatal("expected syncs to resume after a successful probe")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for handled.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := handled.Load(); got != 2 {
+		t.Fatalf("expected queued users to be synced after resuming, got %d", got)
+	}
+	if got := testutil.ToFloat64(m.DestinationHolds.WithLabelValues("imap")); got != 1 {
+		t.Fatalf("expected 1 hold, got %v", got)
+	}
+}
+
+func TestDoveadmHandlerFailover(t *testing.T) {
+	srv := newFakeDoveadm(t)
+	defer srv.Close()
+
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	ctx := context.Background()
+
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "testpass", "imap", testLogger(), q, m)
+	h.SetHistorySize(5)
+	h.SetFallbackDestination("imap-fallback")
+	if err := q.SetReplicationState(ctx, "user-a", "primary-state"); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+
+	h.FailOver()
+	// the fallback has no state yet, so the fake doveadm runs a full sync
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err != nil {
+		t.Fatalf("expected redirected sync to succeed, got %v", err)
+	}
+	if state, _ := q.GetReplicationState(ctx, "user-a"); state != "primary-state" {
+		t.Fatalf("expected the state of the primary to be kept, got %q", state)
+	}
+	if state, _ := q.GetReplicationState(ctx, fallbackStateKey("imap-fallback", "user-a")); state != "full-state" {
+		t.Fatalf("expected the state of the fallback to be stored apart, got %q", state)
+	}
+	if history, _ := q.GetHistory(ctx, "user-a"); len(history) != 1 || history[0].Destination != "imap-fallback" {
+		t.Fatalf("expected redirected sync in the history, got %+v", history)
+	}
+	if last, _ := q.GetLastReplicationTime(ctx, "user-a"); !last.IsZero() {
+		t.Fatalf("expected no replication of the primary to be recorded, got %v", last)
+	}
+	if last, _ := q.GetLastReplicationTime(ctx, fallbackStateKey("imap-fallback", "user-a")); last.IsZero() {
+		t.Fatal("expected the replication of the fallback to be recorded")
+	}
+	if users, _ := q.ListRedirected(ctx); !slices.Equal(users, []string{"user-a"}) {
+		t.Fatalf("expected user-a to be marked as redirected, got %v", users)
+	}
+
+	h.FailBack(ctx)
+	if users := nextUsers(t, q); len(users) != 1 || users[0].Username != "user-a" {
+		t.Fatalf("expected user-a to be queued for reconciliation, got %v", users)
+	}
+	if users, _ := q.ListRedirected(ctx); len(users) != 0 {
+		t.Fatalf("expected the mark to be cleared, got %v", users)
+	}
+	if got := testutil.ToFloat64(m.FailoverReconciliations); got != 1 {
+		t.Fatalf("expected 1 reconciliation, got %v", got)
+	}
+	// back on the primary, the incremental sync fails at the fake doveadm
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err == nil {
+		t.Fatal("expected sync to the primary with its state")
+	}
+}
+
+func TestDoveadmHandlerReconcilesRedirectedAfterRestart(t *testing.T) {
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	ctx := context.Background()
+
+	// marked by a process that stopped during a failover
+	if err := q.MarkRedirected(ctx, "user-a"); err != nil {
+		t.Fatalf("mark redirected: %v", err)
+	}
+
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler("http://127.0.0.1:1", "testpass", "imap", testLogger(), q, m)
+	h.ReconcileRedirected(ctx)
+	if users := nextUsers(t, q); len(users) != 1 || users[0].Username != "user-a" {
+		t.Fatalf("expected user-a to be queued for reconciliation, got %v", users)
+	}
+	if users, _ := q.ListRedirected(ctx); len(users) != 0 {
+		t.Fatalf("expected the mark to be cleared, got %v", users)
+	}
+	if got := testutil.ToFloat64(m.FailoverReconciliations); got != 1 {
+		t.Fatalf("expected 1 reconciliation, got %v", got)
+	}
+}
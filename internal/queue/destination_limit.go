@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseDestinationLimits parses a comma-separated list of destination=limit
+// pairs, e.g. "imap=8,tcp:replica.example.com=4".
+func ParseDestinationLimits(spec string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		destination, limitStr, ok := strings.Cut(entry, "=")
+		destination = strings.TrimSpace(destination)
+		if !ok || destination == "" {
+			return nil, fmt.Errorf("invalid destination limit %q: expected destination=limit", entry)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid destination limit %q: limit must be a positive integer", entry)
+		}
+		limits[destination] = limit
+	}
+	return limits, nil
+}
+
+// SetDestinationLimits caps the number of concurrent syncs per destination,
+// across all workers using the handler. Syncs beyond the cap wait in their
+// worker for a slot. Destinations without a limit are not capped. Must be
+// called before the handler is used concurrently.
+func (h *DoveadmEventHandler) SetDestinationLimits(limits map[string]int) {
+	h.destinationSlots = make(map[string]chan struct{}, len(limits))
+	for destination, limit := range limits {
+		h.destinationSlots[destination] = make(chan struct{}, limit)
+	}
+}
+
+// acquireDestination takes a sync slot of the destination, waiting until one
+// is free or ctx ends. The returned function releases the slot.
+func (h *DoveadmEventHandler) acquireDestination(ctx context.Context, destination string) (func(), error) {
+	slots, ok := h.destinationSlots[destination]
+	if !ok {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+	default:
+		h.logger.Debug("Waiting for a sync slot of the destination", "destination", destination, "limit", cap(slots))
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for a sync slot of destination %s: %w", destination, ctx.Err())
+		}
+	}
+	active := h.metrics.DestinationActiveSyncs.WithLabelValues(h.metrics.LimitLabel("destination", destination))
+	active.Inc()
+	return func() {
+		active.Dec()
+		<-slots
+	}, nil
+}
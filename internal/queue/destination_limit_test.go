@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseDestinationLimits(t *testing.T) {
+	limits, err := ParseDestinationLimits(" imap=8, tcp:replica.example.com:12345=2,")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(limits) != 2 || limits["imap"] != 8 || limits["tcp:replica.example.com:12345"] != 2 {
+		t.Fatalf("unexpected limits %v", limits)
+	}
+	for _, spec := range []string{"imap", "=4", "imap=0", "imap=x"} {
+		if _, err := ParseDestinationLimits(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestDoveadmHandlerDestinationLimit(t *testing.T) {
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler("http://localhost", "testpass", "imap", testLogger(), nil, m)
+	h.SetDestinationLimits(map[string]int{"imap": 1})
+
+	release, err := h.acquireDestination(context.Background(), "imap")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if got := testutil.ToFloat64(m.DestinationActiveSyncs.WithLabelValues("imap")); got != 1 {
+		t.Fatalf("expected 1 active sync, got %v", got)
+	}
+
+	// the second sync waits for the slot
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := h.acquireDestination(ctx, "imap"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the second sync to wait for the slot, got %v", err)
+	}
+	// other destinations are not capped
+	if _, err := h.acquireDestination(ctx, "imap-fallback"); err != nil {
+		t.Fatalf("expected uncapped destination to sync, got %v", err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		if release, err := h.acquireDestination(context.Background(), "imap"); err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the waiting sync to take the released slot")
+	}
+}
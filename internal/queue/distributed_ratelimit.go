@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// takeTokenScript takes a token from the bucket stored in the hash KEYS[1],
+// refilled at ARGV[1] tokens per second up to ARGV[2] tokens. It returns 0 if
+// a token was taken, otherwise the milliseconds until one is available. The
+// time of the Redis server is used, so that the clocks of the replicas do not
+// matter.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(now - ts, 0) / 1000 * rate)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return wait
+`)
+
+// DistributedTokenBucket is a token bucket kept in Redis, so that all
+// replicas using the same Redis server and key share one rate. While Redis is
+// unreachable, tokens are taken from a local fallback bucket instead.
+type DistributedTokenBucket struct {
+	client   redis.UniversalClient
+	key      string
+	rate     float64
+	burst    int
+	fallback *tokenBucket
+	metrics  *metrics.Metrics
+	logger   *slog.Logger
+}
+
+// NewDistributedTokenBucket creates a bucket at key allowing perSecond tokens
+// per second across all replicas, with bursts of up to burst tokens. While
+// Redis fails, each replica takes at most fallbackPerSecond tokens per second.
+// m may be nil.
+func NewDistributedTokenBucket(client redis.UniversalClient, key string, perSecond float64, burst int, fallbackPerSecond float64, m *metrics.Metrics, logger *slog.Logger) *DistributedTokenBucket {
+	return &DistributedTokenBucket{
+		client:   client,
+		key:      key,
+		rate:     perSecond,
+		burst:    max(burst, 1),
+		fallback: newTokenBucket(fallbackPerSecond, burst),
+		metrics:  m,
+		logger:   logger,
+	}
+}
+
+// Wait takes a token, waiting until one is available or ctx ends. Replicas
+// waiting at the same time are not served in order.
+func (b *DistributedTokenBucket) Wait(ctx context.Context) error {
+	if b.rate <= 0 {
+		return nil
+	}
+	start := time.Now()
+	defer func() {
+		if b.metrics != nil {
+			b.metrics.RateLimitWait.Add(time.Since(start).Seconds())
+		}
+	}()
+	for {
+		wait, err := takeTokenScript.Run(ctx, b.client, []string{b.key},
+			strconv.FormatFloat(b.rate, 'f', -1, 64), b.burst).Int64()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if b.metrics != nil {
+				b.metrics.RateLimitErrors.Inc()
+			}
+			b.logger.Warn("Failed to take a token from the distributed rate limiter, using the local rate limit", "error", err)
+			return b.fallback.wait(ctx)
+		}
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(time.Duration(wait) * time.Millisecond)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// DistributedRateLimitMiddleware starts a job only once it took a token from
+// the distributed bucket, bounding the total load of all replicas on doveadm.
+// Jobs fail with the context's error if it ends while waiting.
+func DistributedRateLimitMiddleware(bucket *DistributedTokenBucket) Middleware {
+	return func(next EventHandler) EventHandler {
+		return EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+			if err := bucket.Wait(ctx); err != nil {
+				return err
+			}
+			return next.Handle(ctx, item)
+		})
+	}
+}
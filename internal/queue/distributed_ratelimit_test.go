@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestDistributedTokenBucket(t *testing.T) {
+	mr := miniredis.RunT(t)
+	m := metrics.New(prometheus.NewRegistry())
+
+	// two replicas sharing one Redis and key share the rate
+	var buckets []*DistributedTokenBucket
+	for range 2 {
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer func() { _ = client.Close() }()
+		buckets = append(buckets, NewDistributedTokenBucket(client, "test:doveadm_rate_limit", 50, 2, 50, m, testLogger()))
+	}
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := range 4 {
+		if err := buckets[i%2].Wait(ctx); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	// the shared burst passes at once, the 2 further tokens take 20ms each
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Fatalf("expected tokens beyond the shared burst to be delayed, took %v", elapsed)
+	}
+	if got := testutil.ToFloat64(m.RateLimitWait); got <= 0 {
+		t.Fatalf("expected the wait to be counted, got %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := buckets[0].Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected wait to fail with canceled context, got %v", err)
+	}
+}
+
+func TestDistributedTokenBucketFallback(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer func() { _ = client.Close() }()
+	m := metrics.New(prometheus.NewRegistry())
+	bucket := NewDistributedTokenBucket(client, "test:doveadm_rate_limit", 1, 1, 1000, m, testLogger())
+	mr.Close()
+
+	h := Chain(EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+		return nil
+	}), DistributedRateLimitMiddleware(bucket))
+	for range 3 {
+		if err := h.Handle(context.Background(), QueueItem{Username: "user-a"}); err != nil {
+			t.Fatalf("expected jobs to pass the local fallback, got %v", err)
+		}
+	}
+	if got := testutil.ToFloat64(m.RateLimitErrors); got != 3 {
+		t.Fatalf("expected 3 rate limiter errors, got %v", got)
+	}
+}
@@ -2,63 +2,861 @@ package queue
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dovewarden/dovewarden/internal/doveadm"
+	"github.com/dovewarden/dovewarden/internal/metrics"
 )
 
+// ErrSkipSync is returned by a PreSyncHook to skip a sync without failing it.
+var ErrSkipSync = errors.New("sync skipped by hook")
+
+// SyncInfo describes a sync attempt to the sync hooks.
+type SyncInfo struct {
+	Username    string
+	Destination string
+	FullSync    bool       // no replication state stored
+	Initial     bool       // first sync of a user that was never replicated
+	DryRun      bool       // doveadm is not called
+	Trigger     *EventInfo // nil if not triggered by an event, e.g. background replication
+	Origin      Origin     // why the user was queued
+}
+
+// PreSyncHook runs before a user is synced. Returning ErrSkipSync skips the
+// sync as if it succeeded; any other error fails it, so the user is requeued.
+type PreSyncHook func(ctx context.Context, attempt SyncInfo) error
+
+// PostSyncHook runs after every sync attempt that was not skipped, with its
+// duration and error, nil on success.
+type PostSyncHook func(ctx context.Context, attempt SyncInfo, duration time.Duration, err error)
+
 // DoveadmEventHandler handles events by sending dsync requests to Doveadm
 type DoveadmEventHandler struct {
 	client      *doveadm.Client
 	destination string
 	logger      *slog.Logger
 	queue       Queue
+	metrics     *metrics.Metrics
+
+	// stateResetThreshold is the number of consecutive failed incremental syncs
+	// after which the stored state is dropped; 0 disables automatic resets.
+	stateResetThreshold int64
+
+	// historySize is the number of sync attempts kept per user; 0 disables the history.
+	historySize int
+
+	// dryRun skips the doveadm call and only logs what would have been synced.
+	dryRun bool
+
+	// mailboxConcurrency is the number of changed mailboxes of a user synced in
+	// parallel instead of an incremental account sync; 0 disables per-mailbox syncs.
+	mailboxConcurrency int
+
+	preSyncHooks  []PreSyncHook
+	postSyncHooks []PostSyncHook
+
+	// purgeDeletedUsers deletes the data of users doveadm reports as unknown
+	purgeDeletedUsers bool
+
+	// mailboxFilter restricts event-triggered syncs to some mailboxes; nil syncs all
+	mailboxFilter *MailboxFilter
+	// syncAllInBackground lifts the mailbox filter for syncs without a trigger
+	syncAllInBackground bool
+
+	// fallback is the destination of syncs while failed over; empty disables failover
+	fallback string
+	// failoverMu guards failedOver, so that no redirected sync is missed by the
+	// reconciliation when failing back
+	failoverMu sync.Mutex
+	failedOver bool
+
+	// routes selects the doveadm endpoint of each user in multi-cluster
+	// installations; nil syncs all users via client
+	routes *doveadm.Router
+
+	// backends resolves the doveadm endpoint of each user in proxied clusters;
+	// nil syncs all users via client
+	backends *doveadm.BackendResolver
+
+	// destinationSlots bound the concurrent syncs per destination; destinations
+	// without an entry are not capped
+	destinationSlots map[string]chan struct{}
+
+	// classifier maps failures to error classes; nil applies the built-in
+	// classification only
+	classifier *doveadm.Classifier
+
+	// coolDown pauses all syncs after doveadm shed load; 0 only delays the
+	// failed user
+	coolDown time.Duration
+	// maxCoolDown caps delays requested by Retry-After headers
+	maxCoolDown time.Duration
+	// tempFailThreshold is the number of temporary failures within
+	// tempFailWindow that cool down all syncs; 0 disables
+	tempFailThreshold int
+	tempFailMu        sync.Mutex
+	tempFails         []time.Time
+
+	// sessionCheck defers syncs of users with active sessions; nil disables
+	sessionCheck     *SessionCheck
+	sessionDeferrals *sessionDeferrals
+
+	// largeAccounts bounds the concurrent syncs of large accounts; nil disables
+	largeAccounts *largeAccounts
+
+	// syncCache skips syncs of users synced recently without events since; nil disables
+	syncCache *syncCache
 }
 
 // NewDoveadmEventHandler creates a new handler for Doveadm sync operations
-func NewDoveadmEventHandler(baseURL, password, destination string, logger *slog.Logger, queue Queue) *DoveadmEventHandler {
+func NewDoveadmEventHandler(baseURL, password, destination string, logger *slog.Logger, queue Queue, m *metrics.Metrics) *DoveadmEventHandler {
 	return &DoveadmEventHandler{
 		client:      doveadm.NewClient(baseURL, password),
 		destination: destination,
 		logger:      logger,
 		queue:       queue,
+		metrics:     m,
+	}
+}
+
+// SetPassword replaces the doveadm API password, e.g. after it was rotated.
+func (h *DoveadmEventHandler) SetPassword(password string) {
+	h.client.SetPassword(password)
+}
+
+// SetHTTPClient replaces the HTTP client of the doveadm API requests, e.g. to
+// share a tuned transport. Must be called before SetRoutes and before the
+// handler is used concurrently.
+func (h *DoveadmEventHandler) SetHTTPClient(client *http.Client) {
+	h.client.SetHTTPClient(client)
+}
+
+// SetErrorClassifier sets the classifier deciding how failed syncs are
+// retried and how they are counted. Must be called before the handler is used
+// concurrently.
+func (h *DoveadmEventHandler) SetErrorClassifier(c *doveadm.Classifier) {
+	h.classifier = c
+}
+
+// SetCoolDown pauses all syncs for coolDown after doveadm reported overload,
+// or for the delay of its Retry-After header, capped at maxCoolDown. Once
+// tempFailThreshold syncs failed temporarily within 10 seconds, all syncs
+// are paused as well; 0 disables this. A coolDown of 0 disables both and
+// only delays the failed users. Must be called before the handler is used
+// concurrently.
+func (h *DoveadmEventHandler) SetCoolDown(coolDown, maxCoolDown time.Duration, tempFailThreshold int) {
+	h.coolDown = coolDown
+	h.maxCoolDown = maxCoolDown
+	h.tempFailThreshold = tempFailThreshold
+}
+
+// SetStateResetThreshold configures after how many consecutive failed incremental
+// syncs the stored state of a user is dropped so the next attempt is a full sync.
+// A value of 0 disables automatic state resets.
+func (h *DoveadmEventHandler) SetStateResetThreshold(n int) {
+	h.stateResetThreshold = int64(n)
+}
+
+// SetHistorySize configures how many sync attempts are kept in the history of
+// each user. A value of 0 disables the history.
+func (h *DoveadmEventHandler) SetHistorySize(n int) {
+	h.historySize = n
+}
+
+// SetDryRun makes the handler log and count the syncs it would perform without
+// calling doveadm. Replication states and timestamps are left untouched.
+func (h *DoveadmEventHandler) SetDryRun(dryRun bool) {
+	h.dryRun = dryRun
+}
+
+// SetMailboxConcurrency enables syncing the mailboxes changed by the events
+// that triggered an incremental sync in parallel, up to n at a time, instead
+// of the account. A value of 0 disables per-mailbox syncs.
+func (h *DoveadmEventHandler) SetMailboxConcurrency(n int) {
+	h.mailboxConcurrency = n
+}
+
+// SetSyncParams sets extra parameters merged into every dsync request, see
+// doveadm.Client.SetSyncParams.
+func (h *DoveadmEventHandler) SetSyncParams(params map[string]map[string]any) {
+	h.client.SetSyncParams(params)
+}
+
+// SetBackendResolution makes the handler sync every user on the doveadm
+// endpoint of its current backend, resolved by a passdb lookup and cached for
+// ttl, see doveadm.BackendResolver. Must be called before the handler is used
+// concurrently.
+func (h *DoveadmEventHandler) SetBackendResolution(urlTemplate string, ttl time.Duration) {
+	h.backends = doveadm.NewBackendResolver(urlTemplate, ttl)
+}
+
+// NewRouter creates a router for the routes that falls back to the handler's
+// doveadm endpoint, for SetRoutes.
+func (h *DoveadmEventHandler) NewRouter(routes []doveadm.Route) (*doveadm.Router, error) {
+	return doveadm.NewRouter(h.client, routes)
+}
+
+// SetRoutes makes the handler sync the users matched by a route via the
+// doveadm endpoint of the route, see doveadm.Router. Must be called before the
+// handler is used concurrently.
+func (h *DoveadmEventHandler) SetRoutes(routes *doveadm.Router) {
+	h.routes = routes
+}
+
+// forgetBackend drops the cached backend of a user after a failed sync, in
+// case the user moved to another backend.
+func (h *DoveadmEventHandler) forgetBackend(username string) {
+	if h.backends != nil {
+		h.backends.Forget(username)
+	}
+}
+
+// SetPurgeDeletedUsers makes the handler delete all data of a user when doveadm
+// reports that the user does not exist, e.g. after the account was deleted,
+// instead of keeping it marked as failed.
+func (h *DoveadmEventHandler) SetPurgeDeletedUsers(purge bool) {
+	h.purgeDeletedUsers = purge
+}
+
+// SetMailboxFilter restricts the mailboxes synced by the handler. With
+// syncAllInBackground, syncs without a triggering event, e.g. of the
+// background replication, sync all mailboxes. A nil filter syncs all mailboxes.
+func (h *DoveadmEventHandler) SetMailboxFilter(filter *MailboxFilter, syncAllInBackground bool) {
+	h.mailboxFilter = filter
+	h.syncAllInBackground = syncAllInBackground
+}
+
+// SetFallbackDestination sets the destination syncs are redirected to after
+// FailOver, e.g. by a DestinationMonitor while the primary destination is down.
+// Must be called before the handler is used concurrently.
+func (h *DoveadmEventHandler) SetFallbackDestination(destination string) {
+	h.fallback = destination
+}
+
+// FailOver redirects all following syncs to the fallback destination. Their
+// replication states are stored apart from those of the primary destination.
+func (h *DoveadmEventHandler) FailOver() {
+	if h.fallback == "" {
+		return
+	}
+	h.failoverMu.Lock()
+	defer h.failoverMu.Unlock()
+	if h.failedOver {
+		return
+	}
+	h.failedOver = true
+	h.logger.Warn("Failing over, redirecting syncs", "destination", h.destination, "fallback", h.fallback)
+	h.metrics.FailoverActive.WithLabelValues(h.destination).Set(1)
+}
+
+// FailBack ends a failover: following syncs go to the primary destination
+// again, and the users synced to the fallback meanwhile are queued to
+// reconcile the primary destination.
+func (h *DoveadmEventHandler) FailBack(ctx context.Context) {
+	h.failoverMu.Lock()
+	if !h.failedOver {
+		h.failoverMu.Unlock()
+		return
 	}
+	h.failedOver = false
+	h.failoverMu.Unlock()
+
+	h.metrics.FailoverActive.WithLabelValues(h.destination).Set(0)
+	h.logger.Info("Failing back", "destination", h.destination)
+	h.ReconcileRedirected(ctx)
 }
 
-// Handle sends a dsync request to Doveadm for the given username
-func (h *DoveadmEventHandler) Handle(ctx context.Context, username string) error {
+// ReconcileRedirected queues the users synced to the fallback destination to
+// reconcile the primary destination. FailBack calls it; call it at startup for
+// the users redirected before a restart, whose marks are kept by the queue.
+func (h *DoveadmEventHandler) ReconcileRedirected(ctx context.Context) {
+	redirected, err := h.queue.ListRedirected(ctx)
+	if err != nil {
+		h.logger.Error("Failed to list redirected users", "error", err)
+		return
+	}
+	if len(redirected) == 0 {
+		return
+	}
+	// cleared before queuing, so that a user redirected again meanwhile keeps its mark
+	if err := h.queue.ClearRedirected(ctx, redirected); err != nil {
+		h.logger.Error("Failed to clear redirected users", "error", err)
+		return
+	}
+	h.logger.Info("Queuing redirected users for reconciliation", "destination", h.destination, "users", len(redirected))
+	for _, username := range redirected {
+		if err := h.queue.EnqueueEvent(ctx, username, 1.0, &EventInfo{Origin: OriginBackground}); err != nil {
+			h.logger.Error("Failed to queue redirected user for reconciliation", "username", username, "error", err)
+			if err := h.queue.MarkRedirected(ctx, username); err != nil {
+				h.logger.Error("Failed to keep redirected user for reconciliation", "username", username, "error", err)
+			}
+			continue
+		}
+		h.metrics.FailoverReconciliations.Inc()
+	}
+}
+
+// route returns the destination of a sync of the user and the name its
+// replication state and time are stored under, marking users redirected to
+// the fallback destination for the reconciliation. A redirected sync that
+// could not be marked fails, so that the primary is not left behind unnoticed.
+func (h *DoveadmEventHandler) route(ctx context.Context, username string) (destination, stateKey string, err error) {
+	if h.fallback == "" {
+		return h.destination, username, nil
+	}
+	h.failoverMu.Lock()
+	defer h.failoverMu.Unlock()
+	if !h.failedOver {
+		return h.destination, username, nil
+	}
+	if err := h.queue.MarkRedirected(ctx, username); err != nil {
+		return "", "", err
+	}
+	return h.fallback, fallbackStateKey(h.fallback, username), nil
+}
+
+// fallbackKeyPrefix starts the names replication states and times of fallback
+// destinations are stored under.
+const fallbackKeyPrefix = "@fallback/"
+
+// fallbackStateKey is the name the replication state and last replication time
+// of a user are stored under for the fallback destination, keeping them apart
+// from those of the primary. The destination is escaped, so that the user
+// follows its first slash.
+func fallbackStateKey(destination, username string) string {
+	return fallbackKeyPrefix + url.PathEscape(destination) + "/" + username
+}
+
+// fallbackUser returns the user of a name returned by fallbackStateKey, and
+// false for the names of users.
+func fallbackUser(name string) (string, bool) {
+	rest, ok := strings.CutPrefix(name, fallbackKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	_, username, ok := strings.Cut(rest, "/")
+	return username, ok
+}
+
+// AddPreSyncHook registers a hook run before every sync, in the order of
+// registration, e.g. to lock a user in another system or to skip users.
+// Must be called before the handler is used concurrently.
+func (h *DoveadmEventHandler) AddPreSyncHook(hook PreSyncHook) {
+	h.preSyncHooks = append(h.preSyncHooks, hook)
+}
+
+// AddPostSyncHook registers a hook run after every sync attempt, in the order
+// of registration, e.g. to notify another system. Must be called before the
+// handler is used concurrently.
+func (h *DoveadmEventHandler) AddPostSyncHook(hook PostSyncHook) {
+	h.postSyncHooks = append(h.postSyncHooks, hook)
+}
+
+// Handle sends a dsync request to Doveadm for the user of item
+func (h *DoveadmEventHandler) Handle(ctx context.Context, item QueueItem) (err error) {
+	username, info := item.Username, item.Info
+	if info != nil {
+		// for the hooks and helpers reading the event info from the context
+		ctx = WithEventInfo(ctx, info)
+	}
+	logger := jobLogger(ctx, h.logger)
+	start := time.Now()
+	destination, stateKey, err := h.route(ctx, username)
+	if err != nil {
+		logger.Error("Failed to record redirected sync, not syncing", "username", username, "error", err)
+		return fmt.Errorf("record redirected sync: %w", err)
+	}
+	if info != nil && len(info.CorrelationIDs) > 0 {
+		ctx = doveadm.WithSyncTag(ctx, syncTag(info.CorrelationIDs))
+	}
+
 	// Retrieve the last known replication state for this user
-	state, err := h.queue.GetReplicationState(ctx, username)
+	state, err := h.queue.GetReplicationState(ctx, stateKey)
 	if err != nil {
-		h.logger.Warn("Failed to get replication state, proceeding without state", "username", username, "error", err)
+		logger.Warn("Failed to get replication state, proceeding without state", "username", username, "error", err)
 		state = ""
 	}
+	attempt := SyncInfo{
+		Username:    username,
+		Destination: destination,
+		FullSync:    state == "",
+		Initial:     state == "" && h.firstSeen(ctx, username),
+		DryRun:      h.dryRun,
+		Origin:      item.Origin(),
+	}
+	if info != nil && info.triggered() {
+		attempt.Trigger = info
+	}
+	if state != "" && attempt.Origin != OriginAdmin && h.redundantSync(ctx, stateKey, username, logger) {
+		return nil
+	}
+	skipped := false
+	defer func() {
+		if skipped {
+			return
+		}
+		if err != nil {
+			h.forgetSync(stateKey)
+		}
+		// the history of purged users is gone with the rest of their data
+		if !ResultOf(err).UserDeleted {
+			h.recordHistory(ctx, attempt, start, err)
+		}
+		if attempt.Initial && !attempt.DryRun {
+			result := HistoryResultSuccess
+			if err != nil {
+				result = HistoryResultFailure
+			}
+			h.metrics.InitialSyncs.WithLabelValues(result).Inc()
+		}
+		for _, hook := range h.postSyncHooks {
+			hook(ctx, attempt, time.Since(start), err)
+		}
+	}()
 
-	h.logger.Info("Syncing user via dsync", "username", username, "destination", h.destination, "has_state", state != "")
+	for _, hook := range h.preSyncHooks {
+		if err := hook(ctx, attempt); errors.Is(err, ErrSkipSync) {
+			logger.Info("Sync skipped by pre-sync hook", "username", username)
+			skipped = true
+			return nil
+		} else if err != nil {
+			logger.Error("Pre-sync hook failed, not syncing", "username", username, "error", err)
+			return fmt.Errorf("pre-sync hook: %w", err)
+		}
+	}
 
-	resp, err := h.client.Sync(ctx, username, h.destination, state)
+	logAttrs := []any{"username", username, "destination", destination, "has_state", state != "", "initial", attempt.Initial}
+	if info != nil {
+		logAttrs = append(logAttrs, "trigger_cmd", info.CmdName, "mailboxes", info.Mailboxes)
+	}
+	if h.dryRun {
+		syncType := "incremental"
+		if state == "" {
+			syncType = "full"
+		}
+		logger.Info("Dry run, skipping dsync", append(logAttrs, "sync_type", syncType)...)
+		h.metrics.DryRunSyncs.WithLabelValues(syncType).Inc()
+		return nil
+	}
+	client := h.client
+	if h.routes != nil {
+		client = h.routes.Client(username)
+	}
+	if h.backends != nil {
+		if client, err = h.backends.Resolve(ctx, client, username); err != nil {
+			logger.Error("Failed to resolve the backend of the user", "username", username, "error", err)
+			return h.syncFailure(ctx, username, stateKey, err)
+		}
+	}
+	if client != h.client {
+		logAttrs = append(logAttrs, "doveadm_url", client.BaseURL())
+	}
+	if err := h.checkSessions(ctx, client, username, logger); err != nil {
+		// not an attempt, so neither recorded in the history nor seen by the post-sync hooks
+		skipped = true
+		return err
+	}
+	leave, err := h.enterLargeAccountLane(ctx, client, username, logger)
 	if err != nil {
-		h.logger.Error("dsync failed", "username", username, "error", err)
+		skipped = true
 		return err
 	}
+	defer leave()
+	release, err := h.acquireDestination(ctx, destination)
+	if err != nil {
+		return err
+	}
+	defer release()
+	logger.Info("Syncing user via dsync", logAttrs...)
+
+	filter := h.mailboxFilter
+	if attempt.Trigger == nil && h.syncAllInBackground {
+		filter = nil
+	}
+
+	if state != "" && h.mailboxConcurrency > 0 && attempt.Trigger != nil {
+		mailboxes, err := h.changedMailboxes(ctx, client, username, attempt.Trigger.Mailboxes, filter)
+		if err != nil {
+			logger.Error("Failed to list mailboxes for the per-mailbox sync", "username", username, "error", err)
+			return h.syncFailure(ctx, username, stateKey, err)
+		}
+		if len(mailboxes) > 0 {
+			if err := h.syncMailboxes(ctx, client, username, destination, mailboxes); err != nil {
+				logger.Error("Per-mailbox dsync failed", "username", username, "error", err)
+				h.forgetBackend(username)
+				return h.syncFailure(ctx, username, stateKey, err)
+			}
+			// the stored state stays valid; the next account sync starts from it
+			h.syncCompleted(ctx, username, destination, stateKey, start, false)
+			return nil
+		}
+	}
+
+	var opts doveadm.SyncOptions
+	if filter != nil {
+		var mailboxes []string
+		if filter.NeedsMailboxes() {
+			if mailboxes, err = client.ListMailboxes(ctx, username); err != nil {
+				logger.Error("Failed to list mailboxes for the mailbox filter", "username", username, "error", err)
+				return h.syncFailure(ctx, username, stateKey, fmt.Errorf("failed to list mailboxes: %w", err))
+			}
+		}
+		opts.ExcludeMailboxes = filter.Excludes(mailboxes)
+	}
+
+	resp, err := client.SyncWith(ctx, username, destination, state, opts)
+	if err != nil {
+		logger.Error("dsync failed", "username", username, "error", err)
+		h.forgetBackend(username)
+		if state != "" {
+			h.handleIncrementalFailure(ctx, stateKey)
+		}
+		return h.syncFailure(ctx, username, stateKey, err)
+	}
+
+	if state != "" {
+		if err := h.queue.ClearIncrementalFailures(ctx, stateKey); err != nil {
+			logger.Warn("Failed to clear incremental failure count", "username", username, "error", err)
+		}
+	}
+
+	for _, warning := range resp.Warnings {
+		logger.Warn("dsync reported warning",
+			"username", username,
+			"destination", destination,
+			"type", warning.Type,
+			"mailbox", warning.Mailbox,
+			"message", warning.Message,
+		)
+		h.metrics.SyncWarnings.WithLabelValues(h.metrics.LimitLabel("type", warning.Type)).Inc()
+	}
 
 	// Store the new replication state for next sync
 	if resp.State != "" {
-		if err := h.queue.SetReplicationState(ctx, username, resp.State); err != nil {
-			h.logger.Warn("Failed to store replication state", "username", username, "error", err)
+		if err := h.queue.SetReplicationState(ctx, stateKey, resp.State); err != nil {
+			logger.Warn("Failed to store replication state", "username", username, "error", err)
 			// Don't fail the sync operation if state storage fails
 		} else {
-			h.logger.Debug("Stored replication state", "username", username)
+			logger.Debug("Stored replication state", "username", username)
 		}
 	}
 
+	if attempt.Initial {
+		// the last replication time stored below marks the user as onboarded
+		logger.Info("Initial sync of first-seen user completed", "username", username)
+	}
+	h.syncCompleted(ctx, username, destination, stateKey, start, filter == nil)
+	return nil
+}
+
+// syncCompleted records a successful sync that started at start. wholeAccount
+// tells whether every mailbox was synced, so that the sync makes later syncs of
+// the user within the minimum interval redundant.
+func (h *DoveadmEventHandler) syncCompleted(ctx context.Context, username, destination, stateKey string, start time.Time, wholeAccount bool) {
+	logger := jobLogger(ctx, h.logger)
+
 	// Record the timestamp of this successful replication
-	if err := h.queue.SetLastReplicationTime(ctx, username, time.Now()); err != nil {
-		h.logger.Warn("Failed to store last replication time", "username", username, "error", err)
+	now := time.Now()
+	h.metrics.LastSuccessfulSync.WithLabelValues(h.metrics.LimitLabel("destination", destination)).Set(float64(now.Unix()))
+	// per destination, so that the primary is not taken as current after syncs to the fallback
+	if err := h.queue.SetLastReplicationTime(ctx, stateKey, now); err != nil {
+		logger.Warn("Failed to store last replication time", "username", username, "error", err)
 		// Don't fail the sync operation if timestamp storage fails
 	}
 
-	h.logger.Info("dsync completed", "username", username)
-	return nil
+	h.recordLatency(ctx, username, now)
+	if wholeAccount {
+		h.rememberSync(stateKey, start, now)
+	} else {
+		h.forgetSync(stateKey)
+	}
+	logger.Info("dsync completed", "username", username)
+}
+
+// maxSyncTagIDs bounds the correlation IDs in the tag of a sync request; the
+// log lines of the sync carry all of them.
+const maxSyncTagIDs = 10
+
+// syncTag returns the doveadm tag of a sync triggered by the events with the
+// given correlation IDs.
+func syncTag(ids []string) string {
+	return "dovewarden-" + strings.Join(ids[:min(len(ids), maxSyncTagIDs)], ",")
+}
+
+// firstSeen reports whether a user has never been replicated. Users whose
+// state was dropped, e.g. after repeated failures, keep their last replication
+// time and are not first-seen.
+func (h *DoveadmEventHandler) firstSeen(ctx context.Context, username string) bool {
+	logger := jobLogger(ctx, h.logger)
+	last, err := h.queue.GetLastReplicationTime(ctx, username)
+	if err != nil {
+		logger.Warn("Failed to get last replication time", "username", username, "error", err)
+		return false
+	}
+	return last.IsZero()
+}
+
+// changedMailboxes returns the mailboxes named by the events that triggered an
+// incremental sync if they are worth syncing in parallel instead of the
+// account, which takes long for huge accounts even with a state: at least two
+// mailboxes allowed by the filter, all of which still exist. Otherwise, e.g.
+// after a mailbox was renamed or deleted, it returns none and the account sync
+// reconciles the mailboxes.
+func (h *DoveadmEventHandler) changedMailboxes(ctx context.Context, client *doveadm.Client, username string, changed []string, filter *MailboxFilter) ([]string, error) {
+	changed = slices.Compact(slices.Sorted(slices.Values(changed)))
+	if filter != nil {
+		changed = slices.DeleteFunc(changed, func(mailbox string) bool { return !filter.Allows(mailbox) })
+	}
+	if len(changed) < 2 {
+		// a single mailbox gains nothing over the incremental account sync
+		return nil, nil
+	}
+	existing, err := client.ListMailboxes(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+	for _, mailbox := range changed {
+		if !slices.Contains(existing, mailbox) {
+			return nil, nil
+		}
+	}
+	return changed, nil
+}
+
+// syncMailboxes syncs the given mailboxes of a user in parallel, bounded by the
+// mailbox concurrency. Single-mailbox syncs cannot use the account's state, so
+// the stored state is kept for the next account sync.
+func (h *DoveadmEventHandler) syncMailboxes(ctx context.Context, client *doveadm.Client, username, destination string, mailboxes []string) error {
+	logger := jobLogger(ctx, h.logger)
+	logger.Debug("Syncing changed mailboxes in parallel", "username", username, "mailboxes", len(mailboxes), "concurrency", h.mailboxConcurrency)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		sem  = make(chan struct{}, h.mailboxConcurrency)
+	)
+	for _, mailbox := range mailboxes {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			resp, err := client.SyncMailbox(ctx, username, destination, mailbox)
+			if err != nil {
+				h.metrics.MailboxSyncs.WithLabelValues("failure").Inc()
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("mailbox %q: %w", mailbox, err))
+				mu.Unlock()
+				return
+			}
+			h.metrics.MailboxSyncs.WithLabelValues("success").Inc()
+			for _, warning := range resp.Warnings {
+				logger.Warn("dsync reported warning",
+					"username", username,
+					"destination", destination,
+					"type", warning.Type,
+					"mailbox", mailbox,
+					"message", warning.Message,
+				)
+				h.metrics.SyncWarnings.WithLabelValues(h.metrics.LimitLabel("type", warning.Type)).Inc()
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Delays of the retries of failures that are not retried right away.
+const (
+	// tempFailRetryDelay is how long a user is deferred after doveadm reported
+	// a temporary failure, e.g. because another dsync of the user is running.
+	tempFailRetryDelay = 10 * time.Second
+	// overloadRetryDelay gives an overloaded doveadm time to recover.
+	overloadRetryDelay = 30 * time.Second
+	// authRetryDelay avoids hammering doveadm with rejected credentials until
+	// the password is fixed or rotated.
+	authRetryDelay = time.Minute
+)
+
+// syncFailure classifies a failed sync, counts it by class and adds the retry
+// hints of its class: users that do not exist are purged if enabled and not
+// retried, temporary failures, overload and rejected credentials are retried
+// after a delay, honoring a Retry-After header, and a rejected replication
+// state is dropped so that the retry runs as a full sync. Other failures are
+// retried right away. Overload and bursts of temporary failures also cool
+// down all syncs, see SetCoolDown.
+func (h *DoveadmEventHandler) syncFailure(ctx context.Context, username, stateKey string, err error) error {
+	logger := jobLogger(ctx, h.logger)
+	class := h.classifier.Classify(err)
+	h.metrics.SyncFailures.WithLabelValues(class).Inc()
+	logger.Debug("Classified sync failure", "username", username, "class", class)
+	switch class {
+	case doveadm.ErrorUserMissing:
+		if h.purgeDeletedUsers {
+			if _, purgeErr := h.queue.DeleteUser(ctx, username); purgeErr != nil {
+				logger.Error("Failed to purge deleted user", "username", username, "error", purgeErr)
+			} else {
+				logger.Info("User no longer exists, purged its data", "username", username)
+				h.metrics.DeletedUsersPurged.Inc()
+				return &Result{Err: err, UserDeleted: true, Class: class}
+			}
+		}
+		return &Result{Err: err, Permanent: true, Class: class}
+	case doveadm.ErrorTempFail:
+		result := &Result{Err: err, RetryAfter: tempFailRetryDelay, Class: class}
+		if h.tempFailBurst() {
+			logger.Warn("Many temporary failures, cooling down all syncs", "threshold", h.tempFailThreshold, "window", tempFailWindow, "cool_down", h.coolDown)
+			h.metrics.CoolDowns.WithLabelValues("tempfail").Inc()
+			result.CoolDown = h.coolDown
+		}
+		return result
+	case doveadm.ErrorOverload:
+		result := &Result{Err: err, RetryAfter: overloadRetryDelay, Class: class}
+		var statusErr *doveadm.StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			result.RetryAfter = statusErr.RetryAfter
+			if h.maxCoolDown > 0 {
+				result.RetryAfter = min(result.RetryAfter, h.maxCoolDown)
+			}
+		}
+		if h.coolDown > 0 {
+			result.CoolDown = h.coolDown
+			if statusErr != nil && statusErr.RetryAfter > 0 {
+				result.CoolDown = result.RetryAfter
+			}
+			logger.Warn("Doveadm is overloaded, cooling down all syncs", "username", username, "cool_down", result.CoolDown)
+			h.metrics.CoolDowns.WithLabelValues("overload").Inc()
+		}
+		return result
+	case doveadm.ErrorAuth:
+		return &Result{Err: err, RetryAfter: authRetryDelay, Class: class}
+	case doveadm.ErrorStateInvalid:
+		logger.Warn("Replication state rejected, next sync will be a full sync", "username", username, "error", err)
+		if delErr := h.queue.DeleteReplicationState(ctx, stateKey); delErr != nil {
+			logger.Error("Failed to drop replication state", "username", username, "error", delErr)
+		}
+	}
+	return &Result{Err: err, Class: class}
+}
+
+// tempFailWindow is the window in which the temporary failures counted towards
+// the cool-down threshold must occur.
+const tempFailWindow = 10 * time.Second
+
+// tempFailBurst records a temporary failure and reports whether the threshold
+// of temporary failures within tempFailWindow was reached, which resets the
+// count.
+func (h *DoveadmEventHandler) tempFailBurst() bool {
+	if h.coolDown <= 0 || h.tempFailThreshold <= 0 {
+		return false
+	}
+	h.tempFailMu.Lock()
+	defer h.tempFailMu.Unlock()
+	now := time.Now()
+	h.tempFails = slices.DeleteFunc(h.tempFails, func(t time.Time) bool { return now.Sub(t) >= tempFailWindow })
+	h.tempFails = append(h.tempFails, now)
+	if len(h.tempFails) < h.tempFailThreshold {
+		return false
+	}
+	h.tempFails = h.tempFails[:0]
+	return true
+}
+
+// handleIncrementalFailure counts a failed incremental sync and drops the stored
+// state once the threshold is reached, so the requeued retry runs as a full sync.
+func (h *DoveadmEventHandler) handleIncrementalFailure(ctx context.Context, username string) {
+	logger := jobLogger(ctx, h.logger)
+	if h.stateResetThreshold <= 0 {
+		return
+	}
+	failures, err := h.queue.IncrIncrementalFailures(ctx, username)
+	if err != nil {
+		logger.Warn("Failed to count incremental failure", "username", username, "error", err)
+		return
+	}
+	if failures < h.stateResetThreshold {
+		return
+	}
+
+	logger.Warn("Dropping replication state after repeated incremental failures, next sync will be a full sync",
+		"username", username,
+		"consecutive_failures", failures,
+	)
+	if err := h.queue.DeleteReplicationState(ctx, username); err != nil {
+		logger.Error("Failed to drop replication state", "username", username, "error", err)
+		return
+	}
+	if err := h.queue.ClearIncrementalFailures(ctx, username); err != nil {
+		logger.Warn("Failed to clear incremental failure count", "username", username, "error", err)
+	}
+	h.metrics.ForcedStateResets.Inc()
+}
+
+// recordLatency records the time from the first event that triggered the sync
+// to its completion, both from the receipt of the event and, if it carries
+// one, from its Dovecot timestamp. Syncs without a triggering event, e.g. by
+// background replication, are not counted.
+func (h *DoveadmEventHandler) recordLatency(ctx context.Context, username string, completedAt time.Time) {
+	logger := jobLogger(ctx, h.logger)
+	info := EventInfoFromContext(ctx)
+	if info == nil {
+		return
+	}
+	if !info.OccurredAt.IsZero() {
+		// the clocks of Dovecot and dovewarden may be skewed
+		h.metrics.EventLatency.Observe(max(completedAt.Sub(info.OccurredAt), 0).Seconds())
+	}
+	if info.TriggeredAt.IsZero() {
+		return
+	}
+	latency := completedAt.Sub(info.TriggeredAt)
+	if latency < 0 {
+		latency = 0
+	}
+	h.metrics.ReplicationLatency.Observe(latency.Seconds())
+	if err := h.queue.RecordSyncLatency(ctx, completedAt, latency); err != nil {
+		logger.Warn("Failed to record sync latency", "username", username, "error", err)
+	}
+}
+
+// recordHistory appends the outcome of a sync attempt to the user's history.
+func (h *DoveadmEventHandler) recordHistory(ctx context.Context, attempt SyncInfo, start time.Time, syncErr error) {
+	logger := jobLogger(ctx, h.logger)
+	if h.historySize <= 0 {
+		return
+	}
+	entry := HistoryEntry{
+		StartedAt:       start,
+		DurationSeconds: time.Since(start).Seconds(),
+		Result:          HistoryResultSuccess,
+		FullSync:        attempt.FullSync,
+		Initial:         attempt.Initial,
+		Trigger:         attempt.Trigger,
+		Origin:          attempt.Origin,
+	}
+	if attempt.Destination != h.destination {
+		entry.Destination = attempt.Destination
+	}
+	if h.dryRun {
+		entry.Result = HistoryResultDryRun
+	}
+	if syncErr != nil {
+		entry.Result = HistoryResultFailure
+		entry.Error = syncErr.Error()
+	}
+	if err := h.queue.AppendHistory(ctx, attempt.Username, entry, h.historySize); err != nil {
+		logger.Warn("Failed to record sync history", "username", attempt.Username, "error", err)
+	}
 }
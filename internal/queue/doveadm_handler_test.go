@@ -0,0 +1,469 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+	"github.com/dovewarden/dovewarden/internal/doveadm/doveadmtest"
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newFakeDoveadm returns a doveadm API stub that fails every incremental sync
+// (non-empty state) and succeeds full syncs with state "full-state".
+func newFakeDoveadm(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload [][]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		params := payload[0][1].(map[string]interface{})
+		if params["state"] != "" {
+			_, _ = w.Write([]byte(`[["error",{"type":"exitCode","exitCode":1},"dovewarden-sync"]]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[["doveadmResponse",{"state":"full-state"},"dovewarden-sync"]]`))
+	}))
+}
+
+func TestDoveadmHandlerStateReset(t *testing.T) {
+	srv := newFakeDoveadm(t)
+	defer srv.Close()
+
+	q, err := NewInMemoryQueue("test-state-reset", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "testpass", "imap", testLogger(), q, m)
+	h.SetStateResetThreshold(2)
+
+	ctx := context.Background()
+	if err := q.SetReplicationState(ctx, "user-a", "broken-state"); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+
+	// first failure keeps the state
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err == nil {
+		t.Fatal("expected incremental sync to fail")
+	}
+	if state, _ := q.GetReplicationState(ctx, "user-a"); state != "broken-state" {
+		t.Fatalf("expected state to be kept after one failure, got %q", state)
+	}
+
+	// second failure reaches the threshold and drops the state
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err == nil {
+		t.Fatal("expected incremental sync to fail")
+	}
+	if state, _ := q.GetReplicationState(ctx, "user-a"); state != "" {
+		t.Fatalf("expected state to be dropped, got %q", state)
+	}
+	if got := testutil.ToFloat64(m.ForcedStateResets); got != 1 {
+		t.Fatalf("expected 1 forced reset, got %v", got)
+	}
+
+	// the retry runs as a full sync and stores a fresh state
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err != nil {
+		t.Fatalf("expected full sync to succeed, got %v", err)
+	}
+	if state, _ := q.GetReplicationState(ctx, "user-a"); state != "full-state" {
+		t.Fatalf("expected fresh state, got %q", state)
+	}
+}
+
+func TestDoveadmHandlerHistory(t *testing.T) {
+	srv := newFakeDoveadm(t)
+	defer srv.Close()
+
+	q, err := NewInMemoryQueue("test-history", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+
+	h := NewDoveadmEventHandler(srv.URL, "testpass", "imap", testLogger(), q, metrics.New(prometheus.NewRegistry()))
+	h.SetHistorySize(2)
+
+	ctx := context.Background()
+	trigger := &EventInfo{Event: "imap_command_finished", CmdName: "APPEND", Mailboxes: []string{"INBOX"}, Origin: OriginEvent}
+	background := &EventInfo{Origin: OriginBackground}
+	// full sync succeeds, the following incremental syncs fail
+	if err := h.Handle(ctx, QueueItem{Username: "user-a", Info: trigger}); err != nil {
+		t.Fatalf("expected full sync to succeed, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := h.Handle(ctx, QueueItem{Username: "user-a", Info: background}); err == nil {
+			t.Fatal("expected incremental sync to fail")
+		}
+	}
+
+	history, err := q.GetHistory(ctx, "user-a")
+	if err != nil {
+		t.Fatalf("get history: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected history to be bounded to 2 entries, got %+v", history)
+	}
+	for _, entry := range history {
+		if entry.Result != HistoryResultFailure || entry.FullSync || entry.Error == "" || entry.Trigger != nil || entry.Origin != OriginBackground {
+			t.Fatalf("expected failed incremental background sync without trigger, got %+v", entry)
+		}
+	}
+
+	if err := q.DeleteReplicationState(ctx, "user-a"); err != nil {
+		t.Fatalf("delete state: %v", err)
+	}
+	if err := h.Handle(ctx, QueueItem{Username: "user-a", Info: trigger}); err != nil {
+		t.Fatalf("expected full sync to succeed, got %v", err)
+	}
+	history, _ = q.GetHistory(ctx, "user-a")
+	latest := history[0]
+	if latest.Result != HistoryResultSuccess || !latest.FullSync || latest.Trigger == nil || latest.Trigger.CmdName != "APPEND" || latest.Origin != OriginEvent {
+		t.Fatalf("expected successful full sync triggered by APPEND first, got %+v", latest)
+	}
+
+	if found, err := q.DeleteUser(ctx, "user-a"); err != nil || !found {
+		t.Fatalf("delete user: found=%v err=%v", found, err)
+	}
+	if history, _ := q.GetHistory(ctx, "user-a"); len(history) != 0 {
+		t.Fatalf("expected history to be deleted with the user, got %+v", history)
+	}
+}
+
+func TestDoveadmHandlerDryRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected no doveadm call in dry-run mode")
+	}))
+	defer srv.Close()
+
+	q, err := NewInMemoryQueue("test-dry-run", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "testpass", "imap", testLogger(), q, m)
+	h.SetDryRun(true)
+	h.SetHistorySize(5)
+
+	ctx := context.Background()
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err != nil {
+		t.Fatalf("expected dry run to succeed, got %v", err)
+	}
+	if err := q.SetReplicationState(ctx, "user-b", "c3RhdGU="); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+	if err := h.Handle(ctx, QueueItem{Username: "user-b"}); err != nil {
+		t.Fatalf("expected dry run to succeed, got %v", err)
+	}
+
+	if got := testutil.ToFloat64(m.DryRunSyncs.WithLabelValues("full")); got != 1 {
+		t.Fatalf("expected 1 full dry-run sync, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.DryRunSyncs.WithLabelValues("incremental")); got != 1 {
+		t.Fatalf("expected 1 incremental dry-run sync, got %v", got)
+	}
+	if last, _ := q.GetLastReplicationTime(ctx, "user-a"); !last.IsZero() {
+		t.Fatalf("expected no last replication time in dry-run mode, got %v", last)
+	}
+	if state, _ := q.GetReplicationState(ctx, "user-b"); state != "c3RhdGU=" {
+		t.Fatalf("expected state to be kept, got %q", state)
+	}
+	if history, _ := q.GetHistory(ctx, "user-a"); len(history) != 1 || history[0].Result != HistoryResultDryRun {
+		t.Fatalf("expected dry-run history entry, got %+v", history)
+	}
+}
+
+func TestDoveadmHandlerMailboxSyncs(t *testing.T) {
+	fake := doveadmtest.New("secret", nil)
+	fake.SetConfig(doveadmtest.Config{Mailboxes: []string{"INBOX", "Sent", "Trash", "Archive"}})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	q, err := NewInMemoryQueue("test-mailbox-syncs", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), q, m)
+	h.SetMailboxConcurrency(2)
+
+	// full syncs are not split, as only the account sync returns a state
+	ctx := context.Background()
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err != nil {
+		t.Fatalf("expected full sync to succeed, got %v", err)
+	}
+	stats := fake.Stats()
+	if stats.MailboxSyncs["user-a"] != 0 || stats.Syncs["user-a"] != 1 {
+		t.Fatalf("expected only an account sync, got %+v", stats)
+	}
+	state, _ := q.GetReplicationState(ctx, "user-a")
+	if state == "" {
+		t.Fatal("expected the account sync to store a state")
+	}
+
+	// an incremental sync of several changed mailboxes syncs just those
+	changed := &EventInfo{Mailboxes: []string{"Sent", "INBOX", "Sent"}}
+	if err := h.Handle(ctx, QueueItem{Username: "user-a", Info: changed}); err != nil {
+		t.Fatalf("expected incremental sync to succeed, got %v", err)
+	}
+	stats = fake.Stats()
+	if stats.MailboxSyncs["user-a"] != 2 || stats.Syncs["user-a"] != 1 {
+		t.Fatalf("expected 2 mailbox syncs and no account sync, got %+v", stats)
+	}
+	if got := testutil.ToFloat64(m.MailboxSyncs.WithLabelValues("success")); got != 2 {
+		t.Fatalf("expected 2 successful mailbox syncs, got %v", got)
+	}
+	if kept, _ := q.GetReplicationState(ctx, "user-a"); kept != state {
+		t.Fatalf("expected the state to be kept, got %q", kept)
+	}
+
+	// a single changed mailbox or one that is gone syncs the account
+	for _, mailboxes := range [][]string{{"INBOX"}, {"INBOX", "Renamed"}} {
+		if err := h.Handle(ctx, QueueItem{Username: "user-a", Info: &EventInfo{Mailboxes: mailboxes}}); err != nil {
+			t.Fatalf("expected incremental sync to succeed, got %v", err)
+		}
+	}
+	stats = fake.Stats()
+	if stats.MailboxSyncs["user-a"] != 2 || stats.Syncs["user-a"] != 3 {
+		t.Fatalf("expected only account syncs, got %+v", stats)
+	}
+
+	// a failed mailbox sync fails the whole attempt without an account sync
+	fake.SetConfig(doveadmtest.Config{Mailboxes: []string{"INBOX", "Sent"}, FailingUsers: []string{"user-b"}})
+	if err := q.SetReplicationState(ctx, "user-b", state); err != nil {
+		t.Fatalf("failed to store state: %v", err)
+	}
+	if err := h.Handle(ctx, QueueItem{Username: "user-b", Info: changed}); err == nil {
+		t.Fatal("expected sync of failing user to fail")
+	}
+	if stats := fake.Stats(); stats.Syncs["user-b"] != 0 {
+		t.Fatalf("expected no account sync after failed mailbox syncs, got %+v", stats)
+	}
+
+	// a cancelled sync does not wait for a free mailbox slot
+	h.SetMailboxConcurrency(1)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	client := doveadm.NewClient(srv.URL, "secret")
+	if err := h.syncMailboxes(cancelled, client, "user-a", "imap", []string{"INBOX", "Sent"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancellation, got %v", err)
+	}
+}
+
+func TestDoveadmHandlerRetryHints(t *testing.T) {
+	fake := doveadmtest.New("secret", nil)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), q, m)
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		exitCode  int
+		permanent bool
+		delayed   bool
+		class     string
+	}{
+		{exitCode: 67, permanent: true, class: doveadm.ErrorUserMissing},
+		{exitCode: 75, delayed: true, class: doveadm.ErrorTempFail},
+		{exitCode: 77, delayed: true, class: doveadm.ErrorAuth},
+		{exitCode: 1, class: doveadm.ErrorOther},
+	} {
+		fake.SetConfig(doveadmtest.Config{FailingUsers: []string{"user-a"}, ExitCode: tc.exitCode})
+		err := h.Handle(ctx, QueueItem{Username: "user-a"})
+		if err == nil {
+			t.Fatalf("exit code %d: expected sync to fail", tc.exitCode)
+		}
+		result := ResultOf(err)
+		if result.Permanent != tc.permanent || (result.RetryAfter > 0) != tc.delayed || result.Class != tc.class {
+			t.Fatalf("exit code %d: unexpected retry hints %+v", tc.exitCode, result)
+		}
+		if got := testutil.ToFloat64(m.SyncFailures.WithLabelValues(tc.class)); got != 1 {
+			t.Fatalf("exit code %d: expected 1 %s failure, got %v", tc.exitCode, tc.class, got)
+		}
+	}
+}
+
+func TestDoveadmHandlerCoolDown(t *testing.T) {
+	var retryAfter atomic.Value
+	retryAfter.Store("")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := retryAfter.Load().(string); v != "" {
+			w.Header().Set("Retry-After", v)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), q, m)
+	h.SetCoolDown(30*time.Second, 2*time.Minute, 0)
+	ctx := context.Background()
+
+	result := ResultOf(h.Handle(ctx, QueueItem{Username: "user-a"}))
+	if result.Class != doveadm.ErrorOverload || result.RetryAfter != overloadRetryDelay || result.CoolDown != 30*time.Second {
+		t.Fatalf("unexpected retry hints without Retry-After %+v", result)
+	}
+
+	retryAfter.Store("90")
+	result = ResultOf(h.Handle(ctx, QueueItem{Username: "user-a"}))
+	if result.RetryAfter != 90*time.Second || result.CoolDown != 90*time.Second {
+		t.Fatalf("expected Retry-After to be honored, got %+v", result)
+	}
+
+	retryAfter.Store("3600")
+	result = ResultOf(h.Handle(ctx, QueueItem{Username: "user-a"}))
+	if result.RetryAfter != 2*time.Minute || result.CoolDown != 2*time.Minute {
+		t.Fatalf("expected Retry-After to be capped, got %+v", result)
+	}
+	if got := testutil.ToFloat64(m.CoolDowns.WithLabelValues("overload")); got != 3 {
+		t.Fatalf("expected 3 overload cool-downs, got %v", got)
+	}
+}
+
+func TestDoveadmHandlerTempFailCoolDown(t *testing.T) {
+	fake := doveadmtest.New("secret", nil)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	fake.SetConfig(doveadmtest.Config{FailingUsers: []string{"user-a", "user-b", "user-c"}, ExitCode: doveadm.ExitCodeTempFail})
+
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), q, metrics.New(prometheus.NewRegistry()))
+	h.SetCoolDown(time.Minute, 5*time.Minute, 3)
+
+	ctx := context.Background()
+	for i, user := range []string{"user-a", "user-b", "user-c"} {
+		result := ResultOf(h.Handle(ctx, QueueItem{Username: user}))
+		if result.RetryAfter != tempFailRetryDelay {
+			t.Fatalf("%s: unexpected retry hints %+v", user, result)
+		}
+		if wantCoolDown := i == 2; (result.CoolDown > 0) != wantCoolDown {
+			t.Fatalf("%s: expected cool-down %v, got %+v", user, wantCoolDown, result)
+		}
+	}
+}
+
+func TestDoveadmHandlerErrorRules(t *testing.T) {
+	fake := doveadmtest.New("secret", nil)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), q, metrics.New(prometheus.NewRegistry()))
+	classifier, err := doveadm.NewClassifier([]doveadm.ErrorRule{{Pattern: `exitCode 1\)`, Class: doveadm.ErrorStateInvalid}})
+	if err != nil {
+		t.Fatalf("classifier: %v", err)
+	}
+	h.SetErrorClassifier(classifier)
+
+	ctx := context.Background()
+	if err := q.SetReplicationState(ctx, "user-a", "rejected-state"); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+	fake.SetConfig(doveadmtest.Config{FailingUsers: []string{"user-a"}, ExitCode: 1})
+	err = h.Handle(ctx, QueueItem{Username: "user-a"})
+	if result := ResultOf(err); result.Class != doveadm.ErrorStateInvalid || result.RetryAfter > 0 || result.Permanent {
+		t.Fatalf("unexpected retry hints %+v", result)
+	}
+	if state, err := q.GetReplicationState(ctx, "user-a"); err != nil || state != "" {
+		t.Fatalf("expected the rejected state to be dropped, got %q (err %v)", state, err)
+	}
+}
+
+func TestDoveadmHandlerPurgesDeletedUsers(t *testing.T) {
+	fake := doveadmtest.New("secret", nil)
+	fake.SetConfig(doveadmtest.Config{FailingUsers: []string{"user-a"}, ExitCode: 67})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), q, m)
+	h.SetHistorySize(5)
+	h.SetPurgeDeletedUsers(true)
+
+	ctx := context.Background()
+	if err := q.SetReplicationState(ctx, "user-a", "AQAAAKhj"); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+	if err := q.RecordFailure(ctx, "user-a"); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+
+	err := h.Handle(ctx, QueueItem{Username: "user-a"})
+	if !ResultOf(err).UserDeleted {
+		t.Fatalf("expected the user to be reported as deleted, got %v", err)
+	}
+	if found, err := q.DeleteUser(ctx, "user-a"); err != nil || found {
+		t.Fatalf("expected all data of the user to be purged: found=%v err=%v", found, err)
+	}
+	if got := testutil.ToFloat64(m.DeletedUsersPurged); got != 1 {
+		t.Fatalf("expected 1 purged user, got %v", got)
+	}
+}
+
+func TestDoveadmHandlerInitialSync(t *testing.T) {
+	srv := newFakeDoveadm(t)
+	defer srv.Close()
+
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "testpass", "imap", testLogger(), q, m)
+	h.SetHistorySize(5)
+
+	ctx := context.Background()
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err != nil {
+		t.Fatalf("expected initial sync to succeed, got %v", err)
+	}
+	history, _ := q.GetHistory(ctx, "user-a")
+	if len(history) != 1 || !history[0].Initial || !history[0].FullSync {
+		t.Fatalf("expected an initial full sync, got %+v", history)
+	}
+	if got := testutil.ToFloat64(m.InitialSyncs.WithLabelValues(HistoryResultSuccess)); got != 1 {
+		t.Fatalf("expected 1 successful initial sync, got %v", got)
+	}
+
+	// a dropped state leads to a full sync, but the user is known already
+	if err := q.DeleteReplicationState(ctx, "user-a"); err != nil {
+		t.Fatalf("delete state: %v", err)
+	}
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err != nil {
+		t.Fatalf("expected full sync to succeed, got %v", err)
+	}
+	history, _ = q.GetHistory(ctx, "user-a")
+	if !history[0].FullSync || history[0].Initial {
+		t.Fatalf("expected a full sync that is not initial, got %+v", history[0])
+	}
+}
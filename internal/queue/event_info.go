@@ -0,0 +1,210 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/events"
+)
+
+// EventInfo carries context of the events that caused a user to be queued.
+// Since events for the same user are coalesced into one queue entry, mailboxes
+// and message GUIDs of all coalesced events are accumulated, while the command
+// fields reflect the most recent event. TriggeredAt is the time of the first
+// event, which replication latency is measured from, and OccurredAt the time
+// Dovecot emitted it, which event latency is measured from. CorrelationIDs
+// identify the coalesced events in the logs of the sync. Origin is the most
+// significant origin the user was queued with, see Origins. Attempts counts
+// the failed syncs the user was requeued after.
+type EventInfo struct {
+	Event          string    `json:"event,omitempty"`
+	CmdName        string    `json:"cmd_name,omitempty"`
+	CmdInputName   string    `json:"cmd_input_name,omitempty"`
+	Mailboxes      []string  `json:"mailboxes,omitempty"`
+	MessageGUIDs   []string  `json:"message_guids,omitempty"`
+	CorrelationIDs []string  `json:"correlation_ids,omitempty"`
+	TriggeredAt    time.Time `json:"triggered_at,omitzero"`
+	OccurredAt     time.Time `json:"occurred_at,omitzero"`
+	Origin         Origin    `json:"origin,omitempty"`
+	Attempts       int       `json:"attempts,omitempty"`
+}
+
+// NewEventInfo returns the info to store with the user of an accepted event
+// that was received at triggeredAt.
+func NewEventInfo(filtered *events.FilteredEvent, triggeredAt time.Time) *EventInfo {
+	info := &EventInfo{
+		Event:        filtered.Event,
+		CmdName:      filtered.CmdName,
+		CmdInputName: filtered.CmdInputName,
+		TriggeredAt:  triggeredAt,
+		OccurredAt:   filtered.OccurredAt,
+		Origin:       OriginEvent,
+	}
+	if filtered.Mailbox != "" {
+		info.Mailboxes = []string{filtered.Mailbox}
+	}
+	if filtered.MessageGUID != "" {
+		info.MessageGUIDs = []string{filtered.MessageGUID}
+	}
+	if filtered.CorrelationID != "" {
+		info.CorrelationIDs = []string{filtered.CorrelationID}
+	}
+	return info
+}
+
+// Hash field names and prefixes used to store EventInfo in Redis. Mailboxes and
+// GUIDs are stored as individual fields so that HSET merges them naturally.
+const (
+	eventInfoFieldEvent        = "event"
+	eventInfoFieldCmdName      = "cmd_name"
+	eventInfoFieldCmdInputName = "cmd_input_name"
+	eventInfoFieldTriggeredAt  = "triggered_at" // unix nanoseconds, set only by the first event
+	eventInfoFieldOccurredAt   = "occurred_at"  // unix nanoseconds, set only by the first event carrying one
+	eventInfoFieldAttempts     = "attempts"     // set by retries, kept by later events
+	eventInfoMailboxPrefix     = "mailbox:"
+	eventInfoGUIDPrefix        = "guid:"
+	eventInfoCorrelationPrefix = "correlation:"
+	eventInfoOriginPrefix      = "origin:" // one field per origin, the most significant one wins
+)
+
+// hashFields flattens the info into hash field/value pairs.
+func (i *EventInfo) hashFields() []interface{} {
+	var fields []interface{}
+	if i.Event != "" {
+		fields = append(fields, eventInfoFieldEvent, i.Event)
+	}
+	if i.CmdName != "" {
+		fields = append(fields, eventInfoFieldCmdName, i.CmdName)
+	}
+	if i.CmdInputName != "" {
+		fields = append(fields, eventInfoFieldCmdInputName, i.CmdInputName)
+	}
+	for _, mailbox := range i.Mailboxes {
+		if mailbox != "" {
+			fields = append(fields, eventInfoMailboxPrefix+mailbox, "1")
+		}
+	}
+	for _, guid := range i.MessageGUIDs {
+		if guid != "" {
+			fields = append(fields, eventInfoGUIDPrefix+guid, "1")
+		}
+	}
+	for _, id := range i.CorrelationIDs {
+		if id != "" {
+			fields = append(fields, eventInfoCorrelationPrefix+id, "1")
+		}
+	}
+	if i.Origin != "" {
+		fields = append(fields, eventInfoOriginPrefix+string(i.Origin), "1")
+	}
+	if i.Attempts > 0 {
+		fields = append(fields, eventInfoFieldAttempts, strconv.Itoa(i.Attempts))
+	}
+	return fields
+}
+
+// mergeInto merges the info into stored, a hash representation of coalesced
+// event info, which is allocated if nil. The times of the first event are
+// kept. Returns the merged hash and whether the info added anything.
+func (i *EventInfo) mergeInto(stored map[string]string) (map[string]string, bool) {
+	fields := i.hashFields()
+	if len(fields) == 0 && i.TriggeredAt.IsZero() && i.OccurredAt.IsZero() {
+		return stored, false
+	}
+	if stored == nil {
+		stored = make(map[string]string, len(fields)/2+1)
+	}
+	for j := 0; j+1 < len(fields); j += 2 {
+		stored[fields[j].(string)] = fields[j+1].(string)
+	}
+	if _, ok := stored[eventInfoFieldTriggeredAt]; !ok && !i.TriggeredAt.IsZero() {
+		stored[eventInfoFieldTriggeredAt] = strconv.FormatInt(i.TriggeredAt.UnixNano(), 10)
+	}
+	if _, ok := stored[eventInfoFieldOccurredAt]; !ok && !i.OccurredAt.IsZero() {
+		stored[eventInfoFieldOccurredAt] = strconv.FormatInt(i.OccurredAt.UnixNano(), 10)
+	}
+	return stored, true
+}
+
+// eventInfoFromHash rebuilds an EventInfo from its hash representation.
+func eventInfoFromHash(h map[string]string) *EventInfo {
+	info := &EventInfo{
+		Event:        h[eventInfoFieldEvent],
+		CmdName:      h[eventInfoFieldCmdName],
+		CmdInputName: h[eventInfoFieldCmdInputName],
+	}
+	for field := range h {
+		switch {
+		case strings.HasPrefix(field, eventInfoMailboxPrefix):
+			info.Mailboxes = append(info.Mailboxes, strings.TrimPrefix(field, eventInfoMailboxPrefix))
+		case strings.HasPrefix(field, eventInfoGUIDPrefix):
+			info.MessageGUIDs = append(info.MessageGUIDs, strings.TrimPrefix(field, eventInfoGUIDPrefix))
+		case strings.HasPrefix(field, eventInfoCorrelationPrefix):
+			info.CorrelationIDs = append(info.CorrelationIDs, strings.TrimPrefix(field, eventInfoCorrelationPrefix))
+		case strings.HasPrefix(field, eventInfoOriginPrefix):
+			if origin := Origin(strings.TrimPrefix(field, eventInfoOriginPrefix)); info.Origin == "" || moreSignificant(origin, info.Origin) {
+				info.Origin = origin
+			}
+		}
+	}
+	if ns, err := strconv.ParseInt(h[eventInfoFieldTriggeredAt], 10, 64); err == nil {
+		info.TriggeredAt = time.Unix(0, ns)
+	}
+	if ns, err := strconv.ParseInt(h[eventInfoFieldOccurredAt], 10, 64); err == nil {
+		info.OccurredAt = time.Unix(0, ns)
+	}
+	info.Attempts, _ = strconv.Atoi(h[eventInfoFieldAttempts])
+	sort.Strings(info.Mailboxes)
+	sort.Strings(info.MessageGUIDs)
+	sort.Strings(info.CorrelationIDs)
+	return info
+}
+
+// triggered reports whether info stems from an event rather than only carrying
+// the origin of a user queued without one.
+func (i *EventInfo) triggered() bool {
+	return i.Event != "" || !i.TriggeredAt.IsZero() || !i.OccurredAt.IsZero() || len(i.Mailboxes) > 0 || len(i.MessageGUIDs) > 0 || len(i.CorrelationIDs) > 0
+}
+
+type eventInfoKey struct{}
+
+// WithEventInfo returns a context carrying the event info of the job being handled.
+func WithEventInfo(ctx context.Context, info *EventInfo) context.Context {
+	return context.WithValue(ctx, eventInfoKey{}, info)
+}
+
+// jobLogger returns logger annotated with the origin, correlation IDs and
+// failed attempts of the job being handled, so that its log lines can be found
+// by the IDs of its events.
+func jobLogger(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	return eventLogger(EventInfoFromContext(ctx), logger)
+}
+
+// eventLogger returns logger annotated with the origin, correlation IDs and
+// failed attempts of info, which may be nil.
+func eventLogger(info *EventInfo, logger *slog.Logger) *slog.Logger {
+	if info == nil {
+		return logger
+	}
+	if info.Origin != "" {
+		logger = logger.With("origin", info.Origin)
+	}
+	if len(info.CorrelationIDs) > 0 {
+		logger = logger.With("correlation_ids", info.CorrelationIDs)
+	}
+	if info.Attempts > 0 {
+		logger = logger.With("attempts", info.Attempts)
+	}
+	return logger
+}
+
+// EventInfoFromContext returns the event info of the job being handled, or nil if
+// the user was queued without event context (e.g. by background replication).
+func EventInfoFromContext(ctx context.Context) *EventInfo {
+	info, _ := ctx.Value(eventInfoKey{}).(*EventInfo)
+	return info
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestEventInfoMergesAcrossEnqueues(t *testing.T) {
+	q, err := NewInMemoryQueue("testeventinfo", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	if err := q.EnqueueEvent(ctx, "user-a", 1.0, &EventInfo{
+		Event:          "imap_command_finished",
+		CmdName:        "APPEND",
+		Mailboxes:      []string{"INBOX"},
+		MessageGUIDs:   []string{"guid-1"},
+		CorrelationIDs: []string{"id-1"},
+	}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.EnqueueEvent(ctx, "user-a", 1.0, &EventInfo{
+		Event:          "imap_command_finished",
+		CmdName:        "MOVE",
+		CmdInputName:   "UID MOVE",
+		Mailboxes:      []string{"Archive", "INBOX"},
+		CorrelationIDs: []string{"id-2"},
+	}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	// enqueues without info must not drop what was accumulated
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	info, err := q.TakeEventInfo(ctx, "user-a")
+	if err != nil {
+		t.Fatalf("take event info: %v", err)
+	}
+	want := &EventInfo{
+		Event:          "imap_command_finished",
+		CmdName:        "MOVE",
+		CmdInputName:   "UID MOVE",
+		Mailboxes:      []string{"Archive", "INBOX"},
+		MessageGUIDs:   []string{"guid-1"},
+		CorrelationIDs: []string{"id-1", "id-2"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("expected %+v, got %+v", want, info)
+	}
+
+	// info is consumed by TakeEventInfo
+	info, err = q.TakeEventInfo(ctx, "user-a")
+	if err != nil || info != nil {
+		t.Fatalf("expected no info after take, got %+v (err %v)", info, err)
+	}
+}
+
+func TestEventInfoContext(t *testing.T) {
+	ctx := context.Background()
+	if info := EventInfoFromContext(ctx); info != nil {
+		t.Fatalf("expected nil info, got %+v", info)
+	}
+	info := &EventInfo{CmdName: "EXPUNGE"}
+	if got := EventInfoFromContext(WithEventInfo(ctx, info)); got != info {
+		t.Fatalf("expected info from context, got %+v", got)
+	}
+}
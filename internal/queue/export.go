@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// QueueEntry is a queued user as exported by ExportQueue: its item, with the
+// first-enqueue time and event info, and its priority score.
+type QueueEntry struct {
+	Item  QueueItem `json:"item"`
+	Score float64   `json:"score"`
+}
+
+// QueueExport is a JSON snapshot of a queue, see GET /admin/queue/export.
+type QueueExport struct {
+	ExportedAt time.Time    `json:"exported_at"`
+	Entries    []QueueEntry `json:"entries"`
+}
+
+// errInvalidScore is returned by ImportQueue for entries whose score is not finite.
+var errInvalidScore = errors.New("score must be finite")
+
+// ValidateQueueEntries checks entries before any of them is imported.
+func ValidateQueueEntries(entries []QueueEntry) error {
+	for _, entry := range entries {
+		if entry.Item.Username == "" {
+			return errors.New("entry without user")
+		}
+		if math.IsNaN(entry.Score) || math.IsInf(entry.Score, 0) {
+			return fmt.Errorf("%s: %w", entry.Item.Username, errInvalidScore)
+		}
+	}
+	return nil
+}
+
+// ExportQueue returns up to limit queued users in dequeue order with their
+// score, first-enqueue time and event info, without claiming them; limit 0
+// returns all. Users spilled to disk, deferred or in flight are not included.
+func (q *InMemoryQueue) ExportQueue(ctx context.Context, limit int) ([]QueueEntry, error) {
+	queued, err := q.client.ZRangeWithScores(ctx, fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+	pipe := q.client.Pipeline()
+	enqueuedCmds := make([]*redis.FloatCmd, len(queued))
+	infoCmds := make([]*redis.MapStringStringCmd, len(queued))
+	for i, z := range queued {
+		username := z.Member.(string)
+		enqueuedCmds[i] = pipe.ZScore(ctx, fmt.Sprintf("%s:%s", q.ns, ENQUEUED_AT), username)
+		infoCmds[i] = pipe.HGetAll(ctx, fmt.Sprintf("%s:event_info:%s", q.ns, username))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to read queued users: %w", err)
+	}
+
+	entries := make([]QueueEntry, 0, len(queued))
+	for i, z := range queued {
+		var enqueuedAt time.Time
+		if ts, err := enqueuedCmds[i].Result(); err == nil {
+			enqueuedAt = time.Unix(int64(ts), 0)
+		}
+		var info *EventInfo
+		if h := infoCmds[i].Val(); len(h) > 0 {
+			info = eventInfoFromHash(h)
+		}
+		entries = append(entries, QueueEntry{Item: NewQueueItem(z.Member.(string), priorityFromScore(z.Score, enqueuedAt), enqueuedAt, info), Score: z.Score})
+	}
+	return entries, nil
+}
+
+// ImportQueue queues the users of entries with their score, first-enqueue
+// time and event info, e.g. exported from another environment. Users already
+// queued keep the better score and the older first-enqueue time, and the event
+// info is merged. Nothing is imported if an entry is invalid. Returns the
+// number of imported entries.
+func (q *InMemoryQueue) ImportQueue(ctx context.Context, entries []QueueEntry) (int, error) {
+	if err := ValidateQueueEntries(entries); err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	pipe := q.client.TxPipeline()
+	for _, entry := range entries {
+		username := entry.Item.Username
+		q.pipeEventInfo(ctx, pipe, username, entry.Item.Info)
+		enqueuedAt := entry.Item.EnqueuedAt
+		if enqueuedAt.IsZero() {
+			enqueuedAt = now
+		}
+		pipe.ZAddArgs(ctx, fmt.Sprintf("%s:%s", q.ns, ENQUEUED_AT), redis.ZAddArgs{
+			LT:      true,
+			Members: []redis.Z{{Score: float64(enqueuedAt.Unix()), Member: username}},
+		})
+		pipe.ZAddLT(ctx, fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS), redis.Z{Score: entry.Score, Member: username})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to import queue: %w", err)
+	}
+	atomic.AddUint64(&q.enqueueCount, uint64(len(entries)))
+	q.notifyEnqueued()
+	return len(entries), nil
+}
@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestBackendExportImport(t *testing.T) {
+	for name, newQueue := range backends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			src, dst := newQueue(t), newQueue(t)
+			defer func() { _ = src.Close() }()
+			defer func() { _ = dst.Close() }()
+
+			if err := src.EnqueueEvent(ctx, "alice", 1.0, &EventInfo{Event: "messageNew", Mailboxes: []string{"INBOX"}, Origin: OriginEvent}); err != nil {
+				t.Fatalf("EnqueueEvent: %v", err)
+			}
+			if err := src.EnqueueEvent(ctx, "bob", 4.0, &EventInfo{Origin: OriginAdmin}); err != nil {
+				t.Fatalf("EnqueueEvent: %v", err)
+			}
+			entries, err := src.ExportQueue(ctx, 0)
+			if err != nil {
+				t.Fatalf("ExportQueue: %v", err)
+			}
+			if len(entries) != 2 || entries[0].Item.Username != "bob" || entries[1].Item.Username != "alice" {
+				t.Fatalf("exported %+v, want bob before alice", entries)
+			}
+			if entries[1].Item.EnqueuedAt.IsZero() || entries[1].Item.Origin() != OriginEvent || len(entries[1].Item.Mailboxes()) != 1 {
+				t.Errorf("alice exported as %+v", entries[1].Item)
+			}
+			if limited, err := src.ExportQueue(ctx, 1); err != nil || len(limited) != 1 || limited[0].Item.Username != "bob" {
+				t.Errorf("ExportQueue with limit 1 = %+v, %v", limited, err)
+			}
+
+			// as sent to another environment
+			data, err := json.Marshal(QueueExport{Entries: entries})
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			var export QueueExport
+			if err := json.Unmarshal(data, &export); err != nil {
+				t.Fatalf("Unmarshal %s: %v", data, err)
+			}
+			if n, err := dst.ImportQueue(ctx, export.Entries); err != nil || n != 2 {
+				t.Fatalf("ImportQueue = %d, %v", n, err)
+			}
+			imported, err := dst.ExportQueue(ctx, 0)
+			if err != nil {
+				t.Fatalf("ExportQueue: %v", err)
+			}
+			if len(imported) != 2 {
+				t.Fatalf("imported %+v", imported)
+			}
+			for i := range entries {
+				want, got := entries[i], imported[i]
+				if got.Item.Username != want.Item.Username || got.Score != want.Score || !got.Item.EnqueuedAt.Equal(want.Item.EnqueuedAt) || got.Item.Origin() != want.Item.Origin() {
+					t.Errorf("entry %d imported as %+v, want %+v", i, got, want)
+				}
+			}
+
+			// nothing is imported if an entry is invalid
+			invalid := []QueueEntry{{Item: NewQueueItem("carol", 0, entries[0].Item.EnqueuedAt, nil), Score: 1}, {Item: NewQueueItem("dave", 0, entries[0].Item.EnqueuedAt, nil), Score: math.NaN()}}
+			if _, err := dst.ImportQueue(ctx, invalid); !errors.Is(err, errInvalidScore) {
+				t.Errorf("ImportQueue of a NaN score: %v, want errInvalidScore", err)
+			}
+			if users := nextUsers(t, dst); len(users) != 2 {
+				t.Errorf("queue after a rejected import: %+v", users)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewExternalQueue creates a queue in the namespace of the Redis server at addr.
+// Unlike the embedded miniredis, the server keeps the data across restarts and
+// can be shared by several replicas.
+func NewExternalQueue(namespace, addr, password string, logger *slog.Logger) (*InMemoryQueue, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+	})
+
+	// Verify connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to ping Redis at %s: %w", addr, err)
+	}
+
+	return &InMemoryQueue{
+		client: client,
+		ns:     namespace,
+		logger: logger,
+	}, nil
+}
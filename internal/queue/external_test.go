@@ -0,0 +1,155 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// TestExternalQueueKeepsDataAcrossRestarts verifies that a queue connected to an
+// external server finds the users and states of a previous process
+func TestExternalQueueKeepsDataAcrossRestarts(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	q, err := NewExternalQueue("testexternal", mr.Addr(), "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.SetReplicationState(ctx, "user-a", "state-a"); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("failed to close queue: %v", err)
+	}
+
+	q, err = NewExternalQueue("testexternal", mr.Addr(), "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+	if state, err := q.GetReplicationState(ctx, "user-a"); err != nil || state != "state-a" {
+		t.Fatalf("expected the stored state, got %q (err %v)", state, err)
+	}
+	if user, err := q.Dequeue(ctx); err != nil || user.Username != "user-a" {
+		t.Fatalf("expected user-a to be still queued, got %q (err %v)", user.Username, err)
+	}
+}
+
+func TestExternalQueueUnreachable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+	if _, err := NewExternalQueue("testexternal", addr, "", testLogger()); err == nil {
+		t.Fatal("expected an error for an unreachable server")
+	}
+}
+
+func TestExternalQueueAuthentication(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.RequireAuth("secret")
+
+	for _, password := range []string{"", "wrong"} {
+		q, err := NewExternalQueue("testexternal", mr.Addr(), password, testLogger())
+		if err == nil {
+			_ = q.Close()
+			t.Fatalf("expected password %q to be rejected", password)
+		}
+		if !strings.Contains(err.Error(), mr.Addr()) {
+			t.Errorf("expected the error to name the server, got %v", err)
+		}
+	}
+
+	q, err := NewExternalQueue("testexternal", mr.Addr(), "secret", testLogger())
+	if err != nil {
+		t.Fatalf("failed to connect with the password: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+	if err := q.Enqueue(context.Background(), "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+}
+
+// TestExternalQueueServerOutage verifies that operations fail while the server
+// is down and succeed again once it is back, without a new queue
+func TestExternalQueueServerOutage(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	q, err := NewExternalQueue("testexternal", mr.Addr(), "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+
+	addr := mr.Addr()
+	mr.Close()
+	if err := q.HealthCheck(ctx); err == nil {
+		t.Fatal("expected the health check to fail while the server is down")
+	}
+	if err := q.Enqueue(ctx, "user-a", 1.0); err == nil {
+		t.Fatal("expected enqueue to fail while the server is down")
+	}
+	// a missing state would make the next sync a full sync
+	if _, err := q.GetReplicationState(ctx, "user-a"); err == nil {
+		t.Fatal("expected reading the state to fail while the server is down")
+	}
+	if _, err := q.DequeueN(ctx, 1); err == nil {
+		t.Fatal("expected dequeue to fail while the server is down")
+	}
+
+	if err := mr.StartAddr(addr); err != nil {
+		t.Fatalf("failed to restart server: %v", err)
+	}
+	// go-redis retries failed dials in the background, so it may take a moment
+	deadline := time.Now().Add(5 * time.Second)
+	for q.HealthCheck(ctx) != nil && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := q.HealthCheck(ctx); err != nil {
+		t.Fatalf("expected the queue to reconnect, got %v", err)
+	}
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue after reconnecting: %v", err)
+	}
+}
+
+// TestExternalQueueCloseKeepsServer verifies that closing the queue leaves the
+// server and its data alone, unlike the embedded server
+func TestExternalQueueCloseKeepsServer(t *testing.T) {
+	mr := miniredis.RunT(t)
+	q, err := NewExternalQueue("testexternal", mr.Addr(), "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	if !q.Durable() {
+		t.Fatal("expected the external queue to be durable")
+	}
+	if err := q.Enqueue(context.Background(), "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("failed to close queue: %v", err)
+	}
+	if members, err := mr.ZMembers("testexternal:" + SYNC_TASKS); err != nil || len(members) != 1 {
+		t.Fatalf("expected the queued user to be kept, got %v (err %v)", members, err)
+	}
+
+	embedded, err := NewInMemoryQueue("testexternal", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = embedded.Close() }()
+	if embedded.Durable() {
+		t.Fatal("expected the embedded queue not to be durable")
+	}
+}
@@ -19,12 +19,12 @@ type FuzzingHandler struct {
 	usersProcessed *sync.Map
 }
 
-func (h *FuzzingHandler) Handle(ctx context.Context, username string) error {
+func (h *FuzzingHandler) Handle(ctx context.Context, item QueueItem) error {
 	if rand.Intn(10) == 0 {
-		return fmt.Errorf("simulated handling error for user %v", username)
+		return fmt.Errorf("simulated handling error for user %v", item.Username)
 	}
 
-	h.usersProcessed.Store(username, struct{}{})
+	h.usersProcessed.Store(item.Username, struct{}{})
 	return nil
 }
 
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// historyTTL bounds the lifetime of the history of users that are no longer synced.
+const historyTTL = 30 * 24 * time.Hour
+
+// Results of a sync attempt recorded in the history.
+const (
+	HistoryResultSuccess = "success"
+	HistoryResultFailure = "failure"
+	HistoryResultDryRun  = "dry_run" // sync skipped in dry-run mode
+)
+
+// HistoryEntry describes a single sync attempt of a user.
+type HistoryEntry struct {
+	StartedAt       time.Time  `json:"started_at"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Result          string     `json:"result"`
+	FullSync        bool       `json:"full_sync"`
+	Initial         bool       `json:"initial,omitempty"`     // first sync of a user that was never replicated
+	Destination     string     `json:"destination,omitempty"` // set for syncs redirected to the fallback destination
+	Error           string     `json:"error,omitempty"`
+	Trigger         *EventInfo `json:"trigger,omitempty"` // nil if not triggered by an event, e.g. background replication
+	Origin          Origin     `json:"origin,omitempty"`  // why the user was queued, empty for entries recorded before origins
+}
+
+// AppendHistory records a sync attempt of a user, keeping only the most recent
+// limit entries.
+func (q *InMemoryQueue) AppendHistory(ctx context.Context, username string, entry HistoryEntry, limit int) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %w", err)
+	}
+	key := fmt.Sprintf("%s:history:%s", q.ns, username)
+	pipe := q.client.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, int64(limit)-1)
+	pipe.Expire(ctx, key, historyTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to append history: %w", err)
+	}
+	return nil
+}
+
+// GetHistory returns the recorded sync attempts of a user, most recent first.
+// Malformed entries are skipped.
+func (q *InMemoryQueue) GetHistory(ctx context.Context, username string) ([]HistoryEntry, error) {
+	key := fmt.Sprintf("%s:history:%s", q.ns, username)
+	values, err := q.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history: %w", err)
+	}
+	history := make([]HistoryEntry, 0, len(values))
+	for _, value := range values {
+		var entry HistoryEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			q.logger.Warn("ignoring malformed history entry", "username", username, "error", err)
+			continue
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// QueueItemVersion is the version of the JSON encoding of QueueItem. Decoders
+// accept all versions up to it.
+const QueueItemVersion = 1
+
+// QueueItem is a queued user together with the context it was queued with, as
+// dequeued, handed to the handler and encoded where queue entries leave the
+// process, e.g. on the mirror stream. The queues themselves still hold bare
+// usernames, which coalesces the events of a user into one entry; the context
+// is kept next to it as event info and taken with the claim, and the priority
+// is derived from the score.
+type QueueItem struct {
+	Version    int        `json:"v"`
+	Username   string     `json:"user"`
+	Priority   float64    `json:"priority,omitempty"`   // priority factor; 0 if unknown
+	EnqueuedAt time.Time  `json:"enqueued_at,omitzero"` // first enqueue; zero if unknown
+	Info       *EventInfo `json:"info,omitempty"`
+}
+
+// NewQueueItem returns the item of a user queued with priority and info,
+// which may be nil.
+func NewQueueItem(username string, priority float64, enqueuedAt time.Time, info *EventInfo) QueueItem {
+	return QueueItem{
+		Version:    QueueItemVersion,
+		Username:   username,
+		Priority:   priority,
+		EnqueuedAt: enqueuedAt,
+		Info:       info,
+	}
+}
+
+// Origin returns the most significant origin the user was queued with.
+func (it QueueItem) Origin() Origin {
+	return originOf(it.Info)
+}
+
+// priorityFromScore derives the priority factor of a user first queued at
+// enqueuedAt from its score, the enqueue time divided by the factor. Returns 0
+// if unknown, e.g. for overdue users moved to the head of the queue.
+func priorityFromScore(score float64, enqueuedAt time.Time) float64 {
+	if score <= 0 || enqueuedAt.IsZero() {
+		return 0
+	}
+	return math.Round(float64(enqueuedAt.Unix())/score*1e6) / 1e6
+}
+
+// priorityFactor returns the priority factor to queue the item with, 1.0 if
+// unknown.
+func (it QueueItem) priorityFactor() float64 {
+	if it.Priority == 0 {
+		return 1.0
+	}
+	return it.Priority
+}
+
+// Mailboxes returns the mailboxes of the events of the user.
+func (it QueueItem) Mailboxes() []string {
+	if it.Info == nil {
+		return nil
+	}
+	return it.Info.Mailboxes
+}
+
+// Attempts returns the number of failed syncs the user was requeued after.
+func (it QueueItem) Attempts() int {
+	if it.Info == nil {
+		return 0
+	}
+	return it.Info.Attempts
+}
+
+// TraceID returns the correlation ID of the first event of the user, or ""
+// if it was queued without one.
+func (it QueueItem) TraceID() string {
+	if it.Info == nil || len(it.Info.CorrelationIDs) == 0 {
+		return ""
+	}
+	return it.Info.CorrelationIDs[0]
+}
+
+// MarshalJSON encodes the item with the current version.
+func (it QueueItem) MarshalJSON() ([]byte, error) {
+	type plain QueueItem
+	it.Version = QueueItemVersion
+	return json.Marshal(plain(it))
+}
+
+// UnmarshalJSON decodes an item of any version up to QueueItemVersion, or a
+// legacy entry that is a bare username string.
+func (it *QueueItem) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '"' {
+		var username string
+		if err := json.Unmarshal(data, &username); err != nil {
+			return err
+		}
+		if username == "" {
+			return errors.New("queue item without user")
+		}
+		*it = QueueItem{Username: username}
+		return nil
+	}
+	type plain QueueItem
+	var decoded plain
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	if decoded.Version > QueueItemVersion {
+		return fmt.Errorf("queue item version %d is newer than the supported version %d", decoded.Version, QueueItemVersion)
+	}
+	if decoded.Username == "" {
+		return errors.New("queue item without user")
+	}
+	*it = QueueItem(decoded)
+	return nil
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueItemJSON(t *testing.T) {
+	enqueuedAt := time.Unix(1700000000, 0).UTC()
+	item := NewQueueItem("alice", 2, enqueuedAt, &EventInfo{
+		Mailboxes:      []string{"INBOX"},
+		CorrelationIDs: []string{"id-1", "id-2"},
+		Origin:         OriginEvent,
+		Attempts:       1,
+	})
+	data, err := json.Marshal(item)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var decoded QueueItem
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal %s: %v", data, err)
+	}
+	if decoded.Version != QueueItemVersion || decoded.Username != "alice" || decoded.Priority != 2 || !decoded.EnqueuedAt.Equal(enqueuedAt) {
+		t.Errorf("decoded %+v from %s", decoded, data)
+	}
+	if decoded.Origin() != OriginEvent || len(decoded.Mailboxes()) != 1 || decoded.Attempts() != 1 || decoded.TraceID() != "id-1" {
+		t.Errorf("decoded context %+v from %s", decoded.Info, data)
+	}
+
+	// legacy entries are bare usernames
+	var legacy QueueItem
+	if err := json.Unmarshal([]byte(`"bob"`), &legacy); err != nil || legacy.Username != "bob" || legacy.Origin() != OriginUnknown {
+		t.Errorf("legacy entry decoded as %+v, %v", legacy, err)
+	}
+
+	for _, data := range []string{`{"v":2,"user":"carol"}`, `{"v":1}`, `""`, `42`} {
+		if err := json.Unmarshal([]byte(data), &QueueItem{}); err == nil {
+			t.Errorf("decoded %s without error", data)
+		}
+	}
+}
+
+func TestRetriesCountAttempts(t *testing.T) {
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	ctx := context.Background()
+	pool := NewWorkerPool(q, 1, testLogger())
+	attempts := make(chan QueueItem, 3)
+	pool.SetHandler(EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+		attempts <- item
+		if len(attempts) < 3 {
+			return errors.New("sync failed")
+		}
+		return nil
+	}))
+	if err := q.EnqueueEvent(ctx, "alice", 3.0, &EventInfo{Event: "imap_command_finished", Origin: OriginEvent}); err != nil {
+		t.Fatalf("EnqueueEvent: %v", err)
+	}
+	pool.Start(ctx)
+	defer func() { _ = pool.Stop(ctx) }()
+
+	for want := 0; want < 3; want++ {
+		select {
+		case item := <-attempts:
+			if item.Attempts() != want || item.Priority != 3.0 {
+				t.Errorf("sync %d saw %d attempts at priority %v, want %d at priority 3", want+1, item.Attempts(), item.Priority, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("sync %d not run", want+1)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+)
+
+// largeAccountRetry is the delay of the sync of a large account while all
+// slots of the lane are taken.
+const largeAccountRetry = 30 * time.Second
+
+// LargeAccountLane confines the syncs of very large accounts to a few
+// concurrent syncs, so that they cannot occupy all workers while small
+// accounts wait. Account sizes are looked up with doveadm mailbox status.
+type LargeAccountLane struct {
+	MinBytes    int64         // accounts of at least this size are large; 0 ignores the size
+	MinMessages int64         // accounts with at least this many messages are large; 0 ignores the count
+	Concurrency int           // concurrent syncs of large accounts
+	SizeTTL     time.Duration // how long the size of an account is cached
+}
+
+// large reports whether an account of the given size is large.
+func (l LargeAccountLane) large(size doveadm.AccountSize) bool {
+	return (l.MinBytes > 0 && size.Bytes >= l.MinBytes) || (l.MinMessages > 0 && size.Messages >= l.MinMessages)
+}
+
+type cachedAccountSize struct {
+	size    doveadm.AccountSize
+	expires time.Time
+}
+
+// largeAccounts is the state of the large account lane of a handler.
+type largeAccounts struct {
+	lane  LargeAccountLane
+	slots chan struct{}
+
+	mu        sync.Mutex
+	sizes     map[string]cachedAccountSize
+	lastSweep time.Time
+}
+
+// SetLargeAccountLane routes the syncs of large accounts through a lane of
+// lane.Concurrency slots. Large accounts find a free slot or are deferred by
+// 30 seconds, which frees their worker for other users. Must be called
+// before the handler is used concurrently.
+func (h *DoveadmEventHandler) SetLargeAccountLane(lane LargeAccountLane) {
+	h.largeAccounts = &largeAccounts{
+		lane:      lane,
+		slots:     make(chan struct{}, max(lane.Concurrency, 1)),
+		sizes:     make(map[string]cachedAccountSize),
+		lastSweep: time.Now(),
+	}
+}
+
+// accountSize returns the cached size of an account, looking it up at client
+// once the cached size expired.
+func (l *largeAccounts) accountSize(ctx context.Context, client *doveadm.Client, username string) (doveadm.AccountSize, error) {
+	now := time.Now()
+	l.mu.Lock()
+	if cached, ok := l.sizes[username]; ok && now.Before(cached.expires) {
+		l.mu.Unlock()
+		return cached.size, nil
+	}
+	l.mu.Unlock()
+
+	size, err := client.AccountSize(ctx, username)
+	if err != nil {
+		return doveadm.AccountSize{}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sizes[username] = cachedAccountSize{size: size, expires: now.Add(l.lane.SizeTTL)}
+	if now.Sub(l.lastSweep) >= l.lane.SizeTTL {
+		l.lastSweep = now
+		for user, cached := range l.sizes {
+			if !now.Before(cached.expires) {
+				delete(l.sizes, user)
+			}
+		}
+	}
+	return size, nil
+}
+
+// enterLargeAccountLane takes a slot of the large account lane if the user's
+// account is large. It returns a postponing Result if all slots are taken.
+// The returned function releases the slot. Accounts whose size cannot be
+// looked up are synced as small ones.
+func (h *DoveadmEventHandler) enterLargeAccountLane(ctx context.Context, client *doveadm.Client, username string, logger *slog.Logger) (func(), error) {
+	l := h.largeAccounts
+	if l == nil {
+		return func() {}, nil
+	}
+	size, err := l.accountSize(ctx, client, username)
+	if err != nil {
+		logger.Warn("Failed to look up the account size, syncing as a small account", "username", username, "error", err)
+		return func() {}, nil
+	}
+	if !l.lane.large(size) {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		logger.Debug("Large account lane is full, deferring sync", "username", username, "bytes", size.Bytes, "messages", size.Messages, "delay", largeAccountRetry)
+		h.metrics.LargeAccountDeferrals.Inc()
+		return nil, &Result{Err: errors.New("all large account sync slots are taken"), RetryAfter: largeAccountRetry, Postponed: true}
+	}
+	logger.Info("Syncing large account", "username", username, "bytes", size.Bytes, "messages", size.Messages)
+	h.metrics.LargeAccountSyncs.Inc()
+	return func() {
+		h.metrics.LargeAccountSyncs.Dec()
+		<-l.slots
+	}, nil
+}
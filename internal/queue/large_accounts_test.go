@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm/doveadmtest"
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLargeAccountLane(t *testing.T) {
+	fake := doveadmtest.New("secret", nil)
+	fake.SetConfig(doveadmtest.Config{
+		AccountSizes: map[string]int{"big-1": 4 << 20, "big-2": 8 << 20, "small": 1 << 10},
+		Latency:      doveadmtest.Duration(200 * time.Millisecond),
+	})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.Background()
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), NewNativeQueue(testLogger()), m)
+	h.SetLargeAccountLane(LargeAccountLane{MinBytes: 1 << 20, Concurrency: 1, SizeTTL: time.Hour})
+
+	done := make(chan error, 1)
+	go func() { done <- h.Handle(ctx, QueueItem{Username: "big-1"}) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(m.LargeAccountSyncs) != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the lane is taken by big-1, small accounts bypass it
+	err := h.Handle(ctx, QueueItem{Username: "big-2"})
+	if result := ResultOf(err); !result.Postponed || result.RetryAfter != largeAccountRetry {
+		t.Fatalf("expected big-2 to be postponed, got %v", err)
+	}
+	if err := h.Handle(ctx, QueueItem{Username: "small"}); err != nil {
+		t.Fatalf("expected small to be synced, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("expected big-1 to be synced, got %v", err)
+	}
+	if err := h.Handle(ctx, QueueItem{Username: "big-2"}); err != nil {
+		t.Fatalf("expected big-2 to be synced once the lane is free, got %v", err)
+	}
+
+	if got := testutil.ToFloat64(m.LargeAccountDeferrals); got != 1 {
+		t.Errorf("deferrals = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.LargeAccountSyncs); got != 0 {
+		t.Errorf("large account syncs = %v, want 0", got)
+	}
+	if got := fake.Stats().Syncs; got["big-1"] != 1 || got["big-2"] != 1 || got["small"] != 1 {
+		t.Errorf("unexpected syncs %v", got)
+	}
+}
@@ -126,3 +126,41 @@ func TestLastReplicationTimeMultipleUsers(t *testing.T) {
 		t.Errorf("user-b: expected time %v, got %v", time2.Unix(), retrieved2.Unix())
 	}
 }
+
+// TestListLastReplicationTimes verifies that all stored timestamps are returned
+func TestListLastReplicationTimes(t *testing.T) {
+	q, err := NewInMemoryQueue("test-list-last-replication", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	want := map[string]time.Time{
+		"user-a@example.com": now.Add(-time.Hour),
+		"user-b@example.com": now,
+	}
+	for username, ts := range want {
+		if err := q.SetLastReplicationTime(ctx, username, ts); err != nil {
+			t.Fatalf("set last replication time: %v", err)
+		}
+	}
+
+	got, err := q.ListLastReplicationTimes(ctx)
+	if err != nil {
+		t.Fatalf("list last replication times: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(got))
+	}
+	for username, ts := range want {
+		if !got[username].Equal(ts) {
+			t.Errorf("user %s: expected %v, got %v", username, ts, got[username])
+		}
+	}
+}
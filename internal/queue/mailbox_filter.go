@@ -0,0 +1,95 @@
+package queue
+
+import "strings"
+
+// MailboxFilter selects the mailboxes replicated by event-triggered syncs with
+// include and exclude globs. In globs, * matches any characters and % any
+// characters except the hierarchy separator /, like the masks of dsync -x.
+// Excludes may also be special-use flags such as \Junk, which only dsync
+// resolves; they do not affect includes.
+type MailboxFilter struct {
+	include []string
+	exclude []string
+}
+
+// NewMailboxFilter creates a filter replicating the mailboxes matching any of
+// the include globs, or all mailboxes if there are none, except those matching
+// any of the exclude globs. It returns nil if both lists are empty.
+func NewMailboxFilter(include, exclude []string) *MailboxFilter {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+	return &MailboxFilter{include: include, exclude: exclude}
+}
+
+// NeedsMailboxes reports whether Excludes requires the user's mailbox list,
+// which is the case if includes are configured, as dsync cannot include
+// several mailboxes.
+func (f *MailboxFilter) NeedsMailboxes() bool {
+	return len(f.include) > 0
+}
+
+// Allows reports whether the mailbox is replicated.
+func (f *MailboxFilter) Allows(mailbox string) bool {
+	return !matchesAnyGlob(f.exclude, mailbox) && (len(f.include) == 0 || matchesAnyGlob(f.include, mailbox))
+}
+
+// Excludes returns the dsync -x arguments: the exclude globs and, if includes
+// are configured, every listed mailbox that is neither included nor covered by
+// an exclude glob already.
+func (f *MailboxFilter) Excludes(mailboxes []string) []string {
+	excludes := append([]string(nil), f.exclude...)
+	if len(f.include) == 0 {
+		return excludes
+	}
+	for _, mailbox := range mailboxes {
+		if !matchesAnyGlob(f.include, mailbox) && !matchesAnyGlob(f.exclude, mailbox) {
+			excludes = append(excludes, mailbox)
+		}
+	}
+	return excludes
+}
+
+// matchesAnyGlob reports whether the mailbox matches any of the globs. INBOX
+// is matched case-insensitively, like in IMAP.
+func matchesAnyGlob(globs []string, mailbox string) bool {
+	if strings.EqualFold(mailbox, "INBOX") {
+		mailbox = "INBOX"
+	}
+	for _, glob := range globs {
+		if strings.EqualFold(glob, "INBOX") {
+			glob = "INBOX"
+		}
+		if matchMailboxGlob(glob, mailbox) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMailboxGlob matches a mailbox name against a glob with the * and %
+// wildcards. Special-use flags never match, as names cannot start with \.
+func matchMailboxGlob(glob, mailbox string) bool {
+	for len(glob) > 0 {
+		switch glob[0] {
+		case '*', '%':
+			wildcard := glob[0]
+			glob = glob[1:]
+			for i := 0; i <= len(mailbox); i++ {
+				if matchMailboxGlob(glob, mailbox[i:]) {
+					return true
+				}
+				if i < len(mailbox) && wildcard == '%' && mailbox[i] == '/' {
+					return false
+				}
+			}
+			return false
+		default:
+			if len(mailbox) == 0 || glob[0] != mailbox[0] {
+				return false
+			}
+			glob, mailbox = glob[1:], mailbox[1:]
+		}
+	}
+	return len(mailbox) == 0
+}
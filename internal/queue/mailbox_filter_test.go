@@ -0,0 +1,115 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm/doveadmtest"
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMatchMailboxGlob(t *testing.T) {
+	for _, tc := range []struct {
+		glob, mailbox string
+		want          bool
+	}{
+		{"Junk", "Junk", true},
+		{"Junk", "Junk/Old", false},
+		{"Archive*", "Archive", true},
+		{"Archive/*", "Archive/2024/01", true},
+		{"Archive/%", "Archive/2024", true},
+		{"Archive/%", "Archive/2024/01", false},
+		{"*/Spam", "Lists/Spam", true},
+		{"%", "Sent", true},
+		{"%", "Lists/Go", false},
+		{`\Junk`, "Junk", false},
+	} {
+		if got := matchMailboxGlob(tc.glob, tc.mailbox); got != tc.want {
+			t.Errorf("matchMailboxGlob(%q, %q) = %v, want %v", tc.glob, tc.mailbox, got, tc.want)
+		}
+	}
+}
+
+func TestMailboxFilter(t *testing.T) {
+	if NewMailboxFilter(nil, nil) != nil {
+		t.Fatal("expected no filter without globs")
+	}
+
+	f := NewMailboxFilter([]string{"inbox", "Sent", "Projects/*"}, []string{`\Junk`, "Projects/Old*"})
+	for mailbox, want := range map[string]bool{
+		"INBOX":          true,
+		"Sent":           true,
+		"Projects/Go":    true,
+		"Projects/Old23": false,
+		"Trash":          false,
+	} {
+		if got := f.Allows(mailbox); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", mailbox, got, want)
+		}
+	}
+
+	got := f.Excludes([]string{"INBOX", "Sent", "Trash", "Projects/Go", "Projects/Old23", "Archive"})
+	want := []string{`\Junk`, "Projects/Old*", "Trash", "Archive"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected excludes %v, got %v", want, got)
+	}
+}
+
+func TestDoveadmHandlerMailboxFilter(t *testing.T) {
+	fake := doveadmtest.New("secret", nil)
+	fake.SetConfig(doveadmtest.Config{Mailboxes: []string{"INBOX", "Sent", "Junk", "Archive"}})
+
+	// record the excluded mailboxes of every account sync
+	var (
+		mu       sync.Mutex
+		excludes [][]string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload [][]json.RawMessage
+		if err := json.Unmarshal(body, &payload); err == nil && len(payload) == 1 {
+			var cmd string
+			var params struct {
+				Mailbox        string   `json:"mailbox"`
+				ExcludeMailbox []string `json:"excludeMailbox"`
+			}
+			_ = json.Unmarshal(payload[0][0], &cmd)
+			_ = json.Unmarshal(payload[0][1], &params)
+			if cmd == "sync" && params.Mailbox == "" {
+				mu.Lock()
+				excludes = append(excludes, params.ExcludeMailbox)
+				mu.Unlock()
+			}
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fake.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), q, metrics.New(prometheus.NewRegistry()))
+	h.SetMailboxFilter(NewMailboxFilter([]string{"INBOX", "Sent", "Junk"}, []string{"Junk"}), true)
+
+	ctx := context.Background()
+	trigger := &EventInfo{Event: "imap_command_finished", CmdName: "APPEND"}
+	if err := h.Handle(ctx, QueueItem{Username: "user-a", Info: trigger}); err != nil {
+		t.Fatalf("expected sync to succeed, got %v", err)
+	}
+	// background replication syncs everything
+	if err := h.Handle(context.Background(), QueueItem{Username: "user-a"}); err != nil {
+		t.Fatalf("expected sync to succeed, got %v", err)
+	}
+
+	if len(excludes) != 2 || !slices.Equal(excludes[0], []string{"Junk", "Archive"}) || len(excludes[1]) != 0 {
+		t.Fatalf("expected the event-triggered sync to exclude Junk and Archive only, got %v", excludes)
+	}
+}
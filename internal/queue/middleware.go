@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+)
+
+// EventHandlerFunc adapts a function to an EventHandler.
+type EventHandlerFunc func(ctx context.Context, item QueueItem) error
+
+// Handle calls f.
+func (f EventHandlerFunc) Handle(ctx context.Context, item QueueItem) error {
+	return f(ctx, item)
+}
+
+// Middleware wraps an EventHandler with additional behavior, e.g. logging or
+// limiting concurrency, and returns the wrapped handler.
+type Middleware func(next EventHandler) EventHandler
+
+// Chain wraps h with the middlewares. The first middleware is the outermost,
+// so it sees a job first and its result last.
+func Chain(h EventHandler, middlewares ...Middleware) EventHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// LoggingMiddleware logs the start of every job at debug level and its end
+// with the duration, at error level if the job failed. The lines carry the
+// correlation IDs of the events that queued the user.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next EventHandler) EventHandler {
+		return EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+			logger := jobLogger(ctx, logger)
+			start := time.Now()
+			logger.Debug("Handling user", "username", item.Username)
+			err := next.Handle(ctx, item)
+			if err != nil && ResultOf(err).Postponed {
+				logger.Debug("Handling user postponed", "username", item.Username, "duration", time.Since(start), "reason", err)
+			} else if err != nil {
+				logger.Error("Handling user failed", "username", item.Username, "duration", time.Since(start), "error", err)
+			} else {
+				logger.Debug("Handled user", "username", item.Username, "duration", time.Since(start))
+			}
+			return err
+		})
+	}
+}
+
+// MetricsMiddleware observes the duration of every job in HandlerDuration,
+// labeled with its result (success, failure or postponed) and origin.
+func MetricsMiddleware(m *metrics.Metrics) Middleware {
+	return func(next EventHandler) EventHandler {
+		return EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+			start := time.Now()
+			err := next.Handle(ctx, item)
+			result := "success"
+			if err != nil && ResultOf(err).Postponed {
+				result = "postponed"
+			} else if err != nil {
+				result = "failure"
+			}
+			m.HandlerDuration.WithLabelValues(result, string(item.Origin())).Observe(time.Since(start).Seconds())
+			return err
+		})
+	}
+}
+
+// UserLockMiddleware serializes the jobs of the same user, e.g. when several
+// worker pools share a handler. Jobs of different users run concurrently.
+func UserLockMiddleware() Middleware {
+	var (
+		mu    sync.Mutex
+		locks = make(map[string]*userLock)
+	)
+	return func(next EventHandler) EventHandler {
+		return EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+			mu.Lock()
+			l, ok := locks[item.Username]
+			if !ok {
+				l = &userLock{ch: make(chan struct{}, 1)}
+				locks[item.Username] = l
+			}
+			l.refs++
+			mu.Unlock()
+
+			defer func() {
+				mu.Lock()
+				l.refs--
+				if l.refs == 0 {
+					delete(locks, item.Username)
+				}
+				mu.Unlock()
+			}()
+
+			select {
+			case l.ch <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			defer func() { <-l.ch }()
+			return next.Handle(ctx, item)
+		})
+	}
+}
+
+// userLock is a per-user lock that can be abandoned when the context ends,
+// with the number of jobs holding or waiting for it.
+type userLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// RateLimitMiddleware starts at most perSecond jobs per second across all
+// workers, allowing bursts of up to burst jobs. Jobs wait for their turn, or
+// fail with the context's error if it ends first. Unlike the per-user
+// SyncRateLimit of the worker pool, this bounds the total load on doveadm.
+func RateLimitMiddleware(perSecond float64, burst int) Middleware {
+	bucket := newTokenBucket(perSecond, burst)
+	return func(next EventHandler) EventHandler {
+		return EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+			if err := bucket.wait(ctx); err != nil {
+				return err
+			}
+			return next.Handle(ctx, item)
+		})
+	}
+}
+
+// tokenBucket is a minimal token bucket rate limiter.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(max(burst, 1))
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// wait takes a token, waiting until one is available or ctx ends. A taken
+// token is not returned if ctx ends while waiting for it.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	if b.rate <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
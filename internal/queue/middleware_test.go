@@ -0,0 +1,209 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next EventHandler) EventHandler {
+			return EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+				calls = append(calls, name+" before")
+				err := next.Handle(ctx, item)
+				calls = append(calls, name+" after")
+				return err
+			})
+		}
+	}
+	h := Chain(EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+		calls = append(calls, "handler")
+		return nil
+	}), record("outer"), record("inner"))
+
+	if err := h.Handle(context.Background(), QueueItem{Username: "user-a"}); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	want := "outer before,inner before,handler,inner after,outer after"
+	if got := strings.Join(calls, ","); got != want {
+		t.Fatalf("expected calls %s, got %s", want, got)
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	m := metrics.New(prometheus.NewRegistry())
+	h := Chain(EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+		if item.Username == "user-b" {
+			return errors.New("sync failed")
+		}
+		return nil
+	}), MetricsMiddleware(m))
+
+	_ = h.Handle(context.Background(), QueueItem{Username: "user-a"})
+	_ = h.Handle(context.Background(), QueueItem{Username: "user-b"})
+	_ = h.Handle(context.Background(), QueueItem{Username: "user-b"})
+
+	if got := testutil.CollectAndCount(m.HandlerDuration); got != 2 {
+		t.Fatalf("expected success and failure series, got %d", got)
+	}
+}
+
+func TestUserLockMiddleware(t *testing.T) {
+	var running, maxRunning, others atomic.Int32
+	h := Chain(EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+		if item.Username != "user-a" {
+			others.Add(1)
+			return nil
+		}
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			cur := maxRunning.Load()
+			if n <= cur || maxRunning.CompareAndSwap(cur, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}), UserLockMiddleware())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = h.Handle(context.Background(), QueueItem{Username: "user-a"})
+		}()
+		go func() {
+			defer wg.Done()
+			_ = h.Handle(context.Background(), QueueItem{Username: "user-b"})
+		}()
+	}
+	wg.Wait()
+
+	if got := maxRunning.Load(); got != 1 {
+		t.Fatalf("expected jobs of a user to be serialized, got %d concurrent", got)
+	}
+	if got := others.Load(); got != 5 {
+		t.Fatalf("expected 5 jobs of user-b, got %d", got)
+	}
+}
+
+func TestUserLockMiddlewareContext(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := Chain(EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+		close(started)
+		<-release
+		return nil
+	}), UserLockMiddleware())
+
+	go func() { _ = h.Handle(context.Background(), QueueItem{Username: "user-a"}) }()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected waiting job to give up with its context, got %v", err)
+	}
+	close(release)
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	h := Chain(EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+		return nil
+	}), RateLimitMiddleware(50, 2))
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err != nil {
+			t.Fatalf("handle: %v", err)
+		}
+	}
+	// the burst passes at once, the 2 further jobs wait 20ms each
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Fatalf("expected jobs beyond the burst to be delayed, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected limited job to fail with canceled context, got %v", err)
+	}
+}
+
+func TestDoveadmHandlerSyncHooks(t *testing.T) {
+	srv := newFakeDoveadm(t)
+	defer srv.Close()
+
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+
+	h := NewDoveadmEventHandler(srv.URL, "testpass", "imap", testLogger(), q, metrics.New(prometheus.NewRegistry()))
+	h.SetHistorySize(5)
+	var (
+		pre  []SyncInfo
+		post []error
+	)
+	h.AddPreSyncHook(func(ctx context.Context, attempt SyncInfo) error {
+		pre = append(pre, attempt)
+		switch attempt.Username {
+		case "skipped":
+			return ErrSkipSync
+		case "blocked":
+			return errors.New("user is locked elsewhere")
+		}
+		return nil
+	})
+	h.AddPostSyncHook(func(ctx context.Context, attempt SyncInfo, duration time.Duration, err error) {
+		post = append(post, err)
+	})
+
+	ctx := context.Background()
+	trigger := &EventInfo{Event: "imap_command_finished", CmdName: "APPEND"}
+	if err := h.Handle(ctx, QueueItem{Username: "user-a", Info: trigger}); err != nil {
+		t.Fatalf("expected full sync to succeed, got %v", err)
+	}
+	if len(pre) != 1 || !pre[0].FullSync || pre[0].Destination != "imap" || pre[0].Trigger == nil || pre[0].Trigger.CmdName != "APPEND" {
+		t.Fatalf("unexpected pre-sync info %+v", pre)
+	}
+	if len(post) != 1 || post[0] != nil {
+		t.Fatalf("expected post-sync hook with success, got %v", post)
+	}
+
+	// the incremental sync fails at the fake doveadm
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err == nil {
+		t.Fatal("expected incremental sync to fail")
+	}
+	if len(post) != 2 || post[1] == nil {
+		t.Fatalf("expected post-sync hook with the failure, got %v", post)
+	}
+
+	if err := h.Handle(ctx, QueueItem{Username: "skipped"}); err != nil {
+		t.Fatalf("expected skipped sync to succeed, got %v", err)
+	}
+	if history, _ := q.GetHistory(ctx, "skipped"); len(history) != 0 || len(post) != 2 {
+		t.Fatalf("expected skipped sync not to be recorded, got %+v and %v", history, post)
+	}
+
+	if err := h.Handle(ctx, QueueItem{Username: "blocked"}); err == nil || !strings.Contains(err.Error(), "locked elsewhere") {
+		t.Fatalf("expected pre-sync hook error, got %v", err)
+	}
+	if len(post) != 3 || post[2] == nil {
+		t.Fatalf("expected post-sync hook with the hook error, got %v", post)
+	}
+	if history, _ := q.GetHistory(ctx, "blocked"); len(history) != 1 || history[0].Result != HistoryResultFailure {
+		t.Fatalf("expected aborted sync to be recorded as failure, got %+v", history)
+	}
+}
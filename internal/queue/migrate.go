@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MigrationResult summarizes a namespace migration run.
+type MigrationResult struct {
+	Moved     int      // keys renamed into the target namespace
+	Conflicts []string // source keys left in place because the target key already exists
+}
+
+// renameKeyScript renames KEYS[1] to KEYS[2] unless KEYS[2] exists. Returns 1
+// if the key was renamed, 0 if the target exists and -1 if the source vanished,
+// e.g. because it was dequeued or expired since it was scanned.
+var renameKeyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+return redis.call('RENAMENX', KEYS[1], KEYS[2])
+`)
+
+// MigrateNamespace moves all keys from one namespace prefix to another.
+// Each key is moved with RENAMENX, which is atomic per key and preserves TTLs,
+// so an item is never visible in both namespaces at the same time and cannot be
+// processed twice. Keys whose target already exists are left untouched and
+// reported as conflicts. With dryRun set, keys are only counted.
+func MigrateNamespace(ctx context.Context, client redis.UniversalClient, from, to string, dryRun bool, logger *slog.Logger) (*MigrationResult, error) {
+	if from == "" || to == "" {
+		return nil, fmt.Errorf("source and target namespace must not be empty")
+	}
+	if from == to {
+		return nil, fmt.Errorf("source and target namespace are identical: %s", from)
+	}
+	if strings.HasPrefix(to, from+":") || strings.HasPrefix(from, to+":") {
+		// renamed keys would match the scanned pattern again
+		return nil, fmt.Errorf("source and target namespace must not be nested: %s, %s", from, to)
+	}
+
+	srcPrefix := from + ":"
+	result := &MigrationResult{}
+
+	iter := client.Scan(ctx, 0, srcPrefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		srcKey := iter.Val()
+		dstKey := to + ":" + strings.TrimPrefix(srcKey, srcPrefix)
+
+		if dryRun {
+			logger.Debug("would migrate key", "from", srcKey, "to", dstKey)
+			result.Moved++
+			continue
+		}
+
+		renamed, err := renameKeyScript.Run(ctx, client, []string{srcKey, dstKey}).Int()
+		if err != nil {
+			return result, fmt.Errorf("failed to migrate key %s: %w", srcKey, err)
+		}
+		if renamed < 0 {
+			continue
+		}
+		if renamed == 0 {
+			logger.Warn("target key already exists, skipping", "from", srcKey, "to", dstKey)
+			result.Conflicts = append(result.Conflicts, srcKey)
+			continue
+		}
+		logger.Debug("migrated key", "from", srcKey, "to", dstKey)
+		result.Moved++
+	}
+	if err := iter.Err(); err != nil {
+		return result, fmt.Errorf("failed to scan namespace %s: %w", from, err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMigrateNamespace(t *testing.T) {
+	q, err := NewInMemoryQueue("oldns", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.SetReplicationState(ctx, "user-a", "state-a"); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+	if err := q.SetLastReplicationTime(ctx, "user-a", time.Now()); err != nil {
+		t.Fatalf("set last replication: %v", err)
+	}
+
+	res, err := MigrateNamespace(ctx, q.client, "oldns", "newns", false, testLogger())
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	// sync_tasks, enqueued_at, state, state_checksum and last_replication
+	if res.Moved != 5 || len(res.Conflicts) != 0 {
+		t.Fatalf("expected 5 moved keys and no conflicts, got %+v", res)
+	}
+
+	// Old namespace must be empty, new namespace must hold everything
+	if n, _ := q.client.Keys(ctx, "oldns:*").Result(); len(n) != 0 {
+		t.Fatalf("expected old namespace to be empty, got %v", n)
+	}
+	q.ns = "newns"
+	state, err := q.GetReplicationState(ctx, "user-a")
+	if err != nil || state != "state-a" {
+		t.Fatalf("expected migrated state, got %q (err %v)", state, err)
+	}
+	if ttl := q.client.TTL(ctx, "newns:state:user-a").Val(); ttl <= 0 {
+		t.Fatalf("expected TTL to be preserved, got %v", ttl)
+	}
+	user, err := q.Dequeue(ctx)
+	if err != nil || user.Username != "user-a" {
+		t.Fatalf("expected user-a in migrated queue, got %q (err %v)", user.Username, err)
+	}
+}
+
+func TestMigrateNamespaceConflict(t *testing.T) {
+	q, err := NewInMemoryQueue("oldns", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	if err := q.SetReplicationState(ctx, "user-a", "old"); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+	if err := q.client.Set(ctx, "newns:state:user-a", "new", 0).Err(); err != nil {
+		t.Fatalf("seed target: %v", err)
+	}
+
+	res, err := MigrateNamespace(ctx, q.client, "oldns", "newns", false, testLogger())
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	// the state checksum has no counterpart in the target and is moved
+	if res.Moved != 1 || len(res.Conflicts) != 1 {
+		t.Fatalf("expected one moved key and one conflict, got %+v", res)
+	}
+	if v := q.client.Get(ctx, "newns:state:user-a").Val(); v != "new" {
+		t.Fatalf("target key must not be overwritten, got %q", v)
+	}
+}
+
+func TestMigrateNamespaceDryRun(t *testing.T) {
+	q, err := NewInMemoryQueue("oldns", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	res, err := MigrateNamespace(ctx, q.client, "oldns", "newns", true, testLogger())
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if res.Moved != 2 {
+		t.Fatalf("expected 2 keys counted, got %+v", res)
+	}
+	if n, _ := q.client.Keys(ctx, "newns:*").Result(); len(n) != 0 {
+		t.Fatalf("dry run must not move keys, got %v", n)
+	}
+}
+
+func TestMigrateNamespaceRejectsNested(t *testing.T) {
+	q, err := NewInMemoryQueue("oldns", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	for _, ns := range [][2]string{{"oldns", "oldns:new"}, {"oldns:old", "oldns"}} {
+		if _, err := MigrateNamespace(context.Background(), q.client, ns[0], ns[1], false, testLogger()); err == nil {
+			t.Fatalf("expected migrating %s to %s to be rejected", ns[0], ns[1])
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+)
+
+// Types of the messages a primary streams to its standbys.
+const (
+	MirrorReset   = "reset"   // opens a stream, followed by the snapshot; standbys drop their backlog
+	MirrorEnqueue = "enqueue" // a user was queued
+	MirrorDequeue = "dequeue" // a user was handed to a worker
+)
+
+// MirrorMessage is a change of the queue of a primary, streamed to standbys.
+// Dequeues carry the item as claimed, without priority.
+type MirrorMessage struct {
+	Type string     `json:"type"`
+	Item *QueueItem `json:"item,omitempty"`
+}
+
+// mirrorBuffer is the number of messages a subscriber may fall behind before
+// it is dropped.
+const mirrorBuffer = 4096
+
+// mirrorSnapshotSize bounds the queued users sent to a new subscriber.
+const mirrorSnapshotSize = 100000
+
+// MirroredQueue wraps a queue and streams its enqueues and dequeues to
+// subscribed standbys, see Standby.
+type MirroredQueue struct {
+	Queue
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+
+	mu          sync.Mutex
+	subscribers map[chan MirrorMessage]struct{}
+}
+
+// NewMirroredQueue wraps q so that its changes can be subscribed to.
+func NewMirroredQueue(q Queue, m *metrics.Metrics, logger *slog.Logger) *MirroredQueue {
+	return &MirroredQueue{
+		Queue:       q,
+		metrics:     m,
+		logger:      logger,
+		subscribers: make(map[chan MirrorMessage]struct{}),
+	}
+}
+
+// Enqueue queues the user and streams the enqueue to the subscribers.
+func (m *MirroredQueue) Enqueue(ctx context.Context, username string, priorityFactor float64) error {
+	return m.EnqueueEvent(ctx, username, priorityFactor, nil)
+}
+
+// EnqueueEvent queues the user with info and streams the enqueue to the
+// subscribers.
+func (m *MirroredQueue) EnqueueEvent(ctx context.Context, username string, priorityFactor float64, info *EventInfo) error {
+	if err := m.Queue.EnqueueEvent(ctx, username, priorityFactor, info); err != nil {
+		return err
+	}
+	item := NewQueueItem(username, priorityFactor, time.Time{}, info)
+	m.publish(MirrorMessage{Type: MirrorEnqueue, Item: &item})
+	return nil
+}
+
+// ImportQueue imports the entries and streams them as enqueues to the
+// subscribers.
+func (m *MirroredQueue) ImportQueue(ctx context.Context, entries []QueueEntry) (int, error) {
+	n, err := m.Queue.ImportQueue(ctx, entries)
+	if err != nil {
+		return n, err
+	}
+	for i := range entries {
+		m.publish(MirrorMessage{Type: MirrorEnqueue, Item: &entries[i].Item})
+	}
+	return n, nil
+}
+
+// EnqueueItem queues the user of item and streams the enqueue to the
+// subscribers.
+func (m *MirroredQueue) EnqueueItem(ctx context.Context, item QueueItem) error {
+	return m.EnqueueEvent(ctx, item.Username, item.priorityFactor(), item.Info)
+}
+
+// Dequeue claims the next user and streams the dequeue to the subscribers.
+func (m *MirroredQueue) Dequeue(ctx context.Context) (QueueItem, error) {
+	items, err := m.DequeueN(ctx, 1)
+	if err != nil || len(items) == 0 {
+		return QueueItem{}, err
+	}
+	return items[0], nil
+}
+
+// DequeueN claims up to n users and streams the dequeues to the subscribers.
+func (m *MirroredQueue) DequeueN(ctx context.Context, n int) ([]QueueItem, error) {
+	items, err := m.Queue.DequeueN(ctx, n)
+	for i := range items {
+		m.publish(MirrorMessage{Type: MirrorDequeue, Item: &items[i]})
+	}
+	return items, err
+}
+
+// NotifyEnqueue implements EnqueueNotifier if the wrapped queue does.
+func (m *MirroredQueue) NotifyEnqueue(fn func()) {
+	if n, ok := m.Queue.(EnqueueNotifier); ok {
+		n.NotifyEnqueue(fn)
+	}
+}
+
+// NotifyDequeue implements DequeueObserver if the wrapped queue does.
+func (m *MirroredQueue) NotifyDequeue(fn func(users []DequeuedUser)) {
+	if o, ok := m.Queue.(DequeueObserver); ok {
+		o.NotifyDequeue(fn)
+	}
+}
+
+// SetPriorityBounds implements PriorityLimiter if the wrapped queue does.
+func (m *MirroredQueue) SetPriorityBounds(b *PriorityBounds) {
+	if l, ok := m.Queue.(PriorityLimiter); ok {
+		l.SetPriorityBounds(b)
+	}
+}
+
+// Durable implements DurableQueue if the wrapped queue does.
+func (m *MirroredQueue) Durable() bool {
+	d, ok := m.Queue.(DurableQueue)
+	return ok && d.Durable()
+}
+
+// Subscribe returns the users queued now and a channel receiving all changes
+// since, up to mirrorSnapshotSize users in dequeue order. The snapshot items
+// carry the first-enqueue time but neither priority nor event info. Changes
+// written just before the snapshot may be in it and arrive on the channel as
+// well, so applying them must be idempotent. A subscriber falling more than
+// mirrorBuffer messages behind is dropped by closing its channel, so that it
+// subscribes again and starts over from a new snapshot. cancel ends the
+// subscription.
+func (m *MirroredQueue) Subscribe(ctx context.Context) (snapshot []QueueItem, updates <-chan MirrorMessage, cancel func(), err error) {
+	// Holding mu, no change is lost between the snapshot and the registration:
+	// publish takes mu only after the write to the queue, so a change written
+	// meanwhile is published to the new subscriber too. A change written just
+	// before the snapshot is thus both in it and delivered, e.g. an enqueue.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, err := m.Queue.Status(ctx, mirrorSnapshotSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, user := range status.Next {
+		snapshot = append(snapshot, NewQueueItem(user.Username, 0, user.EnqueuedAt, nil))
+	}
+	ch := make(chan MirrorMessage, mirrorBuffer)
+	m.subscribers[ch] = struct{}{}
+	m.metrics.MirrorSubscribers.Set(float64(len(m.subscribers)))
+	cancel = func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.subscribers[ch]; ok {
+			delete(m.subscribers, ch)
+			close(ch)
+			m.metrics.MirrorSubscribers.Set(float64(len(m.subscribers)))
+		}
+	}
+	return snapshot, ch, cancel, nil
+}
+
+// Disconnect ends all subscriptions, e.g. on shutdown.
+func (m *MirroredQueue) Disconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ch := range m.subscribers {
+		delete(m.subscribers, ch)
+		close(ch)
+	}
+	m.metrics.MirrorSubscribers.Set(0)
+}
+
+// publish hands msg to every subscriber, dropping those that fell behind.
+func (m *MirroredQueue) publish(msg MirrorMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ch := range m.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			m.logger.Warn("Dropping standby that fell behind the mirror stream", "buffered", len(ch))
+			delete(m.subscribers, ch)
+			close(ch)
+			m.metrics.MirrorSubscribers.Set(float64(len(m.subscribers)))
+		}
+	}
+}
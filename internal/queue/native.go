@@ -0,0 +1,1304 @@
+package queue
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// nativeShards is the number of independently locked shards of a NativeQueue.
+const nativeShards = 32
+
+// nativeSweepInterval is how often expired states, timestamps, event info and
+// history are removed from a NativeQueue.
+const nativeSweepInterval = time.Minute
+
+// replicationTTL bounds the lifetime of replication states and last replication
+// times, like the Redis key TTL set by InMemoryQueue.
+const replicationTTL = 30 * 24 * time.Hour
+
+var errNativeQueueClosed = errors.New("queue closed")
+
+// NativeQueue is an in-process implementation of Queue with the same semantics
+// as InMemoryQueue, without the round trips through a miniredis server. Users
+// are spread over shards by a hash of the username, each with its own lock and
+// priority heap, so that concurrent enqueues of different users rarely contend.
+// Dequeueing picks the best head among the shards.
+type NativeQueue struct {
+	seed   maphash.Seed
+	shards [nativeShards]nativeShard
+	logger *slog.Logger
+
+	slaMu sync.Mutex
+	sla   map[int64]*slaCounters // by slot start, unix seconds
+
+	sweepMu     sync.Mutex
+	sweepCursor string
+
+	// operation counters
+	enqueueCount uint64
+	dequeueCount uint64
+
+	// optional callback after users were enqueued
+	onEnqueue atomic.Pointer[func()]
+	// optional callback after users were dequeued
+	onDequeue atomic.Pointer[func([]DequeuedUser)]
+
+	// optional bounds of priority factors
+	priorityBounds atomic.Pointer[PriorityBounds]
+
+	closed atomic.Bool
+	stopCh chan struct{}
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+// nativeShard holds the users hashed to it. The maps mirror the keys
+// InMemoryQueue stores in Redis; timestamps are unix seconds.
+type nativeShard struct {
+	mu                  sync.Mutex
+	tasks               nativeHeap
+	queued              map[string]*nativeTask
+	enqueuedAt          map[string]int64
+	deferred            map[string]int64
+	inFlight            map[string]int64
+	jobs                map[string]*EventInfo
+	failed              map[string]int64
+	incrementalFailures map[string]int64
+	states              map[string]expiring[nativeState]
+	lastReplication     map[string]expiring[int64]
+	lastEvent           map[string]expiring[int64]
+	eventInfo           map[string]expiring[map[string]string]
+	history             map[string]expiring[[][]byte]
+	redirected          map[string]struct{}
+}
+
+// nativeState is a stored replication state with its checksum.
+type nativeState struct {
+	state    string
+	checksum string
+}
+
+// expiring is a value with the time after which it is treated as absent.
+type expiring[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+func (e expiring[T]) expired(now time.Time) bool {
+	return !now.Before(e.expiresAt)
+}
+
+// nativeTask is a queued user in a shard's priority heap.
+type nativeTask struct {
+	username string
+	score    float64
+	index    int
+}
+
+// less orders tasks like a Redis sorted set: by score, then by member.
+func (t *nativeTask) less(o *nativeTask) bool {
+	if t.score != o.score {
+		return t.score < o.score
+	}
+	return t.username < o.username
+}
+
+// nativeHeap is a min-heap of tasks implementing heap.Interface.
+type nativeHeap []*nativeTask
+
+func (h nativeHeap) Len() int           { return len(h) }
+func (h nativeHeap) Less(i, j int) bool { return h[i].less(h[j]) }
+func (h nativeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *nativeHeap) Push(x any) {
+	t := x.(*nativeTask)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *nativeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return t
+}
+
+// slaCounters are the SLA counters of a time slot.
+type slaCounters struct {
+	syncs, within1m, within5m, within1h, latencyMs int64
+	expiresAt                                      time.Time
+}
+
+// counter returns the counter of an SLA target field.
+func (c *slaCounters) counter(field string) *int64 {
+	switch field {
+	case slaFieldWithin1m:
+		return &c.within1m
+	case slaFieldWithin5m:
+		return &c.within5m
+	default:
+		return &c.within1h
+	}
+}
+
+// NewNativeQueue creates a new in-process queue. Nothing is persisted, so the
+// queue is lost on restart just like with InMemoryQueue.
+func NewNativeQueue(logger *slog.Logger) *NativeQueue {
+	q := &NativeQueue{
+		seed:   maphash.MakeSeed(),
+		logger: logger,
+		sla:    make(map[int64]*slaCounters),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.queued = make(map[string]*nativeTask)
+		s.enqueuedAt = make(map[string]int64)
+		s.deferred = make(map[string]int64)
+		s.inFlight = make(map[string]int64)
+		s.jobs = make(map[string]*EventInfo)
+		s.failed = make(map[string]int64)
+		s.incrementalFailures = make(map[string]int64)
+		s.states = make(map[string]expiring[nativeState])
+		s.lastReplication = make(map[string]expiring[int64])
+		s.lastEvent = make(map[string]expiring[int64])
+		s.eventInfo = make(map[string]expiring[map[string]string])
+		s.history = make(map[string]expiring[[][]byte])
+		s.redirected = make(map[string]struct{})
+	}
+	go q.sweep()
+	return q
+}
+
+// shard returns the locked shard of a user. The caller must unlock it.
+func (q *NativeQueue) shard(username string) *nativeShard {
+	s := &q.shards[maphash.String(q.seed, username)%nativeShards]
+	s.mu.Lock()
+	return s
+}
+
+// addLT queues a user with the given score, or lowers the score of a queued
+// user if it is better, like ZADD LT. Returns whether the score changed.
+// The shard must be locked.
+func (s *nativeShard) addLT(username string, score float64) bool {
+	if t, ok := s.queued[username]; ok {
+		if score >= t.score {
+			return false
+		}
+		t.score = score
+		heap.Fix(&s.tasks, t.index)
+		return true
+	}
+	t := &nativeTask{username: username, score: score}
+	heap.Push(&s.tasks, t)
+	s.queued[username] = t
+	return true
+}
+
+// mergeEventInfo merges info, which may be nil, into the event info of a user.
+// The shard must be locked.
+func (s *nativeShard) mergeEventInfo(username string, info *EventInfo, now time.Time) {
+	if info == nil {
+		return
+	}
+	stored, ok := s.eventInfo[username]
+	if !ok || stored.expired(now) {
+		stored.value = nil
+	}
+	if merged, changed := info.mergeInto(stored.value); changed {
+		stored.value = merged
+		stored.expiresAt = now.Add(eventInfoTTL)
+		s.eventInfo[username] = stored
+	}
+	if !info.TriggeredAt.IsZero() {
+		s.lastEvent[username] = expiring[int64]{value: info.TriggeredAt.Unix(), expiresAt: now.Add(lastEventTTL)}
+	}
+}
+
+// Enqueue adds or updates a user in the priority queue, keeping the better of
+// the existing and the new score. See InMemoryQueue.Enqueue for the scoring.
+func (q *NativeQueue) Enqueue(ctx context.Context, username string, priorityFactor float64) error {
+	return q.EnqueueEvent(ctx, username, priorityFactor, nil)
+}
+
+// EnqueueEvent adds or updates a user like Enqueue and merges the event context
+// into the user's event info. info may be nil.
+func (q *NativeQueue) EnqueueEvent(ctx context.Context, username string, priorityFactor float64, info *EventInfo) error {
+	priorityFactor, err := checkPriority(q.priorityBounds.Load(), priorityFactor)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	score := float64(now.UnixNano()) / 1e9 / priorityFactor
+
+	s := q.shard(username)
+	s.mergeEventInfo(username, info, now)
+	if _, ok := s.enqueuedAt[username]; !ok {
+		s.enqueuedAt[username] = now.Unix()
+	}
+	s.addLT(username, score)
+	s.mu.Unlock()
+
+	atomic.AddUint64(&q.enqueueCount, 1)
+	if fn := q.onEnqueue.Load(); fn != nil {
+		(*fn)()
+	}
+	return nil
+}
+
+// EnqueueItem adds or updates the user of item like EnqueueEvent, with its
+// priority factor or 1.0 if unknown.
+func (q *NativeQueue) EnqueueItem(ctx context.Context, item QueueItem) error {
+	return q.EnqueueEvent(ctx, item.Username, item.priorityFactor(), item.Info)
+}
+
+// NotifyEnqueue registers fn to be called after users were enqueued, replacing
+// any function registered before.
+func (q *NativeQueue) NotifyEnqueue(fn func()) {
+	q.onEnqueue.Store(&fn)
+}
+
+// SetPriorityBounds clamps the priority factors of later enqueues to b; nil
+// only rejects factors that are not finite and positive.
+func (q *NativeQueue) SetPriorityBounds(b *PriorityBounds) {
+	q.priorityBounds.Store(b)
+}
+
+// TakeEventInfo returns and removes the event info accumulated for a user.
+// Returns nil if no info was stored.
+func (q *NativeQueue) TakeEventInfo(ctx context.Context, username string) (*EventInfo, error) {
+	s := q.shard(username)
+	stored, ok := s.eventInfo[username]
+	delete(s.eventInfo, username)
+	s.mu.Unlock()
+	if !ok || stored.expired(time.Now()) || len(stored.value) == 0 {
+		return nil, nil
+	}
+	return eventInfoFromHash(stored.value), nil
+}
+
+// Dequeue removes and returns the user with the lowest priority score and
+// claims it as in-flight until Ack is called. Returns an item without username
+// if the queue is empty.
+func (q *NativeQueue) Dequeue(ctx context.Context) (QueueItem, error) {
+	items, err := q.DequeueN(ctx, 1)
+	if err != nil {
+		return QueueItem{}, err
+	}
+	if len(items) == 0 {
+		return QueueItem{}, nil
+	}
+	return items[0], nil
+}
+
+// DequeueN removes and returns up to n users with the lowest priority scores
+// together with their event info, claiming each of them as in-flight until Ack
+// is called. Returns an empty slice if the queue is empty.
+func (q *NativeQueue) DequeueN(ctx context.Context, n int) ([]QueueItem, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	onDequeue := q.onDequeue.Load()
+	items := []QueueItem{}
+	var dequeued []DequeuedUser
+	for len(items) < n {
+		user, info, ok := q.claimHead(time.Now().Unix())
+		if !ok {
+			break
+		}
+		items = append(items, NewQueueItem(user.Username, priorityFromScore(user.Score, user.EnqueuedAt), user.EnqueuedAt, info))
+		if onDequeue != nil {
+			dequeued = append(dequeued, user)
+		}
+	}
+	atomic.AddUint64(&q.dequeueCount, uint64(len(items)))
+	if onDequeue != nil && len(dequeued) > 0 {
+		(*onDequeue)(dequeued)
+	}
+	return items, nil
+}
+
+// NotifyDequeue registers fn to be called with the users claimed by every
+// DequeueN, replacing any function registered before.
+func (q *NativeQueue) NotifyDequeue(fn func(users []DequeuedUser)) {
+	q.onDequeue.Store(&fn)
+}
+
+// head returns the best task of the shard whose user is not claimed already,
+// or nil. A user queued again while its sync runs waits for the Ack, so that
+// it is never synced twice at once; finding the next task then scans the heap.
+func (s *nativeShard) head() *nativeTask {
+	if len(s.tasks) == 0 {
+		return nil
+	}
+	if _, ok := s.inFlight[s.tasks[0].username]; !ok {
+		return s.tasks[0]
+	}
+	var best *nativeTask
+	for _, t := range s.tasks {
+		if _, ok := s.inFlight[t.username]; !ok && (best == nil || t.less(best)) {
+			best = t
+		}
+	}
+	return best
+}
+
+// claimHead pops the best unclaimed task among all shard heads, records its
+// claim and takes its event info. The heads are compared without holding all
+// locks at once, so a concurrent enqueue may overtake the chosen head; the
+// order is then only as exact as with two consumers racing for a Redis queue.
+func (q *NativeQueue) claimHead(claimedAt int64) (DequeuedUser, *EventInfo, bool) {
+	for {
+		var best *nativeShard
+		var bestTask nativeTask
+		for i := range q.shards {
+			s := &q.shards[i]
+			s.mu.Lock()
+			if t := s.head(); t != nil && (best == nil || t.less(&bestTask)) {
+				best = s
+				bestTask = *t
+			}
+			s.mu.Unlock()
+		}
+		if best == nil {
+			return DequeuedUser{}, nil, false
+		}
+
+		best.mu.Lock()
+		t := best.head()
+		if t == nil {
+			// claimed by another consumer meanwhile
+			best.mu.Unlock()
+			continue
+		}
+		heap.Remove(&best.tasks, t.index)
+		user := DequeuedUser{Username: t.username, Score: t.score}
+		if ts, ok := best.enqueuedAt[t.username]; ok {
+			user.EnqueuedAt = time.Unix(ts, 0)
+		}
+		delete(best.queued, t.username)
+		delete(best.enqueuedAt, t.username)
+		best.inFlight[t.username] = claimedAt
+		var info *EventInfo
+		if stored, ok := best.eventInfo[t.username]; ok {
+			delete(best.eventInfo, t.username)
+			if !stored.expired(time.Now()) && len(stored.value) > 0 {
+				info = eventInfoFromHash(stored.value)
+			}
+		}
+		best.mu.Unlock()
+		return user, info, true
+	}
+}
+
+// Len returns the number of queued users, excluding in-flight and deferred ones.
+func (q *NativeQueue) Len(ctx context.Context) (int64, error) {
+	var n int64
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		n += int64(len(s.tasks))
+		s.mu.Unlock()
+	}
+	return n, nil
+}
+
+// OldestEnqueuedAt returns the first-enqueue time of the user waiting the
+// longest, or the zero time if the queue is empty. It scans all queued users.
+func (q *NativeQueue) OldestEnqueuedAt(ctx context.Context) (time.Time, error) {
+	var oldest int64
+	found := false
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for _, ts := range s.enqueuedAt {
+			if !found || ts < oldest {
+				oldest = ts
+				found = true
+			}
+		}
+		s.mu.Unlock()
+	}
+	if !found {
+		return time.Time{}, nil
+	}
+	return time.Unix(oldest, 0), nil
+}
+
+// PromoteOverdue moves every user that has been waiting longer than maxAge to
+// the head of the queue, ordered by how long they have been waiting, and
+// returns the number of users promoted by this call. It scans all queued users.
+func (q *NativeQueue) PromoteOverdue(ctx context.Context, maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-maxAge).Unix()
+	promoted := 0
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for username, ts := range s.enqueuedAt {
+			if ts > cutoff {
+				continue
+			}
+			// only touch users that are still queued; never demote
+			if _, ok := s.queued[username]; ok && s.addLT(username, float64(ts)-overdueScoreOffset) {
+				promoted++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return promoted, nil
+}
+
+// Defer postpones the sync of a user until the given time. If the user is
+// already deferred, the earlier time is kept.
+func (q *NativeQueue) Defer(ctx context.Context, username string, until time.Time) error {
+	return q.DeferEvent(ctx, username, until, nil)
+}
+
+// DeferEvent defers a user like Defer and merges info into the user's event
+// info. info may be nil.
+func (q *NativeQueue) DeferEvent(ctx context.Context, username string, until time.Time, info *EventInfo) error {
+	s := q.shard(username)
+	defer s.mu.Unlock()
+	s.mergeEventInfo(username, info, time.Now())
+	if current, ok := s.deferred[username]; !ok || until.Unix() < current {
+		s.deferred[username] = until.Unix()
+	}
+	return nil
+}
+
+// PromoteDeferred moves all deferred users whose time has come into the queue
+// and returns their number.
+func (q *NativeQueue) PromoteDeferred(ctx context.Context) (int, error) {
+	now := time.Now().Unix()
+	promoted := 0
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for username, until := range s.deferred {
+			if until > now {
+				continue
+			}
+			delete(s.deferred, username)
+			s.addLT(username, float64(now))
+			if _, ok := s.enqueuedAt[username]; !ok {
+				s.enqueuedAt[username] = now
+			}
+			promoted++
+		}
+		s.mu.Unlock()
+	}
+	return promoted, nil
+}
+
+// Ack releases the in-flight claim for a user once handling finished, successfully or not.
+func (q *NativeQueue) Ack(ctx context.Context, username string) error {
+	s := q.shard(username)
+	delete(s.inFlight, username)
+	delete(s.jobs, username)
+	s.mu.Unlock()
+	return nil
+}
+
+// SaveJob stores the event info of a claimed user until Ack, see Queue.SaveJob.
+func (q *NativeQueue) SaveJob(ctx context.Context, username string, info *EventInfo) error {
+	if info == nil {
+		return nil
+	}
+	s := q.shard(username)
+	s.jobs[username] = info
+	s.mu.Unlock()
+	return nil
+}
+
+// RecoverJobs requeues the users claimed before claimedBefore with their saved
+// event info, see Queue.RecoverJobs.
+func (q *NativeQueue) RecoverJobs(ctx context.Context, claimedBefore time.Time) ([]string, error) {
+	var recovered []string
+	jobs := make(map[string]*EventInfo)
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for username, ts := range s.inFlight {
+			if ts < claimedBefore.Unix() {
+				recovered = append(recovered, username)
+				jobs[username] = s.jobs[username]
+			}
+		}
+		s.mu.Unlock()
+	}
+	for _, username := range recovered {
+		if err := q.EnqueueEvent(ctx, username, 1.0, jobs[username]); err != nil {
+			return nil, err
+		}
+		if err := q.Ack(ctx, username); err != nil {
+			return nil, err
+		}
+	}
+	return recovered, nil
+}
+
+// InFlight returns all currently claimed users with the time they were claimed.
+func (q *NativeQueue) InFlight(ctx context.Context) (map[string]time.Time, error) {
+	claims := make(map[string]time.Time)
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for username, ts := range s.inFlight {
+			claims[username] = time.Unix(ts, 0)
+		}
+		s.mu.Unlock()
+	}
+	return claims, nil
+}
+
+// Stats returns the total number of enqueue and dequeue operations.
+func (q *NativeQueue) Stats() (enqueues uint64, dequeues uint64) {
+	return atomic.LoadUint64(&q.enqueueCount), atomic.LoadUint64(&q.dequeueCount)
+}
+
+// HealthCheck reports an error once the queue is closed.
+func (q *NativeQueue) HealthCheck(ctx context.Context) error {
+	if q.closed.Load() {
+		return errNativeQueueClosed
+	}
+	return nil
+}
+
+// Close stops the removal of expired data. The queued users are kept, so
+// calls made during shutdown still succeed.
+func (q *NativeQueue) Close() error {
+	q.once.Do(func() {
+		q.closed.Store(true)
+		close(q.stopCh)
+	})
+	<-q.doneCh
+	return nil
+}
+
+// GetReplicationState retrieves the stored replication state for a user.
+// Returns empty string if no state exists. States failing the sanity checks
+// are discarded with a warning, which makes the next sync a full sync.
+func (q *NativeQueue) GetReplicationState(ctx context.Context, username string) (string, error) {
+	s := q.shard(username)
+	stored, ok := s.states[username]
+	s.mu.Unlock()
+	if !ok || stored.expired(time.Now()) {
+		q.logger.Debug("replication state not found", "username", username)
+		return "", nil
+	}
+
+	state := stored.value.state
+	validationErr := validateState(state)
+	if validationErr == nil && stored.value.checksum != stateChecksum(state) {
+		validationErr = fmt.Errorf("%w: stored %s, computed %s", errStateChecksumMismatch, stored.value.checksum, stateChecksum(state))
+	}
+	if validationErr != nil {
+		q.logger.Warn("discarding invalid replication state",
+			"username", username,
+			"state_length", len(state),
+			"reason", validationErr.Error(),
+		)
+		if err := q.DeleteReplicationState(ctx, username); err != nil {
+			q.logger.Warn("failed to delete invalid replication state", "username", username, "error", err)
+		}
+		return "", nil
+	}
+
+	q.logger.Debug("retrieved replication state", "username", username, "state", state)
+	return state, nil
+}
+
+// SetReplicationState stores the replication state for a user together with
+// its checksum. The state expires after 30 days.
+func (q *NativeQueue) SetReplicationState(ctx context.Context, username string, state string) error {
+	s := q.shard(username)
+	s.states[username] = expiring[nativeState]{
+		value:     nativeState{state: state, checksum: stateChecksum(state)},
+		expiresAt: time.Now().Add(replicationTTL),
+	}
+	s.mu.Unlock()
+	q.logger.Debug("stored replication state", "username", username, "state", state, "ttl", replicationTTL)
+	return nil
+}
+
+// DeleteReplicationState removes the stored replication state of a user,
+// forcing the next sync to be a full sync.
+func (q *NativeQueue) DeleteReplicationState(ctx context.Context, username string) error {
+	s := q.shard(username)
+	delete(s.states, username)
+	s.mu.Unlock()
+	q.logger.Debug("deleted replication state", "username", username)
+	return nil
+}
+
+// IncrIncrementalFailures increments and returns the number of consecutive failed
+// incremental syncs of a user.
+func (q *NativeQueue) IncrIncrementalFailures(ctx context.Context, username string) (int64, error) {
+	s := q.shard(username)
+	defer s.mu.Unlock()
+	s.incrementalFailures[username]++
+	return s.incrementalFailures[username], nil
+}
+
+// ClearIncrementalFailures resets the consecutive incremental failure count of a user.
+func (q *NativeQueue) ClearIncrementalFailures(ctx context.Context, username string) error {
+	s := q.shard(username)
+	delete(s.incrementalFailures, username)
+	s.mu.Unlock()
+	return nil
+}
+
+// GetLastReplicationTime retrieves the timestamp of the last replication for a user.
+// Returns zero time if no replication has been performed.
+func (q *NativeQueue) GetLastReplicationTime(ctx context.Context, username string) (time.Time, error) {
+	s := q.shard(username)
+	stored, ok := s.lastReplication[username]
+	s.mu.Unlock()
+	if !ok || stored.expired(time.Now()) {
+		return time.Time{}, nil
+	}
+	return time.Unix(stored.value, 0), nil
+}
+
+// SetLastReplicationTime stores the timestamp of the last replication for a user
+// with second precision. The timestamp expires after 30 days.
+func (q *NativeQueue) SetLastReplicationTime(ctx context.Context, username string, t time.Time) error {
+	s := q.shard(username)
+	s.lastReplication[username] = expiring[int64]{value: t.Unix(), expiresAt: time.Now().Add(replicationTTL)}
+	s.mu.Unlock()
+	return nil
+}
+
+// ListLastReplicationTimes returns the last replication timestamp of every user
+// that has one stored.
+func (q *NativeQueue) ListLastReplicationTimes(ctx context.Context) (map[string]time.Time, error) {
+	now := time.Now()
+	times := make(map[string]time.Time)
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for username, stored := range s.lastReplication {
+			if !stored.expired(now) && !strings.HasPrefix(username, fallbackKeyPrefix) {
+				times[username] = time.Unix(stored.value, 0)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return times, nil
+}
+
+// GetLastEventTime returns the time of the most recent event of a user, or
+// the zero time if none was received within the last 30 days.
+func (q *NativeQueue) GetLastEventTime(ctx context.Context, username string) (time.Time, error) {
+	s := q.shard(username)
+	stored, ok := s.lastEvent[username]
+	s.mu.Unlock()
+	if !ok || stored.expired(time.Now()) {
+		return time.Time{}, nil
+	}
+	return time.Unix(stored.value, 0), nil
+}
+
+// GetSweepCursor returns the last user processed by an interrupted background
+// replication sweep, or an empty string if no sweep was interrupted. The cursor
+// is lost on restart like all other data of the native queue.
+func (q *NativeQueue) GetSweepCursor(ctx context.Context) (string, error) {
+	q.sweepMu.Lock()
+	defer q.sweepMu.Unlock()
+	return q.sweepCursor, nil
+}
+
+// SetSweepCursor stores the last user processed by the running background
+// replication sweep. An empty cursor removes the checkpoint.
+func (q *NativeQueue) SetSweepCursor(ctx context.Context, cursor string) error {
+	q.sweepMu.Lock()
+	defer q.sweepMu.Unlock()
+	q.sweepCursor = cursor
+	return nil
+}
+
+// RecordFailure marks a user's most recent sync attempt as failed.
+func (q *NativeQueue) RecordFailure(ctx context.Context, username string) error {
+	s := q.shard(username)
+	s.failed[username] = time.Now().Unix()
+	s.mu.Unlock()
+	return nil
+}
+
+// ClearFailure removes the failure mark of a user after a successful sync.
+func (q *NativeQueue) ClearFailure(ctx context.Context, username string) error {
+	s := q.shard(username)
+	delete(s.failed, username)
+	s.mu.Unlock()
+	return nil
+}
+
+// FailedCount returns the number of users whose last sync failed.
+func (q *NativeQueue) FailedCount(ctx context.Context) (int64, error) {
+	var n int64
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		n += int64(len(s.failed))
+		s.mu.Unlock()
+	}
+	return n, nil
+}
+
+// RequeueFailed queues the dead-lettered users among usernames, or all of them
+// if usernames is empty, and returns the requeued users.
+func (q *NativeQueue) RequeueFailed(ctx context.Context, usernames []string, origin Origin) ([]string, error) {
+	nowTime := time.Now()
+	now := nowTime.Unix()
+	requeued := []string{}
+	requeue := func(s *nativeShard, username string) {
+		if _, ok := s.failed[username]; !ok {
+			return
+		}
+		if _, ok := s.queued[username]; ok {
+			return
+		}
+		if _, ok := s.deferred[username]; ok {
+			return
+		}
+		if _, ok := s.inFlight[username]; ok {
+			return
+		}
+		s.addLT(username, float64(now))
+		s.enqueuedAt[username] = now
+		if origin != "" {
+			s.mergeEventInfo(username, &EventInfo{Origin: origin}, nowTime)
+		}
+		requeued = append(requeued, username)
+	}
+	if len(usernames) > 0 {
+		for _, username := range usernames {
+			s := q.shard(username)
+			requeue(s, username)
+			s.mu.Unlock()
+		}
+	} else {
+		for i := range q.shards {
+			s := &q.shards[i]
+			s.mu.Lock()
+			for username := range s.failed {
+				requeue(s, username)
+			}
+			s.mu.Unlock()
+		}
+	}
+	if len(requeued) > 0 {
+		atomic.AddUint64(&q.enqueueCount, uint64(len(requeued)))
+		if fn := q.onEnqueue.Load(); fn != nil {
+			(*fn)()
+		}
+	}
+	return requeued, nil
+}
+
+// AppendHistory records a sync attempt of a user, keeping only the most recent
+// limit entries.
+func (q *NativeQueue) AppendHistory(ctx context.Context, username string, entry HistoryEntry, limit int) error {
+	// stored encoded, so that later changes to the entry do not leak in
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %w", err)
+	}
+	now := time.Now()
+	s := q.shard(username)
+	defer s.mu.Unlock()
+	stored := s.history[username]
+	if stored.expired(now) {
+		stored.value = nil
+	}
+	stored.value = append([][]byte{data}, stored.value...)
+	if limit > 0 && len(stored.value) > limit {
+		stored.value = stored.value[:limit]
+	}
+	stored.expiresAt = now.Add(historyTTL)
+	s.history[username] = stored
+	return nil
+}
+
+// GetHistory returns the recorded sync attempts of a user, most recent first.
+func (q *NativeQueue) GetHistory(ctx context.Context, username string) ([]HistoryEntry, error) {
+	s := q.shard(username)
+	stored, ok := s.history[username]
+	s.mu.Unlock()
+	if !ok || stored.expired(time.Now()) {
+		return []HistoryEntry{}, nil
+	}
+	history := make([]HistoryEntry, 0, len(stored.value))
+	for _, data := range stored.value {
+		var entry HistoryEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			q.logger.Warn("ignoring malformed history entry", "username", username, "error", err)
+			continue
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// DeleteUser removes every trace of a user: queue entries, in-flight claim,
+// replication states and last replication times of all destinations, failure
+// marks, redirection mark, event info and sync history.
+// Returns whether anything was stored for the user.
+func (q *NativeQueue) DeleteUser(ctx context.Context, username string) (bool, error) {
+	now := time.Now()
+	states, times := q.takeFallback(username, now)
+	found := len(states) > 0 || len(times) > 0
+
+	s := q.shard(username)
+	defer s.mu.Unlock()
+	if _, ok := s.redirected[username]; ok {
+		delete(s.redirected, username)
+		found = true
+	}
+	if t, ok := s.queued[username]; ok {
+		heap.Remove(&s.tasks, t.index)
+		delete(s.queued, username)
+		found = true
+	}
+	for _, m := range []map[string]int64{s.enqueuedAt, s.deferred, s.inFlight, s.failed, s.incrementalFailures} {
+		if _, ok := m[username]; ok {
+			delete(m, username)
+			found = true
+		}
+	}
+	delete(s.jobs, username)
+	if stored, ok := s.states[username]; ok {
+		delete(s.states, username)
+		found = found || !stored.expired(now)
+	}
+	if stored, ok := s.lastReplication[username]; ok {
+		delete(s.lastReplication, username)
+		found = found || !stored.expired(now)
+	}
+	if stored, ok := s.eventInfo[username]; ok {
+		delete(s.eventInfo, username)
+		found = found || !stored.expired(now)
+	}
+	if stored, ok := s.history[username]; ok {
+		delete(s.history, username)
+		found = found || !stored.expired(now)
+	}
+	if stored, ok := s.lastEvent[username]; ok {
+		delete(s.lastEvent, username)
+		found = found || !stored.expired(now)
+	}
+	return found, nil
+}
+
+// RenameUser moves the data of a renamed user to the new username, see
+// Queue.RenameUser.
+func (q *NativeQueue) RenameUser(ctx context.Context, oldName, newName string) (bool, error) {
+	if oldName == newName {
+		return false, ErrUserExists
+	}
+	now := time.Now()
+	found, err := q.moveUser(oldName, newName, now)
+	if err != nil {
+		return false, err
+	}
+
+	// the states of fallback destinations are named after the user and kept in other shards
+	states, times := q.takeFallback(oldName, now)
+	for name, stored := range states {
+		name = strings.TrimSuffix(name, oldName) + newName
+		s := q.shard(name)
+		if current, ok := s.states[name]; !ok || current.expired(now) {
+			s.states[name] = stored
+		}
+		s.mu.Unlock()
+	}
+	for name, stored := range times {
+		name = strings.TrimSuffix(name, oldName) + newName
+		s := q.shard(name)
+		if current, ok := s.lastReplication[name]; !ok || current.expired(now) {
+			s.lastReplication[name] = stored
+		}
+		s.mu.Unlock()
+	}
+	return found || len(states) > 0 || len(times) > 0, nil
+}
+
+// moveUser moves the data of the shard of a renamed user, see RenameUser.
+func (q *NativeQueue) moveUser(oldName, newName string, now time.Time) (bool, error) {
+	from, to, unlock := q.shardPair(oldName, newName)
+	defer unlock()
+
+	if _, ok := from.inFlight[oldName]; ok {
+		return false, ErrUserInFlight
+	}
+	if stored, ok := to.states[newName]; ok && !stored.expired(now) {
+		return false, ErrUserExists
+	}
+	if stored, ok := to.lastReplication[newName]; ok && !stored.expired(now) {
+		return false, ErrUserExists
+	}
+
+	found := false
+	if t, ok := from.queued[oldName]; ok {
+		heap.Remove(&from.tasks, t.index)
+		delete(from.queued, oldName)
+		to.addLT(newName, t.score)
+		found = true
+	}
+	for _, m := range [][2]map[string]int64{{from.enqueuedAt, to.enqueuedAt}, {from.deferred, to.deferred}} {
+		if v, ok := m[0][oldName]; ok {
+			delete(m[0], oldName)
+			if current, ok := m[1][newName]; !ok || v < current {
+				m[1][newName] = v
+			}
+			found = true
+		}
+	}
+	for _, m := range []map[string]int64{from.failed, from.incrementalFailures} {
+		if _, ok := m[oldName]; ok {
+			delete(m, oldName)
+			found = true
+		}
+	}
+	found = moveExpiring(from.states, to.states, oldName, newName, now) || found
+	found = moveExpiring(from.lastReplication, to.lastReplication, oldName, newName, now) || found
+	found = moveExpiring(from.eventInfo, to.eventInfo, oldName, newName, now) || found
+	found = moveExpiring(from.history, to.history, oldName, newName, now) || found
+	found = moveExpiring(from.lastEvent, to.lastEvent, oldName, newName, now) || found
+	if _, ok := from.redirected[oldName]; ok {
+		delete(from.redirected, oldName)
+		to.redirected[newName] = struct{}{}
+		found = true
+	}
+	return found, nil
+}
+
+// takeFallback removes the replication states and last replication times of
+// the user for fallback destinations from all shards, see fallbackStateKey,
+// and returns the unexpired ones by name.
+func (q *NativeQueue) takeFallback(username string, now time.Time) (map[string]expiring[nativeState], map[string]expiring[int64]) {
+	states := make(map[string]expiring[nativeState])
+	times := make(map[string]expiring[int64])
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for name, stored := range s.states {
+			if user, ok := fallbackUser(name); ok && user == username {
+				delete(s.states, name)
+				if !stored.expired(now) {
+					states[name] = stored
+				}
+			}
+		}
+		for name, stored := range s.lastReplication {
+			if user, ok := fallbackUser(name); ok && user == username {
+				delete(s.lastReplication, name)
+				if !stored.expired(now) {
+					times[name] = stored
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+	return states, times
+}
+
+// MarkRedirected records that a user was synced to the fallback destination.
+func (q *NativeQueue) MarkRedirected(ctx context.Context, username string) error {
+	s := q.shard(username)
+	defer s.mu.Unlock()
+	s.redirected[username] = struct{}{}
+	return nil
+}
+
+// ListRedirected returns the users synced to the fallback destination.
+func (q *NativeQueue) ListRedirected(ctx context.Context) ([]string, error) {
+	var users []string
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for username := range s.redirected {
+			users = append(users, username)
+		}
+		s.mu.Unlock()
+	}
+	return users, nil
+}
+
+// ClearRedirected removes the redirection marks of usernames.
+func (q *NativeQueue) ClearRedirected(ctx context.Context, usernames []string) error {
+	for _, username := range usernames {
+		s := q.shard(username)
+		delete(s.redirected, username)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// shardPair locks the shards of two different users in a fixed order, so that
+// concurrent calls cannot deadlock, and returns them with the unlock function.
+func (q *NativeQueue) shardPair(a, b string) (*nativeShard, *nativeShard, func()) {
+	i := maphash.String(q.seed, a) % nativeShards
+	j := maphash.String(q.seed, b) % nativeShards
+	sa, sb := &q.shards[i], &q.shards[j]
+	if i == j {
+		sa.mu.Lock()
+		return sa, sb, sa.mu.Unlock
+	}
+	first, second := sa, sb
+	if j < i {
+		first, second = sb, sa
+	}
+	first.mu.Lock()
+	second.mu.Lock()
+	return sa, sb, func() {
+		second.mu.Unlock()
+		first.mu.Unlock()
+	}
+}
+
+// moveExpiring moves the unexpired value of oldName to newName unless newName
+// has one already, and drops the old value. Returns whether an unexpired value
+// was stored for oldName.
+func moveExpiring[T any](from, to map[string]expiring[T], oldName, newName string, now time.Time) bool {
+	stored, ok := from[oldName]
+	if !ok {
+		return false
+	}
+	delete(from, oldName)
+	if stored.expired(now) {
+		return false
+	}
+	if current, ok := to[newName]; !ok || current.expired(now) {
+		to[newName] = stored
+	}
+	return true
+}
+
+// Status returns a summary of the queue including the next n users to be synced.
+// It scans all queued users.
+func (q *NativeQueue) Status(ctx context.Context, next int) (*Status, error) {
+	now := time.Now()
+	status := &Status{Next: []QueuedUser{}}
+	var queued []QueuedUser
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for _, t := range s.tasks {
+			state, ok := s.states[t.username]
+			user := QueuedUser{
+				Username: t.username,
+				Score:    t.score,
+				FullSync: !ok || state.expired(now),
+			}
+			if ts, ok := s.enqueuedAt[t.username]; ok {
+				user.EnqueuedAt = time.Unix(ts, 0)
+			}
+			queued = append(queued, user)
+		}
+		status.InFlight += len(s.inFlight)
+		status.Failed += len(s.failed)
+		status.Deferred += len(s.deferred)
+		for _, stored := range s.lastReplication {
+			if !stored.expired(now) {
+				status.KnownUsers++
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	status.Queued = len(queued)
+	for _, user := range queued {
+		if user.FullSync {
+			status.QueuedFull++
+		} else {
+			status.QueuedIncremental++
+		}
+	}
+	slices.SortFunc(queued, func(a, b QueuedUser) int {
+		if c := cmp.Compare(a.Score, b.Score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Username, b.Username)
+	})
+	status.Next = append(status.Next, queued[:min(max(next, 0), len(queued))]...)
+	return status, nil
+}
+
+// ExportQueue returns up to limit queued users in dequeue order with their
+// score, first-enqueue time and event info, without claiming them; limit 0
+// returns all. See InMemoryQueue.ExportQueue.
+func (q *NativeQueue) ExportQueue(ctx context.Context, limit int) ([]QueueEntry, error) {
+	now := time.Now()
+	var entries []QueueEntry
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for _, t := range s.tasks {
+			var enqueuedAt time.Time
+			if ts, ok := s.enqueuedAt[t.username]; ok {
+				enqueuedAt = time.Unix(ts, 0)
+			}
+			var info *EventInfo
+			if stored, ok := s.eventInfo[t.username]; ok && !stored.expired(now) && len(stored.value) > 0 {
+				info = eventInfoFromHash(stored.value)
+			}
+			entries = append(entries, QueueEntry{Item: NewQueueItem(t.username, priorityFromScore(t.score, enqueuedAt), enqueuedAt, info), Score: t.score})
+		}
+		s.mu.Unlock()
+	}
+	slices.SortFunc(entries, func(a, b QueueEntry) int {
+		if c := cmp.Compare(a.Score, b.Score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Item.Username, b.Item.Username)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// ImportQueue queues the users of entries with their score, first-enqueue
+// time and event info. See InMemoryQueue.ImportQueue.
+func (q *NativeQueue) ImportQueue(ctx context.Context, entries []QueueEntry) (int, error) {
+	if err := ValidateQueueEntries(entries); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		username := entry.Item.Username
+		enqueuedAt := entry.Item.EnqueuedAt
+		if enqueuedAt.IsZero() {
+			enqueuedAt = now
+		}
+		s := q.shard(username)
+		s.mergeEventInfo(username, entry.Item.Info, now)
+		if ts, ok := s.enqueuedAt[username]; !ok || enqueuedAt.Unix() < ts {
+			s.enqueuedAt[username] = enqueuedAt.Unix()
+		}
+		s.addLT(username, entry.Score)
+		s.mu.Unlock()
+	}
+	if len(entries) > 0 {
+		atomic.AddUint64(&q.enqueueCount, uint64(len(entries)))
+		if fn := q.onEnqueue.Load(); fn != nil {
+			(*fn)()
+		}
+	}
+	return len(entries), nil
+}
+
+// RecordSyncLatency counts a sync completed at the given time, latency after
+// its triggering event, into the SLA counters.
+func (q *NativeQueue) RecordSyncLatency(ctx context.Context, completedAt time.Time, latency time.Duration) error {
+	slot := completedAt.Truncate(slaSlot).Unix()
+	q.slaMu.Lock()
+	defer q.slaMu.Unlock()
+	c, ok := q.sla[slot]
+	if !ok || c.expiresAt.Before(time.Now()) {
+		c = &slaCounters{}
+		q.sla[slot] = c
+	}
+	c.syncs++
+	c.latencyMs += latency.Milliseconds()
+	for _, t := range slaTargets {
+		if latency <= t.target {
+			*c.counter(t.field)++
+		}
+	}
+	c.expiresAt = time.Now().Add(slaRetention + slaSlot)
+	return nil
+}
+
+// SLAReport aggregates the SLA counters of the syncs completed since the given
+// time. The start is rounded down to the 5 minute slots the counters are kept
+// in, and counters are kept for at most MaxSLAWindow.
+func (q *NativeQueue) SLAReport(ctx context.Context, since time.Time) (*SLAReport, error) {
+	now := time.Now()
+	report := &SLAReport{}
+	var latencyMs int64
+	q.slaMu.Lock()
+	for slot := since.Truncate(slaSlot); !slot.After(now); slot = slot.Add(slaSlot) {
+		c, ok := q.sla[slot.Unix()]
+		if !ok || c.expiresAt.Before(now) {
+			continue
+		}
+		report.Syncs += c.syncs
+		report.Within1m += c.within1m
+		report.Within5m += c.within5m
+		report.Within1h += c.within1h
+		latencyMs += c.latencyMs
+	}
+	q.slaMu.Unlock()
+	if report.Syncs > 0 {
+		syncs := float64(report.Syncs)
+		report.Within1mRatio = float64(report.Within1m) / syncs
+		report.Within5mRatio = float64(report.Within5m) / syncs
+		report.Within1hRatio = float64(report.Within1h) / syncs
+		report.MeanLatencySeconds = float64(latencyMs) / 1000 / syncs
+	}
+	return report, nil
+}
+
+// sweep periodically removes expired data until the queue is closed, taking
+// the place of Redis key expiry.
+func (q *NativeQueue) sweep() {
+	defer close(q.doneCh)
+	ticker := time.NewTicker(nativeSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stopCh:
+			return
+		case now := <-ticker.C:
+			q.removeExpired(now)
+		}
+	}
+}
+
+func (q *NativeQueue) removeExpired(now time.Time) {
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		deleteExpired(s.states, now)
+		deleteExpired(s.lastReplication, now)
+		deleteExpired(s.eventInfo, now)
+		deleteExpired(s.history, now)
+		deleteExpired(s.lastEvent, now)
+		s.mu.Unlock()
+	}
+	q.slaMu.Lock()
+	for slot, c := range q.sla {
+		if c.expiresAt.Before(now) {
+			delete(q.sla, slot)
+		}
+	}
+	q.slaMu.Unlock()
+}
+
+func deleteExpired[T any](m map[string]expiring[T], now time.Time) {
+	for key, stored := range m {
+		if stored.expired(now) {
+			delete(m, key)
+		}
+	}
+}
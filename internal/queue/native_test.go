@@ -0,0 +1,610 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// backends returns a constructor for every Queue implementation, so that the
+// tests below verify both behave the same.
+func backends() map[string]func(t *testing.T) Queue {
+	return map[string]func(t *testing.T) Queue{
+		"miniredis": func(t *testing.T) Queue {
+			q, err := NewInMemoryQueue("testbackend", "", testLogger())
+			if err != nil {
+				t.Fatalf("failed to create queue: %v", err)
+			}
+			return q
+		},
+		"external": func(t *testing.T) Queue {
+			q, err := NewExternalQueue("testbackend", miniredis.RunT(t).Addr(), "", testLogger())
+			if err != nil {
+				t.Fatalf("failed to create queue: %v", err)
+			}
+			return q
+		},
+		"native": func(t *testing.T) Queue {
+			return NewNativeQueue(testLogger())
+		},
+	}
+}
+
+// skipUnlessDurable skips tests of behavior across processes for queues that
+// lose their data when the process exits.
+func skipUnlessDurable(t *testing.T, q Queue) {
+	t.Helper()
+	if d, ok := q.(DurableQueue); !ok || !d.Durable() {
+		t.Skip("queue does not outlive the process")
+	}
+}
+
+// forEachBackend runs fn against a fresh queue of every implementation.
+func forEachBackend(t *testing.T, fn func(t *testing.T, q Queue)) {
+	for name, newQueue := range backends() {
+		t.Run(name, func(t *testing.T) {
+			q := newQueue(t)
+			defer func() {
+				if cerr := q.Close(); cerr != nil {
+					t.Fatalf("failed to close queue: %v", cerr)
+				}
+			}()
+			fn(t, q)
+		})
+	}
+}
+
+// nextUsers returns the queued users in dequeue order without claiming them.
+func nextUsers(t *testing.T, q Queue) []QueuedUser {
+	t.Helper()
+	status, err := q.Status(context.Background(), 1000)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	return status.Next
+}
+
+func TestBackendPriorityAndBestScore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		if err := q.Enqueue(ctx, "user-low", 0.5); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		if err := q.Enqueue(ctx, "user-normal", 1.0); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		if err := q.Enqueue(ctx, "user-high", 2.0); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		// a lower-priority event must not move user-high back
+		if err := q.Enqueue(ctx, "user-high", 0.1); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+
+		next := nextUsers(t, q)
+		if len(next) != 3 || next[0].Username != "user-high" || next[1].Username != "user-normal" || next[2].Username != "user-low" {
+			t.Fatalf("unexpected order %+v", next)
+		}
+		if n, err := q.Len(ctx); err != nil || n != 3 {
+			t.Fatalf("expected 3 queued users, got %d (err %v)", n, err)
+		}
+
+		users, err := q.DequeueN(ctx, 2)
+		if err != nil || len(users) != 2 || users[0].Username != "user-high" || users[1].Username != "user-normal" {
+			t.Fatalf("unexpected dequeue %v (err %v)", users, err)
+		}
+		claims, err := q.InFlight(ctx)
+		if err != nil || len(claims) != 2 {
+			t.Fatalf("expected 2 in-flight claims, got %v (err %v)", claims, err)
+		}
+		if err := q.Ack(ctx, "user-high"); err != nil {
+			t.Fatalf("ack: %v", err)
+		}
+		if claims, _ := q.InFlight(ctx); len(claims) != 1 {
+			t.Fatalf("expected 1 in-flight claim after ack, got %v", claims)
+		}
+
+		users, err = q.DequeueN(ctx, 5)
+		if err != nil || len(users) != 1 || users[0].Username != "user-low" {
+			t.Fatalf("unexpected dequeue %v (err %v)", users, err)
+		}
+		if user, err := q.Dequeue(ctx); err != nil || user.Username != "" {
+			t.Fatalf("expected empty queue, got %q (err %v)", user.Username, err)
+		}
+		if oldest, err := q.OldestEnqueuedAt(ctx); err != nil || !oldest.IsZero() {
+			t.Fatalf("expected no enqueue time for empty queue, got %v (err %v)", oldest, err)
+		}
+	})
+}
+
+func TestBackendDeferAndOverdue(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		if err := q.Defer(ctx, "user-a", time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("defer: %v", err)
+		}
+		// the earlier time is kept
+		if err := q.Defer(ctx, "user-a", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("defer: %v", err)
+		}
+		if err := q.Defer(ctx, "user-b", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("defer: %v", err)
+		}
+		promoted, err := q.PromoteDeferred(ctx)
+		if err != nil || promoted != 1 {
+			t.Fatalf("expected 1 promoted user, got %d (err %v)", promoted, err)
+		}
+		status, err := q.Status(ctx, 1)
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		if status.Queued != 1 || status.Deferred != 1 || status.Next[0].Username != "user-a" || status.Next[0].EnqueuedAt.IsZero() {
+			t.Fatalf("unexpected status %+v", status)
+		}
+
+		if err := q.Enqueue(ctx, "user-c", 100.0); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		if next := nextUsers(t, q); next[0].Username != "user-c" {
+			t.Fatalf("expected user-c first before aging, got %+v", next)
+		}
+		promoted, err = q.PromoteOverdue(ctx, 0)
+		if err != nil || promoted != 2 {
+			t.Fatalf("expected 2 overdue users, got %d (err %v)", promoted, err)
+		}
+		if promoted, _ := q.PromoteOverdue(ctx, 0); promoted != 0 {
+			t.Fatalf("expected promoted users to stay in place, got %d", promoted)
+		}
+		if next := nextUsers(t, q); next[0].Score >= 0 || next[1].Score >= 0 {
+			t.Fatalf("expected overdue users at the head, got %+v", next)
+		}
+	})
+}
+
+func TestBackendDequeueTakesEventInfo(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		if err := q.EnqueueEvent(ctx, "user-a", 2.0, &EventInfo{CmdName: "APPEND", Origin: OriginRetry, Attempts: 2}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		item, err := q.Dequeue(ctx)
+		if err != nil || item.Username != "user-a" || item.EnqueuedAt.IsZero() || item.Priority != 2.0 {
+			t.Fatalf("dequeued %+v (err %v), want user-a at priority 2", item, err)
+		}
+		if item.Info == nil || item.Info.CmdName != "APPEND" || item.Attempts() != 2 {
+			t.Fatalf("dequeued with event info %+v, want APPEND after 2 attempts", item.Info)
+		}
+		if info, _ := q.TakeEventInfo(ctx, "user-a"); info != nil {
+			t.Fatalf("expected event info to be taken with the claim, got %+v", info)
+		}
+
+		// handed back unprocessed, the item keeps its event info
+		if err := q.EnqueueItem(ctx, item); err != nil {
+			t.Fatalf("enqueue item: %v", err)
+		}
+		if err := q.Ack(ctx, "user-a"); err != nil {
+			t.Fatalf("ack: %v", err)
+		}
+		items, err := q.DequeueN(ctx, 2)
+		if err != nil || len(items) != 1 || items[0].Attempts() != 2 || items[0].Priority != 2.0 {
+			t.Fatalf("dequeued %+v (err %v), want user-a at priority 2 after 2 attempts", items, err)
+		}
+	})
+}
+
+func TestBackendClaimSkipsUsersInFlight(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		for _, user := range []string{"user-a", "user-b"} {
+			if err := q.Enqueue(ctx, user, 1.0); err != nil {
+				t.Fatalf("enqueue: %v", err)
+			}
+		}
+		if item, err := q.Dequeue(ctx); err != nil || item.Username != "user-a" {
+			t.Fatalf("dequeued %+v (err %v), want user-a", item, err)
+		}
+
+		// requeued while its sync runs, the user waits for the release of its claim
+		if err := q.EnqueueEvent(ctx, "user-a", 1e6, &EventInfo{CmdName: "APPEND"}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		items, err := q.DequeueN(ctx, 2)
+		if err != nil || len(items) != 1 || items[0].Username != "user-b" {
+			t.Fatalf("second claimer dequeued %+v (err %v), want user-b only", items, err)
+		}
+		if n, _ := q.Len(ctx); n != 1 {
+			t.Fatalf("expected user-a to stay queued, got length %d", n)
+		}
+
+		if err := q.Ack(ctx, "user-a"); err != nil {
+			t.Fatalf("ack: %v", err)
+		}
+		item, err := q.Dequeue(ctx)
+		if err != nil || item.Username != "user-a" || item.Info == nil || item.Info.CmdName != "APPEND" {
+			t.Fatalf("dequeued %+v (err %v), want user-a with its event info", item, err)
+		}
+		if err := q.SaveJob(ctx, "user-a", item.Info); err != nil {
+			t.Fatalf("save job: %v", err)
+		}
+		claims, err := q.InFlight(ctx)
+		if err != nil || len(claims) != 2 {
+			t.Fatalf("claims %v (err %v), want user-a and user-b", claims, err)
+		}
+		if recovered, err := q.RecoverJobs(ctx, time.Now().Add(time.Second)); err != nil || len(recovered) != 2 {
+			t.Fatalf("recovered %v (err %v), want both claims", recovered, err)
+		}
+		if info, _ := q.TakeEventInfo(ctx, "user-a"); info == nil || info.CmdName != "APPEND" {
+			t.Fatalf("recovered user-a with event info %+v, want its saved job", info)
+		}
+	})
+}
+
+func TestBackendUserData(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		triggered := time.Unix(1700000000, 0)
+		if err := q.EnqueueEvent(ctx, "user-a", 1.0, &EventInfo{CmdName: "APPEND", Mailboxes: []string{"INBOX"}, TriggeredAt: triggered, OccurredAt: triggered.Add(-time.Second)}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		if err := q.EnqueueEvent(ctx, "user-a", 1.0, &EventInfo{CmdName: "EXPUNGE", Mailboxes: []string{"Sent"}, TriggeredAt: triggered.Add(time.Minute), OccurredAt: triggered.Add(time.Minute - time.Second)}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		info, err := q.TakeEventInfo(ctx, "user-a")
+		if err != nil || info == nil {
+			t.Fatalf("expected event info, got %v (err %v)", info, err)
+		}
+		if info.CmdName != "EXPUNGE" || len(info.Mailboxes) != 2 || !info.TriggeredAt.Equal(triggered) || !info.OccurredAt.Equal(triggered.Add(-time.Second)) {
+			t.Fatalf("unexpected merged event info %+v", info)
+		}
+		if info, _ := q.TakeEventInfo(ctx, "user-a"); info != nil {
+			t.Fatalf("expected event info to be taken, got %+v", info)
+		}
+
+		if err := q.SetReplicationState(ctx, "user-a", "AQAAAKhj"); err != nil {
+			t.Fatalf("set state: %v", err)
+		}
+		if state, err := q.GetReplicationState(ctx, "user-a"); err != nil || state != "AQAAAKhj" {
+			t.Fatalf("unexpected state %q (err %v)", state, err)
+		}
+		if err := q.SetReplicationState(ctx, "user-b", "not a state!"); err != nil {
+			t.Fatalf("set state: %v", err)
+		}
+		if state, err := q.GetReplicationState(ctx, "user-b"); err != nil || state != "" {
+			t.Fatalf("expected invalid state to be discarded, got %q (err %v)", state, err)
+		}
+
+		now := time.Now()
+		if err := q.SetLastReplicationTime(ctx, "user-a", now); err != nil {
+			t.Fatalf("set last replication: %v", err)
+		}
+		if last, err := q.GetLastReplicationTime(ctx, "user-a"); err != nil || last.Unix() != now.Unix() {
+			t.Fatalf("unexpected last replication %v (err %v)", last, err)
+		}
+		if times, err := q.ListLastReplicationTimes(ctx); err != nil || len(times) != 1 {
+			t.Fatalf("expected one last replication time, got %v (err %v)", times, err)
+		}
+
+		for i := range 3 {
+			if n, err := q.IncrIncrementalFailures(ctx, "user-a"); err != nil || n != int64(i+1) {
+				t.Fatalf("expected %d incremental failures, got %d (err %v)", i+1, n, err)
+			}
+		}
+		if err := q.RecordFailure(ctx, "user-a"); err != nil {
+			t.Fatalf("record failure: %v", err)
+		}
+		if n, err := q.FailedCount(ctx); err != nil || n != 1 {
+			t.Fatalf("expected 1 failed user, got %d (err %v)", n, err)
+		}
+
+		for i := range 3 {
+			if err := q.AppendHistory(ctx, "user-a", HistoryEntry{Result: fmt.Sprint(i)}, 2); err != nil {
+				t.Fatalf("append history: %v", err)
+			}
+		}
+		history, err := q.GetHistory(ctx, "user-a")
+		if err != nil || len(history) != 2 || history[0].Result != "2" || history[1].Result != "1" {
+			t.Fatalf("unexpected history %+v (err %v)", history, err)
+		}
+
+		found, err := q.DeleteUser(ctx, "user-a")
+		if err != nil || !found {
+			t.Fatalf("expected user-a to be deleted, got found=%v err=%v", found, err)
+		}
+		if n, _ := q.Len(ctx); n != 0 {
+			t.Fatalf("expected deleted user to leave the queue, got %d queued", n)
+		}
+		if n, _ := q.FailedCount(ctx); n != 0 {
+			t.Fatalf("expected failure mark to be deleted, got %d", n)
+		}
+		if history, _ := q.GetHistory(ctx, "user-a"); len(history) != 0 {
+			t.Fatalf("expected history to be deleted, got %+v", history)
+		}
+		if n, _ := q.IncrIncrementalFailures(ctx, "user-a"); n != 1 {
+			t.Fatalf("expected incremental failures to be reset, got %d", n)
+		}
+		if found, _ := q.DeleteUser(ctx, "user-c"); found {
+			t.Fatal("expected nothing stored for an unknown user")
+		}
+	})
+}
+
+func TestBackendRenameUser(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		now := time.Now()
+		if err := q.SetReplicationState(ctx, "old", "AQAAAKhj"); err != nil {
+			t.Fatalf("set state: %v", err)
+		}
+		if err := q.SetLastReplicationTime(ctx, "old", now); err != nil {
+			t.Fatalf("set last replication: %v", err)
+		}
+		if err := q.AppendHistory(ctx, "old", HistoryEntry{Result: HistoryResultSuccess}, 5); err != nil {
+			t.Fatalf("append history: %v", err)
+		}
+		if err := q.RecordFailure(ctx, "old"); err != nil {
+			t.Fatalf("record failure: %v", err)
+		}
+		if err := q.EnqueueEvent(ctx, "old", 1.0, &EventInfo{TriggeredAt: now}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+
+		if found, err := q.RenameUser(ctx, "old", "new"); err != nil || !found {
+			t.Fatalf("rename: found=%v err=%v", found, err)
+		}
+		if state, _ := q.GetReplicationState(ctx, "new"); state != "AQAAAKhj" {
+			t.Fatalf("expected state to be moved, got %q", state)
+		}
+		if last, _ := q.GetLastReplicationTime(ctx, "new"); last.Unix() != now.Unix() {
+			t.Fatalf("expected last replication to be moved, got %v", last)
+		}
+		if history, _ := q.GetHistory(ctx, "new"); len(history) != 1 {
+			t.Fatalf("expected history to be moved, got %+v", history)
+		}
+		if last, _ := q.GetLastEventTime(ctx, "new"); last.Unix() != now.Unix() {
+			t.Fatalf("expected last event time to be moved, got %v", last)
+		}
+		if next := nextUsers(t, q); len(next) != 1 || next[0].Username != "new" || next[0].FullSync {
+			t.Fatalf("expected the new user to be queued for an incremental sync, got %+v", next)
+		}
+		if n, _ := q.FailedCount(ctx); n != 0 {
+			t.Fatalf("expected failure mark to be dropped, got %d failed", n)
+		}
+		if found, err := q.DeleteUser(ctx, "old"); err != nil || found {
+			t.Fatalf("expected nothing left for the old user: found=%v err=%v", found, err)
+		}
+
+		// the new user is not overwritten
+		if err := q.SetReplicationState(ctx, "other", "AQAAAKhk"); err != nil {
+			t.Fatalf("set state: %v", err)
+		}
+		if _, err := q.RenameUser(ctx, "other", "new"); !errors.Is(err, ErrUserExists) {
+			t.Fatalf("expected ErrUserExists, got %v", err)
+		}
+		if state, _ := q.GetReplicationState(ctx, "other"); state != "AQAAAKhk" {
+			t.Fatalf("expected rejected rename to keep the state, got %q", state)
+		}
+
+		// users being synced are not renamed
+		if item, err := q.Dequeue(ctx); err != nil || item.Username != "new" {
+			t.Fatalf("dequeue: %q (err %v)", item.Username, err)
+		}
+		if _, err := q.RenameUser(ctx, "new", "newer"); !errors.Is(err, ErrUserInFlight) {
+			t.Fatalf("expected ErrUserInFlight, got %v", err)
+		}
+
+		if found, err := q.RenameUser(ctx, "unknown", "newer"); err != nil || found {
+			t.Fatalf("expected nothing to rename: found=%v err=%v", found, err)
+		}
+	})
+}
+
+func TestBackendFallbackStateFollowsUser(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		now := time.Now()
+		// a destination with a slash must not be confused with a user "a/alice"
+		fallback := fallbackStateKey("remote:/srv/mail", "alice")
+		other := fallbackStateKey("remote", "a/alice")
+		for _, key := range []string{fallback, other} {
+			if err := q.SetReplicationState(ctx, key, "AQAAAKhj"); err != nil {
+				t.Fatalf("set state: %v", err)
+			}
+			if err := q.SetLastReplicationTime(ctx, key, now); err != nil {
+				t.Fatalf("set last replication: %v", err)
+			}
+		}
+		if err := q.MarkRedirected(ctx, "alice"); err != nil {
+			t.Fatalf("mark redirected: %v", err)
+		}
+		if times, _ := q.ListLastReplicationTimes(ctx); len(times) != 0 {
+			t.Fatalf("expected fallback times not to be listed as users, got %v", times)
+		}
+
+		if found, err := q.RenameUser(ctx, "alice", "bob"); err != nil || !found {
+			t.Fatalf("rename: found=%v err=%v", found, err)
+		}
+		moved := fallbackStateKey("remote:/srv/mail", "bob")
+		if state, _ := q.GetReplicationState(ctx, moved); state != "AQAAAKhj" {
+			t.Fatalf("expected fallback state to be moved, got %q", state)
+		}
+		if last, _ := q.GetLastReplicationTime(ctx, moved); last.Unix() != now.Unix() {
+			t.Fatalf("expected fallback time to be moved, got %v", last)
+		}
+		if state, _ := q.GetReplicationState(ctx, fallback); state != "" {
+			t.Fatalf("expected no fallback state left for the old user, got %q", state)
+		}
+		if users, _ := q.ListRedirected(ctx); !slices.Equal(users, []string{"bob"}) {
+			t.Fatalf("expected redirection mark to be moved, got %v", users)
+		}
+
+		if found, err := q.DeleteUser(ctx, "bob"); err != nil || !found {
+			t.Fatalf("delete: found=%v err=%v", found, err)
+		}
+		if state, _ := q.GetReplicationState(ctx, moved); state != "" {
+			t.Fatalf("expected fallback state to be deleted, got %q", state)
+		}
+		if last, _ := q.GetLastReplicationTime(ctx, moved); !last.IsZero() {
+			t.Fatalf("expected fallback time to be deleted, got %v", last)
+		}
+		if users, _ := q.ListRedirected(ctx); len(users) != 0 {
+			t.Fatalf("expected redirection mark to be deleted, got %v", users)
+		}
+		if state, _ := q.GetReplicationState(ctx, other); state != "AQAAAKhj" {
+			t.Fatalf("expected the fallback state of another user to be kept, got %q", state)
+		}
+		if found, err := q.DeleteUser(ctx, "bob"); err != nil || found {
+			t.Fatalf("expected nothing left: found=%v err=%v", found, err)
+		}
+	})
+}
+
+func TestBackendSLAReport(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		now := time.Now()
+		for _, latency := range []time.Duration{10 * time.Second, 3 * time.Minute, 2 * time.Hour} {
+			if err := q.RecordSyncLatency(ctx, now, latency); err != nil {
+				t.Fatalf("record latency: %v", err)
+			}
+		}
+		report, err := q.SLAReport(ctx, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("report: %v", err)
+		}
+		if report.Syncs != 3 || report.Within1m != 1 || report.Within5m != 2 || report.Within1h != 2 {
+			t.Fatalf("unexpected report %+v", report)
+		}
+	})
+}
+
+func TestNativeQueueConcurrentEnqueueDequeue(t *testing.T) {
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	ctx := context.Background()
+
+	const producers, users = 8, 500
+	var wg sync.WaitGroup
+	for p := range producers {
+		wg.Go(func() {
+			for i := range users {
+				// every producer enqueues every user, which must coalesce
+				_ = q.Enqueue(ctx, fmt.Sprintf("user-%d", (i+p)%users), 1.0)
+			}
+		})
+	}
+	claimed := make(map[string]int)
+	var mu sync.Mutex
+	for range 4 {
+		wg.Go(func() {
+			for range 200 {
+				names, _ := q.DequeueN(ctx, 3)
+				mu.Lock()
+				for _, name := range names {
+					claimed[name.Username]++
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	rest, err := q.DequeueN(ctx, users*producers)
+	if err != nil {
+		t.Fatalf("dequeue: %v", err)
+	}
+	for _, name := range rest {
+		claimed[name.Username]++
+	}
+	for i := range users {
+		if claimed[fmt.Sprintf("user-%d", i)] == 0 {
+			t.Fatalf("user-%d was never dequeued", i)
+		}
+	}
+	if n, _ := q.Len(ctx); n != 0 {
+		t.Fatalf("expected empty queue, got %d", n)
+	}
+}
+
+func BenchmarkEnqueueParallel(b *testing.B) {
+	for name, newQueue := range map[string]func() Queue{
+		"miniredis": func() Queue {
+			q, err := NewInMemoryQueue("benchenqueue", "", testLogger())
+			if err != nil {
+				b.Fatalf("failed to create queue: %v", err)
+			}
+			return q
+		},
+		"native": func() Queue { return NewNativeQueue(testLogger()) },
+	} {
+		b.Run(name, func(b *testing.B) {
+			q := newQueue()
+			defer func() { _ = q.Close() }()
+			ctx := context.Background()
+			info := &EventInfo{CmdName: "APPEND", Mailboxes: []string{"INBOX"}}
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if err := q.EnqueueEvent(ctx, fmt.Sprintf("user-%d", i%10000), 1.0, info); err != nil {
+						b.Fatalf("enqueue: %v", err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestBackendRecoverJobs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		if err := q.EnqueueEvent(ctx, "user-a", 1.0, &EventInfo{CmdName: "APPEND", Mailboxes: []string{"INBOX"}}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		item, err := q.Dequeue(ctx)
+		if err != nil || item.Info == nil {
+			t.Fatalf("dequeued %+v (err %v), want event info", item, err)
+		}
+		info := item.Info
+		if err := q.SaveJob(ctx, "user-a", info); err != nil {
+			t.Fatalf("save job: %v", err)
+		}
+
+		if recovered, err := q.RecoverJobs(ctx, time.Now().Add(-time.Minute)); err != nil || len(recovered) != 0 {
+			t.Fatalf("recovered %v (err %v) of recent claims", recovered, err)
+		}
+		recovered, err := q.RecoverJobs(ctx, time.Now().Add(time.Second))
+		if err != nil || len(recovered) != 1 || recovered[0] != "user-a" {
+			t.Fatalf("recovered %v (err %v), want user-a", recovered, err)
+		}
+		if claims, _ := q.InFlight(ctx); len(claims) != 0 {
+			t.Errorf("claims %v left behind", claims)
+		}
+		if next := nextUsers(t, q); len(next) != 1 {
+			t.Errorf("queued %+v, want user-a", next)
+		}
+		if info, _ := q.TakeEventInfo(ctx, "user-a"); info == nil || info.CmdName != "APPEND" || len(info.Mailboxes) != 1 {
+			t.Errorf("recovered with event info %+v", info)
+		}
+
+		// acknowledged jobs are not recovered with their event info
+		if _, err := q.Dequeue(ctx); err != nil {
+			t.Fatalf("dequeue: %v", err)
+		}
+		if err := q.SaveJob(ctx, "user-a", info); err != nil {
+			t.Fatalf("save job: %v", err)
+		}
+		if err := q.Ack(ctx, "user-a"); err != nil {
+			t.Fatalf("ack: %v", err)
+		}
+		if recovered, _ := q.RecoverJobs(ctx, time.Now().Add(time.Second)); len(recovered) != 0 {
+			t.Errorf("recovered %v after ack", recovered)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Origin tells why a user was queued. It is stored with the event info of the
+// queued user, so that logs, metrics and the history of its sync tell
+// event-driven syncs from background work.
+type Origin string
+
+const (
+	OriginEvent      Origin = "event"      // an event of the user
+	OriginAdmin      Origin = "admin"      // the admin API, e.g. dovewardenctl enqueue
+	OriginRetry      Origin = "retry"      // a retry of a failed sync
+	OriginBackground Origin = "background" // background replication or failover reconciliation
+	OriginUnknown    Origin = "unknown"    // queued without an origin, e.g. by the Go library
+)
+
+// Origins are the origins a user can be queued with, most significant first.
+// A user queued several times before being synced keeps the most significant
+// of their origins, e.g. an event makes a queued background sync event-driven.
+var Origins = []Origin{OriginEvent, OriginAdmin, OriginRetry, OriginBackground}
+
+// moreSignificant reports whether origin a outranks origin b.
+func moreSignificant(a, b Origin) bool {
+	i, j := slices.Index(Origins, a), slices.Index(Origins, b)
+	return i >= 0 && (j < 0 || i < j)
+}
+
+// OriginOf returns the origin of the job being handled, OriginUnknown if it
+// was queued without one.
+func OriginOf(ctx context.Context) Origin {
+	return originOf(EventInfoFromContext(ctx))
+}
+
+// originOf returns the origin of info, which may be nil.
+func originOf(info *EventInfo) Origin {
+	if info == nil || info.Origin == "" {
+		return OriginUnknown
+	}
+	return info.Origin
+}
+
+// RetryPolicy replaces the immediate retry of failed syncs of an origin.
+// Failures retried after a delay of their own, e.g. tempfail or overload, keep
+// it.
+type RetryPolicy struct {
+	Delay    time.Duration // retry after Delay instead of right away
+	Disabled bool          // do not retry; the user is dead-lettered
+}
+
+// ParseRetryPolicies parses comma-separated origin=policy pairs, where policy
+// is "immediate", "none" or a retry delay, e.g. "background=10m,admin=none".
+func ParseRetryPolicies(s string) (map[Origin]RetryPolicy, error) {
+	policies := make(map[Origin]RetryPolicy)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		origin := Origin(strings.TrimSpace(name))
+		if !ok || !slices.Contains(Origins, origin) {
+			return nil, fmt.Errorf("invalid retry policy %q, must be <origin>=<policy> with an origin of event, admin, retry or background", pair)
+		}
+		switch value = strings.TrimSpace(value); value {
+		case "immediate":
+			policies[origin] = RetryPolicy{}
+		case "none":
+			policies[origin] = RetryPolicy{Disabled: true}
+		default:
+			delay, err := time.ParseDuration(value)
+			if err != nil || delay <= 0 {
+				return nil, fmt.Errorf("invalid retry policy of origin %s: %q is neither immediate, none nor a positive duration", origin, value)
+			}
+			policies[origin] = RetryPolicy{Delay: delay}
+		}
+	}
+	return policies, nil
+}
@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryPolicies(t *testing.T) {
+	policies, err := ParseRetryPolicies(" background=10m, admin=none,event=immediate,")
+	if err != nil {
+		t.Fatalf("ParseRetryPolicies: %v", err)
+	}
+	want := map[Origin]RetryPolicy{
+		OriginBackground: {Delay: 10 * time.Minute},
+		OriginAdmin:      {Disabled: true},
+		OriginEvent:      {},
+	}
+	if len(policies) != len(want) {
+		t.Fatalf("got %+v, want %+v", policies, want)
+	}
+	for origin, policy := range want {
+		if policies[origin] != policy {
+			t.Errorf("policy of %s = %+v, want %+v", origin, policies[origin], policy)
+		}
+	}
+
+	for _, invalid := range []string{"background", "unknown=10m", "admin=never", "retry=-1m", "retry=0s"} {
+		if _, err := ParseRetryPolicies(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestBackendOriginPrecedence(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		for _, origin := range []Origin{OriginBackground, OriginEvent, OriginRetry} {
+			if err := q.EnqueueEvent(ctx, "user-a", 1.0, &EventInfo{Origin: origin}); err != nil {
+				t.Fatalf("EnqueueEvent: %v", err)
+			}
+		}
+		info, err := q.TakeEventInfo(ctx, "user-a")
+		if err != nil {
+			t.Fatalf("TakeEventInfo: %v", err)
+		}
+		if info == nil || info.Origin != OriginEvent {
+			t.Fatalf("got %+v, want origin event", info)
+		}
+		if info.triggered() {
+			t.Error("info carrying only an origin reported as triggered by an event")
+		}
+
+		if err := q.DeferEvent(ctx, "user-b", time.Now().Add(time.Hour), &EventInfo{Origin: OriginRetry}); err != nil {
+			t.Fatalf("DeferEvent: %v", err)
+		}
+		if info, err := q.TakeEventInfo(ctx, "user-b"); err != nil || info == nil || info.Origin != OriginRetry {
+			t.Fatalf("got %+v, %v; want origin retry", info, err)
+		}
+	})
+}
+
+func TestWorkerPoolRetryPolicies(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		var attempts atomic.Int32
+		handler := EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+			attempts.Add(1)
+			return errors.New("sync failed")
+		})
+		for username, origin := range map[string]Origin{"background": OriginBackground, "admin": OriginAdmin} {
+			if err := q.EnqueueEvent(ctx, username, 1.0, &EventInfo{Origin: origin}); err != nil {
+				t.Fatalf("EnqueueEvent: %v", err)
+			}
+		}
+
+		wp := NewWorkerPool(q, 2, testLogger())
+		wp.SetHandler(handler)
+		wp.SetRetryPolicies(map[Origin]RetryPolicy{
+			OriginBackground: {Delay: time.Hour},
+			OriginAdmin:      {Disabled: true},
+		})
+		wp.Start(ctx)
+		deadline := time.Now().Add(5 * time.Second)
+		for attempts.Load() < 2 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := wp.Stop(stopCtx); err != nil {
+			t.Fatalf("stop: %v", err)
+		}
+
+		if got := attempts.Load(); got != 2 {
+			t.Fatalf("expected a single attempt per user, got %d", got)
+		}
+		status, err := q.Status(ctx, 10)
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		// background waits for its retry, admin is dead-lettered
+		if status.Queued != 0 || status.Deferred != 1 || status.Failed != 2 {
+			t.Fatalf("expected nothing queued, 1 deferred and 2 failed users, got %+v", status)
+		}
+		if info, err := q.TakeEventInfo(ctx, "background"); err != nil || info == nil || info.Origin != OriginRetry {
+			t.Fatalf("expected the deferred retry to have origin retry, got %+v, %v", info, err)
+		}
+	})
+}
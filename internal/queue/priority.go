@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+)
+
+// ErrInvalidPriority is returned by Enqueue for priority factors that are not
+// finite and positive.
+var ErrInvalidPriority = errors.New("priority factor must be finite and positive")
+
+// Default bounds of priority factors. A factor of 2 already puts a user ahead
+// of every user queued with factor 1 for decades, so factors beyond these
+// bounds order no differently and only hint at a bug.
+const (
+	DefaultMinPriority = 0.01
+	DefaultMaxPriority = 100
+)
+
+// PriorityLimiter is implemented by queues that clamp priority factors.
+type PriorityLimiter interface {
+	SetPriorityBounds(b *PriorityBounds)
+}
+
+// PriorityBounds clamps the priority factors of enqueues to [Min, Max] and
+// counts clamped and rejected factors.
+type PriorityBounds struct {
+	min, max float64
+	metrics  *metrics.Metrics
+}
+
+// NewPriorityBounds creates bounds for priority factors. A bound of 0 leaves
+// that side unbounded. m may be nil.
+func NewPriorityBounds(min, max float64, m *metrics.Metrics) (*PriorityBounds, error) {
+	if min < 0 || max < 0 || math.IsNaN(min) || math.IsNaN(max) || math.IsInf(min, 0) || math.IsInf(max, 0) {
+		return nil, fmt.Errorf("priority bounds must be finite and not negative, got [%g, %g]", min, max)
+	}
+	if max > 0 && min > max {
+		return nil, fmt.Errorf("minimum priority %g exceeds maximum %g", min, max)
+	}
+	return &PriorityBounds{min: min, max: max, metrics: m}, nil
+}
+
+// checkPriority validates a priority factor and clamps it to b, which may be
+// nil to only validate it.
+func checkPriority(b *PriorityBounds, factor float64) (float64, error) {
+	if factor <= 0 || math.IsNaN(factor) || math.IsInf(factor, 0) {
+		b.count("rejected")
+		return 0, fmt.Errorf("%w, got %g", ErrInvalidPriority, factor)
+	}
+	if b == nil {
+		return factor, nil
+	}
+	switch {
+	case b.min > 0 && factor < b.min:
+		b.count("clamped")
+		return b.min, nil
+	case b.max > 0 && factor > b.max:
+		b.count("clamped")
+		return b.max, nil
+	}
+	return factor, nil
+}
+
+func (b *PriorityBounds) count(action string) {
+	if b != nil && b.metrics != nil {
+		b.metrics.PriorityAdjusted.WithLabelValues(action).Inc()
+	}
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBackendPriorityBounds(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		m := metrics.New(prometheus.NewRegistry())
+		bounds, err := NewPriorityBounds(0.5, 2, m)
+		if err != nil {
+			t.Fatalf("NewPriorityBounds: %v", err)
+		}
+		q.(PriorityLimiter).SetPriorityBounds(bounds)
+
+		for _, factor := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+			if err := q.Enqueue(ctx, "invalid", factor); !errors.Is(err, ErrInvalidPriority) {
+				t.Errorf("Enqueue with factor %g: %v, want ErrInvalidPriority", factor, err)
+			}
+		}
+		if n, _ := q.Len(ctx); n != 0 {
+			t.Fatalf("queued %d users with invalid factors", n)
+		}
+
+		// clamped to 2, a huge factor ranks no better than the maximum
+		if err := q.Enqueue(ctx, "huge", 1e12); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		if err := q.Enqueue(ctx, "max", 2); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		if err := q.Enqueue(ctx, "tiny", 1e-9); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		next := nextUsers(t, q)
+		if len(next) != 3 || next[0].Username != "huge" || next[1].Username != "max" || next[2].Username != "tiny" {
+			t.Fatalf("queued %+v, want huge, max, tiny", next)
+		}
+		if next[0].Score < next[1].Score/1.01 {
+			t.Errorf("huge factor scored %v, not clamped to the maximum like %v", next[0].Score, next[1].Score)
+		}
+
+		if got := testutil.ToFloat64(m.PriorityAdjusted.WithLabelValues("rejected")); got != 4 {
+			t.Errorf("rejected = %v, want 4", got)
+		}
+		if got := testutil.ToFloat64(m.PriorityAdjusted.WithLabelValues("clamped")); got != 2 {
+			t.Errorf("clamped = %v, want 2", got)
+		}
+	})
+}
+
+func TestNewPriorityBounds(t *testing.T) {
+	for _, tc := range []struct {
+		min, max float64
+		ok       bool
+	}{
+		{0.01, 100, true},
+		{0, 0, true},
+		{1, 0, true},
+		{2, 1, false},
+		{-1, 1, false},
+		{0, math.Inf(1), false},
+	} {
+		if _, err := NewPriorityBounds(tc.min, tc.max, nil); (err == nil) != tc.ok {
+			t.Errorf("NewPriorityBounds(%g, %g) = %v, want ok %v", tc.min, tc.max, err, tc.ok)
+		}
+	}
+}
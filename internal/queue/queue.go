@@ -2,18 +2,88 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+// Errors returned by Queue.RenameUser.
+var (
+	ErrUserExists   = errors.New("new username already has a replication state")
+	ErrUserInFlight = errors.New("user is being synced")
+)
+
 // Queue defines the interface for a priority queue implementation.
-// Different backends (miniredis, external Redis) implement this interface.
+// Different backends (miniredis, native in-process, external Redis) implement this interface.
 type Queue interface {
 	// Enqueue adds an event to the queue for a given username with a priority score.
+	// A user is queued at most once; enqueueing an already queued user keeps the
+	// better (lower) of the existing and the new score. Priority factors that
+	// are not finite and positive are rejected with ErrInvalidPriority.
 	Enqueue(ctx context.Context, username string, priorityFactor float64) error
 
-	// Dequeue removes and returns the username with the lowest priority score (highest priority).
-	// Returns empty string and error if queue is empty or backend error occurs.
-	Dequeue(ctx context.Context) (string, error)
+	// EnqueueEvent is like Enqueue but additionally merges the event context into
+	// the info stored for the queued user.
+	EnqueueEvent(ctx context.Context, username string, priorityFactor float64, info *EventInfo) error
+
+	// EnqueueItem is like EnqueueEvent for a dequeued item, e.g. to hand it back
+	// to the queue unprocessed. An unknown priority is queued as 1.0.
+	EnqueueItem(ctx context.Context, item QueueItem) error
+
+	// TakeEventInfo returns and removes the event info accumulated for a user.
+	// Returns nil if no info was stored.
+	TakeEventInfo(ctx context.Context, username string) (*EventInfo, error)
+
+	// Dequeue removes and returns the user with the lowest priority score (highest priority),
+	// like DequeueN. The user is claimed as in-flight until Ack is called.
+	// Returns an item without username if the queue is empty.
+	Dequeue(ctx context.Context) (QueueItem, error)
+
+	// DequeueN removes and returns up to n users with the lowest priority scores,
+	// ordered from highest to lowest priority, in a single backend round trip.
+	// Each item carries the event info taken with the claim, so TakeEventInfo
+	// finds none for it. Each user is claimed as in-flight until Ack is called;
+	// a user queued again meanwhile stays queued until then.
+	// Returns an empty slice if the queue is empty.
+	DequeueN(ctx context.Context, n int) ([]QueueItem, error)
+
+	// Len returns the number of queued users, excluding in-flight and deferred ones.
+	Len(ctx context.Context) (int64, error)
+
+	// OldestEnqueuedAt returns the first-enqueue time of the user waiting the
+	// longest, or the zero time if the queue is empty.
+	OldestEnqueuedAt(ctx context.Context) (time.Time, error)
+
+	// PromoteOverdue moves users that have been queued for longer than maxAge to the
+	// head of the queue and returns how many were promoted.
+	PromoteOverdue(ctx context.Context, maxAge time.Duration) (int, error)
+
+	// Defer postpones the sync of a user until the given time. If the user is
+	// already deferred, the earlier time is kept.
+	Defer(ctx context.Context, username string, until time.Time) error
+
+	// DeferEvent is like Defer but additionally merges info into the info
+	// stored for the user, which its sync sees once promoted.
+	DeferEvent(ctx context.Context, username string, until time.Time, info *EventInfo) error
+
+	// PromoteDeferred moves all deferred users whose time has come into the queue
+	// and returns their number.
+	PromoteDeferred(ctx context.Context) (int, error)
+
+	// Ack releases the in-flight claim of a dequeued user after handling finished.
+	Ack(ctx context.Context, username string) error
+
+	// InFlight returns all claimed users with the time they were dequeued.
+	InFlight(ctx context.Context) (map[string]time.Time, error)
+
+	// SaveJob stores the event info of a claimed user while it is handled, so
+	// that RecoverJobs can requeue the user with it should the process stop
+	// before the sync finished. Ack drops it.
+	SaveJob(ctx context.Context, username string, info *EventInfo) error
+
+	// RecoverJobs requeues the users claimed before claimedBefore, e.g. by a
+	// process that stopped during their sync, with the event info saved by
+	// SaveJob, releases their claims and returns them.
+	RecoverJobs(ctx context.Context, claimedBefore time.Time) ([]string, error)
 
 	// HealthCheck verifies the backend is reachable and functioning.
 	HealthCheck(ctx context.Context) error
@@ -27,10 +97,164 @@ type Queue interface {
 	// SetReplicationState stores the replication state for a user.
 	SetReplicationState(ctx context.Context, username string, state string) error
 
+	// DeleteReplicationState removes the stored replication state of a user,
+	// forcing the next sync to be a full sync.
+	DeleteReplicationState(ctx context.Context, username string) error
+
+	// IncrIncrementalFailures increments and returns the number of consecutive
+	// failed incremental syncs of a user.
+	IncrIncrementalFailures(ctx context.Context, username string) (int64, error)
+
+	// ClearIncrementalFailures resets the consecutive incremental failure count of a user.
+	ClearIncrementalFailures(ctx context.Context, username string) error
+
 	// GetLastReplicationTime retrieves the timestamp of the last replication for a user.
 	// Returns zero time if no replication has been performed.
 	GetLastReplicationTime(ctx context.Context, username string) (time.Time, error)
 
 	// SetLastReplicationTime stores the timestamp of the last replication for a user.
 	SetLastReplicationTime(ctx context.Context, username string, t time.Time) error
+
+	// RecordFailure marks a user's most recent sync attempt as failed.
+	RecordFailure(ctx context.Context, username string) error
+
+	// ClearFailure removes the failure mark of a user after a successful sync.
+	ClearFailure(ctx context.Context, username string) error
+
+	// FailedCount returns the number of users whose last sync failed.
+	FailedCount(ctx context.Context) (int64, error)
+
+	// RequeueFailed queues the dead-lettered users among usernames, or all of
+	// them if usernames is empty. Dead-lettered users are users whose last sync
+	// failed and that are neither queued, deferred nor in flight, i.e. no retry
+	// is pending. Their failure marks are kept until a sync succeeds. The
+	// requeued users are queued with origin, unless empty.
+	// Returns the requeued users.
+	RequeueFailed(ctx context.Context, usernames []string, origin Origin) ([]string, error)
+
+	// AppendHistory records a sync attempt of a user, keeping only the most
+	// recent limit entries.
+	AppendHistory(ctx context.Context, username string, entry HistoryEntry, limit int) error
+
+	// GetHistory returns the recorded sync attempts of a user, most recent first.
+	GetHistory(ctx context.Context, username string) ([]HistoryEntry, error)
+
+	// DeleteUser removes every trace of a user: queue entries, in-flight claim,
+	// replication states and last replication times of all destinations,
+	// failure marks, redirection mark, event info, last event time and sync
+	// history.
+	// Returns whether anything was stored for the user.
+	DeleteUser(ctx context.Context, username string) (bool, error)
+
+	// RenameUser moves the replication states and last replication times of all
+	// destinations, sync history, event info, last event time, redirection mark
+	// and queue entries of a renamed user to the new username, so that its next
+	// sync is incremental. Failure marks are dropped.
+	// Fails with ErrUserExists if the new username already has a replication
+	// state or time, and with ErrUserInFlight while the old one is being synced.
+	// Returns whether anything was stored for the old username.
+	RenameUser(ctx context.Context, oldName, newName string) (bool, error)
+
+	// MarkRedirected records that a user was synced to the fallback destination
+	// during a failover, so that the primary destination is reconciled after
+	// failing back, also by a process started meanwhile.
+	MarkRedirected(ctx context.Context, username string) error
+
+	// ListRedirected returns the users marked by MarkRedirected.
+	ListRedirected(ctx context.Context) ([]string, error)
+
+	// ClearRedirected removes the redirection marks of usernames.
+	ClearRedirected(ctx context.Context, usernames []string) error
+
+	// Status returns a summary of the queue including the next n users to be synced.
+	Status(ctx context.Context, next int) (*Status, error)
+
+	// ExportQueue returns up to limit queued users in dequeue order with their
+	// score, first-enqueue time and event info, without claiming them; limit 0
+	// returns all.
+	ExportQueue(ctx context.Context, limit int) ([]QueueEntry, error)
+
+	// ImportQueue queues the users of entries with their score, first-enqueue
+	// time and event info, keeping the better score of users already queued.
+	// Returns the number of imported entries.
+	ImportQueue(ctx context.Context, entries []QueueEntry) (int, error)
+
+	// RecordSyncLatency counts a sync completed at the given time, latency after
+	// its triggering event, into the SLA counters.
+	RecordSyncLatency(ctx context.Context, completedAt time.Time, latency time.Duration) error
+
+	// SLAReport aggregates the SLA counters of the syncs completed since the given time.
+	SLAReport(ctx context.Context, since time.Time) (*SLAReport, error)
+
+	// ListLastReplicationTimes returns the last replication timestamp of every user
+	// that has one stored.
+	ListLastReplicationTimes(ctx context.Context) (map[string]time.Time, error)
+
+	// GetLastEventTime returns the time of the most recent event of a user,
+	// or the zero time if none was received recently. Events are recorded by
+	// EnqueueEvent with an info carrying TriggeredAt.
+	GetLastEventTime(ctx context.Context, username string) (time.Time, error)
+
+	// GetSweepCursor returns the last user processed by an interrupted
+	// background replication sweep, or an empty string if no sweep was
+	// interrupted.
+	GetSweepCursor(ctx context.Context) (string, error)
+
+	// SetSweepCursor stores the last user processed by the running background
+	// replication sweep, so that a sweep interrupted e.g. by a restart resumes
+	// after it. An empty cursor removes the checkpoint of a completed sweep.
+	SetSweepCursor(ctx context.Context, cursor string) error
+}
+
+// EnqueueNotifier is implemented by queues that report enqueues, so that an idle
+// worker pool can fetch new users right away instead of waiting for its next poll.
+type EnqueueNotifier interface {
+	// NotifyEnqueue registers fn to be called after users were enqueued,
+	// replacing any function registered before. fn must not block.
+	NotifyEnqueue(fn func())
+}
+
+// DurableQueue is implemented by queues whose data may outlive the process, so
+// that users requeued at shutdown and claims left behind by a crash are found
+// by the next process.
+type DurableQueue interface {
+	// Durable reports whether the data is kept after the process exits.
+	Durable() bool
+}
+
+// DequeuedUser describes a user claimed by DequeueN, as reported to a
+// DequeueObserver.
+type DequeuedUser struct {
+	Username   string
+	Score      float64   // priority score the user was queued with
+	EnqueuedAt time.Time // first-enqueue time; zero if unknown
+}
+
+// DequeueObserver is implemented by queues that report the users they hand
+// out, e.g. to measure how long users waited in the queue.
+type DequeueObserver interface {
+	// NotifyDequeue registers fn to be called with the users claimed by every
+	// DequeueN, replacing any function registered before. fn must not block.
+	NotifyDequeue(fn func(users []DequeuedUser))
+}
+
+// QueuedUser describes a user waiting in the queue.
+type QueuedUser struct {
+	Username   string    `json:"username"`
+	Score      float64   `json:"score"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	FullSync   bool      `json:"full_sync"` // no replication state stored, next sync is a full sync
+}
+
+// Status summarizes the queue, similar to the former `doveadm replicator status`.
+type Status struct {
+	Queued            int          `json:"queued"`
+	QueuedFull        int          `json:"queued_full"`
+	QueuedIncremental int          `json:"queued_incremental"`
+	InFlight          int          `json:"in_flight"`
+	Failed            int          `json:"failed"`
+	Deferred          int          `json:"deferred"`
+	Spilled           int          `json:"spilled"` // users moved to disk by the overflow, not part of Queued
+	KnownUsers        int          `json:"known_users"`
+	Next              []QueuedUser `json:"next"`
 }
@@ -0,0 +1,115 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// QueueDepthSample is the queue depth at one point in time.
+type QueueDepthSample struct {
+	Time     time.Time `json:"time"`
+	Queued   int64     `json:"queued"`
+	InFlight int       `json:"in_flight"`
+	Failed   int64     `json:"failed"`
+}
+
+// QueueDepthHistory samples the queue depth periodically and keeps the samples
+// of a time window, e.g. to chart the queue depth on the status dashboard.
+type QueueDepthHistory struct {
+	queue    Queue
+	logger   *slog.Logger
+	interval time.Duration
+
+	mu      sync.Mutex
+	samples []QueueDepthSample // ring buffer, next is the oldest sample once full
+	next    int
+	full    bool
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewQueueDepthHistory creates a history sampling every interval and keeping
+// the samples of the last window.
+func NewQueueDepthHistory(q Queue, logger *slog.Logger, interval, window time.Duration) *QueueDepthHistory {
+	return &QueueDepthHistory{
+		queue:    q,
+		logger:   logger,
+		interval: interval,
+		samples:  make([]QueueDepthSample, max(int(window/interval), 1)),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// Start samples once immediately and then every interval until Stop is called.
+func (h *QueueDepthHistory) Start(ctx context.Context) {
+	go func() {
+		defer close(h.doneCh)
+
+		h.sample(ctx)
+
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.stopCh:
+				return
+			case <-ticker.C:
+				h.sample(ctx)
+			}
+		}
+	}()
+}
+
+// Stop stops sampling.
+func (h *QueueDepthHistory) Stop(ctx context.Context) error {
+	close(h.stopCh)
+
+	select {
+	case <-h.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Samples returns the kept samples, oldest first.
+func (h *QueueDepthHistory) Samples() []QueueDepthSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]QueueDepthSample(nil), h.samples[:h.next]...)
+	}
+	return append(append([]QueueDepthSample(nil), h.samples[h.next:]...), h.samples[:h.next]...)
+}
+
+func (h *QueueDepthHistory) sample(ctx context.Context) {
+	s := QueueDepthSample{Time: time.Now()}
+	var err error
+	if s.Queued, err = h.queue.Len(ctx); err != nil {
+		h.logger.Warn("Failed to sample queue length", "error", err)
+		return
+	}
+	inFlight, err := h.queue.InFlight(ctx)
+	if err != nil {
+		h.logger.Warn("Failed to sample in-flight users", "error", err)
+		return
+	}
+	s.InFlight = len(inFlight)
+	if s.Failed, err = h.queue.FailedCount(ctx); err != nil {
+		h.logger.Warn("Failed to sample failed users", "error", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+}
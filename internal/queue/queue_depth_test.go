@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueueDepthHistory(t *testing.T) {
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+	h := NewQueueDepthHistory(q, testLogger(), time.Second, 3*time.Second)
+
+	ctx := context.Background()
+	for i, user := range []string{"alice", "bob", "carol", "dave"} {
+		if err := q.Enqueue(ctx, user, 1); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		if i == 1 {
+			if err := q.RecordFailure(ctx, "alice"); err != nil {
+				t.Fatalf("record failure: %v", err)
+			}
+		}
+		h.sample(ctx)
+	}
+
+	// the window keeps the last 3 samples, oldest first
+	samples := h.Samples()
+	if len(samples) != 3 {
+		t.Fatalf("expected 3 samples, got %+v", samples)
+	}
+	for i, s := range samples {
+		if s.Queued != int64(i+2) {
+			t.Fatalf("expected sample %d to have %d queued users, got %+v", i, i+2, s)
+		}
+	}
+	if samples[0].Failed != 1 || samples[0].Time.After(samples[2].Time) {
+		t.Fatalf("unexpected samples %+v", samples)
+	}
+}
+
+func TestRecentSyncTracker(t *testing.T) {
+	tracker := NewRecentSyncTracker(2)
+	hook := tracker.Hook()
+	ctx := context.Background()
+
+	hook(ctx, SyncInfo{Username: "dry", DryRun: true}, time.Second, nil)
+	hook(ctx, SyncInfo{Username: "user-a", Destination: "imap"}, time.Second, nil)
+	hook(ctx, SyncInfo{Username: "user-b", Destination: "imap"}, time.Second, nil)
+	hook(ctx, SyncInfo{Username: "user-c", Destination: "imap", FullSync: true}, time.Second, nil)
+
+	recent := tracker.Recent()
+	if len(recent) != 2 || recent[0].Username != "user-c" || recent[1].Username != "user-b" {
+		t.Fatalf("expected the 2 most recent syncs, newest first, got %+v", recent)
+	}
+	if recent[0].SyncType != "full" || recent[0].Result != HistoryResultSuccess {
+		t.Fatalf("unexpected sync %+v", recent[0])
+	}
+}
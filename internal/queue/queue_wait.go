@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+)
+
+// Priority buckets of the queue wait metrics.
+const (
+	PriorityBucketHigh    = "high"    // priority factor above 1, e.g. INBOX deliveries
+	PriorityBucketNormal  = "normal"  // priority factor 1
+	PriorityBucketLow     = "low"     // priority factor below 1, e.g. flag changes
+	PriorityBucketOverdue = "overdue" // promoted to the head by queue aging
+)
+
+// QueueWaitRecorder returns a function for DequeueObserver.NotifyDequeue that
+// records how long dequeued users waited, by priority bucket, and counts those
+// that waited longer than threshold. A threshold of 0 disables the counter.
+// Users without a known first-enqueue time are skipped.
+func QueueWaitRecorder(m *metrics.Metrics, threshold time.Duration) func(users []DequeuedUser) {
+	return func(users []DequeuedUser) {
+		now := time.Now()
+		for _, user := range users {
+			if user.EnqueuedAt.IsZero() {
+				continue
+			}
+			wait := max(now.Sub(user.EnqueuedAt), 0)
+			bucket := PriorityBucket(user)
+			m.QueueWait.WithLabelValues(bucket).Observe(wait.Seconds())
+			if threshold > 0 && wait > threshold {
+				m.QueueWaitExceeded.WithLabelValues(bucket).Inc()
+			}
+		}
+	}
+}
+
+// PriorityBucket returns the priority bucket of a dequeued user. The priority
+// factor is not stored with the user, but derived from its score, which is the
+// enqueue time divided by the factor; the error from a better score set by a
+// later event is negligible against the enqueue timestamp.
+func PriorityBucket(user DequeuedUser) string {
+	if user.Score < 0 {
+		return PriorityBucketOverdue
+	}
+	if user.EnqueuedAt.IsZero() || user.Score == 0 {
+		return PriorityBucketNormal
+	}
+	factor := float64(user.EnqueuedAt.Unix()) / user.Score
+	switch {
+	case factor > 1.01:
+		return PriorityBucketHigh
+	case factor < 0.99:
+		return PriorityBucketLow
+	default:
+		return PriorityBucketNormal
+	}
+}
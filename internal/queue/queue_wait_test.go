@@ -0,0 +1,100 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestPriorityBucket(t *testing.T) {
+	enqueuedAt := time.Now()
+	score := func(factor float64) float64 {
+		return float64(enqueuedAt.UnixNano()) / 1e9 / factor
+	}
+	tests := []struct {
+		user DequeuedUser
+		want string
+	}{
+		{DequeuedUser{Score: score(2), EnqueuedAt: enqueuedAt}, PriorityBucketHigh},
+		{DequeuedUser{Score: score(1), EnqueuedAt: enqueuedAt}, PriorityBucketNormal},
+		{DequeuedUser{Score: score(0.5), EnqueuedAt: enqueuedAt}, PriorityBucketLow},
+		{DequeuedUser{Score: float64(enqueuedAt.Unix()) - overdueScoreOffset, EnqueuedAt: enqueuedAt}, PriorityBucketOverdue},
+		{DequeuedUser{Score: score(0.5)}, PriorityBucketNormal},
+	}
+	for _, tt := range tests {
+		if got := PriorityBucket(tt.user); got != tt.want {
+			t.Errorf("PriorityBucket(%+v) = %q, want %q", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestQueueWaitRecorder(t *testing.T) {
+	q, err := NewInMemoryQueue("testwait", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+	m := metrics.New(prometheus.NewRegistry())
+	q.NotifyDequeue(QueueWaitRecorder(m, time.Minute))
+
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, "user-high", 2); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.Enqueue(ctx, "user-low", 0.5); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	// backdate the first-enqueue time so user-low waited beyond the threshold
+	if err := q.client.ZAdd(ctx, q.ns+":"+ENQUEUED_AT, redis.Z{
+		Score:  float64(time.Now().Add(-2 * time.Minute).Unix()),
+		Member: "user-low",
+	}).Err(); err != nil {
+		t.Fatalf("backdate: %v", err)
+	}
+
+	users, err := q.DequeueN(ctx, 2)
+	if err != nil || len(users) != 2 {
+		t.Fatalf("expected 2 users, got %v (err %v)", users, err)
+	}
+	if got := testutil.CollectAndCount(m.QueueWait); got != 2 {
+		t.Fatalf("expected a wait histogram for 2 priority buckets, got %d", got)
+	}
+	if got := testutil.ToFloat64(m.QueueWaitExceeded.WithLabelValues(PriorityBucketLow)); got != 1 {
+		t.Fatalf("expected 1 low-priority user beyond the threshold, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.QueueWaitExceeded.WithLabelValues(PriorityBucketHigh)); got != 0 {
+		t.Fatalf("expected no high-priority user beyond the threshold, got %v", got)
+	}
+}
+
+func TestNativeQueueNotifyDequeue(t *testing.T) {
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+
+	var dequeued []DequeuedUser
+	q.NotifyDequeue(func(users []DequeuedUser) {
+		dequeued = append(dequeued, users...)
+	})
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, "alice", 0.5); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := q.DequeueN(ctx, 10); err != nil {
+		t.Fatalf("dequeue: %v", err)
+	}
+	if len(dequeued) != 1 || dequeued[0].Username != "alice" || dequeued[0].EnqueuedAt.IsZero() {
+		t.Fatalf("unexpected dequeued users %+v", dequeued)
+	}
+	if bucket := PriorityBucket(dequeued[0]); bucket != PriorityBucketLow {
+		t.Fatalf("expected low priority bucket, got %q", bucket)
+	}
+}
@@ -0,0 +1,73 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SyncRateLimit enforces a minimum interval between two syncs of the same user,
+// so that a user continuously generating events (e.g. a mass import) is synced
+// at most once per interval instead of back to back. Events arriving in between
+// are still coalesced into the deferred sync.
+type SyncRateLimit struct {
+	defaultInterval time.Duration
+	domains         map[string]time.Duration
+}
+
+// NewSyncRateLimit creates a rate limit with a global minimum interval and
+// per-domain overrides. An interval of 0 disables rate limiting.
+func NewSyncRateLimit(defaultInterval time.Duration, domains map[string]time.Duration) *SyncRateLimit {
+	normalized := make(map[string]time.Duration, len(domains))
+	for domain, interval := range domains {
+		normalized[strings.ToLower(domain)] = interval
+	}
+	return &SyncRateLimit{defaultInterval: defaultInterval, domains: normalized}
+}
+
+// Enabled reports whether any user is subject to a minimum interval.
+func (l *SyncRateLimit) Enabled() bool {
+	if l.defaultInterval > 0 {
+		return true
+	}
+	for _, interval := range l.domains {
+		if interval > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Interval returns the minimum interval between syncs of a user, taking the
+// domain override for user@domain usernames into account.
+func (l *SyncRateLimit) Interval(username string) time.Duration {
+	if at := strings.LastIndexByte(username, '@'); at >= 0 {
+		if interval, ok := l.domains[strings.ToLower(username[at+1:])]; ok {
+			return interval
+		}
+	}
+	return l.defaultInterval
+}
+
+// ParseDomainIntervals parses a comma-separated list of domain=duration pairs,
+// e.g. "example.com=5m,example.org=0s".
+func ParseDomainIntervals(spec string) (map[string]time.Duration, error) {
+	intervals := make(map[string]time.Duration)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		domain, durationStr, ok := strings.Cut(entry, "=")
+		domain = strings.TrimSpace(domain)
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("invalid domain interval %q: expected domain=duration", entry)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(durationStr))
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid domain interval %q: invalid duration", entry)
+		}
+		intervals[domain] = interval
+	}
+	return intervals, nil
+}
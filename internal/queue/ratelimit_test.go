@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncRateLimitInterval(t *testing.T) {
+	domains, err := ParseDomainIntervals("Example.com=5m, example.org=0s")
+	if err != nil {
+		t.Fatalf("ParseDomainIntervals() error: %v", err)
+	}
+	limit := NewSyncRateLimit(time.Minute, domains)
+
+	tests := []struct {
+		username string
+		want     time.Duration
+	}{
+		{"alice", time.Minute},
+		{"alice@example.net", time.Minute},
+		{"alice@example.com", 5 * time.Minute},
+		{"alice@EXAMPLE.COM", 5 * time.Minute},
+		{"alice@example.org", 0},
+	}
+	for _, tt := range tests {
+		if got := limit.Interval(tt.username); got != tt.want {
+			t.Errorf("Interval(%q) = %v, want %v", tt.username, got, tt.want)
+		}
+	}
+
+	if !limit.Enabled() {
+		t.Fatal("expected rate limit to be enabled")
+	}
+	if NewSyncRateLimit(0, map[string]time.Duration{"example.com": 0}).Enabled() {
+		t.Fatal("expected rate limit without intervals to be disabled")
+	}
+
+	for _, spec := range []string{"example.com", "=5m", "example.com=soon", "example.com=-1m"} {
+		if _, err := ParseDomainIntervals(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestDeferAndPromoteDeferred(t *testing.T) {
+	q, err := NewInMemoryQueue("testdeferred", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	if err := q.Defer(ctx, "user-due", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("defer: %v", err)
+	}
+	if err := q.Defer(ctx, "user-later", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("defer: %v", err)
+	}
+
+	status, err := q.Status(ctx, 0)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if status.Deferred != 2 || status.Queued != 0 {
+		t.Fatalf("expected 2 deferred and no queued users, got %+v", status)
+	}
+
+	promoted, err := q.PromoteDeferred(ctx)
+	if err != nil {
+		t.Fatalf("promote deferred: %v", err)
+	}
+	if promoted != 1 {
+		t.Fatalf("expected 1 promoted user, got %d", promoted)
+	}
+	if order := getQueueOrder(t, q); len(order) != 1 || order[0] != "user-due" {
+		t.Fatalf("expected only user-due to be queued, got %v", order)
+	}
+}
+
+func TestWorkerPoolDefersRateLimitedUsers(t *testing.T) {
+	q, err := NewInMemoryQueue("testratelimit", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	if err := q.SetLastReplicationTime(ctx, "user-recent", time.Now()); err != nil {
+		t.Fatalf("set last replication: %v", err)
+	}
+	if err := q.Enqueue(ctx, "user-recent", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.Enqueue(ctx, "user-new", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	var mu sync.Mutex
+	var handled []string
+	wp := NewWorkerPool(q, 2, testLogger())
+	wp.SetHandler(&TestHandler{onHandle: func(username string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, username)
+		return nil
+	}})
+	wp.SetRateLimit(NewSyncRateLimit(time.Hour, nil))
+	wp.Start(ctx)
+	time.Sleep(500 * time.Millisecond)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := wp.Stop(shutdownCtx); err != nil {
+		t.Fatalf("failed to stop worker pool: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(handled) != 1 || handled[0] != "user-new" {
+		t.Fatalf("expected only user-new to be handled, got %v", handled)
+	}
+	deferred := q.client.ZScore(ctx, "testratelimit:"+DEFERRED, "user-recent").Val()
+	if until := time.Unix(int64(deferred), 0); until.Before(time.Now().Add(59 * time.Minute)) {
+		t.Fatalf("expected user-recent to be deferred by the interval, got %v", until)
+	}
+}
@@ -0,0 +1,32 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// RecentSyncTracker keeps the most recent sync attempts, e.g. for the status
+// dashboard.
+type RecentSyncTracker struct {
+	syncs *syncRing
+}
+
+// NewRecentSyncTracker creates a tracker keeping the last size syncs.
+func NewRecentSyncTracker(size int) *RecentSyncTracker {
+	return &RecentSyncTracker{syncs: newSyncRing(size)}
+}
+
+// Hook returns the PostSyncHook recording every sync. Dry runs are ignored.
+func (t *RecentSyncTracker) Hook() PostSyncHook {
+	return func(ctx context.Context, attempt SyncInfo, duration time.Duration, err error) {
+		if attempt.DryRun {
+			return
+		}
+		t.syncs.add(newSyncRecord(attempt, duration, err))
+	}
+}
+
+// Recent returns the kept syncs, most recent first.
+func (t *RecentSyncTracker) Recent() []SyncRecord {
+	return t.syncs.recent()
+}
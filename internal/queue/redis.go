@@ -4,23 +4,209 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 )
 
 const SYNC_TASKS = "sync_tasks"
 
-// InMemoryQueue is a Redis-compatible queue using miniredis for development and testing.
+// IN_FLIGHT is the hash of claimed users (member -> claim unix timestamp).
+const IN_FLIGHT = "in_flight"
+
+// FAILED is the hash of users whose last sync failed (member -> failure unix timestamp).
+const FAILED = "failed"
+
+// INCREMENTAL_FAILURES is the hash of consecutive failed incremental syncs per user.
+const INCREMENTAL_FAILURES = "incremental_failures"
+
+// eventInfoTTL bounds the lifetime of event info of users that are never dequeued.
+const eventInfoTTL = 7 * 24 * time.Hour
+
+// lastEventTTL bounds the lifetime of the last event time of a user; users
+// without events for longer are treated like users that never had one.
+const lastEventTTL = 30 * 24 * time.Hour
+
+// DEFERRED is the sorted set of rate-limited users scored by the unix time from
+// which on they may be synced again.
+const DEFERRED = "deferred"
+
+// REDIRECTED is the set of users synced to the fallback destination during a
+// failover, whose primary destination is reconciled after failing back.
+const REDIRECTED = "redirected"
+
+// ENQUEUED_AT is the sorted set of queued users scored by the time they were first
+// enqueued. It is independent of the priority score and used for queue aging.
+const ENQUEUED_AT = "enqueued_at"
+
+// overdueScoreOffset is subtracted from the first-enqueue timestamp of overdue users
+// so their scores are negative and sort before every regular (positive) score while
+// remaining in FIFO order among themselves.
+const overdueScoreOffset = 1e10
+
+// claimScript atomically removes up to ARGV[1] members with the lowest score from
+// the sync task set (KEYS[1]) and records each of them in the in-flight hash
+// (KEYS[2]) with the claim timestamp ARGV[2], dropping their first-enqueue time
+// (KEYS[3]) and taking their event info (keys with the prefix ARGV[3]).
+// Members already in the in-flight hash stay queued until their claim is
+// released, so that a user requeued while its sync runs is not synced twice at
+// once. Returns {member, score, first-enqueue time (0 if unknown), event info
+// fields} per claimed member. Running this server-side closes the race window
+// between reading and removing the head when several consumers share a queue.
+var claimScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+local claimed = {}
+local skipped = 0
+while #claimed < n do
+	local head = redis.call('ZRANGE', KEYS[1], skipped, skipped + n - #claimed - 1, 'WITHSCORES')
+	if #head == 0 then
+		break
+	end
+	for i = 1, #head, 2 do
+		local member = head[i]
+		if redis.call('HEXISTS', KEYS[2], member) == 1 then
+			skipped = skipped + 1
+		else
+			local infoKey = ARGV[3] .. member
+			redis.call('ZREM', KEYS[1], member)
+			redis.call('HSET', KEYS[2], member, ARGV[2])
+			local enqueuedAt = redis.call('ZSCORE', KEYS[3], member) or '0'
+			local info = redis.call('HGETALL', infoKey)
+			redis.call('ZREM', KEYS[3], member)
+			redis.call('DEL', infoKey)
+			claimed[#claimed + 1] = {member, head[i + 1], enqueuedAt, info}
+		end
+	end
+end
+return claimed
+`)
+
+// promoteDeferredScript atomically moves all members of the deferred set (KEYS[1])
+// with a score up to ARGV[1] into the sync task set (KEYS[2]) with that score,
+// recording it as their first-enqueue time (KEYS[3]) unless they are queued already.
+var promoteDeferredScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, member in ipairs(due) do
+	redis.call('ZREM', KEYS[1], member)
+	redis.call('ZADD', KEYS[2], 'LT', ARGV[1], member)
+	redis.call('ZADD', KEYS[3], 'NX', ARGV[1], member)
+end
+return #due
+`)
+
+// requeueFailedScript atomically queues the members of the failed hash (KEYS[1])
+// among ARGV[5..], or all of them if none are given, that are neither in the
+// sync task set (KEYS[2]), the deferred set (KEYS[3]) nor the in-flight hash
+// (KEYS[4]), with score and first-enqueue time (KEYS[5]) ARGV[1]. Unless
+// empty, the origin field ARGV[3] is set in their event info (keys with the
+// prefix ARGV[2]), which expires after ARGV[4] seconds.
+// Returns the queued members.
+var requeueFailedScript = redis.NewScript(`
+local candidates = {}
+if #ARGV > 4 then
+	for i = 5, #ARGV do
+		candidates[#candidates + 1] = ARGV[i]
+	end
+else
+	candidates = redis.call('HKEYS', KEYS[1])
+end
+local requeued = {}
+for _, member in ipairs(candidates) do
+	if redis.call('HEXISTS', KEYS[1], member) == 1
+		and not redis.call('ZSCORE', KEYS[2], member)
+		and not redis.call('ZSCORE', KEYS[3], member)
+		and redis.call('HEXISTS', KEYS[4], member) == 0 then
+		redis.call('ZADD', KEYS[2], ARGV[1], member)
+		redis.call('ZADD', KEYS[5], 'NX', ARGV[1], member)
+		if ARGV[3] ~= '' then
+			redis.call('HSET', ARGV[2] .. member, ARGV[3], '1')
+			redis.call('EXPIRE', ARGV[2] .. member, ARGV[4])
+		end
+		requeued[#requeued + 1] = member
+	end
+end
+return requeued
+`)
+
+// renameUserScript atomically moves the data of user ARGV[1] to user ARGV[2].
+// KEYS[1..6] are the state, state checksum, last replication, history, event
+// info and last event keys of the old user, KEYS[7..12] the same keys of the
+// new user. KEYS[13..15] are the sync task, first-enqueue and deferred sets, in
+// which the new user keeps the lower score, and KEYS[16..18] the in-flight,
+// failed and incremental failure hashes, and KEYS[19] the redirected set. Keys
+// the new user has already are kept.
+// Returns -1 if the old user is in flight, -2 if the new user has a state or
+// last replication time, and otherwise 1 if anything was moved, else 0.
+var renameUserScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[16], ARGV[1]) == 1 then
+	return -1
+end
+if redis.call('EXISTS', KEYS[7]) == 1 or redis.call('EXISTS', KEYS[9]) == 1 then
+	return -2
+end
+local found = 0
+for i = 1, 6 do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		found = 1
+		if redis.call('EXISTS', KEYS[i + 6]) == 0 then
+			redis.call('RENAME', KEYS[i], KEYS[i + 6])
+		else
+			redis.call('DEL', KEYS[i])
+		end
+	end
+end
+for i = 13, 15 do
+	local score = redis.call('ZSCORE', KEYS[i], ARGV[1])
+	if score then
+		found = 1
+		redis.call('ZREM', KEYS[i], ARGV[1])
+		redis.call('ZADD', KEYS[i], 'LT', score, ARGV[2])
+	end
+end
+for i = 17, 18 do
+	if redis.call('HDEL', KEYS[i], ARGV[1]) == 1 then
+		found = 1
+	end
+end
+if redis.call('SREM', KEYS[19], ARGV[1]) == 1 then
+	found = 1
+	redis.call('SADD', KEYS[19], ARGV[2])
+end
+return found
+`)
+
+// InMemoryQueue is a Redis-compatible queue using miniredis for development and
+// testing, or an external Redis server, see NewExternalQueue.
 type InMemoryQueue struct {
 	server *miniredis.Miniredis
 	client *redis.Client
 	ns     string
 	logger *slog.Logger
 
+	// optional pipelined enqueue batching
+	batcher *enqueueBatcher
+
+	// optional overflow of the queue tail to disk
+	spiller *spiller
+
+	// optional wakeups of the consumers sharing the Redis server
+	wakeups *wakeups
+
+	// optional callback after users were enqueued
+	onEnqueue atomic.Pointer[func()]
+	// optional callback after users were dequeued
+	onDequeue atomic.Pointer[func([]DequeuedUser)]
+
+	// optional bounds of priority factors
+	priorityBounds atomic.Pointer[PriorityBounds]
+
 	// operation counters
 	enqueueCount uint64
 	dequeueCount uint64
@@ -66,43 +252,423 @@ func NewInMemoryQueue(namespace string, addr string, logger *slog.Logger) (*InMe
 // factor=1.0 = normal priority (scores are timestamps)
 // factor>1.0 = higher priority (scores are reduced by factor)
 // factor<1.0 = lower priority (scores are increased by factor)
+// If the user is already queued, ZADD LT keeps the lower (earlier) of the existing
+// and the new score, so repeated events can only move a user forward, never back.
+// Factors are validated and clamped, see SetPriorityBounds.
 func (q *InMemoryQueue) Enqueue(ctx context.Context, username string, priorityFactor float64) error {
-	key := fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS)
+	return q.EnqueueEvent(ctx, username, priorityFactor, nil)
+}
+
+// EnqueueEvent adds or updates a user like Enqueue and merges the event context
+// into the user's event info hash in the same round trip. info may be nil.
+func (q *InMemoryQueue) EnqueueEvent(ctx context.Context, username string, priorityFactor float64, info *EventInfo) error {
+	priorityFactor, err := checkPriority(q.priorityBounds.Load(), priorityFactor)
+	if err != nil {
+		return err
+	}
 
 	// Use current timestamp as base score
 	timestamp := float64(time.Now().UnixNano()) / 1e9
 
 	// Apply priority factor: divide by factor to adjust priority
-	if priorityFactor <= 0 {
-		priorityFactor = 1.0 // Safety: avoid division by zero
-	}
 	score := timestamp / priorityFactor
 
-	if err := q.client.ZAddLT(ctx, key, redis.Z{
-		Score:  score,
-		Member: username,
-	}).Err(); err != nil {
+	if q.batcher != nil {
+		// counted by the batcher once the pipeline was flushed
+		if err := q.batcher.add(ctx, username, score, info); err != nil {
+			return fmt.Errorf("failed to enqueue event: %w", err)
+		}
+		return nil
+	}
+
+	pipe := q.client.TxPipeline()
+	cmd := q.pipeEnqueue(ctx, pipe, username, score, info)
+	_ = execPipeline(ctx, pipe)
+	if err := cmd.Err(); err != nil {
 		return fmt.Errorf("failed to enqueue event: %w", err)
 	}
 	atomic.AddUint64(&q.enqueueCount, 1)
+	q.notifyEnqueued()
 	return nil
 }
 
-// Dequeue removes and returns the username with the lowest priority score (highest priority).
-// Returns empty string if queue is empty.
-func (q *InMemoryQueue) Dequeue(ctx context.Context) (string, error) {
-	key := fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS)
+// EnqueueItem adds or updates the user of item like EnqueueEvent, with its
+// priority factor or 1.0 if unknown.
+func (q *InMemoryQueue) EnqueueItem(ctx context.Context, item QueueItem) error {
+	return q.EnqueueEvent(ctx, item.Username, item.priorityFactor(), item.Info)
+}
+
+// NotifyEnqueue registers fn to be called after users were enqueued, replacing
+// any function registered before. With batching, fn is called once per flushed
+// batch. With wakeups enabled, fn is also called when another process announced
+// an enqueue.
+func (q *InMemoryQueue) NotifyEnqueue(fn func()) {
+	q.onEnqueue.Store(&fn)
+}
+
+func (q *InMemoryQueue) notifyEnqueued() {
+	q.notifyEnqueueFn()
+	if q.wakeups != nil {
+		q.wakeups.signal()
+	}
+}
+
+// SetPriorityBounds clamps the priority factors of later enqueues to b; nil
+// only rejects factors that are not finite and positive.
+func (q *InMemoryQueue) SetPriorityBounds(b *PriorityBounds) {
+	q.priorityBounds.Store(b)
+}
+
+// notifyEnqueueFn calls the function registered with NotifyEnqueue, if any.
+func (q *InMemoryQueue) notifyEnqueueFn() {
+	if fn := q.onEnqueue.Load(); fn != nil {
+		(*fn)()
+	}
+}
+
+// pipeEnqueue adds the commands for one enqueue to a pipeline: the priority score
+// (ZADD LT, keeping the best score), the first-enqueue time (ZADD NX, keeping
+// the oldest time) used by PromoteOverdue and, if given, the event info.
+func (q *InMemoryQueue) pipeEnqueue(ctx context.Context, pipe redis.Pipeliner, username string, score float64, info *EventInfo) *redis.IntCmd {
+	q.pipeEventInfo(ctx, pipe, username, info)
+	pipe.ZAddNX(ctx, fmt.Sprintf("%s:%s", q.ns, ENQUEUED_AT), redis.Z{
+		Score:  float64(time.Now().Unix()),
+		Member: username,
+	})
+	return pipe.ZAddLT(ctx, fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS), redis.Z{
+		Score:  score,
+		Member: username,
+	})
+}
+
+// execPipeline runs the commands of pipe and returns the first error. go-redis
+// leaves the errors of the commands unset if the pipeline failed as a whole,
+// e.g. while the server is unreachable, so they are set to that error then.
+func execPipeline(ctx context.Context, pipe redis.Pipeliner) error {
+	cmds, err := pipe.Exec(ctx)
+	if err != nil && !slices.ContainsFunc(cmds, func(cmd redis.Cmder) bool { return cmd.Err() != nil }) {
+		for _, cmd := range cmds {
+			cmd.SetErr(err)
+		}
+	}
+	return err
+}
+
+// pipeEventInfo adds the commands merging info, which may be nil, into the
+// event info hash of a user to a pipeline.
+func (q *InMemoryQueue) pipeEventInfo(ctx context.Context, pipe redis.Pipeliner, username string, info *EventInfo) {
+	if info == nil {
+		return
+	}
+	key := fmt.Sprintf("%s:event_info:%s", q.ns, username)
+	fields := info.hashFields()
+	if len(fields) > 0 {
+		pipe.HSet(ctx, key, fields...)
+	}
+	// keep the time of the first coalesced event
+	if !info.TriggeredAt.IsZero() {
+		pipe.HSetNX(ctx, key, eventInfoFieldTriggeredAt, info.TriggeredAt.UnixNano())
+	}
+	if !info.OccurredAt.IsZero() {
+		pipe.HSetNX(ctx, key, eventInfoFieldOccurredAt, info.OccurredAt.UnixNano())
+	}
+	if len(fields) > 0 || !info.TriggeredAt.IsZero() || !info.OccurredAt.IsZero() {
+		pipe.Expire(ctx, key, eventInfoTTL)
+	}
+	if !info.TriggeredAt.IsZero() {
+		pipe.Set(ctx, fmt.Sprintf("%s:last_event:%s", q.ns, username), info.TriggeredAt.Unix(), lastEventTTL)
+	}
+}
+
+// EnableEnqueueBatching buffers Enqueue calls and writes them to Redis in a single
+// pipeline once maxItems are buffered or flushInterval has elapsed. This reduces
+// round trips during event bursts at the cost of up to flushInterval extra latency.
+// A maxItems value below 2 leaves batching disabled. Must be called before the
+// queue is used concurrently.
+func (q *InMemoryQueue) EnableEnqueueBatching(maxItems int, flushInterval time.Duration) {
+	if maxItems < 2 || flushInterval <= 0 || q.batcher != nil {
+		return
+	}
+	q.batcher = newEnqueueBatcher(q.client, maxItems, flushInterval, q.pipeEnqueue, func(n int) {
+		atomic.AddUint64(&q.enqueueCount, uint64(n))
+		q.notifyEnqueued()
+	})
+}
+
+// EnableMetrics records the latency and errors of the commands sent to Redis
+// in m and registers the statistics of the client's connection pool with reg.
+// Must be called before the queue is used concurrently.
+func (q *InMemoryQueue) EnableMetrics(m *metrics.Metrics, reg prometheus.Registerer) error {
+	m.InstrumentRedis(q.client)
+	if err := reg.Register(metrics.NewRedisPoolCollector(q.client.PoolStats)); err != nil {
+		return fmt.Errorf("failed to register Redis pool metrics: %w", err)
+	}
+	return nil
+}
+
+// TakeEventInfo returns and removes the event info accumulated for a user.
+// Returns nil if no info was stored.
+func (q *InMemoryQueue) TakeEventInfo(ctx context.Context, username string) (*EventInfo, error) {
+	key := fmt.Sprintf("%s:event_info:%s", q.ns, username)
+	var getCmd *redis.MapStringStringCmd
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		getCmd = pipe.HGetAll(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to take event info: %w", err)
+	}
+	if len(getCmd.Val()) == 0 {
+		return nil, nil
+	}
+	return eventInfoFromHash(getCmd.Val()), nil
+}
+
+// Dequeue removes and returns the user with the lowest priority score (highest priority).
+// The user is atomically moved into the in-flight hash until Ack is called.
+// Returns an item without username if queue is empty.
+func (q *InMemoryQueue) Dequeue(ctx context.Context) (QueueItem, error) {
+	items, err := q.DequeueN(ctx, 1)
+	if err != nil {
+		return QueueItem{}, err
+	}
+	if len(items) == 0 {
+		return QueueItem{}, nil
+	}
+	return items[0], nil
+}
+
+// DequeueN removes and returns up to n users with the lowest priority scores
+// together with their event info, atomically moving each of them into the
+// in-flight hash until Ack is called. Returns an empty slice if the queue is empty.
+func (q *InMemoryQueue) DequeueN(ctx context.Context, n int) ([]QueueItem, error) {
+	if n <= 0 {
+		return nil, nil
+	}
 	// Using BZPopMin would be preferable to avoid busy-waiting, but miniredis does not support it
 	// https://github.com/alicebob/miniredis/issues/428
-	result, err := q.client.ZPopMin(ctx, key).Result()
+	keys := []string{
+		fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS),
+		fmt.Sprintf("%s:%s", q.ns, IN_FLIGHT),
+		fmt.Sprintf("%s:%s", q.ns, ENQUEUED_AT),
+	}
+	res, err := claimScript.Run(ctx, q.client, keys, n, time.Now().Unix(), fmt.Sprintf("%s:event_info:", q.ns)).Slice()
 	if err != nil {
-		return "", fmt.Errorf("failed to dequeue: %w", err)
+		return nil, fmt.Errorf("failed to dequeue: %w", err)
 	}
-	if len(result) == 0 {
-		return "", nil
+	onDequeue := q.onDequeue.Load()
+	items := make([]QueueItem, 0, len(res))
+	var dequeued []DequeuedUser
+	for _, user := range parseSpilled(res) {
+		var enqueuedAt time.Time
+		if user.EnqueuedAt > 0 {
+			enqueuedAt = time.Unix(int64(user.EnqueuedAt), 0)
+		}
+		var info *EventInfo
+		if len(user.EventInfo) > 0 {
+			info = eventInfoFromHash(user.EventInfo)
+		}
+		items = append(items, NewQueueItem(user.Username, priorityFromScore(user.Score, enqueuedAt), enqueuedAt, info))
+		if onDequeue != nil {
+			dequeued = append(dequeued, DequeuedUser{Username: user.Username, Score: user.Score, EnqueuedAt: enqueuedAt})
+		}
+	}
+	atomic.AddUint64(&q.dequeueCount, uint64(len(items)))
+	if onDequeue != nil && len(dequeued) > 0 {
+		(*onDequeue)(dequeued)
+	}
+	return items, nil
+}
+
+// NotifyDequeue registers fn to be called with the users claimed by every
+// DequeueN, replacing any function registered before.
+func (q *InMemoryQueue) NotifyDequeue(fn func(users []DequeuedUser)) {
+	q.onDequeue.Store(&fn)
+}
+
+// Len returns the number of queued users, excluding in-flight and deferred ones.
+func (q *InMemoryQueue) Len(ctx context.Context) (int64, error) {
+	n, err := q.client.ZCard(ctx, fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get queue length: %w", err)
+	}
+	return n, nil
+}
+
+// OldestEnqueuedAt returns the first-enqueue time of the user waiting the
+// longest, or the zero time if the queue is empty.
+func (q *InMemoryQueue) OldestEnqueuedAt(ctx context.Context) (time.Time, error) {
+	oldest, err := q.client.ZRangeWithScores(ctx, fmt.Sprintf("%s:%s", q.ns, ENQUEUED_AT), 0, 0).Result()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read oldest enqueue time: %w", err)
+	}
+	if len(oldest) == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(int64(oldest[0].Score), 0), nil
+}
+
+// PromoteOverdue moves every user that has been waiting longer than maxAge to the
+// head of the queue, ordered by how long they have been waiting, so that a constant
+// stream of high-priority events cannot starve low-priority users indefinitely.
+// Returns the number of users promoted by this call.
+func (q *InMemoryQueue) PromoteOverdue(ctx context.Context, maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-maxAge).Unix()
+	overdue, err := q.client.ZRangeByScoreWithScores(ctx, fmt.Sprintf("%s:%s", q.ns, ENQUEUED_AT), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(cutoff, 10),
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list overdue users: %w", err)
+	}
+	if len(overdue) == 0 {
+		return 0, nil
+	}
+
+	key := fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS)
+	pipe := q.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(overdue))
+	for i, z := range overdue {
+		// XX: only touch users that are still queued; LT: never demote
+		cmds[i] = pipe.ZAddArgs(ctx, key, redis.ZAddArgs{
+			XX:      true,
+			LT:      true,
+			Ch:      true,
+			Members: []redis.Z{{Score: z.Score - overdueScoreOffset, Member: z.Member}},
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to promote overdue users: %w", err)
+	}
+
+	promoted := 0
+	for _, cmd := range cmds {
+		promoted += int(cmd.Val())
+	}
+	return promoted, nil
+}
+
+// Defer postpones the sync of a user until the given time. If the user is
+// already deferred, the earlier time is kept.
+func (q *InMemoryQueue) Defer(ctx context.Context, username string, until time.Time) error {
+	return q.DeferEvent(ctx, username, until, nil)
+}
+
+// DeferEvent defers a user like Defer and merges info into the user's event
+// info hash in the same round trip. info may be nil.
+func (q *InMemoryQueue) DeferEvent(ctx context.Context, username string, until time.Time, info *EventInfo) error {
+	pipe := q.client.TxPipeline()
+	q.pipeEventInfo(ctx, pipe, username, info)
+	cmd := pipe.ZAddArgs(ctx, fmt.Sprintf("%s:%s", q.ns, DEFERRED), redis.ZAddArgs{
+		LT:      true,
+		Members: []redis.Z{{Score: float64(until.Unix()), Member: username}},
+	})
+	_ = execPipeline(ctx, pipe)
+	if err := cmd.Err(); err != nil {
+		return fmt.Errorf("failed to defer user: %w", err)
+	}
+	return nil
+}
+
+// PromoteDeferred moves all deferred users whose time has come into the queue
+// and returns their number.
+func (q *InMemoryQueue) PromoteDeferred(ctx context.Context) (int, error) {
+	keys := []string{
+		fmt.Sprintf("%s:%s", q.ns, DEFERRED),
+		fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS),
+		fmt.Sprintf("%s:%s", q.ns, ENQUEUED_AT),
+	}
+	promoted, err := promoteDeferredScript.Run(ctx, q.client, keys, time.Now().Unix()).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to promote deferred users: %w", err)
+	}
+	return promoted, nil
+}
+
+// Ack releases the in-flight claim for a user once handling finished, successfully or not.
+func (q *InMemoryQueue) Ack(ctx context.Context, username string) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, fmt.Sprintf("%s:%s", q.ns, IN_FLIGHT), username)
+		pipe.Del(ctx, fmt.Sprintf("%s:job_info:%s", q.ns, username))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release in-flight claim: %w", err)
+	}
+	return nil
+}
+
+// SaveJob stores the event info of a claimed user until Ack, see Queue.SaveJob.
+func (q *InMemoryQueue) SaveJob(ctx context.Context, username string, info *EventInfo) error {
+	if info == nil {
+		return nil
+	}
+	fields, ok := info.mergeInto(nil)
+	if !ok {
+		return nil
+	}
+	key := fmt.Sprintf("%s:job_info:%s", q.ns, username)
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, fields)
+		pipe.Expire(ctx, key, eventInfoTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	return nil
+}
+
+// RecoverJobs requeues the users claimed before claimedBefore with their saved
+// event info, see Queue.RecoverJobs.
+func (q *InMemoryQueue) RecoverJobs(ctx context.Context, claimedBefore time.Time) ([]string, error) {
+	claims, err := q.InFlight(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var recovered []string
+	for username, claimedAt := range claims {
+		if !claimedAt.Before(claimedBefore) {
+			continue
+		}
+		saved, err := q.client.HGetAll(ctx, fmt.Sprintf("%s:job_info:%s", q.ns, username)).Result()
+		if err != nil {
+			return recovered, fmt.Errorf("failed to read saved job: %w", err)
+		}
+		var info *EventInfo
+		if len(saved) > 0 {
+			info = eventInfoFromHash(saved)
+		}
+		if err := q.EnqueueEvent(ctx, username, 1.0, info); err != nil {
+			return recovered, err
+		}
+		if err := q.Ack(ctx, username); err != nil {
+			return recovered, err
+		}
+		recovered = append(recovered, username)
+	}
+	return recovered, nil
+}
+
+// InFlight returns all currently claimed users with the time they were claimed.
+func (q *InMemoryQueue) InFlight(ctx context.Context) (map[string]time.Time, error) {
+	key := fmt.Sprintf("%s:%s", q.ns, IN_FLIGHT)
+	raw, err := q.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read in-flight claims: %w", err)
+	}
+	claims := make(map[string]time.Time, len(raw))
+	for username, tsStr := range raw {
+		ts, err := strconv.ParseInt(tsStr, 10, 64)
+		if err != nil {
+			q.logger.Warn("ignoring malformed in-flight claim", "username", username, "value", tsStr)
+			continue
+		}
+		claims[username] = time.Unix(ts, 0)
 	}
-	atomic.AddUint64(&q.dequeueCount, 1)
-	return result[0].Member.(string), nil
+	return claims, nil
 }
 
 // Stats returns the total number of enqueue and dequeue operations.
@@ -110,17 +676,34 @@ func (q *InMemoryQueue) Stats() (enqueues uint64, dequeues uint64) {
 	return atomic.LoadUint64(&q.enqueueCount), atomic.LoadUint64(&q.dequeueCount)
 }
 
-// HealthCheck checks connectivity to the in-memory Redis client.
+// Durable reports whether the queue is kept in an external Redis server, which
+// keeps the data after the process exits, unlike the embedded miniredis.
+func (q *InMemoryQueue) Durable() bool {
+	return q.server == nil
+}
+
+// HealthCheck checks connectivity to the Redis server.
 func (q *InMemoryQueue) HealthCheck(ctx context.Context) error {
 	return q.client.Ping(ctx).Err()
 }
 
 // Close closes the queue and releases resources.
 func (q *InMemoryQueue) Close() error {
+	if q.spiller != nil {
+		q.spiller.stop()
+	}
+	if q.batcher != nil {
+		q.batcher.stop()
+	}
+	if q.wakeups != nil {
+		q.wakeups.stop()
+	}
 	if err := q.client.Close(); err != nil {
 		return fmt.Errorf("failed to close client: %w", err)
 	}
-	q.server.Close()
+	if q.server != nil {
+		q.server.Close()
+	}
 	return nil
 }
 
@@ -135,10 +718,19 @@ func (q *InMemoryQueue) GetQueueSize(ctx context.Context, username string) (int6
 }
 
 // GetReplicationState retrieves the stored replication state for a user.
-// Returns empty string if no state exists.
+// Returns empty string if no state exists. States failing the sanity checks
+// (length, base64 alphabet, checksum if one was stored) are discarded with a
+// warning, which makes the next sync a full sync.
 func (q *InMemoryQueue) GetReplicationState(ctx context.Context, username string) (string, error) {
 	key := fmt.Sprintf("%s:state:%s", q.ns, username)
-	state, err := q.client.Get(ctx, key).Result()
+	sumKey := fmt.Sprintf("%s:state_checksum:%s", q.ns, username)
+
+	pipe := q.client.Pipeline()
+	stateCmd := pipe.Get(ctx, key)
+	sumCmd := pipe.Get(ctx, sumKey)
+	_ = execPipeline(ctx, pipe)
+
+	state, err := stateCmd.Result()
 	if err == redis.Nil {
 		// No state stored yet
 		q.logger.Debug("replication state not found", "username", username, "key", key)
@@ -148,18 +740,43 @@ func (q *InMemoryQueue) GetReplicationState(ctx context.Context, username string
 		q.logger.Debug("failed to get replication state", "username", username, "key", key, "error", err)
 		return "", fmt.Errorf("failed to get replication state: %w", err)
 	}
+
+	validationErr := validateState(state)
+	// states stored before checksums were introduced have no checksum key
+	if sum, err := sumCmd.Result(); validationErr == nil && err == nil && sum != stateChecksum(state) {
+		validationErr = fmt.Errorf("%w: stored %s, computed %s", errStateChecksumMismatch, sum, stateChecksum(state))
+	}
+	if validationErr != nil {
+		q.logger.Warn("discarding invalid replication state",
+			"username", username,
+			"key", key,
+			"state_length", len(state),
+			"reason", validationErr.Error(),
+		)
+		if err := q.DeleteReplicationState(ctx, username); err != nil {
+			q.logger.Warn("failed to delete invalid replication state", "username", username, "error", err)
+		}
+		return "", nil
+	}
+
 	q.logger.Debug("retrieved replication state", "username", username, "key", key, "state", state)
 	return state, nil
 }
 
-// SetReplicationState stores the replication state for a user.
+// SetReplicationState stores the replication state for a user together with its checksum.
 // The state is used for incremental sync in the next replication.
 // State expires after 30 days to prevent unbounded Redis memory growth.
 func (q *InMemoryQueue) SetReplicationState(ctx context.Context, username string, state string) error {
 	key := fmt.Sprintf("%s:state:%s", q.ns, username)
+	sumKey := fmt.Sprintf("%s:state_checksum:%s", q.ns, username)
 	// Set TTL to 30 days - states older than this are considered stale
 	ttl := 30 * 24 * time.Hour
-	if err := q.client.Set(ctx, key, state, ttl).Err(); err != nil {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, state, ttl)
+		pipe.Set(ctx, sumKey, stateChecksum(state), ttl)
+		return nil
+	})
+	if err != nil {
 		q.logger.Debug("failed to set replication state", "username", username, "key", key, "state", state, "error", err)
 		return fmt.Errorf("failed to set replication state: %w", err)
 	}
@@ -167,6 +784,38 @@ func (q *InMemoryQueue) SetReplicationState(ctx context.Context, username string
 	return nil
 }
 
+// DeleteReplicationState removes the stored replication state of a user,
+// forcing the next sync to be a full sync.
+func (q *InMemoryQueue) DeleteReplicationState(ctx context.Context, username string) error {
+	key := fmt.Sprintf("%s:state:%s", q.ns, username)
+	sumKey := fmt.Sprintf("%s:state_checksum:%s", q.ns, username)
+	if err := q.client.Del(ctx, key, sumKey).Err(); err != nil {
+		return fmt.Errorf("failed to delete replication state: %w", err)
+	}
+	q.logger.Debug("deleted replication state", "username", username, "key", key)
+	return nil
+}
+
+// IncrIncrementalFailures increments and returns the number of consecutive failed
+// incremental syncs of a user.
+func (q *InMemoryQueue) IncrIncrementalFailures(ctx context.Context, username string) (int64, error) {
+	key := fmt.Sprintf("%s:%s", q.ns, INCREMENTAL_FAILURES)
+	n, err := q.client.HIncrBy(ctx, key, username, 1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment incremental failures: %w", err)
+	}
+	return n, nil
+}
+
+// ClearIncrementalFailures resets the consecutive incremental failure count of a user.
+func (q *InMemoryQueue) ClearIncrementalFailures(ctx context.Context, username string) error {
+	key := fmt.Sprintf("%s:%s", q.ns, INCREMENTAL_FAILURES)
+	if err := q.client.HDel(ctx, key, username).Err(); err != nil {
+		return fmt.Errorf("failed to clear incremental failures: %w", err)
+	}
+	return nil
+}
+
 // GetLastReplicationTime retrieves the timestamp of the last replication for a user.
 // Returns zero time if no replication has been performed.
 func (q *InMemoryQueue) GetLastReplicationTime(ctx context.Context, username string) (time.Time, error) {
@@ -208,3 +857,372 @@ func (q *InMemoryQueue) SetLastReplicationTime(ctx context.Context, username str
 	q.logger.Debug("stored last replication time", "username", username, "key", key, "time", t, "ttl", ttl)
 	return nil
 }
+
+// GetLastEventTime returns the time of the most recent event of a user, or
+// the zero time if none was received within the last 30 days.
+func (q *InMemoryQueue) GetLastEventTime(ctx context.Context, username string) (time.Time, error) {
+	str, err := q.client.Get(ctx, fmt.Sprintf("%s:last_event:%s", q.ns, username)).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last event time: %w", err)
+	}
+	ts, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse last event time: %w", err)
+	}
+	return time.Unix(ts, 0), nil
+}
+
+// GetSweepCursor returns the last user processed by an interrupted background
+// replication sweep, or an empty string if no sweep was interrupted.
+func (q *InMemoryQueue) GetSweepCursor(ctx context.Context) (string, error) {
+	cursor, err := q.client.Get(ctx, fmt.Sprintf("%s:sweep_cursor", q.ns)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get sweep cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// SetSweepCursor stores the last user processed by the running background
+// replication sweep. An empty cursor removes the checkpoint.
+func (q *InMemoryQueue) SetSweepCursor(ctx context.Context, cursor string) error {
+	key := fmt.Sprintf("%s:sweep_cursor", q.ns)
+	var err error
+	if cursor == "" {
+		err = q.client.Del(ctx, key).Err()
+	} else {
+		err = q.client.Set(ctx, key, cursor, 0).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set sweep cursor: %w", err)
+	}
+	return nil
+}
+
+// ListLastReplicationTimes returns the last replication timestamp of every user
+// that has one stored, scanning the keyspace in batches.
+func (q *InMemoryQueue) ListLastReplicationTimes(ctx context.Context) (map[string]time.Time, error) {
+	prefix := fmt.Sprintf("%s:last_replication:", q.ns)
+	times := make(map[string]time.Time)
+
+	iter := q.client.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	var keys []string
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		vals, err := q.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read last replication times: %w", err)
+		}
+		for i, v := range vals {
+			str, ok := v.(string)
+			if !ok {
+				// expired between SCAN and MGET
+				continue
+			}
+			ts, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				q.logger.Warn("ignoring malformed last replication time", "key", keys[i], "value", str)
+				continue
+			}
+			times[strings.TrimPrefix(keys[i], prefix)] = time.Unix(ts, 0)
+		}
+		keys = keys[:0]
+		return nil
+	}
+
+	for iter.Next(ctx) {
+		if strings.HasPrefix(strings.TrimPrefix(iter.Val(), prefix), fallbackKeyPrefix) {
+			// a time of a fallback destination, not of a user
+			continue
+		}
+		keys = append(keys, iter.Val())
+		if len(keys) >= 1000 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan last replication times: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return times, nil
+}
+
+// RecordFailure marks a user's most recent sync attempt as failed.
+func (q *InMemoryQueue) RecordFailure(ctx context.Context, username string) error {
+	key := fmt.Sprintf("%s:%s", q.ns, FAILED)
+	if err := q.client.HSet(ctx, key, username, time.Now().Unix()).Err(); err != nil {
+		return fmt.Errorf("failed to record failure: %w", err)
+	}
+	return nil
+}
+
+// ClearFailure removes the failure mark of a user after a successful sync.
+func (q *InMemoryQueue) ClearFailure(ctx context.Context, username string) error {
+	key := fmt.Sprintf("%s:%s", q.ns, FAILED)
+	if err := q.client.HDel(ctx, key, username).Err(); err != nil {
+		return fmt.Errorf("failed to clear failure: %w", err)
+	}
+	return nil
+}
+
+// FailedCount returns the number of users whose last sync failed.
+func (q *InMemoryQueue) FailedCount(ctx context.Context) (int64, error) {
+	n, err := q.client.HLen(ctx, fmt.Sprintf("%s:%s", q.ns, FAILED)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count failed users: %w", err)
+	}
+	return n, nil
+}
+
+// RequeueFailed queues the dead-lettered users among usernames, or all of them
+// if usernames is empty, and returns the requeued users.
+func (q *InMemoryQueue) RequeueFailed(ctx context.Context, usernames []string, origin Origin) ([]string, error) {
+	keys := []string{
+		fmt.Sprintf("%s:%s", q.ns, FAILED),
+		fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS),
+		fmt.Sprintf("%s:%s", q.ns, DEFERRED),
+		fmt.Sprintf("%s:%s", q.ns, IN_FLIGHT),
+		fmt.Sprintf("%s:%s", q.ns, ENQUEUED_AT),
+	}
+	originField := ""
+	if origin != "" {
+		originField = eventInfoOriginPrefix + string(origin)
+	}
+	args := make([]interface{}, 0, len(usernames)+4)
+	args = append(args, time.Now().Unix(), fmt.Sprintf("%s:event_info:", q.ns), originField, int64(eventInfoTTL.Seconds()))
+	for _, username := range usernames {
+		args = append(args, username)
+	}
+	requeued, err := requeueFailedScript.Run(ctx, q.client, keys, args...).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to requeue failed users: %w", err)
+	}
+	if len(requeued) > 0 {
+		atomic.AddUint64(&q.enqueueCount, uint64(len(requeued)))
+		q.notifyEnqueued()
+	}
+	return requeued, nil
+}
+
+// DeleteUser removes every trace of a user: queue entries, in-flight claim and
+// saved job, replication states and last replication times of all
+// destinations, failure marks, redirection mark, event info and sync history.
+// Returns whether anything was stored for the user. A sync of the user that is
+// in flight while deleting may store a new state when it completes.
+func (q *InMemoryQueue) DeleteUser(ctx context.Context, username string) (bool, error) {
+	fallbackKeys, err := q.fallbackKeys(ctx, username)
+	if err != nil {
+		return false, err
+	}
+	var cmds []*redis.IntCmd
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, set := range []string{SYNC_TASKS, ENQUEUED_AT, DEFERRED} {
+			cmds = append(cmds, pipe.ZRem(ctx, fmt.Sprintf("%s:%s", q.ns, set), username))
+		}
+		for _, hash := range []string{IN_FLIGHT, FAILED, INCREMENTAL_FAILURES} {
+			cmds = append(cmds, pipe.HDel(ctx, fmt.Sprintf("%s:%s", q.ns, hash), username))
+		}
+		cmds = append(cmds, pipe.SRem(ctx, fmt.Sprintf("%s:%s", q.ns, REDIRECTED), username))
+		cmds = append(cmds, pipe.Del(ctx, append(fallbackKeys,
+			fmt.Sprintf("%s:state:%s", q.ns, username),
+			fmt.Sprintf("%s:state_checksum:%s", q.ns, username),
+			fmt.Sprintf("%s:last_replication:%s", q.ns, username),
+			fmt.Sprintf("%s:event_info:%s", q.ns, username),
+			fmt.Sprintf("%s:job_info:%s", q.ns, username),
+			fmt.Sprintf("%s:history:%s", q.ns, username),
+			fmt.Sprintf("%s:last_event:%s", q.ns, username),
+		)...))
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	found := false
+	for _, cmd := range cmds {
+		if cmd.Val() > 0 {
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// RenameUser moves the data of a renamed user to the new username, see
+// Queue.RenameUser.
+func (q *InMemoryQueue) RenameUser(ctx context.Context, oldName, newName string) (bool, error) {
+	if oldName == newName {
+		return false, ErrUserExists
+	}
+	var keys []string
+	for _, username := range []string{oldName, newName} {
+		for _, prefix := range []string{"state", "state_checksum", "last_replication", "history", "event_info", "last_event"} {
+			keys = append(keys, fmt.Sprintf("%s:%s:%s", q.ns, prefix, username))
+		}
+	}
+	for _, key := range []string{SYNC_TASKS, ENQUEUED_AT, DEFERRED, IN_FLIGHT, FAILED, INCREMENTAL_FAILURES, REDIRECTED} {
+		keys = append(keys, fmt.Sprintf("%s:%s", q.ns, key))
+	}
+
+	res, err := renameUserScript.Run(ctx, q.client, keys, oldName, newName).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to rename user: %w", err)
+	}
+	switch res {
+	case -1:
+		return false, ErrUserInFlight
+	case -2:
+		return false, ErrUserExists
+	}
+
+	// the states of fallback destinations, named after the user, cannot be
+	// passed to the script up front
+	fallbackKeys, err := q.fallbackKeys(ctx, oldName)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range fallbackKeys {
+		renamed, err := q.client.RenameNX(ctx, key, strings.TrimSuffix(key, oldName)+newName).Result()
+		if err == nil && !renamed {
+			err = q.client.Del(ctx, key).Err()
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to rename fallback state: %w", err)
+		}
+	}
+	return res == 1 || len(fallbackKeys) > 0, nil
+}
+
+// fallbackKeys returns the replication state, checksum and last replication
+// keys stored for the user by fallback destinations, see fallbackStateKey.
+func (q *InMemoryQueue) fallbackKeys(ctx context.Context, username string) ([]string, error) {
+	prefix := q.ns + ":"
+	var keys []string
+	iter := q.client.Scan(ctx, 0, prefix+"*:"+fallbackKeyPrefix+"*/"+globEscape(username), 1000).Iterator()
+	for iter.Next(ctx) {
+		kind, name, _ := strings.Cut(strings.TrimPrefix(iter.Val(), prefix), ":")
+		if user, ok := fallbackUser(name); ok && user == username && (kind == "state" || kind == "state_checksum" || kind == "last_replication") {
+			keys = append(keys, iter.Val())
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan fallback states: %w", err)
+	}
+	return keys, nil
+}
+
+// globEscape escapes the characters of s that are special in Redis patterns.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// MarkRedirected records that a user was synced to the fallback destination.
+func (q *InMemoryQueue) MarkRedirected(ctx context.Context, username string) error {
+	if err := q.client.SAdd(ctx, fmt.Sprintf("%s:%s", q.ns, REDIRECTED), username).Err(); err != nil {
+		return fmt.Errorf("failed to mark redirected user: %w", err)
+	}
+	return nil
+}
+
+// ListRedirected returns the users synced to the fallback destination.
+func (q *InMemoryQueue) ListRedirected(ctx context.Context) ([]string, error) {
+	users, err := q.client.SMembers(ctx, fmt.Sprintf("%s:%s", q.ns, REDIRECTED)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list redirected users: %w", err)
+	}
+	return users, nil
+}
+
+// ClearRedirected removes the redirection marks of usernames.
+func (q *InMemoryQueue) ClearRedirected(ctx context.Context, usernames []string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(usernames))
+	for i, username := range usernames {
+		members[i] = username
+	}
+	if err := q.client.SRem(ctx, fmt.Sprintf("%s:%s", q.ns, REDIRECTED), members...).Err(); err != nil {
+		return fmt.Errorf("failed to clear redirected users: %w", err)
+	}
+	return nil
+}
+
+// Status returns a summary of the queue including the next n users to be synced.
+// Determining full vs. incremental syncs checks the state key of every queued user,
+// so the cost grows with the queue length.
+func (q *InMemoryQueue) Status(ctx context.Context, next int) (*Status, error) {
+	tasksKey := fmt.Sprintf("%s:%s", q.ns, SYNC_TASKS)
+	queued, err := q.client.ZRangeWithScores(ctx, tasksKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	pipe := q.client.Pipeline()
+	stateCmds := make([]*redis.IntCmd, len(queued))
+	enqueuedCmds := make([]*redis.FloatCmd, len(queued))
+	for i, z := range queued {
+		username := z.Member.(string)
+		stateCmds[i] = pipe.Exists(ctx, fmt.Sprintf("%s:state:%s", q.ns, username))
+		enqueuedCmds[i] = pipe.ZScore(ctx, fmt.Sprintf("%s:%s", q.ns, ENQUEUED_AT), username)
+	}
+	inFlightCmd := pipe.HLen(ctx, fmt.Sprintf("%s:%s", q.ns, IN_FLIGHT))
+	failedCmd := pipe.HLen(ctx, fmt.Sprintf("%s:%s", q.ns, FAILED))
+	deferredCmd := pipe.ZCard(ctx, fmt.Sprintf("%s:%s", q.ns, DEFERRED))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to read queue status: %w", err)
+	}
+
+	status := &Status{
+		Queued:   len(queued),
+		InFlight: int(inFlightCmd.Val()),
+		Failed:   int(failedCmd.Val()),
+		Deferred: int(deferredCmd.Val()),
+		Spilled:  q.Spilled(),
+		Next:     []QueuedUser{},
+	}
+	for i, z := range queued {
+		full := stateCmds[i].Val() == 0
+		if full {
+			status.QueuedFull++
+		} else {
+			status.QueuedIncremental++
+		}
+		if i < next {
+			user := QueuedUser{
+				Username: z.Member.(string),
+				Score:    z.Score,
+				FullSync: full,
+			}
+			if ts, err := enqueuedCmds[i].Result(); err == nil {
+				user.EnqueuedAt = time.Unix(int64(ts), 0)
+			}
+			status.Next = append(status.Next, user)
+		}
+	}
+
+	known, err := q.ListLastReplicationTimes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.KnownUsers = len(known)
+
+	return status, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log/slog"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -137,12 +138,12 @@ func TestDequeueEmptyQueue(t *testing.T) {
 
 	ctx := context.Background()
 	// Dequeue from empty queue should return empty string and no error
-	username, err := q.Dequeue(ctx)
+	item, err := q.Dequeue(ctx)
 	if err != nil {
 		t.Fatalf("expected no error on empty dequeue, got %v", err)
 	}
-	if username != "" {
-		t.Fatalf("expected empty username on empty queue, got %q", username)
+	if item.Username != "" {
+		t.Fatalf("expected empty username on empty queue, got %q", item.Username)
 	}
 }
 
@@ -161,12 +162,12 @@ func TestDequeueRetryBehavior(t *testing.T) {
 
 	// Simulate multiple retry attempts on empty queue
 	for i := 0; i < 5; i++ {
-		username, err := q.Dequeue(ctx)
+		item, err := q.Dequeue(ctx)
 		if err != nil {
 			t.Fatalf("retry attempt %d: expected no error, got %v", i, err)
 		}
-		if username != "" {
-			t.Fatalf("retry attempt %d: expected empty username, got %q", i, username)
+		if item.Username != "" {
+			t.Fatalf("retry attempt %d: expected empty username, got %q", i, item.Username)
 		}
 	}
 }
@@ -198,14 +199,14 @@ func TestDequeueGracefulErrorOnMalformedData(t *testing.T) {
 	}
 
 	// Now try to dequeue - should handle gracefully
-	username, err := q.Dequeue(ctx)
+	item, err := q.Dequeue(ctx)
 	// Should either succeed with string conversion or return an error gracefully (no panic)
 	if err != nil {
 		// If it's an error, ensure it's wrapped and informative
 		t.Logf("graceful error handling: %v", err)
 	} else {
 		// If no error, the member should be extractable (even if numeric)
-		t.Logf("dequeued member: %q", username)
+		t.Logf("dequeued member: %q", item.Username)
 	}
 }
 
@@ -232,29 +233,29 @@ func TestDequeueWithEnqueuedData(t *testing.T) {
 	}
 
 	// Dequeue and verify order
-	username1, err := q.Dequeue(ctx)
+	item1, err := q.Dequeue(ctx)
 	if err != nil {
 		t.Fatalf("dequeue 1: %v", err)
 	}
-	if username1 != "user-a" {
-		t.Fatalf("expected user-a, got %q", username1)
+	if item1.Username != "user-a" {
+		t.Fatalf("expected user-a, got %q", item1.Username)
 	}
 
-	username2, err := q.Dequeue(ctx)
+	item2, err := q.Dequeue(ctx)
 	if err != nil {
 		t.Fatalf("dequeue 2: %v", err)
 	}
-	if username2 != "user-b" {
-		t.Fatalf("expected user-b, got %q", username2)
+	if item2.Username != "user-b" {
+		t.Fatalf("expected user-b, got %q", item2.Username)
 	}
 
 	// Queue should now be empty
-	username3, err := q.Dequeue(ctx)
+	item3, err := q.Dequeue(ctx)
 	if err != nil {
 		t.Fatalf("dequeue 3 (should be empty): %v", err)
 	}
-	if username3 != "" {
-		t.Fatalf("expected empty string when queue empty, got %q", username3)
+	if item3.Username != "" {
+		t.Fatalf("expected empty string when queue empty, got %q", item3.Username)
 	}
 }
 
@@ -408,3 +409,315 @@ func TestReplicationStateMultipleUsers(t *testing.T) {
 		}
 	}
 }
+
+func TestDequeueN(t *testing.T) {
+	q, err := NewInMemoryQueue("testdequeuen", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	// higher factor = higher priority, so user-c must come first
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.Enqueue(ctx, "user-b", 1.5); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.Enqueue(ctx, "user-c", 2.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	batch, err := q.DequeueN(ctx, 2)
+	if err != nil {
+		t.Fatalf("dequeueN: %v", err)
+	}
+	if len(batch) != 2 || batch[0].Username != "user-c" || batch[1].Username != "user-b" {
+		t.Fatalf("expected [user-c user-b], got %v", batch)
+	}
+
+	batch, err = q.DequeueN(ctx, 5)
+	if err != nil {
+		t.Fatalf("dequeueN: %v", err)
+	}
+	if len(batch) != 1 || batch[0].Username != "user-a" {
+		t.Fatalf("expected [user-a], got %v", batch)
+	}
+
+	batch, err = q.DequeueN(ctx, 5)
+	if err != nil {
+		t.Fatalf("dequeueN on empty queue: %v", err)
+	}
+	if len(batch) != 0 {
+		t.Fatalf("expected empty batch, got %v", batch)
+	}
+
+	if _, deq := q.Stats(); deq != 3 {
+		t.Fatalf("expected 3 dequeues counted, got %d", deq)
+	}
+}
+
+func TestDequeueClaimsInFlight(t *testing.T) {
+	q, err := NewInMemoryQueue("testinflight", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	item, err := q.Dequeue(ctx)
+	if err != nil || item.Username != "user-a" {
+		t.Fatalf("expected user-a, got %q (err %v)", item.Username, err)
+	}
+
+	claims, err := q.InFlight(ctx)
+	if err != nil {
+		t.Fatalf("in-flight: %v", err)
+	}
+	claimedAt, ok := claims["user-a"]
+	if !ok {
+		t.Fatalf("expected user-a to be in flight, got %v", claims)
+	}
+	if claimedAt.Before(before) {
+		t.Fatalf("unexpected claim timestamp %v", claimedAt)
+	}
+
+	if err := q.Ack(ctx, "user-a"); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	claims, err = q.InFlight(ctx)
+	if err != nil {
+		t.Fatalf("in-flight: %v", err)
+	}
+	if len(claims) != 0 {
+		t.Fatalf("expected no in-flight claims after ack, got %v", claims)
+	}
+}
+
+func TestEnqueueKeepsBestScore(t *testing.T) {
+	q, err := NewInMemoryQueue("testbestscore", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	key := q.ns + ":" + SYNC_TASKS
+
+	if err := q.Enqueue(ctx, "user-a", 2.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	first := q.client.ZScore(ctx, key, "user-a").Val()
+
+	// a later, lower-priority event must not move the user back
+	time.Sleep(10 * time.Millisecond)
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if got := q.client.ZScore(ctx, key, "user-a").Val(); got != first {
+		t.Fatalf("expected score to stay at %f, got %f", first, got)
+	}
+
+	// a higher-priority event may move the user forward
+	if err := q.Enqueue(ctx, "user-a", 4.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if got := q.client.ZScore(ctx, key, "user-a").Val(); got >= first {
+		t.Fatalf("expected score to improve below %f, got %f", first, got)
+	}
+
+	if size := q.client.ZCard(ctx, key).Val(); size != 1 {
+		t.Fatalf("expected user to be queued once, got %d entries", size)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	q, err := NewInMemoryQueue("teststatus", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	for _, u := range []string{"user-a", "user-b", "user-c"} {
+		if err := q.Enqueue(ctx, u, 1.0); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	if err := q.SetReplicationState(ctx, "user-b", "state"); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+	if err := q.SetLastReplicationTime(ctx, "user-b", time.Now()); err != nil {
+		t.Fatalf("set last replication: %v", err)
+	}
+	if err := q.RecordFailure(ctx, "user-c"); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	if _, err := q.Dequeue(ctx); err != nil {
+		t.Fatalf("dequeue: %v", err)
+	}
+
+	status, err := q.Status(ctx, 1)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if status.Queued != 2 || status.QueuedFull != 1 || status.QueuedIncremental != 1 {
+		t.Fatalf("unexpected queued counts: %+v", status)
+	}
+	if status.InFlight != 1 || status.Failed != 1 || status.KnownUsers != 1 {
+		t.Fatalf("unexpected counters: %+v", status)
+	}
+	if len(status.Next) != 1 || status.Next[0].EnqueuedAt.IsZero() {
+		t.Fatalf("expected one upcoming user with enqueue time, got %+v", status.Next)
+	}
+
+	if err := q.ClearFailure(ctx, "user-c"); err != nil {
+		t.Fatalf("clear failure: %v", err)
+	}
+	status, err = q.Status(ctx, 0)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if status.Failed != 0 || len(status.Next) != 0 {
+		t.Fatalf("expected no failures and no listed users, got %+v", status)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	q, err := NewInMemoryQueue("testdelete", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	for _, username := range []string{"user-a", "user-b"} {
+		if err := q.EnqueueEvent(ctx, username, 1.0, &EventInfo{CmdName: "APPEND"}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		if err := q.SetReplicationState(ctx, username, "state"); err != nil {
+			t.Fatalf("set state: %v", err)
+		}
+		if err := q.SetLastReplicationTime(ctx, username, time.Now()); err != nil {
+			t.Fatalf("set last replication: %v", err)
+		}
+		if err := q.RecordFailure(ctx, username); err != nil {
+			t.Fatalf("record failure: %v", err)
+		}
+		if _, err := q.IncrIncrementalFailures(ctx, username); err != nil {
+			t.Fatalf("incr failures: %v", err)
+		}
+		if err := q.Defer(ctx, username, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("defer: %v", err)
+		}
+	}
+	if _, err := q.DequeueN(ctx, 1); err != nil {
+		t.Fatalf("dequeue: %v", err)
+	}
+
+	found, err := q.DeleteUser(ctx, "user-a")
+	if err != nil || !found {
+		t.Fatalf("expected user-a to be deleted, got found=%v err=%v", found, err)
+	}
+
+	// no key may still reference user-a, while user-b stays untouched
+	keys, err := q.client.Keys(ctx, "testdelete:*").Result()
+	if err != nil {
+		t.Fatalf("keys: %v", err)
+	}
+	for _, key := range keys {
+		if strings.HasSuffix(key, ":user-a") {
+			t.Errorf("expected key %s to be deleted", key)
+		}
+		switch q.client.Type(ctx, key).Val() {
+		case "zset":
+			if _, err := q.client.ZScore(ctx, key, "user-a").Result(); err != redis.Nil {
+				t.Errorf("expected user-a to be removed from %s", key)
+			}
+		case "hash":
+			if q.client.HExists(ctx, key, "user-a").Val() {
+				t.Errorf("expected user-a to be removed from %s", key)
+			}
+		}
+	}
+	if state, err := q.GetReplicationState(ctx, "user-b"); err != nil || state != "state" {
+		t.Fatalf("expected user-b to be kept, got %q (err %v)", state, err)
+	}
+
+	found, err = q.DeleteUser(ctx, "user-a")
+	if err != nil || found {
+		t.Fatalf("expected nothing left to delete, got found=%v err=%v", found, err)
+	}
+}
+
+func TestOldestEnqueuedAtAndFailedCount(t *testing.T) {
+	q, err := NewInMemoryQueue("testoldest", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	oldest, err := q.OldestEnqueuedAt(ctx)
+	if err != nil {
+		t.Fatalf("oldest: %v", err)
+	}
+	if !oldest.IsZero() {
+		t.Fatalf("expected zero time for empty queue, got %v", oldest)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.Enqueue(ctx, "user-b", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	oldest, err = q.OldestEnqueuedAt(ctx)
+	if err != nil {
+		t.Fatalf("oldest: %v", err)
+	}
+	if oldest.Before(before) || oldest.After(time.Now()) {
+		t.Fatalf("unexpected oldest enqueue time %v", oldest)
+	}
+
+	if err := q.RecordFailure(ctx, "user-a"); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	if err := q.RecordFailure(ctx, "user-b"); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	if n, err := q.FailedCount(ctx); err != nil || n != 2 {
+		t.Fatalf("expected 2 failed users, got %d (%v)", n, err)
+	}
+}
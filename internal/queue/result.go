@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"errors"
+	"time"
+)
+
+// Result is the outcome of a failed handling of a user, telling the worker
+// pool how to retry it. Handlers return it as their error, possibly wrapped;
+// plain errors are retried right away, like a Result without hints.
+type Result struct {
+	Err error
+
+	// Permanent failures are not retried. The user stays marked as failed
+	// until a later event or background replication syncs it successfully.
+	Permanent bool
+
+	// RetryAfter defers the retry by this long instead of requeuing the user
+	// right away. The event info of the failed attempt is not kept for it.
+	RetryAfter time.Duration
+
+	// CoolDown stops the worker pool from dequeuing any user for this long,
+	// e.g. because doveadm sheds load, instead of only delaying this user.
+	CoolDown time.Duration
+
+	// FullSync drops the replication state of the user, so that the retry
+	// runs as a full sync.
+	FullSync bool
+
+	// UserDeleted reports that the user no longer exists and its data was
+	// purged by the handler. The failure is neither recorded nor retried.
+	UserDeleted bool
+
+	// Postponed reports that the user was not synced yet rather than failed,
+	// e.g. because of an active session: it is deferred by RetryAfter with its
+	// event info, and no failure is recorded or reported.
+	Postponed bool
+
+	// Class is the error class of the failure, see doveadm.Classifier; empty
+	// if the handler did not classify it.
+	Class string
+}
+
+// Error returns the message of the underlying error.
+func (r *Result) Error() string {
+	if r.Err == nil {
+		return "handler failed"
+	}
+	return r.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (r *Result) Unwrap() error {
+	return r.Err
+}
+
+// ResultOf returns the retry hints of an error returned by a handler, or a
+// Result without hints for plain errors.
+func ResultOf(err error) Result {
+	var r *Result
+	if errors.As(err, &r) {
+		return *r
+	}
+	return Result{Err: err}
+}
@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+)
+
+// retryBudgetBuckets is the number of buckets of the sliding window.
+const retryBudgetBuckets = 10
+
+// RetryBudget bounds the immediate retries of a worker pool to a ratio of its
+// fresh attempts within a sliding window, so that a partial outage of doveadm
+// is not amplified by retries. Retries beyond the budget are deferred by the
+// budget's delay instead.
+type RetryBudget struct {
+	ratio      float64
+	minRetries int
+	window     time.Duration
+	delay      time.Duration
+	metrics    *metrics.Metrics
+
+	mu       sync.Mutex
+	buckets  [retryBudgetBuckets]retryBucket
+	retrying map[string]struct{} // users whose next attempt is a retry
+	degraded bool
+}
+
+type retryBucket struct {
+	start    time.Time
+	attempts int
+	retries  int
+}
+
+// NewRetryBudget creates a budget allowing minRetries plus ratio retries per
+// fresh attempt within window, deferring further retries by delay.
+func NewRetryBudget(ratio float64, minRetries int, window, delay time.Duration, m *metrics.Metrics) *RetryBudget {
+	return &RetryBudget{
+		ratio:      ratio,
+		minRetries: minRetries,
+		window:     window,
+		delay:      delay,
+		metrics:    m,
+		retrying:   make(map[string]struct{}),
+	}
+}
+
+// Delay returns how long retries beyond the budget are deferred.
+func (b *RetryBudget) Delay() time.Duration {
+	return b.delay
+}
+
+// Attempt records an attempt to handle the user. Attempts of users that were
+// not failed and retried before count as fresh.
+func (b *RetryBudget) Attempt(username string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.retrying[username]; ok {
+		delete(b.retrying, username)
+		return
+	}
+	b.bucket(time.Now()).attempts++
+}
+
+// Deferred records a failed attempt of the user that is retried later by a
+// retry hint, outside of the budget.
+func (b *RetryBudget) Deferred(username string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retrying[username] = struct{}{}
+}
+
+// Allow records a failed attempt of the user to be retried and reports whether
+// the retry fits into the budget. Retries that do not fit are not counted.
+func (b *RetryBudget) Allow(username string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retrying[username] = struct{}{}
+
+	now := time.Now()
+	current := b.bucket(now)
+	attempts, retries := 0, 0
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.window {
+			attempts += bucket.attempts
+			retries += bucket.retries
+		}
+	}
+	allowed := float64(retries) < float64(b.minRetries)+b.ratio*float64(attempts)
+	if allowed {
+		current.retries++
+	} else {
+		b.metrics.RetryBudgetDeferrals.Inc()
+	}
+	if b.degraded == allowed {
+		b.degraded = !allowed
+		if b.degraded {
+			b.metrics.RetryBudgetExhausted.Set(1)
+		} else {
+			b.metrics.RetryBudgetExhausted.Set(0)
+		}
+	}
+	return allowed
+}
+
+// Degraded reports whether the last retry exceeded the budget.
+func (b *RetryBudget) Degraded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.degraded
+}
+
+// bucket returns the bucket of now, resetting it if it belongs to an earlier
+// rotation of the window. Must be called with mu held.
+func (b *RetryBudget) bucket(now time.Time) *retryBucket {
+	width := max(b.window/retryBudgetBuckets, time.Millisecond)
+	start := now.Truncate(width)
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%retryBudgetBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = retryBucket{start: start}
+	}
+	return bucket
+}
@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetryBudget(t *testing.T) {
+	m := metrics.New(prometheus.NewRegistry())
+	budget := NewRetryBudget(0.5, 1, time.Minute, time.Minute, m)
+
+	// 4 fresh attempts allow 1 + 0.5*4 = 3 retries
+	for _, user := range []string{"a", "b", "c", "d"} {
+		budget.Attempt(user)
+	}
+	for _, user := range []string{"a", "b", "c"} {
+		if !budget.Allow(user) {
+			t.Fatalf("expected retry of %s to be allowed", user)
+		}
+	}
+	if budget.Degraded() || testutil.ToFloat64(m.RetryBudgetExhausted) != 0 {
+		t.Fatal("expected budget not to be exhausted")
+	}
+	if budget.Allow("d") {
+		t.Fatal("expected retry beyond the budget to be deferred")
+	}
+	if !budget.Degraded() || testutil.ToFloat64(m.RetryBudgetExhausted) != 1 {
+		t.Fatal("expected budget to be exhausted")
+	}
+	if got := testutil.ToFloat64(m.RetryBudgetDeferrals); got != 1 {
+		t.Fatalf("expected 1 deferral, got %v", got)
+	}
+
+	// Retries of a, b and c are no fresh attempts and do not extend the budget
+	for _, user := range []string{"a", "b", "c"} {
+		budget.Attempt(user)
+	}
+	if budget.Allow("a") {
+		t.Fatal("expected retries not to count as fresh attempts")
+	}
+
+	// 2 more fresh attempts make room for another retry
+	budget.Attempt("e")
+	budget.Attempt("f")
+	if !budget.Allow("e") {
+		t.Fatal("expected retry to be allowed after fresh attempts")
+	}
+	if budget.Degraded() || testutil.ToFloat64(m.RetryBudgetExhausted) != 0 {
+		t.Fatal("expected budget to recover")
+	}
+}
+
+func TestRetryBudgetWindow(t *testing.T) {
+	budget := NewRetryBudget(0, 1, 50*time.Millisecond, time.Minute, metrics.New(prometheus.NewRegistry()))
+
+	if !budget.Allow("a") {
+		t.Fatal("expected the minimum retries to be allowed")
+	}
+	if budget.Allow("b") {
+		t.Fatal("expected retry beyond the minimum to be deferred")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !budget.Allow("b") {
+		t.Fatal("expected retries to be allowed again once the window passed")
+	}
+}
+
+func TestWorkerPoolRetryBudget(t *testing.T) {
+	q := NewNativeQueue(testLogger())
+	m := metrics.New(prometheus.NewRegistry())
+	wp := NewWorkerPool(q, 1, testLogger())
+	wp.SetRetryBudget(NewRetryBudget(0, 0, time.Minute, time.Hour, m))
+
+	wp.retry(t.Context(), 0, QueueItem{Username: "alice"}, Result{Err: errors.New("connection refused")})
+
+	if user, err := q.Dequeue(t.Context()); err != nil || user.Username != "" {
+		t.Fatalf("expected retry to be deferred, got %q (%v)", user.Username, err)
+	}
+	if got := testutil.ToFloat64(m.RetryBudgetDeferrals); got != 1 {
+		t.Fatalf("expected 1 deferral, got %v", got)
+	}
+}
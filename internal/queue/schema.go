@@ -0,0 +1,313 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SchemaVersion is the version of the format of the data stored in Redis.
+// Bump it together with a migration in schemaMigrations when a stored format
+// changes incompatibly.
+const SchemaVersion = 1
+
+// SCHEMA_VERSION is the key holding the schema version of a namespace.
+const SCHEMA_VERSION = "schema_version"
+
+// SCHEMA_LOCK is held by the process migrating a namespace.
+const SCHEMA_LOCK = "schema_lock"
+
+// QUARANTINE prefixes the keys a migration could not upgrade. They are kept
+// for inspection until they expire after quarantineTTL.
+const QUARANTINE = "quarantine"
+
+const quarantineTTL = 30 * 24 * time.Hour
+
+// schemaLockTTL bounds how long a crashed migration blocks the others. A
+// running migration renews the lock at least every schemaLockBatch keys.
+const schemaLockTTL = 5 * time.Minute
+
+const schemaLockBatch = 1000
+
+// errSchemaLockLost aborts a migration whose lock expired or was taken over,
+// so that two processes never migrate the same namespace at once.
+var errSchemaLockLost = errors.New("schema migration lock lost")
+
+// renewLockScript renews the expiry of lock KEYS[1] to ARGV[2] milliseconds if
+// it is still held with token ARGV[1]. Returns 1 if it was, else 0.
+var renewLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLockScript deletes lock KEYS[1] if it is still held with token ARGV[1].
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// schemaLock is the migration lock of a namespace held by this process.
+type schemaLock struct {
+	q     *InMemoryQueue
+	key   string
+	token string
+}
+
+// renew checks that the lock is still held and extends its expiry. Returns
+// errSchemaLockLost if it is not held anymore.
+func (l *schemaLock) renew(ctx context.Context) error {
+	held, err := renewLockScript.Run(ctx, l.q.client, []string{l.key}, l.token, schemaLockTTL.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to renew the schema migration lock: %w", err)
+	}
+	if held == 0 {
+		return errSchemaLockLost
+	}
+	return nil
+}
+
+// release deletes the lock unless another process has taken it over.
+func (l *schemaLock) release(ctx context.Context) error {
+	return releaseLockScript.Run(ctx, l.q.client, []string{l.key}, l.token).Err()
+}
+
+// ErrSchemaTooNew is returned by MigrateSchema if the namespace was written by
+// a newer version of dovewarden, whose data this version may corrupt.
+var ErrSchemaTooNew = errors.New("stored data has a newer schema version")
+
+// SchemaMigrationResult summarizes a schema migration.
+type SchemaMigrationResult struct {
+	From        int      // schema version found; 0 for data written before versioning
+	To          int      // schema version after the migration
+	Upgraded    int      // keys rewritten in the current format
+	Quarantined []string // keys moved aside as they could not be upgraded
+}
+
+// schemaMigrations[v] upgrades the data of a namespace from version v to v+1.
+// Migrations renew the lock at least every schemaLockBatch keys.
+var schemaMigrations = []func(ctx context.Context, q *InMemoryQueue, lock *schemaLock, result *SchemaMigrationResult) error{
+	migrateSchemaV1,
+}
+
+// MigrateSchema upgrades the data stored in the namespace to SchemaVersion
+// and records the version in the namespace. It is a no-op if the data is
+// current. Only one process migrates at a time; the others wait for it.
+// Returns ErrSchemaTooNew if the data is newer than SchemaVersion.
+func (q *InMemoryQueue) MigrateSchema(ctx context.Context) (*SchemaMigrationResult, error) {
+	versionKey := fmt.Sprintf("%s:%s", q.ns, SCHEMA_VERSION)
+	lock := &schemaLock{q: q, key: fmt.Sprintf("%s:%s", q.ns, SCHEMA_LOCK), token: rand.Text()}
+	for {
+		version, err := q.schemaVersion(ctx, versionKey)
+		if err != nil {
+			return nil, err
+		}
+		result := &SchemaMigrationResult{From: version, To: version}
+		if version > SchemaVersion {
+			return result, fmt.Errorf("%w: %d, this version supports up to %d", ErrSchemaTooNew, version, SchemaVersion)
+		}
+		if version == SchemaVersion {
+			return result, nil
+		}
+		locked, err := q.client.SetNX(ctx, lock.key, lock.token, schemaLockTTL).Result()
+		if err != nil {
+			return result, fmt.Errorf("failed to lock the schema migration: %w", err)
+		}
+		if !locked {
+			q.logger.Info("Waiting for another process to migrate the stored data", "version", version)
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		err = q.migrateSchema(ctx, versionKey, lock, result)
+		if releaseErr := lock.release(context.WithoutCancel(ctx)); releaseErr != nil {
+			q.logger.Warn("Failed to release the schema migration lock", "error", releaseErr)
+		}
+		return result, err
+	}
+}
+
+// migrateSchema runs the migrations from result.From on while holding lock,
+// recording the version after each of them.
+func (q *InMemoryQueue) migrateSchema(ctx context.Context, versionKey string, lock *schemaLock, result *SchemaMigrationResult) error {
+	// the version may have been raised while waiting for the lock
+	version, err := q.schemaVersion(ctx, versionKey)
+	if err != nil {
+		return err
+	}
+	result.From, result.To = version, version
+	for v := version; v < SchemaVersion; v++ {
+		q.logger.Info("Migrating stored data", "from_version", v, "to_version", v+1)
+		if err := schemaMigrations[v](ctx, q, lock, result); err != nil {
+			return fmt.Errorf("failed to migrate stored data to version %d: %w", v+1, err)
+		}
+		if err := lock.renew(ctx); err != nil {
+			return fmt.Errorf("failed to record schema version %d: %w", v+1, err)
+		}
+		if err := q.client.Set(ctx, versionKey, v+1, 0).Err(); err != nil {
+			return fmt.Errorf("failed to record schema version %d: %w", v+1, err)
+		}
+		result.To = v + 1
+	}
+	return nil
+}
+
+// schemaVersion returns the schema version of the namespace, 0 if none is
+// recorded.
+func (q *InMemoryQueue) schemaVersion(ctx context.Context, versionKey string) (int, error) {
+	value, err := q.client.Get(ctx, versionKey).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q", value)
+	}
+	return version, nil
+}
+
+// quarantine moves a key that could not be upgraded aside.
+func (q *InMemoryQueue) quarantine(ctx context.Context, key, reason string, result *SchemaMigrationResult) error {
+	target := fmt.Sprintf("%s:%s:%s", q.ns, QUARANTINE, strings.TrimPrefix(key, q.ns+":"))
+	pipe := q.client.TxPipeline()
+	pipe.Rename(ctx, key, target)
+	pipe.Expire(ctx, target, quarantineTTL)
+	if _, err := pipe.Exec(ctx); err != nil && strings.Contains(err.Error(), "no such key") {
+		// vanished since it was read, e.g. expired
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", key, err)
+	}
+	q.logger.Warn("Quarantined stored data of an unsupported format", "key", key, "quarantine_key", target, "reason", reason)
+	result.Quarantined = append(result.Quarantined, key)
+	return nil
+}
+
+// schemaV1Types are the Redis types of the keys of schema version 1, by key
+// name or, ending in ":", by key prefix, both without the namespace.
+var schemaV1Types = map[string]string{
+	SYNC_TASKS:           "zset",
+	ENQUEUED_AT:          "zset",
+	DEFERRED:             "zset",
+	IN_FLIGHT:            "hash",
+	FAILED:               "hash",
+	INCREMENTAL_FAILURES: "hash",
+	REDIRECTED:           "set",
+	"sweep_cursor":       "string",
+	"state:":             "string",
+	"state_checksum:":    "string",
+	"last_replication:":  "string",
+	"last_event:":        "string",
+	"history:":           "list",
+	"event_info:":        "hash",
+	"job_info:":          "hash",
+	"sla:":               "hash",
+}
+
+// schemaV1Type returns the type of a key of schema version 1 given without
+// the namespace, or "" if the key is not one of dovewarden's.
+func schemaV1Type(name string) string {
+	if typ, ok := schemaV1Types[name]; ok {
+		return typ
+	}
+	prefix, _, ok := strings.Cut(name, ":")
+	if !ok {
+		return ""
+	}
+	return schemaV1Types[prefix+":"]
+}
+
+// migrateSchemaV1 upgrades data written before schema versioning: replication
+// states stored before checksums get one, and keys of a type this version
+// cannot read, or states failing the sanity checks, are quarantined. Keys
+// other than dovewarden's are left alone.
+func migrateSchemaV1(ctx context.Context, q *InMemoryQueue, lock *schemaLock, result *SchemaMigrationResult) error {
+	prefix := q.ns + ":"
+	iter := q.client.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	for n := 0; iter.Next(ctx); n++ {
+		if n%schemaLockBatch == 0 {
+			if err := lock.renew(ctx); err != nil {
+				return err
+			}
+		}
+		key := iter.Val()
+		name := strings.TrimPrefix(key, prefix)
+		want := schemaV1Type(name)
+		if want == "" {
+			continue
+		}
+		typ, err := q.client.Type(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("failed to get the type of %s: %w", key, err)
+		}
+		if typ == "none" {
+			// vanished between SCAN and TYPE, e.g. expired
+			continue
+		}
+		if typ != want {
+			if err := q.quarantine(ctx, key, fmt.Sprintf("type %s instead of %s", typ, want), result); err != nil {
+				return err
+			}
+			continue
+		}
+		if username, ok := strings.CutPrefix(name, "state:"); ok {
+			if err := q.upgradeStateV1(ctx, key, username, result); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan namespace %s: %w", q.ns, err)
+	}
+	return nil
+}
+
+// upgradeStateV1 quarantines an invalid state and adds the checksum to a
+// state stored without one, expiring with the state.
+func (q *InMemoryQueue) upgradeStateV1(ctx context.Context, key, username string, result *SchemaMigrationResult) error {
+	state, err := q.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	if err := validateState(state); err != nil {
+		return q.quarantine(ctx, key, err.Error(), result)
+	}
+	sumKey := fmt.Sprintf("%s:state_checksum:%s", q.ns, username)
+	ttl, err := q.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get the TTL of %s: %w", key, err)
+	}
+	switch ttl {
+	case -2:
+		// vanished since it was read
+		return nil
+	case -1:
+		ttl = 0 // no expiry
+	}
+	added, err := q.client.SetNX(ctx, sumKey, stateChecksum(state), ttl).Result()
+	if err != nil {
+		return fmt.Errorf("failed to store the checksum of %s: %w", key, err)
+	}
+	if added {
+		result.Upgraded++
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestMigrateSchema(t *testing.T) {
+	ctx := context.Background()
+	// data written to the external server before schema versioning
+	mr := miniredis.RunT(t)
+	mr.Set("schema:state:alice", "c3RhdGU=")
+	mr.SetTTL("schema:state:alice", time.Hour)
+	mr.Set("schema:state:bob", "not a state!")
+	mr.Set("schema:event_info:carol", "APPEND")
+	if _, err := mr.ZAdd("schema:sync_tasks", 1, "carol"); err != nil {
+		t.Fatalf("ZAdd: %v", err)
+	}
+	mr.Set("schema:doveadm_rate_limit", "foreign")
+
+	q, err := NewExternalQueue("schema", mr.Addr(), "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+
+	result, err := q.MigrateSchema(ctx)
+	if err != nil {
+		t.Fatalf("MigrateSchema: %v", err)
+	}
+	if result.From != 0 || result.To != SchemaVersion || result.Upgraded != 1 || len(result.Quarantined) != 2 {
+		t.Errorf("result = %+v, want 1 upgraded and 2 quarantined keys", result)
+	}
+
+	if state, err := q.GetReplicationState(ctx, "alice"); err != nil || state != "c3RhdGU=" {
+		t.Errorf("alice's state = %q, %v", state, err)
+	}
+	if sum := q.client.Get(ctx, "schema:state_checksum:alice").Val(); sum != stateChecksum("c3RhdGU=") {
+		t.Errorf("alice's checksum = %q", sum)
+	}
+	if ttl := q.client.TTL(ctx, "schema:state_checksum:alice").Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("alice's checksum expires in %v, want with the state", ttl)
+	}
+	for _, key := range []string{"schema:quarantine:state:bob", "schema:quarantine:event_info:carol"} {
+		if ttl := q.client.TTL(ctx, key).Val(); ttl <= 0 {
+			t.Errorf("%s not quarantined with an expiry, TTL %v", key, ttl)
+		}
+	}
+	if info, err := q.TakeEventInfo(ctx, "carol"); err != nil || info != nil {
+		t.Errorf("carol's event info = %+v, %v after quarantine", info, err)
+	}
+	if n := q.client.Exists(ctx, "schema:doveadm_rate_limit", "schema:sync_tasks").Val(); n != 2 {
+		t.Errorf("%d of the keys of a supported format kept, want 2", n)
+	}
+
+	// current data is left alone
+	if result, err := q.MigrateSchema(ctx); err != nil || result.From != SchemaVersion || result.Upgraded != 0 {
+		t.Errorf("second MigrateSchema = %+v, %v", result, err)
+	}
+	if q.client.Exists(ctx, "schema:schema_lock").Val() != 0 {
+		t.Error("schema lock left behind")
+	}
+
+	// data of a newer version is refused
+	q.client.Set(ctx, "schema:schema_version", SchemaVersion+1, 0)
+	if _, err := q.MigrateSchema(ctx); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("MigrateSchema of newer data: %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestSchemaMigrationLock(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	q, err := NewExternalQueue("schema", mr.Addr(), "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+
+	lock := &schemaLock{q: q, key: "schema:schema_lock", token: "mine"}
+	mr.Set(lock.key, "mine")
+	mr.SetTTL(lock.key, time.Minute)
+	if err := lock.renew(ctx); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if ttl := mr.TTL(lock.key); ttl != schemaLockTTL {
+		t.Errorf("lock expires in %v after renewing, want %v", ttl, schemaLockTTL)
+	}
+
+	// expired and taken over by another process
+	mr.Set(lock.key, "theirs")
+	mr.Set("schema:state:alice", "c3RhdGU=")
+	result := &SchemaMigrationResult{}
+	if err := q.migrateSchema(ctx, "schema:schema_version", lock, result); !errors.Is(err, errSchemaLockLost) {
+		t.Fatalf("migrateSchema without the lock: %v, want errSchemaLockLost", err)
+	}
+	if mr.Exists("schema:state_checksum:alice") || mr.Exists("schema:schema_version") {
+		t.Error("data migrated without holding the lock")
+	}
+	if err := lock.release(ctx); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if owner, _ := mr.Get(lock.key); owner != "theirs" {
+		t.Errorf("lock held by %q after releasing, want the other process to keep it", owner)
+	}
+}
@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+)
+
+// SessionCheck defers the syncs of users logged in with many connections,
+// e.g. while a client uploads a mailbox, instead of syncing them again and
+// again while they change. Connections are counted with doveadm who.
+type SessionCheck struct {
+	MinConnections int           // connections from which a user is deferred
+	Services       []string      // services whose connections count, all if empty
+	Delay          time.Duration // delay of the sync of a user with an active session
+	MaxDelay       time.Duration // a user is synced anyway once deferred this long; 0 defers indefinitely
+}
+
+// sessionDeferrals tracks since when users are deferred by the session check.
+type sessionDeferrals struct {
+	mu    sync.Mutex
+	since map[string]time.Time
+}
+
+// SetSessionCheck enables the session check before every sync. Must be called
+// before the handler is used concurrently.
+func (h *DoveadmEventHandler) SetSessionCheck(check SessionCheck) {
+	h.sessionCheck = &check
+	h.sessionDeferrals = &sessionDeferrals{since: make(map[string]time.Time)}
+}
+
+// checkSessions returns a postponing Result if the user has at least
+// MinConnections connections and was deferred for less than MaxDelay so far,
+// and nil if the user is to be synced. Failures of doveadm who are logged and
+// do not hold the sync.
+func (h *DoveadmEventHandler) checkSessions(ctx context.Context, client *doveadm.Client, username string, logger *slog.Logger) error {
+	check := h.sessionCheck
+	if check == nil {
+		return nil
+	}
+	sessions, err := client.Who(ctx, username)
+	if err != nil {
+		logger.Warn("Failed to check the sessions of the user, syncing", "username", username, "error", err)
+		h.metrics.SessionChecks.WithLabelValues("error").Inc()
+		return nil
+	}
+	connections := 0
+	for _, session := range sessions {
+		if len(check.Services) == 0 || slices.Contains(check.Services, session.Service) {
+			connections += session.Connections
+		}
+	}
+
+	d := h.sessionDeferrals
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if connections < check.MinConnections {
+		delete(d.since, username)
+		h.metrics.SessionChecks.WithLabelValues("idle").Inc()
+		return nil
+	}
+	now := time.Now()
+	since, ok := d.since[username]
+	if !ok {
+		since = now
+		d.since[username] = now
+	}
+	if check.MaxDelay > 0 && now.Sub(since) >= check.MaxDelay {
+		delete(d.since, username)
+		logger.Info("User still has active sessions, syncing after the maximum delay", "username", username, "connections", connections, "deferred_since", since)
+		h.metrics.SessionChecks.WithLabelValues("max_delay").Inc()
+		return nil
+	}
+	logger.Info("User has active sessions, deferring sync", "username", username, "connections", connections, "delay", check.Delay)
+	h.metrics.SessionChecks.WithLabelValues("deferred").Inc()
+	return &Result{
+		Err:        fmt.Errorf("user has %d active connections", connections),
+		RetryAfter: check.Delay,
+		Postponed:  true,
+	}
+}
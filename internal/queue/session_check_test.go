@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm/doveadmtest"
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSessionCheck(t *testing.T) {
+	fake := doveadmtest.New("secret", nil)
+	fake.SetConfig(doveadmtest.Config{Sessions: map[string]int{"busy": 3, "idle": 1}})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.Background()
+	q := NewNativeQueue(testLogger())
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), q, m)
+	h.SetHistorySize(10)
+	h.SetSessionCheck(SessionCheck{MinConnections: 2, Delay: time.Minute, MaxDelay: 100 * time.Millisecond})
+
+	err := h.Handle(ctx, QueueItem{Username: "busy"})
+	if result := ResultOf(err); !result.Postponed || result.RetryAfter != time.Minute {
+		t.Fatalf("expected busy to be postponed by a minute, got %v", err)
+	}
+	if history, _ := q.GetHistory(ctx, "busy"); len(history) != 0 {
+		t.Errorf("postponed sync recorded in the history: %+v", history)
+	}
+	if err := h.Handle(ctx, QueueItem{Username: "idle"}); err != nil {
+		t.Fatalf("expected idle to be synced, got %v", err)
+	}
+
+	// once deferred for the maximum delay, the user is synced anyway
+	time.Sleep(100 * time.Millisecond)
+	if err := h.Handle(ctx, QueueItem{Username: "busy"}); err != nil {
+		t.Fatalf("expected busy to be synced after the maximum delay, got %v", err)
+	}
+	if got := fake.Stats().Syncs; got["busy"] != 1 || got["idle"] != 1 {
+		t.Errorf("unexpected syncs %v", got)
+	}
+	for result, want := range map[string]float64{"deferred": 1, "idle": 1, "max_delay": 1} {
+		if got := testutil.ToFloat64(m.SessionChecks.WithLabelValues(result)); got != want {
+			t.Errorf("%s checks = %v, want %v", result, got, want)
+		}
+	}
+}
+
+func TestWorkerPoolPostpone(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		var attempts atomic.Int32
+		handler := EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+			attempts.Add(1)
+			return &Result{Err: errors.New("active session"), RetryAfter: time.Hour, Postponed: true}
+		})
+		if err := q.EnqueueEvent(ctx, "user-a", 1.0, &EventInfo{Event: "imap_command_finished", Origin: OriginEvent}); err != nil {
+			t.Fatalf("EnqueueEvent: %v", err)
+		}
+
+		wp := NewWorkerPool(q, 1, testLogger())
+		wp.SetHandler(handler)
+		wp.Start(ctx)
+		deadline := time.Now().Add(5 * time.Second)
+		for attempts.Load() < 1 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := wp.Stop(stopCtx); err != nil {
+			t.Fatalf("stop: %v", err)
+		}
+
+		status, err := q.Status(ctx, 10)
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		if status.Queued != 0 || status.Deferred != 1 || status.Failed != 0 {
+			t.Fatalf("expected user-a deferred without a failure, got %+v", status)
+		}
+		// the deferred sync sees the event that queued the user
+		if info, err := q.TakeEventInfo(ctx, "user-a"); err != nil || info == nil || info.Origin != OriginEvent || info.Event != "imap_command_finished" {
+			t.Fatalf("expected the event info to be kept, got %+v, %v", info, err)
+		}
+	})
+}
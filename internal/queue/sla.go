@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Hash fields of the SLA counters of a time slot.
+const (
+	slaFieldSyncs     = "syncs"
+	slaFieldWithin1m  = "within_1m"
+	slaFieldWithin5m  = "within_5m"
+	slaFieldWithin1h  = "within_1h"
+	slaFieldLatencyMs = "latency_ms" // sum of all latencies, for the mean
+)
+
+// SLA targets of the replication latency, the time from the first event
+// triggering a sync to the sync's successful completion.
+var slaTargets = []struct {
+	field  string
+	target time.Duration
+}{
+	{slaFieldWithin1m, time.Minute},
+	{slaFieldWithin5m, 5 * time.Minute},
+	{slaFieldWithin1h, time.Hour},
+}
+
+// slaSlot is the width of the time slots SLA counters are aggregated in, and
+// thereby the granularity of report windows.
+const slaSlot = 5 * time.Minute
+
+// slaRetention bounds how long SLA counters are kept and thereby the longest
+// report window.
+const slaRetention = 7 * 24 * time.Hour
+
+// MaxSLAWindow is the longest window SLAReport can cover.
+const MaxSLAWindow = slaRetention
+
+// SLAReport aggregates the replication latencies of the event-triggered syncs
+// completed within a time window. The Within counters are cumulative, e.g.
+// Within5m includes the syncs counted in Within1m.
+type SLAReport struct {
+	Syncs              int64   `json:"syncs"`
+	Within1m           int64   `json:"within_1m"`
+	Within5m           int64   `json:"within_5m"`
+	Within1h           int64   `json:"within_1h"`
+	Within1mRatio      float64 `json:"within_1m_ratio"`
+	Within5mRatio      float64 `json:"within_5m_ratio"`
+	Within1hRatio      float64 `json:"within_1h_ratio"`
+	MeanLatencySeconds float64 `json:"mean_latency_seconds"`
+}
+
+// slaKey returns the key of the SLA counters of the slot containing t.
+func (q *InMemoryQueue) slaKey(t time.Time) string {
+	return fmt.Sprintf("%s:sla:%d", q.ns, t.Truncate(slaSlot).Unix())
+}
+
+// RecordSyncLatency counts a sync completed at the given time, latency after
+// its triggering event, into the SLA counters.
+func (q *InMemoryQueue) RecordSyncLatency(ctx context.Context, completedAt time.Time, latency time.Duration) error {
+	key := q.slaKey(completedAt)
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, key, slaFieldSyncs, 1)
+		pipe.HIncrBy(ctx, key, slaFieldLatencyMs, latency.Milliseconds())
+		for _, t := range slaTargets {
+			if latency <= t.target {
+				pipe.HIncrBy(ctx, key, t.field, 1)
+			}
+		}
+		pipe.Expire(ctx, key, slaRetention+slaSlot)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record sync latency: %w", err)
+	}
+	return nil
+}
+
+// SLAReport aggregates the SLA counters of the syncs completed since the given
+// time. The start is rounded down to the 5 minute slots the counters are kept
+// in, and counters are kept for at most MaxSLAWindow.
+func (q *InMemoryQueue) SLAReport(ctx context.Context, since time.Time) (*SLAReport, error) {
+	pipe := q.client.Pipeline()
+	var cmds []*redis.MapStringStringCmd
+	for slot := since.Truncate(slaSlot); !slot.After(time.Now()); slot = slot.Add(slaSlot) {
+		cmds = append(cmds, pipe.HGetAll(ctx, q.slaKey(slot)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to read SLA counters: %w", err)
+	}
+
+	report := &SLAReport{}
+	var latencyMs int64
+	for _, cmd := range cmds {
+		counters := cmd.Val()
+		report.Syncs += parseCounter(counters[slaFieldSyncs])
+		report.Within1m += parseCounter(counters[slaFieldWithin1m])
+		report.Within5m += parseCounter(counters[slaFieldWithin5m])
+		report.Within1h += parseCounter(counters[slaFieldWithin1h])
+		latencyMs += parseCounter(counters[slaFieldLatencyMs])
+	}
+	if report.Syncs > 0 {
+		syncs := float64(report.Syncs)
+		report.Within1mRatio = float64(report.Within1m) / syncs
+		report.Within5mRatio = float64(report.Within5m) / syncs
+		report.Within1hRatio = float64(report.Within1h) / syncs
+		report.MeanLatencySeconds = float64(latencyMs) / 1000 / syncs
+	}
+	return report, nil
+}
+
+// parseCounter parses a hash counter, treating missing fields as zero.
+func parseCounter(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
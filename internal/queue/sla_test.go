@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm/doveadmtest"
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestSLAReport(t *testing.T) {
+	q, err := NewInMemoryQueue("testsla", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+
+	ctx := context.Background()
+	now := time.Now()
+	for _, latency := range []time.Duration{10 * time.Second, 3 * time.Minute, 30 * time.Minute, 2 * time.Hour} {
+		if err := q.RecordSyncLatency(ctx, now, latency); err != nil {
+			t.Fatalf("record latency: %v", err)
+		}
+	}
+	// outside of the report window
+	if err := q.RecordSyncLatency(ctx, now.Add(-2*time.Hour), time.Second); err != nil {
+		t.Fatalf("record latency: %v", err)
+	}
+
+	report, err := q.SLAReport(ctx, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if report.Syncs != 4 || report.Within1m != 1 || report.Within5m != 2 || report.Within1h != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Within1hRatio != 0.75 {
+		t.Fatalf("expected within 1h ratio 0.75, got %v", report.Within1hRatio)
+	}
+	wantMean := (10*time.Second + 3*time.Minute + 30*time.Minute + 2*time.Hour).Seconds() / 4
+	if report.MeanLatencySeconds != wantMean {
+		t.Fatalf("expected mean latency %v, got %v", wantMean, report.MeanLatencySeconds)
+	}
+
+	report, err = q.SLAReport(ctx, now.Add(-3*time.Hour))
+	if err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if report.Syncs != 5 {
+		t.Fatalf("expected 5 syncs in the longer window, got %d", report.Syncs)
+	}
+}
+
+func TestEventInfoKeepsFirstTriggerTime(t *testing.T) {
+	q, err := NewInMemoryQueue("testtrigger", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+
+	ctx := context.Background()
+	first := time.Unix(1700000000, 0)
+	for _, at := range []time.Time{first, first.Add(time.Minute)} {
+		if err := q.EnqueueEvent(ctx, "user-a", 1.0, &EventInfo{CmdName: "APPEND", TriggeredAt: at}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+
+	info, err := q.TakeEventInfo(ctx, "user-a")
+	if err != nil {
+		t.Fatalf("take event info: %v", err)
+	}
+	if info == nil || !info.TriggeredAt.Equal(first) {
+		t.Fatalf("expected trigger time %v, got %+v", first, info)
+	}
+}
+
+func TestEventLatency(t *testing.T) {
+	srv := httptest.NewServer(doveadmtest.New("secret", nil))
+	defer srv.Close()
+
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), NewNativeQueue(testLogger()), m)
+	now := time.Now()
+	for _, info := range []*EventInfo{
+		{TriggeredAt: now, OccurredAt: now.Add(-30 * time.Second)},
+		{TriggeredAt: now},         // the event carried no time
+		{Origin: OriginBackground}, // no triggering event
+	} {
+		if err := h.Handle(context.Background(), QueueItem{Username: "user-a", Info: info}); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	}
+
+	count, sum := histogramCountSum(t, m.EventLatency)
+	if count != 1 || sum < 30 {
+		t.Errorf("event latency count %d, sum %v; want one observation of at least 30s", count, sum)
+	}
+	if count, _ := histogramCountSum(t, m.ReplicationLatency); count != 2 {
+		t.Errorf("replication latency count %d, want 2", count)
+	}
+}
+
+// histogramCountSum returns the number and sum of the observations of h.
+func histogramCountSum(t *testing.T, h prometheus.Histogram) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
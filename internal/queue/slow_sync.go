@@ -0,0 +1,139 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+)
+
+// SyncRecord is a sync attempt kept for investigation.
+type SyncRecord struct {
+	Username        string    `json:"username"`
+	Destination     string    `json:"destination"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	SyncType        string    `json:"sync_type"` // full or incremental
+	Result          string    `json:"result"`
+	Error           string    `json:"error,omitempty"`
+	TriggerEvent    string    `json:"trigger_event,omitempty"` // empty if not triggered by an event
+	CorrelationIDs  []string  `json:"correlation_ids,omitempty"`
+	Origin          Origin    `json:"origin,omitempty"`
+}
+
+// SlowSync is a sync that took longer than the slow sync threshold.
+type SlowSync = SyncRecord
+
+// newSyncRecord records a sync attempt that ended now.
+func newSyncRecord(attempt SyncInfo, duration time.Duration, err error) SyncRecord {
+	record := SyncRecord{
+		Username:        attempt.Username,
+		Destination:     attempt.Destination,
+		StartedAt:       time.Now().Add(-duration),
+		DurationSeconds: duration.Seconds(),
+		SyncType:        "incremental",
+		Result:          HistoryResultSuccess,
+		Origin:          attempt.Origin,
+	}
+	if attempt.FullSync {
+		record.SyncType = "full"
+	}
+	if err != nil {
+		record.Result = HistoryResultFailure
+		record.Error = err.Error()
+	}
+	if attempt.Trigger != nil {
+		record.TriggerEvent = attempt.Trigger.Event
+		record.CorrelationIDs = attempt.Trigger.CorrelationIDs
+	}
+	return record
+}
+
+// syncRing keeps the last sync records.
+type syncRing struct {
+	mu      sync.Mutex
+	entries []SyncRecord // ring buffer, next is the oldest entry once full
+	next    int
+	full    bool
+}
+
+func newSyncRing(size int) *syncRing {
+	return &syncRing{entries: make([]SyncRecord, max(size, 1))}
+}
+
+func (r *syncRing) add(entry SyncRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// recent returns the kept records, most recent first.
+func (r *syncRing) recent() []SyncRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.next
+	if r.full {
+		n = len(r.entries)
+	}
+	recent := make([]SyncRecord, 0, n)
+	for i := 1; i <= n; i++ {
+		recent = append(recent, r.entries[(r.next-i+len(r.entries))%len(r.entries)])
+	}
+	return recent
+}
+
+// SlowSyncTracker reports syncs taking longer than a threshold and keeps the
+// most recent ones for investigation.
+type SlowSyncTracker struct {
+	threshold time.Duration
+	logger    *slog.Logger
+	metrics   *metrics.Metrics
+	syncs     *syncRing
+}
+
+// NewSlowSyncTracker creates a tracker keeping the last size slow syncs.
+func NewSlowSyncTracker(threshold time.Duration, size int, logger *slog.Logger, m *metrics.Metrics) *SlowSyncTracker {
+	return &SlowSyncTracker{
+		threshold: threshold,
+		logger:    logger,
+		metrics:   m,
+		syncs:     newSyncRing(size),
+	}
+}
+
+// Threshold returns the duration above which a sync is slow.
+func (t *SlowSyncTracker) Threshold() time.Duration {
+	return t.threshold
+}
+
+// Hook returns the PostSyncHook reporting slow syncs. Dry runs are ignored.
+func (t *SlowSyncTracker) Hook() PostSyncHook {
+	return func(ctx context.Context, attempt SyncInfo, duration time.Duration, err error) {
+		if attempt.DryRun || duration <= t.threshold {
+			return
+		}
+		entry := newSyncRecord(attempt, duration, err)
+
+		jobLogger(ctx, t.logger).Warn("Slow sync",
+			"username", entry.Username,
+			"destination", entry.Destination,
+			"duration", duration,
+			"threshold", t.threshold,
+			"sync_type", entry.SyncType,
+			"result", entry.Result,
+		)
+		t.metrics.SlowSyncs.WithLabelValues(entry.SyncType).Inc()
+		t.syncs.add(entry)
+	}
+}
+
+// Recent returns the kept slow syncs, most recent first.
+func (t *SlowSyncTracker) Recent() []SlowSync {
+	return t.syncs.recent()
+}
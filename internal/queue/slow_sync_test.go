@@ -0,0 +1,43 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSlowSyncTracker(t *testing.T) {
+	m := metrics.New(prometheus.NewRegistry())
+	tracker := NewSlowSyncTracker(time.Second, 2, testLogger(), m)
+	hook := tracker.Hook()
+	ctx := context.Background()
+
+	hook(ctx, SyncInfo{Username: "fast", Destination: "imap"}, 500*time.Millisecond, nil)
+	hook(ctx, SyncInfo{Username: "dry", Destination: "imap", DryRun: true}, time.Minute, nil)
+	if recent := tracker.Recent(); len(recent) != 0 {
+		t.Fatalf("expected fast syncs and dry runs to be ignored, got %+v", recent)
+	}
+
+	hook(ctx, SyncInfo{Username: "user-a", Destination: "imap", FullSync: true}, 2*time.Second, nil)
+	hook(ctx, SyncInfo{Username: "user-b", Destination: "imap", Trigger: &EventInfo{Event: "imap_command_finished"}}, 3*time.Second, errors.New("timeout"))
+	hook(ctx, SyncInfo{Username: "user-c", Destination: "imap"}, 4*time.Second, nil)
+
+	recent := tracker.Recent()
+	if len(recent) != 2 || recent[0].Username != "user-c" || recent[1].Username != "user-b" {
+		t.Fatalf("expected the 2 most recent slow syncs, newest first, got %+v", recent)
+	}
+	if b := recent[1]; b.SyncType != "incremental" || b.Result != HistoryResultFailure || b.Error != "timeout" || b.TriggerEvent != "imap_command_finished" || b.DurationSeconds != 3 {
+		t.Fatalf("unexpected slow sync %+v", b)
+	}
+	if got := testutil.ToFloat64(m.SlowSyncs.WithLabelValues("full")); got != 1 {
+		t.Fatalf("expected 1 slow full sync, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.SlowSyncs.WithLabelValues("incremental")); got != 2 {
+		t.Fatalf("expected 2 slow incremental syncs, got %v", got)
+	}
+}
@@ -0,0 +1,380 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// spillInterval is how often the spiller checks the queue length.
+const spillInterval = time.Second
+
+// maxSpillSegment bounds the number of users written to a single spill file.
+const maxSpillSegment = 10000
+
+// spillScript atomically removes up to ARGV[2] users with the highest scores
+// from the sync task set (KEYS[1]) while it holds more than ARGV[1] users,
+// together with their first-enqueue time (KEYS[2]) and event info (keys with
+// the prefix ARGV[3]). It returns {member, score, enqueued_at or false,
+// event info fields} per removed user.
+var spillScript = redis.NewScript(`
+local excess = redis.call('ZCARD', KEYS[1]) - tonumber(ARGV[1])
+if excess <= 0 then
+	return {}
+end
+local n = math.min(excess, tonumber(ARGV[2]))
+local tail = redis.call('ZRANGE', KEYS[1], -n, -1, 'WITHSCORES')
+local spilled = {}
+for i = 1, #tail, 2 do
+	local member = tail[i]
+	local infoKey = ARGV[3] .. member
+	local enqueuedAt = redis.call('ZSCORE', KEYS[2], member)
+	local info = redis.call('HGETALL', infoKey)
+	redis.call('ZREM', KEYS[1], member)
+	redis.call('ZREM', KEYS[2], member)
+	redis.call('DEL', infoKey)
+	spilled[#spilled + 1] = {member, tail[i + 1], enqueuedAt, info}
+end
+return spilled
+`)
+
+// spilledUser is a queued user written to disk, one JSON object per line.
+type spilledUser struct {
+	Username   string            `json:"username"`
+	Score      float64           `json:"score"`
+	EnqueuedAt float64           `json:"enqueued_at,omitempty"`
+	EventInfo  map[string]string `json:"event_info,omitempty"`
+}
+
+// spillSegment is a file of spilled users.
+type spillSegment struct {
+	path  string
+	count int
+}
+
+// spiller moves the tail of the queue to files in dir once more than
+// maxQueued users are queued, and moves them back as the queue drains.
+// Segments are reloaded in the order they were written, so users that were
+// spilled first return first.
+type spiller struct {
+	q         *InMemoryQueue
+	dir       string
+	maxQueued int
+	batch     int
+
+	mu       sync.Mutex
+	segments []spillSegment
+	nextSeq  uint64
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+// EnableSpill keeps at most maxQueued users in memory: beyond that, the users
+// with the lowest priority are written to files in dir and read back once the
+// queue has drained enough to hold them. Spill files left by a previous run
+// are picked up, so queued users survive a restart while they are on disk.
+// Must be called before the queue is used concurrently.
+func (q *InMemoryQueue) EnableSpill(dir string, maxQueued int) error {
+	if maxQueued < 1 || q.spiller != nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	s := &spiller{
+		q:         q,
+		dir:       dir,
+		maxQueued: maxQueued,
+		batch:     min(max(maxQueued/4, 1), maxSpillSegment),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+	if err := s.loadSegments(); err != nil {
+		return err
+	}
+	q.spiller = s
+	go s.run()
+	return nil
+}
+
+// Spilled returns the number of users currently spilled to disk.
+func (q *InMemoryQueue) Spilled() int {
+	if q.spiller == nil {
+		return 0
+	}
+	return q.spiller.spilled()
+}
+
+// loadSegments registers the spill files found in the directory.
+func (s *spiller) loadSegments() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "spill-*.jsonl"))
+	if err != nil {
+		return fmt.Errorf("failed to list spill files: %w", err)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		users, err := readSpillSegment(path)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, spillSegment{path: path, count: len(users)})
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "spill-"), ".jsonl"), 10, 64)
+		if err == nil && seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	if len(s.segments) > 0 {
+		s.q.logger.Info("Found spilled queue entries from a previous run", "files", len(s.segments), "users", s.spilled())
+	}
+	return nil
+}
+
+func (s *spiller) spilled() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, seg := range s.segments {
+		n += seg.count
+	}
+	return n
+}
+
+func (s *spiller) run() {
+	defer close(s.doneCh)
+	ticker := time.NewTicker(spillInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			s.pass(ctx)
+			cancel()
+		}
+	}
+}
+
+func (s *spiller) stop() {
+	s.once.Do(func() {
+		close(s.stopCh)
+	})
+	<-s.doneCh
+}
+
+// pass spills the tail while the queue is over its limit, or otherwise
+// reloads spilled users as long as they fit.
+func (s *spiller) pass(ctx context.Context) {
+	spilled, err := s.spill(ctx)
+	if err != nil {
+		s.q.logger.Error("Failed to spill queue to disk", "error", err)
+		return
+	}
+	if spilled > 0 {
+		s.q.logger.Warn("Queue over its in-memory limit, spilled users to disk", "count", spilled, "max_queued", s.maxQueued, "spilled_total", s.spilled())
+		return
+	}
+	reloaded, err := s.reload(ctx)
+	if err != nil {
+		s.q.logger.Error("Failed to reload spilled users", "error", err)
+	}
+	if reloaded > 0 {
+		s.q.notifyEnqueued()
+		s.q.logger.Info("Reloaded spilled users into the queue", "count", reloaded, "spilled_total", s.spilled())
+	}
+}
+
+// spill moves users beyond the limit to new segments and returns their number.
+func (s *spiller) spill(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		res, err := spillScript.Run(ctx, s.q.client,
+			[]string{fmt.Sprintf("%s:%s", s.q.ns, SYNC_TASKS), fmt.Sprintf("%s:%s", s.q.ns, ENQUEUED_AT)},
+			s.maxQueued, s.batch, fmt.Sprintf("%s:event_info:", s.q.ns),
+		).Slice()
+		if err != nil {
+			return total, fmt.Errorf("failed to remove tail: %w", err)
+		}
+		if len(res) == 0 {
+			return total, nil
+		}
+		users := parseSpilled(res)
+		if err := s.writeSegment(users); err != nil {
+			// put the users back rather than losing them
+			if rerr := s.restore(ctx, users); rerr != nil {
+				return total, errors.Join(err, rerr)
+			}
+			return total, err
+		}
+		total += len(users)
+	}
+}
+
+// parseSpilled converts the reply of spillScript or claimScript.
+func parseSpilled(res []interface{}) []spilledUser {
+	users := make([]spilledUser, 0, len(res))
+	for _, item := range res {
+		fields, ok := item.([]interface{})
+		if !ok || len(fields) != 4 {
+			continue
+		}
+		user := spilledUser{}
+		user.Username, _ = fields[0].(string)
+		if score, ok := fields[1].(string); ok {
+			user.Score, _ = strconv.ParseFloat(score, 64)
+		}
+		if enqueuedAt, ok := fields[2].(string); ok {
+			user.EnqueuedAt, _ = strconv.ParseFloat(enqueuedAt, 64)
+		}
+		if info, ok := fields[3].([]interface{}); ok && len(info) > 0 {
+			user.EventInfo = make(map[string]string, len(info)/2)
+			for i := 0; i+1 < len(info); i += 2 {
+				k, _ := info[i].(string)
+				v, _ := info[i+1].(string)
+				user.EventInfo[k] = v
+			}
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// writeSegment writes users to a new segment file. The file is renamed into
+// place once complete, so a crash never leaves a partial segment behind.
+func (s *spiller) writeSegment(users []spilledUser) error {
+	s.mu.Lock()
+	seq := s.nextSeq
+	s.nextSeq++
+	s.mu.Unlock()
+
+	path := filepath.Join(s.dir, fmt.Sprintf("spill-%020d.jsonl", seq))
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	for _, user := range users {
+		if err := enc.Encode(user); err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+			return fmt.Errorf("failed to write spill file: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+
+	s.mu.Lock()
+	s.segments = append(s.segments, spillSegment{path: path, count: len(users)})
+	s.mu.Unlock()
+	return nil
+}
+
+// reload moves the oldest segments back into the queue while they fit within
+// the limit and returns the number of users reloaded.
+func (s *spiller) reload(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		s.mu.Lock()
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return total, nil
+		}
+		seg := s.segments[0]
+		s.mu.Unlock()
+
+		queued, err := s.q.Len(ctx)
+		if err != nil {
+			return total, err
+		}
+		if int(queued)+seg.count > s.maxQueued {
+			return total, nil
+		}
+
+		users, err := readSpillSegment(seg.path)
+		if err != nil {
+			return total, err
+		}
+		if err := s.restore(ctx, users); err != nil {
+			return total, err
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return total, fmt.Errorf("failed to remove spill file: %w", err)
+		}
+		s.mu.Lock()
+		s.segments = s.segments[1:]
+		s.mu.Unlock()
+		total += len(users)
+	}
+}
+
+// restore puts spilled users back into the queue. Users that were queued
+// again while on disk keep the better of both scores and the earlier
+// first-enqueue time; event info fields stored since are not overwritten,
+// except for the trigger and event times, which the spilled events precede.
+func (s *spiller) restore(ctx context.Context, users []spilledUser) error {
+	pipe := s.q.client.Pipeline()
+	for _, user := range users {
+		pipe.ZAddLT(ctx, fmt.Sprintf("%s:%s", s.q.ns, SYNC_TASKS), redis.Z{Score: user.Score, Member: user.Username})
+		if user.EnqueuedAt > 0 {
+			pipe.ZAddLT(ctx, fmt.Sprintf("%s:%s", s.q.ns, ENQUEUED_AT), redis.Z{Score: user.EnqueuedAt, Member: user.Username})
+		}
+		if len(user.EventInfo) > 0 {
+			key := fmt.Sprintf("%s:event_info:%s", s.q.ns, user.Username)
+			for field, value := range user.EventInfo {
+				if field == eventInfoFieldTriggeredAt || field == eventInfoFieldOccurredAt {
+					// the spilled events precede any stored since
+					pipe.HSet(ctx, key, field, value)
+				} else {
+					pipe.HSetNX(ctx, key, field, value)
+				}
+			}
+			pipe.Expire(ctx, key, eventInfoTTL)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to restore spilled users: %w", err)
+	}
+	return nil
+}
+
+// readSpillSegment reads all users of a segment file.
+func readSpillSegment(path string) ([]spilledUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var users []spilledUser
+	dec := json.NewDecoder(f)
+	for {
+		var user spilledUser
+		if err := dec.Decode(&user); err == io.EOF {
+			return users, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read spill file %s: %w", path, err)
+		}
+		users = append(users, user)
+	}
+}
@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpillAndReload(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewInMemoryQueue("testspill", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+	if err := q.EnableSpill(dir, 4); err != nil {
+		t.Fatalf("enable spill: %v", err)
+	}
+
+	ctx := context.Background()
+	users := []string{"user-0", "user-1", "user-2", "user-3", "user-4", "user-5", "user-6", "user-7"}
+	for _, u := range users {
+		if err := q.EnqueueEvent(ctx, u, 1.0, &EventInfo{Mailboxes: []string{"INBOX"}, TriggeredAt: time.Now()}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		time.Sleep(time.Millisecond) // distinct scores
+	}
+
+	q.spiller.pass(ctx)
+	if n, _ := q.Len(ctx); n != 4 {
+		t.Fatalf("expected 4 users in memory, got %d", n)
+	}
+	if q.Spilled() != 4 {
+		t.Fatalf("expected 4 spilled users, got %d", q.Spilled())
+	}
+	if order := getQueueOrder(t, q); order[0] != "user-0" || order[3] != "user-3" {
+		t.Fatalf("expected the highest priority users to stay in memory, got %v", order)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "spill-*.jsonl")); len(files) == 0 {
+		t.Fatal("expected spill files")
+	}
+
+	// a spilled user queued again is merged with its spilled entry on reload
+	if err := q.Enqueue(ctx, "user-7", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	// draining the queue lets the spilled users return in order
+	got, err := q.DequeueN(ctx, 4)
+	if err != nil || len(got) != 4 {
+		t.Fatalf("dequeue: %v %v", got, err)
+	}
+	for i := 0; i < 3; i++ {
+		q.spiller.pass(ctx)
+	}
+	if q.Spilled() != 0 {
+		t.Fatalf("expected all users reloaded, got %d still spilled", q.Spilled())
+	}
+	if order := getQueueOrder(t, q); len(order) != 4 || order[0] != "user-4" || order[3] != "user-7" {
+		t.Fatalf("unexpected queue after reload: %v", order)
+	}
+	info, err := q.TakeEventInfo(ctx, "user-5")
+	if err != nil || info == nil || len(info.Mailboxes) != 1 || info.TriggeredAt.IsZero() {
+		t.Fatalf("expected event info to survive the spill, got %+v (%v)", info, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "spill-*.jsonl")); len(files) != 0 {
+		t.Fatalf("expected spill files to be removed, got %v", files)
+	}
+}
+
+func TestSpillFilesSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	q, err := NewInMemoryQueue("testspillrestart", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	if err := q.EnableSpill(dir, 1); err != nil {
+		t.Fatalf("enable spill: %v", err)
+	}
+	for _, u := range []string{"user-a", "user-b", "user-c"} {
+		if err := q.Enqueue(ctx, u, 1.0); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	q.spiller.pass(ctx)
+	if q.Spilled() != 2 {
+		t.Fatalf("expected 2 spilled users, got %d", q.Spilled())
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	restarted, err := NewInMemoryQueue("testspillrestart", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := restarted.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+	if err := restarted.EnableSpill(dir, 10); err != nil {
+		t.Fatalf("enable spill: %v", err)
+	}
+	if restarted.Spilled() != 2 {
+		t.Fatalf("expected spill files of the previous run, got %d", restarted.Spilled())
+	}
+	restarted.spiller.pass(ctx)
+	if n, _ := restarted.Len(ctx); n != 2 || restarted.Spilled() != 0 {
+		t.Fatalf("expected spilled users to be reloaded, got %d queued and %d spilled", n, restarted.Spilled())
+	}
+}
@@ -0,0 +1,296 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+)
+
+// MirrorStreamPath is the admin API path a primary streams its queue changes
+// on, as server-sent events.
+const MirrorStreamPath = "/admin/mirror/stream"
+
+// MirrorHeartbeat is how often a primary sends a heartbeat on idle streams.
+const MirrorHeartbeat = 10 * time.Second
+
+// mirrorStaleAfter is how long a standby waits for a line of the stream,
+// heartbeats included, before reconnecting.
+const mirrorStaleAfter = 3 * MirrorHeartbeat
+
+// standbyMaxBackoff bounds the wait of a standby between connection attempts.
+const standbyMaxBackoff = 30 * time.Second
+
+// mirroredUser is a user queued at the primary, as mirrored by a standby.
+type mirroredUser struct {
+	priority float64           // 0 if unknown
+	info     map[string]string // hash representation of the coalesced event info
+}
+
+// Standby mirrors the queue of a primary while the local worker pool stands
+// by, and queues the mirrored users once it takes over, so that a failover
+// starts with a warm backlog instead of an empty queue. Users queued locally
+// meanwhile, e.g. by events sent to the standby, stay queued as well.
+type Standby struct {
+	url           string
+	client        *http.Client
+	queue         Queue
+	pool          *WorkerPool
+	metrics       *metrics.Metrics
+	logger        *slog.Logger
+	takeoverAfter time.Duration
+
+	mu          sync.Mutex
+	token       string
+	backlog     map[string]mirroredUser
+	lastContact time.Time
+	takenOver   bool
+	cancel      context.CancelFunc // ends the running stream
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+// NewStandby creates a standby of the primary whose metrics listener is at
+// primaryURL, e.g. http://primary:9090. If takeoverAfter is positive, the
+// standby takes over once it lost the primary for that long; otherwise only
+// TakeOver does.
+func NewStandby(primaryURL string, q Queue, pool *WorkerPool, m *metrics.Metrics, logger *slog.Logger, takeoverAfter time.Duration) *Standby {
+	return &Standby{
+		url:           strings.TrimRight(primaryURL, "/") + MirrorStreamPath,
+		client:        &http.Client{},
+		queue:         q,
+		pool:          pool,
+		metrics:       m,
+		logger:        logger,
+		takeoverAfter: takeoverAfter,
+		backlog:       make(map[string]mirroredUser),
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+}
+
+// SetToken sets the admin API token the stream is requested with.
+func (s *Standby) SetToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// Start holds the worker pool and starts mirroring the primary.
+func (s *Standby) Start(ctx context.Context) {
+	s.pool.SetStandby(true)
+	s.mu.Lock()
+	s.lastContact = time.Now()
+	s.mu.Unlock()
+	s.logger.Info("Standing by, mirroring the queue of the primary", "url", s.url, "takeover_after", s.takeoverAfter)
+	go s.run(ctx)
+}
+
+// Stop ends mirroring without taking over.
+func (s *Standby) Stop(ctx context.Context) error {
+	s.once.Do(func() { close(s.stopCh) })
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TakenOver reports whether the standby took over.
+func (s *Standby) TakenOver() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.takenOver
+}
+
+// TakeOver ends mirroring, queues the mirrored users with their event info and
+// lets the worker pool dequeue, and returns the number of users queued. Users
+// the primary was syncing when it was lost are not mirrored; they are synced
+// once they get another event or by background replication. Taking over
+// again does nothing.
+func (s *Standby) TakeOver(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	if s.takenOver {
+		s.mu.Unlock()
+		return 0, nil
+	}
+	s.takenOver = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	backlog := s.backlog
+	s.backlog = make(map[string]mirroredUser)
+	s.mu.Unlock()
+	s.once.Do(func() { close(s.stopCh) })
+	s.metrics.StandbyConnected.Set(0)
+	s.metrics.StandbyBacklog.Set(0)
+
+	queued := 0
+	var errs []error
+	for username, user := range backlog {
+		var info *EventInfo
+		if len(user.info) > 0 {
+			info = eventInfoFromHash(user.info)
+		}
+		priority := user.priority
+		if priority <= 0 {
+			priority = 1.0 // unknown for users of the snapshot
+		}
+		if err := s.queue.EnqueueEvent(ctx, username, priority, info); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", username, err))
+			continue
+		}
+		queued++
+	}
+	s.pool.SetStandby(false)
+	s.logger.Warn("Took over from the primary", "queued_users", queued, "failed", len(errs))
+	return queued, errors.Join(errs...)
+}
+
+// run mirrors the primary until the standby is stopped or takes over,
+// reconnecting with exponential backoff.
+func (s *Standby) run(ctx context.Context) {
+	defer close(s.doneCh)
+	backoff := time.Second
+	for {
+		connected, err := s.stream(ctx)
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+		s.metrics.StandbyConnected.Set(0)
+		s.logger.Warn("Lost the mirror stream of the primary", "error", err)
+		if connected {
+			backoff = time.Second
+		}
+
+		s.mu.Lock()
+		lost := time.Since(s.lastContact)
+		s.mu.Unlock()
+		if s.takeoverAfter > 0 && lost >= s.takeoverAfter {
+			s.logger.Warn("Primary unreachable for too long, taking over", "lost_for", lost)
+			if _, err := s.TakeOver(context.WithoutCancel(ctx)); err != nil {
+				s.logger.Error("Failed to queue some mirrored users", "error", err)
+			}
+			return
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-s.stopCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, standbyMaxBackoff)
+	}
+}
+
+// stream applies the messages of one connection to the primary until it
+// ends, and reports whether it was established.
+func (s *Standby) stream(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	if s.takenOver {
+		s.mu.Unlock()
+		return false, errors.New("taken over")
+	}
+	s.cancel = cancel
+	token := s.token
+	s.mu.Unlock()
+	// a primary that stops sending, heartbeats included, is given up on
+	watchdog := time.AfterFunc(mirrorStaleAfter, cancel)
+	defer watchdog.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	s.logger.Info("Connected to the mirror stream of the primary", "url", s.url)
+	s.metrics.StandbyConnected.Set(1)
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		watchdog.Reset(mirrorStaleAfter)
+		s.mu.Lock()
+		s.lastContact = time.Now()
+		s.mu.Unlock()
+
+		// heartbeats are comments, and messages are single data lines
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var msg MirrorMessage
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &msg); err != nil {
+			s.logger.Warn("Ignoring malformed mirror message", "error", err)
+			continue
+		}
+		s.apply(msg)
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, errors.New("stream closed by the primary")
+}
+
+// apply applies a message of the primary to the mirrored backlog.
+func (s *Standby) apply(msg MirrorMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.takenOver {
+		return
+	}
+	switch msg.Type {
+	case MirrorReset:
+		clear(s.backlog)
+	case MirrorEnqueue:
+		if msg.Item == nil {
+			return
+		}
+		user, ok := s.backlog[msg.Item.Username]
+		if !ok || msg.Item.Priority > user.priority {
+			user.priority = msg.Item.Priority
+		}
+		if msg.Item.Info != nil {
+			user.info, _ = msg.Item.Info.mergeInto(user.info)
+		}
+		s.backlog[msg.Item.Username] = user
+	case MirrorDequeue:
+		if msg.Item != nil {
+			delete(s.backlog, msg.Item.Username)
+		}
+	}
+	s.metrics.StandbyBacklog.Set(float64(len(s.backlog)))
+}
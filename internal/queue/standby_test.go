@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// serveMirror streams the changes of q like the admin API does.
+func serveMirror(q *MirroredQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, updates, cancel, err := q.Subscribe(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer cancel()
+		send := func(msg MirrorMessage) {
+			data, _ := json.Marshal(msg)
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+			_ = http.NewResponseController(w).Flush()
+		}
+		send(MirrorMessage{Type: MirrorReset})
+		for i := range snapshot {
+			send(MirrorMessage{Type: MirrorEnqueue, Item: &snapshot[i]})
+		}
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case msg, ok := <-updates:
+				if !ok {
+					return
+				}
+				send(msg)
+			}
+		}
+	}
+}
+
+func TestStandbyMirrorsAndTakesOver(t *testing.T) {
+	ctx := context.Background()
+	m := metrics.New(prometheus.NewRegistry())
+	primary := NewMirroredQueue(NewNativeQueue(testLogger()), m, testLogger())
+	if err := primary.Enqueue(ctx, "queued-before", 1.0); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	srv := httptest.NewServer(serveMirror(primary))
+	defer srv.Close()
+
+	local := NewNativeQueue(testLogger())
+	pool := NewWorkerPool(local, 1, testLogger())
+	standby := NewStandby(srv.URL, local, pool, m, testLogger(), 0)
+	standby.Start(ctx)
+	defer func() { _ = standby.Stop(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(m.StandbyConnected) != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := primary.EnqueueEvent(ctx, "user-a", 2.0, &EventInfo{CmdName: "APPEND", Mailboxes: []string{"INBOX"}, Origin: OriginEvent}); err != nil {
+		t.Fatalf("EnqueueEvent: %v", err)
+	}
+	if err := primary.Enqueue(ctx, "user-b", 3.0); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	// user-b is synced by the primary
+	if _, err := primary.DequeueN(ctx, 1); err != nil {
+		t.Fatalf("DequeueN: %v", err)
+	}
+	for testutil.ToFloat64(m.StandbyBacklog) != 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(m.StandbyBacklog); got != 2 {
+		t.Fatalf("mirrored backlog = %v, want 2", got)
+	}
+	if n, _ := local.Len(ctx); !pool.standby.Load() || n != 0 {
+		t.Fatal("standby queued users or let its worker pool dequeue before taking over")
+	}
+
+	// the mirrored users are queued with their event info by priority
+	queued, err := standby.TakeOver(ctx)
+	if err != nil || queued != 2 {
+		t.Fatalf("TakeOver = %d, %v; want 2 users", queued, err)
+	}
+	if info, _ := local.TakeEventInfo(ctx, "user-a"); info == nil || info.CmdName != "APPEND" || info.Origin != OriginEvent {
+		t.Errorf("user-a queued with event info %+v", info)
+	}
+	if next := nextUsers(t, local); len(next) != 2 || next[0].Username != "user-a" {
+		t.Errorf("queued %+v, want user-a ahead of queued-before", next)
+	}
+	if pool.standby.Load() {
+		t.Error("worker pool still standing by")
+	}
+	if got := testutil.ToFloat64(m.StandbyBacklog); got != 0 {
+		t.Errorf("mirrored backlog = %v after taking over, want 0", got)
+	}
+	if queued, _ := standby.TakeOver(ctx); queued != 0 {
+		t.Errorf("second TakeOver queued %d users", queued)
+	}
+}
+
+func TestStandbyTakesOverAfterLosingPrimary(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	local := NewNativeQueue(testLogger())
+	pool := NewWorkerPool(local, 1, testLogger())
+	standby := NewStandby(srv.URL, local, pool, metrics.New(prometheus.NewRegistry()), testLogger(), 100*time.Millisecond)
+	standby.Start(ctx)
+	defer func() { _ = standby.Stop(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !standby.TakenOver() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !standby.TakenOver() {
+		t.Fatal("standby did not take over")
+	}
+	if pool.standby.Load() {
+		t.Error("worker pool still standing by")
+	}
+}
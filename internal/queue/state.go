@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// maxStateLength bounds the size of a stored dsync state. Real states are a few
+// hundred bytes per mailbox; anything beyond this is considered corrupted.
+const maxStateLength = 1 << 20
+
+var (
+	errStateTooLong          = errors.New("state exceeds maximum length")
+	errStateInvalidChars     = errors.New("state contains characters outside the base64 alphabet")
+	errStateInvalidPadding   = errors.New("state has misplaced base64 padding")
+	errStateChecksumMismatch = errors.New("state checksum mismatch")
+)
+
+// validateState performs cheap sanity checks on a dsync state string. Dovecot
+// exports states base64 encoded, so the state must only use the base64 alphabet
+// (standard or URL-safe) with padding restricted to the end.
+func validateState(state string) error {
+	if len(state) > maxStateLength {
+		return fmt.Errorf("%w: %d bytes", errStateTooLong, len(state))
+	}
+	trimmed := strings.TrimRight(state, "=")
+	if len(state)-len(trimmed) > 2 {
+		return errStateInvalidPadding
+	}
+	for _, c := range trimmed {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '+', c == '/', c == '-', c == '_':
+		case c == '=':
+			return errStateInvalidPadding
+		default:
+			return fmt.Errorf("%w: %q", errStateInvalidChars, c)
+		}
+	}
+	return nil
+}
+
+// stateChecksum returns the checksum stored alongside a state to detect truncation.
+func stateChecksum(state string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(state)))
+}
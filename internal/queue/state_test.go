@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateState(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   string
+		wantErr bool
+	}{
+		{"base64 std", "AQAAAKhj3mJ2ZXJzaW9uMQ==", false},
+		{"base64 url", "AQAAAKhj3mJ2-_", false},
+		{"whitespace", "AQAA AKhj", true},
+		{"control chars", "AQAA\x00AKhj", true},
+		{"padding in middle", "AQ==AKhj", true},
+		{"excess padding", "AQAA===", true},
+		{"too long", strings.Repeat("A", maxStateLength+1), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateState(tt.state)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetReplicationStateDiscardsInvalid(t *testing.T) {
+	q, err := NewInMemoryQueue("test-invalid-state", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+
+	// state with characters outside the base64 alphabet
+	if err := q.client.Set(ctx, q.ns+":state:user-a", "not a state!", 0).Err(); err != nil {
+		t.Fatalf("seed state: %v", err)
+	}
+	state, err := q.GetReplicationState(ctx, "user-a")
+	if err != nil || state != "" {
+		t.Fatalf("expected invalid state to be discarded, got %q (err %v)", state, err)
+	}
+	if n := q.client.Exists(ctx, q.ns+":state:user-a").Val(); n != 0 {
+		t.Fatal("expected invalid state to be deleted")
+	}
+
+	// truncated state detected via checksum
+	if err := q.SetReplicationState(ctx, "user-b", "AQAAAKhj3mJ2ZXJzaW9uMQ"); err != nil {
+		t.Fatalf("set state: %v", err)
+	}
+	if err := q.client.Set(ctx, q.ns+":state:user-b", "AQAAAKhj3m", 0).Err(); err != nil {
+		t.Fatalf("truncate state: %v", err)
+	}
+	state, err = q.GetReplicationState(ctx, "user-b")
+	if err != nil || state != "" {
+		t.Fatalf("expected truncated state to be discarded, got %q (err %v)", state, err)
+	}
+
+	// legacy state without checksum is accepted
+	if err := q.client.Set(ctx, q.ns+":state:user-c", "AQAAAKhj", 0).Err(); err != nil {
+		t.Fatalf("seed state: %v", err)
+	}
+	state, err = q.GetReplicationState(ctx, "user-c")
+	if err != nil || state != "AQAAAKhj" {
+		t.Fatalf("expected legacy state to be kept, got %q (err %v)", state, err)
+	}
+}
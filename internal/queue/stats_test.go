@@ -7,6 +7,10 @@ import (
 	"os"
 	"testing"
 	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 // TestQueueStats verifies that enqueue/dequeue operations are counted correctly
@@ -57,3 +61,109 @@ func TestQueueStats(t *testing.T) {
 		t.Errorf("expected 10 dequeues, got %d", dequeues)
 	}
 }
+
+// TestQueueStatsWithBatching verifies that batched enqueues are flushed and counted
+func TestQueueStatsWithBatching(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	q, err := NewInMemoryQueue("teststatsbatch", "", logger)
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+	q.EnableEnqueueBatching(4, 20*time.Millisecond)
+
+	ctx := context.Background()
+
+	// Enqueue concurrently so items share pipelines; 10 items = 2 full batches + 1 timed flush
+	errCh := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			errCh <- q.Enqueue(ctx, fmt.Sprintf("user-%d", i), 1.0)
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+	}
+
+	enq, _ := q.Stats()
+	if enq != 10 {
+		t.Fatalf("expected 10 enqueues, got %d", enq)
+	}
+	size, err := q.client.ZCard(ctx, q.ns+":"+SYNC_TASKS).Result()
+	if err != nil {
+		t.Fatalf("zcard failed: %v", err)
+	}
+	if size != 10 {
+		t.Fatalf("expected 10 queued users, got %d", size)
+	}
+}
+
+// TestBatchedEnqueueOutlivesContext verifies that an item submitted to the batch
+// is reported as enqueued even if the caller's context ends before the flush
+func TestBatchedEnqueueOutlivesContext(t *testing.T) {
+	q, err := NewInMemoryQueue("testbatchctx", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+	q.EnableEnqueueBatching(100, 100*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.Enqueue(ctx, "user-1", 1.0); err != nil {
+		t.Fatalf("expected the flushed enqueue to succeed, got %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("expected the context to end before the flush")
+	}
+	if _, err := q.client.ZScore(context.Background(), q.ns+":"+SYNC_TASKS, "user-1").Result(); err != nil {
+		t.Fatalf("expected user-1 to be queued: %v", err)
+	}
+}
+
+func TestQueueRedisMetrics(t *testing.T) {
+	q, err := NewInMemoryQueue("testredismetrics", "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	reg := prometheus.NewRegistry()
+	m := metrics.New(reg)
+	if err := q.EnableMetrics(m, reg); err != nil {
+		t.Fatalf("enable metrics: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := q.GetLastReplicationTime(ctx, "user-a"); err != nil {
+		t.Fatalf("get last replication: %v", err)
+	}
+
+	if n := testutil.CollectAndCount(m.RedisCommandDuration, "dovewarden_redis_command_duration_seconds"); n != 2 {
+		t.Fatalf("expected latencies of the pipeline and get, got %d series", n)
+	}
+	// a missing key is not an error
+	if got := testutil.ToFloat64(m.RedisErrors); got != 0 {
+		t.Fatalf("expected no Redis errors, got %v", got)
+	}
+	if n := testutil.CollectAndCount(reg, "dovewarden_redis_pool_connections"); n != 2 {
+		t.Fatalf("expected idle and total pool connections, got %d series", n)
+	}
+}
@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// syncCache remembers the recent complete and successful syncs of users, so
+// that a sync of a user without events since can be skipped.
+type syncCache struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	syncs     map[string]cachedSync // by state key, so that syncs to the fallback destination are apart
+	lastSweep time.Time
+}
+
+type cachedSync struct {
+	started   time.Time
+	completed time.Time
+}
+
+// SetRedundantSyncWindow skips the sync of a user if its last sync succeeded
+// less than window ago and no event of the user arrived since that sync
+// started. Full syncs, syncs queued through the admin API and syncs following
+// a sync restricted by the mailbox filter are never skipped. 0 disables. Must
+// be called before the handler is used concurrently.
+func (h *DoveadmEventHandler) SetRedundantSyncWindow(window time.Duration) {
+	if window <= 0 {
+		h.syncCache = nil
+		return
+	}
+	h.syncCache = &syncCache{window: window, syncs: make(map[string]cachedSync), lastSweep: time.Now()}
+}
+
+// rememberSync records a complete and successful sync.
+func (h *DoveadmEventHandler) rememberSync(stateKey string, started, completed time.Time) {
+	c := h.syncCache
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncs[stateKey] = cachedSync{started: started, completed: completed}
+	if completed.Sub(c.lastSweep) >= c.window {
+		c.lastSweep = completed
+		for key, cached := range c.syncs {
+			if completed.Sub(cached.completed) >= c.window {
+				delete(c.syncs, key)
+			}
+		}
+	}
+}
+
+// forgetSync drops the recorded sync of a user, e.g. after a failed or
+// partial sync.
+func (h *DoveadmEventHandler) forgetSync(stateKey string) {
+	c := h.syncCache
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.syncs, stateKey)
+}
+
+// redundantSync reports whether the user was synced successfully within the
+// window and no event arrived since that sync started. Event times are stored
+// in seconds, so events within the second the sync started count as new.
+func (h *DoveadmEventHandler) redundantSync(ctx context.Context, stateKey, username string, logger *slog.Logger) bool {
+	c := h.syncCache
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	cached, ok := c.syncs[stateKey]
+	c.mu.Unlock()
+	if !ok || time.Since(cached.completed) >= c.window {
+		return false
+	}
+	lastEvent, err := h.queue.GetLastEventTime(ctx, username)
+	if err != nil {
+		logger.Warn("Failed to read the last event time, not skipping the sync", "username", username, "error", err)
+		return false
+	}
+	if !lastEvent.IsZero() && lastEvent.Unix() >= cached.started.Unix() {
+		return false
+	}
+	logger.Info("Skipping redundant sync, no events since the last sync", "username", username, "last_sync", cached.completed)
+	h.metrics.RedundantSyncsSkipped.Inc()
+	return true
+}
@@ -0,0 +1,63 @@
+package queue
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm/doveadmtest"
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRedundantSyncSkipped(t *testing.T) {
+	fake := doveadmtest.New("secret", nil)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.Background()
+	q := NewNativeQueue(testLogger())
+	m := metrics.New(prometheus.NewRegistry())
+	h := NewDoveadmEventHandler(srv.URL, "secret", "imap", testLogger(), q, m)
+	h.SetRedundantSyncWindow(time.Hour)
+
+	// full syncs are never skipped, the following incremental ones are
+	for range 3 {
+		if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	}
+	if got := fake.Stats().Syncs["user-a"]; got != 1 {
+		t.Fatalf("syncs = %d, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.RedundantSyncsSkipped); got != 2 {
+		t.Errorf("skipped = %v, want 2", got)
+	}
+
+	// an event since the last sync makes the next one necessary
+	if err := q.EnqueueEvent(ctx, "user-a", 1.0, &EventInfo{TriggeredAt: time.Now()}); err != nil {
+		t.Fatalf("EnqueueEvent: %v", err)
+	}
+	if _, err := q.Dequeue(ctx); err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	if err := q.Ack(ctx, "user-a"); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if err := h.Handle(ctx, QueueItem{Username: "user-a"}); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if got := fake.Stats().Syncs["user-a"]; got != 2 {
+		t.Errorf("syncs = %d, want 2", got)
+	}
+
+	// syncs queued through the admin API are never skipped
+	if err := h.Handle(ctx, QueueItem{Username: "user-a", Info: &EventInfo{Origin: OriginAdmin}}); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if got := fake.Stats().Syncs["user-a"]; got != 3 {
+		t.Errorf("syncs = %d, want 3", got)
+	}
+}
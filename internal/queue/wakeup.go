@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WAKEUP is the pub/sub channel on which consumers sharing a Redis server
+// announce enqueues to each other.
+const WAKEUP = "wakeup"
+
+// wakeupPublishTimeout bounds a single PUBLISH of the wakeup publisher.
+const wakeupPublishTimeout = 5 * time.Second
+
+// wakeups publishes a message on the wakeup channel after local enqueues and
+// wakes the local consumer when another process published one. Enqueues that
+// happen while a message is being published are coalesced into the next one,
+// so that bursts cost one round trip per flight instead of one per user.
+type wakeups struct {
+	client  *redis.Client
+	channel string
+	id      string // payload of the own messages, which are ignored
+	pubsub  *redis.PubSub
+	onWake  func()
+	logger  *slog.Logger
+
+	kick   chan struct{}
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// EnableWakeups publishes a message on the wakeup channel of the namespace
+// after users were enqueued and subscribes to it, so that the worker pools of
+// other processes sharing the Redis server poll right away instead of after
+// their fetch backoff. Must be called before the queue is used concurrently.
+func (q *InMemoryQueue) EnableWakeups(ctx context.Context) error {
+	if q.wakeups != nil {
+		return nil
+	}
+	channel := fmt.Sprintf("%s:%s", q.ns, WAKEUP)
+	pubsub := q.client.Subscribe(ctx, channel)
+	// wait for the confirmation, so that a server refusing pub/sub fails here
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+	w := &wakeups{
+		client:  q.client,
+		channel: channel,
+		id:      rand.Text(),
+		pubsub:  pubsub,
+		onWake:  q.notifyEnqueueFn,
+		logger:  q.logger,
+		kick:    make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}
+	w.wg.Add(2)
+	go w.publish()
+	go w.receive()
+	q.wakeups = w
+	return nil
+}
+
+// signal requests a message on the wakeup channel without waiting for it.
+func (w *wakeups) signal() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (w *wakeups) publish() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-w.kick:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), wakeupPublishTimeout)
+		err := w.client.Publish(ctx, w.channel, w.id).Err()
+		cancel()
+		if err != nil {
+			// the other consumers still find the users at their next poll
+			w.logger.Warn("Failed to publish wakeup", "channel", w.channel, "error", err)
+		}
+	}
+}
+
+func (w *wakeups) receive() {
+	defer w.wg.Done()
+	// the channel is closed when the subscription is; go-redis reconnects it
+	for msg := range w.pubsub.Channel() {
+		if msg.Payload != w.id {
+			w.onWake()
+		}
+	}
+}
+
+func (w *wakeups) stop() {
+	w.once.Do(func() {
+		close(w.stopCh)
+		_ = w.pubsub.Close()
+		w.wg.Wait()
+	})
+}
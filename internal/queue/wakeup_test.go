@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestWakeupsWakeOtherConsumers(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	producer, err := NewExternalQueue("wakeup", mr.Addr(), "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = producer.Close() }()
+	// a second process sharing the Redis server of the producer
+	consumer, err := NewExternalQueue("wakeup", mr.Addr(), "", testLogger())
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() { _ = consumer.Close() }()
+
+	producerWoken := make(chan struct{}, 10)
+	consumerWoken := make(chan struct{}, 10)
+	producer.NotifyEnqueue(func() { producerWoken <- struct{}{} })
+	consumer.NotifyEnqueue(func() { consumerWoken <- struct{}{} })
+	if err := producer.EnableWakeups(ctx); err != nil {
+		t.Fatalf("EnableWakeups: %v", err)
+	}
+	if err := consumer.EnableWakeups(ctx); err != nil {
+		t.Fatalf("EnableWakeups: %v", err)
+	}
+
+	if err := producer.Enqueue(ctx, "alice", 1.0); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	select {
+	case <-consumerWoken:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer not woken by the enqueue of another process")
+	}
+	if users, err := consumer.DequeueN(ctx, 1); err != nil || len(users) != 1 || users[0].Username != "alice" {
+		t.Fatalf("DequeueN = %v, %v; want alice", users, err)
+	}
+
+	// the producer is notified of its own enqueue once, not again by its message
+	<-producerWoken
+	select {
+	case <-producerWoken:
+		t.Error("producer woken by its own wakeup")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
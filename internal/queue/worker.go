@@ -2,17 +2,23 @@ package queue
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/dovewarden/dovewarden/internal/errreport"
 )
 
 // EventHandler is the interface for handling dequeued events.
 type EventHandler interface {
-	// Handle processes an event for the given username.
-	// Returns error if handling failed (event will be requeued).
-	Handle(ctx context.Context, username string) error
+	// Handle processes the dequeued item of a user, whose Info carries the
+	// context of its events and the failed attempts before.
+	// Returns error if handling failed (event will be requeued). A *Result
+	// error tells the pool how to retry instead.
+	Handle(ctx context.Context, item QueueItem) error
 }
 
 // DefaultEventHandler is a placeholder implementation that just logs the username.
@@ -21,8 +27,8 @@ type DefaultEventHandler struct {
 }
 
 // Handle logs the username (placeholder for actual handling).
-func (h *DefaultEventHandler) Handle(ctx context.Context, username string) error {
-	h.logger.Info("Handling event", "username", username)
+func (h *DefaultEventHandler) Handle(ctx context.Context, item QueueItem) error {
+	h.logger.Info("Handling event", "username", item.Username)
 	return nil
 }
 
@@ -33,25 +39,103 @@ type WorkerPool struct {
 	handler    EventHandler
 	logger     *slog.Logger
 
+	// wrapped around the handler when the pool starts
+	middlewares []Middleware
+
 	// Channels for coordination
 	stopCh chan struct{}
 	wg     sync.WaitGroup
 
-	// internal pipe for jobs
-	jobsCh chan string
+	// internal pipe for jobs, buffered to hand one job to every worker at once
+	jobsCh chan QueueItem
+
+	// signals the fetcher that a worker finished a job
+	freeCh chan struct{}
+
+	// wakes the fetcher from its idle wait when a user was enqueued or requeued
+	wakeCh chan struct{}
+
+	// upper bound of the fetcher's idle and error backoff
+	maxFetchBackoff time.Duration
 
 	activeCount int32
+
+	// jobs being handled by username, requeued if Stop times out
+	activeMu   sync.Mutex
+	activeJobs map[string]QueueItem
+
+	// claims older than this are requeued as abandoned; 0 disables
+	recoveryAge  time.Duration
+	lastRecovery time.Time
+
+	// whether the queue outlives the process; otherwise jobs are neither saved
+	// for recovery nor requeued at shutdown, as the next process would not see them
+	durable bool
+
+	// unix nanoseconds of the fetcher's last loop iteration, for liveness checks
+	lastFetch atomic.Int64
+
+	// optional minimum interval between syncs of a user
+	rateLimit    atomic.Pointer[SyncRateLimit]
+	lastPromoted time.Time
+	// set once a failed or postponed user was deferred, so the fetcher promotes it later
+	retriesDeferred atomic.Bool
+	// set while the destination is down; the fetcher leaves users queued
+	held atomic.Bool
+	// set while a standby mirrors its primary; like held, but not lifted by Resume
+	standby atomic.Bool
+	// unix nanoseconds until which the fetcher leaves users queued after doveadm shed load
+	coolDownUntil atomic.Int64
+
+	// optional bound of immediate retries; nil retries every failure right away
+	retryBudget *RetryBudget
+	// optional retry policies replacing the immediate retry per origin
+	retryPolicies map[Origin]RetryPolicy
+
+	// recently completed syncs, for the backlog ETA
+	throughput *Throughput
+
+	// optional error tracker; nil discards reports
+	reporter *errreport.Reporter
+	// consecutive failed syncs across all users before reporting a systemic problem
+	failureReportThreshold int64
+	consecutiveFailures    atomic.Int64
 }
 
+// deferredPromoteInterval is how often the fetcher moves rate-limited users whose
+// interval has passed back into the queue.
+const deferredPromoteInterval = time.Second
+
+// activeRequeueTimeout bounds handing the jobs in progress back to the queue
+// once Stop timed out.
+const activeRequeueTimeout = 5 * time.Second
+
+// minFetchBackoff is the fetcher's first wait after finding the queue empty or
+// failing to dequeue. Each further empty poll or error doubles the wait, up to
+// the pool's maximum backoff.
+const minFetchBackoff = 10 * time.Millisecond
+
+// DefaultMaxFetchBackoff is the default maximum wait of the fetcher between polls
+// of an empty queue.
+const DefaultMaxFetchBackoff = time.Second
+
 // NewWorkerPool creates a new worker pool with the specified number of workers.
 func NewWorkerPool(q Queue, numWorkers int, logger *slog.Logger) *WorkerPool {
+	d, ok := q.(DurableQueue)
 	return &WorkerPool{
 		queue:      q,
 		numWorkers: numWorkers,
 		handler:    &DefaultEventHandler{logger: logger},
 		logger:     logger,
 		stopCh:     make(chan struct{}),
-		jobsCh:     make(chan string, 1),
+		jobsCh:     make(chan QueueItem, max(numWorkers, 1)),
+		freeCh:     make(chan struct{}, 1),
+		wakeCh:     make(chan struct{}, 1),
+		throughput: NewThroughput(throughputWindow),
+		activeJobs: make(map[string]QueueItem),
+		durable:    ok && d.Durable(),
+
+		maxFetchBackoff: DefaultMaxFetchBackoff,
 	}
 }
 
@@ -60,8 +144,69 @@ func (wp *WorkerPool) SetHandler(handler EventHandler) {
 	wp.handler = handler
 }
 
+// SetMaxFetchBackoff caps the wait of the fetcher between polls of an empty or
+// failing queue. Queues implementing EnqueueNotifier wake the fetcher as soon
+// as a user is enqueued, so the cap mostly bounds how late deferred users and
+// users enqueued by other processes are picked up. Must be called before Start.
+func (wp *WorkerPool) SetMaxFetchBackoff(d time.Duration) {
+	wp.maxFetchBackoff = max(d, minFetchBackoff)
+}
+
+// Use wraps the handler with the middlewares when the pool starts, the first
+// being the outermost. Must be called before Start.
+func (wp *WorkerPool) Use(middlewares ...Middleware) {
+	wp.middlewares = append(wp.middlewares, middlewares...)
+}
+
+// SetErrorReporter reports handler panics, dequeue errors and runs of
+// threshold consecutive failed syncs across all users to an error tracker.
+// A threshold of 0 disables reporting failed syncs.
+func (wp *WorkerPool) SetErrorReporter(r *errreport.Reporter, threshold int) {
+	wp.reporter = r
+	wp.failureReportThreshold = int64(threshold)
+}
+
+// SetRetryBudget bounds the immediate retries of failed users, see RetryBudget.
+// Must be called before Start.
+func (wp *WorkerPool) SetRetryBudget(b *RetryBudget) {
+	wp.retryBudget = b
+}
+
+// SetJobRecoveryAge requeues users claimed longer than age ago when the pool
+// starts and every age thereafter, as their sync was abandoned by a process
+// that stopped without finishing it, e.g. after a crash. It must exceed the
+// longest sync, since a sync still running is repeated. 0 disables. Only
+// queues implementing DurableQueue keep the claims of a stopped process, so
+// jobs are not recovered from other queues.
+func (wp *WorkerPool) SetJobRecoveryAge(age time.Duration) {
+	wp.recoveryAge = age
+}
+
+// SetRetryPolicies replaces the immediate retry of failed users by the policy
+// of the origin they were queued with, see RetryPolicy. Origins without a
+// policy are retried right away. Must be called before Start.
+func (wp *WorkerPool) SetRetryPolicies(policies map[Origin]RetryPolicy) {
+	wp.retryPolicies = policies
+}
+
+// SetRateLimit enforces a minimum interval between syncs of the same user. Users
+// dequeued before their interval has passed are deferred instead of synced.
+// It may be called while the pool is running.
+func (wp *WorkerPool) SetRateLimit(limit *SyncRateLimit) {
+	if limit != nil && !limit.Enabled() {
+		limit = nil
+	}
+	wp.rateLimit.Store(limit)
+}
+
 // Start begins processing events from the queue with the configured number of workers.
 func (wp *WorkerPool) Start(ctx context.Context) {
+	wp.lastFetch.Store(time.Now().UnixNano())
+	wp.handler = Chain(wp.handler, wp.middlewares...)
+	if n, ok := wp.queue.(EnqueueNotifier); ok {
+		n.NotifyEnqueue(wp.wake)
+	}
+
 	// Start fetcher goroutine that pulls from Redis and pushes into jobsCh
 	wp.wg.Add(1)
 	go wp.fetcher(ctx)
@@ -74,53 +219,156 @@ func (wp *WorkerPool) Start(ctx context.Context) {
 	wp.logger.Info("Worker pool started", "num_workers", wp.numWorkers)
 }
 
-// fetcher continuously dequeues from the backend and pushes into jobsCh
+// fetcher continuously dequeues batches from the backend and pushes them into jobsCh.
+// Each round trip pulls at most as many users as workers are free to take them,
+// so that no user is claimed while waiting for a worker. While users are found
+// it polls again right away; an empty queue or a failing backend backs off
+// exponentially up to maxFetchBackoff, and an empty queue wait ends early when
+// a user is enqueued. A single timer is reused for all waits, and the dequeue
+// relies on the backend's own timeouts, so that a poll allocates nothing
+// beyond the dequeued batch.
 func (wp *WorkerPool) fetcher(ctx context.Context) {
 	defer wp.wg.Done()
+	defer wp.stopFetching(ctx)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	var backoff time.Duration
 	for {
+		wp.lastFetch.Store(time.Now().UnixNano())
+
 		select {
 		case <-wp.stopCh:
-			// stop fetching new jobs
-			close(wp.jobsCh) // signal no more jobs
-			wp.logger.Debug("Fetcher stopping")
 			return
 		default:
 		}
 
-		// Try to dequeue with timeout
-		dequeueCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
-		username, err := wp.queue.Dequeue(dequeueCtx)
-		cancel()
+		if wp.durable && wp.recoveryAge > 0 && time.Since(wp.lastRecovery) >= wp.recoveryAge {
+			wp.recoverJobs(ctx)
+		}
+
+		// keep promoting after rate limiting was disabled, so deferred users are not stranded
+		if (wp.rateLimit.Load() != nil || wp.retriesDeferred.Load() || !wp.lastPromoted.IsZero()) && time.Since(wp.lastPromoted) >= deferredPromoteInterval {
+			wp.promoteDeferred(ctx)
+		}
+
+		if wp.held.Load() || wp.standby.Load() {
+			// leave users queued until Resume or SetStandby wakes the fetcher
+			timer.Reset(wp.maxFetchBackoff)
+			select {
+			case <-wp.stopCh:
+				timer.Stop()
+				return
+			case <-wp.wakeCh:
+				timer.Stop()
+			case <-timer.C:
+			}
+			continue
+		}
 
+		if wait := time.Until(time.Unix(0, wp.coolDownUntil.Load())); wait > 0 {
+			// enqueues do not end a cool-down, so the wake channel is ignored
+			timer.Reset(min(wait, wp.maxFetchBackoff))
+			select {
+			case <-wp.stopCh:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+
+		free := wp.numWorkers - int(wp.ActiveCount()) - len(wp.jobsCh)
+		if free <= 0 {
+			// all workers busy; polled again once one finished (provides backpressure)
+			select {
+			case <-wp.stopCh:
+				return
+			case <-wp.freeCh:
+			}
+			continue
+		}
+
+		items, err := wp.queue.DequeueN(ctx, free)
 		if err != nil {
 			wp.logger.Error("Failed to dequeue", "error", err)
-			// brief backoff
+			wp.reporter.ReportError(errreport.KindQueueError, "failed to dequeue", err, nil)
+			backoff = wp.nextBackoff(backoff)
+			timer.Reset(backoff)
 			select {
 			case <-wp.stopCh:
-				close(wp.jobsCh)
+				timer.Stop()
 				return
-			case <-time.After(100 * time.Millisecond):
+			case <-timer.C:
 			}
 			continue
 		}
 
-		if username == "" {
-			// empty queue, wait a bit
+		if len(items) == 0 {
+			backoff = wp.nextBackoff(backoff)
+			timer.Reset(backoff)
 			select {
 			case <-wp.stopCh:
-				close(wp.jobsCh)
+				timer.Stop()
 				return
-			case <-time.After(300 * time.Millisecond):
+			case <-wp.wakeCh:
+				timer.Stop()
+				backoff = 0
+			case <-timer.C:
 			}
 			continue
 		}
+		backoff = 0
+
+		// does not block: the batch fits into the free slots of the buffer
+		for i, item := range items {
+			select {
+			case <-wp.stopCh:
+				// hand undelivered users back to the queue so they are not lost
+				wp.requeue(ctx, items[i:])
+				return
+			case wp.jobsCh <- item:
+			}
+		}
+	}
+}
 
-		// push job into pipe; block if workers are busy (provides backpressure)
+// stopFetching hands the jobs no worker has taken yet back to the queue and
+// closes jobsCh, so that the workers exit once their current job is done.
+func (wp *WorkerPool) stopFetching(ctx context.Context) {
+	var pending []QueueItem
+	for len(wp.jobsCh) > 0 {
 		select {
-		case <-wp.stopCh:
-			close(wp.jobsCh)
-			return
-		case wp.jobsCh <- username:
+		case item := <-wp.jobsCh:
+			pending = append(pending, item)
+		default:
+			// taken by a worker meanwhile
+		}
+	}
+	wp.requeue(ctx, pending)
+	close(wp.jobsCh) // signal no more jobs
+	wp.logger.Debug("Fetcher stopping")
+}
+
+// nextBackoff doubles the fetcher's backoff, starting at minFetchBackoff and
+// capped at maxFetchBackoff.
+func (wp *WorkerPool) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff < minFetchBackoff {
+		return min(minFetchBackoff, wp.maxFetchBackoff)
+	}
+	return min(2*backoff, wp.maxFetchBackoff)
+}
+
+// requeue puts dequeued but unprocessed users back into the queue with their
+// event info. The claim is released first, as a release after the requeue
+// could drop the claim of another consumer that dequeued the user meanwhile.
+func (wp *WorkerPool) requeue(ctx context.Context, items []QueueItem) {
+	for _, item := range items {
+		if err := wp.queue.Ack(ctx, item.Username); err != nil {
+			wp.logger.Warn("Failed to release in-flight claim", "username", item.Username, "error", err)
+		}
+		if err := wp.queue.EnqueueItem(ctx, item); err != nil {
+			wp.logger.Error("Failed to requeue undelivered job", "username", item.Username, "error", err)
 		}
 	}
 }
@@ -136,38 +384,344 @@ func (wp *WorkerPool) worker(ctx context.Context, id int) {
 		default:
 		}
 
-		username, ok := wp.takeJob()
+		item, ok := wp.takeJob()
 		if !ok {
 			// jobsCh closed and drained
 			wp.logger.Debug("Worker stopping", "worker_id", id)
 			return
 		}
 
+		username, info := item.Username, item.Info
+
 		// mark active
 		atomic.AddInt32(&wp.activeCount, 1)
 		wp.logger.Debug("Processing event", "worker_id", id, "username", username)
 
-		// Handle the event
-		if err := wp.handler.Handle(ctx, username); err != nil {
-			wp.logger.Error("Handler failed, requeuing", "worker_id", id, "username", username, "error", err)
-			if err := wp.queue.Enqueue(ctx, username, 1.0); err != nil {
-				wp.logger.Error("Failed to requeue", "worker_id", id, "username", username, "error", err)
+		// Defer users synced too recently, keeping their event info for the deferred sync
+		if wp.deferIfRateLimited(ctx, id, username, info) {
+			if err := wp.queue.Ack(ctx, username); err != nil {
+				wp.logger.Warn("Failed to release in-flight claim", "worker_id", id, "username", username, "error", err)
 			}
+			wp.release()
+			continue
+		}
+
+		// Attach the accumulated event context for helpers reading it from the context
+		jobCtx := ctx
+		if info != nil {
+			jobCtx = WithEventInfo(ctx, info)
+			if wp.durable {
+				if err := wp.queue.SaveJob(ctx, username, info); err != nil {
+					wp.logger.Warn("Failed to save job, its event info is lost if the process stops during the sync", "worker_id", id, "username", username, "error", err)
+				}
+			}
+		}
+		wp.activeMu.Lock()
+		wp.activeJobs[username] = item
+		wp.activeMu.Unlock()
+
+		if wp.retryBudget != nil {
+			wp.retryBudget.Attempt(username)
+		}
+
+		// Handle the event, releasing the claim before the user is requeued or deferred
+		err := wp.handle(jobCtx, id, item)
+		if err := wp.queue.Ack(ctx, username); err != nil {
+			wp.logger.Warn("Failed to release in-flight claim", "worker_id", id, "username", username, "error", err)
+		}
+		if err != nil && ResultOf(err).UserDeleted {
+			wp.logger.Info("User no longer exists, not retrying", "worker_id", id, "username", username)
+		} else if err != nil && ResultOf(err).Postponed {
+			wp.postpone(ctx, id, username, info, ResultOf(err))
+		} else if err != nil {
+			wp.countFailure(err)
+			if err := wp.queue.RecordFailure(ctx, username); err != nil {
+				wp.logger.Warn("Failed to record failure", "worker_id", id, "username", username, "error", err)
+			}
+			wp.retry(ctx, id, item, ResultOf(err))
+		} else {
+			wp.consecutiveFailures.Store(0)
+			wp.throughput.Add(time.Now())
+			if err := wp.queue.ClearFailure(ctx, username); err != nil {
+				wp.logger.Warn("Failed to clear failure", "worker_id", id, "username", username, "error", err)
+			}
+		}
+		wp.activeMu.Lock()
+		delete(wp.activeJobs, username)
+		wp.activeMu.Unlock()
+
+		wp.release()
+	}
+}
+
+// retry applies the hints of a failed handling: it drops the replication state
+// if a full sync is required, then requeues the user right away, defers it, or
+// gives up on it. Retries are queued with OriginRetry; only immediate ones keep
+// the event info of the failed sync.
+func (wp *WorkerPool) retry(ctx context.Context, id int, item QueueItem, result Result) {
+	username, info := item.Username, item.Info
+	logger := eventLogger(info, wp.logger)
+	policy, hasPolicy := wp.retryPolicies[originOf(info)]
+	attempts := 1
+	if info != nil {
+		attempts = info.Attempts + 1
+	}
+	deferred := &EventInfo{Origin: OriginRetry, Attempts: attempts}
+	if result.FullSync {
+		if err := wp.queue.DeleteReplicationState(ctx, username); err != nil {
+			logger.Error("Failed to drop replication state for a full sync", "worker_id", id, "username", username, "error", err)
+		}
+	}
+
+	if result.CoolDown > 0 {
+		wp.CoolDown(result.CoolDown)
+	}
+
+	switch {
+	case result.Permanent:
+		logger.Error("Handler failed permanently, not retrying", "worker_id", id, "username", username, "error", result.Err)
+	case result.RetryAfter > 0:
+		until := time.Now().Add(result.RetryAfter)
+		logger.Error("Handler failed, retrying later", "worker_id", id, "username", username, "error", result.Err, "until", until)
+		if err := wp.queue.DeferEvent(ctx, username, until, deferred); err != nil {
+			logger.Error("Failed to defer retry", "worker_id", id, "username", username, "error", err)
+			return
+		}
+		wp.retriesDeferred.Store(true)
+		if wp.retryBudget != nil {
+			wp.retryBudget.Deferred(username)
+		}
+	case hasPolicy && policy.Disabled:
+		logger.Error("Handler failed, retries of the origin are disabled, not retrying", "worker_id", id, "username", username, "error", result.Err)
+	case hasPolicy && policy.Delay > 0:
+		until := time.Now().Add(policy.Delay)
+		logger.Error("Handler failed, retrying later by the policy of the origin", "worker_id", id, "username", username, "error", result.Err, "until", until)
+		if err := wp.queue.DeferEvent(ctx, username, until, deferred); err != nil {
+			logger.Error("Failed to defer retry", "worker_id", id, "username", username, "error", err)
+			return
+		}
+		wp.retriesDeferred.Store(true)
+	case wp.retryBudget != nil && !wp.retryBudget.Allow(username):
+		until := time.Now().Add(wp.retryBudget.Delay())
+		logger.Error("Handler failed, retry budget exhausted, retrying later", "worker_id", id, "username", username, "error", result.Err, "until", until)
+		if err := wp.queue.DeferEvent(ctx, username, until, deferred); err != nil {
+			logger.Error("Failed to defer retry", "worker_id", id, "username", username, "error", err)
+			return
+		}
+		wp.retriesDeferred.Store(true)
+	default:
+		logger.Error("Handler failed, requeuing", "worker_id", id, "username", username, "error", result.Err)
+		// keep the event info so that the retry sees the same context
+		retry := EventInfo{Origin: OriginRetry, Attempts: attempts}
+		if info != nil {
+			retry = *info
+			retry.Origin = OriginRetry
+			retry.Attempts = attempts
+		}
+		if err := wp.queue.EnqueueEvent(ctx, username, item.priorityFactor(), &retry); err != nil {
+			logger.Error("Failed to requeue", "worker_id", id, "username", username, "error", err)
+		} else {
+			wp.wake()
+		}
+	}
+}
+
+// postpone defers a user whose sync the handler postponed, keeping its event
+// info for the deferred sync.
+func (wp *WorkerPool) postpone(ctx context.Context, id int, username string, info *EventInfo, result Result) {
+	until := time.Now().Add(result.RetryAfter)
+	logger := eventLogger(info, wp.logger)
+	if err := wp.queue.DeferEvent(ctx, username, until, info); err != nil {
+		logger.Error("Failed to defer postponed sync", "worker_id", id, "username", username, "error", err)
+		return
+	}
+	logger.Debug("Postponed sync", "worker_id", id, "username", username, "reason", result.Err, "until", until)
+	wp.retriesDeferred.Store(true)
+}
+
+// release marks a worker inactive and lets a fetcher waiting for a free
+// worker poll the queue.
+func (wp *WorkerPool) release() {
+	atomic.AddInt32(&wp.activeCount, -1)
+	select {
+	case wp.freeCh <- struct{}{}:
+	default:
+	}
+}
+
+// handle runs the handler, turning a panic into an error so that a single
+// user cannot crash the process.
+func (wp *WorkerPool) handle(ctx context.Context, id int, item QueueItem) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			jobLogger(ctx, wp.logger).Error("Handler panicked", "worker_id", id, "username", item.Username, "panic", v)
+			wp.reporter.ReportPanic(v, map[string]string{"worker_id": strconv.Itoa(id)})
+			err = fmt.Errorf("handler panicked: %v", v)
 		}
+	}()
+	return wp.handler.Handle(ctx, item)
+}
+
+// countFailure reports a run of consecutive failed syncs once it reaches the
+// threshold, as it hints at a systemic problem rather than a single user.
+func (wp *WorkerPool) countFailure(err error) {
+	n := wp.consecutiveFailures.Add(1)
+	if wp.failureReportThreshold > 0 && n == wp.failureReportThreshold {
+		wp.reporter.ReportError(errreport.KindHandlerFailures,
+			fmt.Sprintf("%d consecutive syncs failed", n), err, nil)
+	}
+}
+
+// Hold stops the pool from dequeuing users, e.g. while the sync destination is
+// down, so that they stay queued instead of failing and being retried. Jobs
+// already handed to workers still run.
+func (wp *WorkerPool) Hold() {
+	wp.held.Store(true)
+}
 
-		// mark inactive
-		atomic.AddInt32(&wp.activeCount, -1)
+// Resume lets the pool dequeue users again after Hold. The fetcher polls right
+// away, and the users queued meanwhile are synced at full concurrency.
+func (wp *WorkerPool) Resume() {
+	if wp.held.Swap(false) {
+		wp.wake()
 	}
 }
 
+// SetStandby keeps the pool from dequeuing users while standby is true, e.g.
+// while a Standby mirrors its primary. Unlike Hold, Resume does not lift it.
+func (wp *WorkerPool) SetStandby(standby bool) {
+	if wp.standby.Swap(standby) && !standby {
+		wp.wake()
+	}
+}
+
+// Held reports whether the pool is held.
+func (wp *WorkerPool) Held() bool {
+	return wp.held.Load()
+}
+
+// CoolDown stops the pool from dequeuing users for d, e.g. while doveadm sheds
+// load, extending a cool-down already in progress if it ends earlier. Jobs
+// already handed to workers still run.
+func (wp *WorkerPool) CoolDown(d time.Duration) {
+	until := time.Now().Add(d).UnixNano()
+	for {
+		current := wp.coolDownUntil.Load()
+		if current >= until {
+			return
+		}
+		if wp.coolDownUntil.CompareAndSwap(current, until) {
+			wp.logger.Warn("Cooling down, not dequeuing users", "duration", d)
+			return
+		}
+	}
+}
+
+// CoolingDown reports whether the pool is in a cool-down.
+func (wp *WorkerPool) CoolingDown() bool {
+	return time.Now().UnixNano() < wp.coolDownUntil.Load()
+}
+
+// wake lets an idle fetcher poll the queue right away instead of waiting.
+func (wp *WorkerPool) wake() {
+	select {
+	case wp.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// recoverJobs requeues the users claimed longer than the recovery age ago.
+func (wp *WorkerPool) recoverJobs(ctx context.Context) {
+	wp.lastRecovery = time.Now()
+	recovered, err := wp.queue.RecoverJobs(ctx, wp.lastRecovery.Add(-wp.recoveryAge))
+	if len(recovered) > 0 {
+		wp.logger.Warn("Requeued users whose sync was abandoned", "count", len(recovered), "usernames", recovered)
+	}
+	if err != nil {
+		wp.logger.Error("Failed to recover abandoned jobs", "error", err)
+	}
+}
+
+// requeueActive hands the jobs still being handled back to the queue with
+// their event info, so that syncs cut short by the shutdown are not lost. A
+// sync that completes nevertheless is repeated. A queue that does not outlive
+// the process would lose them anyway, so they are only logged then.
+func (wp *WorkerPool) requeueActive(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), activeRequeueTimeout)
+	defer cancel()
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+	if !wp.durable {
+		for username, item := range wp.activeJobs {
+			eventLogger(item.Info, wp.logger).Warn("Sync in progress at shutdown is lost, as the queue does not outlive the process", "username", username)
+		}
+		return
+	}
+	for username, item := range wp.activeJobs {
+		info := item.Info
+		// released first, like in requeue
+		if err := wp.queue.Ack(ctx, username); err != nil {
+			wp.logger.Warn("Failed to release in-flight claim", "username", username, "error", err)
+		}
+		if err := wp.queue.EnqueueItem(ctx, item); err != nil {
+			eventLogger(info, wp.logger).Error("Failed to requeue job in progress", "username", username, "error", err)
+			continue
+		}
+		eventLogger(info, wp.logger).Warn("Requeued job still in progress at shutdown", "username", username)
+	}
+}
+
+// promoteDeferred moves rate-limited users whose interval has passed back into the queue.
+func (wp *WorkerPool) promoteDeferred(ctx context.Context) {
+	wp.lastPromoted = time.Now()
+	promoted, err := wp.queue.PromoteDeferred(ctx)
+	if err != nil {
+		wp.logger.Error("Failed to promote deferred users", "error", err)
+		return
+	}
+	if promoted > 0 {
+		wp.logger.Debug("Promoted rate-limited users back into the queue", "count", promoted)
+	}
+}
+
+// deferIfRateLimited defers the user if its last sync is more recent than the
+// configured minimum interval, keeping info for the deferred sync. Returns true
+// if the user was deferred.
+func (wp *WorkerPool) deferIfRateLimited(ctx context.Context, id int, username string, info *EventInfo) bool {
+	limit := wp.rateLimit.Load()
+	if limit == nil {
+		return false
+	}
+	interval := limit.Interval(username)
+	if interval <= 0 {
+		return false
+	}
+	last, err := wp.queue.GetLastReplicationTime(ctx, username)
+	if err != nil {
+		wp.logger.Warn("Failed to read last replication time, not rate limiting", "worker_id", id, "username", username, "error", err)
+		return false
+	}
+	until := last.Add(interval)
+	if last.IsZero() || !until.After(time.Now()) {
+		return false
+	}
+	if err := wp.queue.DeferEvent(ctx, username, until, info); err != nil {
+		wp.logger.Error("Failed to defer rate-limited user, syncing now", "worker_id", id, "username", username, "error", err)
+		return false
+	}
+	wp.logger.Debug("Deferred rate-limited user", "worker_id", id, "username", username, "until", until)
+	return true
+}
+
 // takeJob reads a single job from jobsCh, blocking until available or channel closed.
-func (wp *WorkerPool) takeJob() (string, bool) {
-	username, ok := <-wp.jobsCh
-	return username, ok
+func (wp *WorkerPool) takeJob() (QueueItem, bool) {
+	item, ok := <-wp.jobsCh
+	return item, ok
 }
 
 // Stop gracefully shuts down the worker pool.
 // It stops accepting new tasks and waits for all active tasks to complete.
+// If ctx expires first, the tasks still active are requeued.
 func (wp *WorkerPool) Stop(ctx context.Context) error {
 	wp.logger.Info("Stopping worker pool")
 	// signal to stop
@@ -190,6 +744,7 @@ func (wp *WorkerPool) Stop(ctx context.Context) error {
 		wp.logger.Info("Worker pool stopped gracefully")
 		return nil
 	case <-ctx.Done():
+		wp.requeueActive(ctx)
 		return ctx.Err()
 	}
 }
@@ -198,3 +753,42 @@ func (wp *WorkerPool) Stop(ctx context.Context) error {
 func (wp *WorkerPool) ActiveCount() int32 {
 	return atomic.LoadInt32(&wp.activeCount)
 }
+
+// Throughput returns the counter of syncs completed by the pool.
+func (wp *WorkerPool) Throughput() *Throughput {
+	return wp.throughput
+}
+
+// ConsecutiveFailures returns the number of syncs in a row that failed across
+// all users, reset by the next successful sync.
+func (wp *WorkerPool) ConsecutiveFailures() int64 {
+	return wp.consecutiveFailures.Load()
+}
+
+// NumWorkers returns the configured number of workers.
+func (wp *WorkerPool) NumWorkers() int {
+	return wp.numWorkers
+}
+
+// Alive reports whether the pool is making progress: it has not been stopped,
+// and the fetcher polled the queue within maxStall or is waiting for workers
+// that are all busy.
+func (wp *WorkerPool) Alive(maxStall time.Duration) bool {
+	if wp.Stopped() {
+		return false
+	}
+	if time.Since(time.Unix(0, wp.lastFetch.Load())) < maxStall {
+		return true
+	}
+	return int(wp.ActiveCount()) >= wp.numWorkers
+}
+
+// Stopped reports whether Stop has been called.
+func (wp *WorkerPool) Stopped() bool {
+	select {
+	case <-wp.stopCh:
+		return true
+	default:
+		return false
+	}
+}
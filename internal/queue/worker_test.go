@@ -3,6 +3,7 @@ package queue
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"sync/atomic"
@@ -46,8 +47,8 @@ func TestWorkerPoolDequeue(t *testing.T) {
 	if err != nil {
 		t.Fatalf("dequeue failed: %v", err)
 	}
-	if dequeued != "" {
-		t.Fatalf("expected empty queue, got user: %s", dequeued)
+	if dequeued.Username != "" {
+		t.Fatalf("expected empty queue, got user: %s", dequeued.Username)
 	}
 
 	// Graceful shutdown
@@ -111,6 +112,52 @@ func TestWorkerPoolRequeueOnError(t *testing.T) {
 	}
 }
 
+// TestWorkerPoolRecoversHandlerPanic verifies that a panicking handler fails
+// the sync instead of crashing the process, and the user is retried.
+func TestWorkerPoolRecoversHandlerPanic(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	q, err := NewInMemoryQueue("test", "", logger)
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer func() {
+		if cerr := q.Close(); cerr != nil {
+			t.Fatalf("failed to close queue: %v", cerr)
+		}
+	}()
+
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+
+	wp := NewWorkerPool(q, 1, logger)
+	calls := int32(0)
+	wp.SetHandler(&TestHandler{
+		onHandle: func(username string) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				panic("simulated handler panic")
+			}
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp.Start(ctx)
+	time.Sleep(2 * time.Second)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wp.Stop(shutdownCtx); err != nil {
+		t.Fatalf("failed to stop worker pool: %v", err)
+	}
+
+	if atomic.LoadInt32(&calls) < 2 {
+		t.Fatalf("expected the user to be retried after the panic, got %d calls", atomic.LoadInt32(&calls))
+	}
+}
+
 // TestGracefulShutdown verifies that shutdown waits for active tasks.
 func TestGracefulShutdown(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
@@ -160,14 +207,241 @@ func TestGracefulShutdown(t *testing.T) {
 	}
 }
 
+// TestShutdownRequeuesActiveJobs verifies that syncs still running when the
+// shutdown times out are requeued with their event info.
+func TestShutdownRequeuesActiveJobs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		skipUnlessDurable(t, q)
+		ctx := context.Background()
+		if err := q.EnqueueEvent(ctx, "user-a", 1.0, &EventInfo{CmdName: "APPEND", Origin: OriginEvent}); err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+
+		unblock := make(chan struct{})
+		wp := NewWorkerPool(q, 1, testLogger())
+		wp.SetHandler(&TestHandler{onHandle: func(string) error {
+			<-unblock
+			return nil
+		}})
+		wp.Start(ctx)
+		defer close(unblock)
+		deadline := time.Now().Add(5 * time.Second)
+		for wp.ActiveCount() != 1 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		if err := wp.Stop(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Stop = %v, want a timeout", err)
+		}
+		if next := nextUsers(t, q); len(next) != 1 || next[0].Username != "user-a" {
+			t.Fatalf("queued %+v, want user-a", next)
+		}
+		if claims, _ := q.InFlight(ctx); len(claims) != 0 {
+			t.Errorf("claims %v left behind", claims)
+		}
+		if info, _ := q.TakeEventInfo(ctx, "user-a"); info == nil || info.CmdName != "APPEND" || info.Origin != OriginEvent {
+			t.Errorf("requeued with event info %+v", info)
+		}
+	})
+}
+
+// TestWorkerPoolRecoversAbandonedJobs verifies that the pool requeues users
+// claimed by a stopped process when it starts.
+func TestWorkerPoolRecoversAbandonedJobs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		skipUnlessDurable(t, q)
+		ctx := context.Background()
+		if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+		if _, err := q.Dequeue(ctx); err != nil {
+			t.Fatalf("dequeue failed: %v", err)
+		}
+		// claimed at second granularity
+		time.Sleep(1100 * time.Millisecond)
+
+		handled := make(chan string, 1)
+		wp := NewWorkerPool(q, 1, testLogger())
+		wp.SetJobRecoveryAge(time.Second)
+		wp.SetHandler(&TestHandler{onHandle: func(username string) error {
+			handled <- username
+			return nil
+		}})
+		wp.Start(ctx)
+		defer func() { _ = wp.Stop(ctx) }()
+		select {
+		case username := <-handled:
+			if username != "user-a" {
+				t.Errorf("handled %s, want user-a", username)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("abandoned user was not recovered")
+		}
+	})
+}
+
+// TestWorkerPoolWakesOnEnqueue verifies that an idle fetcher in a long backoff
+// picks up a newly enqueued user right away.
+func TestWorkerPoolWakesOnEnqueue(t *testing.T) {
+	for name, newQueue := range backends() {
+		t.Run(name, func(t *testing.T) {
+			q := newQueue(t)
+			defer func() { _ = q.Close() }()
+
+			handled := make(chan time.Time, 1)
+			wp := NewWorkerPool(q, 1, testLogger())
+			wp.SetMaxFetchBackoff(time.Hour)
+			wp.SetHandler(&TestHandler{onHandle: func(username string) error {
+				handled <- time.Now()
+				return nil
+			}})
+			ctx := context.Background()
+			wp.Start(ctx)
+			defer func() { _ = wp.Stop(ctx) }()
+
+			// let the backoff grow beyond a second
+			time.Sleep(1500 * time.Millisecond)
+			enqueued := time.Now()
+			if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+				t.Fatalf("enqueue: %v", err)
+			}
+			select {
+			case at := <-handled:
+				if delay := at.Sub(enqueued); delay > 500*time.Millisecond {
+					t.Fatalf("expected the enqueue to wake the fetcher, handled after %v", delay)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("user was not handled")
+			}
+		})
+	}
+}
+
+func TestFetchBackoff(t *testing.T) {
+	wp := NewWorkerPool(nil, 1, testLogger())
+	wp.SetMaxFetchBackoff(50 * time.Millisecond)
+	var backoff time.Duration
+	var got []time.Duration
+	for range 4 {
+		backoff = wp.nextBackoff(backoff)
+		got = append(got, backoff)
+	}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected backoffs %v, got %v", want, got)
+		}
+	}
+}
+
+func TestWorkerPoolCoolDown(t *testing.T) {
+	q := NewNativeQueue(testLogger())
+	defer func() { _ = q.Close() }()
+
+	var handled atomic.Int32
+	wp := NewWorkerPool(q, 1, testLogger())
+	wp.SetMaxFetchBackoff(10 * time.Millisecond)
+	wp.SetHandler(EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+		if handled.Add(1) == 1 {
+			return &Result{Err: errors.New("overloaded"), RetryAfter: time.Hour, CoolDown: 300 * time.Millisecond}
+		}
+		return nil
+	}))
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, "user-a", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	wp.Start(ctx)
+	defer func() { _ = wp.Stop(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !wp.CoolingDown() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !wp.CoolingDown() {
+		t.Fatal("expected the pool to cool down")
+	}
+	if err := q.Enqueue(ctx, "user-b", 1.0); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := handled.Load(); got != 1 {
+		t.Fatalf("expected no user to be dequeued during the cool-down, got %d handled", got)
+	}
+	for handled.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := handled.Load(); got != 2 {
+		t.Fatalf("expected user-b to be synced after the cool-down, got %d handled", got)
+	}
+}
+
 // TestHandler is a mock event handler for testing.
+func TestWorkerPoolRetryHints(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		ctx := context.Background()
+		if err := q.SetReplicationState(ctx, "user-full", "broken-state"); err != nil {
+			t.Fatalf("set state: %v", err)
+		}
+		var fullSyncAttempts atomic.Int32
+		handler := EventHandlerFunc(func(ctx context.Context, item QueueItem) error {
+			switch item.Username {
+			case "user-gone":
+				return fmt.Errorf("sync: %w", &Result{Err: errors.New("no such user"), Permanent: true})
+			case "user-busy":
+				return &Result{Err: errors.New("locked"), RetryAfter: time.Hour}
+			case "user-full":
+				if fullSyncAttempts.Add(1) == 1 {
+					return &Result{Err: errors.New("state broken"), FullSync: true}
+				}
+			}
+			return nil
+		})
+		for _, user := range []string{"user-gone", "user-busy", "user-full"} {
+			if err := q.Enqueue(ctx, user, 1.0); err != nil {
+				t.Fatalf("enqueue: %v", err)
+			}
+		}
+
+		wp := NewWorkerPool(q, 2, testLogger())
+		wp.SetHandler(handler)
+		wp.Start(ctx)
+		deadline := time.Now().Add(5 * time.Second)
+		for fullSyncAttempts.Load() < 2 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := wp.Stop(stopCtx); err != nil {
+			t.Fatalf("stop: %v", err)
+		}
+
+		if got := fullSyncAttempts.Load(); got != 2 {
+			t.Fatalf("expected user-full to be retried once, got %d attempts", got)
+		}
+		if state, _ := q.GetReplicationState(ctx, "user-full"); state != "" {
+			t.Fatalf("expected state to be dropped for the full sync, got %q", state)
+		}
+		status, err := q.Status(ctx, 10)
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		// user-gone is dropped, user-busy waits for its retry
+		if status.Queued != 0 || status.Deferred != 1 || status.Failed != 2 {
+			t.Fatalf("expected nothing queued, 1 deferred and 2 failed users, got %+v", status)
+		}
+	})
+}
+
 type TestHandler struct {
 	delay    time.Duration
 	failOnce bool
 	onHandle func(username string) error
 }
 
-func (h *TestHandler) Handle(ctx context.Context, username string) error {
+func (h *TestHandler) Handle(ctx context.Context, item QueueItem) error {
 	if h.delay > 0 {
 		select {
 		case <-time.After(h.delay):
@@ -177,7 +451,42 @@ func (h *TestHandler) Handle(ctx context.Context, username string) error {
 	}
 
 	if h.onHandle != nil {
-		return h.onHandle(username)
+		return h.onHandle(item.Username)
 	}
 	return nil
 }
+
+// BenchmarkWorkerPoolThroughput measures the overhead of moving users from the
+// queue through the fetcher to the workers, with a handler doing nothing.
+func BenchmarkWorkerPoolThroughput(b *testing.B) {
+	for _, workers := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			q := NewNativeQueue(testLogger())
+			defer func() { _ = q.Close() }()
+			ctx := context.Background()
+			for i := range b.N {
+				if err := q.Enqueue(ctx, fmt.Sprintf("user-%d", i), 1.0); err != nil {
+					b.Fatalf("enqueue: %v", err)
+				}
+			}
+
+			var handled atomic.Int64
+			done := make(chan struct{})
+			wp := NewWorkerPool(q, workers, testLogger())
+			wp.SetHandler(&TestHandler{onHandle: func(username string) error {
+				if handled.Add(1) == int64(b.N) {
+					close(done)
+				}
+				return nil
+			}})
+			b.ReportAllocs()
+			b.ResetTimer()
+			wp.Start(ctx)
+			<-done
+			b.StopTimer()
+			if err := wp.Stop(ctx); err != nil {
+				b.Fatalf("stop: %v", err)
+			}
+		})
+	}
+}
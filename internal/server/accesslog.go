@@ -0,0 +1,73 @@
+package server
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// AccessLogOptions configures AccessLog.
+type AccessLogOptions struct {
+	Listener     string       // logged as "listener", e.g. events or metrics
+	SampleRate   float64      // share of successful requests logged, in (0, 1]; failed requests are always logged
+	ExcludePaths []string     // paths logged only when they fail, e.g. probes and scrapes
+	Logger       *slog.Logger // slog.Default() if nil
+}
+
+// AccessLog wraps next so that every request is logged as an "HTTP request"
+// record with method, path, status, duration, response size and source.
+// Requests answered with a reason code, e.g. ignored events, include it as
+// "reason", events their correlation ID as "correlation_id". Failed requests
+// (status 400 and above) are logged at warning level and never sampled out.
+func AccessLog(next http.Handler, opts AccessLogOptions) http.Handler {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		failed := rec.status >= http.StatusBadRequest
+		if !failed {
+			if slices.Contains(opts.ExcludePaths, r.URL.Path) {
+				return
+			}
+			if opts.SampleRate < 1 && rand.Float64() >= opts.SampleRate {
+				return
+			}
+		}
+
+		attrs := []slog.Attr{
+			slog.String("listener", opts.Listener),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int("bytes", rec.bytes),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if reason := rec.Header().Get(ReasonHeader); reason != "" {
+			attrs = append(attrs, slog.String("reason", reason))
+		}
+		if id := rec.Header().Get(CorrelationIDHeader); id != "" {
+			attrs = append(attrs, slog.String("correlation_id", id))
+		}
+		if identity := ClientIdentity(r); identity != "" {
+			attrs = append(attrs, slog.String("client_cert", identity))
+		}
+		if ua := r.UserAgent(); ua != "" {
+			attrs = append(attrs, slog.String("user_agent", ua))
+		}
+		level := slog.LevelInfo
+		if failed {
+			level = slog.LevelWarn
+		}
+		opts.Logger.LogAttrs(r.Context(), level, "HTTP request", attrs...)
+	})
+}
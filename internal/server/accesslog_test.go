@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/ignored", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ReasonHeader, "event_not_relevant")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusBadRequest, ReasonReadBody, "bad")
+	})
+	handler := AccessLog(mux, AccessLogOptions{Listener: "events", ExcludePaths: []string{"/ok"}})
+
+	for _, path := range []string{"/ok", "/ignored", "/fail"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+
+	var records []map[string]any
+	for line := range strings.Lines(buf.String()) {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	// successful requests of excluded paths are not logged
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2: %s", len(records), buf.String())
+	}
+	if records[0]["path"] != "/ignored" || records[0]["status"] != float64(http.StatusNoContent) ||
+		records[0]["reason"] != "event_not_relevant" || records[0]["level"] != "INFO" || records[0]["listener"] != "events" {
+		t.Errorf("unexpected record %v", records[0])
+	}
+	if records[1]["path"] != "/fail" || records[1]["level"] != "WARN" || records[1]["bytes"].(float64) == 0 {
+		t.Errorf("unexpected record %v", records[1])
+	}
+}
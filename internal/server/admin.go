@@ -0,0 +1,690 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/dovewarden/dovewarden/internal/queue"
+)
+
+// Admin serves the administrative JSON API, mounted on the metrics listener.
+type Admin struct {
+	queue       queue.Queue
+	metrics     *metrics.Metrics
+	destination string
+	mux         *http.ServeMux
+	routes      []route
+	version     string
+	logger      *slog.Logger
+	reload      func() error
+	backlog     *queue.BacklogEstimator
+	slowSyncs   *queue.SlowSyncTracker
+	auth        *TokenAuth
+	// without auth, lets anyone call the operator routes
+	insecure bool
+
+	depthHistory       *queue.QueueDepthHistory
+	recentSyncs        *queue.RecentSyncTracker
+	destinationMonitor *queue.DestinationMonitor
+	mirror             *queue.MirroredQueue
+	standby            *queue.Standby
+}
+
+// NewAdmin creates the admin API handler.
+func NewAdmin(q queue.Queue, m *metrics.Metrics, destination string) *Admin {
+	a := &Admin{
+		queue:       q,
+		metrics:     m,
+		destination: destination,
+		mux:         http.NewServeMux(),
+		version:     "0.0.0-dev",
+		logger:      slog.Default(),
+	}
+
+	a.routes = []route{
+		{method: "GET", path: "/admin/replication/freshness", role: RoleViewer,
+			summary:  "Last replication age across all users",
+			response: FreshnessSummary{}, status: http.StatusOK,
+			errors: []int{http.StatusInternalServerError}, handler: a.handleFreshness},
+		{method: "GET", path: "/admin/replicator/status", role: RoleViewer,
+			summary:  "Queue counters and the next users to be synced",
+			query:    []queryParam{{name: "next", kind: "integer", description: "Number of listed users (default 10)"}},
+			response: ReplicatorStatus{}, status: http.StatusOK,
+			errors: []int{http.StatusBadRequest, http.StatusInternalServerError}, handler: a.handleReplicatorStatus},
+		{method: "POST", path: "/admin/reload", role: RoleOperator,
+			summary:  "Reload the configuration, as on SIGHUP",
+			response: map[string]string{}, status: http.StatusOK,
+			errors: []int{http.StatusInternalServerError, http.StatusNotImplemented}, jsonErrors: true, handler: a.handleReload},
+		{method: "DELETE", path: "/admin/users/{user}", role: RoleOperator,
+			summary: "Drop all replication data of a user",
+			status:  http.StatusNoContent,
+			errors:  []int{http.StatusNotFound, http.StatusInternalServerError}, handler: a.handleDeleteUser},
+		{method: "POST", path: "/admin/users/{user}/rename", role: RoleOperator,
+			summary: "Move the replication data of a renamed user",
+			request: RenameRequest{}, response: RenameResponse{}, status: http.StatusOK,
+			errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError}, handler: a.handleRenameUser},
+		{method: "GET", path: "/admin/users/{user}/history", role: RoleViewer,
+			summary:  "Most recent sync attempts of a user",
+			response: UserHistory{}, status: http.StatusOK,
+			errors: []int{http.StatusInternalServerError}, handler: a.handleUserHistory},
+		{method: "GET", path: "/admin/users/{user}/state", role: RoleViewer,
+			summary:  "Stored dsync state of a user",
+			response: UserState{}, status: http.StatusOK,
+			errors: []int{http.StatusNotFound, http.StatusInternalServerError}, handler: a.handleGetState},
+		{method: "PUT", path: "/admin/users/{user}/state", role: RoleOperator,
+			summary: "Store the dsync state of a user",
+			request: UserState{}, status: http.StatusNoContent,
+			errors: []int{http.StatusBadRequest, http.StatusInternalServerError}, handler: a.handlePutState},
+		{method: "DELETE", path: "/admin/users/{user}/state", role: RoleOperator,
+			summary: "Drop the dsync state of a user, so that its next sync is a full sync",
+			status:  http.StatusNoContent,
+			errors:  []int{http.StatusInternalServerError}, handler: a.handleDeleteState},
+		{method: "GET", path: "/admin/report/sla", role: RoleViewer,
+			summary:  "Share of event-triggered syncs completed within 1m, 5m and 1h",
+			query:    []queryParam{{name: "window", kind: "string", description: "Covered time span as Go duration (default 24h, at most 168h)"}},
+			response: SLAReport{}, status: http.StatusOK,
+			errors: []int{http.StatusBadRequest, http.StatusInternalServerError}, handler: a.handleSLAReport},
+		{method: "GET", path: "/admin/backlog/eta", role: RoleViewer,
+			summary:  "Estimated time until the queue is drained",
+			response: BacklogETA{}, status: http.StatusOK,
+			errors: []int{http.StatusInternalServerError, http.StatusNotImplemented}, handler: a.handleBacklogETA},
+		{method: "GET", path: "/admin/slow-syncs", role: RoleViewer,
+			summary:  "Slowest recent syncs",
+			response: SlowSyncs{}, status: http.StatusOK,
+			errors: []int{http.StatusNotImplemented}, handler: a.handleSlowSyncs},
+		{method: "POST", path: "/admin/deadletter/requeue", role: RoleOperator,
+			summary: "Queue the given dead-lettered users, or all of them without a body",
+			request: DeadLetterRequeueRequest{}, requestOptional: true, response: DeadLetterRequeueResponse{}, status: http.StatusOK,
+			errors: []int{http.StatusBadRequest, http.StatusInternalServerError}, handler: a.handleDeadLetterRequeue},
+		{method: "POST", path: "/admin/enqueue", role: RoleOperator,
+			summary: "Queue users for a sync",
+			request: EnqueueRequest{}, response: EnqueueResponse{}, status: http.StatusOK,
+			errors: []int{http.StatusBadRequest, http.StatusInternalServerError}, handler: a.handleEnqueue},
+		{method: "GET", path: "/admin/queue/history", role: RoleViewer,
+			summary:  "Queue depth samples, oldest first",
+			response: QueueHistory{}, status: http.StatusOK,
+			errors: []int{http.StatusNotImplemented}, handler: a.handleQueueHistory},
+		{method: "GET", path: "/admin/queue/export", role: RoleViewer,
+			summary:  "Queued users in dequeue order with their scores and event info",
+			response: queue.QueueExport{}, status: http.StatusOK,
+			errors: []int{http.StatusBadRequest, http.StatusInternalServerError}, handler: a.handleQueueExport},
+		{method: "POST", path: "/admin/queue/import", role: RoleOperator,
+			summary: "Queue the users of a queue export",
+			request: queue.QueueExport{}, response: QueueImportResponse{}, status: http.StatusOK,
+			errors: []int{http.StatusBadRequest, http.StatusInternalServerError}, handler: a.handleQueueImport},
+		{method: "GET", path: "/admin/syncs/recent", role: RoleViewer,
+			summary:  "Most recent syncs of all users",
+			response: RecentSyncs{}, status: http.StatusOK,
+			errors: []int{http.StatusNotImplemented}, handler: a.handleRecentSyncs},
+		{method: "GET", path: "/admin/failures", role: RoleViewer,
+			summary:  "Failed syncs by error class and users whose last sync failed",
+			response: Failures{}, status: http.StatusOK,
+			errors: []int{http.StatusInternalServerError}, handler: a.handleFailures},
+		{method: "GET", path: "/admin/destination/health", role: RoleViewer,
+			summary:  "Result of the destination health probes",
+			response: queue.DestinationHealth{}, status: http.StatusOK,
+			errors: []int{http.StatusNotImplemented, http.StatusServiceUnavailable}, handler: a.handleDestinationHealth},
+		{method: "GET", path: queue.MirrorStreamPath, role: RoleViewer,
+			summary: "Stream of the queue changes for standbys (text/event-stream)",
+			status:  http.StatusOK,
+			errors:  []int{http.StatusInternalServerError, http.StatusNotImplemented}, handler: a.handleMirrorStream},
+		{method: "POST", path: "/admin/standby/takeover", role: RoleOperator,
+			summary:  "Make this standby take over from its primary",
+			response: TakeoverResponse{}, status: http.StatusOK,
+			errors: []int{http.StatusConflict, http.StatusInternalServerError, http.StatusNotImplemented}, handler: a.handleStandbyTakeover},
+		// the page holds no data, it calls the routes above with the token entered in it
+		{method: "GET", path: "/admin/dashboard",
+			summary: "Status dashboard (HTML)",
+			status:  http.StatusOK, handler: a.handleDashboard},
+		{method: "GET", path: "/admin/openapi.json",
+			summary: "This document",
+			status:  http.StatusOK, handler: a.handleOpenAPI},
+	}
+	for _, r := range a.routes {
+		h := r.handler
+		if r.role != "" {
+			h = a.requireRole(r.role, h)
+		}
+		a.mux.HandleFunc(r.method+" "+r.path, h)
+	}
+
+	return a
+}
+
+// SetReloadFunc sets the function applying a configuration reload, as on SIGHUP.
+func (a *Admin) SetReloadFunc(reload func() error) {
+	a.reload = reload
+}
+
+// SetLogger sets the logger of the admin API, slog.Default() if unset.
+func (a *Admin) SetLogger(logger *slog.Logger) {
+	a.logger = logger
+}
+
+// SetVersion sets the version reported in GET /admin/openapi.json.
+func (a *Admin) SetVersion(version string) {
+	a.version = version
+}
+
+// SetBacklogEstimator sets the estimator serving GET /admin/backlog/eta.
+func (a *Admin) SetBacklogEstimator(e *queue.BacklogEstimator) {
+	a.backlog = e
+}
+
+// SetSlowSyncTracker sets the tracker serving GET /admin/slow-syncs.
+func (a *Admin) SetSlowSyncTracker(t *queue.SlowSyncTracker) {
+	a.slowSyncs = t
+}
+
+// SetAuth requires a bearer token for every admin route. Read-only routes
+// require the viewer role, destructive ones the operator role.
+func (a *Admin) SetAuth(auth *TokenAuth) {
+	a.auth = auth
+}
+
+// SetInsecure lets anyone call the operator routes while no authenticator is
+// set. Otherwise only the read-only routes are open then.
+func (a *Admin) SetInsecure(insecure bool) {
+	a.insecure = insecure
+}
+
+// Handler returns the HTTP handler serving all /admin/ routes.
+func (a *Admin) Handler() http.Handler {
+	return a.mux
+}
+
+// FreshnessSummary describes how long ago users were last replicated.
+type FreshnessSummary struct {
+	Destination      string    `json:"destination"`
+	Users            int       `json:"users"`
+	MinAgeSeconds    float64   `json:"min_age_seconds"`
+	MedianAgeSeconds float64   `json:"median_age_seconds"`
+	MaxAgeSeconds    float64   `json:"max_age_seconds"`
+	OldestUser       string    `json:"oldest_user,omitempty"`
+	GeneratedAt      time.Time `json:"generated_at"`
+}
+
+// handleFreshness returns min/median/max last-replication age across all users.
+func (a *Admin) handleFreshness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	times, err := a.queue.ListLastReplicationTimes(ctx)
+	if err != nil {
+		a.logger.Error("failed to list last replication times", "error", err)
+		http.Error(w, "failed to list last replication times", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, summarizeFreshness(times, a.destination, time.Now()))
+}
+
+// ReplicatorStatus is the response of GET /admin/replicator/status.
+type ReplicatorStatus struct {
+	Destination      string    `json:"destination"`
+	OldestEnqueuedAt time.Time `json:"oldest_enqueued_at,omitzero"` // first-enqueue time of the user waiting the longest, zero if the queue is empty
+	*queue.Status
+}
+
+// handleReplicatorStatus returns queue counters and the next users to be synced,
+// mirroring the former `doveadm replicator status`. The optional "next" query
+// parameter limits the number of listed users (default 10).
+func (a *Admin) handleReplicatorStatus(w http.ResponseWriter, r *http.Request) {
+	next := 10
+	if v := r.URL.Query().Get("next"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid next parameter", http.StatusBadRequest)
+			return
+		}
+		next = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	status, err := a.queue.Status(ctx, next)
+	if err != nil {
+		a.logger.Error("failed to get queue status", "error", err)
+		http.Error(w, "failed to get queue status", http.StatusInternalServerError)
+		return
+	}
+	oldest, err := a.queue.OldestEnqueuedAt(ctx)
+	if err != nil {
+		a.logger.Error("failed to get oldest enqueue time", "error", err)
+		http.Error(w, "failed to get queue status", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ReplicatorStatus{Destination: a.destination, OldestEnqueuedAt: oldest, Status: status})
+}
+
+// handleReload re-reads the config file and applies the reloadable settings.
+func (a *Admin) handleReload(w http.ResponseWriter, r *http.Request) {
+	if a.reload == nil {
+		writeError(w, http.StatusNotImplemented, ReasonReloadFailed, "reload not supported")
+		return
+	}
+	if err := a.reload(); err != nil {
+		writeError(w, http.StatusInternalServerError, ReasonReloadFailed, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// handleDeleteUser removes all data stored for a user, e.g. for account deletion.
+// It responds with 204 if data was removed and 404 if nothing was stored.
+func (a *Admin) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	found, err := a.queue.DeleteUser(ctx, username)
+	if err != nil {
+		a.logger.Error("failed to delete user", "username", username, "error", err)
+		http.Error(w, "failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	a.logger.Info("deleted user data", "username", username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RenameRequest is the body of POST /admin/users/{user}/rename.
+type RenameRequest struct {
+	NewUsername string `json:"new_username"`
+}
+
+// RenameResponse is the response of POST /admin/users/{user}/rename.
+type RenameResponse struct {
+	OldUsername string `json:"old_username"`
+	NewUsername string `json:"new_username"`
+}
+
+// handleRenameUser moves the data of a renamed user to its new username, so
+// that the next sync of the new username is incremental. It responds with 404
+// if nothing was stored for the user and with 409 if the new username already
+// has a replication state or the user is being synced.
+func (a *Admin) handleRenameUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+	var req RenameRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil || req.NewUsername == "" {
+		http.Error(w, "body must be {\"new_username\": \"...\"}", http.StatusBadRequest)
+		return
+	}
+	if req.NewUsername == username {
+		http.Error(w, "new username equals the old one", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	found, err := a.queue.RenameUser(ctx, username, req.NewUsername)
+	if errors.Is(err, queue.ErrUserExists) || errors.Is(err, queue.ErrUserInFlight) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		a.logger.Error("failed to rename user", "username", username, "new_username", req.NewUsername, "error", err)
+		http.Error(w, "failed to rename user", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	a.logger.Info("renamed user data", "username", username, "new_username", req.NewUsername)
+	writeJSON(w, http.StatusOK, RenameResponse{OldUsername: username, NewUsername: req.NewUsername})
+}
+
+// BacklogETA is the response of GET /admin/backlog/eta.
+type BacklogETA struct {
+	Destination string `json:"destination"`
+	ETA         string `json:"eta,omitempty"` // human-readable ETASeconds, empty if not drainable
+	*queue.BacklogEstimate
+}
+
+// handleBacklogETA returns the estimated time until all queued users are synced
+// at the throughput of the last 5 minutes.
+func (a *Admin) handleBacklogETA(w http.ResponseWriter, r *http.Request) {
+	if a.backlog == nil {
+		http.Error(w, "backlog estimation not available", http.StatusNotImplemented)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	estimate, err := a.backlog.Estimate(ctx)
+	if err != nil {
+		a.logger.Error("failed to estimate backlog", "error", err)
+		http.Error(w, "failed to estimate backlog", http.StatusInternalServerError)
+		return
+	}
+
+	resp := BacklogETA{Destination: a.destination, BacklogEstimate: estimate}
+	if estimate.Drainable {
+		resp.ETA = time.Duration(estimate.ETASeconds * float64(time.Second)).Round(time.Second).String()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// SlowSyncs is the response of GET /admin/slow-syncs.
+type SlowSyncs struct {
+	ThresholdSeconds float64          `json:"threshold_seconds"`
+	Syncs            []queue.SlowSync `json:"syncs"`
+}
+
+// handleSlowSyncs returns the most recent syncs that exceeded the slow sync
+// threshold, newest first.
+func (a *Admin) handleSlowSyncs(w http.ResponseWriter, r *http.Request) {
+	if a.slowSyncs == nil {
+		http.Error(w, "slow sync tracking not enabled", http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, http.StatusOK, SlowSyncs{
+		ThresholdSeconds: a.slowSyncs.Threshold().Seconds(),
+		Syncs:            a.slowSyncs.Recent(),
+	})
+}
+
+// DeadLetterRequeueRequest is the optional body of POST /admin/deadletter/requeue.
+type DeadLetterRequeueRequest struct {
+	Users []string `json:"users"` // empty requeues all dead-lettered users
+}
+
+// DeadLetterRequeueResponse is the response of POST /admin/deadletter/requeue.
+type DeadLetterRequeueResponse struct {
+	Requeued int      `json:"requeued"`
+	Users    []string `json:"users"`
+}
+
+// handleDeadLetterRequeue queues the given dead-lettered users, or all of them
+// without a body, i.e. failed users for which no retry is pending. Given users
+// that are not dead-lettered are skipped.
+func (a *Admin) handleDeadLetterRequeue(w http.ResponseWriter, r *http.Request) {
+	var req DeadLetterRequeueRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "body must be empty or {\"users\": [\"...\"]}", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	requeued, err := a.queue.RequeueFailed(ctx, req.Users, queue.OriginAdmin)
+	if err != nil {
+		a.logger.Error("failed to requeue dead-lettered users", "error", err)
+		http.Error(w, "failed to requeue dead-lettered users", http.StatusInternalServerError)
+		return
+	}
+	a.metrics.DeadLettersRequeued.WithLabelValues("api").Add(float64(len(requeued)))
+
+	a.logger.Info("requeued dead-lettered users", "count", len(requeued))
+	writeJSON(w, http.StatusOK, DeadLetterRequeueResponse{Requeued: len(requeued), Users: requeued})
+}
+
+// maxEnqueueUsers is the maximum number of users per POST /admin/enqueue.
+const maxEnqueueUsers = 10000
+
+// EnqueueRequest is the body of POST /admin/enqueue.
+type EnqueueRequest struct {
+	Users    []string `json:"users"`
+	Priority float64  `json:"priority,omitempty"` // priority factor, 1 if unset
+	Full     bool     `json:"full,omitempty"`     // drop the replication state so that the next sync is a full sync
+}
+
+// EnqueueResponse is the response of POST /admin/enqueue.
+type EnqueueResponse struct {
+	Enqueued int `json:"enqueued"`
+}
+
+// handleEnqueue queues the given users for a sync, e.g. to resync the users
+// affected by an incident. Users already queued keep the better of their
+// scores.
+func (a *Admin) handleEnqueue(w http.ResponseWriter, r *http.Request) {
+	var req EnqueueRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(&req); err != nil {
+		http.Error(w, "body must be {\"users\": [\"...\"]}", http.StatusBadRequest)
+		return
+	}
+	if len(req.Users) == 0 || len(req.Users) > maxEnqueueUsers {
+		http.Error(w, "between 1 and "+strconv.Itoa(maxEnqueueUsers)+" users required", http.StatusBadRequest)
+		return
+	}
+	if req.Priority < 0 {
+		http.Error(w, "priority must be positive", http.StatusBadRequest)
+		return
+	}
+	priority := req.Priority
+	if priority == 0 {
+		priority = 1
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	enqueued := 0
+	for _, username := range req.Users {
+		if username == "" {
+			continue
+		}
+		if req.Full {
+			if err := a.queue.DeleteReplicationState(ctx, username); err != nil {
+				a.logger.Error("failed to delete replication state", "username", username, "error", err)
+				http.Error(w, "failed to enqueue users", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := a.queue.EnqueueEvent(ctx, username, priority, &queue.EventInfo{Origin: queue.OriginAdmin}); err != nil {
+			a.logger.Error("failed to enqueue user", "username", username, "error", err)
+			http.Error(w, "failed to enqueue users", http.StatusInternalServerError)
+			return
+		}
+		enqueued++
+	}
+
+	a.logger.Info("enqueued users", "count", enqueued, "priority", priority, "full", req.Full)
+	writeJSON(w, http.StatusOK, EnqueueResponse{Enqueued: enqueued})
+}
+
+// UserHistory is the response of GET /admin/users/{user}/history.
+type UserHistory struct {
+	Username string               `json:"username"`
+	History  []queue.HistoryEntry `json:"history"`
+}
+
+// handleUserHistory returns the recorded sync attempts of a user, most recent
+// first. Users without history get an empty list.
+func (a *Admin) handleUserHistory(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	history, err := a.queue.GetHistory(ctx, username)
+	if err != nil {
+		a.logger.Error("failed to get sync history", "username", username, "error", err)
+		http.Error(w, "failed to get sync history", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, UserHistory{Username: username, History: history})
+}
+
+// SLAReport is the response of GET /admin/report/sla.
+type SLAReport struct {
+	Destination string    `json:"destination"`
+	Window      string    `json:"window"`
+	Since       time.Time `json:"since"`
+	GeneratedAt time.Time `json:"generated_at"`
+	*queue.SLAReport
+}
+
+// handleSLAReport returns how many event-triggered syncs completed within 1m, 5m
+// and 1h of their first event. The optional "window" query parameter sets the
+// covered time span (default 24h, at most 7 days).
+func (a *Admin) handleSLAReport(w http.ResponseWriter, r *http.Request) {
+	window := 24 * time.Hour
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > queue.MaxSLAWindow {
+			http.Error(w, "invalid window parameter", http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	since := now.Add(-window)
+	report, err := a.queue.SLAReport(ctx, since)
+	if err != nil {
+		a.logger.Error("failed to build SLA report", "error", err)
+		http.Error(w, "failed to build SLA report", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SLAReport{
+		Destination: a.destination,
+		Window:      window.String(),
+		Since:       since,
+		GeneratedAt: now,
+		SLAReport:   report,
+	})
+}
+
+// summarizeFreshness computes age statistics from last replication timestamps.
+func summarizeFreshness(times map[string]time.Time, destination string, now time.Time) FreshnessSummary {
+	summary := FreshnessSummary{
+		Destination: destination,
+		Users:       len(times),
+		GeneratedAt: now,
+	}
+	if len(times) == 0 {
+		return summary
+	}
+
+	ages := make([]float64, 0, len(times))
+	var oldest time.Time
+	for username, t := range times {
+		ages = append(ages, now.Sub(t).Seconds())
+		if summary.OldestUser == "" || t.Before(oldest) {
+			oldest = t
+			summary.OldestUser = username
+		}
+	}
+	sort.Float64s(ages)
+
+	summary.MinAgeSeconds = ages[0]
+	summary.MaxAgeSeconds = ages[len(ages)-1]
+	if mid := len(ages) / 2; len(ages)%2 == 1 {
+		summary.MedianAgeSeconds = ages[mid]
+	} else {
+		summary.MedianAgeSeconds = (ages[mid-1] + ages[mid]) / 2
+	}
+	return summary
+}
+
+// writeJSON encodes v as the JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode JSON response", "error", err)
+	}
+}
+
+// UserState is the response of GET /admin/users/{user}/state and the body of
+// PUT /admin/users/{user}/state, which only reads State.
+type UserState struct {
+	Username        string    `json:"username"`
+	Destination     string    `json:"destination"`
+	State           string    `json:"state"`
+	LastReplication time.Time `json:"last_replication,omitzero"`
+}
+
+// handleGetState returns the stored dsync state of a user for the primary
+// destination. It responds with 404 if no state is stored.
+func (a *Admin) handleGetState(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	state, err := a.queue.GetReplicationState(ctx, username)
+	if err != nil {
+		a.logger.Error("failed to get replication state", "username", username, "error", err)
+		http.Error(w, "failed to get replication state", http.StatusInternalServerError)
+		return
+	}
+	if state == "" {
+		http.Error(w, "no replication state stored", http.StatusNotFound)
+		return
+	}
+	last, err := a.queue.GetLastReplicationTime(ctx, username)
+	if err != nil {
+		a.logger.Error("failed to get last replication time", "username", username, "error", err)
+		http.Error(w, "failed to get replication state", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, UserState{Username: username, Destination: a.destination, State: state, LastReplication: last})
+}
+
+// handlePutState stores the given dsync state of a user, e.g. exported from
+// another environment, so that its next sync is incremental from that state.
+func (a *Admin) handlePutState(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+
+	var req UserState
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(&req); err != nil || req.State == "" {
+		http.Error(w, "body must be {\"state\": \"...\"}", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := a.queue.SetReplicationState(ctx, username, req.State); err != nil {
+		a.logger.Error("failed to set replication state", "username", username, "error", err)
+		http.Error(w, "failed to set replication state", http.StatusInternalServerError)
+		return
+	}
+	a.logger.Info("imported replication state", "username", username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDeleteState drops the stored dsync state of a user, so that its next
+// sync is a full sync. The user is not queued.
+func (a *Admin) handleDeleteState(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := a.queue.DeleteReplicationState(ctx, username); err != nil {
+		a.logger.Error("failed to delete replication state", "username", username, "error", err)
+		http.Error(w, "failed to delete replication state", http.StatusInternalServerError)
+		return
+	}
+	a.logger.Info("cleared replication state", "username", username)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Role is the permission level of an admin API token.
+type Role string
+
+// Admin API roles. An operator may do everything a viewer may.
+const (
+	RoleViewer   Role = "viewer"   // read-only inspection
+	RoleOperator Role = "operator" // destructive actions, e.g. deleting user data or reloading
+)
+
+// allows reports whether the role grants the required one.
+func (r Role) allows(required Role) bool {
+	return r == required || r == RoleOperator
+}
+
+// Principal is the authenticated owner of an admin API token.
+type Principal struct {
+	Name string
+	Role Role
+}
+
+// tokenEntry is a token with its owner.
+type tokenEntry struct {
+	token     []byte
+	principal Principal
+}
+
+// TokenAuth authenticates admin API requests by bearer token. The tokens can
+// be replaced at runtime, e.g. after the tokens file was rotated.
+type TokenAuth struct {
+	tokens atomic.Pointer[[]tokenEntry]
+}
+
+// NewTokenAuth creates an authenticator from the contents of a tokens file,
+// see parseTokens.
+func NewTokenAuth(data string) (*TokenAuth, error) {
+	a := &TokenAuth{}
+	if err := a.Update(data); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Update replaces the accepted tokens. On error, the previous tokens stay in use.
+func (a *TokenAuth) Update(data string) error {
+	tokens, err := parseTokens(data)
+	if err != nil {
+		return err
+	}
+	a.tokens.Store(&tokens)
+	return nil
+}
+
+// parseTokens parses lines of "<name> <role> <token>". Blank lines and lines
+// starting with # are ignored.
+func parseTokens(data string) ([]tokenEntry, error) {
+	var tokens []tokenEntry
+	names := make(map[string]bool)
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected <name> <role> <token>", i+1)
+		}
+		name, role, token := fields[0], Role(fields[1]), fields[2]
+		if role != RoleViewer && role != RoleOperator {
+			return nil, fmt.Errorf("line %d: unknown role %q", i+1, role)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("line %d: duplicate name %q", i+1, name)
+		}
+		names[name] = true
+		tokens = append(tokens, tokenEntry{token: []byte(token), principal: Principal{Name: name, Role: role}})
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens defined")
+	}
+	return tokens, nil
+}
+
+// Authenticate returns the owner of the bearer token of the request.
+func (a *TokenAuth) Authenticate(r *http.Request) (Principal, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return Principal{}, false
+	}
+	var (
+		found     Principal
+		matched   bool
+		candidate = []byte(token)
+	)
+	// Compare against every token so the timing does not reveal which matched
+	for _, entry := range *a.tokens.Load() {
+		if subtle.ConstantTimeCompare(entry.token, candidate) == 1 {
+			found, matched = entry.principal, true
+		}
+	}
+	return found, matched
+}
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// requireRole wraps an admin route so that only principals holding the role
+// may call it. Calls of operator routes are audit logged. Without an
+// authenticator, only viewer routes are allowed unless SetInsecure opened the
+// operator routes as well.
+func (a *Admin) requireRole(role Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.auth == nil {
+			if role == RoleOperator && !a.insecure {
+				a.logger.Warn("Rejected operator admin request without an admin tokens file",
+					"method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				writeError(w, http.StatusForbidden, ReasonForbidden, "operator routes require an admin tokens file")
+				return
+			}
+			next(w, r)
+			return
+		}
+		principal, ok := a.auth.Authenticate(r)
+		if !ok {
+			a.logger.Warn("Rejected unauthenticated admin request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dovewarden"`)
+			writeError(w, http.StatusUnauthorized, ReasonUnauthorized, "missing or invalid token")
+			return
+		}
+		if !principal.Role.allows(role) {
+			a.logger.Warn("Rejected admin request lacking the required role",
+				"principal", principal.Name, "role", principal.Role, "required_role", role,
+				"method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			writeError(w, http.StatusForbidden, ReasonForbidden, fmt.Sprintf("role %s required", role))
+			return
+		}
+		if role != RoleOperator {
+			next(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		a.logger.Info("Admin action",
+			"audit", true,
+			"principal", principal.Name,
+			"role", principal.Role,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"remote_addr", r.RemoteAddr,
+		)
+	}
+}
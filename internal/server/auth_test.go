@@ -0,0 +1,45 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/dovewarden/dovewarden/internal/queue"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAdminWithoutTokensOpensViewerRoutesOnly(t *testing.T) {
+	q := queue.NewNativeQueue(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer func() { _ = q.Close() }()
+	a := NewAdmin(q, metrics.New(prometheus.NewRegistry()), "imap.example.com")
+	reloads := 0
+	a.SetReloadFunc(func() error {
+		reloads++
+		return nil
+	})
+
+	serve := func(method, path string) int {
+		rec := httptest.NewRecorder()
+		a.Handler().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+
+	if code := serve(http.MethodGet, "/admin/replicator/status"); code != http.StatusOK {
+		t.Errorf("viewer route returned %d, want 200", code)
+	}
+	if code := serve(http.MethodPost, "/admin/reload"); code != http.StatusForbidden || reloads != 0 {
+		t.Errorf("operator route returned %d after %d reloads, want 403 without a reload", code, reloads)
+	}
+	if code := serve(http.MethodDelete, "/admin/users/alice"); code != http.StatusForbidden {
+		t.Errorf("user deletion returned %d, want 403", code)
+	}
+
+	a.SetInsecure(true)
+	if code := serve(http.MethodPost, "/admin/reload"); code != http.StatusOK || reloads != 1 {
+		t.Errorf("insecure operator route returned %d after %d reloads, want 200 after a reload", code, reloads)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/queue"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// SetQueueDepthHistory sets the history serving GET /admin/queue/history.
+func (a *Admin) SetQueueDepthHistory(h *queue.QueueDepthHistory) {
+	a.depthHistory = h
+}
+
+// SetRecentSyncTracker sets the tracker serving GET /admin/syncs/recent.
+func (a *Admin) SetRecentSyncTracker(t *queue.RecentSyncTracker) {
+	a.recentSyncs = t
+}
+
+// SetDestinationMonitor sets the monitor serving GET /admin/destination/health.
+func (a *Admin) SetDestinationMonitor(d *queue.DestinationMonitor) {
+	a.destinationMonitor = d
+}
+
+// handleDashboard serves the status dashboard page.
+func (a *Admin) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	_, _ = w.Write(dashboardHTML)
+}
+
+// QueueHistory is the response of GET /admin/queue/history.
+type QueueHistory struct {
+	Samples []queue.QueueDepthSample `json:"samples"`
+}
+
+// handleQueueHistory returns the sampled queue depth, oldest first.
+func (a *Admin) handleQueueHistory(w http.ResponseWriter, r *http.Request) {
+	if a.depthHistory == nil {
+		http.Error(w, "queue history not enabled", http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, http.StatusOK, QueueHistory{Samples: a.depthHistory.Samples()})
+}
+
+// RecentSyncs is the response of GET /admin/syncs/recent.
+type RecentSyncs struct {
+	Syncs []queue.SyncRecord `json:"syncs"`
+}
+
+// handleRecentSyncs returns the most recent sync attempts, newest first.
+func (a *Admin) handleRecentSyncs(w http.ResponseWriter, r *http.Request) {
+	if a.recentSyncs == nil {
+		http.Error(w, "recent sync tracking not enabled", http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, http.StatusOK, RecentSyncs{Syncs: a.recentSyncs.Recent()})
+}
+
+// Failures is the response of GET /admin/failures.
+type Failures struct {
+	ByClass     map[string]float64 `json:"by_class"`     // failed syncs since the start, by error class
+	FailedUsers int64              `json:"failed_users"` // users whose last sync failed
+}
+
+// handleFailures returns the failed syncs since the start by error class and
+// the number of users whose last sync failed.
+func (a *Admin) handleFailures(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	failed, err := a.queue.FailedCount(ctx)
+	if err != nil {
+		a.logger.Error("failed to count failed users", "error", err)
+		http.Error(w, "failed to count failed users", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, Failures{ByClass: counterValues(a.metrics.SyncFailures, "class"), FailedUsers: failed})
+}
+
+// counterValues returns the values of a counter vector by the given label.
+func counterValues(c *prometheus.CounterVec, label string) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	values := make(map[string]float64)
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			continue
+		}
+		for _, l := range m.GetLabel() {
+			if l.GetName() == label {
+				values[l.GetValue()] += m.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+// handleDestinationHealth returns the state of the destination after its last
+// health probe.
+func (a *Admin) handleDestinationHealth(w http.ResponseWriter, r *http.Request) {
+	if a.destinationMonitor == nil {
+		http.Error(w, "destination health check not enabled", http.StatusNotImplemented)
+		return
+	}
+	health := a.destinationMonitor.Health()
+	if health == nil {
+		http.Error(w, "destination not probed yet", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, health)
+}
@@ -0,0 +1,33 @@
+package server
+
+import "net/http"
+
+// ReasonHeader carries the reason code of a rejected or failed request so that
+// clients can grep it without parsing the body.
+const ReasonHeader = "X-Dovewarden-Reason"
+
+// Reason codes for failures not originating from the event filter.
+const (
+	ReasonReadBody      = "read_body_failed"
+	ReasonEnqueueFailed = "enqueue_failed"
+	ReasonReloadFailed  = "reload_failed"
+
+	ReasonSourceNotAllowed = "source_not_allowed"
+
+	ReasonUnsupportedAPIVersion = "unsupported_api_version"
+
+	ReasonUnauthorized = "unauthorized"
+	ReasonForbidden    = "forbidden"
+)
+
+// ErrorResponse is the JSON body of every error returned by the events API.
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+// writeError sends a structured JSON error with a stable reason code.
+func writeError(w http.ResponseWriter, status int, reason, msg string) {
+	w.Header().Set(ReasonHeader, reason)
+	writeJSON(w, status, ErrorResponse{Error: msg, Reason: reason})
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCHealthService is the service name answering like the empty name, for
+// clients that check a named service.
+const GRPCHealthService = "dovewarden"
+
+// grpcHealthWatchInterval is how often Watch re-evaluates readiness.
+const grpcHealthWatchInterval = time.Second
+
+// GRPCHealth implements the grpc.health.v1 health checking protocol for
+// service meshes. Each check evaluates the same readiness function as /readyz.
+type GRPCHealth struct {
+	healthpb.UnimplementedHealthServer
+	ready func(ctx context.Context) error
+}
+
+// NewGRPCHealth creates a health server reporting SERVING while ready returns nil.
+func NewGRPCHealth(ready func(ctx context.Context) error) *GRPCHealth {
+	return &GRPCHealth{ready: ready}
+}
+
+// known reports whether the service name refers to dovewarden.
+func known(service string) bool {
+	return service == "" || service == GRPCHealthService
+}
+
+func (h *GRPCHealth) status(ctx context.Context) healthpb.HealthCheckResponse_ServingStatus {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := h.ready(ctx); err != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// Check implements healthpb.HealthServer.
+func (h *GRPCHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if !known(req.GetService()) {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	return &healthpb.HealthCheckResponse{Status: h.status(ctx)}, nil
+}
+
+// Watch implements healthpb.HealthServer. It sends the current status and
+// then every change until the client cancels. Unknown services are reported
+// as SERVICE_UNKNOWN, as the protocol requires.
+func (h *GRPCHealth) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx := stream.Context()
+	if !known(req.GetService()) {
+		if err := stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVICE_UNKNOWN}); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	ticker := time.NewTicker(grpcHealthWatchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		if current := h.status(ctx); current != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: current}); err != nil {
+				return err
+			}
+			last = current
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
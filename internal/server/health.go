@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+	"github.com/dovewarden/dovewarden/internal/queue"
+)
+
+// Component health states.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+	HealthDisabled = "disabled"
+)
+
+// ComponentHealth is the health of a single component.
+type ComponentHealth struct {
+	Status    string         `json:"status"`
+	LatencyMS float64        `json:"latency_ms,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// HealthDetails is the response of GET /healthz/details.
+type HealthDetails struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// Health reports per-component health of a running instance.
+type Health struct {
+	queue      queue.Queue
+	doveadm    *doveadm.Client
+	workers    *queue.WorkerPool
+	background *queue.BackgroundReplicationService // nil if background replication is disabled
+}
+
+// NewHealth creates a health reporter. background may be nil.
+func NewHealth(q queue.Queue, client *doveadm.Client, workers *queue.WorkerPool, background *queue.BackgroundReplicationService) *Health {
+	return &Health{
+		queue:      q,
+		doveadm:    client,
+		workers:    workers,
+		background: background,
+	}
+}
+
+// HandleDetails serves GET /healthz/details. It responds with 503 if a component
+// required for processing is down and 200 otherwise, including when degraded.
+func (h *Health) HandleDetails(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	details := h.Details(ctx)
+	status := http.StatusOK
+	if details.Status == HealthDown {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, details)
+}
+
+// Details checks all components and aggregates their states.
+func (h *Health) Details(ctx context.Context) HealthDetails {
+	components := map[string]ComponentHealth{
+		"queue":                  h.checkQueue(ctx),
+		"doveadm":                h.checkDoveadm(ctx),
+		"workers":                h.checkWorkers(),
+		"background_replication": h.checkBackground(),
+	}
+
+	overall := HealthOK
+	for name, c := range components {
+		switch {
+		case c.Status == HealthDown && (name == "queue" || name == "workers"):
+			overall = HealthDown
+		case (c.Status == HealthDown || c.Status == HealthDegraded) && overall == HealthOK:
+			overall = HealthDegraded
+		}
+	}
+
+	return HealthDetails{Status: overall, Components: components}
+}
+
+func (h *Health) checkQueue(ctx context.Context) ComponentHealth {
+	start := time.Now()
+	err := h.queue.HealthCheck(ctx)
+	c := ComponentHealth{Status: HealthOK, LatencyMS: msSince(start)}
+	if err != nil {
+		c.Status = HealthDown
+		c.Error = err.Error()
+	}
+	return c
+}
+
+func (h *Health) checkDoveadm(ctx context.Context) ComponentHealth {
+	start := time.Now()
+	err := h.doveadm.Ping(ctx)
+	c := ComponentHealth{Status: HealthOK, LatencyMS: msSince(start)}
+	if err != nil {
+		c.Status = HealthDown
+		c.Error = err.Error()
+	}
+	return c
+}
+
+func (h *Health) checkWorkers() ComponentHealth {
+	c := ComponentHealth{
+		Status: HealthOK,
+		Details: map[string]any{
+			"workers": h.workers.NumWorkers(),
+			"active":  h.workers.ActiveCount(),
+		},
+	}
+	if h.workers.Stopped() {
+		c.Status = HealthDown
+		c.Error = "worker pool stopped"
+	}
+	return c
+}
+
+func (h *Health) checkBackground() ComponentHealth {
+	if h.background == nil {
+		return ComponentHealth{Status: HealthDisabled}
+	}
+
+	sweep := h.background.SweepStatus()
+	c := ComponentHealth{
+		Status: HealthOK,
+		Details: map[string]any{
+			// without leader election, every instance runs the sweep itself
+			"leader":      sweep.Leader,
+			"running":     sweep.Running,
+			"interval_s":  sweep.Interval.Seconds(),
+			"last_sweep":  nil,
+			"last_status": "pending",
+		},
+	}
+	if !sweep.LastSweep.IsZero() {
+		c.Details["last_sweep"] = sweep.LastSweep
+		c.Details["last_status"] = "ok"
+	}
+	if sweep.LastError != nil {
+		c.Status = HealthDegraded
+		c.Error = sweep.LastError.Error()
+		c.Details["last_status"] = "error"
+	}
+	// a sweep that has not finished for two intervals is considered stuck
+	// followers do not sweep, so only the leader can be stuck
+	if sweep.Leader && !sweep.LastSweep.IsZero() && time.Since(sweep.LastSweep) > 2*sweep.Interval+time.Minute {
+		c.Status = HealthDegraded
+		c.Error = "no background sweep completed within two intervals"
+	}
+	return c
+}
+
+func msSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
@@ -1,21 +1,51 @@
 package server
 
 import (
-	"io"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/dovewarden/dovewarden/internal/errreport"
 	"github.com/dovewarden/dovewarden/internal/events"
 	"github.com/dovewarden/dovewarden/internal/metrics"
 	"github.com/dovewarden/dovewarden/internal/queue"
 )
 
+// maxPooledBodySize bounds the capacity of body buffers returned to the pool,
+// so that a single huge request does not pin its buffer forever.
+const maxPooledBodySize = 64 * 1024
+
+// bodyPool holds the buffers event bodies are read into. Reusing them avoids
+// allocating and growing a new slice for every event, which dominated the
+// allocations of the handler under high event rates.
+var bodyPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
 // Server handles HTTP requests for the Dovecot event API.
 type Server struct {
 	addr    string
 	queue   queue.Queue
 	metrics *metrics.Metrics
 	mux     *http.ServeMux
+	logger  *slog.Logger
+
+	mu                sync.RWMutex
+	mailboxPriorities map[string]float64
+	allowedNetworks   []netip.Prefix // empty allows all sources
+	firstSeenPriority float64        // boosts users never replicated; <= 1 disables
+
+	reporter *errreport.Reporter
+
+	deprecationWarned sync.Map // deprecated events paths already logged
 }
 
 // New creates a new HTTP server.
@@ -25,46 +55,268 @@ func New(addr string, q queue.Queue, m *metrics.Metrics) *Server {
 		queue:   q,
 		metrics: m,
 		mux:     http.NewServeMux(),
+		logger:  slog.Default(),
 	}
 
-	s.mux.HandleFunc("POST /events", s.handleEvents)
+	for _, v := range eventsAPIVersions {
+		r := eventsRoute(v, s.versioned(v, s.handleEvents))
+		s.mux.HandleFunc(r.method+" "+r.path, r.handler)
+	}
+	s.mux.HandleFunc("POST /{version}/events", s.handleUnsupportedVersion)
 
 	return s
 }
 
+// CorrelationIDHeader carries the correlation ID of an event. A valid ID sent
+// by the producer or a proxy is kept, otherwise one is generated; either way
+// it is returned in the response and logged with the event and its sync.
+const CorrelationIDHeader = "X-Correlation-ID"
+
+// correlationIDPattern restricts accepted correlation IDs to characters that
+// need no quoting in logs and doveadm tags.
+var correlationIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// correlationID returns the correlation ID of the event posted with r.
+func correlationID(r *http.Request) string {
+	if id := r.Header.Get(CorrelationIDHeader); correlationIDPattern.MatchString(id) {
+		return id
+	}
+	return rand.Text()
+}
+
+// APIVersionHeader carries the version of the events API that handled a
+// request.
+const APIVersionHeader = "X-Dovewarden-API-Version"
+
+// eventsAPIVersion is a path the events API is served on.
+type eventsAPIVersion struct {
+	path       string
+	version    string
+	deprecated bool   // served only for existing exporter configs
+	successor  string // path deprecated paths should be migrated to
+}
+
+// eventsAPIVersions lists the paths of the events API. A change of the event
+// payload schema gets a new version, so that exporters configured for an
+// older one keep working until they are migrated.
+var eventsAPIVersions = []eventsAPIVersion{
+	{path: "/v1/events", version: "v1"},
+	// the path from before versioning, an alias of v1
+	{path: "/events", version: "v1", deprecated: true, successor: "/v1/events"},
+}
+
+// eventsRoute describes POST on the path of v, served by handler.
+func eventsRoute(v eventsAPIVersion, handler http.HandlerFunc) route {
+	summary := "Receive a Dovecot event; events not triggering a sync are ignored"
+	if v.deprecated {
+		summary = "Alias of " + v.successor + " for existing exporter configs"
+	}
+	return route{method: "POST", path: v.path, summary: summary, deprecated: v.deprecated,
+		request: events.Event{}, status: http.StatusAccepted, otherStatuses: []int{http.StatusNoContent},
+		errors:     []int{http.StatusBadRequest, http.StatusForbidden, http.StatusInternalServerError},
+		jsonErrors: true, handler: handler}
+}
+
+// versioned reports the API version of v in the response and counts the
+// request. Responses on deprecated paths carry a Deprecation header and link
+// the successor path; the first request on each is logged, so that operators
+// notice exporter configs to migrate.
+func (s *Server) versioned(v eventsAPIVersion, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(APIVersionHeader, v.version)
+		s.metrics.EventsAPIRequests.WithLabelValues(v.version, v.path).Inc()
+		if v.deprecated {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+v.successor+">; rel=\"successor-version\"")
+			if _, warned := s.deprecationWarned.LoadOrStore(v.path, true); !warned {
+				s.logger.Warn("events received on a deprecated path, point the Dovecot event exporter to its successor",
+					"path", v.path, "successor", v.successor, "remote_addr", r.RemoteAddr)
+			}
+		}
+		next(w, r)
+	}
+}
+
+// handleUnsupportedVersion rejects events posted to a version of the events
+// API this build does not serve, listing the supported ones.
+func (s *Server) handleUnsupportedVersion(w http.ResponseWriter, r *http.Request) {
+	var supported []string
+	for _, v := range eventsAPIVersions {
+		if !v.deprecated {
+			supported = append(supported, v.path)
+		}
+	}
+	writeError(w, http.StatusNotFound, ReasonUnsupportedAPIVersion,
+		fmt.Sprintf("events API version %q not supported, use %s", r.PathValue("version"), strings.Join(supported, " or ")))
+}
+
+// SetLogger sets the logger of the events API, slog.Default() if unset.
+func (s *Server) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// SetMailboxPriorities configures the priority modifiers applied to events
+// targeting the given mailboxes (see ParseMailboxPriorities).
+// It is safe to call while events are being handled.
+func (s *Server) SetMailboxPriorities(priorities map[string]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mailboxPriorities = priorities
+}
+
+// SetFirstSeenPriority multiplies the priority of events of users that were
+// never replicated by factor, so that new accounts get their initial full sync
+// soon. Checking costs a backend read per event; a factor of 1 or less
+// disables it. It is safe to call while events are being handled.
+func (s *Server) SetFirstSeenPriority(factor float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.firstSeenPriority = factor
+}
+
+// firstSeenFactor returns the first-seen priority factor if the user has
+// neither a last replication time nor a replication state, and 1 otherwise.
+func (s *Server) firstSeenFactor(ctx context.Context, username string) float64 {
+	s.mu.RLock()
+	factor := s.firstSeenPriority
+	s.mu.RUnlock()
+	if factor <= 1 {
+		return 1
+	}
+
+	last, err := s.queue.GetLastReplicationTime(ctx, username)
+	if err != nil {
+		s.logger.Warn("failed to check for first-seen user", "username", username, "error", err)
+		return 1
+	}
+	if !last.IsZero() {
+		return 1
+	}
+	if state, err := s.queue.GetReplicationState(ctx, username); err != nil || state != "" {
+		return 1
+	}
+	return factor
+}
+
+// SetErrorReporter reports queue backend errors while enqueuing events.
+func (s *Server) SetErrorReporter(r *errreport.Reporter) {
+	s.reporter = r
+}
+
+// SetAllowedNetworks restricts the sources events are accepted from. An empty
+// list accepts events from any source. It is safe to call while events are
+// being handled.
+func (s *Server) SetAllowedNetworks(networks []netip.Prefix) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allowedNetworks = networks
+}
+
+// sourceAllowed reports whether the remote address of the request is within the
+// allowed networks, and returns the source IP for logging.
+func (s *Server) sourceAllowed(r *http.Request) (bool, string) {
+	s.mu.RLock()
+	networks := s.allowedNetworks
+	s.mu.RUnlock()
+
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return len(networks) == 0, r.RemoteAddr
+	}
+	addr := addrPort.Addr().Unmap()
+	if len(networks) == 0 {
+		return true, addr.String()
+	}
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true, addr.String()
+		}
+	}
+	return false, addr.String()
+}
+
 // handleEvents processes incoming Dovecot events.
 func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	id := correlationID(r)
+	w.Header().Set(CorrelationIDHeader, id)
+
+	// Reject unknown sources before reading the body
+	if allowed, source := s.sourceAllowed(r); !allowed {
+		s.logger.Warn("event rejected", "reason", ReasonSourceNotAllowed, "source", source, "correlation_id", id)
+		s.metrics.EventsRejected.WithLabelValues(s.metrics.LimitLabel("source", source)).Inc()
+		writeError(w, http.StatusForbidden, ReasonSourceNotAllowed, "source not allowed")
+		return
+	}
+
 	s.metrics.EventsReceived.Inc()
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		slog.Error("failed to read request body", "error", err)
-		http.Error(w, "failed to read request body", http.StatusBadRequest)
+	buf := bodyPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBodySize {
+			bodyPool.Put(buf)
+		}
+	}()
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		s.logger.Error("failed to read request body", "error", err, "correlation_id", id)
+		writeError(w, http.StatusBadRequest, ReasonReadBody, "failed to read request body")
 		return
 	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(r.Body)
+	// the filter copies every value it keeps, so body may be reused afterwards
+	body := buf.Bytes()
 
 	// Filter the event
 	filtered, err := events.Filter(body)
 	if err != nil {
-		slog.Warn("event ignored", "reason", err.Error(), "body", string(body))
+		reason := events.Reason(err)
+		if reason == events.ReasonInvalidJSON {
+			s.logger.Warn("malformed event", "reason", reason, "error", err.Error(), "body", string(body), "correlation_id", id)
+			writeError(w, http.StatusBadRequest, reason, err.Error())
+			return
+		}
+		// Filtered events are not an error for the producer; 204 carries no body,
+		// so the reason is only reported in the header
+		s.logger.Warn("event ignored", "reason", reason, "error", err.Error(), "body", string(body), "correlation_id", id)
+		w.Header().Set(ReasonHeader, reason)
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
 	s.metrics.EventsFiltered.Inc()
+	filtered.CorrelationID = id
 
-	slog.Info("event accepted", "username", filtered.Username, "cmd", filtered.CmdName, "event_type", filtered.Event)
+	// Enqueue the event with the command priority, adjusted by the target mailbox
+	priorityMailbox := filtered.Mailbox
+	if priorityMailbox == "" && filtered.Event == events.EventMailDeliveryFinished {
+		// deliveries without a mailbox (no Sieve fileinto) go to INBOX
+		priorityMailbox = "INBOX"
+	}
+	firstSeen := s.firstSeenFactor(r.Context(), filtered.Username)
+	priority := filtered.Priority * s.mailboxPriority(priorityMailbox) * firstSeen
 
-	// Enqueue the event with static priority
-	staticPriority := 1.0 // Static priority for now; will be extended per event type later
+	// typed attributes avoid boxing every value into an interface
+	logAttrs := []slog.Attr{
+		slog.String("username", filtered.Username),
+		slog.String("cmd", filtered.CmdName),
+		slog.String("event_type", filtered.Event),
+		slog.String("mailbox", filtered.Mailbox),
+		slog.Float64("priority", priority),
+		slog.String("correlation_id", id),
+	}
+	if firstSeen > 1 {
+		logAttrs = append(logAttrs, slog.Bool("first_seen", true))
+	}
+	if identity := ClientIdentity(r); identity != "" {
+		logAttrs = append(logAttrs, slog.String("client_cert", identity))
+	}
+	s.logger.LogAttrs(r.Context(), slog.LevelInfo, "event accepted", logAttrs...)
 
-	if err := s.queue.Enqueue(r.Context(), filtered.Username, staticPriority); err != nil {
-		slog.Error("failed to enqueue event", "username", filtered.Username, "error", err)
+	info := queue.NewEventInfo(filtered, time.Now())
+	if err := s.queue.EnqueueEvent(r.Context(), filtered.Username, priority, info); err != nil {
+		s.logger.Error("failed to enqueue event", "username", filtered.Username, "error", err, "correlation_id", id)
 		s.metrics.EnqueueErrors.Inc()
-		http.Error(w, "failed to enqueue event", http.StatusInternalServerError)
+		s.reporter.ReportError(errreport.KindQueueError, "failed to enqueue event", err, nil)
+		writeError(w, http.StatusInternalServerError, ReasonEnqueueFailed, "failed to enqueue event")
 		return
 	}
 
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/dovewarden/dovewarden/internal/queue"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// discardQueue accepts every event without storing it, so that the benchmark
+// measures the handler rather than the queue backend.
+type discardQueue struct {
+	queue.Queue
+}
+
+func (discardQueue) EnqueueEvent(ctx context.Context, username string, priorityFactor float64, info *queue.EventInfo) error {
+	return nil
+}
+
+func BenchmarkHandleEvents(b *testing.B) {
+	body, err := os.ReadFile("../../fixtures/events/append.json")
+	if err != nil {
+		b.Fatalf("failed to read fixture: %v", err)
+	}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	handler := New("", discardQueue{}, metrics.New(prometheus.NewRegistry())).Handler()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		req := httptest.NewRequest(http.MethodPost, "/v1/events", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted {
+			b.Fatalf("unexpected status %d", rec.Code)
+		}
+	}
+}
+
+func TestEventsAPIVersions(t *testing.T) {
+	body, err := os.ReadFile("../../fixtures/events/append.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	handler := New("", discardQueue{}, metrics.New(prometheus.NewRegistry())).Handler()
+
+	tests := []struct {
+		path       string
+		status     int
+		deprecated bool
+	}{
+		{path: "/v1/events", status: http.StatusAccepted},
+		{path: "/events", status: http.StatusAccepted, deprecated: true},
+		{path: "/v2/events", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(body)))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, rec.Code, tt.status)
+		}
+		if tt.status == http.StatusNotFound {
+			if reason := rec.Header().Get(ReasonHeader); reason != ReasonUnsupportedAPIVersion {
+				t.Errorf("%s: reason %q, want %q", tt.path, reason, ReasonUnsupportedAPIVersion)
+			}
+			continue
+		}
+		if v := rec.Header().Get(APIVersionHeader); v != "v1" {
+			t.Errorf("%s: version %q, want v1", tt.path, v)
+		}
+		if got := rec.Header().Get("Deprecation") != ""; got != tt.deprecated {
+			t.Errorf("%s: deprecated %v, want %v", tt.path, got, tt.deprecated)
+		}
+	}
+}
+
+func TestEventsCorrelationID(t *testing.T) {
+	body, err := os.ReadFile("../../fixtures/events/append.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	handler := New("", discardQueue{}, metrics.New(prometheus.NewRegistry())).Handler()
+
+	post := func(id string) string {
+		req := httptest.NewRequest(http.MethodPost, "/v1/events", bytes.NewReader(body))
+		if id != "" {
+			req.Header.Set(CorrelationIDHeader, id)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header().Get(CorrelationIDHeader)
+	}
+	if got := post("proxy-42"); got != "proxy-42" {
+		t.Errorf("valid ID replaced by %q", got)
+	}
+	generated := post("")
+	if generated == "" || generated == post("") {
+		t.Errorf("generated IDs %q are not unique", generated)
+	}
+	if got := post("not valid, with spaces"); got == "not valid, with spaces" || got == "" {
+		t.Errorf("invalid ID kept as %q", got)
+	}
+}
+
+func TestEventsRejectedSourcesAreLimited(t *testing.T) {
+	m := metrics.New(prometheus.NewRegistry())
+	m.SetMaxLabelValues(2)
+	s := New("", discardQueue{}, m)
+	s.SetAllowedNetworks([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	handler := s.Handler()
+
+	for i := 1; i <= 5; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/events", bytes.NewReader([]byte("{}")))
+		req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("source %s: status %d, want 403", req.RemoteAddr, rec.Code)
+		}
+	}
+	if n := testutil.CollectAndCount(m.EventsRejected); n != 3 {
+		t.Errorf("rejections recorded under %d sources, want 2 and \"other\"", n)
+	}
+	if got := testutil.ToFloat64(m.EventsRejected.WithLabelValues("other")); got != 3 {
+		t.Errorf("rejections under \"other\" = %v, want 3", got)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/queue"
+)
+
+// SetMirroredQueue sets the queue whose changes GET /admin/mirror/stream
+// streams to standbys.
+func (a *Admin) SetMirroredQueue(q *queue.MirroredQueue) {
+	a.mirror = q
+}
+
+// SetStandby sets the standby taken over by POST /admin/standby/takeover.
+func (a *Admin) SetStandby(s *queue.Standby) {
+	a.standby = s
+}
+
+// handleMirrorStream streams the queue as server-sent events: a reset, an
+// enqueue per queued user and then every enqueue and dequeue, with a comment
+// as heartbeat while idle. A standby that falls behind is disconnected.
+func (a *Admin) handleMirrorStream(w http.ResponseWriter, r *http.Request) {
+	if a.mirror == nil {
+		http.Error(w, "queue mirroring not enabled", http.StatusNotImplemented)
+		return
+	}
+	snapshot, updates, cancel, err := a.mirror.Subscribe(r.Context())
+	if err != nil {
+		a.logger.Error("failed to subscribe to the queue", "error", err)
+		http.Error(w, "failed to subscribe to the queue", http.StatusInternalServerError)
+		return
+	}
+	defer cancel()
+	a.logger.Info("Standby subscribed to the mirror stream", "remote_addr", r.RemoteAddr, "snapshot_users", len(snapshot))
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(msg queue.MirrorMessage) error {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		return err
+	}
+	if err := send(queue.MirrorMessage{Type: queue.MirrorReset}); err != nil {
+		return
+	}
+	for i := range snapshot {
+		if err := send(queue.MirrorMessage{Type: queue.MirrorEnqueue, Item: &snapshot[i]}); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(queue.MirrorHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-updates:
+			if !ok {
+				// dropped for falling behind or on shutdown; the standby reconnects
+				return
+			}
+			if err := send(msg); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// TakeoverResponse is the response of POST /admin/standby/takeover.
+type TakeoverResponse struct {
+	Queued int `json:"queued"` // mirrored users queued locally
+}
+
+// handleStandbyTakeover makes the standby take over from its primary.
+func (a *Admin) handleStandbyTakeover(w http.ResponseWriter, r *http.Request) {
+	if a.standby == nil {
+		http.Error(w, "not a standby", http.StatusNotImplemented)
+		return
+	}
+	if a.standby.TakenOver() {
+		http.Error(w, "already taken over", http.StatusConflict)
+		return
+	}
+	queued, err := a.standby.TakeOver(r.Context())
+	if err != nil {
+		a.logger.Error("failed to queue some mirrored users", "error", err)
+		http.Error(w, "failed to queue some mirrored users", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, TakeoverResponse{Queued: queued})
+}
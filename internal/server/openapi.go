@@ -0,0 +1,271 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// route is an API route. Routes are registered with the mux and described in
+// the OpenAPI document from the same definition, so the document cannot drift
+// from the served routes.
+type route struct {
+	method          string
+	path            string
+	role            Role // required role if admin tokens are configured, empty for public routes
+	summary         string
+	deprecated      bool
+	query           []queryParam
+	request         any   // JSON request body, nil without a body
+	requestOptional bool  // the request body may be empty
+	response        any   // JSON response of a successful request, nil without a body
+	status          int   // status of a successful request
+	otherStatuses   []int // other success statuses, without a body
+	errors          []int
+	jsonErrors      bool // errors have an ErrorResponse body instead of plain text
+	handler         http.HandlerFunc
+}
+
+// queryParam is a query parameter of a route.
+type queryParam struct {
+	name        string
+	kind        string // JSON schema type
+	description string
+}
+
+// pathParamPattern matches the wildcards of mux patterns, e.g. {user}.
+var pathParamPattern = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// openAPIDocument builds the OpenAPI 3.1 document describing the given routes.
+func openAPIDocument(version string, routes []route) map[string]any {
+	g := &schemaGenerator{schemas: map[string]any{}, names: map[reflect.Type]string{}}
+	paths := map[string]map[string]any{}
+	for _, r := range routes {
+		item := paths[r.path]
+		if item == nil {
+			item = map[string]any{}
+			paths[r.path] = item
+		}
+		item[strings.ToLower(r.method)] = g.operation(r)
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "dovewarden",
+			"version":     version,
+			"description": "Events API, served on the events listener, and admin API, served on the metrics listener.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Admin token, required only if DOVEWARDEN_ADMIN_TOKENS_FILE is set; without it, operator routes are open only with DOVEWARDEN_ADMIN_INSECURE",
+				},
+			},
+		},
+	}
+}
+
+// schemaGenerator derives JSON schemas from Go types, collecting named structs
+// as components.
+type schemaGenerator struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+}
+
+func (g *schemaGenerator) operation(r route) map[string]any {
+	op := map[string]any{
+		"summary":     r.summary,
+		"operationId": operationID(r),
+		"tags":        []string{strings.Split(strings.TrimPrefix(r.path, "/"), "/")[0]},
+	}
+	if r.deprecated {
+		op["deprecated"] = true
+	}
+
+	var params []any
+	for _, m := range pathParamPattern.FindAllStringSubmatch(r.path, -1) {
+		params = append(params, map[string]any{
+			"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+		})
+	}
+	for _, q := range r.query {
+		params = append(params, map[string]any{
+			"name": q.name, "in": "query", "description": q.description, "schema": map[string]any{"type": q.kind},
+		})
+	}
+	if params != nil {
+		op["parameters"] = params
+	}
+
+	if r.request != nil {
+		op["requestBody"] = map[string]any{
+			"required": !r.requestOptional,
+			"content":  map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(r.request))}},
+		}
+	}
+
+	success := map[string]any{"description": http.StatusText(r.status)}
+	if r.response != nil {
+		success["content"] = map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(r.response))}}
+	}
+	responses := map[string]any{statusKey(r.status): success}
+	for _, status := range r.otherStatuses {
+		responses[statusKey(status)] = map[string]any{"description": http.StatusText(status)}
+	}
+	for _, status := range r.errors {
+		responses[statusKey(status)] = g.errorResponse(status, r.jsonErrors)
+	}
+	if r.role != "" {
+		// requireRole rejects requests with an ErrorResponse
+		responses[statusKey(http.StatusUnauthorized)] = g.errorResponse(http.StatusUnauthorized, true)
+		responses[statusKey(http.StatusForbidden)] = g.errorResponse(http.StatusForbidden, true)
+		op["security"] = []any{map[string]any{"bearer": []string{}}}
+		op["x-dovewarden-role"] = string(r.role)
+	}
+	op["responses"] = responses
+	return op
+}
+
+// errorResponse describes an error response with an ErrorResponse or a plain
+// text body.
+func (g *schemaGenerator) errorResponse(status int, jsonBody bool) map[string]any {
+	content := map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}}
+	if jsonBody {
+		content = map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeFor[ErrorResponse]())}}
+	}
+	return map[string]any{"description": http.StatusText(status), "content": content}
+}
+
+// operationID derives a unique operation name from the method and path, e.g.
+// getAdminUsersUserHistory.
+func operationID(r route) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(r.method))
+	for _, part := range strings.FieldsFunc(r.path, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func statusKey(status int) string {
+	return strconv.Itoa(status)
+}
+
+// schema returns the JSON schema of t, a reference for named structs.
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == reflect.TypeFor[time.Duration]():
+		return map[string]any{"type": "integer", "description": "nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.componentName(t)
+			g.names[t] = name
+			g.schemas[name] = map[string]any{} // placeholder for recursive types
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]any{}
+	}
+}
+
+// componentName names the component of a struct after the type, prefixed
+// with its package if another type of the same name is already collected.
+func (g *schemaGenerator) componentName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := g.schemas[name]; !taken {
+		return name
+	}
+	pkg := path.Base(t.PkgPath())
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + name
+}
+
+// structSchema returns the object schema of a struct. Fields of embedded
+// structs without a JSON name are inlined, like encoding/json does.
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	g.addFields(t, properties, &required)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(ft, properties, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// handleOpenAPI serves the OpenAPI document of the events and admin APIs.
+func (a *Admin) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	var routes []route
+	for _, v := range eventsAPIVersions {
+		routes = append(routes, eventsRoute(v, nil))
+	}
+	writeJSON(w, http.StatusOK, openAPIDocument(a.version, append(routes, a.routes...)))
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	a := NewAdmin(discardQueue{}, metrics.New(prometheus.NewRegistry()), "imap.example.com")
+	a.SetVersion("1.2.3")
+
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	var doc struct {
+		Info       struct{ Version string }
+		Paths      map[string]map[string]json.RawMessage
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage
+				Required   []string
+			}
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid document: %v", err)
+	}
+	if doc.Info.Version != "1.2.3" {
+		t.Errorf("version = %q, want 1.2.3", doc.Info.Version)
+	}
+
+	// every served route is described
+	routes := a.routes
+	for _, v := range eventsAPIVersions {
+		routes = append(routes, eventsRoute(v, nil))
+	}
+	for _, r := range routes {
+		if _, ok := doc.Paths[r.path][strings.ToLower(r.method)]; !ok {
+			t.Errorf("%s %s not described", r.method, r.path)
+		}
+	}
+	if _, ok := doc.Paths["/admin/users/{user}/state"]["put"]; !ok {
+		t.Error("PUT /admin/users/{user}/state not described")
+	}
+
+	// fields of the embedded queue.Status are inlined like encoding/json does
+	status := doc.Components.Schemas["ReplicatorStatus"]
+	for _, field := range []string{"destination", "queued", "in_flight", "next", "oldest_enqueued_at"} {
+		if _, ok := status.Properties[field]; !ok {
+			t.Errorf("ReplicatorStatus lacks %s", field)
+		}
+	}
+	for _, field := range status.Required {
+		if field == "oldest_enqueued_at" {
+			t.Error("omitzero field oldest_enqueued_at is required")
+		}
+	}
+	if _, ok := doc.Components.Schemas["ErrorResponse"]; !ok {
+		t.Error("ErrorResponse schema missing")
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseMailboxPriorities parses a comma-separated list of mailbox=factor pairs,
+// e.g. "INBOX=2,Sent=2,Trash=0.5,Junk=0.5". Factors above 1 make events for the
+// mailbox replicate sooner, factors below 1 later.
+func ParseMailboxPriorities(spec string) (map[string]float64, error) {
+	priorities := make(map[string]float64)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		mailbox, factorStr, ok := strings.Cut(entry, "=")
+		mailbox = strings.TrimSpace(mailbox)
+		if !ok || mailbox == "" {
+			return nil, fmt.Errorf("invalid mailbox priority %q: expected mailbox=factor", entry)
+		}
+		factor, err := strconv.ParseFloat(strings.TrimSpace(factorStr), 64)
+		if err != nil || factor <= 0 {
+			return nil, fmt.Errorf("invalid mailbox priority %q: factor must be a positive number", entry)
+		}
+		priorities[normalizeMailbox(mailbox)] = factor
+	}
+	return priorities, nil
+}
+
+// mailboxPriority returns the priority modifier for a mailbox, or 1 if none is configured.
+func (s *Server) mailboxPriority(mailbox string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if factor, ok := s.mailboxPriorities[normalizeMailbox(mailbox)]; ok {
+		return factor
+	}
+	return 1.0
+}
+
+// normalizeMailbox folds the case of INBOX, which IMAP treats case-insensitively.
+func normalizeMailbox(mailbox string) string {
+	if strings.EqualFold(mailbox, "INBOX") {
+		return "INBOX"
+	}
+	return mailbox
+}
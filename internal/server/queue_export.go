@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/queue"
+)
+
+// maxImportBytes is the maximum body size of POST /admin/queue/import.
+const maxImportBytes = 256 << 20
+
+// QueueImportResponse is the response of POST /admin/queue/import.
+type QueueImportResponse struct {
+	Imported int `json:"imported"`
+}
+
+// handleQueueExport returns the queued users in dequeue order with their
+// scores and event info, e.g. to reproduce a backlog in another environment.
+// The optional "limit" query parameter caps the number of users.
+func (a *Admin) handleQueueExport(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	entries, err := a.queue.ExportQueue(ctx, limit)
+	if err != nil {
+		a.logger.Error("failed to export queue", "error", err)
+		http.Error(w, "failed to export queue", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, queue.QueueExport{ExportedAt: now, Entries: entries})
+}
+
+// handleQueueImport queues the users of an export with their scores and event
+// info. Users already queued keep the better score. Nothing is imported if an
+// entry is invalid.
+func (a *Admin) handleQueueImport(w http.ResponseWriter, r *http.Request) {
+	var export queue.QueueExport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportBytes)).Decode(&export); err != nil {
+		http.Error(w, "body must be a queue export: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := queue.ValidateQueueEntries(export.Entries); err != nil {
+		http.Error(w, "invalid entry: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	imported, err := a.queue.ImportQueue(ctx, export.Entries)
+	if err != nil {
+		a.logger.Error("failed to import queue", "error", err)
+		http.Error(w, "failed to import queue", http.StatusInternalServerError)
+		return
+	}
+
+	a.logger.Info("imported queue", "count", imported, "exported_at", export.ExportedAt)
+	writeJSON(w, http.StatusOK, QueueImportResponse{Imported: imported})
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// TLSOptions configures TLS on the events listener.
+type TLSOptions struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string   // if set, clients must present a certificate signed by this CA
+	AllowedClients []string // DNS SANs or common names accepted from clients; empty accepts any certificate signed by the CA
+}
+
+// NewTLSConfig builds the TLS configuration of the events listener. With a
+// client CA, every event producer has to authenticate with its own
+// certificate, and the handshake fails for certificates whose identity is not
+// in AllowedClients.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("both a certificate and a key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if opts.ClientCAFile == "" {
+		if len(opts.AllowedClients) > 0 {
+			return nil, errors.New("allowed clients require a client CA")
+		}
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(opts.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", opts.ClientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+	if len(opts.AllowedClients) > 0 {
+		allowed := slices.Clone(opts.AllowedClients)
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no client certificate")
+			}
+			leaf := cs.PeerCertificates[0]
+			if slices.Contains(allowed, leaf.Subject.CommonName) {
+				return nil
+			}
+			for _, name := range leaf.DNSNames {
+				if slices.Contains(allowed, name) {
+					return nil
+				}
+			}
+			return fmt.Errorf("client certificate %q is not allowed", certIdentity(leaf))
+		}
+	}
+	return cfg, nil
+}
+
+// ClientIdentity returns the identity of the client certificate the request
+// was authenticated with, or "" if none was presented.
+func ClientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return certIdentity(r.TLS.PeerCertificates[0])
+}
+
+// certIdentity names a certificate by its first DNS SAN, or its common name.
+func certIdentity(cert *x509.Certificate) string {
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
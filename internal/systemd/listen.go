@@ -0,0 +1,63 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// Listener is a socket passed by the service manager.
+type Listener struct {
+	Name string // FileDescriptorName= of the socket unit, "unknown" if unset
+	net.Listener
+}
+
+// Listeners returns the sockets passed via socket activation (LISTEN_FDS), in
+// the order of the socket unit. The sockets stay open across service restarts,
+// so connections arriving while no process is running wait in the backlog
+// instead of being refused. Returns nil if no sockets were passed. The
+// environment variables are cleared so that child processes do not inherit them.
+func Listeners() ([]Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		// FileListener dups the descriptor
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %d (%s) is not a listening socket: %w", fd, name, err)
+		}
+		listeners = append(listeners, Listener{Name: name, Listener: ln})
+	}
+	return listeners, nil
+}
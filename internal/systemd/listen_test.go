@@ -0,0 +1,78 @@
+package systemd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+func TestListenersNotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := Listeners()
+	if listeners != nil || err != nil {
+		t.Fatalf("expected no listeners for another PID, got %v (err %v)", listeners, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Fatal("expected LISTEN_FDS to be cleared")
+	}
+}
+
+// TestListeners passes a socket to a child process as file descriptor 3, the way
+// the service manager does, and checks that the child accepts on it.
+func TestListeners(t *testing.T) {
+	if os.Getenv("DOVEWARDEN_TEST_LISTENERS_CHILD") == "1" {
+		// LISTEN_PID is only known once the child runs
+		_ = os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		listeners, err := Listeners()
+		if err != nil || len(listeners) != 1 {
+			fmt.Printf("expected one listener, got %v (err %v)\n", listeners, err)
+			os.Exit(1)
+		}
+		if listeners[0].Name != "events" {
+			fmt.Printf("expected name events, got %q\n", listeners[0].Name)
+			os.Exit(1)
+		}
+		conn, err := listeners[0].Accept()
+		if err != nil {
+			fmt.Printf("accept: %v\n", err)
+			os.Exit(1)
+		}
+		_ = conn.Close()
+		os.Exit(0)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListeners$")
+	cmd.Env = append(os.Environ(), "DOVEWARDEN_TEST_LISTENERS_CHILD=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=events")
+	cmd.ExtraFiles = []*os.File{f}
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start child: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.Close()
+
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("child failed: %v\n%s", err, out.String())
+	}
+}
@@ -0,0 +1,62 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Fatalf("expected no notification without NOTIFY_SOCKET, got sent=%v err=%v", sent, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	if sent, err := Notify(Ready); !sent || err != nil {
+		t.Fatalf("expected notification to be sent, got sent=%v err=%v", sent, err)
+	}
+
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got := string(buf[:n]); got != Ready {
+		t.Fatalf("expected %q, got %q", Ready, got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	if d, err := WatchdogInterval(); d != 0 || err != nil {
+		t.Fatalf("expected disabled watchdog, got %v (err %v)", d, err)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if d, err := WatchdogInterval(); d != 30*time.Second || err != nil {
+		t.Fatalf("expected 30s, got %v (err %v)", d, err)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if d, err := WatchdogInterval(); d != 0 || err != nil {
+		t.Fatalf("expected watchdog of another process to be ignored, got %v (err %v)", d, err)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "soon")
+	if _, err := WatchdogInterval(); err == nil {
+		t.Fatal("expected error for invalid WATCHDOG_USEC")
+	}
+}
@@ -0,0 +1,324 @@
+// Package vault fetches secrets from HashiCorp Vault over its HTTP API. It
+// logs in with AppRole or Kubernetes auth and keeps the resulting token alive
+// by renewing its lease, logging in again once it can no longer be renewed.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported auth methods.
+const (
+	AuthAppRole    = "approle"
+	AuthKubernetes = "kubernetes"
+)
+
+// DefaultKubernetesTokenFile is the service account token mounted into pods.
+const DefaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// minRenewInterval bounds how often the token is renewed for very short TTLs.
+const minRenewInterval = 5 * time.Second
+
+// Config configures the connection to Vault.
+type Config struct {
+	Addr       string // e.g. https://vault.example.com:8200
+	Namespace  string // Vault Enterprise namespace, optional
+	AuthMethod string // AuthAppRole or AuthKubernetes
+	AuthMount  string // mount path of the auth method; defaults to the method name
+
+	// AppRole auth
+	RoleID       string
+	SecretIDFile string
+
+	// Kubernetes auth
+	Role      string
+	TokenFile string // service account token; defaults to DefaultKubernetesTokenFile
+}
+
+// Secret is a secret read from Vault.
+type Secret struct {
+	Data          map[string]string
+	LeaseDuration time.Duration // 0 if the secret has no lease, e.g. in a KV store
+}
+
+// Client is a Vault API client holding the token of the last login.
+type Client struct {
+	cfg    Config
+	client *http.Client
+
+	mu        sync.Mutex
+	token     string
+	ttl       time.Duration
+	renewable bool
+}
+
+// NewClient validates the configuration and creates a client. Call Login
+// before reading secrets.
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("vault address is required")
+	}
+	switch cfg.AuthMethod {
+	case AuthAppRole:
+		if cfg.RoleID == "" || cfg.SecretIDFile == "" {
+			return nil, errors.New("approle auth requires a role ID and a secret ID file")
+		}
+	case AuthKubernetes:
+		if cfg.Role == "" {
+			return nil, errors.New("kubernetes auth requires a role")
+		}
+		if cfg.TokenFile == "" {
+			cfg.TokenFile = DefaultKubernetesTokenFile
+		}
+	default:
+		return nil, fmt.Errorf("unsupported vault auth method %q", cfg.AuthMethod)
+	}
+	if cfg.AuthMount == "" {
+		cfg.AuthMount = cfg.AuthMethod
+	}
+	cfg.Addr = strings.TrimRight(cfg.Addr, "/")
+	return &Client{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// authResponse is the auth block of login and renewal responses.
+type authResponse struct {
+	Auth *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+// Login authenticates with the configured auth method. The credentials are
+// read from their files on every login, so rotated ones are picked up.
+func (c *Client) Login(ctx context.Context) error {
+	var body map[string]string
+	switch c.cfg.AuthMethod {
+	case AuthAppRole:
+		secretID, err := readCredential(c.cfg.SecretIDFile)
+		if err != nil {
+			return fmt.Errorf("failed to read secret ID: %w", err)
+		}
+		body = map[string]string{"role_id": c.cfg.RoleID, "secret_id": secretID}
+	case AuthKubernetes:
+		jwt, err := readCredential(c.cfg.TokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read service account token: %w", err)
+		}
+		body = map[string]string{"role": c.cfg.Role, "jwt": jwt}
+	}
+
+	var resp authResponse
+	if err := c.do(ctx, http.MethodPost, "auth/"+c.cfg.AuthMount+"/login", "", body, &resp); err != nil {
+		return fmt.Errorf("vault login failed: %w", err)
+	}
+	return c.setToken(resp)
+}
+
+// RenewToken extends the lease of the current token.
+func (c *Client) RenewToken(ctx context.Context) error {
+	var resp authResponse
+	if err := c.do(ctx, http.MethodPost, "auth/token/renew-self", c.currentToken(), map[string]string{}, &resp); err != nil {
+		return fmt.Errorf("vault token renewal failed: %w", err)
+	}
+	return c.setToken(resp)
+}
+
+func (c *Client) setToken(resp authResponse) error {
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return errors.New("vault response contains no token")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = resp.Auth.ClientToken
+	c.ttl = time.Duration(resp.Auth.LeaseDuration) * time.Second
+	c.renewable = resp.Auth.Renewable
+	return nil
+}
+
+func (c *Client) currentToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// ReadSecret reads the secret at path, e.g. "secret/data/dovewarden". Both KV
+// version 1 and 2 responses are understood; non-string values are ignored.
+func (c *Client) ReadSecret(ctx context.Context, path string) (*Secret, error) {
+	var resp struct {
+		LeaseDuration int            `json:"lease_duration"`
+		Data          map[string]any `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, strings.TrimPrefix(path, "/"), c.currentToken(), nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to read vault secret %s: %w", path, err)
+	}
+
+	data := resp.Data
+	// KV version 2 nests the secret in data.data next to data.metadata
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	secret := &Secret{
+		Data:          make(map[string]string, len(data)),
+		LeaseDuration: time.Duration(resp.LeaseDuration) * time.Second,
+	}
+	for key, value := range data {
+		if s, ok := value.(string); ok {
+			secret.Data[key] = s
+		}
+	}
+	return secret, nil
+}
+
+// KeepAlive renews the token at two thirds of its TTL until ctx is cancelled.
+// If the token is not renewable or renewal fails, it logs in again. Tokens
+// without a TTL are left alone.
+func (c *Client) KeepAlive(ctx context.Context, logger *slog.Logger) {
+	for {
+		c.mu.Lock()
+		ttl, renewable := c.ttl, c.renewable
+		c.mu.Unlock()
+		if ttl <= 0 {
+			return
+		}
+
+		wait := max(ttl*2/3, minRenewInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if renewable {
+			err := c.RenewToken(ctx)
+			if err == nil {
+				logger.Debug("Renewed vault token")
+				continue
+			}
+			logger.Warn("Failed to renew vault token, logging in again", "error", err)
+		}
+		if err := c.Login(ctx); err != nil {
+			logger.Error("Failed to log in to vault", "error", err)
+			// Retry soon rather than waiting for a TTL that has run out
+			c.mu.Lock()
+			c.ttl, c.renewable = minRenewInterval*3/2, false
+			c.mu.Unlock()
+			continue
+		}
+		logger.Info("Logged in to vault again")
+	}
+}
+
+// WatchSecret re-reads the secret at path every interval until ctx is
+// cancelled and calls fn with its data whenever it changed.
+func (c *Client) WatchSecret(ctx context.Context, path string, interval time.Duration, logger *slog.Logger, fn func(map[string]string)) {
+	var last map[string]string
+	if secret, err := c.ReadSecret(ctx, path); err == nil {
+		last = secret.Data
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			secret, err := c.ReadSecret(ctx, path)
+			if err != nil {
+				logger.Warn("Failed to re-read vault secret, keeping the previous value", "path", path, "error", err)
+				continue
+			}
+			if !equalData(last, secret.Data) {
+				logger.Info("Vault secret changed, using the new value", "path", path)
+				last = secret.Data
+				fn(secret.Data)
+			}
+		}
+	}
+}
+
+func equalData(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if v, ok := b[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// do sends a request to the Vault API and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method, path, token string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.cfg.Addr+"/v1/"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.cfg.Namespace)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&errResp)
+		if len(errResp.Errors) > 0 {
+			return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.Join(errResp.Errors, "; "))
+		}
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func readCredential(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return value, nil
+}
@@ -0,0 +1,114 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeVault serves AppRole login, token renewal and a KV version 2 secret.
+func fakeVault(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/auth/approle/login", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["role_id"] != "role" || body["secret_id"] != "s3cret" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["invalid role or secret ID"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"auth":{"client_token":"token-1","lease_duration":60,"renewable":true}}`))
+	})
+	mux.HandleFunc("POST /v1/auth/token/renew-self", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token-1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"auth":{"client_token":"token-1","lease_duration":120,"renewable":true}}`))
+	})
+	mux.HandleFunc("GET /v1/secret/data/dovewarden", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token-1" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"lease_duration":0,"data":{"data":{"doveadm_password":"pw","ttl":3},"metadata":{"version":2}}}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newAppRoleClient(t *testing.T, addr, secretID string) *Client {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret-id")
+	if err := os.WriteFile(path, []byte(secretID+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(Config{Addr: addr, AuthMethod: AuthAppRole, RoleID: "role", SecretIDFile: path})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+func TestLoginAndReadSecret(t *testing.T) {
+	srv := fakeVault(t)
+	c := newAppRoleClient(t, srv.URL, "s3cret")
+	ctx := context.Background()
+
+	if err := c.Login(ctx); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	secret, err := c.ReadSecret(ctx, "secret/data/dovewarden")
+	if err != nil {
+		t.Fatalf("ReadSecret: %v", err)
+	}
+	if got := secret.Data["doveadm_password"]; got != "pw" {
+		t.Errorf("expected doveadm_password pw, got %q", got)
+	}
+	if _, ok := secret.Data["ttl"]; ok {
+		t.Error("expected non-string values to be ignored")
+	}
+
+	if err := c.RenewToken(ctx); err != nil {
+		t.Fatalf("RenewToken: %v", err)
+	}
+	if c.ttl.Seconds() != 120 {
+		t.Errorf("expected renewed TTL of 120s, got %v", c.ttl)
+	}
+}
+
+func TestLoginFailureReportsVaultErrors(t *testing.T) {
+	srv := fakeVault(t)
+	c := newAppRoleClient(t, srv.URL, "wrong")
+
+	err := c.Login(context.Background())
+	if err == nil {
+		t.Fatal("expected login to fail")
+	}
+	if want := "invalid role or secret ID"; !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, got %v", want, err)
+	}
+}
+
+func TestNewClientValidatesAuthConfig(t *testing.T) {
+	if _, err := NewClient(Config{Addr: "http://vault", AuthMethod: AuthAppRole, RoleID: "role"}); err == nil {
+		t.Error("expected error without secret ID file")
+	}
+	if _, err := NewClient(Config{Addr: "http://vault", AuthMethod: "token"}); err == nil {
+		t.Error("expected error for unsupported auth method")
+	}
+	c, err := NewClient(Config{Addr: "http://vault/", AuthMethod: AuthKubernetes, Role: "dovewarden"})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if c.cfg.TokenFile != DefaultKubernetesTokenFile || c.cfg.AuthMount != AuthKubernetes || c.cfg.Addr != "http://vault" {
+		t.Errorf("unexpected defaults: %+v", c.cfg)
+	}
+}
@@ -0,0 +1,128 @@
+// Package doveadm is a client for the parts of the Doveadm HTTP API dovewarden
+// uses: dsync replication, listing users and mailboxes, and health checks.
+package doveadm
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/doveadm"
+)
+
+// Client talks to the Doveadm HTTP API. It is safe for concurrent use.
+type Client = doveadm.Client
+
+// SyncResponse is the result of a successful sync.
+type SyncResponse = doveadm.SyncResponse
+
+// Warning is a non-fatal problem reported by dsync.
+type Warning = doveadm.Warning
+
+// ResponseError is an error entry returned by Doveadm.
+type ResponseError = doveadm.ResponseError
+
+// User is a user listed by Client.ListUsers.
+type User = doveadm.User
+
+// BackendResolver resolves the doveadm endpoint of a user's backend in proxied
+// clusters.
+type BackendResolver = doveadm.BackendResolver
+
+// Route sends the syncs of the users it matches to another doveadm endpoint.
+type Route = doveadm.Route
+
+// Router selects the doveadm endpoint of a user from a static routing table.
+type Router = doveadm.Router
+
+// SyncOptions restricts what Client.SyncWith replicates.
+type SyncOptions = doveadm.SyncOptions
+
+// SyncError is a failed sync reported by Doveadm, with its exit code.
+type SyncError = doveadm.SyncError
+
+// StatusError is a sync rejected by the Doveadm API with an HTTP error status.
+type StatusError = doveadm.StatusError
+
+// Exit codes of failed syncs.
+const (
+	ExitCodeNoUser   = doveadm.ExitCodeNoUser
+	ExitCodeTempFail = doveadm.ExitCodeTempFail
+	ExitCodeNoPerm   = doveadm.ExitCodeNoPerm
+)
+
+// ErrorRule classifies errors whose message matches a regular expression.
+type ErrorRule = doveadm.ErrorRule
+
+// Classifier maps errors of Doveadm requests to error classes.
+type Classifier = doveadm.Classifier
+
+// Error classes of failed syncs.
+const (
+	ErrorNetwork      = doveadm.ErrorNetwork
+	ErrorAuth         = doveadm.ErrorAuth
+	ErrorTempFail     = doveadm.ErrorTempFail
+	ErrorUserMissing  = doveadm.ErrorUserMissing
+	ErrorStateInvalid = doveadm.ErrorStateInvalid
+	ErrorOverload     = doveadm.ErrorOverload
+	ErrorOther        = doveadm.ErrorOther
+)
+
+// Warning types reported by dsync.
+const (
+	WarningMailboxSkipped = doveadm.WarningMailboxSkipped
+	WarningConflict       = doveadm.WarningConflict
+	WarningOther          = doveadm.WarningOther
+)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient makes the client send its requests with hc instead of a
+// default http.Client, e.g. to configure TLS or timeouts.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.SetHTTPClient(hc)
+	}
+}
+
+// NewClient creates a client for the Doveadm API at baseURL, e.g.
+// "http://localhost:8080", authenticating with the doveadm API password.
+// The password can be rotated later with Client.SetPassword.
+func NewClient(baseURL, password string, opts ...Option) *Client {
+	c := doveadm.NewClient(baseURL, password)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ParseSyncParams parses a JSON object mapping destinations to extra sync
+// parameters, e.g. {"imap": {"lockTimeout": 30}}, for Client.SetSyncParams.
+func ParseSyncParams(s string) (map[string]map[string]any, error) {
+	return doveadm.ParseSyncParams(s)
+}
+
+// NewBackendResolver creates a resolver substituting the passdb host of users
+// for {host} in urlTemplate, e.g. "http://{host}:8080". Resolved endpoints are
+// cached for ttl.
+func NewBackendResolver(urlTemplate string, ttl time.Duration) *BackendResolver {
+	return doveadm.NewBackendResolver(urlTemplate, ttl)
+}
+
+// ParseErrorRules parses a JSON array of error rules, e.g.
+// [{"pattern": "Too many connections", "class": "overload"}].
+func ParseErrorRules(s string) ([]ErrorRule, error) {
+	return doveadm.ParseErrorRules(s)
+}
+
+// NewClassifier creates a classifier checking the rules, in order, before the
+// built-in classification by exit code, HTTP status and network error.
+func NewClassifier(rules []ErrorRule) (*Classifier, error) {
+	return doveadm.NewClassifier(rules)
+}
+
+// NewRouter creates a router for the routes, checked in order. Users matched by
+// no route are handled by fallback.
+func NewRouter(fallback *Client, routes []Route) (*Router, error) {
+	return doveadm.NewRouter(fallback, routes)
+}
@@ -0,0 +1,87 @@
+// Package events validates and filters Dovecot event API notifications the
+// way dovewarden does before queueing a user for replication.
+//
+// The filter settings are process-wide: call SetIgnoredNamespacePrefixes and
+// SetSelfInduced once at startup, or whenever the settings change.
+package events
+
+import (
+	"net/netip"
+
+	"github.com/dovewarden/dovewarden/internal/events"
+)
+
+// Event is a Dovecot event as posted by the event API.
+type Event = events.Event
+
+// Fields are the fields of an Event used by the filter.
+type Fields = events.Fields
+
+// FilteredEvent is an event that passed the filter, with the user to sync and
+// the priority factor of the command.
+type FilteredEvent = events.FilteredEvent
+
+// Event types accepted by the filter.
+const (
+	EventIMAPCommandFinished  = events.EventIMAPCommandFinished
+	EventMailDeliveryFinished = events.EventMailDeliveryFinished
+	EventSieveActionFinished  = events.EventSieveActionFinished
+)
+
+// DefaultPriority is the priority factor of events without a specific priority.
+const DefaultPriority = events.DefaultPriority
+
+// Errors returned by Filter for events that are not replicated.
+var (
+	ErrEmptyEvent         = events.ErrEmptyEvent
+	ErrEmptyUsername      = events.ErrEmptyUsername
+	ErrInvalidEventType   = events.ErrInvalidEventType
+	ErrInvalidCmdName     = events.ErrInvalidCmdName
+	ErrSharedNamespace    = events.ErrSharedNamespace
+	ErrSelfInduced        = events.ErrSelfInduced
+	ErrDeliveryFailed     = events.ErrDeliveryFailed
+	ErrInvalidSieveAction = events.ErrInvalidSieveAction
+)
+
+// Stable reason codes returned by Reason.
+const (
+	ReasonInvalidJSON        = events.ReasonInvalidJSON
+	ReasonEmptyEvent         = events.ReasonEmptyEvent
+	ReasonEmptyUsername      = events.ReasonEmptyUsername
+	ReasonInvalidEventType   = events.ReasonInvalidEventType
+	ReasonInvalidCmdName     = events.ReasonInvalidCmdName
+	ReasonSharedNamespace    = events.ReasonSharedNamespace
+	ReasonSelfInduced        = events.ReasonSelfInduced
+	ReasonDeliveryFailed     = events.ReasonDeliveryFailed
+	ReasonInvalidSieveAction = events.ReasonInvalidSieveAction
+	ReasonUnknown            = events.ReasonUnknown
+)
+
+// Filter decodes a JSON event and returns it if it requires a sync, or an
+// error telling why not. Malformed JSON is returned as the decoding error.
+func Filter(data []byte) (*FilteredEvent, error) {
+	return events.Filter(data)
+}
+
+// Reason maps an error returned by Filter to its stable reason code.
+func Reason(err error) string {
+	return events.Reason(err)
+}
+
+// SetIgnoredNamespacePrefixes sets the mailbox prefixes of shared and public
+// namespaces whose events are ignored. The default is "Shared/" and "Public/".
+func SetIgnoredNamespacePrefixes(prefixes []string) {
+	events.SetIgnoredNamespacePrefixes(prefixes)
+}
+
+// SetSelfInduced sets the session ID prefixes and the remote networks of the
+// syncs run by the embedding service, whose events are ignored to avoid
+// replicating changes back and forth.
+func SetSelfInduced(sessionPrefixes []string, remoteNetworks []netip.Prefix) {
+	events.SetSelfInduced(sessionPrefixes, remoteNetworks)
+}
+
+// ParseRemoteNetworks parses IP addresses and CIDR networks for SetSelfInduced.
+func ParseRemoteNetworks(values []string) ([]netip.Prefix, error) {
+	return events.ParseRemoteNetworks(values)
+}
@@ -0,0 +1,47 @@
+package queue_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dovewarden/dovewarden/pkg/events"
+	"github.com/dovewarden/dovewarden/pkg/queue"
+)
+
+// printHandler prints the users it is handed instead of syncing them.
+type printHandler struct {
+	done chan struct{}
+}
+
+func (h printHandler) Handle(ctx context.Context, item queue.QueueItem) error {
+	fmt.Printf("syncing %s after %s to %v\n", item.Username, item.Info.CmdName, item.Mailboxes())
+	close(h.done)
+	return nil
+}
+
+func Example() {
+	q, err := queue.NewNative()
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = q.Close() }()
+
+	ctx := context.Background()
+	handler := printHandler{done: make(chan struct{})}
+	pool := queue.NewWorkerPool(q, 1, handler)
+	pool.Start(ctx)
+	defer func() { _ = pool.Stop(ctx) }()
+
+	body := []byte(`{"event":"imap_command_finished","fields":{"user":"alice@example.org","cmd_name":"APPEND","mailbox":"INBOX"}}`)
+	filtered, err := events.Filter(body)
+	if err != nil {
+		fmt.Println("ignored:", events.Reason(err))
+		return
+	}
+	if err := q.EnqueueEvent(ctx, filtered.Username, filtered.Priority, queue.NewEventInfo(filtered, time.Now())); err != nil {
+		panic(err)
+	}
+	<-handler.done
+	// Output: syncing alice@example.org after APPEND to [INBOX]
+}
@@ -0,0 +1,308 @@
+// Package queue embeds dovewarden's replication pipeline in another service:
+// a priority queue coalescing the events of a user into one pending sync, a
+// worker pool draining it, and a handler replicating users with dsync through
+// the Doveadm API.
+//
+// A minimal pipeline filters events with the events package, enqueues the
+// accepted ones and lets a worker pool sync them:
+//
+//	q, err := queue.NewNative()
+//	...
+//	handler := queue.NewDoveadmHandler(doveadmURL, password, "imap", q)
+//	pool := queue.NewWorkerPool(q, 4, handler)
+//	pool.Start(ctx)
+//	defer pool.Stop(ctx)
+//
+//	filtered, err := events.Filter(body)
+//	if err == nil {
+//		err = q.EnqueueEvent(ctx, filtered.Username, filtered.Priority, queue.NewEventInfo(filtered, time.Now()))
+//	}
+package queue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/dovewarden/dovewarden/internal/metrics"
+	"github.com/dovewarden/dovewarden/internal/queue"
+	"github.com/dovewarden/dovewarden/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// Queue is a priority queue of users to sync, together with the replication
+// state, history and failure marks of every user.
+type Queue = queue.Queue
+
+// EventHandler handles a dequeued user. A returned error requeues the user.
+type EventHandler = queue.EventHandler
+
+// QueueItem is a dequeued user as handed to an EventHandler, together with the
+// event info it was queued with and its failed attempts.
+type QueueItem = queue.QueueItem
+
+// EventInfo is the context of the events that caused a user to be queued.
+type EventInfo = queue.EventInfo
+
+// HistoryEntry describes a single sync attempt of a user.
+type HistoryEntry = queue.HistoryEntry
+
+// Status summarizes a queue.
+type Status = queue.Status
+
+// QueuedUser describes a user waiting in a queue.
+type QueuedUser = queue.QueuedUser
+
+// SLAReport aggregates the replication latencies of a time window.
+type SLAReport = queue.SLAReport
+
+// WorkerPool dequeues users and hands them to an EventHandler.
+type WorkerPool = queue.WorkerPool
+
+// SyncRateLimit enforces a minimum interval between syncs of a user, see
+// WorkerPool.SetRateLimit.
+type SyncRateLimit = queue.SyncRateLimit
+
+// DoveadmHandler is an EventHandler syncing users with dsync through the
+// Doveadm API, incrementally once a replication state is stored.
+type DoveadmHandler = queue.DoveadmEventHandler
+
+// AgingService periodically promotes users that waited too long to the head
+// of a queue, so low-priority users cannot starve.
+type AgingService = queue.AgingService
+
+// MailboxFilter selects the mailboxes synced by a DoveadmHandler, see
+// DoveadmHandler.SetMailboxFilter.
+type MailboxFilter = queue.MailboxFilter
+
+// NewMailboxFilter creates a filter syncing the mailboxes matching any include
+// glob, or all if there are none, except those matching any exclude glob.
+func NewMailboxFilter(include, exclude []string) *MailboxFilter {
+	return queue.NewMailboxFilter(include, exclude)
+}
+
+// Errors returned by Queue.RenameUser.
+var (
+	ErrUserExists   = queue.ErrUserExists
+	ErrUserInFlight = queue.ErrUserInFlight
+)
+
+// EventHandlerFunc adapts a function to an EventHandler.
+type EventHandlerFunc = queue.EventHandlerFunc
+
+// Middleware wraps an EventHandler, see WorkerPool.Use.
+type Middleware = queue.Middleware
+
+// SyncInfo describes a sync attempt to the sync hooks of a DoveadmHandler.
+type SyncInfo = queue.SyncInfo
+
+// PreSyncHook runs before a user is synced, see DoveadmHandler.AddPreSyncHook.
+type PreSyncHook = queue.PreSyncHook
+
+// PostSyncHook runs after a user was synced, see DoveadmHandler.AddPostSyncHook.
+type PostSyncHook = queue.PostSyncHook
+
+// ErrSkipSync is returned by a PreSyncHook to skip a sync without failing it.
+var ErrSkipSync = queue.ErrSkipSync
+
+// Result is returned as the error of a failed handling to tell the worker pool
+// how to retry the user.
+type Result = queue.Result
+
+// ResultOf returns the retry hints of an error returned by a handler.
+func ResultOf(err error) Result {
+	return queue.ResultOf(err)
+}
+
+// Chain wraps h with the middlewares, the first one outermost.
+func Chain(h EventHandler, middlewares ...Middleware) EventHandler {
+	return queue.Chain(h, middlewares...)
+}
+
+// LoggingMiddleware logs every handled user, failures at error level.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return queue.LoggingMiddleware(logger)
+}
+
+// UserLockMiddleware serializes the jobs of the same user.
+func UserLockMiddleware() Middleware {
+	return queue.UserLockMiddleware()
+}
+
+// RateLimitMiddleware starts at most perSecond jobs per second, allowing
+// bursts of up to burst jobs.
+func RateLimitMiddleware(perSecond float64, burst int) Middleware {
+	return queue.RateLimitMiddleware(perSecond, burst)
+}
+
+// DistributedRateLimitMiddleware starts at most perSecond jobs per second
+// across all processes sharing the Redis server of client and key, allowing
+// bursts of up to burst jobs. While Redis fails, each process falls back to
+// perSecond jobs per second on its own.
+func DistributedRateLimitMiddleware(client redis.UniversalClient, key string, perSecond float64, burst int, logger *slog.Logger) Middleware {
+	return queue.DistributedRateLimitMiddleware(queue.NewDistributedTokenBucket(client, key, perSecond, burst, perSecond, nil, logger))
+}
+
+// CommandHook returns a PostSyncHook running the program at path with the
+// username, destination and sync result as arguments and DOVEWARDEN_*
+// environment variables, killed after timeout.
+func CommandHook(path string, timeout time.Duration, logger *slog.Logger) PostSyncHook {
+	return queue.CommandHook(path, timeout, logger)
+}
+
+// Option configures a queue created by NewInMemory or NewNative.
+type Option func(*options)
+
+type options struct {
+	logger        *slog.Logger
+	namespace     string
+	batchSize     int
+	batchInterval time.Duration
+	spillDir      string
+	maxInMemory   int
+}
+
+// WithLogger sets the logger of the queue. The default is slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithNamespace sets the prefix of the Redis keys of the in-memory queue.
+// The default is "dovewarden".
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithEnqueueBatching buffers enqueues of the in-memory queue and writes them
+// in a single pipeline once maxItems are buffered or interval has elapsed.
+func WithEnqueueBatching(maxItems int, interval time.Duration) Option {
+	return func(o *options) {
+		o.batchSize = maxItems
+		o.batchInterval = interval
+	}
+}
+
+// WithSpill keeps at most maxQueued users of the in-memory queue in memory and
+// writes the users with the lowest priority beyond that to files in dir.
+func WithSpill(dir string, maxQueued int) Option {
+	return func(o *options) {
+		o.spillDir = dir
+		o.maxInMemory = maxQueued
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{logger: slog.Default(), namespace: "dovewarden"}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// NewInMemory creates a queue backed by an embedded miniredis server.
+// Nothing is persisted beyond spill files, so the queue is lost on restart.
+// It accepts all options.
+func NewInMemory(opts ...Option) (Queue, error) {
+	o := newOptions(opts)
+	q, err := queue.NewInMemoryQueue(o.namespace, "", o.logger)
+	if err != nil {
+		return nil, err
+	}
+	if o.batchSize > 1 {
+		q.EnableEnqueueBatching(o.batchSize, o.batchInterval)
+	}
+	if o.spillDir != "" {
+		if err := q.EnableSpill(o.spillDir, o.maxInMemory); err != nil {
+			_ = q.Close()
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// NewNative creates a queue kept in sharded Go data structures, which scales
+// better with concurrent enqueues than NewInMemory. Nothing is persisted.
+// Only WithLogger applies; WithSpill is rejected, and batching and namespaces
+// are not needed without Redis.
+func NewNative(opts ...Option) (Queue, error) {
+	o := newOptions(opts)
+	if o.spillDir != "" {
+		return nil, errors.New("spill is only supported by the in-memory queue")
+	}
+	return queue.NewNativeQueue(o.logger), nil
+}
+
+// NewEventInfo returns the info to store with the user of an accepted event
+// that was received at triggeredAt, for Queue.EnqueueEvent.
+func NewEventInfo(filtered *events.FilteredEvent, triggeredAt time.Time) *EventInfo {
+	return queue.NewEventInfo(filtered, triggeredAt)
+}
+
+// EventInfoFromContext returns the event info of the user being handled by an
+// EventHandler, as in its QueueItem, or nil if the user was queued without one.
+func EventInfoFromContext(ctx context.Context) *EventInfo {
+	return queue.EventInfoFromContext(ctx)
+}
+
+// NewWorkerPool creates a pool of workers handing the users dequeued from q to
+// handler. Call Start to begin and Stop to drain the pool.
+func NewWorkerPool(q Queue, workers int, handler EventHandler, opts ...Option) *WorkerPool {
+	o := newOptions(opts)
+	pool := queue.NewWorkerPool(q, workers, o.logger)
+	pool.SetHandler(handler)
+	return pool
+}
+
+// NewSyncRateLimit creates a rate limit with a global minimum interval between
+// syncs of a user and per-domain overrides. An interval of 0 disables it.
+func NewSyncRateLimit(defaultInterval time.Duration, domains map[string]time.Duration) *SyncRateLimit {
+	return queue.NewSyncRateLimit(defaultInterval, domains)
+}
+
+// NewAgingService creates a service promoting users queued for longer than
+// maxDelay. Call Start to run it.
+func NewAgingService(q Queue, maxDelay time.Duration, opts ...Option) *AgingService {
+	return queue.NewAgingService(q, newOptions(opts).logger, maxDelay)
+}
+
+// HandlerOption configures a DoveadmHandler.
+type HandlerOption func(*handlerOptions)
+
+type handlerOptions struct {
+	logger     *slog.Logger
+	registerer prometheus.Registerer
+}
+
+// WithHandlerLogger sets the logger of the handler. The default is slog.Default().
+func WithHandlerLogger(logger *slog.Logger) HandlerOption {
+	return func(o *handlerOptions) {
+		o.logger = logger
+	}
+}
+
+// WithRegisterer registers the dovewarden_* metrics of the handler with reg.
+// By default they are kept in a private registry and not exported. A
+// registerer can only be used for a single handler.
+func WithRegisterer(reg prometheus.Registerer) HandlerOption {
+	return func(o *handlerOptions) {
+		o.registerer = reg
+	}
+}
+
+// NewDoveadmHandler creates a handler syncing users to destination (the dsync
+// destination, e.g. "imap") through the Doveadm API at baseURL. Further
+// settings such as the history size or dry-run mode are set with the setters
+// of DoveadmHandler before the pool is started.
+func NewDoveadmHandler(baseURL, password, destination string, q Queue, opts ...HandlerOption) *DoveadmHandler {
+	o := &handlerOptions{logger: slog.Default(), registerer: prometheus.NewRegistry()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return queue.NewDoveadmEventHandler(baseURL, password, destination, o.logger, q, metrics.New(o.registerer))
+}